@@ -0,0 +1,102 @@
+package alert
+
+import (
+	"strconv"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetAlerts lists the alerts of the caller's territory, latest first.
+// Filters: status, rule_uuid, metric, severity, level, territory_uuid,
+// brand_uuid, agent_uuid, and start_date/end_date on the opening.
+func GetAlerts(c *fiber.Ctx) error {
+	scope := utils.ResolveScope(middlewares.CurrentUser(c))
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	query := utils.ApplyScope(database.DB.Model(&models.Alert{}), scope, "alerts")
+	for _, param := range []string{"status", "rule_uuid", "metric", "severity", "level", "territory_uuid", "brand_uuid", "agent_uuid"} {
+		if value := c.Query(param); value != "" {
+			query = query.Where("alerts."+param+" = ?", value)
+		}
+	}
+	if start := c.Query("start_date"); start != "" {
+		query = query.Where("alerts.opened_at >= ?", start)
+	}
+	if end := c.Query("end_date"); end != "" {
+		query = query.Where("alerts.opened_at <= ?", end+" 23:59:59")
+	}
+
+	query = query.Session(&gorm.Session{})
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var open int64
+	query.Where("alerts.status = ?", models.AlertOpen).Count(&open)
+
+	var dataList []models.Alert
+	err = query.Order("alerts.opened_at DESC").Offset(offset).Limit(limit).Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch alerts",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alerts retrieved successfully",
+		"data":    dataList,
+		"open":    open,
+		"pagination": map[string]interface{}{
+			"total_records": totalRecords,
+			"total_pages":   totalPages,
+			"current_page":  page,
+			"page_size":     limit,
+		},
+	})
+}
+
+// GetAlert returns one alert of the caller's territory with its rule.
+func GetAlert(c *fiber.Ctx) error {
+	scope := utils.ResolveScope(middlewares.CurrentUser(c))
+
+	var a models.Alert
+	err := utils.ApplyScope(database.DB.Model(&models.Alert{}), scope, "alerts").
+		Where("uuid = ?", c.Params("uuid")).
+		First(&a).Error
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No alert found",
+			"data":    nil,
+		})
+	}
+
+	var rule models.AlertRule
+	database.DB.Unscoped().Where("uuid = ?", a.RuleUUID).First(&rule)
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alert found",
+		"data":    a,
+		"rule":    rule,
+	})
+}
@@ -0,0 +1,301 @@
+package alert
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/dashboard"
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var startOnce sync.Once
+
+// Start evaluates the active rules every ALERT_EVAL_MINUTES (15).
+func Start() {
+	startOnce.Do(func() {
+		interval := time.Duration(utils.EnvInt("ALERT_EVAL_MINUTES", 15)) * time.Minute
+		go func() {
+			for {
+				runDue(time.Now(), interval)
+				time.Sleep(interval)
+			}
+		}()
+	})
+}
+
+// runDue evaluates the active rules not evaluated for half an interval.
+// Each one is claimed by moving its last evaluation first, so that it is
+// evaluated once even with several instances of the API.
+func runDue(now time.Time, interval time.Duration) {
+	db := database.DB
+
+	if err := resolveRetired(db, now); err != nil {
+		log.Printf("Alert rules: %v", err)
+	}
+
+	var due []models.AlertRule
+	err := db.Where("active = ? AND (last_evaluated_at IS NULL OR last_evaluated_at <= ?)", true, now.Add(-interval/2)).
+		Order("created_at").
+		Find(&due).Error
+	if err != nil {
+		log.Printf("Alert rules: %v", err)
+		return
+	}
+	for i := range due {
+		rule := &due[i]
+		claim := db.Model(&models.AlertRule{}).Where("uuid = ?", rule.UUID)
+		if rule.LastEvaluatedAt == nil {
+			claim = claim.Where("last_evaluated_at IS NULL")
+		} else {
+			claim = claim.Where("last_evaluated_at = ?", *rule.LastEvaluatedAt)
+		}
+		claim = claim.Update("last_evaluated_at", now)
+		if claim.Error != nil || claim.RowsAffected != 1 {
+			continue
+		}
+		if _, err := Evaluate(rule, now); err != nil {
+			log.Printf("Alert rule %s (%s): %v", rule.UUID, rule.Metric, err)
+		}
+	}
+}
+
+// resolveRetired resolves the open alerts of the rules deleted or
+// deactivated.
+func resolveRetired(db *gorm.DB, now time.Time) error {
+	return db.Model(&models.Alert{}).
+		Where("status = ?", models.AlertOpen).
+		Where("rule_uuid NOT IN (SELECT uuid FROM alert_rules WHERE active = true AND deleted_at IS NULL)").
+		Updates(map[string]interface{}{"status": models.AlertResolved, "resolved_at": now}).Error
+}
+
+// resolveRule resolves the open alerts of a rule.
+func resolveRule(db *gorm.DB, ruleUUID string, now time.Time) error {
+	return db.Model(&models.Alert{}).
+		Where("rule_uuid = ? AND status = ?", ruleUUID, models.AlertOpen).
+		Updates(map[string]interface{}{"status": models.AlertResolved, "resolved_at": now}).Error
+}
+
+// breaches reports whether value compares to the threshold of the rule.
+func breaches(rule *models.AlertRule, value float64) bool {
+	switch rule.Comparison {
+	case models.AlertAbove:
+		return value > rule.Threshold
+	case models.AlertAboveOrEqual:
+		return value >= rule.Threshold
+	case models.AlertBelow:
+		return value < rule.Threshold
+	case models.AlertBelowOrEqual:
+		return value <= rule.Threshold
+	}
+	return false
+}
+
+// worse reports whether value is further from the threshold of the rule
+// than peak.
+func worse(rule *models.AlertRule, value, peak float64) bool {
+	if rule.Comparison == models.AlertBelow || rule.Comparison == models.AlertBelowOrEqual {
+		return value < peak
+	}
+	return value > peak
+}
+
+// Evaluation is the outcome of an evaluation of a rule.
+type Evaluation struct {
+	Measured int `json:"measured"` // Subjects measured
+	Opened   int `json:"opened"`
+	Ongoing  int `json:"ongoing"`
+	Resolved int `json:"resolved"`
+}
+
+// Evaluate measures the rule at now: it opens an alert for each subject
+// that breaches the threshold without an open one, updates the open ones
+// still breaching and resolves the others. The error, if any, is kept on
+// the rule.
+func Evaluate(rule *models.AlertRule, now time.Time) (Evaluation, error) {
+	db := database.DB
+	var ev Evaluation
+
+	measures, err := dashboard.MeasureAlertRule(rule, now)
+	if err == nil {
+		ev, err = apply(db, rule, measures, now)
+	}
+
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+	db.Model(&models.AlertRule{}).Where("uuid = ?", rule.UUID).
+		Updates(map[string]interface{}{"last_evaluated_at": now, "last_error": lastError})
+	rule.LastEvaluatedAt = &now
+	rule.LastError = lastError
+	return ev, err
+}
+
+// apply reconciles the open alerts of the rule with its measures.
+func apply(db *gorm.DB, rule *models.AlertRule, measures []dashboard.AlertMeasure, now time.Time) (Evaluation, error) {
+	ev := Evaluation{Measured: len(measures)}
+
+	var open []models.Alert
+	if err := db.Where("rule_uuid = ? AND status = ?", rule.UUID, models.AlertOpen).Find(&open).Error; err != nil {
+		return ev, err
+	}
+	bySubject := map[string]*models.Alert{}
+	for i := range open {
+		bySubject[open[i].Subject] = &open[i]
+	}
+
+	for _, m := range measures {
+		if !breaches(rule, m.Value) {
+			continue
+		}
+		if a, ok := bySubject[m.Subject]; ok {
+			delete(bySubject, m.Subject)
+			peak := a.PeakValue
+			if worse(rule, m.Value, peak) {
+				peak = m.Value
+			}
+			err := db.Model(a).Updates(map[string]interface{}{
+				"value":        m.Value,
+				"peak_value":   peak,
+				"threshold":    rule.Threshold,
+				"severity":     rule.Severity,
+				"subject_name": m.Name,
+				"last_seen_at": now,
+			}).Error
+			if err != nil {
+				return ev, err
+			}
+			ev.Ongoing++
+			continue
+		}
+
+		a, err := openAlert(db, rule, m, now)
+		if err != nil {
+			return ev, err
+		}
+		ev.Opened++
+		if rule.Notify {
+			notify(db, rule, a)
+		}
+	}
+
+	// Subjects no longer breaching, or no longer measured
+	for _, a := range bySubject {
+		err := db.Model(a).Updates(map[string]interface{}{"status": models.AlertResolved, "resolved_at": now}).Error
+		if err != nil {
+			return ev, err
+		}
+		ev.Resolved++
+	}
+	return ev, nil
+}
+
+// openAlert saves a new alert of the rule for the measure.
+func openAlert(db *gorm.DB, rule *models.AlertRule, m dashboard.AlertMeasure, now time.Time) (*models.Alert, error) {
+	a := &models.Alert{
+		UUID:          uuid.New().String(),
+		RuleUUID:      rule.UUID,
+		Metric:        rule.Metric,
+		Severity:      rule.Severity,
+		Subject:       m.Subject,
+		SubjectName:   m.Name,
+		Level:         m.Level,
+		TerritoryUUID: m.TerritoryUUID,
+		BrandUUID:     m.BrandUUID,
+		AgentUUID:     m.AgentUUID,
+		Value:         m.Value,
+		PeakValue:     m.Value,
+		Threshold:     rule.Threshold,
+		Status:        models.AlertOpen,
+		OpenedAt:      now,
+		LastSeenAt:    now,
+	}
+
+	// Locate the subject, for the scope of the readers and the leads
+	var t territory
+	var err error
+	if m.AgentUUID != "" {
+		t, err = locateAgent(db, m.AgentUUID)
+	} else {
+		t, err = locate(db, levelColumns[m.Level], m.TerritoryUUID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.CountryUUID, a.ProvinceUUID, a.AreaUUID, a.SubAreaUUID, a.CommuneUUID =
+		t.CountryUUID, t.ProvinceUUID, t.AreaUUID, t.SubAreaUUID, t.CommuneUUID
+
+	if err := db.Create(a).Error; err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// metricLabels name the metrics in the notifications.
+var metricLabels = map[string]string{
+	models.AlertOOS:        "OOS",
+	models.AlertND:         "ND",
+	models.AlertSOS:        "SOS",
+	models.AlertWD:         "WD",
+	models.AlertVisits:     "Visites",
+	models.AlertInactivity: "Jours sans visite",
+}
+
+var comparisonSigns = map[string]string{
+	models.AlertAbove:        ">",
+	models.AlertAboveOrEqual: "≥",
+	models.AlertBelow:        "<",
+	models.AlertBelowOrEqual: "≤",
+}
+
+// notify tells the leads of the subject of a new alert, and the author of
+// the rule.
+func notify(db *gorm.DB, rule *models.AlertRule, a *models.Alert) {
+	kind := models.NotifyAlert
+	switch rule.Metric {
+	case models.AlertInactivity:
+		kind = models.NotifyAgentInactive
+	case models.AlertOOS:
+		kind = models.NotifyOOSCritical
+	}
+
+	value := utils.FormatNumber(a.Value, 1)
+	threshold := utils.FormatNumber(a.Threshold, 1)
+	switch rule.Metric {
+	case models.AlertOOS, models.AlertND, models.AlertSOS, models.AlertWD:
+		value += " %"
+		threshold += " %"
+	}
+
+	body := fmt.Sprintf("%s : %s (seuil %s %s", metricLabels[rule.Metric], value, comparisonSigns[rule.Comparison], threshold)
+	if rule.Metric != models.AlertInactivity {
+		body += fmt.Sprintf(", sur %d jours", rule.WindowDays)
+	}
+	body += ")."
+
+	recipients := utils.TerritoryLeads(db, a.CountryUUID, a.ProvinceUUID, a.AreaUUID, a.SubAreaUUID, a.CommuneUUID, a.AgentUUID)
+	recipients = append(recipients, rule.CreatedBy)
+	utils.Notify(db, recipients, models.Notification{
+		Type:  kind,
+		Title: fmt.Sprintf("%s — %s", rule.Name, a.SubjectName),
+		Body:  body,
+		Payload: models.JSONMap{
+			"alert_uuid":     a.UUID,
+			"rule_uuid":      rule.UUID,
+			"metric":         rule.Metric,
+			"severity":       a.Severity,
+			"value":          a.Value,
+			"threshold":      a.Threshold,
+			"territory_uuid": a.TerritoryUUID,
+			"brand_uuid":     a.BrandUUID,
+			"agent_uuid":     a.AgentUUID,
+		},
+		Key: "alert:" + a.UUID,
+	})
+}
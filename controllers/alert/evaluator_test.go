@@ -0,0 +1,156 @@
+package alert
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/dashboard"
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBreachesAndWorse(t *testing.T) {
+	rule := func(comparison string) *models.AlertRule {
+		return &models.AlertRule{Comparison: comparison, Threshold: 30}
+	}
+	tests := []struct {
+		comparison  string
+		value       float64
+		breach      bool
+		worseThan25 bool
+	}{
+		{models.AlertAbove, 30, false, true},
+		{models.AlertAbove, 31, true, true},
+		{models.AlertAboveOrEqual, 30, true, true},
+		{models.AlertBelow, 30, false, false},
+		{models.AlertBelow, 20, true, true},
+		{models.AlertBelowOrEqual, 30, true, false},
+		{"between", 30, false, true},
+	}
+	for _, tt := range tests {
+		r := rule(tt.comparison)
+		if got := breaches(r, tt.value); got != tt.breach {
+			t.Errorf("%s 30 with %v: breaches = %v, want %v", tt.comparison, tt.value, got, tt.breach)
+		}
+		if got := worse(r, tt.value, 25); got != tt.worseThan25 {
+			t.Errorf("%s 30 with %v: worse than 25 = %v, want %v", tt.comparison, tt.value, got, tt.worseThan25)
+		}
+	}
+}
+
+// tableRows are the fixed rows of a table.
+type tableRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// scriptedConnector answers the queries of its tables with their rows, the
+// others with none, and records the statements with their args.
+type scriptedConnector struct {
+	tables     map[string]tableRows // By quoted or plain table name in FROM
+	statements *[]string
+}
+
+func (c scriptedConnector) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (scriptedConnector) Driver() driver.Driver                          { return nil }
+func (c scriptedConnector) Prepare(query string) (driver.Stmt, error) {
+	return scriptedStmt{c, query}, nil
+}
+func (scriptedConnector) Close() error                { return nil }
+func (c scriptedConnector) Begin() (driver.Tx, error) { return c, nil }
+func (scriptedConnector) Commit() error               { return nil }
+func (scriptedConnector) Rollback() error             { return nil }
+
+type scriptedStmt struct {
+	c     scriptedConnector
+	query string
+}
+
+func (scriptedStmt) Close() error  { return nil }
+func (scriptedStmt) NumInput() int { return -1 }
+func (s scriptedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	*s.c.statements = append(*s.c.statements, fmt.Sprint(s.query, " ", args))
+	return driver.RowsAffected(1), nil
+}
+func (s scriptedStmt) Query([]driver.Value) (driver.Rows, error) {
+	for table, t := range s.c.tables {
+		if strings.Contains(s.query, "FROM "+table+" ") || strings.Contains(s.query, `FROM "`+table+`"`) {
+			return &scriptedRows{t, 0}, nil
+		}
+	}
+	return &scriptedRows{}, nil
+}
+
+type scriptedRows struct {
+	tableRows
+	next int
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if r.next == len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+func TestApply(t *testing.T) {
+	var statements []string
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(scriptedConnector{
+		tables: map[string]tableRows{
+			"alerts": {
+				columns: []string{"uuid", "subject", "peak_value", "status"},
+				rows:    [][]driver.Value{{"a1", "province:p1|brand:b1", 20.0, "open"}, {"a2", "province:p2|brand:b1", 25.0, "open"}},
+			},
+			"provinces": {
+				columns: []string{"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid"},
+				rows:    [][]driver.Value{{"cd", "p3", "", "", ""}},
+			},
+		},
+		statements: &statements,
+	})}), &gorm.Config{SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rule := &models.AlertRule{UUID: "r1", Metric: models.AlertND, Level: "province", Comparison: models.AlertBelow, Threshold: 30, Severity: models.AlertCritical}
+	now := time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC)
+	ev, err := apply(db, rule, []dashboard.AlertMeasure{
+		{Subject: "province:p1|brand:b1", Name: "Kinshasa — Alpha", Level: "province", TerritoryUUID: "p1", BrandUUID: "b1", Value: 15},
+		{Subject: "province:p2|brand:b1", Name: "Kongo — Alpha", Level: "province", TerritoryUUID: "p2", BrandUUID: "b1", Value: 40},
+		{Subject: "province:p3|brand:b1", Name: "Kwilu — Alpha", Level: "province", TerritoryUUID: "p3", BrandUUID: "b1", Value: 10},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Evaluation{Measured: 3, Opened: 1, Ongoing: 1, Resolved: 1}); ev != want {
+		t.Errorf("evaluation = %+v, want %+v", ev, want)
+	}
+
+	if len(statements) != 3 {
+		t.Fatalf("statements = %q, want the update of a1, the insert and the resolution of a2", statements)
+	}
+	// a1 at its new peak, the new alert of p3 located in cd, a2 resolved
+	for i, want := range [][]string{
+		{`UPDATE "alerts"`, `"peak_value"=$2`, "UTC 15 critical Kinshasa — Alpha 30 15 ", " a1]"},
+		{`INSERT INTO "alerts"`, " r1 nd critical province:p3|brand:b1 Kwilu — Alpha province p3 b1  cd p3    10 10 30 open "},
+		{`UPDATE "alerts"`, `"status"=$2`, "UTC resolved ", " a2]"},
+	} {
+		for _, part := range want {
+			if !strings.Contains(statements[i], part) {
+				t.Errorf("statement %d misses %q:\n%s", i, part, statements[i])
+			}
+		}
+	}
+}
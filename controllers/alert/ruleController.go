@@ -0,0 +1,372 @@
+package alert
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/dashboard"
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	metrics     = []string{models.AlertOOS, models.AlertND, models.AlertSOS, models.AlertWD, models.AlertVisits, models.AlertInactivity}
+	comparisons = []string{models.AlertAbove, models.AlertAboveOrEqual, models.AlertBelow, models.AlertBelowOrEqual}
+	severities  = []string{models.AlertInfo, models.AlertWarning, models.AlertCritical}
+)
+
+// ruleInput is an alert rule as sent by the client.
+type ruleInput struct {
+	Name       string   `json:"name"`
+	Metric     string   `json:"metric"`
+	Level      string   `json:"level"`
+	Comparison string   `json:"comparison"`
+	Threshold  *float64 `json:"threshold"`
+	WindowDays int      `json:"window_days"`
+	Severity   string   `json:"severity"`
+
+	CountryUUID  string `json:"country_uuid"`
+	ProvinceUUID string `json:"province_uuid"`
+	AreaUUID     string `json:"area_uuid"`
+	SubAreaUUID  string `json:"sub_area_uuid"`
+	CommuneUUID  string `json:"commune_uuid"`
+	BrandUUID    string `json:"brand_uuid"`
+	UserUUID     string `json:"user_uuid"`
+
+	Notify *bool `json:"notify"`
+	Active *bool `json:"active"`
+}
+
+// apply validates the input and copies it to r. The territory of the rule
+// is completed with its wider levels, and restricted to the scope of the
+// user when it is wider.
+func (in *ruleInput) apply(db *gorm.DB, r *models.AlertRule, scope utils.Scope) error {
+	metric := strings.ToLower(strings.TrimSpace(in.Metric))
+	if !slices.Contains(metrics, metric) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(metrics, ", "))
+	}
+	level := strings.ToLower(strings.TrimSpace(in.Level))
+	if level == "" && metric == models.AlertInactivity {
+		level = "agent"
+	}
+	if !dashboard.AlertLevelValid(metric, level) {
+		return fmt.Errorf("metric %s cannot be measured by %q", metric, level)
+	}
+	comparison := strings.ToLower(strings.TrimSpace(in.Comparison))
+	if !slices.Contains(comparisons, comparison) {
+		return fmt.Errorf("comparison must be one of %s", strings.Join(comparisons, ", "))
+	}
+	if in.Threshold == nil {
+		return fmt.Errorf("threshold is required")
+	}
+	window := in.WindowDays
+	if window == 0 {
+		window = 7
+	}
+	if window < 1 || window > 90 {
+		return fmt.Errorf("window_days must be from 1 to 90")
+	}
+	severity := strings.ToLower(strings.TrimSpace(in.Severity))
+	if severity == "" {
+		severity = models.AlertWarning
+	}
+	if !slices.Contains(severities, severity) {
+		return fmt.Errorf("severity must be one of %s", strings.Join(severities, ", "))
+	}
+
+	if scope.Denied {
+		return fmt.Errorf("no territory is visible to you")
+	}
+	t := territory{in.CountryUUID, in.ProvinceUUID, in.AreaUUID, in.SubAreaUUID, in.CommuneUUID}
+	if column, _ := t.narrowest(); scope.Column != "" && (column == "" || wider(column, scope.Column)) {
+		t = t.with(scope.Column, scope.UUID)
+	}
+	t, err := t.complete(db)
+	if err != nil {
+		return err
+	}
+	if !scope.Contains(t.CountryUUID, t.ProvinceUUID, t.AreaUUID, t.SubAreaUUID, t.CommuneUUID) {
+		return fmt.Errorf("the territory of the rule is outside yours")
+	}
+
+	if in.BrandUUID != "" {
+		if metric == models.AlertVisits || metric == models.AlertInactivity {
+			return fmt.Errorf("brand_uuid does not apply to %s", metric)
+		}
+		var count int64
+		db.Model(&models.Brand{}).Where("uuid = ?", in.BrandUUID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("no brand %s", in.BrandUUID)
+		}
+	}
+	if in.UserUUID != "" {
+		if metric != models.AlertVisits && metric != models.AlertInactivity {
+			return fmt.Errorf("user_uuid does not apply to %s", metric)
+		}
+		agent, err := locateAgent(db, in.UserUUID)
+		if err != nil {
+			return err
+		}
+		if !scope.Contains(agent.CountryUUID, agent.ProvinceUUID, agent.AreaUUID, agent.SubAreaUUID, agent.CommuneUUID) {
+			return fmt.Errorf("the agent is outside your territory")
+		}
+	}
+
+	r.Name = strings.TrimSpace(in.Name)
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("%s %s %s", metricLabels[metric], comparisonSigns[comparison], utils.FormatNumber(*in.Threshold, 1))
+	}
+	r.Metric = metric
+	r.Level = level
+	r.Comparison = comparison
+	r.Threshold = *in.Threshold
+	r.WindowDays = window
+	r.Severity = severity
+	r.CountryUUID, r.ProvinceUUID, r.AreaUUID, r.SubAreaUUID, r.CommuneUUID =
+		t.CountryUUID, t.ProvinceUUID, t.AreaUUID, t.SubAreaUUID, t.CommuneUUID
+	r.BrandUUID = in.BrandUUID
+	r.UserUUID = in.UserUUID
+	if dashboard.AlertScopeNarrower(r) {
+		return fmt.Errorf("the territory of the rule is narrower than its level %s", level)
+	}
+	if in.Notify != nil {
+		r.Notify = *in.Notify
+	}
+	if in.Active != nil {
+		r.Active = *in.Active
+	}
+	return nil
+}
+
+// wider reports whether the territory column a is wider than b.
+func wider(a, b string) bool {
+	for _, tt := range territoryTables {
+		if tt.Column == b {
+			return false
+		}
+		if tt.Column == a {
+			return true
+		}
+	}
+	return false
+}
+
+// findRule loads the rule of the uuid param within the scope of the caller.
+func findRule(c *fiber.Ctx) (*models.AlertRule, error) {
+	scope := utils.ResolveScope(middlewares.CurrentUser(c))
+	var r models.AlertRule
+	err := utils.ApplyScope(database.DB.Model(&models.AlertRule{}), scope, "alert_rules").
+		Where("uuid = ?", c.Params("uuid")).
+		First(&r).Error
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetRules lists the alert rules of the caller's territory, filtered by
+// metric and active.
+func GetRules(c *fiber.Ctx) error {
+	scope := utils.ResolveScope(middlewares.CurrentUser(c))
+
+	query := utils.ApplyScope(database.DB.Model(&models.AlertRule{}), scope, "alert_rules")
+	if metric := c.Query("metric"); metric != "" {
+		query = query.Where("metric = ?", metric)
+	}
+	if active := c.Query("active"); active != "" {
+		query = query.Where("active = ?", c.QueryBool("active"))
+	}
+
+	var data []models.AlertRule
+	if err := query.Order("created_at DESC").Find(&data).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch alert rules",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All alert rules",
+		"data":    data,
+	})
+}
+
+// GetRule returns one alert rule.
+func GetRule(c *fiber.Ctx) error {
+	r, err := findRule(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No alert rule found",
+			"data":    nil,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alert rule found",
+		"data":    r,
+	})
+}
+
+// CreateRule saves an alert rule. It is first evaluated by the next run of
+// the evaluator.
+func CreateRule(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+
+	var input ruleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	r := &models.AlertRule{UUID: uuid.New().String(), CreatedBy: user.UUID, Notify: true, Active: true}
+	if err := input.apply(database.DB, r, utils.ResolveScope(user)); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid alert rule",
+			"error":   err.Error(),
+		})
+	}
+
+	// Select all so that notify=false and active=false are not replaced by the defaults
+	if err := database.DB.Select("*").Create(r).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create alert rule",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alert rule created success",
+		"data":    r,
+	})
+}
+
+// UpdateRule replaces the settings of an alert rule. The open alerts of a
+// rule deactivated are resolved.
+func UpdateRule(c *fiber.Ctx) error {
+	r, err := findRule(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No alert rule found",
+			"data":    nil,
+		})
+	}
+
+	var input ruleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	scope := utils.ResolveScope(middlewares.CurrentUser(c))
+	if err := input.apply(database.DB, r, scope); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid alert rule",
+			"error":   err.Error(),
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(r).Error; err != nil {
+			return err
+		}
+		if !r.Active {
+			return resolveRule(tx, r.UUID, time.Now())
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update alert rule",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alert rule updated success",
+		"data":    r,
+	})
+}
+
+// DeleteRule removes an alert rule and resolves its open alerts. Its
+// alerts are kept.
+func DeleteRule(c *fiber.Ctx) error {
+	r, err := findRule(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No alert rule found",
+			"data":    nil,
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(r).Error; err != nil {
+			return err
+		}
+		return resolveRule(tx, r.UUID, time.Now())
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete alert rule",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alert rule deleted success",
+		"data":    nil,
+	})
+}
+
+// EvaluateRule evaluates an active alert rule now and returns the outcome.
+func EvaluateRule(c *fiber.Ctx) error {
+	r, err := findRule(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No alert rule found",
+			"data":    nil,
+		})
+	}
+	if !r.Active {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The alert rule is not active",
+			"data":    nil,
+		})
+	}
+
+	ev, err := Evaluate(r, time.Now())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to evaluate alert rule",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "alert rule evaluated",
+		"data":    ev,
+	})
+}
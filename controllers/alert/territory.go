@@ -0,0 +1,132 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+// territory is the place of a subject or a rule in the hierarchy.
+type territory struct {
+	CountryUUID  string
+	ProvinceUUID string
+	AreaUUID     string
+	SubAreaUUID  string
+	CommuneUUID  string
+}
+
+// territoryTables are the territory columns and their tables, widest first.
+var territoryTables = []struct {
+	Column string
+	Table  string
+}{
+	{"country_uuid", "countries"},
+	{"province_uuid", "provinces"},
+	{"area_uuid", "areas"},
+	{"sub_area_uuid", "sub_areas"},
+	{"commune_uuid", "communes"},
+}
+
+// levelColumns are the territory columns of the rule levels.
+var levelColumns = map[string]string{
+	"province": "province_uuid",
+	"area":     "area_uuid",
+	"subarea":  "sub_area_uuid",
+	"commune":  "commune_uuid",
+}
+
+// locate returns the hierarchy of the territory of column (province_uuid,
+// area_uuid…) down to it. Its table holds the uuids of the wider levels.
+func locate(db *gorm.DB, column, uuid string) (territory, error) {
+	var t territory
+	selects := []string{}
+	table := ""
+	for _, tt := range territoryTables {
+		switch {
+		case table != "":
+			selects = append(selects, "'' AS "+tt.Column)
+		case tt.Column == column:
+			table = tt.Table
+			selects = append(selects, "uuid AS "+tt.Column)
+		default:
+			selects = append(selects, tt.Column)
+		}
+	}
+	if table == "" {
+		return t, fmt.Errorf("unknown territory column %s", column)
+	}
+
+	res := db.Table(table).Select(strings.Join(selects, ", ")).
+		Where("uuid = ? AND deleted_at IS NULL", uuid).
+		Limit(1).Scan(&t)
+	if res.Error != nil {
+		return t, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return t, fmt.Errorf("no %s %s", strings.TrimSuffix(column, "_uuid"), uuid)
+	}
+	return t, nil
+}
+
+// locateAgent returns the territory of a user.
+func locateAgent(db *gorm.DB, userUUID string) (territory, error) {
+	var u models.User
+	if err := db.Where("uuid = ?", userUUID).First(&u).Error; err != nil {
+		return territory{}, fmt.Errorf("no user %s", userUUID)
+	}
+	return territory{u.CountryUUID, u.ProvinceUUID, u.AreaUUID, u.SubAreaUUID, u.CommuneUUID}, nil
+}
+
+// narrowest returns the column and uuid of the narrowest territory of t.
+func (t territory) narrowest() (string, string) {
+	values := t.values()
+	for i := len(values) - 1; i >= 0; i-- {
+		if values[i] != "" {
+			return territoryTables[i].Column, values[i]
+		}
+	}
+	return "", ""
+}
+
+// with returns t restricted to the territory of column.
+func (t territory) with(column, uuid string) territory {
+	switch column {
+	case "country_uuid":
+		t.CountryUUID = uuid
+	case "province_uuid":
+		t.ProvinceUUID = uuid
+	case "area_uuid":
+		t.AreaUUID = uuid
+	case "sub_area_uuid":
+		t.SubAreaUUID = uuid
+	case "commune_uuid":
+		t.CommuneUUID = uuid
+	}
+	return t
+}
+
+func (t territory) values() []string {
+	return []string{t.CountryUUID, t.ProvinceUUID, t.AreaUUID, t.SubAreaUUID, t.CommuneUUID}
+}
+
+// complete fills the wider levels of t from its narrowest territory, and
+// fails if a territory set in t is not one of them.
+func (t territory) complete(db *gorm.DB) (territory, error) {
+	column, uuid := t.narrowest()
+	if column == "" {
+		return t, nil
+	}
+	full, err := locate(db, column, uuid)
+	if err != nil {
+		return t, err
+	}
+	given, found := t.values(), full.values()
+	for i, v := range given {
+		if v != "" && v != found[i] {
+			return t, fmt.Errorf("%s is not in %s %s", uuid, strings.TrimSuffix(territoryTables[i].Column, "_uuid"), v)
+		}
+	}
+	return full, nil
+}
@@ -1,12 +1,11 @@
 package auth
 
 import (
-	"fmt"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
@@ -139,14 +138,7 @@ func Login(c *fiber.Ctx) error {
 
 func AuthUser(c *fiber.Ctx) error {
 
-	token := c.Query("token")
-
-	fmt.Println("token", token)
-
-	// cookie := c.Cookies("token")
-	UserUUID, _ := utils.VerifyJwt(token)
-
-	fmt.Println("UserUUID", UserUUID)
+	UserUUID := middlewares.CurrentUser(c).UUID
 
 	u := models.User{}
 
@@ -256,17 +248,13 @@ func UpdateInfo(c *fiber.Ctx) error {
 		})
 	}
 
-	cookie := c.Cookies("token")
-
-	Id, _ := utils.VerifyJwt(cookie)
-
-	UserUUID, _ := strconv.Atoi(Id)
+	UserUUID := middlewares.CurrentUser(c).UUID
 
 	user := new(models.User)
 
 	db := database.DB
 
-	db.First(&user, UserUUID)
+	db.Where("uuid = ?", UserUUID).First(&user)
 	user.Fullname = updateData.Fullname
 	user.Email = updateData.Email
 	user.Phone = updateData.Phone
@@ -298,9 +286,7 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
-	cookie := c.Cookies("token")
-
-	UserUUID, _ := utils.VerifyJwt(cookie)
+	UserUUID := middlewares.CurrentUser(c).UUID
 
 	user := new(models.User)
 
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"net/smtp"
 	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
@@ -45,19 +44,13 @@ func Forgot(c *fiber.Ctx) error {
 
 	database.DB.Create(pr)
 
-	from := utils.Env("EMAIL_FROM")
-
-	to := []string{
-		u.Email,
-	}
-
-	auth := smtp.PlainAuth("", utils.Env("EMAIL_USERNAME"), utils.Env("EMAIL_PASSWORD"), utils.Env("EMAIL_HOST"))
-
 	url := utils.Env("RESET_URL") + token
 
-	msg := []byte("Click <a href=\"" + url + "\">here</a> to reset your password!")
-
-	err := smtp.SendMail(utils.Env("EMAIL_HOST")+":"+utils.Env("EMAIL_PORT"), auth, from, to, msg)
+	err := utils.SendEmail(utils.EmailMessage{
+		To:      []string{u.Email},
+		Subject: "Reset your password",
+		HTML:    "Click <a href=\"" + url + "\">here</a> to reset your password!",
+	})
 	if err != nil {
 		c.Status(400)
 		return c.JSON(fiber.Map{
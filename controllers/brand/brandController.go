@@ -2,14 +2,51 @@ package brand
 
 import (
 	"fmt"
+	"log"
 	"strconv"
 
 	"github.com/danny19977/mspos-api-v3/database"
 	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
+// checkCatalogue checks that the manufacturer and category of the brand,
+// when given, exist.
+func checkCatalogue(b *models.Brand) error {
+	var count int64
+	if b.ManufacturerUUID != "" {
+		database.DB.Model(&models.Manufacturer{}).Where("uuid = ?", b.ManufacturerUUID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("unknown manufacturer_uuid")
+		}
+	}
+	if b.CategoryUUID != "" {
+		database.DB.Model(&models.Category{}).Where("uuid = ?", b.CategoryUUID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("unknown category_uuid")
+		}
+	}
+	return nil
+}
+
+// rebuildFacts recomputes, in the background, the dashboard facts of a
+// brand moved to another category, manufacturer or ownership.
+func rebuildFacts(brandUUID string) {
+	go func() {
+		if err := utils.RebuildBrandFacts(database.DB, brandUUID); err != nil {
+			log.Printf("Rebuild facts of brand %s: %v", brandUUID, err)
+		}
+	}()
+}
+
+// invalidateDashboards drops the cached dashboard responses, which show
+// the catalogue.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
 // Paginate
 func GetPaginatedBrands(c *fiber.Ctx) error {
 	db := database.DB
@@ -240,8 +277,18 @@ func GetPaginatedBrandsByProvinceUUID(c *fiber.Ctx) error {
 func GetAllBrands(c *fiber.Ctx) error {
 	db := database.DB
 
+	query := db.Model(&models.Brand{})
+	for _, param := range []string{"manufacturer_uuid", "category_uuid"} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(param+" = ?", v)
+		}
+	}
+	if competitor := c.Query("competitor"); competitor != "" {
+		query = query.Where("competitor = ?", competitor == "true")
+	}
+
 	var data []models.Brand
-	db.
+	query.
 		Preload("Province").
 		Order("brands.updated_at DESC").
 		Find(&data)
@@ -304,8 +351,17 @@ func CreateBrand(c *fiber.Ctx) error {
 		return err
 	}
 
+	if err := checkCatalogue(p); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid brand",
+			"error":   err.Error(),
+		})
+	}
+
 	p.UUID = uuid.New().String()
 	database.DB.Create(p)
+	invalidateDashboards()
 
 	return c.JSON(
 		fiber.Map{
@@ -327,6 +383,11 @@ func UpdateBrand(c *fiber.Ctx) error {
 		CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null"`
 		ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null"`
 		Signature    string `json:"signature"`
+
+		// Left unchanged when absent
+		ManufacturerUUID *string `json:"manufacturer_uuid"`
+		CategoryUUID     *string `json:"category_uuid"`
+		Competitor       *bool   `json:"competitor"`
 	}
 
 	var updateData UpdateData
@@ -349,7 +410,30 @@ func UpdateBrand(c *fiber.Ctx) error {
 	brand.ProvinceUUID = updateData.ProvinceUUID
 	brand.Signature = updateData.Signature
 
+	before := *brand
+	if updateData.ManufacturerUUID != nil {
+		brand.ManufacturerUUID = *updateData.ManufacturerUUID
+	}
+	if updateData.CategoryUUID != nil {
+		brand.CategoryUUID = *updateData.CategoryUUID
+	}
+	if updateData.Competitor != nil {
+		brand.Competitor = *updateData.Competitor
+	}
+	if err := checkCatalogue(brand); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid brand",
+			"error":   err.Error(),
+		})
+	}
+
 	db.Save(&brand)
+	invalidateDashboards()
+	if brand.ManufacturerUUID != before.ManufacturerUUID || brand.CategoryUUID != before.CategoryUUID ||
+		brand.Competitor != before.Competitor {
+		rebuildFacts(brand.UUID)
+	}
 
 	return c.JSON(
 		fiber.Map{
@@ -380,6 +464,7 @@ func DeleteBrand(c *fiber.Ctx) error {
 	}
 
 	db.Delete(&brand)
+	invalidateDashboards()
 
 	return c.JSON(
 		fiber.Map{
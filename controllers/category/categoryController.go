@@ -0,0 +1,243 @@
+package category
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// categoryInput is a category as sent by the client.
+type categoryInput struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+}
+
+// invalidateDashboards drops the cached dashboard responses, which show
+// the catalogue.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedCategories(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	search := c.Query("search", "")
+
+	var totalRecords int64
+	db.Model(&models.Category{}).
+		Where("name ILIKE ?", "%"+search+"%").
+		Count(&totalRecords)
+
+	var dataList []models.Category
+	err = db.
+		Where("name ILIKE ?", "%"+search+"%").
+		Offset(offset).
+		Limit(limit).
+		Order("name").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch categories",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "categories retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data
+func GetAllCategories(c *fiber.Ctx) error {
+	db := database.DB
+
+	var data []models.Category
+	db.Order("name").Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All categories",
+		"data":    data,
+	})
+}
+
+// Get one data, with its brands
+func GetOneCategory(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var category models.Category
+	db.Preload("Brands").Where("uuid = ?", uuid).First(&category)
+	if category.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No category found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "category found",
+			"data":    category,
+		},
+	)
+}
+
+// Create data
+func CreateCategory(c *fiber.Ctx) error {
+	var input categoryInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "name is required",
+		})
+	}
+
+	p := &models.Category{
+		UUID:      uuid.New().String(),
+		Name:      strings.TrimSpace(input.Name),
+		Signature: input.Signature,
+	}
+	if err := database.DB.Create(p).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create category",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "category created success",
+			"data":    p,
+		},
+	)
+}
+
+// Update data
+func UpdateCategory(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input categoryInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "name is required",
+		})
+	}
+
+	var category models.Category
+	db.Where("uuid = ?", uuid).First(&category)
+	if category.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No category found",
+			"data":    nil,
+		})
+	}
+
+	category.Name = strings.TrimSpace(input.Name)
+	category.Signature = input.Signature
+	if err := db.Save(&category).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update category",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "category updated success",
+			"data":    category,
+		},
+	)
+}
+
+// Delete data, refused while brands are in the category
+func DeleteCategory(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var category models.Category
+	db.Where("uuid = ?", uuid).First(&category)
+	if category.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No category found",
+				"data":    nil,
+			},
+		)
+	}
+
+	var brands int64
+	db.Model(&models.Brand{}).Where("category_uuid = ?", uuid).Count(&brands)
+	if brands > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Move the brands of the category before deleting it",
+		})
+	}
+
+	db.Delete(&category)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "category deleted success",
+			"data":    nil,
+		},
+	)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/danny19977/mspos-api-v3/database"
 	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
@@ -142,6 +143,16 @@ func CreateCountry(c *fiber.Ctx) error {
 		return err
 	}
 
+	currency, err := utils.NormalizeCurrency(p.Currency)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid currency",
+			"error":   err.Error(),
+		})
+	}
+	p.Currency = currency
+
 	p.UUID = uuid.New().String()
 	database.DB.Create(p)
 
@@ -162,6 +173,7 @@ func UpdateCountry(c *fiber.Ctx) error {
 	type UpdateData struct {
 		UUID      string `json:"uuid"`
 		Name      string `json:"name"`
+		Currency  string `json:"currency"`
 		Signature string `json:"signature"`
 	}
 
@@ -181,6 +193,17 @@ func UpdateCountry(c *fiber.Ctx) error {
 
 	db.Where("uuid = ?", uuid).First(&country)
 	country.Name = updateData.Name
+	if updateData.Currency != "" {
+		currency, err := utils.NormalizeCurrency(updateData.Currency)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid currency",
+				"error":   err.Error(),
+			})
+		}
+		country.Currency = currency
+	}
 	country.Signature = updateData.Signature
 
 	db.Save(&country)
@@ -0,0 +1,287 @@
+package dashboard
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// ╔══════════════════════════════════════════════════════════════════════════════╗
+// ║                 ALERT MEASURES — VALUES CHECKED BY ALERT RULES               ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  oos, nd, sos, wd — metric engine territory rows: one per territory × brand ║
+// ║  visits           — visits per agent, or per territory of the level         ║
+// ║  inactivity       — days since the last visit of each field agent           ║
+// ║  The % metrics and visits are computed over the rule window; inactivity is ║
+// ║  measured at the time of the evaluation.                                    ║
+// ╚══════════════════════════════════════════════════════════════════════════════╝
+
+// alertMetrics maps the % metrics of the alert rules to the engine.
+var alertMetrics = map[string]struct {
+	def    *metricDefinition
+	column string
+}{
+	models.AlertOOS: {&oosMetric, "oos_percent"},
+	models.AlertND:  {&ndMetric, "nd_percent"},
+	models.AlertSOS: {&sosMetric, "sos_percent"},
+	models.AlertWD:  {&wdMetric, "wd_percent"},
+}
+
+// AlertMeasure is the value of the metric of a rule for one subject.
+type AlertMeasure struct {
+	Subject       string // Key of the subject, e.g. area:<uuid>|brand:<uuid>
+	Name          string
+	Level         string
+	TerritoryUUID string
+	BrandUUID     string
+	AgentUUID     string
+	Value         float64
+}
+
+// AlertLevelValid reports whether the metric can be measured at level.
+func AlertLevelValid(metric, level string) bool {
+	_, territory := metricLevels[level]
+	switch metric {
+	case models.AlertInactivity:
+		return level == "agent"
+	case models.AlertVisits:
+		return territory || level == "agent"
+	default:
+		_, ok := alertMetrics[metric]
+		return ok && territory
+	}
+}
+
+// MeasureAlertRule computes the metric of the rule for each of its subjects
+// over the window ending at now.
+func MeasureAlertRule(rule *models.AlertRule, now time.Time) ([]AlertMeasure, error) {
+	if !AlertLevelValid(rule.Metric, rule.Level) {
+		return nil, fmt.Errorf("metric %s cannot be measured by %s", rule.Metric, rule.Level)
+	}
+	window := rule.WindowDays
+	if window <= 0 {
+		window = 7
+	}
+	start := now.AddDate(0, 0, -window+1)
+
+	switch rule.Metric {
+	case models.AlertInactivity:
+		return measureInactivity(rule, now)
+	case models.AlertVisits:
+		return measureVisits(rule, start, now)
+	}
+
+	// The engine reads one country at a time
+	countries := []string{rule.CountryUUID}
+	if rule.CountryUUID == "" {
+		countries = nil
+		if err := database.DB.Model(&models.Country{}).Pluck("uuid", &countries).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	m := alertMetrics[rule.Metric]
+	out := []AlertMeasure{}
+	for _, country := range countries {
+		rows, err := m.def.territoryRows(rule.Level, metricLevels[rule.Level], map[string]interface{}{
+			"country_uuid":  country,
+			"province_uuid": rule.ProvinceUUID,
+			"area_uuid":     rule.AreaUUID,
+			"sub_area_uuid": rule.SubAreaUUID,
+			"commune_uuid":  rule.CommuneUUID,
+			"brand_uuid":    rule.BrandUUID,
+			"start_date":    start.Format("2006-01-02"),
+			"end_date":      now.Format("2006-01-02") + " 23:59:59",
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			territory, _ := r["territory_uuid"].(string)
+			territoryName, _ := r["territory_name"].(string)
+			brand, _ := r["brand_uuid"].(string)
+			brandName, _ := r["brand_name"].(string)
+			if rule.BrandUUID != "" && brand != rule.BrandUUID {
+				continue
+			}
+			value, _ := r[m.column].(float64)
+			out = append(out, AlertMeasure{
+				Subject:       rule.Level + ":" + territory + "|brand:" + brand,
+				Name:          territoryName + " — " + brandName,
+				Level:         rule.Level,
+				TerritoryUUID: territory,
+				BrandUUID:     brand,
+				Value:         value,
+			})
+		}
+	}
+	return out, nil
+}
+
+// alertUserFilter is the territory and agent filter of users u.
+const alertUserFilter = `u.status = true
+			  AND (@country_uuid  = '' OR u.country_uuid  = @country_uuid)
+			  AND (@province_uuid = '' OR u.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR u.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR u.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR u.commune_uuid  = @commune_uuid)
+			  AND (@user_uuid     = '' OR u.uuid          = @user_uuid)
+			  AND u.deleted_at IS NULL`
+
+func alertRuleParams(rule *models.AlertRule) map[string]interface{} {
+	return map[string]interface{}{
+		"country_uuid":  rule.CountryUUID,
+		"province_uuid": rule.ProvinceUUID,
+		"area_uuid":     rule.AreaUUID,
+		"sub_area_uuid": rule.SubAreaUUID,
+		"commune_uuid":  rule.CommuneUUID,
+		"user_uuid":     rule.UserUUID,
+		"field_titles":  []string{"ASM", "Supervisor", "DR", "Cyclo", "Agent"},
+	}
+}
+
+// measureInactivity returns the days since the last visit of each field
+// agent, or since their creation when they never visited.
+func measureInactivity(rule *models.AlertRule, now time.Time) ([]AlertMeasure, error) {
+	params := alertRuleParams(rule)
+	params["now"] = now
+
+	var rows []struct {
+		UUID     string
+		Fullname string
+		Days     float64
+	}
+	err := database.DB.Raw(`
+		SELECT u.uuid, u.fullname,
+		       FLOOR(EXTRACT(EPOCH FROM (@now - COALESCE(MAX(pf.created_at), u.created_at))) / 86400) AS days
+		FROM users u
+		LEFT JOIN pos_forms pf ON pf.user_uuid = u.uuid AND pf.deleted_at IS NULL
+		WHERE `+alertUserFilter+`
+		  AND u.title IN @field_titles
+		GROUP BY u.uuid, u.fullname, u.created_at
+	`, params).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]AlertMeasure, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, AlertMeasure{
+			Subject:   "agent:" + r.UUID,
+			Name:      r.Fullname,
+			Level:     "agent",
+			AgentUUID: r.UUID,
+			Value:     r.Days,
+		})
+	}
+	return out, nil
+}
+
+// measureVisits returns the visits from start to end of each field agent,
+// or of each territory of the level.
+func measureVisits(rule *models.AlertRule, start, end time.Time) ([]AlertMeasure, error) {
+	params := alertRuleParams(rule)
+	params["start_date"] = start.Format("2006-01-02")
+	params["end_date"] = end.Format("2006-01-02") + " 23:59:59"
+
+	if rule.Level == "agent" {
+		var rows []struct {
+			UUID     string
+			Fullname string
+			Visits   float64
+		}
+		err := database.DB.Raw(`
+			SELECT u.uuid, u.fullname, COUNT(pf.uuid) AS visits
+			FROM users u
+			LEFT JOIN pos_forms pf ON pf.user_uuid = u.uuid
+			      AND pf.deleted_at IS NULL
+			      AND pf.created_at >= @start_date AND pf.created_at <= @end_date
+			WHERE `+alertUserFilter+`
+			  AND u.title IN @field_titles
+			GROUP BY u.uuid, u.fullname
+		`, params).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		out := make([]AlertMeasure, 0, len(rows))
+		for _, r := range rows {
+			out = append(out, AlertMeasure{
+				Subject:   "agent:" + r.UUID,
+				Name:      r.Fullname,
+				Level:     "agent",
+				AgentUUID: r.UUID,
+				Value:     r.Visits,
+			})
+		}
+		return out, nil
+	}
+
+	lv := metricLevels[rule.Level]
+	var rows []struct {
+		UUID   string
+		Name   string
+		Visits float64
+	}
+	err := database.DB.Raw(`
+		SELECT t.uuid, t.name, COUNT(pf.uuid) AS visits
+		FROM `+lv.Table+` t
+		LEFT JOIN pos_forms pf ON pf.`+lv.Column+` = t.uuid
+		      AND pf.deleted_at IS NULL
+		      AND pf.created_at >= @start_date AND pf.created_at <= @end_date
+		      AND (@user_uuid = '' OR pf.user_uuid = @user_uuid)
+		WHERE t.deleted_at IS NULL`+territoryFilter(rule.Level)+`
+		GROUP BY t.uuid, t.name
+	`, params).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]AlertMeasure, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, AlertMeasure{
+			Subject:       rule.Level + ":" + r.UUID,
+			Name:          r.Name,
+			Level:         rule.Level,
+			TerritoryUUID: r.UUID,
+			Value:         r.Visits,
+		})
+	}
+	return out, nil
+}
+
+// alertScopeColumns are the territory columns of a rule, widest first.
+var alertScopeColumns = []string{"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid"}
+
+// AlertScopeNarrower reports whether the rule is restricted to a territory
+// narrower than its level, which a territory of the level cannot be in.
+func AlertScopeNarrower(rule *models.AlertRule) bool {
+	lv, ok := metricLevels[rule.Level]
+	if !ok {
+		return false
+	}
+	values := []string{rule.CountryUUID, rule.ProvinceUUID, rule.AreaUUID, rule.SubAreaUUID, rule.CommuneUUID}
+	narrower := false
+	for i, column := range alertScopeColumns {
+		if narrower && values[i] != "" {
+			return true
+		}
+		if column == lv.Column {
+			narrower = true
+		}
+	}
+	return false
+}
+
+// territoryFilter restricts the territories t of the level to those of the
+// rule. Territory tables hold the uuids of the wider levels.
+func territoryFilter(level string) string {
+	filter := ""
+	for _, column := range alertScopeColumns {
+		if column == metricLevels[level].Column {
+			return filter + "\n\t\t  AND (@" + column + " = '' OR t.uuid = @" + column + ")"
+		}
+		filter += "\n\t\t  AND (@" + column + " = '' OR t." + column + " = @" + column + ")"
+	}
+	return filter
+}
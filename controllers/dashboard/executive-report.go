@@ -0,0 +1,416 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ╔══════════════════════════════════════════════════════════════════════════════╗
+// ║              EXECUTIVE REPORT — MONTHLY TERRITORY REVIEW (PDF)               ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  GET /dashboard/executive-report/pdf                                        ║
+// ║  Query params: country_uuid (required), province_uuid, area_uuid, …,        ║
+// ║                month=YYYY-MM (default: last month) or start_date/end_date,  ║
+// ║                months=6 (length of the trends)                              ║
+// ║  Pages: cover · key indicators · ND/SOS/WD trends · brand ranking ·         ║
+// ║         top/bottom areas · OOS critical alerts · agent scorecards           ║
+// ║  Each section is read from the dashboard views of the same territory and   ║
+// ║  period, so the report shows what the dashboards show.                      ║
+// ╚══════════════════════════════════════════════════════════════════════════════╝
+
+// ExportExecutivePDF génère la revue mensuelle d'un territoire en PDF
+func ExportExecutivePDF(c *fiber.Ctx) error {
+	return utils.SendDocument(c, BuildExecutivePDF)
+}
+
+// executiveSections is the number of sections of the report, for progress
+const executiveSections = 6
+
+var frenchMonths = []string{"Janvier", "Février", "Mars", "Avril", "Mai", "Juin",
+	"Juillet", "Août", "Septembre", "Octobre", "Novembre", "Décembre"}
+
+// executivePeriod reads the period of the report: month, else start_date
+// and end_date, else the last complete month. Returns its label too.
+func executivePeriod(c *fiber.Ctx) (start, end time.Time, label string, err error) {
+	if month := c.Query("month"); month != "" {
+		start, err = time.Parse("2006-01", month)
+		if err != nil {
+			return start, end, "", err
+		}
+	} else if c.Query("start_date") != "" && c.Query("end_date") != "" {
+		if start, err = utils.ParseDay(c.Query("start_date")); err != nil {
+			return start, end, "", err
+		}
+		if end, err = utils.ParseDay(c.Query("end_date")); err != nil {
+			return start, end, "", err
+		}
+		return start, end, start.Format("02/01/2006") + " – " + end.Format("02/01/2006"), nil
+	} else {
+		now := time.Now()
+		start = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	end = start.AddDate(0, 1, -1)
+	return start, end, fmt.Sprintf("%s %d", frenchMonths[start.Month()-1], start.Year()), nil
+}
+
+// territoryName is the name of the narrowest territory of the params.
+func territoryName(c *fiber.Ctx) string {
+	for _, key := range []string{"commune_uuid", "sub_area_uuid", "area_uuid", "province_uuid", "country_uuid"} {
+		if uuid := c.Query(key); uuid != "" {
+			name := exportFilterNames[key]
+			var resolved string
+			database.DB.Table(name[1]).Select(name[2]).Where("uuid = ?", uuid).Scan(&resolved)
+			if resolved != "" {
+				return resolved
+			}
+		}
+	}
+	return "Tous territoires"
+}
+
+// executiveParams are the metricParams of the territory of c from start to
+// end, whatever the period params of c.
+func executiveParams(c *fiber.Ctx, start, end time.Time) map[string]interface{} {
+	params := map[string]interface{}{
+		"start_date": start.Format("2006-01-02"),
+		"end_date":   end.Format("2006-01-02") + " 23:59:59",
+	}
+	for _, key := range []string{"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid", "brand_uuid"} {
+		params[key] = c.Query(key)
+	}
+	return params
+}
+
+func pct(v float64) string { return utils.FormatNumber(v, 1) + " %" }
+
+// BuildExecutivePDF construit le rapport de ExportExecutivePDF
+func BuildExecutivePDF(c *fiber.Ctx, opts utils.ReportOptions) ([]byte, string, error) {
+	if c.Query("country_uuid") == "" {
+		return nil, "", &utils.ReportError{Status: fiber.StatusBadRequest, Message: "country_uuid is required"}
+	}
+	start, end, periodLabel, err := executivePeriod(c)
+	if err != nil {
+		return nil, "", &utils.ReportError{Status: fiber.StatusBadRequest, Message: "invalid month or start_date/end_date; use YYYY-MM / YYYY-MM-DD", Err: err}
+	}
+	months := c.QueryInt("months", 6)
+	if months < 1 || months > 24 {
+		months = 6
+	}
+
+	period := map[string]string{
+		"start_date": start.Format("2006-01-02"),
+		"end_date":   end.Format("2006-01-02"),
+	}
+	view := func(h fiber.Handler, extra map[string]string) (*jsonObject, error) {
+		params := map[string]string{}
+		for k, v := range period {
+			params[k] = v
+		}
+		for k, v := range extra {
+			params[k] = v
+		}
+		return runView(c, h, params)
+	}
+	fail := func(section string, err error) ([]byte, string, error) {
+		return nil, "", &utils.ReportError{Status: fiber.StatusInternalServerError, Message: "Failed to build the " + section + " section", Err: err}
+	}
+
+	territory := territoryName(c)
+	author := ""
+	if user := middlewares.CurrentUser(c); user != nil {
+		author = user.Fullname
+	}
+	rep := utils.NewPDFReport(utils.PDFReportConfig{
+		Title:       "Revue mensuelle — " + territory,
+		Subtitle:    periodLabel,
+		CompanyName: "MSPOS",
+		ReportDate:  time.Now(),
+		Author:      author,
+	})
+	filters := exportFilters(c, "executive-report", "pdf")[1:]
+	filters = append([]utils.ReportFilter{{Label: "Période", Value: periodLabel}}, filters...)
+	rep.CoverPage(filters)
+
+	// ── 1. Key indicators ─────────────────────────────────────────────────────
+	nd, err := view(NDSummaryKPI, nil)
+	if err != nil {
+		return fail("ND summary", err)
+	}
+	sos, err := view(SOSSummaryKPI, nil)
+	if err != nil {
+		return fail("SOS summary", err)
+	}
+	wd, err := view(WDSummaryKPI, nil)
+	if err != nil {
+		return fail("WD summary", err)
+	}
+	oos, err := view(OOSSummaryKPI, nil)
+	if err != nil {
+		return fail("OOS summary", err)
+	}
+	sales, err := view(SalesSummaryKPI, nil)
+	if err != nil {
+		return fail("sales summary", err)
+	}
+	ndKPI, sosKPI, wdKPI, oosKPI := nd.object("data"), sos.object("data"), wd.object("data"), oos.object("data")
+	current := sales.object("data").object("current")
+
+	rep.Section("Indicateurs clés", true)
+	rep.KPICards([]utils.PDFCard{
+		{Label: "Visites", Value: utils.FormatNumber(current.num("total_visits"), 0),
+			Note: utils.FormatNumber(current.num("active_agents"), 0) + " agents actifs"},
+		{Label: "POS actifs", Value: utils.FormatNumber(current.num("active_pos"), 0),
+			Note: "Couverture univers " + pct(ndKPI.num("reach_rate"))},
+		{Label: "Chiffre d'affaires", Value: utils.FormatNumber(current.num("total_revenue"), 0),
+			Note: utils.FormatNumber(current.num("total_farde"), 0) + " fardes, " + utils.FormatNumber(current.num("total_sold"), 0) + " vendus"},
+		{Label: "ND moyen", Value: pct(ndKPI.num("avg_nd_percent")),
+			Note: utils.FormatNumber(ndKPI.num("total_brands"), 0) + " marques suivies"},
+		{Label: "WD moyen", Value: pct(wdKPI.num("avg_wd_percent")),
+			Note: "Meilleure: " + wdKPI.str("best_brand_name")},
+		{Label: "OOS moyen", Value: pct(oosKPI.num("avg_oos_percent")),
+			Note: "Plus touchée: " + oosKPI.str("most_affected_brand")},
+		{Label: "SOS leader", Value: sosKPI.str("dominant_brand"),
+			Note: pct(sosKPI.num("dominant_sos")) + " du linéaire"},
+		{Label: "Concentration (HHI)", Value: utils.FormatNumber(sosKPI.num("hhi_index"), 0),
+			Note: sosKPI.str("market_structure")},
+		{Label: "Marques présentes", Value: utils.FormatNumber(sosKPI.num("brand_count"), 0),
+			Note: utils.FormatNumber(wdKPI.num("brands_above_50pct"), 0) + " au-dessus de 50 % WD"},
+	})
+	opts.ReportProgress(1, executiveSections)
+
+	// ── 2. Trends ─────────────────────────────────────────────────────────────
+	rep.Section(fmt.Sprintf("Tendances sur %d mois", months), true)
+	trendStart := time.Date(start.Year(), start.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.UTC)
+	if start.Before(trendStart) {
+		trendStart = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	params := executiveParams(c, trendStart, end)
+
+	labels := []string{}
+	for m := trendStart; !m.After(end); m = m.AddDate(0, 1, 0) {
+		labels = append(labels, m.Format("2006-01"))
+	}
+	for _, m := range []*metricDefinition{&ndMetric, &sosMetric, &wdMetric} {
+		rows, err := m.monthRows(params)
+		if err != nil {
+			return fail(m.Label+" trend", err)
+		}
+		rep.LineChart(m.Label+" % par marque (5 premières du dernier mois)", labels, trendSeries(rows, labels, m.Sort, 5), "%")
+	}
+	opts.ReportProgress(2, executiveSections)
+
+	// ── 3. Brand ranking ──────────────────────────────────────────────────────
+	ndRank, err := view(NDBrandRanking, nil)
+	if err != nil {
+		return fail("ND ranking", err)
+	}
+	sosRank, err := view(SOSBrandRanking, nil)
+	if err != nil {
+		return fail("SOS ranking", err)
+	}
+
+	rep.Section("Classement des marques", true)
+	ndRows := ndRank.list("data")
+	barLabels, barValues := []string{}, []float64{}
+	for _, r := range ndRows[:min(len(ndRows), 12)] {
+		barLabels = append(barLabels, r.str("brand_name"))
+		barValues = append(barValues, r.num("nd_percent"))
+	}
+	rep.BarChart("Distribution numérique (ND %)", barLabels, barValues, "%")
+
+	rep.Heading("Part de linéaire (SOS)")
+	rows := [][]string{}
+	for _, r := range sosRank.list("data") {
+		rows = append(rows, []string{
+			utils.FormatNumber(r.num("rank"), 0), r.str("brand_name"),
+			utils.FormatNumber(r.num("brand_fardes"), 0), pct(r.num("sos_percent")),
+			pct(r.num("cumulative_sos")), r.str("dominance"),
+		})
+	}
+	rep.Table([]utils.PDFColumn{
+		{Title: "#", Width: 10, Align: "C"}, {Title: "Marque"},
+		{Title: "Fardes", Width: 28, Align: "R"}, {Title: "SOS", Width: 24, Align: "R"},
+		{Title: "SOS cumulé", Width: 26, Align: "R"}, {Title: "Position", Width: 28, Align: "C"},
+	}, rows)
+	opts.ReportProgress(3, executiveSections)
+
+	// ── 4. Top / bottom areas ─────────────────────────────────────────────────
+	areaRows, err := ndMetric.territoryRows("area", metricLevels["area"], executiveParams(c, start, end))
+	if err != nil {
+		return fail("areas", err)
+	}
+	areas := rankAreas(areaRows)
+
+	rep.Section("Zones — meilleures et moins performantes", true)
+	rep.Paragraph("Zones classées par ND moyen des marques sur la période, avec leur couverture de l'univers POS.")
+	areaColumns := []utils.PDFColumn{
+		{Title: "Zone"}, {Title: "POS visités", Width: 26, Align: "R"}, {Title: "Univers", Width: 24, Align: "R"},
+		{Title: "Couverture", Width: 26, Align: "R"}, {Title: "ND moyen", Width: 26, Align: "R"},
+	}
+	areaTable := func(list []areaScore) [][]string {
+		out := [][]string{}
+		for _, a := range list {
+			out = append(out, []string{a.name, utils.FormatNumber(a.visited, 0), utils.FormatNumber(a.universe, 0), pct(a.reach), pct(a.nd)})
+		}
+		return out
+	}
+	if len(areas) <= 10 {
+		rep.Table(areaColumns, areaTable(areas))
+	} else {
+		rep.Heading("5 meilleures zones")
+		rep.Table(areaColumns, areaTable(areas[:5]))
+		rep.Heading("5 zones les moins performantes")
+		rep.Table(areaColumns, areaTable(areas[len(areas)-5:]))
+	}
+	opts.ReportProgress(4, executiveSections)
+
+	// ── 5. OOS critical alerts ────────────────────────────────────────────────
+	alerts, err := view(OOSCriticalAlert, map[string]string{"level": "area"})
+	if err != nil {
+		return fail("OOS alerts", err)
+	}
+	rep.Section("Alertes de rupture (OOS > 15 %)", false)
+	rows = [][]string{}
+	for _, r := range alerts.list("data") {
+		rows = append(rows, []string{r.str("territory_name"), r.str("brand_name"), pct(r.num("oos_percent")), r.str("severity")})
+	}
+	rep.Table([]utils.PDFColumn{
+		{Title: "Zone"}, {Title: "Marque"}, {Title: "OOS", Width: 24, Align: "R"}, {Title: "Sévérité", Width: 30, Align: "C"},
+	}, rows)
+	opts.ReportProgress(5, executiveSections)
+
+	// ── 6. Agent scorecards ───────────────────────────────────────────────────
+	scorecard, err := view(SalesRepScorecard, nil)
+	if err != nil {
+		return fail("agent scorecards", err)
+	}
+	rep.Section("Scorecards des agents", true)
+	rows = [][]string{}
+	for _, r := range scorecard.list("data") {
+		rows = append(rows, []string{
+			r.str("agent_name"), r.str("title"),
+			utils.FormatNumber(r.num("total_visits"), 0), utils.FormatNumber(r.num("unique_pos"), 0),
+			utils.FormatNumber(r.num("total_farde"), 0), pct(r.num("achievement_pct")),
+			utils.FormatNumber(r.num("perf_score"), 1),
+		})
+	}
+	rep.Table([]utils.PDFColumn{
+		{Title: "Agent"}, {Title: "Titre", Width: 22},
+		{Title: "Visites", Width: 18, Align: "R"}, {Title: "POS", Width: 16, Align: "R"},
+		{Title: "Fardes", Width: 20, Align: "R"}, {Title: "Objectif", Width: 20, Align: "R"},
+		{Title: "Score", Width: 16, Align: "R"},
+	}, rows)
+	opts.ReportProgress(executiveSections, executiveSections)
+
+	var buf bytes.Buffer
+	if err := rep.Output(&buf); err != nil {
+		return nil, "", err
+	}
+	slug := strings.ToLower(strings.Join(strings.Fields(territory), "_"))
+	return buf.Bytes(), fmt.Sprintf("revue_%s_%s.pdf", slug, start.Format("2006-01")), nil
+}
+
+// trendSeries makes a series per brand of the value column over the months
+// of labels: the n brands with the highest value on the last month having
+// data.
+func trendSeries(rows []metricRow, labels []string, value string, n int) []utils.PDFSeries {
+	index := map[string]int{}
+	for i, m := range labels {
+		index[m] = i
+	}
+	byBrand := map[string][]float64{}
+	last := map[string]float64{}
+	lastMonth := ""
+	for _, r := range rows {
+		month, _ := r["month"].(string)
+		brand, _ := r["brand_name"].(string)
+		i, ok := index[month]
+		if !ok {
+			continue
+		}
+		if byBrand[brand] == nil {
+			byBrand[brand] = make([]float64, len(labels))
+		}
+		v, _ := r[value].(float64)
+		byBrand[brand][i] = v
+		if month > lastMonth {
+			lastMonth, last = month, map[string]float64{}
+		}
+		if month == lastMonth {
+			last[brand] = v
+		}
+	}
+
+	brands := make([]string, 0, len(byBrand))
+	for b := range byBrand {
+		brands = append(brands, b)
+	}
+	sort.Slice(brands, func(i, j int) bool {
+		if last[brands[i]] != last[brands[j]] {
+			return last[brands[i]] > last[brands[j]]
+		}
+		return brands[i] < brands[j]
+	})
+
+	series := []utils.PDFSeries{}
+	for _, b := range brands[:min(len(brands), n)] {
+		series = append(series, utils.PDFSeries{Name: b, Values: byBrand[b]})
+	}
+	return series
+}
+
+// areaScore is an area of the ND territory rows, its brands averaged.
+type areaScore struct {
+	name              string
+	visited, universe float64
+	reach, nd         float64
+	brands            int
+}
+
+// rankAreas averages the ND % of the brands of each area, best first.
+func rankAreas(rows []metricRow) []areaScore {
+	num := func(v interface{}) float64 {
+		switch n := v.(type) {
+		case int64:
+			return float64(n)
+		case float64:
+			return n
+		}
+		return 0
+	}
+
+	index := map[string]int{}
+	areas := []areaScore{}
+	for _, r := range rows {
+		uuid, _ := r["territory_uuid"].(string)
+		i, ok := index[uuid]
+		if !ok {
+			name, _ := r["territory_name"].(string)
+			areas = append(areas, areaScore{
+				name:     name,
+				visited:  num(r["total_pos"]),
+				universe: num(r["universe_pos"]),
+				reach:    num(r["reach_rate"]),
+			})
+			i = len(areas) - 1
+			index[uuid] = i
+		}
+		areas[i].nd += num(r["nd_percent"])
+		areas[i].brands++
+	}
+	for i := range areas {
+		if areas[i].brands > 0 {
+			areas[i].nd /= float64(areas[i].brands)
+		}
+	}
+	sort.SliceStable(areas, func(i, j int) bool { return areas[i].nd > areas[j].nd })
+	return areas
+}
@@ -0,0 +1,103 @@
+package dashboard
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestExecutivePeriod(t *testing.T) {
+	type period struct {
+		Start, End, Label string
+		Err               bool
+	}
+	var got period
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		start, end, label, err := executivePeriod(c)
+		got = period{start.Format("2006-01-02"), end.Format("2006-01-02"), label, err != nil}
+		return nil
+	})
+
+	lastMonth := time.Date(time.Now().Year(), time.Now().Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]period{
+		"?month=2024-02": {"2024-02-01", "2024-02-29", "Février 2024", false},
+		"?month=2026-12": {"2026-12-01", "2026-12-31", "Décembre 2026", false},
+		"?start_date=2026-03-05&end_date=2026-03-20T00:00:00Z": {"2026-03-05", "2026-03-20", "05/03/2026 – 20/03/2026", false},
+		// A period needs both dates
+		"?start_date=2026-03-05": {
+			lastMonth.Format("2006-01-02"),
+			lastMonth.AddDate(0, 1, -1).Format("2006-01-02"),
+			frenchMonths[lastMonth.Month()-1] + " " + lastMonth.Format("2006"),
+			false,
+		},
+		"?month=02-2026": {Err: true},
+	}
+	for query, want := range tests {
+		if _, err := app.Test(httptest.NewRequest("GET", "/"+query, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if want.Err {
+			if !got.Err {
+				t.Errorf("%s: no error", query)
+			}
+			continue
+		}
+		if got != want {
+			t.Errorf("%s = %+v, want %+v", query, got, want)
+		}
+	}
+}
+
+func TestTrendSeries(t *testing.T) {
+	labels := []string{"2026-01", "2026-02", "2026-03"}
+	rows := []metricRow{
+		{"month": "2026-01", "brand_name": "Alpha", "nd": 30.0},
+		{"month": "2026-03", "brand_name": "Alpha", "nd": 20.0},
+		{"month": "2026-02", "brand_name": "Beta", "nd": 50.0},
+		{"month": "2026-03", "brand_name": "Beta", "nd": 40.0},
+		{"month": "2026-03", "brand_name": "Gamma", "nd": 20.0},
+		{"month": "2025-12", "brand_name": "Delta", "nd": 90.0}, // Before the trend
+	}
+	got := trendSeries(rows, labels, "nd", 2)
+	// The two highest on the last month, Alpha before Gamma on a tie
+	want := []utils.PDFSeries{
+		{Name: "Beta", Values: []float64{0, 50, 40}},
+		{Name: "Alpha", Values: []float64{30, 0, 20}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trendSeries = %+v, want %+v", got, want)
+	}
+}
+
+func TestRankAreas(t *testing.T) {
+	rows := []metricRow{
+		{"territory_uuid": "a1", "territory_name": "Gombe", "total_pos": int64(40), "universe_pos": int64(50), "reach_rate": 80.0, "nd_percent": 30.0},
+		{"territory_uuid": "a1", "territory_name": "Gombe", "nd_percent": 50.0},
+		{"territory_uuid": "a2", "territory_name": "Limete", "total_pos": int64(10), "universe_pos": int64(40), "reach_rate": 25.0, "nd_percent": int64(60)},
+	}
+	got := rankAreas(rows)
+	want := []areaScore{
+		{name: "Limete", visited: 10, universe: 40, reach: 25, nd: 60, brands: 1},
+		{name: "Gombe", visited: 40, universe: 50, reach: 80, nd: 40, brands: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rankAreas = %+v, want %+v", got, want)
+	}
+}
+
+func TestExportExecutivePDFWithoutCountry(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", ExportExecutivePDF)
+	resp, err := app.Test(httptest.NewRequest("GET", "/?month=2026-02", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("status %d, want 400", resp.StatusCode)
+	}
+}
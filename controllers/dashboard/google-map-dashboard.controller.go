@@ -13,6 +13,7 @@ func GoogleMaps(c *fiber.Ctx) error {
 	search := c.Query("search")               // recherche par nom d'utilisateur
 	province_uuid := c.Query("province_uuid") // filtre par province (menu déroulant)
 	user_type := c.Query("user_type")         // filtre par type : asm | supervisor | dr | cyclo
+	geofence := c.Query("geofence_status")    // filtre geofence : inside | outside | unknown
 
 	var results []struct {
 		Latitude     float64 `json:"latitude"`  // Latitude du marqueur
@@ -29,6 +30,11 @@ func GoogleMaps(c *fiber.Ctx) error {
 		ProvinceUUID string  `json:"province_uuid"` // UUID de la province
 		ProvinceName string  `json:"province_name"` // Nom de la province
 		CreatedAt    string  `json:"created_at"`    // Date de création du formulaire
+
+		PosLatitude    float64  `json:"pos_latitude"`    // Position de référence du POS
+		PosLongitude   float64  `json:"pos_longitude"`   // Position de référence du POS
+		DistanceToPos  *float64 `json:"distance_to_pos"` // Distance en mètres entre la visite et le POS
+		GeofenceStatus string   `json:"geofence_status"` // inside | outside | unknown
 	}
 
 	query := db.Table("pos_forms").
@@ -44,6 +50,10 @@ func GoogleMaps(c *fiber.Ctx) error {
 			pos.name AS pos_name,
 			pos.uuid AS pos_uuid,
 			pos.postype AS postype,
+			pos.latitude AS pos_latitude,
+			pos.longitude AS pos_longitude,
+			pos_forms.distance_to_pos AS distance_to_pos,
+			pos_forms.geofence_status AS geofence_status,
 			CASE 
 				WHEN pos_forms.signature = pos_forms.asm THEN ''
 				ELSE pos_forms.asm 
@@ -79,6 +89,11 @@ func GoogleMaps(c *fiber.Ctx) error {
 		query = query.Where("pos_forms.province_uuid = ?", province_uuid)
 	}
 
+	// Filtre geofence : visites loin du POS
+	if geofence != "" {
+		query = query.Where("pos_forms.geofence_status = ?", geofence)
+	}
+
 	// Barre de recherche : filtre par nom d'utilisateur (tous rôles confondus)
 	if search != "" {
 		query = query.Where(`
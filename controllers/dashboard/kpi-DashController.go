@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -42,7 +44,8 @@ func TotalVisitsByCountry(c *fiber.Ctx) error {
 		Title        string  `json:"title"`
 		TotalVisits  int     `json:"total_visits"`
 		Objectif     float64 `json:"objectif"`
-		Target       int     `json:"target"`
+		Target       float64 `json:"target"`
+		UserUUID     string  `json:"user_uuid"`
 	}
 
 	query := db.Table("pos_forms").
@@ -52,25 +55,8 @@ func TotalVisitsByCountry(c *fiber.Ctx) error {
 		users.fullname AS signature,
 		users.title AS title, 
 		COUNT(pos_forms.uuid) AS total_visits,
-		(ROUND((COUNT(pos_forms.uuid) / (
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) ::numeric) * 100, 2)) AS objectif,
-		(
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) AS target
-		`, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date).
+		users.uuid AS user_uuid
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN countries ON countries.uuid = pos_forms.country_uuid").
 		Where("pos_forms.country_uuid = ?", country_uuid)
@@ -109,6 +95,23 @@ func TotalVisitsByCountry(c *fiber.Ctx) error {
 		})
 	}
 
+	userUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		userUUIDs = append(userUUIDs, r.UserUUID)
+	}
+	target, err := utils.AgentTargets(db, models.TargetVisits, userUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].Target = math.Round(target(results[i].UserUUID, "")*100) / 100
+		results[i].Objectif = utils.Achievement(float64(results[i].TotalVisits), results[i].Target)
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "chartData data",
@@ -141,7 +144,8 @@ func TotalVisitsByProvince(c *fiber.Ctx) error {
 		Title        string  `json:"title"`
 		TotalVisits  int     `json:"total_visits"`
 		Objectif     float64 `json:"objectif"`
-		Target       int     `json:"target"`
+		Target       float64 `json:"target"`
+		UserUUID     string  `json:"user_uuid"`
 	}
 
 	query := db.Table("pos_forms").
@@ -155,25 +159,8 @@ func TotalVisitsByProvince(c *fiber.Ctx) error {
 		users.fullname AS signature,
 		users.title AS title,
 		COUNT(pos_forms.uuid) AS total_visits,
-		(ROUND((COUNT(pos_forms.uuid) / (
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) ::numeric) * 100, 2)) AS objectif,
-		(
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) AS target
-		`, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date).
+		users.uuid AS user_uuid
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN provinces ON provinces.uuid = pos_forms.province_uuid").
 		Where("pos_forms.country_uuid = ? AND pos_forms.province_uuid = ?", country_uuid, province_uuid)
@@ -218,6 +205,23 @@ func TotalVisitsByProvince(c *fiber.Ctx) error {
 		})
 	}
 
+	userUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		userUUIDs = append(userUUIDs, r.UserUUID)
+	}
+	target, err := utils.AgentTargets(db, models.TargetVisits, userUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].Target = math.Round(target(results[i].UserUUID, "")*100) / 100
+		results[i].Objectif = utils.Achievement(float64(results[i].TotalVisits), results[i].Target)
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "chartData data",
@@ -246,7 +250,8 @@ func TotalVisitsByArea(c *fiber.Ctx) error {
 		Title       string  `json:"title"`
 		TotalVisits int     `json:"total_visits"`
 		Objectif    float64 `json:"objectif"`
-		Target      int     `json:"target"`
+		Target      float64 `json:"target"`
+		UserUUID    string  `json:"user_uuid"`
 	}
 
 	query := db.Table("pos_forms").
@@ -256,25 +261,8 @@ func TotalVisitsByArea(c *fiber.Ctx) error {
 		users.fullname AS signature,
 		users.title AS title, 
 		COUNT(pos_forms.uuid) AS total_visits,
-		(ROUND((COUNT(pos_forms.uuid) / (
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) ::numeric) * 100, 2)) AS objectif,
-		(
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) AS target
-		`, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date).
+		users.uuid AS user_uuid
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN areas ON pos_forms.area_uuid = areas.uuid").
 		Where("pos_forms.country_uuid = ? AND pos_forms.province_uuid = ?", country_uuid, province_uuid)
@@ -309,6 +297,23 @@ func TotalVisitsByArea(c *fiber.Ctx) error {
 		})
 	}
 
+	userUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		userUUIDs = append(userUUIDs, r.UserUUID)
+	}
+	target, err := utils.AgentTargets(db, models.TargetVisits, userUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].Target = math.Round(target(results[i].UserUUID, "")*100) / 100
+		results[i].Objectif = utils.Achievement(float64(results[i].TotalVisits), results[i].Target)
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "chartData data",
@@ -337,7 +342,8 @@ func TotalVisitsBySubArea(c *fiber.Ctx) error {
 		Title       string  `json:"title"`
 		TotalVisits int     `json:"total_visits"`
 		Objectif    float64 `json:"objectif"`
-		Target      int     `json:"target"`
+		Target      float64 `json:"target"`
+		UserUUID    string  `json:"user_uuid"`
 	}
 
 	query := db.Table("pos_forms").
@@ -347,25 +353,8 @@ func TotalVisitsBySubArea(c *fiber.Ctx) error {
 		users.fullname AS signature,
 		users.title AS title, 
 		COUNT(pos_forms.uuid) AS total_visits,
-		(ROUND((COUNT(pos_forms.uuid) / (
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) ::numeric) * 100, 2)) AS objectif,
-		(
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) AS target
-		`, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date).
+		users.uuid AS user_uuid
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN sub_areas ON pos_forms.sub_area_uuid = sub_areas.uuid").
 		Where("pos_forms.country_uuid = ? AND pos_forms.province_uuid = ? AND pos_forms.area_uuid = ?", country_uuid, province_uuid, area_uuid)
@@ -397,6 +386,23 @@ func TotalVisitsBySubArea(c *fiber.Ctx) error {
 		})
 	}
 
+	userUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		userUUIDs = append(userUUIDs, r.UserUUID)
+	}
+	target, err := utils.AgentTargets(db, models.TargetVisits, userUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].Target = math.Round(target(results[i].UserUUID, "")*100) / 100
+		results[i].Objectif = utils.Achievement(float64(results[i].TotalVisits), results[i].Target)
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "chartData data",
@@ -424,7 +430,8 @@ func TotalVisitsByCommune(c *fiber.Ctx) error {
 		Title       string  `json:"title"`
 		TotalVisits int     `json:"total_visits"`
 		Objectif    float64 `json:"objectif"`
-		Target      int     `json:"target"`
+		Target      float64 `json:"target"`
+		UserUUID    string  `json:"user_uuid"`
 	}
 
 	query := db.Table("pos_forms").
@@ -434,25 +441,8 @@ func TotalVisitsByCommune(c *fiber.Ctx) error {
 		users.fullname AS signature,
 		users.title AS title, 
 		COUNT(pos_forms.uuid) AS total_visits,
-		(ROUND((COUNT(pos_forms.uuid) / (
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) ::numeric) * 100, 2)) AS objectif,
-		(
-			CASE
-					WHEN users.title = 'ASM'  THEN 10 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Supervisor'  THEN 20 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'DR'   THEN 40 * ((?::date - ?::date) + 1)
-					WHEN users.title = 'Cyclo' THEN 40 * ((?::date - ?::date) + 1)
-					ELSE 1 
-			END
-		) AS target
-		`, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date).
+		users.uuid AS user_uuid
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN communes ON pos_forms.commune_uuid = communes.uuid").
 		Where("pos_forms.country_uuid = ? AND pos_forms.province_uuid = ? AND pos_forms.area_uuid = ? AND pos_forms.sub_area_uuid = ?", country_uuid, province_uuid, area_uuid, sub_area_uuid)
@@ -478,6 +468,23 @@ func TotalVisitsByCommune(c *fiber.Ctx) error {
 		})
 	}
 
+	userUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		userUUIDs = append(userUUIDs, r.UserUUID)
+	}
+	target, err := utils.AgentTargets(db, models.TargetVisits, userUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].Target = math.Round(target(results[i].UserUUID, "")*100) / 100
+		results[i].Objectif = utils.Achievement(float64(results[i].TotalVisits), results[i].Target)
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "chartData data",
@@ -504,17 +511,20 @@ func KpiUserVisitSummary(c *fiber.Ctx) error {
 		Name          string  `json:"name"`
 		Title         string  `json:"title"`
 		DailyVisits   int     `json:"daily_visits"`
-		DailyTarget   int     `json:"daily_target"`
+		DailyTarget   float64 `json:"daily_target"`
 		DailyPct      float64 `json:"daily_pct"`
 		MonthlyVisits int     `json:"monthly_visits"`
-		MonthlyTarget int     `json:"monthly_target"`
+		MonthlyTarget float64 `json:"monthly_target"`
 		MonthlyPct    float64 `json:"monthly_pct"`
 		YearlyVisits  int     `json:"yearly_visits"`
-		YearlyTarget  int     `json:"yearly_target"`
+		YearlyTarget  float64 `json:"yearly_target"`
 		YearlyPct     float64 `json:"yearly_pct"`
 		TotalVisits   int     `json:"total_visits"`
-		RangeTarget   int     `json:"range_target"`
+		RangeTarget   float64 `json:"range_target"`
 		RangePct      float64 `json:"range_pct"`
+
+		// Time on site over the selected range
+		visitTiming `gorm:"-"`
 	}
 
 	query := db.Table("pos_forms").
@@ -524,82 +534,13 @@ func KpiUserVisitSummary(c *fiber.Ctx) error {
 			users.title    AS title,
 			COUNT(pos_forms.uuid) FILTER (WHERE DATE(pos_forms.created_at) = CURRENT_DATE)
 				AS daily_visits,
-			CASE
-				WHEN users.title = 'ASM'        THEN 10
-				WHEN users.title = 'Supervisor' THEN 20
-				WHEN users.title IN ('DR','Cyclo') THEN 40
-				ELSE 0
-			END AS daily_target,
-			ROUND(
-				COUNT(pos_forms.uuid) FILTER (WHERE DATE(pos_forms.created_at) = CURRENT_DATE)::numeric
-				/ NULLIF(CASE WHEN users.title = 'ASM' THEN 10
-				              WHEN users.title = 'Supervisor' THEN 20
-				              WHEN users.title IN ('DR','Cyclo') THEN 40
-				              ELSE 1 END, 0) * 100
-			, 2) AS daily_pct,
 			COUNT(pos_forms.uuid) FILTER (WHERE DATE_TRUNC('month', pos_forms.created_at) = DATE_TRUNC('month', CURRENT_DATE))
 				AS monthly_visits,
-			(CASE
-				WHEN users.title = 'ASM'          THEN 10
-				WHEN users.title = 'Supervisor'   THEN 20
-				WHEN users.title IN ('DR','Cyclo') THEN 40
-				ELSE 0
-			END * EXTRACT(DAY FROM (DATE_TRUNC('month', CURRENT_DATE) + INTERVAL '1 month - 1 day'))::int)
-				AS monthly_target,
-			ROUND(
-				COUNT(pos_forms.uuid) FILTER (WHERE DATE_TRUNC('month', pos_forms.created_at) = DATE_TRUNC('month', CURRENT_DATE))::numeric
-				/ NULLIF(
-					(CASE WHEN users.title = 'ASM' THEN 10
-					      WHEN users.title = 'Supervisor' THEN 20
-					      WHEN users.title IN ('DR','Cyclo') THEN 40
-					      ELSE 1 END)
-					* EXTRACT(DAY FROM (DATE_TRUNC('month', CURRENT_DATE) + INTERVAL '1 month - 1 day'))
-				, 0) * 100
-			, 2) AS monthly_pct,
 			COUNT(pos_forms.uuid) FILTER (WHERE DATE_TRUNC('year', pos_forms.created_at) = DATE_TRUNC('year', CURRENT_DATE))
 				AS yearly_visits,
-			(CASE
-				WHEN users.title = 'ASM'          THEN 10
-				WHEN users.title = 'Supervisor'   THEN 20
-				WHEN users.title IN ('DR','Cyclo') THEN 40
-				ELSE 0
-			END * EXTRACT(DOY FROM (DATE_TRUNC('year', CURRENT_DATE) + INTERVAL '1 year - 1 day'))::int)
-				AS yearly_target,
-			ROUND(
-				COUNT(pos_forms.uuid) FILTER (WHERE DATE_TRUNC('year', pos_forms.created_at) = DATE_TRUNC('year', CURRENT_DATE))::numeric
-				/ NULLIF(
-					(CASE WHEN users.title = 'ASM' THEN 10
-					      WHEN users.title = 'Supervisor' THEN 20
-					      WHEN users.title IN ('DR','Cyclo') THEN 40
-					      ELSE 1 END)
-					* EXTRACT(DOY FROM (DATE_TRUNC('year', CURRENT_DATE) + INTERVAL '1 year - 1 day'))
-				, 0) * 100
-			, 2) AS yearly_pct,
 			COUNT(pos_forms.uuid) FILTER (WHERE pos_forms.created_at BETWEEN ?::date AND ?::date)
-				AS total_visits,
-			(CASE
-				WHEN users.title = 'ASM'          THEN 10
-				WHEN users.title = 'Supervisor'   THEN 20
-				WHEN users.title IN ('DR','Cyclo') THEN 40
-				ELSE 0
-			END * ((?::date - ?::date) + 1))
-				AS range_target,
-			ROUND(
-				COUNT(pos_forms.uuid) FILTER (WHERE pos_forms.created_at BETWEEN ?::date AND ?::date)::numeric
-				/ NULLIF(
-					(CASE WHEN users.title = 'ASM' THEN 10
-					      WHEN users.title = 'Supervisor' THEN 20
-					      WHEN users.title IN ('DR','Cyclo') THEN 40
-					      ELSE 1 END)
-					* ((?::date - ?::date) + 1)
-				, 0) * 100
-			, 2) AS range_pct
-		`,
-			start_date, end_date,
-			end_date, start_date,
-			start_date, end_date,
-			end_date, start_date,
-		).
+				AS total_visits
+		`, start_date, end_date).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Where("pos_forms.country_uuid = ?", country_uuid).
 		Where("pos_forms.deleted_at IS NULL")
@@ -636,6 +577,57 @@ func KpiUserVisitSummary(c *fiber.Ctx) error {
 		})
 	}
 
+	userUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		userUUIDs = append(userUUIDs, r.UserUUID)
+	}
+	timing, err := agentVisitTiming(db, userUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch visit timing",
+			"error":   err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].visitTiming = timing[results[i].UserUUID]
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	yearStart := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	var daily, monthly, yearly map[string]float64
+	var ranged func(userUUID, brandUUID string) float64
+	daily, err = utils.UserTargets(db, models.TargetVisits, "", userUUIDs, now, now)
+	if err == nil {
+		monthly, err = utils.UserTargets(db, models.TargetVisits, "", userUUIDs, monthStart, monthStart.AddDate(0, 1, -1))
+	}
+	if err == nil {
+		yearly, err = utils.UserTargets(db, models.TargetVisits, "", userUUIDs, yearStart, yearStart.AddDate(1, 0, -1))
+	}
+	if err == nil {
+		ranged, err = utils.AgentTargets(db, models.TargetVisits, userUUIDs, start_date, end_date)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	for i := range results {
+		r := &results[i]
+		r.DailyTarget = round(daily[r.UserUUID])
+		r.DailyPct = utils.Achievement(float64(r.DailyVisits), r.DailyTarget)
+		r.MonthlyTarget = round(monthly[r.UserUUID])
+		r.MonthlyPct = utils.Achievement(float64(r.MonthlyVisits), r.MonthlyTarget)
+		r.YearlyTarget = round(yearly[r.UserUUID])
+		r.YearlyPct = utils.Achievement(float64(r.YearlyVisits), r.YearlyTarget)
+		r.RangeTarget = round(ranged(r.UserUUID, ""))
+		r.RangePct = utils.Achievement(float64(r.TotalVisits), r.RangeTarget)
+	}
+
 	return c.JSON(fiber.Map{
 		"status":  "success",
 		"message": "KPI user visit summary",
@@ -833,6 +825,8 @@ func GetAgentPerformanceDetails(c *fiber.Ctx) error {
 		SyncRate         float64 `json:"sync_rate"`
 		CommunesCovered  int64   `json:"communes_covered"`
 		PerformanceScore float64 `json:"performance_score"`
+
+		visitTiming `gorm:"-"`
 	}
 
 	var summary AgentSummary
@@ -841,20 +835,39 @@ func GetAgentPerformanceDetails(c *fiber.Ctx) error {
 		Joins("LEFT JOIN users u ON pf.user_uuid = u.uuid").
 		Where("pf.user_uuid = ? AND pf.created_at BETWEEN ? AND ?", agentUUID, start, end).
 		Select(`
-			u.uuid,
-			u.fullname,
-			u.title,
-			COUNT(DISTINCT pf.uuid),
-			COUNT(DISTINCT pf.pos_uuid),
-			COUNT(DISTINCT DATE(pf.created_at)),
-			ROUND(COUNT(DISTINCT pf.uuid)::FLOAT / NULLIF(COUNT(DISTINCT DATE(pf.created_at)), 0), 2),
-			COUNT(CASE WHEN pf.sync = false THEN 1 END),
-			ROUND(100.0 * COUNT(CASE WHEN pf.sync = true THEN 1 ELSE 0 END) / NULLIF(COUNT(*), 0), 2),
-			COUNT(DISTINCT pf.commune_uuid)
+			u.uuid AS agent_uuid,
+			u.fullname AS agent_name,
+			u.title AS agent_title,
+			COUNT(DISTINCT pf.uuid) AS total_visits,
+			COUNT(DISTINCT pf.pos_uuid) AS unique_pos_visited,
+			COUNT(DISTINCT DATE(pf.created_at)) AS active_days,
+			ROUND((COUNT(DISTINCT pf.uuid)::FLOAT / NULLIF(COUNT(DISTINCT DATE(pf.created_at)), 0))::numeric, 2) AS avg_visits_per_day,
+			COUNT(CASE WHEN pf.sync = false THEN 1 END) AS unsynced_forms,
+			ROUND(100.0 * COUNT(CASE WHEN pf.sync = true THEN 1 ELSE 0 END) / NULLIF(COUNT(*), 0), 2) AS sync_rate,
+			COUNT(DISTINCT pf.commune_uuid) AS communes_covered
 		`).
+		Group("u.uuid, u.fullname, u.title").
 		Scan(&summary)
 
-	summary.PerformanceScore = (summary.AvgVisitsPerDay/10)*40 + (summary.SyncRate * 0.3) + (float64(summary.CommunesCovered) / 10)
+	timing, err := agentVisitTiming(db, []string{agentUUID}, start, end)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Error: %v", err),
+		})
+	}
+	summary.visitTiming = timing[agentUUID]
+
+	targets, err := utils.UserTargets(db, models.TargetVisits, "", []string{agentUUID}, start, end)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Error: %v", err),
+		})
+	}
+	visitScore := 0.0
+	if dailyTarget := targets[agentUUID] / (end.Sub(start).Hours()/24 + 1); dailyTarget > 0 {
+		visitScore = (summary.AvgVisitsPerDay / dailyTarget) * 40
+	}
+	summary.PerformanceScore = visitScore + (summary.SyncRate * 0.3) + (float64(summary.CommunesCovered) / 10)
 
 	response := fiber.Map{
 		"agent_summary": summary,
@@ -871,22 +884,34 @@ func GetAgentPerformanceDetails(c *fiber.Ctx) error {
 			POSCount        int64   `json:"pos_count"`
 			Synced          int64   `json:"synced"`
 			POSMMPercentage float64 `json:"posmm_percentage"`
+
+			dailyVisitTiming `gorm:"-"`
 		}
 
 		var dailyData []DailyBreakdown
 		db.Table("pos_forms pf").
 			Where("pf.user_uuid = ? AND pf.created_at BETWEEN ? AND ?", agentUUID, start, end).
 			Select(`
-				DATE(pf.created_at),
-				COUNT(DISTINCT pf.uuid),
-				COUNT(DISTINCT pf.pos_uuid),
-				COUNT(DISTINCT CASE WHEN pf.sync = true THEN pf.uuid END),
-				ROUND(AVG(CASE WHEN pf.price > 0 THEN 100 ELSE 0 END), 2)
+				TO_CHAR(DATE(pf.created_at), 'YYYY-MM-DD') AS date_key,
+				COUNT(DISTINCT pf.uuid) AS visits,
+				COUNT(DISTINCT pf.pos_uuid) AS pos_count,
+				COUNT(DISTINCT CASE WHEN pf.sync = true THEN pf.uuid END) AS synced,
+				ROUND(AVG(CASE WHEN pf.price > 0 THEN 100 ELSE 0 END), 2) AS posmm_percentage
 			`).
 			Group("DATE(pf.created_at)").
 			Order("DATE(pf.created_at) DESC").
 			Scan(&dailyData)
 
+		daily, err := agentDailyVisitTiming(db, agentUUID, start, end)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": fmt.Sprintf("Error: %v", err),
+			})
+		}
+		for i := range dailyData {
+			dailyData[i].dailyVisitTiming = daily[dailyData[i].DateKey]
+		}
+
 		response["daily_breakdown"] = dailyData
 	}
 
@@ -1032,27 +1057,62 @@ func GetKPITargetVsActual(c *fiber.Ctx) error {
 		nameCol = "a.name"
 	}
 
+	var visits []struct {
+		TerritoryUUID string
+		Territory     string
+		UserUUID      string
+		Visits        int64
+	}
 	query := db.Table("pos_forms pf").
-		Joins("LEFT JOIN users u ON pf.user_uuid = u.uuid").
 		Joins("LEFT JOIN areas a ON pf.area_uuid = a.uuid").
 		Joins("LEFT JOIN provinces pr ON pf.province_uuid = pr.uuid").
 		Where("pf.created_at BETWEEN ? AND ?", start, end).
 		Where("pf.deleted_at IS NULL").
 		Select(fmt.Sprintf(`
-			%s,
-			COUNT(DISTINCT pf.uuid),
-			CAST(10 * (EXTRACT(DAY FROM ?::timestamp - ?::timestamp) + 1) AS BIGINT),
-			ROUND(100.0 * COUNT(DISTINCT pf.uuid) / NULLIF(10 * (EXTRACT(DAY FROM ?::timestamp - ?::timestamp) + 1), 0), 2)
-		`, nameCol),
-			end, start, end, start).
-		Group(groupCol + ", " + nameCol)
+			%s AS territory_uuid,
+			%s AS territory,
+			pf.user_uuid,
+			COUNT(DISTINCT pf.uuid) AS visits
+		`, groupCol, nameCol)).
+		Group(groupCol + ", " + nameCol + ", pf.user_uuid").
+		Order(nameCol)
+
+	if err := query.Scan(&visits).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": fmt.Sprintf("Error: %v", err),
+		})
+	}
 
-	if err := query.Scan(&results).Error; err != nil {
+	userUUIDs := make([]string, 0, len(visits))
+	for _, v := range visits {
+		userUUIDs = append(userUUIDs, v.UserUUID)
+	}
+	targets, err := utils.UserTargets(db, models.TargetVisits, "", userUUIDs, start, end)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Error: %v", err),
 		})
 	}
 
+	// The target of a territory is the sum of the targets of its agents
+	index := map[string]int{}
+	userTargets := [][]float64{}
+	for _, v := range visits {
+		i, ok := index[v.TerritoryUUID]
+		if !ok {
+			i = len(results)
+			index[v.TerritoryUUID] = i
+			results = append(results, TargetAnalysis{Territory: v.Territory})
+			userTargets = append(userTargets, nil)
+		}
+		results[i].ActualVisits += v.Visits
+		userTargets[i] = append(userTargets[i], targets[v.UserUUID])
+	}
+	for i := range results {
+		results[i].TargetVisits = int64(math.Round(utils.TerritoryTarget(models.TargetVisits, userTargets[i])))
+		results[i].AchievementPercentage = utils.Achievement(float64(results[i].ActualVisits), float64(results[i].TargetVisits))
+	}
+
 	for i := range results {
 		if results[i].AchievementPercentage >= 100 {
 			results[i].Status = "✅ ON_TRACK"
@@ -1077,33 +1137,33 @@ func GetKPITargetVsActual(c *fiber.Ctx) error {
 	})
 }
 
-// GetTeamAbsenceAnalysis - Inactive Agents
-func GetTeamAbsenceAnalysis(c *fiber.Ctx) error {
-	db := database.DB
-
-	daysInactive := c.Query("days_inactive", "7")
-	daysInt, _ := strconv.Atoi(daysInactive)
-	inactiveThreshold := time.Now().AddDate(0, 0, -daysInt)
+// AbsenceAlert is an agent without a visit for some days.
+type AbsenceAlert struct {
+	AgentUUID    string     `json:"agent_uuid"`
+	AgentName    string     `json:"agent_name"`
+	AgentTitle   string     `json:"agent_title"`
+	LastVisitAt  *time.Time `json:"last_visit_at"`
+	DaysInactive int64      `json:"days_inactive"`
+	AlertLevel   string     `json:"alert_level"`
+}
 
-	type AbsenceAlert struct {
-		AgentUUID    string `json:"agent_uuid"`
-		AgentName    string `json:"agent_name"`
-		AgentTitle   string `json:"agent_title"`
-		DaysInactive int64  `json:"days_inactive"`
-		AlertLevel   string `json:"alert_level"`
-	}
+// InactiveAgents lists the active field agents without a visit for more
+// than days days, the longest inactive first.
+func InactiveAgents(days int) ([]AbsenceAlert, error) {
+	inactiveThreshold := time.Now().AddDate(0, 0, -days)
 
 	var results []AbsenceAlert
 
-	query := db.Table("users u").
+	query := database.DB.Table("users u").
 		Joins("LEFT JOIN pos_forms pf ON u.uuid = pf.user_uuid").
 		Where("u.title IN ?", []string{"ASM", "Supervisor", "DR", "Cyclo", "Agent"}).
 		Where("u.status = true").
 		Having("MAX(pf.created_at) < ? OR MAX(pf.created_at) IS NULL", inactiveThreshold).
 		Select(`
-			u.uuid        AS agent_uuid,
-			u.fullname    AS agent_name,
-			u.title       AS agent_title,
+			u.uuid          AS agent_uuid,
+			u.fullname      AS agent_name,
+			u.title         AS agent_title,
+			MAX(pf.created_at) AS last_visit_at,
 			COALESCE(ROUND(EXTRACT(EPOCH FROM (NOW() - MAX(pf.created_at))) / 86400)::BIGINT, ?) AS days_inactive,
 			CASE
 				WHEN MAX(pf.created_at) IS NULL THEN '🔴 CRITICAL'
@@ -1111,11 +1171,21 @@ func GetTeamAbsenceAnalysis(c *fiber.Ctx) error {
 				WHEN ROUND(EXTRACT(EPOCH FROM (NOW() - MAX(pf.created_at))) / 86400) > 7  THEN '🟡 WARNING'
 				ELSE '🟢 OK'
 			END AS alert_level
-		`, daysInt).
+		`, days).
 		Group("u.uuid, u.fullname, u.title").
 		Order("days_inactive DESC")
 
-	if err := query.Scan(&results).Error; err != nil {
+	err := query.Scan(&results).Error
+	return results, err
+}
+
+// GetTeamAbsenceAnalysis - Inactive Agents
+func GetTeamAbsenceAnalysis(c *fiber.Ctx) error {
+	daysInactive := c.Query("days_inactive", "7")
+	daysInt, _ := strconv.Atoi(daysInactive)
+
+	results, err := InactiveAgents(daysInt)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": fmt.Sprintf("Error: %v", err),
 		})
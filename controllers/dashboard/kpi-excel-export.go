@@ -3,10 +3,13 @@ package dashboard
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/xuri/excelize/v2"
 )
@@ -15,11 +18,19 @@ import (
 // KPI EXCEL EXPORT — rapport complet multi-onglets
 // GET /dashboard/kpi/export-excel
 // Query params: country_uuid, province_uuid, area_uuid, sub_area_uuid,
-//               commune_uuid, start_date, end_date, title
+//               commune_uuid, start_date, end_date, title, currency
 // ═══════════════════════════════════════════════════════════════════════════
 
 // ExportKPIExcel génère un rapport Excel complet avec tous les KPIs
 func ExportKPIExcel(c *fiber.Ctx) error {
+	return utils.SendReport(c, BuildKPIExcel)
+}
+
+// kpiExcelSheets est le nombre d'onglets du rapport KPI, pour la progression
+const kpiExcelSheets = 7
+
+// BuildKPIExcel construit le rapport de ExportKPIExcel
+func BuildKPIExcel(c *fiber.Ctx, opts utils.ReportOptions) (*excelize.File, string, error) {
 	db := database.DB
 
 	// ── paramètres ────────────────────────────────────────────────────────────
@@ -44,9 +55,14 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 	end, _ := time.Parse("2006-01-02", endDate)
 	days := int(end.Sub(start).Hours()/24) + 1
 
+	// Devise du chiffre d'affaires, celle du pays par défaut
+	currency, err := utils.ResolveCurrency(db, c.Query("currency"), countryUUID)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// ── fichier Excel ─────────────────────────────────────────────────────────
 	f := excelize.NewFile()
-	defer f.Close()
 
 	// ── styles ────────────────────────────────────────────────────────────────
 	titleStyle, _ := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 16, Color: "1F4E79", Family: "Calibri"}, Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center"}, Fill: excelize.Fill{Type: "pattern", Color: []string{"D6E4F0"}, Pattern: 1}})
@@ -92,24 +108,29 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 
 	// KPIs globaux
 	type GlobalKPI struct {
-		TotalVisits  int64
-		TotalAgents  int64
-		TotalPOS     int64
-		UniquePOS    int64
-		SyncRate     float64
-		AvgVisitsDay float64
+		TotalVisits       int64
+		TotalAgents       int64
+		TotalPOS          int64
+		UniquePOS         int64
+		SyncRate          float64
+		AvgVisitsDay      float64
+		TotalRevenue      float64
+		UnconvertedVisits int64
 	}
 
 	var gkpi GlobalKPI
 	gkpiQuery := db.Table("pos_forms pf").
 		Joins("LEFT JOIN users u ON pf.user_uuid = u.uuid").
+		Joins(visitFX, currency).
 		Where("pf.created_at BETWEEN ? AND ?", start, end).
 		Where("pf.deleted_at IS NULL").
 		Select(`
 			COUNT(DISTINCT pf.uuid) AS total_visits,
 			COUNT(DISTINCT pf.user_uuid) AS total_agents,
 			COUNT(DISTINCT pf.pos_uuid) AS unique_pos,
-			ROUND(100.0 * COUNT(CASE WHEN pf.sync = true THEN 1 END) / NULLIF(COUNT(*), 0), 2) AS sync_rate
+			ROUND(100.0 * COUNT(CASE WHEN pf.sync = true THEN 1 END) / NULLIF(COUNT(*), 0), 2) AS sync_rate,
+			ROUND(COALESCE(SUM(pf.price * fx.rate), 0)::numeric, 2) AS total_revenue,
+			COUNT(*) FILTER (WHERE pf.price <> 0 AND fx.rate IS NULL) AS unconverted_visits
 		`)
 
 	if countryUUID != "" {
@@ -143,6 +164,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		{"POS Uniques Visités", gkpi.UniquePOS},
 		{"Taux de Sync (%)", fmt.Sprintf("%.2f%%", gkpi.SyncRate)},
 		{"Moyenne Visites/Jour", fmt.Sprintf("%.2f", gkpi.AvgVisitsDay)},
+		{fmt.Sprintf("Chiffre d'Affaires (%s)", currency), gkpi.TotalRevenue},
+		{"Visites sans Taux de Change", gkpi.UnconvertedVisits},
 		{"Durée (jours)", days},
 	}
 
@@ -158,6 +181,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		row++
 	}
 
+	opts.ReportProgress(1, kpiExcelSheets)
+
 	// ═══════════════════════════════════════════════════════════════════════
 	// ONGLET 2 — Performance par Agent
 	// ═══════════════════════════════════════════════════════════════════════
@@ -218,33 +243,9 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 			sa.name AS sub_area_name,
 			com.name AS commune_name,
 			COUNT(DISTINCT pf.uuid) FILTER (WHERE pf.created_at BETWEEN ?::date AND ?::date) AS total_visits,
-			(CASE
-				WHEN u.title = 'ASM'           THEN 10
-				WHEN u.title = 'Supervisor'    THEN 20
-				WHEN u.title IN ('DR','Cyclo') THEN 40
-				ELSE 0
-			END * (?::int)) AS range_target,
-			ROUND(
-				COUNT(DISTINCT pf.uuid) FILTER (WHERE pf.created_at BETWEEN ?::date AND ?::date)::numeric
-				/ NULLIF(CASE WHEN u.title='ASM' THEN 10 WHEN u.title='Supervisor' THEN 20 WHEN u.title IN('DR','Cyclo') THEN 40 ELSE 1 END * ?::int, 0) * 100
-			, 2) AS range_pct,
 			COUNT(pf.uuid) FILTER (WHERE DATE(pf.created_at) = CURRENT_DATE) AS daily_visits,
-			(CASE WHEN u.title='ASM' THEN 10 WHEN u.title='Supervisor' THEN 20 WHEN u.title IN('DR','Cyclo') THEN 40 ELSE 0 END) AS daily_target,
-			ROUND(
-				COUNT(pf.uuid) FILTER (WHERE DATE(pf.created_at) = CURRENT_DATE)::numeric
-				/ NULLIF(CASE WHEN u.title='ASM' THEN 10 WHEN u.title='Supervisor' THEN 20 WHEN u.title IN('DR','Cyclo') THEN 40 ELSE 1 END, 0) * 100
-			, 2) AS daily_pct,
-			COUNT(pf.uuid) FILTER (WHERE DATE_TRUNC('month', pf.created_at) = DATE_TRUNC('month', CURRENT_DATE)) AS monthly_visits,
-			(CASE WHEN u.title='ASM' THEN 10 WHEN u.title='Supervisor' THEN 20 WHEN u.title IN('DR','Cyclo') THEN 40 ELSE 0 END
-			 * EXTRACT(DAY FROM (DATE_TRUNC('month', CURRENT_DATE) + INTERVAL '1 month - 1 day'))::int) AS monthly_target,
-			ROUND(
-				COUNT(pf.uuid) FILTER (WHERE DATE_TRUNC('month', pf.created_at) = DATE_TRUNC('month', CURRENT_DATE))::numeric
-				/ NULLIF(
-					(CASE WHEN u.title='ASM' THEN 10 WHEN u.title='Supervisor' THEN 20 WHEN u.title IN('DR','Cyclo') THEN 40 ELSE 1 END)
-					* EXTRACT(DAY FROM (DATE_TRUNC('month', CURRENT_DATE) + INTERVAL '1 month - 1 day'))
-				, 0) * 100
-			, 2) AS monthly_pct
-		`, start, end, days, start, end, days)
+			COUNT(pf.uuid) FILTER (WHERE DATE_TRUNC('month', pf.created_at) = DATE_TRUNC('month', CURRENT_DATE)) AS monthly_visits
+		`, start, end)
 
 	if countryUUID != "" {
 		agentQuery = agentQuery.Where("pf.country_uuid = ?", countryUUID)
@@ -269,6 +270,37 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		Order("u.title, u.fullname").
 		Scan(&agentResults)
 
+	// Objectifs de visites de chaque agent sur la période, le jour et le mois
+	agentUUIDs := make([]string, 0, len(agentResults))
+	for _, ar := range agentResults {
+		agentUUIDs = append(agentUUIDs, ar.UserUUID)
+	}
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	rangeTargets, err := utils.UserTargets(db, models.TargetVisits, "", agentUUIDs, start, end)
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Failed to fetch targets", Err: err}
+	}
+	dailyTargets, err := utils.UserTargets(db, models.TargetVisits, "", agentUUIDs, now, now)
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Failed to fetch targets", Err: err}
+	}
+	monthlyTargets, err := utils.UserTargets(db, models.TargetVisits, "", agentUUIDs, monthStart, monthStart.AddDate(0, 1, -1))
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Failed to fetch targets", Err: err}
+	}
+	for i := range agentResults {
+		ar := &agentResults[i]
+		ar.RangeTarget = int64(math.Round(rangeTargets[ar.UserUUID]))
+		ar.RangePct = utils.Achievement(float64(ar.TotalVisits), float64(ar.RangeTarget))
+		ar.DailyTarget = int64(math.Round(dailyTargets[ar.UserUUID]))
+		ar.DailyPct = utils.Achievement(float64(ar.DailyVisits), float64(ar.DailyTarget))
+		ar.MonthlyTarget = int64(math.Round(monthlyTargets[ar.UserUUID]))
+		ar.MonthlyPct = utils.Achievement(float64(ar.MonthlyVisits), float64(ar.MonthlyTarget))
+	}
+
 	var totalVisitsPeriod, totalTarget int64
 	for idx, ar := range agentResults {
 		rowNum := idx + 3
@@ -332,6 +364,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 	}
 	f.SetCellStyle(sheetAgent, fmt.Sprintf("J%d", totalRow), fmt.Sprintf("Q%d", totalRow), styles["boldTotal"])
 
+	opts.ReportProgress(2, kpiExcelSheets)
+
 	// ═══════════════════════════════════════════════════════════════════════
 	// ONGLET 3 — Visites par Territoire
 	// ═══════════════════════════════════════════════════════════════════════
@@ -350,6 +384,7 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 	type TerrRow struct {
 		Name        string `json:"name"`
 		Level       string
+		UserUUID    string  `json:"user_uuid"`
 		Signature   string  `json:"signature"`
 		Title       string  `json:"title"`
 		TotalVisits int     `json:"total_visits"`
@@ -361,22 +396,20 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 
 	// Province
 	var pvRes []struct {
-		Name      string  `json:"name"`
-		Signature string  `json:"signature"`
-		Title     string  `json:"title"`
-		Visits    int     `json:"total_visits"`
-		Target    int     `json:"target"`
-		Objectif  float64 `json:"objectif"`
+		Name      string `json:"name"`
+		UserUUID  string `json:"user_uuid"`
+		Signature string `json:"signature"`
+		Title     string `json:"title"`
+		Visits    int    `json:"total_visits"`
 	}
 	pvQ := db.Table("pos_forms").
 		Select(`
 			provinces.name AS name,
+			users.uuid AS user_uuid,
 			users.fullname AS signature,
 			users.title AS title,
-			COUNT(pos_forms.uuid) AS total_visits,
-			(CASE WHEN users.title='ASM' THEN 10 WHEN users.title='Supervisor' THEN 20 WHEN users.title IN('DR','Cyclo') THEN 40 ELSE 0 END * ?) AS target,
-			ROUND(COUNT(pos_forms.uuid)::numeric / NULLIF((CASE WHEN users.title='ASM' THEN 10 WHEN users.title='Supervisor' THEN 20 WHEN users.title IN('DR','Cyclo') THEN 40 ELSE 1 END * ?), 0) * 100, 2) AS objectif
-		`, days, days).
+			COUNT(pos_forms.uuid) AS total_visits
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN provinces ON provinces.uuid = pos_forms.province_uuid").
 		Where("pos_forms.created_at BETWEEN ? AND ?", start, end).
@@ -391,29 +424,27 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 	if titleFilter != "" {
 		pvQ = pvQ.Where("users.title = ?", titleFilter)
 	}
-	pvQ.Group("provinces.name, users.fullname, users.title").Order("provinces.name, users.fullname").Scan(&pvRes)
+	pvQ.Group("provinces.name, users.uuid, users.fullname, users.title").Order("provinces.name, users.fullname").Scan(&pvRes)
 	for _, r := range pvRes {
-		terrRows = append(terrRows, TerrRow{Name: r.Name, Level: "Province", Signature: r.Signature, Title: r.Title, TotalVisits: r.Visits, Target: r.Target, Objectif: r.Objectif})
+		terrRows = append(terrRows, TerrRow{Name: r.Name, Level: "Province", UserUUID: r.UserUUID, Signature: r.Signature, Title: r.Title, TotalVisits: r.Visits})
 	}
 
 	// Area
 	var arRes []struct {
-		Name      string  `json:"name"`
-		Signature string  `json:"signature"`
-		Title     string  `json:"title"`
-		Visits    int     `json:"total_visits"`
-		Target    int     `json:"target"`
-		Objectif  float64 `json:"objectif"`
+		Name      string `json:"name"`
+		UserUUID  string `json:"user_uuid"`
+		Signature string `json:"signature"`
+		Title     string `json:"title"`
+		Visits    int    `json:"total_visits"`
 	}
 	arQ := db.Table("pos_forms").
 		Select(`
 			areas.name AS name,
+			users.uuid AS user_uuid,
 			users.fullname AS signature,
 			users.title AS title,
-			COUNT(pos_forms.uuid) AS total_visits,
-			(CASE WHEN users.title='ASM' THEN 10 WHEN users.title='Supervisor' THEN 20 WHEN users.title IN('DR','Cyclo') THEN 40 ELSE 0 END * ?) AS target,
-			ROUND(COUNT(pos_forms.uuid)::numeric / NULLIF((CASE WHEN users.title='ASM' THEN 10 WHEN users.title='Supervisor' THEN 20 WHEN users.title IN('DR','Cyclo') THEN 40 ELSE 1 END * ?), 0) * 100, 2) AS objectif
-		`, days, days).
+			COUNT(pos_forms.uuid) AS total_visits
+		`).
 		Joins("JOIN users ON users.uuid = pos_forms.user_uuid").
 		Joins("JOIN areas ON areas.uuid = pos_forms.area_uuid").
 		Where("pos_forms.created_at BETWEEN ? AND ?", start, end).
@@ -428,9 +459,23 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 	if titleFilter != "" {
 		arQ = arQ.Where("users.title = ?", titleFilter)
 	}
-	arQ.Group("areas.name, users.fullname, users.title").Order("areas.name, users.fullname").Scan(&arRes)
+	arQ.Group("areas.name, users.uuid, users.fullname, users.title").Order("areas.name, users.fullname").Scan(&arRes)
 	for _, r := range arRes {
-		terrRows = append(terrRows, TerrRow{Name: r.Name, Level: "Area", Signature: r.Signature, Title: r.Title, TotalVisits: r.Visits, Target: r.Target, Objectif: r.Objectif})
+		terrRows = append(terrRows, TerrRow{Name: r.Name, Level: "Area", UserUUID: r.UserUUID, Signature: r.Signature, Title: r.Title, TotalVisits: r.Visits})
+	}
+
+	terrUUIDs := make([]string, 0, len(terrRows))
+	for _, tr := range terrRows {
+		terrUUIDs = append(terrUUIDs, tr.UserUUID)
+	}
+	terrTargets, err := utils.UserTargets(db, models.TargetVisits, "", terrUUIDs, start, end)
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Failed to fetch targets", Err: err}
+	}
+	for i := range terrRows {
+		terrRows[i].Target = int(math.Round(terrTargets[terrRows[i].UserUUID]))
+		terrRows[i].Objectif = utils.Achievement(float64(terrRows[i].TotalVisits), float64(terrRows[i].Target))
 	}
 
 	for idx, tr := range terrRows {
@@ -458,6 +503,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		f.SetCellStyle(sheetTerr, fmt.Sprintf("I%d", rowNum), fmt.Sprintf("I%d", rowNum), sStyle)
 	}
 
+	opts.ReportProgress(3, kpiExcelSheets)
+
 	// ═══════════════════════════════════════════════════════════════════════
 	// ONGLET 4 — Analyse des Absences
 	// ═══════════════════════════════════════════════════════════════════════
@@ -548,6 +595,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		f.SetCellStyle(sheetAbs, fmt.Sprintf("F%d", rowNum), fmt.Sprintf("F%d", rowNum), alertStyle)
 	}
 
+	opts.ReportProgress(4, kpiExcelSheets)
+
 	// ═══════════════════════════════════════════════════════════════════════
 	// ONGLET 5 — Comparaison Périodique (3 derniers mois)
 	// ═══════════════════════════════════════════════════════════════════════
@@ -627,6 +676,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		_ = ps
 	}
 
+	opts.ReportProgress(5, kpiExcelSheets)
+
 	// ═══════════════════════════════════════════════════════════════════════
 	// ONGLET 6 — Objectifs vs Réalisé par Territoire
 	// ═══════════════════════════════════════════════════════════════════════
@@ -649,21 +700,53 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		Pct       float64 `json:"achievement_percentage"`
 	}
 
-	var targetResults []TargetRow
+	var areaVisits []struct {
+		Territory string
+		UserUUID  string
+		Visits    int64
+	}
 	db.Table("pos_forms pf").
-		Joins("LEFT JOIN users u ON pf.user_uuid = u.uuid").
 		Joins("LEFT JOIN areas a ON pf.area_uuid = a.uuid").
 		Where("pf.created_at BETWEEN ? AND ?", start, end).
 		Where("pf.deleted_at IS NULL").
-		Select(fmt.Sprintf(`
+		Select(`
 			a.name AS territory,
-			COUNT(DISTINCT pf.uuid) AS actual_visits,
-			CAST(40 * %d AS BIGINT) AS target_visits,
-			ROUND(100.0 * COUNT(DISTINCT pf.uuid) / NULLIF(40 * %d, 0), 2) AS achievement_percentage
-		`, days, days)).
-		Group("a.name").
-		Order("achievement_percentage ASC").
-		Scan(&targetResults)
+			pf.user_uuid,
+			COUNT(DISTINCT pf.uuid) AS visits
+		`).
+		Group("a.name, pf.user_uuid").
+		Scan(&areaVisits)
+
+	areaUUIDs := make([]string, 0, len(areaVisits))
+	for _, av := range areaVisits {
+		areaUUIDs = append(areaUUIDs, av.UserUUID)
+	}
+	areaTargets, err := utils.UserTargets(db, models.TargetVisits, "", areaUUIDs, start, end)
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Failed to fetch targets", Err: err}
+	}
+
+	// L'objectif d'une area est la somme des objectifs de ses agents
+	var targetResults []TargetRow
+	areaIndex := map[string]int{}
+	areaUserTargets := [][]float64{}
+	for _, av := range areaVisits {
+		i, ok := areaIndex[av.Territory]
+		if !ok {
+			i = len(targetResults)
+			areaIndex[av.Territory] = i
+			targetResults = append(targetResults, TargetRow{Territory: av.Territory})
+			areaUserTargets = append(areaUserTargets, nil)
+		}
+		targetResults[i].Actual += av.Visits
+		areaUserTargets[i] = append(areaUserTargets[i], areaTargets[av.UserUUID])
+	}
+	for i := range targetResults {
+		targetResults[i].Target = int64(math.Round(utils.TerritoryTarget(models.TargetVisits, areaUserTargets[i])))
+		targetResults[i].Pct = utils.Achievement(float64(targetResults[i].Actual), float64(targetResults[i].Target))
+	}
+	sort.Slice(targetResults, func(i, j int) bool { return targetResults[i].Pct < targetResults[j].Pct })
 
 	for idx, tr := range targetResults {
 		rowNum := idx + 3
@@ -684,6 +767,8 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 		f.SetCellStyle(sheetTarget, fmt.Sprintf("F%d", rowNum), fmt.Sprintf("F%d", rowNum), sStyle)
 	}
 
+	opts.ReportProgress(6, kpiExcelSheets)
+
 	// ═══════════════════════════════════════════════════════════════════════
 	// ONGLET 7 — Détail POS
 	// ═══════════════════════════════════════════════════════════════════════
@@ -795,20 +880,10 @@ func ExportKPIExcel(c *fiber.Ctx) error {
 	summaryIdx, _ := f.GetSheetIndex(sheetSummary)
 	f.SetActiveSheet(summaryIdx)
 
-	// ══ Envoi du fichier ══════════════════════════════════════════════════
+	opts.ReportProgress(kpiExcelSheets, kpiExcelSheets)
+
 	filename := fmt.Sprintf("rapport_kpi_%s_%s.xlsx", startDate, endDate)
-	c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Set("Cache-Control", "no-cache, no-store, must-revalidate")
-
-	if err := f.Write(c.Response().BodyWriter()); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de la génération du fichier Excel",
-			"error":   err.Error(),
-		})
-	}
-	return nil
+	return f, filename, nil
 }
 
 // ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
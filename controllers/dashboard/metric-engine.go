@@ -0,0 +1,744 @@
+package dashboard
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ╔══════════════════════════════════════════════════════════════════════════════╗
+// ║          DISTRIBUTION METRIC ENGINE — ONE DEFINITION PER METRIC             ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  Every distribution metric (ND, SOS, OOS, WD, WS, SISH, …) is declared once ║
+// ║  as a metricDefinition: the SQL aggregate of one (dim × brand) row and the  ║
+// ║  JSON shape of its charts. The engine serves the common views:              ║
+// ║    table-view          — territory × brand rows      (?level=…)             ║
+// ║    bar-chart           — chart shape of the same rows (?level=…)            ║
+// ║    line-chart-by-month — month × brand rows                                 ║
+// ║    heatmap             — brand × territory matrix     (?level=…)            ║
+// ║  Metric specific views (summary, ranking, evolution, …) are plain handlers  ║
+// ║  listed in Views. All of them answer GET /dashboard/:metric/:view.          ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  Aggregates read the daily_facts table (see utils/dailyFacts.go) instead of ║
+// ║  pos_forms × pos_form_items. The distinct POS over the period are counted   ║
+// ║  on daily_pos_facts, as distinct counts do not add up across days.          ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  Catalogue: ?category_uuid, ?manufacturer_uuid and ?ownership=own|competitor ║
+// ║  select the brands; ?group=category|manufacturer|ownership replaces the     ║
+// ║  brands with their groups, read from daily_group_facts. Shares are within   ║
+// ║  the category of the brand, or of the category filter when grouping.        ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  Segment: ?segment=A|B|C keeps the visits made while the POS was in the     ║
+// ║  segment, and the POS universe of the segment now.                          ║
+// ╚══════════════════════════════════════════════════════════════════════════════╝
+
+// metricSegmentFilter keeps the visits pf made while the POS was in the
+// segment.
+var metricSegmentFilter = `(@segment = '' OR ` + utils.PosSegmentAtSQL("pf.pos_uuid", "pf.created_at") + ` = @segment)`
+
+// metricFormFilter is the territory, period and segment filter of
+// pos_forms pf.
+var metricFormFilter = `pf.country_uuid = @country_uuid
+			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
+			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
+			  AND pf.deleted_at IS NULL
+			  AND ` + metricSegmentFilter
+
+// metricFactFilter is the territory, period and segment filter of
+// daily_facts, daily_group_facts and daily_pos_facts f.
+const metricFactFilter = `f.country_uuid = @country_uuid
+			  AND (@province_uuid = '' OR f.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR f.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR f.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR f.commune_uuid  = @commune_uuid)
+			  AND f.day >= CAST(@start_date AS date) AND f.day <= CAST(@end_date AS date)
+			  AND (@segment = '' OR f.segment = @segment)`
+
+// metricPosFilter is the territory and current segment filter of the POS
+// universe p.
+const metricPosFilter = `p.country_uuid = @country_uuid
+			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
+			  AND (@segment = '' OR p.segment = @segment)
+			  AND p.deleted_at IS NULL`
+
+// metricBrandFilter is the catalogue filter of brands b.
+const metricBrandFilter = `(@category_uuid     = '' OR b.category_uuid     = @category_uuid)
+			  AND (@manufacturer_uuid = '' OR b.manufacturer_uuid = @manufacturer_uuid)
+			  AND (@ownership         = '' OR b.competitor = (@ownership = '` + models.OwnershipCompetitor + `'))`
+
+// metricDim is what the rows of an aggregate are grouped on.
+type metricDim struct {
+	Fact  string // Expression over daily_facts f
+	Pos   string // Matching column of pos p, empty when the dim is not a territory
+	Group string // Brand group the rows are of, empty for brands
+}
+
+// metricGroup is a brand group the metrics can be grouped by.
+type metricGroup struct {
+	Key    string // Expression of the group key over brands b
+	Scope  string // Category the shares are computed within
+	Labels string // Table of the group uuid and name
+	Filter string // Filter that cannot be combined with the group
+}
+
+var metricGroups = map[string]metricGroup{
+	models.GroupCategory: {
+		Key:    "COALESCE(b.category_uuid, '')",
+		Scope:  "'" + models.GroupScopeAll + "'",
+		Labels: "(SELECT uuid, name FROM categories WHERE deleted_at IS NULL UNION ALL SELECT '', 'Uncategorised')",
+		Filter: "manufacturer_uuid and ownership",
+	},
+	models.GroupManufacturer: {
+		Key:    "COALESCE(b.manufacturer_uuid, '')",
+		Scope:  "CASE WHEN @category_uuid = '' THEN '" + models.GroupScopeAll + "' ELSE @category_uuid END",
+		Labels: "(SELECT uuid, name FROM manufacturers WHERE deleted_at IS NULL UNION ALL SELECT '', 'No manufacturer')",
+		Filter: "ownership",
+	},
+	models.GroupOwnership: {
+		Key:    "CASE WHEN b.competitor THEN '" + models.OwnershipCompetitor + "' ELSE '" + models.OwnershipOwn + "' END",
+		Scope:  "CASE WHEN @category_uuid = '' THEN '" + models.GroupScopeAll + "' ELSE @category_uuid END",
+		Labels: "(SELECT * FROM (VALUES ('" + models.OwnershipOwn + "', 'Own'), ('" + models.OwnershipCompetitor + "', 'Competitor')) v(uuid, name))",
+		Filter: "manufacturer_uuid",
+	},
+}
+
+// metricRow is one scanned row, keyed by column name.
+type metricRow = map[string]interface{}
+
+// metricShape turns the rows of a view into the JSON the frontend expects.
+type metricShape func(rows []metricRow) interface{}
+
+// metricDefinition declares a distribution metric.
+//
+// Aggregate returns a query with one row per (dim × brand) and the columns
+// dim, brand_uuid, Counts and Amounts. It may use the named params of
+// metricParams, the filters above and the CTEs of metricFacts.
+type metricDefinition struct {
+	Key       string // Route segment, e.g. numeric-distribution
+	Label     string // Message prefix, e.g. ND
+	Aggregate func(d metricDim) string
+	Counts    []string // Integer columns
+	Amounts   []string // Decimal columns
+	Sort      string   // Column the rows are ranked on within a territory or month
+	Bar       metricShape
+	Line      metricShape
+	Heatmap   string // Column of the heatmap cells, empty when Views has its own heatmap
+	Views     map[string]fiber.Handler
+}
+
+type metricLevel struct {
+	Column string
+	Table  string
+	Title  string
+}
+
+var metricLevels = map[string]metricLevel{
+	"province": {"province_uuid", "provinces", "Province"},
+	"area":     {"area_uuid", "areas", "Area"},
+	"subarea":  {"sub_area_uuid", "sub_areas", "SubArea"},
+	"commune":  {"commune_uuid", "communes", "Commune"},
+}
+
+// metricDefinitions holds the metrics served by MetricView, by route key.
+var metricDefinitions = map[string]*metricDefinition{
+	ndMetric.Key:   &ndMetric,
+	sosMetric.Key:  &sosMetric,
+	oosMetric.Key:  &oosMetric,
+	wdMetric.Key:   &wdMetric,
+	wsMetric.Key:   &wsMetric,
+	sishMetric.Key: &sishMetric,
+}
+
+// MetricView serves any view of any metric.
+//
+// GET /api/dashboard/:metric/:view?level=province|area|subarea|commune
+func MetricView(c *fiber.Ctx) error {
+	m, ok := metricDefinitions[c.Params("metric")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status": "error", "message": "Unknown metric " + c.Params("metric"),
+		})
+	}
+	return m.serve(c, c.Params("view"), c.Query("level", "province"))
+}
+
+// metricParams reads the common query params. Returns nil when required
+// params are missing. end_date is inclusive of the whole day.
+func metricParams(c *fiber.Ctx) map[string]interface{} {
+	country_uuid := c.Query("country_uuid")
+	start_date := c.Query("start_date")
+	end_date := c.Query("end_date")
+	if country_uuid == "" || start_date == "" || end_date == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"country_uuid":  country_uuid,
+		"province_uuid": c.Query("province_uuid"),
+		"area_uuid":     c.Query("area_uuid"),
+		"sub_area_uuid": c.Query("sub_area_uuid"),
+		"commune_uuid":  c.Query("commune_uuid"),
+		"brand_uuid":    c.Query("brand_uuid"),
+		"start_date":    start_date,
+		"end_date":      end_date + " 23:59:59",
+
+		"category_uuid":     c.Query("category_uuid"),
+		"manufacturer_uuid": c.Query("manufacturer_uuid"),
+		"ownership":         c.Query("ownership"),
+		"group":             c.Query("group"),
+
+		"segment": segmentQuery(c),
+	}
+}
+
+// segmentQuery reads the ?segment=A|B|C param.
+func segmentQuery(c *fiber.Ctx) string {
+	return strings.ToUpper(strings.TrimSpace(c.Query("segment")))
+}
+
+// validSegment reports whether segment is empty or a POS segment.
+func validSegment(segment interface{}) bool {
+	switch segment {
+	case nil, "", models.SegmentA, models.SegmentB, models.SegmentC:
+		return true
+	}
+	return false
+}
+
+// catalogueError checks the ownership, group and segment params, returning
+// the message of the first invalid one.
+func catalogueError(params map[string]interface{}) string {
+	if !validSegment(params["segment"]) {
+		return "invalid segment; use A|B|C"
+	}
+	switch params["ownership"] {
+	case "", models.OwnershipOwn, models.OwnershipCompetitor:
+	default:
+		return "invalid ownership; use own|competitor"
+	}
+	group, _ := params["group"].(string)
+	if group == "" {
+		return ""
+	}
+	g, ok := metricGroups[group]
+	if !ok {
+		return "invalid group; use category|manufacturer|ownership"
+	}
+	for _, key := range strings.Split(g.Filter, " and ") {
+		if params[key] != "" {
+			return "group=" + group + " cannot be combined with " + g.Filter
+		}
+	}
+	return ""
+}
+
+// catalogueParams returns a copy of params with the catalogue and segment
+// params callers may leave out. brand_uuid does not apply to groups.
+func catalogueParams(params map[string]interface{}) (map[string]interface{}, string) {
+	out := make(map[string]interface{}, len(params)+5)
+	for k, v := range params {
+		out[k] = v
+	}
+	for _, key := range []string{"category_uuid", "manufacturer_uuid", "ownership", "group", "segment"} {
+		if _, ok := out[key]; !ok {
+			out[key] = ""
+		}
+	}
+	group, _ := out["group"].(string)
+	if group != "" {
+		out["brand_uuid"] = ""
+	}
+	return out, group
+}
+
+// metricLabels is the table the uuid and name of the rows of group are
+// read from.
+func metricLabels(group string) string {
+	if g, ok := metricGroups[group]; ok {
+		return g.Labels
+	}
+	return "brands"
+}
+
+func (m *metricDefinition) serve(c *fiber.Ctx, view, level string) error {
+	if h, ok := m.Views[view]; ok {
+		return h(c)
+	}
+
+	switch view {
+	case "table-view", "bar-chart", "line-chart-by-month":
+	case "heatmap":
+		if m.Heatmap != "" {
+			break
+		}
+		fallthrough
+	default:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status": "error", "message": "Unknown " + m.Label + " view " + view,
+		})
+	}
+
+	params := metricParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "country_uuid, start_date and end_date are required",
+		})
+	}
+	if msg := catalogueError(params); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
+	}
+	group := params["group"]
+
+	if view == "line-chart-by-month" {
+		rows, err := m.monthRows(params)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status": "error", "message": "Failed to fetch " + m.Label + " monthly trend", "error": err.Error(),
+			})
+		}
+		return c.JSON(fiber.Map{"status": "success", "message": m.Label + " Monthly Trend by Brand", "group": group, "data": m.Line(rows)})
+	}
+
+	lv, ok := metricLevels[level]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "invalid level; use province|area|subarea|commune",
+		})
+	}
+	rows, err := m.territoryRows(level, lv, params)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to fetch " + m.Label + " " + level + " " + view, "error": err.Error(),
+		})
+	}
+
+	switch view {
+	case "bar-chart":
+		return c.JSON(fiber.Map{"status": "success", "message": m.Label + " Bar Chart — " + lv.Title, "level": level, "group": group, "data": m.Bar(rows)})
+	case "heatmap":
+		return c.JSON(fiber.Map{"status": "success", "message": m.Label + " Heatmap — Brand × Territory", "level": level, "group": group, "data": metricHeatmap(rows, m.Heatmap)})
+	}
+	return c.JSON(fiber.Map{"status": "success", "message": m.Label + " " + lv.Title + " Table", "level": level, "group": group, "data": rows})
+}
+
+// metricFacts opens the WITH clause of an aggregate with the CTEs:
+//
+//	visited     — dim, total_pos: distinct POS visited
+//	keys        — brand_uuid, category_uuid: the brands, or groups of
+//	              d.Group, selected by the catalogue filter
+//	totals      — dim, category_uuid, visits, fardes, sold: visits of all
+//	              brands, fardes and sold of the brands of the category
+//	brand_facts — dim, brand_uuid, category_uuid, lines, pos_count, fardes,
+//	              sold, brand_present, fardes_share, basket_sold
+//
+// lines counts the brand lines of the visits and pos_count the distinct
+// POS having one, counted on daily_pos_facts as the daily counts do not
+// add up over several days. When grouping, brand_uuid is the group key and
+// category_uuid the category the shares are within, '*' for all.
+func metricFacts(d metricDim) string {
+	keys := `SELECT b.uuid AS brand_uuid, COALESCE(b.category_uuid, '') AS category_uuid
+			FROM brands b
+			WHERE ` + metricBrandFilter
+	key, scope := "f.brand_uuid", "COALESCE(b.category_uuid, '')"
+	facts := `
+				` + d.Fact + ` AS dim,
+				f.brand_uuid,
+				COALESCE(b.category_uuid, '') AS category_uuid,
+				SUM(f.visits)        AS lines,
+				SUM(f.fardes)        AS fardes,
+				SUM(f.sold)          AS sold,
+				SUM(f.brand_present) AS brand_present,
+				SUM(f.fardes_share)  AS fardes_share,
+				SUM(f.basket_sold)   AS basket_sold
+			FROM daily_facts f
+			LEFT JOIN brands b ON b.uuid = f.brand_uuid
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid <> ''`
+	if g, ok := metricGroups[d.Group]; ok {
+		keys = `SELECT DISTINCT ` + g.Key + ` AS brand_uuid, ` + g.Scope + ` AS category_uuid
+			FROM brands b
+			WHERE ` + metricBrandFilter
+		key, scope = g.Key, g.Scope
+		facts = `
+				` + d.Fact + ` AS dim,
+				f.group_key    AS brand_uuid,
+				f.category_uuid,
+				SUM(f.visits)        AS lines,
+				SUM(f.fardes)        AS fardes,
+				SUM(f.sold)          AS sold,
+				SUM(f.brand_present) AS brand_present,
+				SUM(f.fardes_share)  AS fardes_share,
+				SUM(f.basket_sold)   AS basket_sold
+			FROM daily_group_facts f
+			WHERE ` + metricFactFilter + `
+			  AND f.group_by = '` + d.Group + `'
+			  AND f.category_uuid = ` + g.Scope
+	}
+
+	return `
+		WITH visited AS (
+			SELECT ` + d.Fact + ` AS dim, COUNT(DISTINCT f.pos_uuid) AS total_pos
+			FROM daily_pos_facts f
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid = ''
+			GROUP BY 1
+		),
+		visit_totals AS (
+			SELECT ` + d.Fact + ` AS dim, SUM(f.visits) AS visits
+			FROM daily_facts f
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid = ''
+			GROUP BY 1
+		),
+		keys AS (
+			` + keys + `
+		),
+		facts AS (
+			SELECT` + facts + `
+			GROUP BY 1, 2, 3
+		),
+		brand_pos AS (
+			SELECT
+				` + d.Fact + ` AS dim,
+				` + key + ` AS brand_uuid,
+				` + scope + ` AS category_uuid,
+				COUNT(DISTINCT f.pos_uuid) AS pos_count
+			FROM daily_pos_facts f
+			LEFT JOIN brands b ON b.uuid = f.brand_uuid
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid <> ''
+			  AND (` + scope + ` = '` + models.GroupScopeAll + `' OR COALESCE(b.category_uuid, '') = ` + scope + `)
+			GROUP BY 1, 2, 3
+		),
+		totals AS (
+			SELECT ft.dim, ft.category_uuid, vt.visits, ft.fardes, ft.sold
+			FROM (
+				SELECT dim, category_uuid, SUM(fardes) AS fardes, SUM(sold) AS sold
+				FROM facts
+				GROUP BY 1, 2
+			) ft
+			LEFT JOIN visit_totals vt ON vt.dim = ft.dim
+		),
+		brand_facts AS (
+			SELECT fa.*, COALESCE(bp.pos_count, 0) AS pos_count
+			FROM facts fa
+			INNER JOIN keys k ON k.brand_uuid = fa.brand_uuid AND k.category_uuid = fa.category_uuid
+			LEFT JOIN brand_pos bp ON bp.dim = fa.dim AND bp.brand_uuid = fa.brand_uuid AND bp.category_uuid = fa.category_uuid
+		)`
+}
+
+// selectColumns selects the metric columns of m, NULL read as 0.
+func (m *metricDefinition) selectColumns() string {
+	s := ""
+	for _, col := range m.Counts {
+		s += ",\n\t\t\tCOALESCE(m." + col + ", 0)::bigint AS " + col
+	}
+	for _, col := range m.Amounts {
+		s += ",\n\t\t\tCOALESCE(m." + col + ", 0)::float8 AS " + col
+	}
+	return s
+}
+
+// territoryRows returns one row per territory of the level × brand, or
+// brand group of params["group"].
+func (m *metricDefinition) territoryRows(level string, lv metricLevel, params map[string]interface{}) ([]metricRow, error) {
+	params, group := catalogueParams(params)
+	query := `
+		WITH m AS (` + m.Aggregate(metricDim{Fact: "f." + lv.Column, Pos: "p." + lv.Column, Group: group}) + `
+		)
+		SELECT
+			t.name        AS territory_name,
+			t.uuid        AS territory_uuid,
+			'` + level + `' AS territory_level,
+			b.name        AS brand_name,
+			b.uuid        AS brand_uuid` + m.selectColumns() + `
+		FROM m
+		INNER JOIN ` + lv.Table + ` t ON t.uuid = m.dim
+		INNER JOIN ` + metricLabels(group) + ` b ON b.uuid = m.brand_uuid
+		ORDER BY t.name, ` + m.Sort + ` DESC
+	`
+	rows := []metricRow{}
+	err := database.DB.Raw(query, params).Scan(&rows).Error
+	return rows, err
+}
+
+// monthRows returns one row per month (YYYY-MM) × brand, or brand group
+// of params["group"].
+func (m *metricDefinition) monthRows(params map[string]interface{}) ([]metricRow, error) {
+	params, group := catalogueParams(params)
+	query := `
+		WITH m AS (` + m.Aggregate(metricDim{Fact: "TO_CHAR(f.day, 'YYYY-MM')", Group: group}) + `
+		)
+		SELECT
+			m.dim  AS month,
+			b.name AS brand_name,
+			b.uuid AS brand_uuid` + m.selectColumns() + `
+		FROM m
+		INNER JOIN ` + metricLabels(group) + ` b ON b.uuid = m.brand_uuid
+		ORDER BY month, ` + m.Sort + ` DESC
+	`
+	rows := []metricRow{}
+	err := database.DB.Raw(query, params).Scan(&rows).Error
+	return rows, err
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// METRIC SPECIFIC VIEWS
+// Summary, ranking, gap and evolution views select from the aggregate of
+// their metric over the whole territory and period, or over two periods.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// metricTotal is the dim of the aggregates over the whole territory and
+// period: one row per brand.
+const metricTotal = "CAST('' AS text)"
+
+// metricPeriods is the dim of the evolution views over the params of
+// periodParams: 'current' from @period_start, 'previous' before.
+const metricPeriods = "CASE WHEN f.day >= CAST(@period_start AS date) THEN 'current' ELSE 'previous' END"
+
+// metricVisits is the CTE visits: total_pos, the distinct POS visited, and
+// visits over the whole territory and period, counted when no brand has a
+// row too.
+const metricVisits = `visits AS (
+			SELECT COUNT(DISTINCT f.pos_uuid) AS total_pos, COALESCE(SUM(f.visits), 0)::bigint AS visits
+			FROM daily_pos_facts f
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid = ''
+		)`
+
+// metricPosDays is the CTE pos_days of the POS drill-downs: one row per
+// day and POS visited, with the visits and measures over all brands and
+// those of the brand @brand_uuid, 0 when it had no line.
+const metricPosDays = `pos_days AS (
+			SELECT
+				f.day,
+				f.pos_uuid,
+				SUM(f.visits)::bigint                       AS visits,
+				SUM(f.fardes)                               AS fardes,
+				SUM(f.sold)                                 AS sold,
+				SUM(f.revenue)                              AS revenue,
+				COALESCE(SUM(fb.visits), 0)::bigint         AS brand_visits,
+				COALESCE(SUM(fb.brand_present), 0)::bigint  AS brand_present,
+				COALESCE(SUM(fb.fardes), 0)                 AS brand_fardes,
+				COALESCE(SUM(fb.sold), 0)                   AS brand_sold
+			FROM daily_pos_facts f
+			LEFT JOIN daily_pos_facts fb
+			       ON fb.day = f.day AND fb.pos_uuid = f.pos_uuid
+			      AND fb.user_uuid = f.user_uuid AND fb.brand_uuid = @brand_uuid
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid = ''
+			GROUP BY f.day, f.pos_uuid
+		)`
+
+// viewParams reads the params of a metric specific view, catalogue params
+// included, and the brand group. It returns the message of the bad request
+// when they are missing or invalid.
+func viewParams(c *fiber.Ctx) (map[string]interface{}, string, string) {
+	params := metricParams(c)
+	if params == nil {
+		return nil, "", "country_uuid, start_date and end_date are required"
+	}
+	if msg := catalogueError(params); msg != "" {
+		return nil, "", msg
+	}
+	params, group := catalogueParams(params)
+	return params, group, ""
+}
+
+// periodParams returns a copy of params spanning the window before
+// start_date of the same length and the period itself, which starts at
+// period_start, for the dim metricPeriods.
+func periodParams(params map[string]interface{}) (map[string]interface{}, error) {
+	const dateFmt = "2006-01-02"
+	start, _ := params["start_date"].(string)
+	end, _ := params["end_date"].(string)
+	startDate, err := time.Parse(dateFmt, start)
+	if err != nil {
+		return nil, err
+	}
+	if len(end) > len(dateFmt) {
+		end = end[:len(dateFmt)]
+	}
+	endDate, err := time.Parse(dateFmt, end)
+	if err != nil {
+		return nil, err
+	}
+
+	days := int(endDate.Sub(startDate).Hours()/24) + 1
+	out := make(map[string]interface{}, len(params)+1)
+	for k, v := range params {
+		out[k] = v
+	}
+	out["period_start"] = start
+	out["start_date"] = startDate.AddDate(0, 0, -days).Format(dateFmt)
+	return out, nil
+}
+
+// metricCTE returns the CTE m: the aggregate over d with the name of each
+// brand, or group, as brand_name. The handlers of Views pass the Aggregate
+// of their metric, as the metric itself refers to them.
+func metricCTE(aggregate func(d metricDim) string, d metricDim) string {
+	return `m AS (
+			SELECT a.*, b.name AS brand_name
+			FROM (` + aggregate(d) + `
+			) a
+			INNER JOIN ` + metricLabels(d.Group) + ` b ON b.uuid = a.brand_uuid
+		)`
+}
+
+// metricEvolution returns the CTE evolution over the rows of the CTE from,
+// which has the columns dim over metricPeriods, brand_name and brand_uuid:
+// one row per brand with current_<col> and previous_<col> of each of cols,
+// 0 when the brand has no row in the period, and of each of totals, the
+// same for every brand of the period.
+func metricEvolution(from string, cols, totals []string) string {
+	s := `evolution AS (
+			SELECT brand_name, brand_uuid`
+	for _, period := range []string{"current", "previous"} {
+		for _, col := range cols {
+			s += ",\n\t\t\t\tCOALESCE(MAX(" + col + ") FILTER (WHERE dim = '" + period + "'), 0) AS " + period + "_" + col
+		}
+		for _, col := range totals {
+			s += ",\n\t\t\t\tCOALESCE(MAX(MAX(" + col + ") FILTER (WHERE dim = '" + period + "')) OVER (), 0) AS " + period + "_" + col
+		}
+	}
+	return s + `
+			FROM ` + from + `
+			GROUP BY brand_name, brand_uuid
+		)`
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// SHAPES
+// ─────────────────────────────────────────────────────────────────────────────
+
+func pick(r metricRow, fields []string) metricRow {
+	out := make(metricRow, len(fields))
+	for _, f := range fields {
+		out[f] = r[f]
+	}
+	return out
+}
+
+// flatRows keeps the given fields of each row.
+func flatRows(fields ...string) metricShape {
+	return func(rows []metricRow) interface{} {
+		out := make([]metricRow, 0, len(rows))
+		for _, r := range rows {
+			out = append(out, pick(r, fields))
+		}
+		return out
+	}
+}
+
+// territoryGroups nests the brands of each territory under "brands". The
+// group fields are read from the first row of the territory.
+func territoryGroups(groupFields, brandFields []string) metricShape {
+	return func(rows []metricRow) interface{} {
+		groups := []metricRow{}
+		index := map[interface{}]int{}
+		for _, r := range rows {
+			i, ok := index[r["territory_uuid"]]
+			if !ok {
+				g := pick(r, groupFields)
+				g["brands"] = []metricRow{}
+				groups = append(groups, g)
+				i = len(groups) - 1
+				index[r["territory_uuid"]] = i
+			}
+			groups[i]["brands"] = append(groups[i]["brands"].([]metricRow), pick(r, brandFields))
+		}
+		return groups
+	}
+}
+
+// brandSeries makes one series per brand with its points under key.
+func brandSeries(key string, pointFields ...string) metricShape {
+	return func(rows []metricRow) interface{} {
+		series := []metricRow{}
+		index := map[interface{}]int{}
+		for _, r := range rows {
+			i, ok := index[r["brand_uuid"]]
+			if !ok {
+				series = append(series, metricRow{
+					"brand_name": r["brand_name"],
+					"brand_uuid": r["brand_uuid"],
+					key:          []metricRow{},
+				})
+				i = len(series) - 1
+				index[r["brand_uuid"]] = i
+			}
+			series[i][key] = append(series[i][key].([]metricRow), pick(r, pointFields))
+		}
+		return series
+	}
+}
+
+// withAxis adds the distinct values of field, in order of appearance, next
+// to the series: {name: [...], "series": [...]}.
+func withAxis(name, field string, shape metricShape) metricShape {
+	return func(rows []metricRow) interface{} {
+		axis := []interface{}{}
+		seen := map[interface{}]bool{}
+		for _, r := range rows {
+			if !seen[r[field]] {
+				seen[r[field]] = true
+				axis = append(axis, r[field])
+			}
+		}
+		return fiber.Map{name: axis, "series": shape(rows)}
+	}
+}
+
+// metricHeatmap pivots territory rows into a brand × territory matrix of
+// the value column: matrix[brandIndex][territoryIndex].
+func metricHeatmap(rows []metricRow, value string) fiber.Map {
+	brands := []map[string]string{}
+	territories := []map[string]string{}
+	brandIndex := map[string]int{}
+	terrIndex := map[string]int{}
+
+	for _, r := range rows {
+		b, _ := r["brand_uuid"].(string)
+		if _, ok := brandIndex[b]; !ok {
+			brandIndex[b] = len(brands)
+			name, _ := r["brand_name"].(string)
+			brands = append(brands, map[string]string{"uuid": b, "name": name})
+		}
+		t, _ := r["territory_uuid"].(string)
+		if _, ok := terrIndex[t]; !ok {
+			terrIndex[t] = len(territories)
+			name, _ := r["territory_name"].(string)
+			territories = append(territories, map[string]string{"uuid": t, "name": name})
+		}
+	}
+
+	// Brands in alphabetical order, the matrix rows follow
+	sort.SliceStable(brands, func(i, j int) bool { return brands[i]["name"] < brands[j]["name"] })
+	for i, b := range brands {
+		brandIndex[b["uuid"]] = i
+	}
+
+	matrix := make([][]float64, len(brands))
+	for i := range matrix {
+		matrix[i] = make([]float64, len(territories))
+	}
+	for _, r := range rows {
+		v, _ := r[value].(float64)
+		b, _ := r["brand_uuid"].(string)
+		t, _ := r["territory_uuid"].(string)
+		matrix[brandIndex[b]][terrIndex[t]] = v
+	}
+
+	return fiber.Map{"brands": brands, "territories": territories, "matrix": matrix}
+}
@@ -0,0 +1,229 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The aggregates of every metric, grouped by territory, month or brand
+// group, read the facts only.
+func TestMetricAggregatesReadFacts(t *testing.T) {
+	dims := map[string]metricDim{
+		"province":     {Fact: "f.province_uuid", Pos: "p.province_uuid"},
+		"month":        {Fact: "TO_CHAR(f.day, 'YYYY-MM')"},
+		"category":     {Fact: "f.province_uuid", Pos: "p.province_uuid", Group: models.GroupCategory},
+		"manufacturer": {Fact: "TO_CHAR(f.day, 'YYYY-MM')", Group: models.GroupManufacturer},
+	}
+	for key, m := range metricDefinitions {
+		for name, d := range dims {
+			sql := m.Aggregate(d)
+			for _, table := range []string{"pos_forms", "pos_form_items"} {
+				if strings.Contains(sql, table) {
+					t.Errorf("%s by %s reads %s", key, name, table)
+				}
+			}
+			if !strings.Contains(sql, "FROM daily_pos_facts f") {
+				t.Errorf("%s by %s does not count the POS on daily_pos_facts", key, name)
+			}
+		}
+	}
+}
+
+// Every label table can be joined under the alias b.
+func TestMetricLabelsAreSubqueries(t *testing.T) {
+	for _, group := range []string{"", models.GroupCategory, models.GroupManufacturer, models.GroupOwnership} {
+		labels := metricLabels(group)
+		if labels == "brands" {
+			continue
+		}
+		if !strings.HasPrefix(labels, "(SELECT ") || !strings.HasSuffix(labels, ")") {
+			t.Errorf("labels of %q = %s, want a subquery to alias", group, labels)
+		}
+	}
+}
+
+func TestMetricShapes(t *testing.T) {
+	rows := []metricRow{
+		{"territory_uuid": "t1", "territory_name": "Kinshasa", "brand_uuid": "b2", "brand_name": "Zeta", "nd": 40.0},
+		{"territory_uuid": "t1", "territory_name": "Kinshasa", "brand_uuid": "b1", "brand_name": "Alpha", "nd": 25.0},
+		{"territory_uuid": "t2", "territory_name": "Kongo", "brand_uuid": "b2", "brand_name": "Zeta", "nd": 10.0},
+	}
+
+	t.Run("flatRows", func(t *testing.T) {
+		got := flatRows("brand_name", "nd")(rows)
+		want := []metricRow{
+			{"brand_name": "Zeta", "nd": 40.0},
+			{"brand_name": "Alpha", "nd": 25.0},
+			{"brand_name": "Zeta", "nd": 10.0},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("territoryGroups", func(t *testing.T) {
+		got := territoryGroups([]string{"territory_name"}, []string{"brand_name", "nd"})(rows)
+		want := []metricRow{
+			{"territory_name": "Kinshasa", "brands": []metricRow{
+				{"brand_name": "Zeta", "nd": 40.0},
+				{"brand_name": "Alpha", "nd": 25.0},
+			}},
+			{"territory_name": "Kongo", "brands": []metricRow{
+				{"brand_name": "Zeta", "nd": 10.0},
+			}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("brandSeries with axis", func(t *testing.T) {
+		got := withAxis("territories", "territory_name", brandSeries("points", "territory_name", "nd"))(rows)
+		want := fiber.Map{
+			"territories": []interface{}{"Kinshasa", "Kongo"},
+			"series": []metricRow{
+				{"brand_name": "Zeta", "brand_uuid": "b2", "points": []metricRow{
+					{"territory_name": "Kinshasa", "nd": 40.0},
+					{"territory_name": "Kongo", "nd": 10.0},
+				}},
+				{"brand_name": "Alpha", "brand_uuid": "b1", "points": []metricRow{
+					{"territory_name": "Kinshasa", "nd": 25.0},
+				}},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("metricHeatmap", func(t *testing.T) {
+		got := metricHeatmap(rows, "nd")
+		want := fiber.Map{
+			"brands": []map[string]string{
+				{"uuid": "b1", "name": "Alpha"},
+				{"uuid": "b2", "name": "Zeta"},
+			},
+			"territories": []map[string]string{
+				{"uuid": "t1", "name": "Kinshasa"},
+				{"uuid": "t2", "name": "Kongo"},
+			},
+			// Alpha has no row in Kongo
+			"matrix": [][]float64{{25, 0}, {40, 10}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestPeriodParams(t *testing.T) {
+	params := map[string]interface{}{"country_uuid": "c1", "start_date": "2026-03-01", "end_date": "2026-03-31 23:59:59"}
+	got, err := periodParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// March has 31 days: the previous window starts 31 days earlier
+	if got["start_date"] != "2026-01-29" || got["period_start"] != "2026-03-01" || got["end_date"] != "2026-03-31 23:59:59" {
+		t.Errorf("got %v", got)
+	}
+	if params["start_date"] != "2026-03-01" {
+		t.Errorf("params modified: start_date = %v", params["start_date"])
+	}
+	if _, err := periodParams(map[string]interface{}{"start_date": "01/03/2026", "end_date": "2026-03-31"}); err == nil {
+		t.Error("no error on a bad start_date")
+	}
+}
+
+// The requests the engine refuses are answered before any query.
+func TestMetricViewBadRequests(t *testing.T) {
+	app := fiber.New()
+	app.Get("/dashboard/:metric/:view", MetricView)
+
+	const period = "country_uuid=c1&start_date=2026-03-01&end_date=2026-03-31"
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/dashboard/unknown/table-view?" + period, fiber.StatusNotFound},
+		{"/dashboard/numeric-distribution/pie-chart?" + period, fiber.StatusNotFound},
+		{"/dashboard/numeric-distribution/table-view?country_uuid=c1", fiber.StatusBadRequest},
+		{"/dashboard/numeric-distribution/table-view?" + period + "&level=village", fiber.StatusBadRequest},
+		{"/dashboard/numeric-distribution/bar-chart?" + period + "&segment=D", fiber.StatusBadRequest},
+		{"/dashboard/numeric-distribution/bar-chart?" + period + "&ownership=partner", fiber.StatusBadRequest},
+		{"/dashboard/numeric-distribution/bar-chart?" + period + "&group=category&ownership=own", fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", tt.target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct{ Status, Message string }
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status || body.Status != "error" || body.Message == "" {
+			t.Errorf("%s: %d %+v, want %d with an error message", tt.target, resp.StatusCode, body, tt.status)
+		}
+	}
+}
+
+func TestCatalogueError(t *testing.T) {
+	with := func(kv ...string) map[string]interface{} {
+		params := map[string]interface{}{"category_uuid": "", "manufacturer_uuid": "", "ownership": "", "group": "", "segment": ""}
+		for i := 0; i < len(kv); i += 2 {
+			params[kv[i]] = kv[i+1]
+		}
+		return params
+	}
+	for want, params := range map[string]map[string]interface{}{
+		"":                                      with("category_uuid", "beer", "manufacturer_uuid", "bralima", "ownership", models.OwnershipOwn),
+		"invalid ownership; use own|competitor": with("ownership", "Own"),
+		"invalid group; use category|manufacturer|ownership":                     with("group", "brand"),
+		"group=category cannot be combined with manufacturer_uuid and ownership": with("group", models.GroupCategory, "ownership", models.OwnershipCompetitor),
+		"group=manufacturer cannot be combined with ownership":                   with("group", models.GroupManufacturer, "ownership", models.OwnershipOwn),
+		"group=ownership cannot be combined with manufacturer_uuid":              with("group", models.GroupOwnership, "manufacturer_uuid", "bralima"),
+	} {
+		if got := catalogueError(params); got != want {
+			t.Errorf("catalogueError(%v) = %q, want %q", params, got, want)
+		}
+	}
+
+	// A group keeps the filters it is computed within
+	for group, params := range map[string]map[string]interface{}{
+		models.GroupCategory:     with("group", models.GroupCategory),
+		models.GroupManufacturer: with("group", models.GroupManufacturer, "category_uuid", "beer", "manufacturer_uuid", "bralima"),
+		models.GroupOwnership:    with("group", models.GroupOwnership, "category_uuid", "beer", "ownership", models.OwnershipOwn),
+	} {
+		if got := catalogueError(params); got != "" {
+			t.Errorf("group=%s refused with %q", group, got)
+		}
+	}
+}
+
+func TestCatalogueParams(t *testing.T) {
+	params := map[string]interface{}{"country_uuid": "c1", "brand_uuid": "primus", "category_uuid": "beer"}
+
+	got, group := catalogueParams(params)
+	want := map[string]interface{}{
+		"country_uuid": "c1", "brand_uuid": "primus", "category_uuid": "beer",
+		"manufacturer_uuid": "", "ownership": "", "group": "", "segment": "",
+	}
+	if group != "" || !reflect.DeepEqual(got, want) {
+		t.Errorf("without group: %v, %q; want %v", got, group, want)
+	}
+
+	params["group"] = models.GroupManufacturer
+	got, group = catalogueParams(params)
+	if group != models.GroupManufacturer || got["brand_uuid"] != "" || got["category_uuid"] != "beer" {
+		t.Errorf("by manufacturer: %v, %q; want the brand dropped and the category kept", got, group)
+	}
+	if params["brand_uuid"] != "primus" || len(params) != 4 {
+		t.Errorf("params modified: %v", params)
+	}
+}
@@ -1,12 +1,8 @@
 ﻿package dashboard
 
 import (
-	"math"
-	"time"
-
 	"github.com/danny19977/mspos-api-v3/database"
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 )
 
 // ╔══════════════════════════════════════════════════════════════════════════════╗
@@ -23,778 +19,105 @@ import (
 // ║  SECTION 5 — ADVANCED       : Brand×Territory Heatmap / Period Evolution     ║
 // ╚══════════════════════════════════════════════════════════════════════════════╝
 
-// runRaw executes a raw named-param query inside a transaction that minimises
-// shared-memory usage, preventing SQLSTATE 53100 on containers with small
-// /dev/shm (e.g. Render.com, Docker with default shm_size).
-//   - work_mem '64kB'     — PostgreSQL minimum; forces spill-to-disk over
-//     large in-memory hash/sort buffers.
-//   - enable_hashagg off  — replaces HashAggregate with GroupAggregate (no
-//     shared-memory hash table).
-//   - enable_hashjoin off — replaces HashJoin with MergeJoin/NestLoop.
-func runRaw(db *gorm.DB, query string, params map[string]interface{}, dest interface{}) error {
-	return db.Transaction(func(tx *gorm.DB) error {
-		stmts := []string{
-			"SET LOCAL work_mem = '64kB'",
-			"SET LOCAL enable_hashagg = off",
-			"SET LOCAL enable_hashjoin = off",
-		}
-		for _, s := range stmts {
-			if err := tx.Exec(s).Error; err != nil {
-				return err
-			}
-		}
-		return tx.Raw(query, params).Scan(dest).Error
-	})
-}
-
 // ─────────────────────────────────────────────────────────────────────────────
-// SECTION 1 — TABLE VIEWS
+// SECTIONS 1–3 — TABLE VIEWS / BAR CHARTS / MONTHLY TREND (metric engine)
 // Each row = (territory × brand) with:
 //   nd_pos       — COUNT(DISTINCT pos_form_items.uuid) per territory/brand
 //   total_pos    — total distinct POS visited (any brand)
 //   nd_percent   — nd_pos / total_posforms × 100
 //   universe_pos — total registered POS in the territory
 //   reach_rate   — total_pos / universe_pos × 100
+// Every brand selected is listed for every territory, with 0 when it was not found.
+// The monthly trend has no POS universe and only lists brands found.
 // ─────────────────────────────────────────────────────────────────────────────
 
-// NDTableViewProvince — ND breakdown per brand at Province level
-func NDTableViewProvince(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH visited AS (
-			SELECT
-				pf.province_uuid,
-				COUNT(DISTINCT pf.pos_uuid) AS total_pos,
-				COUNT(DISTINCT pf.uuid)     AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY pf.province_uuid
-		),
-		universe AS (
-			SELECT p.province_uuid, COUNT(p.uuid) AS universe_pos
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND p.deleted_at IS NULL
-			GROUP BY p.province_uuid
-		),
-		nd_counts AS (
-			SELECT
-				pf.province_uuid,
-				pfi.brand_uuid,
-				COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.province_uuid, pfi.brand_uuid
-		)
-		SELECT
-			pr.name                                                          AS territory_name,
-			pr.uuid                                                          AS territory_uuid,
-			'province'                                                       AS territory_level,
-			b.name                                                           AS brand_name,
-			b.uuid                                                           AS brand_uuid,
-			COALESCE(nd.nd_pos, 0)                                           AS nd_pos,
-			COALESCE(v.total_pos, 0)                                         AS total_pos,
-			COALESCE(u.universe_pos, 0)                                      AS universe_pos,
-			ROUND((COALESCE(nd.nd_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(v.total_posforms, 0), 0))::numeric, 2)   AS nd_percent,
-			ROUND((COALESCE(v.total_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)     AS reach_rate
-		FROM universe u
-		INNER JOIN provinces pr ON pr.uuid = u.province_uuid
-		CROSS JOIN brands b
-		LEFT  JOIN nd_counts nd ON nd.province_uuid = u.province_uuid AND nd.brand_uuid = b.uuid
-		LEFT  JOIN visited v    ON v.province_uuid  = u.province_uuid
-		ORDER BY pr.name, nd_percent DESC
-	`
-
-	type NDRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		UniversePos    int64   `json:"universe_pos"`
-		NdPercent      float64 `json:"nd_percent"`
-		ReachRate      float64 `json:"reach_rate"`
-	}
-
-	var results []NDRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND province data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Province Table", "data": results})
+var ndMetric = metricDefinition{
+	Key:       "numeric-distribution",
+	Label:     "ND",
+	Aggregate: ndAggregate,
+	Counts:    []string{"nd_pos", "total_pos", "universe_pos"},
+	Amounts:   []string{"nd_percent", "reach_rate"},
+	Sort:      "nd_percent",
+	Bar: territoryGroups(
+		[]string{"territory_name", "territory_uuid", "territory_level", "total_pos", "universe_pos", "reach_rate"},
+		[]string{"brand_name", "brand_uuid", "nd_pos", "total_pos", "nd_percent"},
+	),
+	Line:    brandSeries("points", "brand_name", "brand_uuid", "month", "nd_pos", "total_pos", "nd_percent"),
+	Heatmap: "nd_percent",
+	Views: map[string]fiber.Handler{
+		"summary-kpi":   NDSummaryKPI,
+		"brand-ranking": NDBrandRanking,
+		"gap-analysis":  NDGapAnalysis,
+		"evolution":     NDEvolution,
+	},
 }
 
-// NDTableViewArea — ND breakdown per brand at Area level
-func NDTableViewArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH visited AS (
-			SELECT pf.area_uuid,
-			       COUNT(DISTINCT pf.pos_uuid) AS total_pos,
-			       COUNT(DISTINCT pf.uuid)     AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY pf.area_uuid
-		),
-		universe AS (
-			SELECT p.area_uuid, COUNT(p.uuid) AS universe_pos
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@area_uuid = '' OR p.area_uuid = @area_uuid)
-			  AND p.deleted_at IS NULL
-			GROUP BY p.area_uuid
-		),
-		nd_counts AS (
-			SELECT pf.area_uuid, pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.area_uuid, pfi.brand_uuid
-		)
+func ndAggregate(d metricDim) string {
+	if d.Pos == "" {
+		return metricFacts(d) + `
 		SELECT
-			a.name                                                            AS territory_name,
-			a.uuid                                                            AS territory_uuid,
-			'area'                                                            AS territory_level,
-			b.name                                                            AS brand_name,
-			b.uuid                                                            AS brand_uuid,
-			COALESCE(nd.nd_pos, 0)                                            AS nd_pos,
-			COALESCE(v.total_pos, 0)                                          AS total_pos,
-			COALESCE(u.universe_pos, 0)                                       AS universe_pos,
-			ROUND((COALESCE(nd.nd_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(v.total_posforms, 0), 0))::numeric, 2)    AS nd_percent,
-			ROUND((COALESCE(v.total_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)      AS reach_rate
-		FROM universe u
-		INNER JOIN areas a ON a.uuid = u.area_uuid
-		CROSS JOIN brands b
-		LEFT  JOIN nd_counts nd ON nd.area_uuid = u.area_uuid AND nd.brand_uuid = b.uuid
-		LEFT  JOIN visited v    ON v.area_uuid  = u.area_uuid
-		ORDER BY a.name, nd_percent DESC
-	`
-
-	type NDRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		UniversePos    int64   `json:"universe_pos"`
-		NdPercent      float64 `json:"nd_percent"`
-		ReachRate      float64 `json:"reach_rate"`
-	}
-
-	var results []NDRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND area data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Area Table", "data": results})
-}
-
-// NDTableViewSubArea — ND breakdown per brand at SubArea level
-func NDTableViewSubArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH visited AS (
-			SELECT pf.sub_area_uuid,
-			       COUNT(DISTINCT pf.pos_uuid) AS total_pos,
-			       COUNT(DISTINCT pf.uuid)     AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY pf.sub_area_uuid
-		),
+			bf.dim,
+			bf.brand_uuid,
+			bf.lines AS nd_pos,
+			v.total_pos,
+			bf.fardes,
+			0 AS universe_pos,
+			ROUND((bf.lines * 100.0 / NULLIF(t.visits, 0))::numeric, 2) AS nd_percent,
+			0 AS reach_rate
+		FROM brand_facts bf
+		LEFT JOIN visited v ON v.dim = bf.dim
+		LEFT JOIN totals t  ON t.dim = bf.dim AND t.category_uuid = bf.category_uuid`
+	}
+
+	return metricFacts(d) + `,
 		universe AS (
-			SELECT p.sub_area_uuid, COUNT(p.uuid) AS universe_pos
+			SELECT ` + d.Pos + ` AS dim, COUNT(p.uuid) AS universe_pos
 			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND p.deleted_at IS NULL
-			GROUP BY p.sub_area_uuid
-		),
-		nd_counts AS (
-			SELECT pf.sub_area_uuid, pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.sub_area_uuid, pfi.brand_uuid
+			WHERE ` + metricPosFilter + `
+			GROUP BY 1
 		)
 		SELECT
-			sa.name                                                           AS territory_name,
-			sa.uuid                                                           AS territory_uuid,
-			'subarea'                                                         AS territory_level,
-			b.name                                                            AS brand_name,
-			b.uuid                                                            AS brand_uuid,
-			COALESCE(nd.nd_pos, 0)                                            AS nd_pos,
-			COALESCE(v.total_pos, 0)                                          AS total_pos,
-			COALESCE(u.universe_pos, 0)                                       AS universe_pos,
-			ROUND((COALESCE(nd.nd_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(v.total_posforms, 0), 0))::numeric, 2)    AS nd_percent,
+			u.dim,
+			k.brand_uuid,
+			bf.lines AS nd_pos,
+			v.total_pos,
+			bf.fardes,
+			u.universe_pos,
+			ROUND((COALESCE(bf.lines, 0) * 100.0 /
+			       NULLIF(COALESCE(t.visits, 0), 0))::numeric, 2)        AS nd_percent,
 			ROUND((COALESCE(v.total_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)      AS reach_rate
+			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)  AS reach_rate
 		FROM universe u
-		INNER JOIN sub_areas sa ON sa.uuid = u.sub_area_uuid
-		CROSS JOIN brands b
-		LEFT  JOIN nd_counts nd ON nd.sub_area_uuid = u.sub_area_uuid AND nd.brand_uuid = b.uuid
-		LEFT  JOIN visited v    ON v.sub_area_uuid  = u.sub_area_uuid
-		ORDER BY sa.name, nd_percent DESC
-	`
-
-	type NDRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		UniversePos    int64   `json:"universe_pos"`
-		NdPercent      float64 `json:"nd_percent"`
-		ReachRate      float64 `json:"reach_rate"`
-	}
-
-	var results []NDRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND subarea data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND SubArea Table", "data": results})
+		CROSS JOIN keys k
+		LEFT JOIN brand_facts bf ON bf.dim = u.dim AND bf.brand_uuid = k.brand_uuid AND bf.category_uuid = k.category_uuid
+		LEFT JOIN visited v      ON v.dim  = u.dim
+		LEFT JOIN visit_totals t ON t.dim  = u.dim`
 }
 
-// NDTableViewCommune — ND breakdown per brand at Commune level
-func NDTableViewCommune(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH visited AS (
-			SELECT pf.commune_uuid,
-			       COUNT(DISTINCT pf.pos_uuid) AS total_pos,
-			       COUNT(DISTINCT pf.uuid)     AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY pf.commune_uuid
-		),
-		universe AS (
-			SELECT p.commune_uuid, COUNT(p.uuid) AS universe_pos
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@commune_uuid = '' OR p.commune_uuid = @commune_uuid)
-			  AND p.deleted_at IS NULL
-			GROUP BY p.commune_uuid
-		),
-		nd_counts AS (
-			SELECT pf.commune_uuid, pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.commune_uuid, pfi.brand_uuid
-		)
-		SELECT
-			cm.name                                                           AS territory_name,
-			cm.uuid                                                           AS territory_uuid,
-			'commune'                                                         AS territory_level,
-			b.name                                                            AS brand_name,
-			b.uuid                                                            AS brand_uuid,
-			COALESCE(nd.nd_pos, 0)                                            AS nd_pos,
-			COALESCE(v.total_pos, 0)                                          AS total_pos,
-			COALESCE(u.universe_pos, 0)                                       AS universe_pos,
-			ROUND((COALESCE(nd.nd_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(v.total_posforms, 0), 0))::numeric, 2)    AS nd_percent,
-			ROUND((COALESCE(v.total_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)      AS reach_rate
-		FROM universe u
-		INNER JOIN communes cm ON cm.uuid = u.commune_uuid
-		CROSS JOIN brands b
-		LEFT  JOIN nd_counts nd ON nd.commune_uuid = u.commune_uuid AND nd.brand_uuid = b.uuid
-		LEFT  JOIN visited v    ON v.commune_uuid  = u.commune_uuid
-		ORDER BY cm.name, nd_percent DESC
-	`
-
-	type NDRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		UniversePos    int64   `json:"universe_pos"`
-		NdPercent      float64 `json:"nd_percent"`
-		ReachRate      float64 `json:"reach_rate"`
-	}
-
-	var results []NDRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND commune data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Commune Table", "data": results})
-}
-
-// ─────────────────────────────────────────────────────────────────────────────
-// SECTION 2 — BAR CHARTS
-// Returns data grouped per territory, each territory contains an array of
-// brands with nd_pos, total_pos and nd_percent. Ready for grouped bar charts.
-// ─────────────────────────────────────────────────────────────────────────────
-
-type ndBrandItem struct {
-	BrandName string  `json:"brand_name"`
-	BrandUUID string  `json:"brand_uuid"`
-	NdPos     int64   `json:"nd_pos"`
-	TotalPos  int64   `json:"total_pos"`
-	NdPercent float64 `json:"nd_percent"`
-}
-
-type ndBarGroup struct {
-	TerritoryName  string        `json:"territory_name"`
-	TerritoryUUID  string        `json:"territory_uuid"`
-	TerritoryLevel string        `json:"territory_level"`
-	TotalPos       int64         `json:"total_pos"`
-	UniversePos    int64         `json:"universe_pos"`
-	ReachRate      float64       `json:"reach_rate"`
-	Brands         []ndBrandItem `json:"brands"`
-}
-
-// ndBarChartBuilder — shared logic for all bar-chart endpoints.
-func ndBarChartBuilder(c *fiber.Ctx, geoCol, joinTable, level string) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	type rawRow struct {
-		TerritoryName string  `json:"territory_name"`
-		TerritoryUUID string  `json:"territory_uuid"`
-		BrandName     string  `json:"brand_name"`
-		BrandUUID     string  `json:"brand_uuid"`
-		NdPos         int64   `json:"nd_pos"`
-		TotalPos      int64   `json:"total_pos"`
-		UniversePos   int64   `json:"universe_pos"`
-		NdPercent     float64 `json:"nd_percent"`
-		ReachRate     float64 `json:"reach_rate"`
-	}
-
-	sqlQuery := `
-		WITH visited AS (
-			SELECT pf.` + geoCol + `,
-			       COUNT(DISTINCT pf.pos_uuid) AS total_pos,
-			       COUNT(DISTINCT pf.uuid)     AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY pf.` + geoCol + `
-		),
-		universe AS (
-			SELECT p.` + geoCol + `, COUNT(p.uuid) AS universe_pos
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
-			  AND p.deleted_at IS NULL
-			GROUP BY p.` + geoCol + `
-		),
-		nd_counts AS (
-			SELECT pf.` + geoCol + `, pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.` + geoCol + `, pfi.brand_uuid
-		)
-		SELECT
-			t.name                                                            AS territory_name,
-			t.uuid                                                            AS territory_uuid,
-			b.name                                                            AS brand_name,
-			b.uuid                                                            AS brand_uuid,
-			COALESCE(nd.nd_pos, 0)                                            AS nd_pos,
-			COALESCE(v.total_pos, 0)                                          AS total_pos,
-			COALESCE(u.universe_pos, 0)                                       AS universe_pos,
-			ROUND((COALESCE(nd.nd_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(v.total_posforms, 0), 0))::numeric, 2)    AS nd_percent,
-			ROUND((COALESCE(v.total_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)      AS reach_rate
-		FROM universe u
-		INNER JOIN ` + joinTable + ` t ON t.uuid = u.` + geoCol + `
-		CROSS JOIN brands b
-		LEFT  JOIN nd_counts nd ON nd.` + geoCol + ` = u.` + geoCol + ` AND nd.brand_uuid = b.uuid
-		LEFT  JOIN visited v    ON v.` + geoCol + `  = u.` + geoCol + `
-		ORDER BY t.name, nd_percent DESC
-	`
-
-	var rawResults []rawRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &rawResults)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND bar chart data", "error": err.Error(),
-		})
-	}
-
-	// Aggregate into grouped structure
-	groupMap := make(map[string]*ndBarGroup)
-	order := []string{}
-
-	for _, r := range rawResults {
-		if _, exists := groupMap[r.TerritoryUUID]; !exists {
-			reachRate := math.Round(float64(r.TotalPos)*100.0/math.Max(float64(r.UniversePos), 1)*100) / 100
-			groupMap[r.TerritoryUUID] = &ndBarGroup{
-				TerritoryName:  r.TerritoryName,
-				TerritoryUUID:  r.TerritoryUUID,
-				TerritoryLevel: level,
-				TotalPos:       r.TotalPos,
-				UniversePos:    r.UniversePos,
-				ReachRate:      reachRate,
-				Brands:         []ndBrandItem{},
-			}
-			order = append(order, r.TerritoryUUID)
-		}
-		groupMap[r.TerritoryUUID].Brands = append(groupMap[r.TerritoryUUID].Brands, ndBrandItem{
-			BrandName: r.BrandName,
-			BrandUUID: r.BrandUUID,
-			NdPos:     r.NdPos,
-			TotalPos:  r.TotalPos,
-			NdPercent: r.NdPercent,
-		})
-	}
-
-	grouped := make([]ndBarGroup, 0, len(order))
-	for _, uuid := range order {
-		grouped = append(grouped, *groupMap[uuid])
-	}
-
-	return c.JSON(fiber.Map{
-		"status": "success", "message": "ND Bar Chart — " + level, "data": grouped,
-	})
-}
+// NDTableViewProvince — ND breakdown per brand at Province level
+func NDTableViewProvince(c *fiber.Ctx) error { return ndMetric.serve(c, "table-view", "province") }
 
-func NDBarChartProvince(c *fiber.Ctx) error {
-	return ndBarChartBuilder(c, "province_uuid", "provinces", "province")
-}
-func NDBarChartArea(c *fiber.Ctx) error {
-	return ndBarChartBuilder(c, "area_uuid", "areas", "area")
-}
-func NDBarChartSubArea(c *fiber.Ctx) error {
-	return ndBarChartBuilder(c, "sub_area_uuid", "sub_areas", "subarea")
-}
-func NDBarChartCommune(c *fiber.Ctx) error {
-	return ndBarChartBuilder(c, "commune_uuid", "communes", "commune")
-}
+// NDTableViewArea — ND breakdown per brand at Area level
+func NDTableViewArea(c *fiber.Ctx) error { return ndMetric.serve(c, "table-view", "area") }
 
-// ─────────────────────────────────────────────────────────────────────────────
-// SECTION 3 — MONTHLY TREND LINE CHART
-// Returns ND% per brand per calendar month. Great for seasonal analysis.
-// ─────────────────────────────────────────────────────────────────────────────
+// NDTableViewSubArea — ND breakdown per brand at SubArea level
+func NDTableViewSubArea(c *fiber.Ctx) error { return ndMetric.serve(c, "table-view", "subarea") }
 
-// NDLineChartByMonth — ND% per brand per calendar month
+// NDTableViewCommune — ND breakdown per brand at Commune level
+func NDTableViewCommune(c *fiber.Ctx) error { return ndMetric.serve(c, "table-view", "commune") }
+
+// Bar charts: [{territory_name, territory_uuid, territory_level, total_pos,
+// universe_pos, reach_rate, brands: [{brand_name, brand_uuid, nd_pos,
+// total_pos, nd_percent}]}]
+func NDBarChartProvince(c *fiber.Ctx) error { return ndMetric.serve(c, "bar-chart", "province") }
+func NDBarChartArea(c *fiber.Ctx) error     { return ndMetric.serve(c, "bar-chart", "area") }
+func NDBarChartSubArea(c *fiber.Ctx) error  { return ndMetric.serve(c, "bar-chart", "subarea") }
+func NDBarChartCommune(c *fiber.Ctx) error  { return ndMetric.serve(c, "bar-chart", "commune") }
+
+// NDLineChartByMonth — ND% per brand per calendar month:
+// [{brand_name, brand_uuid, points: [{month, nd_pos, total_pos, nd_percent, …}]}]
 func NDLineChartByMonth(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	type MonthRow struct {
-		BrandName string  `json:"brand_name"`
-		BrandUUID string  `json:"brand_uuid"`
-		Month     string  `json:"month"`
-		NdPos     int64   `json:"nd_pos"`
-		TotalPos  int64   `json:"total_pos"`
-		NdPercent float64 `json:"nd_percent"`
-	}
-
-	sqlQuery := `
-		WITH universe AS (
-			SELECT COUNT(p.uuid) AS cnt
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
-			  AND p.deleted_at IS NULL
-		),
-		monthly_visited AS (
-			SELECT
-				TO_CHAR(pf.created_at, 'YYYY-MM') AS month,
-				COUNT(DISTINCT pf.pos_uuid)        AS total_pos,
-				COUNT(DISTINCT pf.uuid)            AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY month
-		),
-		nd_monthly AS (
-			SELECT
-				TO_CHAR(pf.created_at, 'YYYY-MM') AS month,
-				pfi.brand_uuid,
-				COUNT(DISTINCT pfi.uuid)           AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY month, pfi.brand_uuid
-		)
-		SELECT
-			b.name                                                              AS brand_name,
-			b.uuid                                                              AS brand_uuid,
-			nm.month,
-			nm.nd_pos,
-			COALESCE(mv.total_pos, 0)                                           AS total_pos,
-			ROUND((nm.nd_pos * 100.0 /
-			       NULLIF(COALESCE(mv.total_posforms, 0), 0))::numeric, 2)     AS nd_percent
-		FROM nd_monthly nm
-		INNER JOIN brands b ON b.uuid = nm.brand_uuid
-		LEFT  JOIN monthly_visited mv ON mv.month = nm.month
-		ORDER BY b.name, nm.month
-	`
-
-	var rawRows []MonthRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &rawRows)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND monthly trend", "error": err.Error(),
-		})
-	}
-
-	// Group per brand → series of monthly points
-	type BrandSeries struct {
-		BrandName string     `json:"brand_name"`
-		BrandUUID string     `json:"brand_uuid"`
-		Points    []MonthRow `json:"points"`
-	}
-	brandMap := make(map[string]*BrandSeries)
-	order := []string{}
-	for _, r := range rawRows {
-		if _, ok := brandMap[r.BrandUUID]; !ok {
-			brandMap[r.BrandUUID] = &BrandSeries{BrandName: r.BrandName, BrandUUID: r.BrandUUID, Points: []MonthRow{}}
-			order = append(order, r.BrandUUID)
-		}
-		brandMap[r.BrandUUID].Points = append(brandMap[r.BrandUUID].Points, r)
-	}
-	series := make([]BrandSeries, 0, len(order))
-	for _, uuid := range order {
-		series = append(series, *brandMap[uuid])
-	}
-
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Monthly Trend by Brand", "data": series})
+	return ndMetric.serve(c, "line-chart-by-month", "")
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -811,20 +134,9 @@ func NDLineChartByMonth(c *fiber.Ctx) error {
 //	reach_rate          — visited / universe × 100
 //	coverage_index      — nd_pos / universe × 100
 func NDSummaryKPI(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
 
 	type KPI struct {
@@ -838,114 +150,36 @@ func NDSummaryKPI(c *fiber.Ctx) error {
 	}
 
 	sqlQuery := `
-		WITH universe AS (
-			SELECT COUNT(p.uuid) AS cnt
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
-			  AND p.deleted_at IS NULL
-		),
-		visited AS (
-			SELECT COUNT(DISTINCT pf.pos_uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		nd_pos AS (
-			SELECT COUNT(DISTINCT pfi.uuid) AS cnt
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		),
-		posforms AS (
-			SELECT COUNT(DISTINCT pf.uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		brand_nd AS (
-			SELECT
-				pfi.brand_uuid,
-				ROUND((COUNT(DISTINCT pfi.uuid) * 100.0 /
-				       NULLIF((SELECT cnt FROM posforms), 0))::numeric, 2) AS nd_pct
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pfi.brand_uuid
-		)
+		WITH ` + metricCTE(ndAggregate, metricDim{Fact: metricTotal, Pos: metricTotal, Group: group}) + `,
+		` + metricVisits + `
 		SELECT
-			(SELECT cnt FROM universe)                                         AS total_universe_pos,
-			(SELECT cnt FROM visited)                                          AS total_visited_pos,
-			(SELECT cnt FROM nd_pos)                                           AS total_nd_pos,
-			ROUND(COALESCE((SELECT AVG(nd_pct) FROM brand_nd), 0)::numeric, 2) AS avg_nd_percent,
-			(SELECT COUNT(*) FROM brand_nd)                                    AS total_brands,
-			ROUND(((SELECT cnt FROM visited) * 100.0 /
-			       NULLIF((SELECT cnt FROM universe), 0))::numeric, 2)        AS reach_rate,
-			ROUND(((SELECT cnt FROM nd_pos) * 100.0 /
-			       NULLIF((SELECT cnt FROM posforms), 0))::numeric, 2)        AS coverage_index
+			COALESCE(MAX(m.universe_pos), 0)                                   AS total_universe_pos,
+			(SELECT total_pos FROM visits)                                     AS total_visited_pos,
+			COALESCE(SUM(m.nd_pos), 0)::bigint                                 AS total_nd_pos,
+			ROUND(COALESCE(AVG(m.nd_percent) FILTER (WHERE m.nd_pos > 0), 0)::numeric, 2) AS avg_nd_percent,
+			COUNT(*) FILTER (WHERE m.nd_pos > 0)                               AS total_brands,
+			ROUND(COALESCE((SELECT total_pos FROM visits) * 100.0 /
+			      NULLIF(MAX(m.universe_pos), 0), 0)::numeric, 2)              AS reach_rate,
+			ROUND(COALESCE(SUM(m.nd_pos) * 100.0 /
+			      NULLIF((SELECT visits FROM visits), 0), 0)::numeric, 2)      AS coverage_index
+		FROM m
 	`
 
 	var kpi KPI
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &kpi)
-
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&kpi).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch ND summary KPI", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Summary KPI", "data": kpi})
+	return c.JSON(fiber.Map{"status": "success", "message": "ND Summary KPI", "group": group, "data": kpi})
 }
 
 // NDBrandRanking — Brands ranked by ND% descending.
 // Also returns total_farde and avg_farde for deeper sales insight.
 func NDBrandRanking(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
 
 	type RankRow struct {
@@ -960,87 +194,27 @@ func NDBrandRanking(c *fiber.Ctx) error {
 	}
 
 	sqlQuery := `
-		WITH universe AS (
-			SELECT COUNT(p.uuid) AS cnt
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
-			  AND p.deleted_at IS NULL
-		),
-		visited AS (
-			SELECT COUNT(DISTINCT pf.pos_uuid) AS total_pos
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		posforms AS (
-			SELECT COUNT(DISTINCT pf.uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		brand_stats AS (
-			SELECT
-				pfi.brand_uuid,
-				COUNT(DISTINCT pfi.uuid)                 AS nd_pos,
-				ROUND(SUM(pfi.number_farde)::numeric, 2) AS total_farde,
-				ROUND(AVG(pfi.number_farde)::numeric, 2) AS avg_farde
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pfi.brand_uuid
-		)
+		WITH ` + metricCTE(ndAggregate, metricDim{Fact: metricTotal, Group: group}) + `
 		SELECT
 			ROW_NUMBER() OVER (ORDER BY nd_pos DESC)                           AS rank,
-			b.name                                                             AS brand_name,
-			b.uuid                                                             AS brand_uuid,
-			bs.nd_pos,
-			(SELECT total_pos FROM visited)                                    AS total_pos,
-			ROUND((bs.nd_pos * 100.0 /
-			       NULLIF((SELECT cnt FROM posforms), 0))::numeric, 2)        AS nd_percent,
-			bs.total_farde,
-			bs.avg_farde
-		FROM brand_stats bs
-		INNER JOIN brands b ON b.uuid = bs.brand_uuid
+			brand_name,
+			brand_uuid,
+			nd_pos,
+			total_pos,
+			nd_percent,
+			ROUND(fardes::numeric, 2)                                          AS total_farde,
+			ROUND((fardes / NULLIF(nd_pos, 0))::numeric, 2)                    AS avg_farde
+		FROM m
 		ORDER BY nd_pos DESC
 	`
 
 	var results []RankRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch ND brand ranking", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Brand Ranking", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "ND Brand Ranking", "group": group, "data": results})
 }
 
 // NDGapAnalysis — 3-zone opportunity funnel per brand:
@@ -1050,21 +224,13 @@ func NDBrandRanking(c *fiber.Ctx) error {
 //	Zone C (Universe Gap)  — Registered POS never visited
 //
 // opportunity_pct = (B + C) / universe × 100
+//
+// ?segment=A|B|C keeps the visits made while the POS was in the segment
+// and the POS of the segment now.
 func NDGapAnalysis(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
 
 	type GapRow struct {
@@ -1081,89 +247,36 @@ func NDGapAnalysis(c *fiber.Ctx) error {
 	}
 
 	sqlQuery := `
-		WITH universe AS (
-			SELECT COUNT(p.uuid) AS cnt
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
-			  AND p.deleted_at IS NULL
-		),
-		visited AS (
-			SELECT COUNT(DISTINCT pf.pos_uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		posforms AS (
-			SELECT COUNT(DISTINCT pf.uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		nd_per_brand AS (
-			SELECT pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pfi.brand_uuid
+		WITH ` + metricCTE(ndAggregate, metricDim{Fact: metricTotal, Pos: metricTotal, Group: group}) + `,
+		gaps AS (
+			SELECT
+				brand_name,
+				brand_uuid,
+				nd_pos,
+				GREATEST(COALESCE(total_pos, 0) - nd_pos, 0)                  AS visited_gap_pos,
+				GREATEST(universe_pos - COALESCE(total_pos, 0), 0)            AS universe_gap_pos,
+				COALESCE(total_pos, 0)                                         AS total_visited,
+				universe_pos                                                   AS total_universe,
+				nd_percent,
+				reach_rate
+			FROM m
+			WHERE nd_pos > 0
 		)
 		SELECT
-			b.name                                                                AS brand_name,
-			b.uuid                                                                AS brand_uuid,
-			nb.nd_pos,
-			GREATEST((SELECT cnt FROM visited) - nb.nd_pos, 0)                   AS visited_gap_pos,
-			GREATEST((SELECT cnt FROM universe) - (SELECT cnt FROM visited), 0)  AS universe_gap_pos,
-			(SELECT cnt FROM visited)                                             AS total_visited,
-			(SELECT cnt FROM universe)                                            AS total_universe,
-			ROUND((nb.nd_pos * 100.0 /
-			       NULLIF((SELECT cnt FROM posforms), 0))::numeric, 2)            AS nd_percent,
-			ROUND(((SELECT cnt FROM visited) * 100.0 /
-			       NULLIF((SELECT cnt FROM universe), 0))::numeric, 2)           AS reach_rate,
-			ROUND(((GREATEST((SELECT cnt FROM visited) - nb.nd_pos, 0) +
-			        GREATEST((SELECT cnt FROM universe) - (SELECT cnt FROM visited), 0))
-			       * 100.0 /
-			       NULLIF((SELECT cnt FROM universe), 0))::numeric, 2)           AS opportunity_pct
-		FROM nd_per_brand nb
-		INNER JOIN brands b ON b.uuid = nb.brand_uuid
+			*,
+			ROUND(((visited_gap_pos + universe_gap_pos) * 100.0 /
+			       NULLIF(total_universe, 0))::numeric, 2)                   AS opportunity_pct
+		FROM gaps
 		ORDER BY nd_percent DESC
 	`
 
 	var results []GapRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch ND gap analysis", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Gap Analysis (3-Zone Funnel)", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "ND Gap Analysis (3-Zone Funnel)", "group": group, "data": results})
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -1182,149 +295,7 @@ func NDGapAnalysis(c *fiber.Ctx) error {
 //
 // Query param ?level=province|area|subarea|commune  (default: province)
 func NDHeatmap(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-	level := c.Query("level")
-	if level == "" {
-		level = "province"
-	}
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	geoCol, joinTable := "province_uuid", "provinces"
-	switch level {
-	case "area":
-		geoCol, joinTable = "area_uuid", "areas"
-	case "subarea":
-		geoCol, joinTable = "sub_area_uuid", "sub_areas"
-	case "commune":
-		geoCol, joinTable = "commune_uuid", "communes"
-	}
-
-	type CellRow struct {
-		BrandName     string  `json:"brand_name"`
-		BrandUUID     string  `json:"brand_uuid"`
-		TerritoryName string  `json:"territory_name"`
-		TerritoryUUID string  `json:"territory_uuid"`
-		NdPos         int64   `json:"nd_pos"`
-		TotalPos      int64   `json:"total_pos"`
-		NdPercent     float64 `json:"nd_percent"`
-	}
-
-	sqlQuery := `
-		WITH universe AS (
-			SELECT p.` + geoCol + `, COUNT(p.uuid) AS universe_pos
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid AND p.deleted_at IS NULL
-			GROUP BY p.` + geoCol + `
-		),
-		visited AS (
-			SELECT pf.` + geoCol + `,
-			       COUNT(DISTINCT pf.pos_uuid) AS total_pos,
-			       COUNT(DISTINCT pf.uuid)     AS total_posforms
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-			GROUP BY pf.` + geoCol + `
-		),
-		nd_cells AS (
-			SELECT pf.` + geoCol + `, pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.` + geoCol + `, pfi.brand_uuid
-		)
-		SELECT
-			b.name                                                            AS brand_name,
-			b.uuid                                                            AS brand_uuid,
-			t.name                                                            AS territory_name,
-			t.uuid                                                            AS territory_uuid,
-			COALESCE(nc.nd_pos, 0)                                            AS nd_pos,
-			COALESCE(v.total_pos, 0)                                          AS total_pos,
-			ROUND((COALESCE(nc.nd_pos, 0) * 100.0 /
-			       NULLIF(COALESCE(v.total_posforms, 0), 0))::numeric, 2)    AS nd_percent
-		FROM nd_cells nc
-		INNER JOIN brands       b ON b.uuid = nc.brand_uuid
-		INNER JOIN ` + joinTable + ` t ON t.uuid = nc.` + geoCol + `
-		LEFT  JOIN visited v ON v.` + geoCol + ` = nc.` + geoCol + `
-		LEFT  JOIN universe u ON u.` + geoCol + ` = nc.` + geoCol + `
-		ORDER BY b.name, t.name
-	`
-
-	var raw []CellRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date + " 23:59:59",
-	}, &raw)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch ND heatmap", "error": err.Error(),
-		})
-	}
-
-	// Pivot into brands × territories matrix
-	brandIndex := map[string]int{}
-	terrIndex := map[string]int{}
-	var brands []map[string]string
-	var territories []map[string]string
-
-	for _, r := range raw {
-		if _, ok := brandIndex[r.BrandUUID]; !ok {
-			brandIndex[r.BrandUUID] = len(brands)
-			brands = append(brands, map[string]string{"uuid": r.BrandUUID, "name": r.BrandName})
-		}
-		if _, ok := terrIndex[r.TerritoryUUID]; !ok {
-			terrIndex[r.TerritoryUUID] = len(territories)
-			territories = append(territories, map[string]string{"uuid": r.TerritoryUUID, "name": r.TerritoryName})
-		}
-	}
-
-	matrix := make([][]float64, len(brands))
-	for i := range matrix {
-		matrix[i] = make([]float64, len(territories))
-	}
-	for _, r := range raw {
-		matrix[brandIndex[r.BrandUUID]][terrIndex[r.TerritoryUUID]] = r.NdPercent
-	}
-
-	return c.JSON(fiber.Map{
-		"status":  "success",
-		"message": "ND Heatmap — Brand × Territory",
-		"level":   level,
-		"data": fiber.Map{
-			"brands":      brands,
-			"territories": territories,
-			"matrix":      matrix,
-		},
-	})
+	return ndMetric.serve(c, "heatmap", c.Query("level", "province"))
 }
 
 // NDEvolution — Period-over-Period (PoP) ND% comparison.
@@ -1336,36 +307,16 @@ func NDHeatmap(c *fiber.Ctx) error {
 //	delta               — current - previous (pp points)
 //	trend               — "up" | "down" | "stable"
 func NDEvolution(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
-
-	// Compute previous period in Go to avoid @param::date cast issues with GORM
-	const dateFmt = "2006-01-02"
-	parsedStart, err1 := time.Parse(dateFmt, start_date)
-	parsedEnd, err2 := time.Parse(dateFmt, end_date)
-	if err1 != nil || err2 != nil {
+	params, err := periodParams(params)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status": "error", "message": "start_date and end_date must be YYYY-MM-DD",
 		})
 	}
-	window := parsedEnd.Sub(parsedStart) + 24*time.Hour
-	prevEnd := parsedStart.AddDate(0, 0, -1)
-	prevStart := prevEnd.Add(-window + 24*time.Hour)
-	prev_start_date := prevStart.Format(dateFmt)
-	prev_end_date := prevEnd.Format(dateFmt)
 
 	type EvoRow struct {
 		BrandName         string  `json:"brand_name"`
@@ -1381,132 +332,25 @@ func NDEvolution(c *fiber.Ctx) error {
 	}
 
 	sqlQuery := `
-		WITH universe AS (
-			SELECT COUNT(p.uuid) AS cnt
-			FROM pos p
-			WHERE p.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR p.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR p.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR p.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR p.commune_uuid  = @commune_uuid)
-			  AND p.deleted_at IS NULL
-		),
-		curr_visited AS (
-			SELECT COUNT(DISTINCT pf.pos_uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		curr_posforms AS (
-			SELECT COUNT(DISTINCT pf.uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL
-		),
-		prev_visited AS (
-			SELECT COUNT(DISTINCT pf.pos_uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @prev_start_date AND pf.created_at <= @prev_end_date
-			  AND pf.deleted_at IS NULL
-		),
-		prev_posforms AS (
-			SELECT COUNT(DISTINCT pf.uuid) AS cnt
-			FROM pos_forms pf
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @prev_start_date AND pf.created_at <= @prev_end_date
-			  AND pf.deleted_at IS NULL
-		),
-		curr_nd AS (
-			SELECT pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @start_date AND pf.created_at <= @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pfi.brand_uuid
-		),
-		prev_nd AS (
-			SELECT pfi.brand_uuid, COUNT(DISTINCT pfi.uuid) AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at >= @prev_start_date AND pf.created_at <= @prev_end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pfi.brand_uuid
-		)
+		WITH ` + metricCTE(ndAggregate, metricDim{Fact: metricPeriods, Group: group}) + `,
+		` + metricEvolution("m", []string{"nd_pos", "nd_percent"}, []string{"total_pos"}) + `
 		SELECT
-			b.name                                                                 AS brand_name,
-			b.uuid                                                                 AS brand_uuid,
-			COALESCE(cn.nd_pos, 0)                                                 AS current_nd_pos,
-			COALESCE(pn.nd_pos, 0)                                                 AS previous_nd_pos,
-			(SELECT cnt FROM curr_visited)                                         AS current_total_pos,
-			(SELECT cnt FROM prev_visited)                                         AS previous_total_pos,
-			ROUND((COALESCE(cn.nd_pos, 0) * 100.0 /
-			       NULLIF((SELECT cnt FROM curr_posforms), 0))::numeric, 2)         AS current_nd_percent,
-			ROUND((COALESCE(pn.nd_pos, 0) * 100.0 /
-			       NULLIF((SELECT cnt FROM prev_posforms), 0))::numeric, 2)         AS previous_nd_percent,
-			ROUND((COALESCE(cn.nd_pos, 0) * 100.0 /
-			       NULLIF((SELECT cnt FROM curr_posforms), 0) -
-			       COALESCE(pn.nd_pos, 0) * 100.0 /
-			       NULLIF((SELECT cnt FROM prev_posforms), 0))::numeric, 2)         AS delta,
+			*,
+			current_nd_percent - previous_nd_percent AS delta,
 			CASE
-				WHEN COALESCE(cn.nd_pos, 0) > COALESCE(pn.nd_pos, 0) THEN 'up'
-				WHEN COALESCE(cn.nd_pos, 0) < COALESCE(pn.nd_pos, 0) THEN 'down'
+				WHEN current_nd_pos > previous_nd_pos THEN 'up'
+				WHEN current_nd_pos < previous_nd_pos THEN 'down'
 				ELSE 'stable'
 			END AS trend
-		FROM (SELECT DISTINCT brand_uuid FROM curr_nd
-		      UNION
-		      SELECT DISTINCT brand_uuid FROM prev_nd) all_brands
-		INNER JOIN brands b ON b.uuid = all_brands.brand_uuid
-		LEFT  JOIN curr_nd cn ON cn.brand_uuid = all_brands.brand_uuid
-		LEFT  JOIN prev_nd pn ON pn.brand_uuid = all_brands.brand_uuid
+		FROM evolution
 		ORDER BY current_nd_percent DESC
 	`
 
 	var results []EvoRow
-	err := runRaw(db, sqlQuery, map[string]interface{}{
-		"country_uuid":    country_uuid,
-		"province_uuid":   province_uuid,
-		"area_uuid":       area_uuid,
-		"sub_area_uuid":   sub_area_uuid,
-		"commune_uuid":    commune_uuid,
-		"start_date":      start_date,
-		"end_date":        end_date + " 23:59:59",
-		"prev_start_date": prev_start_date,
-		"prev_end_date":   prev_end_date + " 23:59:59",
-	}, &results)
-
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch ND evolution", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "ND Period-over-Period Evolution", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "ND Period-over-Period Evolution", "group": group, "data": results})
 }
@@ -0,0 +1,257 @@
+package dashboard
+
+import (
+	"strconv"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ╔══════════════════════════════════════════════════════════════════════════════╗
+// ║              PRICE COMPLIANCE DASHBOARD — SHELF PRICE VS RRP                 ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  1.  Summary           — share of POS above / at / below the RRP per brand   ║
+// ║  2.  Dispersion        — spread of the POS prices per commune × brand        ║
+// ║  3.  Trend             — price vs RRP by day, week or month                  ║
+// ║  Prices are the shelf prices captured on the brand lines, in one unit       ║
+// ║  (unit=farde|pack) and currency (currency=). The price of a POS is its      ║
+// ║  latest in the period. A price is at the RRP when within tolerance=% (5)    ║
+// ║  of it; the RRP is the most specific recommended price of the visit.        ║
+// ╚══════════════════════════════════════════════════════════════════════════════╝
+
+// rrpJoin adds rrp.price, the recommended price of the line pfi of the
+// visit pf: most specific territory first, then the latest start date.
+// Null when no recommended price applies.
+const rrpJoin = `LEFT JOIN LATERAL (
+			SELECT rp.price
+			FROM recommended_prices rp
+			WHERE rp.brand_uuid = pfi.brand_uuid
+			  AND rp.price_unit = pfi.price_unit AND rp.currency = pfi.currency
+			  AND rp.start_date <= pf.created_at::date
+			  AND (rp.end_date IS NULL OR rp.end_date >= pf.created_at::date)
+			  AND rp.country_uuid  IN ('', pf.country_uuid)
+			  AND rp.province_uuid IN ('', pf.province_uuid)
+			  AND rp.area_uuid     IN ('', pf.area_uuid)
+			  AND rp.sub_area_uuid IN ('', pf.sub_area_uuid)
+			  AND rp.commune_uuid  IN ('', pf.commune_uuid)
+			  AND rp.deleted_at IS NULL
+			ORDER BY rp.commune_uuid <> '' DESC, rp.sub_area_uuid <> '' DESC, rp.area_uuid <> '' DESC,
+			         rp.province_uuid <> '' DESC, rp.country_uuid <> '' DESC,
+			         rp.start_date DESC, rp.updated_at DESC
+			LIMIT 1
+		) rrp ON true`
+
+// priceFilter restricts the priced lines pfi of the visits pf to the
+// filters of the view.
+const priceFilter = `pf.created_at BETWEEN @start_date AND @end_date
+			  AND (@country_uuid  = '' OR pf.country_uuid  = @country_uuid)
+			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
+			  AND (@brand_uuid    = '' OR pfi.brand_uuid   = @brand_uuid)
+			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+			  AND ` + pricedLine
+
+// latestPrices is the CTE of the latest price of each POS × brand in the
+// period, with its RRP and its position: above, at, below or no_rrp.
+const latestPrices = `latest AS (
+			SELECT DISTINCT ON (pf.pos_uuid, pfi.brand_uuid)
+				pf.pos_uuid,
+				pfi.brand_uuid,
+				pf.commune_uuid,
+				pfi.price,
+				rrp.price AS rrp,
+				CASE
+					WHEN rrp.price IS NULL                                  THEN 'no_rrp'
+					WHEN pfi.price > rrp.price * (1 + @tolerance / 100.0)   THEN 'above'
+					WHEN pfi.price < rrp.price * (1 - @tolerance / 100.0)   THEN 'below'
+					ELSE 'at'
+				END AS position
+			FROM pos_form_items pfi
+			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
+			` + rrpJoin + `
+			WHERE ` + priceFilter + `
+			ORDER BY pf.pos_uuid, pfi.brand_uuid, pf.created_at DESC
+		)`
+
+// complianceParams reads the filters of the price compliance views and
+// answers the request itself when they are invalid.
+func complianceParams(c *fiber.Ctx) (map[string]interface{}, bool) {
+	params, err := priceParams(c)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "Invalid price filters", "error": err.Error(),
+		})
+		return nil, false
+	}
+	if params == nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "start_date and end_date are required",
+		})
+		return nil, false
+	}
+	tolerance, err := strconv.ParseFloat(c.Query("tolerance", "5"), 64)
+	if err != nil || tolerance < 0 || tolerance >= 100 {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "tolerance must be a percentage from 0 to 100",
+		})
+		return nil, false
+	}
+	params["tolerance"] = tolerance
+	return params, true
+}
+
+// serveCompliance runs a price compliance query and answers with its rows.
+func serveCompliance(c *fiber.Ctx, params map[string]interface{}, query, message string) error {
+	results := []metricRow{}
+	if err := database.DB.Raw(query, params).Scan(&results).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status": "success", "message": message, "data": results,
+		"unit": params["unit"], "currency": params["currency"], "tolerance": params["tolerance"],
+	})
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// SECTION 1 — SUMMARY : POS above / at / below the RRP per brand
+// The percentages are over the POS whose price has an RRP.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// PriceComplianceSummary — share of POS selling above, at or below the RRP
+func PriceComplianceSummary(c *fiber.Ctx) error {
+	params, ok := complianceParams(c)
+	if !ok {
+		return nil
+	}
+	query := `
+		WITH ` + latestPrices + `
+		SELECT
+			b.name                                                   AS brand_name,
+			b.uuid                                                   AS brand_uuid,
+			COUNT(*)::bigint                                         AS priced_pos,
+			COUNT(*) FILTER (WHERE l.rrp IS NOT NULL)::bigint        AS pos_with_rrp,
+			COUNT(*) FILTER (WHERE l.position = 'above')::bigint     AS pos_above,
+			COUNT(*) FILTER (WHERE l.position = 'at')::bigint        AS pos_at,
+			COUNT(*) FILTER (WHERE l.position = 'below')::bigint     AS pos_below,
+			COALESCE(ROUND((COUNT(*) FILTER (WHERE l.position = 'above') * 100.0 /
+			      NULLIF(COUNT(*) FILTER (WHERE l.rrp IS NOT NULL), 0))::numeric, 2), 0)::float8 AS above_percent,
+			COALESCE(ROUND((COUNT(*) FILTER (WHERE l.position = 'at') * 100.0 /
+			      NULLIF(COUNT(*) FILTER (WHERE l.rrp IS NOT NULL), 0))::numeric, 2), 0)::float8 AS at_percent,
+			COALESCE(ROUND((COUNT(*) FILTER (WHERE l.position = 'below') * 100.0 /
+			      NULLIF(COUNT(*) FILTER (WHERE l.rrp IS NOT NULL), 0))::numeric, 2), 0)::float8 AS below_percent,
+			ROUND(AVG(l.price)::numeric, 2)::float8                  AS avg_price,
+			COALESCE(ROUND(AVG(l.rrp)::numeric, 2), 0)::float8       AS avg_rrp,
+			COALESCE(ROUND(AVG((l.price - l.rrp) * 100.0 / NULLIF(l.rrp, 0))::numeric, 2), 0)::float8 AS avg_gap_percent
+		FROM latest l
+		INNER JOIN brands b ON b.uuid = l.brand_uuid
+		GROUP BY b.name, b.uuid
+		ORDER BY priced_pos DESC
+	`
+	return serveCompliance(c, params, query, "Price compliance — Summary")
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// SECTION 2 — DISPERSION : spread of the POS prices per commune × brand
+// cv_percent is the coefficient of variation (stddev / avg); the higher, the
+// less uniform the price across the POS of the commune.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// PriceDispersionCommune — price dispersion by commune and brand
+func PriceDispersionCommune(c *fiber.Ctx) error {
+	params, ok := complianceParams(c)
+	if !ok {
+		return nil
+	}
+	query := `
+		WITH ` + latestPrices + `
+		SELECT
+			co.name                                                  AS commune_name,
+			co.uuid                                                  AS commune_uuid,
+			b.name                                                   AS brand_name,
+			b.uuid                                                   AS brand_uuid,
+			COUNT(*)::bigint                                         AS priced_pos,
+			ROUND(AVG(l.price)::numeric, 2)::float8                  AS avg_price,
+			ROUND(MIN(l.price)::numeric, 2)::float8                  AS min_price,
+			ROUND(MAX(l.price)::numeric, 2)::float8                  AS max_price,
+			ROUND(PERCENTILE_CONT(0.25) WITHIN GROUP (ORDER BY l.price)::numeric, 2)::float8 AS p25_price,
+			ROUND(PERCENTILE_CONT(0.5)  WITHIN GROUP (ORDER BY l.price)::numeric, 2)::float8 AS median_price,
+			ROUND(PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY l.price)::numeric, 2)::float8 AS p75_price,
+			COALESCE(ROUND(STDDEV_POP(l.price)::numeric, 2), 0)::float8 AS stddev_price,
+			COALESCE(ROUND((STDDEV_POP(l.price) * 100.0 / NULLIF(AVG(l.price), 0))::numeric, 2), 0)::float8 AS cv_percent,
+			COALESCE(ROUND(AVG(l.rrp)::numeric, 2), 0)::float8       AS avg_rrp,
+			COALESCE(ROUND((COUNT(*) FILTER (WHERE l.position = 'at') * 100.0 /
+			      NULLIF(COUNT(*) FILTER (WHERE l.rrp IS NOT NULL), 0))::numeric, 2), 0)::float8 AS at_percent
+		FROM latest l
+		INNER JOIN communes co ON co.uuid = l.commune_uuid
+		INNER JOIN brands b    ON b.uuid = l.brand_uuid
+		GROUP BY co.name, co.uuid, b.name, b.uuid
+		ORDER BY co.name, cv_percent DESC
+	`
+	return serveCompliance(c, params, query, "Price compliance — Dispersion by commune")
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// SECTION 3 — TREND : price vs RRP over time
+// One row per period (granularity=day|week|month, month by default) × brand,
+// over every priced line of the period.
+// ─────────────────────────────────────────────────────────────────────────────
+
+var trendGranularities = map[string]string{
+	"day":   "YYYY-MM-DD",
+	"week":  "IYYY-\"W\"IW",
+	"month": "YYYY-MM",
+}
+
+// PriceTrend — average price vs RRP per period and brand
+func PriceTrend(c *fiber.Ctx) error {
+	params, ok := complianceParams(c)
+	if !ok {
+		return nil
+	}
+	granularity := c.Query("granularity", "month")
+	format, ok := trendGranularities[granularity]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "granularity must be day, week or month",
+		})
+	}
+	params["format"] = format
+
+	query := `
+		WITH lines AS (
+			SELECT
+				TO_CHAR(pf.created_at, @format) AS period,
+				pfi.brand_uuid,
+				pfi.price,
+				rrp.price AS rrp
+			FROM pos_form_items pfi
+			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
+			` + rrpJoin + `
+			WHERE ` + priceFilter + `
+		)
+		SELECT
+			l.period                                                 AS period,
+			b.name                                                   AS brand_name,
+			b.uuid                                                   AS brand_uuid,
+			COUNT(*)::bigint                                         AS priced_lines,
+			ROUND(AVG(l.price)::numeric, 2)::float8                  AS avg_price,
+			ROUND(MIN(l.price)::numeric, 2)::float8                  AS min_price,
+			ROUND(MAX(l.price)::numeric, 2)::float8                  AS max_price,
+			COALESCE(ROUND(AVG(l.rrp)::numeric, 2), 0)::float8       AS avg_rrp,
+			COALESCE(ROUND((AVG(l.price) FILTER (WHERE l.rrp IS NOT NULL) * 100.0 /
+			      NULLIF(AVG(l.rrp), 0))::numeric, 2), 0)::float8    AS price_index,
+			COALESCE(ROUND((COUNT(*) FILTER (WHERE l.price > l.rrp * (1 + @tolerance / 100.0)) * 100.0 /
+			      NULLIF(COUNT(l.rrp), 0))::numeric, 2), 0)::float8  AS above_percent,
+			COALESCE(ROUND((COUNT(*) FILTER (WHERE l.price < l.rrp * (1 - @tolerance / 100.0)) * 100.0 /
+			      NULLIF(COUNT(l.rrp), 0))::numeric, 2), 0)::float8  AS below_percent
+		FROM lines l
+		INNER JOIN brands b ON b.uuid = l.brand_uuid
+		GROUP BY l.period, b.name, b.uuid
+		ORDER BY l.period, b.name
+	`
+	return serveCompliance(c, params, query, "Price compliance — Trend")
+}
@@ -0,0 +1,131 @@
+package dashboard
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// queryLog is a database/sql connector whose queries return no row. It
+// keeps the queries with their args.
+type queryLog struct{ queries *[]string }
+
+func (l queryLog) Connect(context.Context) (driver.Conn, error) { return l, nil }
+func (queryLog) Driver() driver.Driver                          { return nil }
+func (l queryLog) Prepare(query string) (driver.Stmt, error)    { return queryLogStmt{l, query}, nil }
+func (queryLog) Close() error                                   { return nil }
+func (l queryLog) Begin() (driver.Tx, error)                    { return l, nil }
+func (queryLog) Commit() error                                  { return nil }
+func (queryLog) Rollback() error                                { return nil }
+
+type queryLogStmt struct {
+	l     queryLog
+	query string
+}
+
+func (queryLogStmt) Close() error  { return nil }
+func (queryLogStmt) NumInput() int { return -1 }
+func (s queryLogStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (s queryLogStmt) Query(args []driver.Value) (driver.Rows, error) {
+	q := s.query
+	for i := len(args) - 1; i >= 0; i-- {
+		q = strings.ReplaceAll(q, "$"+strconv.Itoa(i+1), fmt.Sprintf("'%v'", args[i]))
+	}
+	*s.l.queries = append(*s.l.queries, q)
+	return noRows{}, nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+// logQueries swaps database.DB for a queryLog until the end of the test,
+// and returns the queries it receives.
+func logQueries(t *testing.T) *[]string {
+	t.Helper()
+	queries := &[]string{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(queryLog{queries})}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = saved })
+	return queries
+}
+
+func TestPriceCompliance(t *testing.T) {
+	queries := logQueries(t)
+	app := fiber.New()
+	app.Get("/summary", PriceComplianceSummary)
+	app.Get("/trend", PriceTrend)
+
+	get := func(target string) (int, map[string]interface{}) {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	const period = "start_date=2026-09-01&end_date=2026-09-30"
+	for _, target := range []string{
+		"/summary?end_date=2026-09-30",
+		"/summary?" + period + "&unit=carton",
+		"/summary?" + period + "&currency=dollars",
+		"/summary?" + period + "&tolerance=100",
+		"/summary?" + period + "&tolerance=-1",
+		"/trend?" + period + "&granularity=year",
+	} {
+		if status, body := get(target); status != fiber.StatusBadRequest {
+			t.Errorf("%s: %d %v, want 400", target, status, body)
+		}
+	}
+	if len(*queries) != 0 {
+		t.Fatalf("refused requests ran %q", *queries)
+	}
+
+	status, body := get("/trend?" + period + "&currency=usd&unit=pack&tolerance=10&granularity=week")
+	if status != fiber.StatusOK {
+		t.Fatalf("trend: %d %v", status, body)
+	}
+	if body["unit"] != "pack" || body["currency"] != "USD" || body["tolerance"] != 10.0 {
+		t.Errorf("trend filters = %v %v %v", body["unit"], body["currency"], body["tolerance"])
+	}
+	if len(*queries) != 1 {
+		t.Fatalf("queries = %q", *queries)
+	}
+	for _, want := range []string{
+		`TO_CHAR(pf.created_at, 'IYYY-"W"IW')`,
+		"pfi.price_unit = 'pack' AND pfi.currency = 'USD'",
+		"pf.created_at BETWEEN '2026-09-01' AND '2026-09-30 23:59:59'",
+		"rp.price_unit = pfi.price_unit AND rp.currency = pfi.currency",
+		"* (1 + '10' / 100.0)",
+	} {
+		if !strings.Contains((*queries)[0], want) {
+			t.Errorf("trend query misses %s", want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package dashboard
+
+import (
+	"fmt"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// ROUTE PLAN COMPLIANCE
+// Planned vs. actually visited POS per agent, DR, Supervisor or ASM:
+//   planned_pos       — route plan items with a plan day in the period
+//   visited_pos       — items marked visited (by a matching PosForm)
+//   missed_pos        — items of a past plan day still not visited
+//   strike_rate       — visited_pos / planned_pos
+//   unplanned_visits  — PosForms of the period that fulfil no plan item
+// The hierarchy of a planned item is the one of its POS.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// complianceKeys maps the level to the member column of route plan items
+// (through pos p / route_plans rp) and of pos_forms pf.
+var complianceKeys = map[string][2]string{
+	"agent":      {"rp.user_uuid", "pf.user_uuid"},
+	"dr":         {"p.dr_uuid", "pf.dr_uuid"},
+	"supervisor": {"p.sup_uuid", "pf.sup_uuid"},
+	"asm":        {"p.asm_uuid", "pf.asm_uuid"},
+}
+
+// RouteComplianceReport returns the route plan compliance of each member of
+// the requested level (agent | dr | supervisor | asm, default agent).
+func RouteComplianceReport(c *fiber.Ctx) error {
+	db := database.DB
+
+	country_uuid := c.Query("country_uuid")
+	province_uuid := c.Query("province_uuid")
+	area_uuid := c.Query("area_uuid")
+	sub_area_uuid := c.Query("sub_area_uuid")
+	commune_uuid := c.Query("commune_uuid")
+	start_date := c.Query("start_date")
+	end_date := c.Query("end_date")
+	level := c.Query("level", "agent")
+
+	if start_date == "" || end_date == "" || country_uuid == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "country_uuid, start_date and end_date are required",
+		})
+	}
+
+	keys, ok := complianceKeys[level]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "level must be one of agent, dr, supervisor, asm",
+		})
+	}
+
+	type Result struct {
+		MemberUUID      string  `json:"member_uuid"`
+		MemberName      string  `json:"member_name"`
+		Title           string  `json:"title"`
+		PlannedPos      int64   `json:"planned_pos"`
+		VisitedPos      int64   `json:"visited_pos"`
+		MissedPos       int64   `json:"missed_pos"`
+		StrikeRate      float64 `json:"strike_rate"`
+		TotalVisits     int64   `json:"total_visits"`
+		UnplannedVisits int64   `json:"unplanned_visits"`
+		UnplannedPct    float64 `json:"unplanned_pct"`
+	}
+
+	sqlQuery := fmt.Sprintf(`
+		WITH planned AS (
+			SELECT
+				%[1]s AS member_uuid,
+				COUNT(rpi.uuid) AS planned_pos,
+				COUNT(rpi.uuid) FILTER (WHERE rpi.status = true) AS visited_pos,
+				COUNT(rpi.uuid) FILTER (
+					WHERE rpi.status = false
+					  AND COALESCE(rp.plan_date, DATE(rp.created_at)) < CURRENT_DATE
+				) AS missed_pos
+			FROM route_plan_items rpi
+			INNER JOIN route_plans rp ON rp.uuid = rpi.route_plan_uuid
+			INNER JOIN pos p          ON p.uuid  = rpi.pos_uuid
+			WHERE rp.country_uuid = @country_uuid
+			  AND (@province_uuid = '' OR rp.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR rp.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR rp.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR rp.commune_uuid  = @commune_uuid)
+			  AND COALESCE(rp.plan_date, DATE(rp.created_at)) BETWEEN CAST(@start_date AS date) AND CAST(@end_date AS date)
+			  AND rp.status = 'published'
+			  AND rp.deleted_at IS NULL AND rpi.deleted_at IS NULL
+			GROUP BY 1
+		),
+		visits AS (
+			SELECT
+				%[2]s AS member_uuid,
+				COUNT(pf.uuid) AS total_visits,
+				COUNT(pf.uuid) FILTER (WHERE pf.route_plan_uuid = '') AS unplanned_visits
+			FROM pos_forms pf
+			WHERE pf.country_uuid = @country_uuid
+			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
+			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
+			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
+			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
+			  AND pf.created_at::date BETWEEN CAST(@start_date AS date) AND CAST(@end_date AS date)
+			  AND pf.deleted_at IS NULL
+			GROUP BY 1
+		)
+		SELECT
+			COALESCE(pl.member_uuid, v.member_uuid)                 AS member_uuid,
+			COALESCE(u.fullname, '')                                 AS member_name,
+			COALESCE(u.title, '')                                    AS title,
+			COALESCE(pl.planned_pos, 0)                              AS planned_pos,
+			COALESCE(pl.visited_pos, 0)                              AS visited_pos,
+			COALESCE(pl.missed_pos, 0)                               AS missed_pos,
+			COALESCE(ROUND(pl.visited_pos * 100.0 / NULLIF(pl.planned_pos, 0), 2), 0)       AS strike_rate,
+			COALESCE(v.total_visits, 0)                              AS total_visits,
+			COALESCE(v.unplanned_visits, 0)                          AS unplanned_visits,
+			COALESCE(ROUND(v.unplanned_visits * 100.0 / NULLIF(v.total_visits, 0), 2), 0)   AS unplanned_pct
+		FROM planned pl
+		FULL OUTER JOIN visits v ON v.member_uuid = pl.member_uuid
+		LEFT JOIN users u ON u.uuid = COALESCE(pl.member_uuid, v.member_uuid)
+		WHERE COALESCE(pl.member_uuid, v.member_uuid) <> ''
+		ORDER BY strike_rate DESC, member_name
+	`, keys[0], keys[1])
+
+	params := map[string]interface{}{
+		"country_uuid":  country_uuid,
+		"province_uuid": province_uuid,
+		"area_uuid":     area_uuid,
+		"sub_area_uuid": sub_area_uuid,
+		"commune_uuid":  commune_uuid,
+		"start_date":    start_date,
+		"end_date":      end_date,
+	}
+
+	var results []Result
+	if err := db.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to fetch route compliance", "error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"status": "success", "message": "Route plan compliance", "level": level, "data": results})
+}
@@ -2,6 +2,7 @@ package dashboard
 
 import (
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -20,675 +21,284 @@ import (
 
 // ─────────────────────────────────────────────────────────────────────────────
 // SECTION 1 — SALES BY POS TYPE : Table Views
-// Breaks down volume (number_farde), sold quantity, revenue (pos_forms.price)
-// and computes market share per POS type within a geographic scope.
+// Breaks down volume (number_farde), sold quantity, declared revenue
+// (pos_forms.price, once per visit) and computes market share per POS type
+// within a geographic scope. Read from the all-brands rows of
+// daily_pos_facts; the POS type is that of the POS at the visit.
 // ─────────────────────────────────────────────────────────────────────────────
 
-// TypePosTableProvince — Province-level sales breakdown by POS type
-func TypePosTableProvince(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
+// salesParams reads the params of the sales views served from the daily
+// facts. It returns the message of the bad request when they are missing
+// or invalid.
+func salesParams(c *fiber.Ctx) (map[string]interface{}, string) {
+	params := metricParams(c)
+	if params == nil {
+		return nil, "country_uuid, start_date and end_date are required"
+	}
+	if !validSegment(params["segment"]) {
+		return nil, "invalid segment; use A|B|C"
+	}
+	return params, ""
+}
 
-	if start_date == "" || end_date == "" {
+// salesBrandDays is the CTE brand_days: the brand rows of daily_pos_facts
+// within the filters, with visit_revenue, the declared price of the visits
+// of the day which only the all-brands row holds.
+const salesBrandDays = `brand_days AS (
+			SELECT fb.*, f.revenue AS visit_revenue
+			FROM daily_pos_facts f
+			INNER JOIN daily_pos_facts fb
+			        ON fb.day = f.day AND fb.pos_uuid = f.pos_uuid
+			       AND fb.user_uuid = f.user_uuid AND fb.brand_uuid <> ''
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid = ''
+			  AND (@brand_uuid = '' OR fb.brand_uuid = @brand_uuid)
+		)`
+
+// typePosTable serves the sales of the territories of level, one row per
+// territory × POS type. Market shares are of the whole filtered scope.
+func typePosTable(c *fiber.Ctx, level, name, message string) error {
+	params, msg := salesParams(c)
+	if msg != "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
+			"status": "error", "message": msg,
 		})
 	}
 
-	type Result struct {
-		ProvinceName     string  `json:"province_name"`
-		ProvinceUUID     string  `json:"province_uuid"`
-		PosType          string  `json:"pos_type"`
-		TotalVisits      int64   `json:"total_visits"`
-		TotalPos         int64   `json:"total_pos"`
-		TotalFarde       float64 `json:"total_farde"`
-		TotalSold        float64 `json:"total_sold"`
-		TotalRevenue     float64 `json:"total_revenue"`
-		AvgFardePerVisit float64 `json:"avg_farde_per_visit"`
-		AvgSoldPerVisit  float64 `json:"avg_sold_per_visit"`
-		MarketShareFarde float64 `json:"market_share_farde"`
-		MarketShareSold  float64 `json:"market_share_sold"`
-	}
-
+	lv := metricLevels[level]
 	sqlQuery := `
-		WITH global AS (
+		WITH days AS (
+			SELECT f.*
+			FROM daily_pos_facts f
+			WHERE ` + metricFactFilter + `
+			  AND f.brand_uuid = ''
+		),
+		global AS (
 			SELECT
-				COALESCE(SUM(pfi.number_farde), 0) AS g_farde,
-				COALESCE(SUM(pfi.sold), 0)          AS g_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+				COALESCE(SUM(fardes), 0) AS g_farde,
+				COALESCE(SUM(sold), 0)   AS g_sold
+			FROM days
 		)
 		SELECT
-			pr.name                                          AS province_name,
-			pr.uuid                                          AS province_uuid,
-			COALESCE(NULLIF(p.postype, ''), 'Non défini')   AS pos_type,
-			COUNT(DISTINCT pf.uuid)                          AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                      AS total_pos,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)         AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                 AS total_sold,
-			ROUND(SUM(pf.price)::numeric, 2)                 AS total_revenue,
-			ROUND((SUM(pfi.number_farde) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2) AS avg_farde_per_visit,
-			ROUND((SUM(pfi.sold) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2)         AS avg_sold_per_visit,
-			ROUND((SUM(pfi.number_farde) * 100.0 / NULLIF((SELECT g_farde FROM global), 0))::numeric, 2) AS market_share_farde,
-			ROUND((SUM(pfi.sold) * 100.0 / NULLIF((SELECT g_sold FROM global), 0))::numeric, 2)          AS market_share_sold
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN pos p        ON pf.pos_uuid = p.uuid
-		INNER JOIN provinces pr ON pf.province_uuid = pr.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY pr.name, pr.uuid, p.postype
+			t.name                                          AS ` + name + `_name,
+			t.uuid                                          AS ` + name + `_uuid,
+			COALESCE(NULLIF(d.postype, ''), 'Non défini')  AS pos_type,
+			SUM(d.visits)::bigint                           AS total_visits,
+			COUNT(DISTINCT d.pos_uuid)::bigint              AS total_pos,
+			ROUND(SUM(d.fardes)::numeric, 2)::float8        AS total_farde,
+			ROUND(SUM(d.sold)::numeric, 2)::float8          AS total_sold,
+			ROUND(SUM(d.revenue)::numeric, 2)::float8       AS total_revenue,
+			ROUND((SUM(d.fardes) / NULLIF(SUM(d.visits), 0))::numeric, 2)::float8 AS avg_farde_per_visit,
+			ROUND((SUM(d.sold) / NULLIF(SUM(d.visits), 0))::numeric, 2)::float8   AS avg_sold_per_visit,
+			ROUND((SUM(d.fardes) * 100.0 / NULLIF((SELECT g_farde FROM global), 0))::numeric, 2)::float8 AS market_share_farde,
+			ROUND((SUM(d.sold) * 100.0 / NULLIF((SELECT g_sold FROM global), 0))::numeric, 2)::float8    AS market_share_sold
+		FROM days d
+		INNER JOIN ` + lv.Table + ` t ON t.uuid = d.` + lv.Column + `
+		GROUP BY t.name, t.uuid, pos_type
 		ORDER BY total_farde DESC;
 	`
 
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, start_date, end_date,
-		country_uuid, province_uuid, start_date, end_date,
-	).Scan(&results).Error
-
-	if err != nil {
+	results := []metricRow{}
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Sales by POS type — Province", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": message, "data": results})
+}
+
+// TypePosTableProvince — Province-level sales breakdown by POS type
+func TypePosTableProvince(c *fiber.Ctx) error {
+	return typePosTable(c, "province", "province", "Sales by POS type — Province")
 }
 
 // TypePosTableArea — Area-level sales breakdown by POS type
 func TypePosTableArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
-		})
-	}
-
-	type Result struct {
-		AreaName         string  `json:"area_name"`
-		AreaUUID         string  `json:"area_uuid"`
-		PosType          string  `json:"pos_type"`
-		TotalVisits      int64   `json:"total_visits"`
-		TotalPos         int64   `json:"total_pos"`
-		TotalFarde       float64 `json:"total_farde"`
-		TotalSold        float64 `json:"total_sold"`
-		TotalRevenue     float64 `json:"total_revenue"`
-		AvgFardePerVisit float64 `json:"avg_farde_per_visit"`
-		AvgSoldPerVisit  float64 `json:"avg_sold_per_visit"`
-		MarketShareFarde float64 `json:"market_share_farde"`
-		MarketShareSold  float64 `json:"market_share_sold"`
-	}
-
-	sqlQuery := `
-		WITH global AS (
-			SELECT
-				COALESCE(SUM(pfi.number_farde), 0) AS g_farde,
-				COALESCE(SUM(pfi.sold), 0)          AS g_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		)
-		SELECT
-			a.name                                          AS area_name,
-			a.uuid                                          AS area_uuid,
-			COALESCE(NULLIF(p.postype, ''), 'Non défini')  AS pos_type,
-			COUNT(DISTINCT pf.uuid)                         AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                     AS total_pos,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)        AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                AS total_sold,
-			ROUND(SUM(pf.price)::numeric, 2)                AS total_revenue,
-			ROUND((SUM(pfi.number_farde) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2) AS avg_farde_per_visit,
-			ROUND((SUM(pfi.sold) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2)         AS avg_sold_per_visit,
-			ROUND((SUM(pfi.number_farde) * 100.0 / NULLIF((SELECT g_farde FROM global), 0))::numeric, 2) AS market_share_farde,
-			ROUND((SUM(pfi.sold) * 100.0 / NULLIF((SELECT g_sold FROM global), 0))::numeric, 2)          AS market_share_sold
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN pos p        ON pf.pos_uuid = p.uuid
-		INNER JOIN areas a      ON pf.area_uuid = a.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY a.name, a.uuid, p.postype
-		ORDER BY total_farde DESC;
-	`
-
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, area_uuid, start_date, end_date,
-		country_uuid, province_uuid, area_uuid, start_date, end_date,
-	).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Sales by POS type — Area", "data": results})
+	return typePosTable(c, "area", "area", "Sales by POS type — Area")
 }
 
 // TypePosTableSubArea — SubArea-level sales breakdown by POS type
 func TypePosTableSubArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
-		})
-	}
-
-	type Result struct {
-		SubAreaName      string  `json:"sub_area_name"`
-		SubAreaUUID      string  `json:"sub_area_uuid"`
-		PosType          string  `json:"pos_type"`
-		TotalVisits      int64   `json:"total_visits"`
-		TotalPos         int64   `json:"total_pos"`
-		TotalFarde       float64 `json:"total_farde"`
-		TotalSold        float64 `json:"total_sold"`
-		TotalRevenue     float64 `json:"total_revenue"`
-		AvgFardePerVisit float64 `json:"avg_farde_per_visit"`
-		AvgSoldPerVisit  float64 `json:"avg_sold_per_visit"`
-		MarketShareFarde float64 `json:"market_share_farde"`
-		MarketShareSold  float64 `json:"market_share_sold"`
-	}
-
-	sqlQuery := `
-		WITH global AS (
-			SELECT
-				COALESCE(SUM(pfi.number_farde), 0) AS g_farde,
-				COALESCE(SUM(pfi.sold), 0)          AS g_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ? AND pf.sub_area_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		)
-		SELECT
-			sa.name                                          AS sub_area_name,
-			sa.uuid                                          AS sub_area_uuid,
-			COALESCE(NULLIF(p.postype, ''), 'Non défini')   AS pos_type,
-			COUNT(DISTINCT pf.uuid)                          AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                      AS total_pos,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)         AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                 AS total_sold,
-			ROUND(SUM(pf.price)::numeric, 2)                 AS total_revenue,
-			ROUND((SUM(pfi.number_farde) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2) AS avg_farde_per_visit,
-			ROUND((SUM(pfi.sold) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2)         AS avg_sold_per_visit,
-			ROUND((SUM(pfi.number_farde) * 100.0 / NULLIF((SELECT g_farde FROM global), 0))::numeric, 2) AS market_share_farde,
-			ROUND((SUM(pfi.sold) * 100.0 / NULLIF((SELECT g_sold FROM global), 0))::numeric, 2)          AS market_share_sold
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf  ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN pos p         ON pf.pos_uuid = p.uuid
-		INNER JOIN sub_areas sa  ON pf.sub_area_uuid = sa.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ? AND pf.sub_area_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY sa.name, sa.uuid, p.postype
-		ORDER BY total_farde DESC;
-	`
-
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, start_date, end_date,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, start_date, end_date,
-	).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Sales by POS type — SubArea", "data": results})
+	return typePosTable(c, "subarea", "sub_area", "Sales by POS type — SubArea")
 }
 
 // TypePosTableCommune — Commune-level sales breakdown by POS type
 func TypePosTableCommune(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
-		})
-	}
-
-	type Result struct {
-		CommuneName      string  `json:"commune_name"`
-		CommuneUUID      string  `json:"commune_uuid"`
-		PosType          string  `json:"pos_type"`
-		TotalVisits      int64   `json:"total_visits"`
-		TotalPos         int64   `json:"total_pos"`
-		TotalFarde       float64 `json:"total_farde"`
-		TotalSold        float64 `json:"total_sold"`
-		TotalRevenue     float64 `json:"total_revenue"`
-		AvgFardePerVisit float64 `json:"avg_farde_per_visit"`
-		AvgSoldPerVisit  float64 `json:"avg_sold_per_visit"`
-		MarketShareFarde float64 `json:"market_share_farde"`
-		MarketShareSold  float64 `json:"market_share_sold"`
-	}
-
-	sqlQuery := `
-		WITH global AS (
-			SELECT
-				COALESCE(SUM(pfi.number_farde), 0) AS g_farde,
-				COALESCE(SUM(pfi.sold), 0)          AS g_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-			  AND pf.sub_area_uuid = ? AND pf.commune_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		)
-		SELECT
-			co.name                                          AS commune_name,
-			co.uuid                                          AS commune_uuid,
-			COALESCE(NULLIF(p.postype, ''), 'Non défini')   AS pos_type,
-			COUNT(DISTINCT pf.uuid)                          AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                      AS total_pos,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)         AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                 AS total_sold,
-			ROUND(SUM(pf.price)::numeric, 2)                 AS total_revenue,
-			ROUND((SUM(pfi.number_farde) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2) AS avg_farde_per_visit,
-			ROUND((SUM(pfi.sold) / NULLIF(COUNT(DISTINCT pf.uuid), 0))::numeric, 2)         AS avg_sold_per_visit,
-			ROUND((SUM(pfi.number_farde) * 100.0 / NULLIF((SELECT g_farde FROM global), 0))::numeric, 2) AS market_share_farde,
-			ROUND((SUM(pfi.sold) * 100.0 / NULLIF((SELECT g_sold FROM global), 0))::numeric, 2)          AS market_share_sold
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf  ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN pos p         ON pf.pos_uuid = p.uuid
-		INNER JOIN communes co   ON pf.commune_uuid = co.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-		  AND pf.sub_area_uuid = ? AND pf.commune_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY co.name, co.uuid, p.postype
-		ORDER BY total_farde DESC;
-	`
-
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, start_date, end_date,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, start_date, end_date,
-	).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Sales by POS type — Commune", "data": results})
+	return typePosTable(c, "commune", "commune", "Sales by POS type — Commune")
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
 // SECTION 2 — PRICE ANALYSIS : Table Views
-// Analyses price distribution (avg, min, max, revenue) per brand across
-// geographic levels. Useful to spot pricing anomalies and coverage gaps.
+// Analyses the shelf price captured on each brand line (avg, min, max,
+// revenue = price × sold) per brand across geographic levels, against the
+// recommended retail price (RRP) of the visit. Prices are compared in one
+// unit (unit=farde|pack, farde by default) and reported in one currency
+// (currency=, the currency of the country by default), converted at the
+// rate of the visit date; lines priced in another unit, not priced, or
+// without exchange rate are left out of the price columns. Useful to spot
+// pricing anomalies and coverage gaps. Read from pos_form_items, as the
+// daily facts keep no line price.
 // ─────────────────────────────────────────────────────────────────────────────
 
-// PriceTableProvince — Province-level price analysis per brand
-func PriceTableProvince(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
-		})
+// priceParams reads the filters of the price views, or nil without a
+// period.
+func priceParams(c *fiber.Ctx) (map[string]interface{}, error) {
+	if c.Query("start_date") == "" || c.Query("end_date") == "" {
+		return nil, nil
 	}
-
-	type Result struct {
-		ProvinceName string  `json:"province_name"`
-		ProvinceUUID string  `json:"province_uuid"`
-		BrandName    string  `json:"brand_name"`
-		TotalVisits  int64   `json:"total_visits"`
-		TotalPos     int64   `json:"total_pos"`
-		TotalRevenue float64 `json:"total_revenue"`
-		AvgPrice     float64 `json:"avg_price"`
-		MinPrice     float64 `json:"min_price"`
-		MaxPrice     float64 `json:"max_price"`
-		TotalFarde   float64 `json:"total_farde"`
-		TotalSold    float64 `json:"total_sold"`
-		RevenueShare float64 `json:"revenue_share"`
+	unit, err := utils.NormalizePriceUnit(c.Query("unit"))
+	if err != nil {
+		return nil, err
 	}
+	currency, err := utils.ResolveCurrency(database.DB, c.Query("currency"), c.Query("country_uuid"))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"country_uuid":  c.Query("country_uuid"),
+		"province_uuid": c.Query("province_uuid"),
+		"area_uuid":     c.Query("area_uuid"),
+		"sub_area_uuid": c.Query("sub_area_uuid"),
+		"commune_uuid":  c.Query("commune_uuid"),
+		"brand_uuid":    c.Query("brand_uuid"),
+		"start_date":    c.Query("start_date"),
+		"end_date":      c.Query("end_date") + " 23:59:59",
+		"unit":          unit,
+		"currency":      currency,
+	}, nil
+}
 
-	sqlQuery := `
-		WITH global_rev AS (
-			SELECT COALESCE(SUM(pf.price), 0) AS g_rev
-			FROM pos_forms pf
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL
-		)
-		SELECT
-			pr.name                                              AS province_name,
-			pr.uuid                                              AS province_uuid,
-			b.name                                               AS brand_name,
-			COUNT(DISTINCT pf.uuid)                              AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                          AS total_pos,
-			ROUND(SUM(pf.price)::numeric, 2)                     AS total_revenue,
-			ROUND(AVG(pf.price)::numeric, 2)                     AS avg_price,
-			ROUND(MIN(pf.price)::numeric, 2)                     AS min_price,
-			ROUND(MAX(pf.price)::numeric, 2)                     AS max_price,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)             AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                     AS total_sold,
-			ROUND((SUM(pf.price) * 100.0 / NULLIF((SELECT g_rev FROM global_rev), 0))::numeric, 2) AS revenue_share
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf  ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN brands b      ON pfi.brand_uuid = b.uuid
-		INNER JOIN provinces pr  ON pf.province_uuid = pr.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY pr.name, pr.uuid, b.name
-		ORDER BY total_revenue DESC;
-	`
+// pricedLine selects the lines of pos_form_items pfi priced in the unit
+// and currency of the view.
+const pricedLine = `pfi.price > 0 AND pfi.price_unit = @unit AND pfi.currency = @currency`
 
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, start_date, end_date,
-		country_uuid, province_uuid, start_date, end_date,
-	).Scan(&results).Error
+// lineFX adds fx.rate, the rate converting the price of the line pfi of
+// the visit pf to the currency of the view.
+var lineFX = utils.FXJoin("fx", "pfi.currency", "pf.created_at::date", "@currency")
+
+// visitFX adds fx.rate, the rate converting the price of the visit pf to
+// the currency of the view, bound to the single ? of the join.
+var visitFX = utils.FXJoin("fx", utils.FormCurrencySQL("pf"), "pf.created_at::date", "?")
 
+// convertedLine selects the lines of pos_form_items pfi priced in the unit
+// of the view and convertible to its currency.
+const convertedLine = `pfi.price > 0 AND pfi.price_unit = @unit AND fx.rate IS NOT NULL`
+
+// priceTable serves the price analysis of the territories of level, one
+// row per territory × brand. The view is restricted to the territory of
+// each wider level, and of level itself, as passed in the params.
+func priceTable(c *fiber.Ctx, level, name, message string) error {
+	params, err := priceParams(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "Invalid price filters", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Price analysis — Province", "data": results})
-}
-
-// PriceTableArea — Area-level price analysis per brand
-func PriceTableArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
+	if params == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status": "error", "message": "start_date and end_date are required",
 		})
 	}
 
-	type Result struct {
-		AreaName     string  `json:"area_name"`
-		AreaUUID     string  `json:"area_uuid"`
-		BrandName    string  `json:"brand_name"`
-		TotalVisits  int64   `json:"total_visits"`
-		TotalPos     int64   `json:"total_pos"`
-		TotalRevenue float64 `json:"total_revenue"`
-		AvgPrice     float64 `json:"avg_price"`
-		MinPrice     float64 `json:"min_price"`
-		MaxPrice     float64 `json:"max_price"`
-		TotalFarde   float64 `json:"total_farde"`
-		TotalSold    float64 `json:"total_sold"`
-		RevenueShare float64 `json:"revenue_share"`
+	lv := metricLevels[level]
+	where := ""
+	for _, column := range []string{"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid"} {
+		where += " AND pf." + column + " = @" + column
+		if column == lv.Column {
+			break
+		}
 	}
 
 	sqlQuery := `
 		WITH global_rev AS (
-			SELECT COALESCE(SUM(pf.price), 0) AS g_rev
-			FROM pos_forms pf
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL
+			SELECT COALESCE(SUM(pfi.price * fx.rate * pfi.sold), 0) AS g_rev
+			FROM pos_form_items pfi
+			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
+			` + lineFX + `
+			WHERE pf.created_at BETWEEN @start_date AND @end_date` + where + `
+			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+			  AND ` + convertedLine + `
 		)
 		SELECT
-			a.name                                               AS area_name,
-			a.uuid                                               AS area_uuid,
+			t.name                                               AS ` + name + `_name,
+			t.uuid                                               AS ` + name + `_uuid,
 			b.name                                               AS brand_name,
-			COUNT(DISTINCT pf.uuid)                              AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                          AS total_pos,
-			ROUND(SUM(pf.price)::numeric, 2)                     AS total_revenue,
-			ROUND(AVG(pf.price)::numeric, 2)                     AS avg_price,
-			ROUND(MIN(pf.price)::numeric, 2)                     AS min_price,
-			ROUND(MAX(pf.price)::numeric, 2)                     AS max_price,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)             AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                     AS total_sold,
-			ROUND((SUM(pf.price) * 100.0 / NULLIF((SELECT g_rev FROM global_rev), 0))::numeric, 2) AS revenue_share
+			COUNT(DISTINCT pf.uuid)::bigint                      AS total_visits,
+			COUNT(DISTINCT pf.pos_uuid)::bigint                  AS total_pos,
+			COUNT(DISTINCT pf.pos_uuid) FILTER (WHERE ` + convertedLine + `)::bigint AS priced_pos,
+			COUNT(*) FILTER (WHERE pfi.price > 0 AND pfi.price_unit = @unit AND fx.rate IS NULL)::bigint AS unconverted_lines,
+			ROUND(COALESCE(SUM(pfi.price * fx.rate * pfi.sold) FILTER (WHERE ` + convertedLine + `), 0)::numeric, 2)::float8 AS total_revenue,
+			ROUND(COALESCE(AVG(pfi.price * fx.rate) FILTER (WHERE ` + convertedLine + `), 0)::numeric, 2)::float8 AS avg_price,
+			ROUND(COALESCE(MIN(pfi.price * fx.rate) FILTER (WHERE ` + convertedLine + `), 0)::numeric, 2)::float8 AS min_price,
+			ROUND(COALESCE(MAX(pfi.price * fx.rate) FILTER (WHERE ` + convertedLine + `), 0)::numeric, 2)::float8 AS max_price,
+			ROUND(COALESCE(AVG(rrp.price * fx.rate) FILTER (WHERE ` + convertedLine + `), 0)::numeric, 2)::float8 AS avg_rrp,
+			ROUND(COALESCE(AVG(pfi.price * fx.rate) FILTER (WHERE ` + convertedLine + ` AND rrp.price IS NOT NULL) * 100.0 /
+			      NULLIF(AVG(rrp.price * fx.rate) FILTER (WHERE ` + convertedLine + `), 0), 0)::numeric, 2)::float8 AS price_index,
+			ROUND(SUM(pfi.number_farde)::numeric, 2)::float8     AS total_farde,
+			ROUND(SUM(pfi.sold)::numeric, 2)::float8             AS total_sold,
+			ROUND(COALESCE(SUM(pfi.price * fx.rate * pfi.sold) FILTER (WHERE ` + convertedLine + `) * 100.0 /
+			      NULLIF((SELECT g_rev FROM global_rev), 0), 0)::numeric, 2)::float8 AS revenue_share
 		FROM pos_form_items pfi
 		INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
 		INNER JOIN brands b     ON pfi.brand_uuid = b.uuid
-		INNER JOIN areas a      ON pf.area_uuid = a.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
+		INNER JOIN ` + lv.Table + ` t ON pf.` + lv.Column + ` = t.uuid
+		` + rrpJoin + `
+		` + lineFX + `
+		WHERE pf.created_at BETWEEN @start_date AND @end_date` + where + `
 		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY a.name, a.uuid, b.name
+		GROUP BY t.name, t.uuid, b.name
 		ORDER BY total_revenue DESC;
 	`
 
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, area_uuid, start_date, end_date,
-		country_uuid, province_uuid, area_uuid, start_date, end_date,
-	).Scan(&results).Error
-
-	if err != nil {
+	results := []metricRow{}
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Price analysis — Area", "data": results})
+	return c.JSON(fiber.Map{
+		"status": "success", "message": message, "data": results,
+		"unit": params["unit"], "currency": params["currency"],
+	})
 }
 
-// PriceTableSubArea — SubArea-level price analysis per brand
-func PriceTableSubArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
-		})
-	}
-
-	type Result struct {
-		SubAreaName  string  `json:"sub_area_name"`
-		SubAreaUUID  string  `json:"sub_area_uuid"`
-		BrandName    string  `json:"brand_name"`
-		TotalVisits  int64   `json:"total_visits"`
-		TotalPos     int64   `json:"total_pos"`
-		TotalRevenue float64 `json:"total_revenue"`
-		AvgPrice     float64 `json:"avg_price"`
-		MinPrice     float64 `json:"min_price"`
-		MaxPrice     float64 `json:"max_price"`
-		TotalFarde   float64 `json:"total_farde"`
-		TotalSold    float64 `json:"total_sold"`
-		RevenueShare float64 `json:"revenue_share"`
-	}
-
-	sqlQuery := `
-		WITH global_rev AS (
-			SELECT COALESCE(SUM(pf.price), 0) AS g_rev
-			FROM pos_forms pf
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ? AND pf.sub_area_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL
-		)
-		SELECT
-			sa.name                                              AS sub_area_name,
-			sa.uuid                                              AS sub_area_uuid,
-			b.name                                               AS brand_name,
-			COUNT(DISTINCT pf.uuid)                              AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                          AS total_pos,
-			ROUND(SUM(pf.price)::numeric, 2)                     AS total_revenue,
-			ROUND(AVG(pf.price)::numeric, 2)                     AS avg_price,
-			ROUND(MIN(pf.price)::numeric, 2)                     AS min_price,
-			ROUND(MAX(pf.price)::numeric, 2)                     AS max_price,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)             AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                     AS total_sold,
-			ROUND((SUM(pf.price) * 100.0 / NULLIF((SELECT g_rev FROM global_rev), 0))::numeric, 2) AS revenue_share
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf  ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN brands b      ON pfi.brand_uuid = b.uuid
-		INNER JOIN sub_areas sa  ON pf.sub_area_uuid = sa.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ? AND pf.sub_area_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY sa.name, sa.uuid, b.name
-		ORDER BY total_revenue DESC;
-	`
+// PriceTableProvince — Province-level price analysis per brand
+func PriceTableProvince(c *fiber.Ctx) error {
+	return priceTable(c, "province", "province", "Price analysis — Province")
+}
 
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, start_date, end_date,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, start_date, end_date,
-	).Scan(&results).Error
+// PriceTableArea — Area-level price analysis per brand
+func PriceTableArea(c *fiber.Ctx) error {
+	return priceTable(c, "area", "area", "Price analysis — Area")
+}
 
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Price analysis — SubArea", "data": results})
+// PriceTableSubArea — SubArea-level price analysis per brand
+func PriceTableSubArea(c *fiber.Ctx) error {
+	return priceTable(c, "subarea", "sub_area", "Price analysis — SubArea")
 }
 
 // PriceTableCommune — Commune-level price analysis per brand
 func PriceTableCommune(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
-		})
-	}
-
-	type Result struct {
-		CommuneName  string  `json:"commune_name"`
-		CommuneUUID  string  `json:"commune_uuid"`
-		BrandName    string  `json:"brand_name"`
-		TotalVisits  int64   `json:"total_visits"`
-		TotalPos     int64   `json:"total_pos"`
-		TotalRevenue float64 `json:"total_revenue"`
-		AvgPrice     float64 `json:"avg_price"`
-		MinPrice     float64 `json:"min_price"`
-		MaxPrice     float64 `json:"max_price"`
-		TotalFarde   float64 `json:"total_farde"`
-		TotalSold    float64 `json:"total_sold"`
-		RevenueShare float64 `json:"revenue_share"`
-	}
-
-	sqlQuery := `
-		WITH global_rev AS (
-			SELECT COALESCE(SUM(pf.price), 0) AS g_rev
-			FROM pos_forms pf
-			WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-			  AND pf.sub_area_uuid = ? AND pf.commune_uuid = ?
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL
-		)
-		SELECT
-			co.name                                              AS commune_name,
-			co.uuid                                              AS commune_uuid,
-			b.name                                               AS brand_name,
-			COUNT(DISTINCT pf.uuid)                              AS total_visits,
-			COUNT(DISTINCT pf.pos_uuid)                          AS total_pos,
-			ROUND(SUM(pf.price)::numeric, 2)                     AS total_revenue,
-			ROUND(AVG(pf.price)::numeric, 2)                     AS avg_price,
-			ROUND(MIN(pf.price)::numeric, 2)                     AS min_price,
-			ROUND(MAX(pf.price)::numeric, 2)                     AS max_price,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)             AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                     AS total_sold,
-			ROUND((SUM(pf.price) * 100.0 / NULLIF((SELECT g_rev FROM global_rev), 0))::numeric, 2) AS revenue_share
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf  ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN brands b      ON pfi.brand_uuid = b.uuid
-		INNER JOIN communes co   ON pf.commune_uuid = co.uuid
-		WHERE pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?
-		  AND pf.sub_area_uuid = ? AND pf.commune_uuid = ?
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY co.name, co.uuid, b.name
-		ORDER BY total_revenue DESC;
-	`
-
-	var results []Result
-	err := db.Raw(sqlQuery,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, start_date, end_date,
-		country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, start_date, end_date,
-	).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch data", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Price analysis — Commune", "data": results})
+	return priceTable(c, "commune", "commune", "Price analysis — Commune")
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
 // SECTION 3 — MONTHLY SALES EVOLUTION LINE CHART
 // Returns one row per (month, brand) with farde, sold, revenue and MoM growth.
-// Perfect for a multi-series time-series chart on the frontend.
+// Perfect for a multi-series time-series chart on the frontend. Sections 3,
+// 4, 5 and 8 read the brand rows of daily_pos_facts; the revenue of a brand
+// is the declared price of the visits having a line of the brand.
 // Query params: country_uuid, province_uuid?, area_uuid?, sub_area_uuid?,
-//               commune_uuid?, brand_uuid?, start_date, end_date
+//               commune_uuid?, brand_uuid?, segment?, start_date, end_date
 // ─────────────────────────────────────────────────────────────────────────────
 
 func SalesEvolutionByMonth(c *fiber.Ctx) error {
 	db := database.DB
 
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	brand_uuid := c.Query("brand_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" {
+	params, msg := salesParams(c)
+	if msg != "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
+			"status": "error", "message": msg,
 		})
 	}
 
@@ -704,48 +314,19 @@ func SalesEvolutionByMonth(c *fiber.Ctx) error {
 		GrowthSold   float64 `json:"growth_sold_pct"`
 	}
 
-	// Build dynamic WHERE clauses for optional filters
-	filters := "pf.country_uuid = @country_uuid AND pf.created_at BETWEEN @start_date AND @end_date AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL"
-	params := map[string]interface{}{
-		"country_uuid": country_uuid,
-		"start_date":   start_date,
-		"end_date":     end_date,
-	}
-	if province_uuid != "" {
-		filters += " AND pf.province_uuid = @province_uuid"
-		params["province_uuid"] = province_uuid
-	}
-	if area_uuid != "" {
-		filters += " AND pf.area_uuid = @area_uuid"
-		params["area_uuid"] = area_uuid
-	}
-	if sub_area_uuid != "" {
-		filters += " AND pf.sub_area_uuid = @sub_area_uuid"
-		params["sub_area_uuid"] = sub_area_uuid
-	}
-	if commune_uuid != "" {
-		filters += " AND pf.commune_uuid = @commune_uuid"
-		params["commune_uuid"] = commune_uuid
-	}
-	if brand_uuid != "" {
-		filters += " AND pfi.brand_uuid = @brand_uuid"
-		params["brand_uuid"] = brand_uuid
-	}
-
 	sqlQuery := `
-		WITH monthly AS (
+		WITH ` + salesBrandDays + `,
+		monthly AS (
 			SELECT
-				TO_CHAR(pf.created_at, 'YYYY-MM')           AS year_month,
-				b.name                                        AS brand_name,
-				COUNT(DISTINCT pf.uuid)                       AS total_visits,
-				COUNT(DISTINCT pf.pos_uuid)                   AS total_pos,
-				ROUND(SUM(pfi.number_farde)::numeric, 2)      AS total_farde,
-				ROUND(SUM(pfi.sold)::numeric, 2)              AS total_sold,
-				ROUND(SUM(pf.price)::numeric, 2)              AS total_revenue
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			INNER JOIN brands b     ON pfi.brand_uuid = b.uuid
-			WHERE ` + filters + `
+				TO_CHAR(d.day, 'YYYY-MM')                  AS year_month,
+				b.name                                     AS brand_name,
+				SUM(d.visits)::bigint                      AS total_visits,
+				COUNT(DISTINCT d.pos_uuid)                 AS total_pos,
+				ROUND(SUM(d.fardes)::numeric, 2)           AS total_farde,
+				ROUND(SUM(d.sold)::numeric, 2)             AS total_sold,
+				ROUND(SUM(d.visit_revenue)::numeric, 2)    AS total_revenue
+			FROM brand_days d
+			INNER JOIN brands b ON b.uuid = d.brand_uuid
 			GROUP BY year_month, b.name
 		)
 		SELECT
@@ -779,22 +360,25 @@ func SalesEvolutionByMonth(c *fiber.Ctx) error {
 // Compares two date ranges (current vs previous) and computes absolute delta
 // and % growth per brand.  Enables instant YoY / MoM growth scorecards.
 // Query params: country_uuid, province_uuid?, area_uuid?, sub_area_uuid?,
-//               commune_uuid?, curr_start, curr_end, prev_start, prev_end
+//               commune_uuid?, segment?, curr_start, curr_end, prev_start,
+//               prev_end
 // ─────────────────────────────────────────────────────────────────────────────
 
 func SalesGrowthRate(c *fiber.Ctx) error {
 	db := database.DB
 
 	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
 	curr_start := c.Query("curr_start")
 	curr_end := c.Query("curr_end")
 	prev_start := c.Query("prev_start")
 	prev_end := c.Query("prev_end")
 
+	if country_uuid == "" || curr_start == "" || curr_end == "" || prev_start == "" || prev_end == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "country_uuid, curr_start, curr_end, prev_start and prev_end are required",
+		})
+	}
+
 	type Result struct {
 		BrandName        string  `json:"brand_name"`
 		CurrFarde        float64 `json:"curr_farde"`
@@ -814,99 +398,77 @@ func SalesGrowthRate(c *fiber.Ctx) error {
 		Trend            string  `json:"trend"` // "UP" | "DOWN" | "STABLE"
 	}
 
-	geoFilterBase := "pf.country_uuid = ?"
-	geoArgs := []interface{}{country_uuid, country_uuid}
-
-	extraFilter := ""
-	if province_uuid != "" {
-		extraFilter += " AND pf.province_uuid = ?"
-		geoArgs = append([]interface{}{country_uuid, province_uuid}, country_uuid, province_uuid)
-		geoArgs = []interface{}{country_uuid, province_uuid, country_uuid, province_uuid}
-	}
-	if area_uuid != "" {
-		extraFilter += " AND pf.area_uuid = ?"
-		geoArgs = []interface{}{country_uuid, province_uuid, area_uuid, country_uuid, province_uuid, area_uuid}
-	}
-	if sub_area_uuid != "" {
-		extraFilter += " AND pf.sub_area_uuid = ?"
-		geoArgs = []interface{}{country_uuid, province_uuid, area_uuid, sub_area_uuid, country_uuid, province_uuid, area_uuid, sub_area_uuid}
-	}
-	if commune_uuid != "" {
-		extraFilter += " AND pf.commune_uuid = ?"
-		geoArgs = []interface{}{country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid}
+	// The facts are read over the span of both periods, each sum keeping
+	// the days of its own period
+	params := map[string]interface{}{
+		"country_uuid":  country_uuid,
+		"province_uuid": c.Query("province_uuid"),
+		"area_uuid":     c.Query("area_uuid"),
+		"sub_area_uuid": c.Query("sub_area_uuid"),
+		"commune_uuid":  c.Query("commune_uuid"),
+		"brand_uuid":    "",
+		"segment":       segmentQuery(c),
+		"start_date":    min(curr_start, prev_start),
+		"end_date":      max(curr_end, prev_end),
+		"curr_start":    curr_start,
+		"curr_end":      curr_end,
+		"prev_start":    prev_start,
+		"prev_end":      prev_end,
+	}
+	if !validSegment(params["segment"]) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "invalid segment; use A|B|C",
+		})
 	}
 
-	geoWhere := geoFilterBase + extraFilter
-
-	// Inject date ranges into args
-	// Pattern: curr CTE args, prev CTE args
-	var fullArgs []interface{}
-	for _, a := range geoArgs[:len(geoArgs)/2] {
-		fullArgs = append(fullArgs, a)
-	}
-	fullArgs = append(fullArgs, curr_start, curr_end)
-	for _, a := range geoArgs[len(geoArgs)/2:] {
-		fullArgs = append(fullArgs, a)
-	}
-	fullArgs = append(fullArgs, prev_start, prev_end)
+	const curr = "d.day BETWEEN CAST(@curr_start AS date) AND CAST(@curr_end AS date)"
+	const prev = "d.day BETWEEN CAST(@prev_start AS date) AND CAST(@prev_end AS date)"
 
 	sqlQuery := `
-		WITH curr AS (
-			SELECT
-				b.name                                    AS brand_name,
-				ROUND(SUM(pfi.number_farde)::numeric, 2)  AS farde,
-				ROUND(SUM(pfi.sold)::numeric, 2)           AS sold,
-				ROUND(SUM(pf.price)::numeric, 2)           AS revenue,
-				COUNT(DISTINCT pf.uuid)                    AS visits
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			INNER JOIN brands b     ON pfi.brand_uuid = b.uuid
-			WHERE ` + geoWhere + ` AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY b.name
-		),
-		prev AS (
+		WITH ` + salesBrandDays + `,
+		periods AS (
 			SELECT
-				b.name                                    AS brand_name,
-				ROUND(SUM(pfi.number_farde)::numeric, 2)  AS farde,
-				ROUND(SUM(pfi.sold)::numeric, 2)           AS sold,
-				ROUND(SUM(pf.price)::numeric, 2)           AS revenue,
-				COUNT(DISTINCT pf.uuid)                    AS visits
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			INNER JOIN brands b     ON pfi.brand_uuid = b.uuid
-			WHERE ` + geoWhere + ` AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+				b.name                                                         AS brand_name,
+				COALESCE(SUM(d.fardes) FILTER (WHERE ` + curr + `), 0)        AS curr_farde,
+				COALESCE(SUM(d.fardes) FILTER (WHERE ` + prev + `), 0)        AS prev_farde,
+				COALESCE(SUM(d.sold) FILTER (WHERE ` + curr + `), 0)          AS curr_sold,
+				COALESCE(SUM(d.sold) FILTER (WHERE ` + prev + `), 0)          AS prev_sold,
+				COALESCE(SUM(d.visit_revenue) FILTER (WHERE ` + curr + `), 0) AS curr_revenue,
+				COALESCE(SUM(d.visit_revenue) FILTER (WHERE ` + prev + `), 0) AS prev_revenue,
+				COALESCE(SUM(d.visits) FILTER (WHERE ` + curr + `), 0)::bigint AS curr_visits,
+				COALESCE(SUM(d.visits) FILTER (WHERE ` + prev + `), 0)::bigint AS prev_visits
+			FROM brand_days d
+			INNER JOIN brands b ON b.uuid = d.brand_uuid
+			WHERE (` + curr + `) OR (` + prev + `)
 			GROUP BY b.name
 		)
 		SELECT
-			COALESCE(c.brand_name, p.brand_name)                        AS brand_name,
-			COALESCE(c.farde, 0)                                         AS curr_farde,
-			COALESCE(p.farde, 0)                                         AS prev_farde,
-			ROUND((COALESCE(c.farde,0) - COALESCE(p.farde,0))::numeric, 2) AS delta_farde,
-			ROUND(((COALESCE(c.farde,0) - COALESCE(p.farde,0)) * 100.0 / NULLIF(COALESCE(p.farde,0), 0))::numeric, 2) AS growth_farde_pct,
-			COALESCE(c.sold, 0)                                          AS curr_sold,
-			COALESCE(p.sold, 0)                                          AS prev_sold,
-			ROUND((COALESCE(c.sold,0) - COALESCE(p.sold,0))::numeric, 2)   AS delta_sold,
-			ROUND(((COALESCE(c.sold,0) - COALESCE(p.sold,0)) * 100.0 / NULLIF(COALESCE(p.sold,0), 0))::numeric, 2)    AS growth_sold_pct,
-			COALESCE(c.revenue, 0)                                       AS curr_revenue,
-			COALESCE(p.revenue, 0)                                       AS prev_revenue,
-			ROUND((COALESCE(c.revenue,0) - COALESCE(p.revenue,0))::numeric, 2) AS delta_revenue,
-			ROUND(((COALESCE(c.revenue,0) - COALESCE(p.revenue,0)) * 100.0 / NULLIF(COALESCE(p.revenue,0), 0))::numeric, 2) AS growth_revenue_pct,
-			COALESCE(c.visits, 0)                                        AS curr_visits,
-			COALESCE(p.visits, 0)                                        AS prev_visits,
+			brand_name,
+			ROUND(curr_farde::numeric, 2)                                  AS curr_farde,
+			ROUND(prev_farde::numeric, 2)                                  AS prev_farde,
+			ROUND((curr_farde - prev_farde)::numeric, 2)                   AS delta_farde,
+			ROUND(((curr_farde - prev_farde) * 100.0 / NULLIF(prev_farde, 0))::numeric, 2) AS growth_farde_pct,
+			ROUND(curr_sold::numeric, 2)                                   AS curr_sold,
+			ROUND(prev_sold::numeric, 2)                                   AS prev_sold,
+			ROUND((curr_sold - prev_sold)::numeric, 2)                     AS delta_sold,
+			ROUND(((curr_sold - prev_sold) * 100.0 / NULLIF(prev_sold, 0))::numeric, 2)    AS growth_sold_pct,
+			ROUND(curr_revenue::numeric, 2)                                AS curr_revenue,
+			ROUND(prev_revenue::numeric, 2)                                AS prev_revenue,
+			ROUND((curr_revenue - prev_revenue)::numeric, 2)               AS delta_revenue,
+			ROUND(((curr_revenue - prev_revenue) * 100.0 / NULLIF(prev_revenue, 0))::numeric, 2) AS growth_revenue_pct,
+			curr_visits,
+			prev_visits,
 			CASE
-				WHEN COALESCE(c.farde,0) > COALESCE(p.farde,0) THEN 'UP'
-				WHEN COALESCE(c.farde,0) < COALESCE(p.farde,0) THEN 'DOWN'
+				WHEN curr_farde > prev_farde THEN 'UP'
+				WHEN curr_farde < prev_farde THEN 'DOWN'
 				ELSE 'STABLE'
 			END AS trend
-		FROM curr c
-		FULL OUTER JOIN prev p ON c.brand_name = p.brand_name
+		FROM periods
 		ORDER BY growth_farde_pct DESC NULLS LAST;
 	`
 
 	var results []Result
-	err := db.Raw(sqlQuery, fullArgs...).Scan(&results).Error
+	err := db.Raw(sqlQuery, params).Scan(&results).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to compute growth rate", "error": err.Error(),
@@ -920,22 +482,23 @@ func SalesGrowthRate(c *fiber.Ctx) error {
 // Returns a pivot-style matrix: for each geographic unit × brand pair,
 // compute farde, sold, market share and rank.
 // Ideal for a heatmap or grouped-bar chart on the frontend.
+// Query params: level=province|area|subarea|commune (province by default)
+// and the filters of the metric views.
 // ─────────────────────────────────────────────────────────────────────────────
 
 func BrandCompetitionMatrix(c *fiber.Ctx) error {
 	db := database.DB
 
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-	level := c.Query("level") // "province" | "area" | "subarea" | "commune"
-
-	if start_date == "" || end_date == "" {
+	params, msg := salesParams(c)
+	if msg != "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "start_date and end_date are required",
+			"status": "error", "message": msg,
 		})
 	}
+	lv, ok := metricLevels[c.Query("level")]
+	if !ok {
+		lv = metricLevels["province"]
+	}
 
 	type Result struct {
 		GeoName     string  `json:"geo_name"`
@@ -948,53 +511,20 @@ func BrandCompetitionMatrix(c *fiber.Ctx) error {
 		TotalVisits int64   `json:"total_visits"`
 	}
 
-	geoSelect := "pr.name AS geo_name, pr.uuid AS geo_uuid"
-	geoJoin := "INNER JOIN provinces pr ON pf.province_uuid = pr.uuid"
-	geoGroup := "pr.name, pr.uuid"
-	geoFilter := "pf.country_uuid = ? AND pf.province_uuid = ?"
-	geoArgs := []interface{}{country_uuid, province_uuid, start_date, end_date}
-
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-
-	switch level {
-	case "area":
-		geoSelect = "a.name AS geo_name, a.uuid AS geo_uuid"
-		geoJoin = "INNER JOIN areas a ON pf.area_uuid = a.uuid"
-		geoGroup = "a.name, a.uuid"
-		geoFilter = "pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ?"
-		geoArgs = []interface{}{country_uuid, province_uuid, area_uuid, start_date, end_date}
-	case "subarea":
-		geoSelect = "sa.name AS geo_name, sa.uuid AS geo_uuid"
-		geoJoin = "INNER JOIN sub_areas sa ON pf.sub_area_uuid = sa.uuid"
-		geoGroup = "sa.name, sa.uuid"
-		geoFilter = "pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ? AND pf.sub_area_uuid = ?"
-		geoArgs = []interface{}{country_uuid, province_uuid, area_uuid, sub_area_uuid, start_date, end_date}
-	case "commune":
-		geoSelect = "co.name AS geo_name, co.uuid AS geo_uuid"
-		geoJoin = "INNER JOIN communes co ON pf.commune_uuid = co.uuid"
-		geoGroup = "co.name, co.uuid"
-		geoFilter = "pf.country_uuid = ? AND pf.province_uuid = ? AND pf.area_uuid = ? AND pf.sub_area_uuid = ? AND pf.commune_uuid = ?"
-		geoArgs = []interface{}{country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, start_date, end_date}
-	}
-
 	sqlQuery := `
-		WITH base AS (
+		WITH ` + salesBrandDays + `,
+		base AS (
 			SELECT
-				` + geoSelect + `,
-				b.name                                    AS brand_name,
-				COUNT(DISTINCT pf.uuid)                   AS total_visits,
-				ROUND(SUM(pfi.number_farde)::numeric, 2)  AS total_farde,
-				ROUND(SUM(pfi.sold)::numeric, 2)           AS total_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			INNER JOIN brands b     ON pfi.brand_uuid = b.uuid
-			` + geoJoin + `
-			WHERE ` + geoFilter + `
-			  AND pf.created_at BETWEEN ? AND ?
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY ` + geoGroup + `, b.name
+				t.name                            AS geo_name,
+				t.uuid                            AS geo_uuid,
+				b.name                            AS brand_name,
+				SUM(d.visits)::bigint             AS total_visits,
+				ROUND(SUM(d.fardes)::numeric, 2)  AS total_farde,
+				ROUND(SUM(d.sold)::numeric, 2)    AS total_sold
+			FROM brand_days d
+			INNER JOIN brands b ON b.uuid = d.brand_uuid
+			INNER JOIN ` + lv.Table + ` t ON t.uuid = d.` + lv.Column + `
+			GROUP BY t.name, t.uuid, b.name
 		),
 		totals AS (
 			SELECT geo_uuid, SUM(total_farde) AS geo_total_farde FROM base GROUP BY geo_uuid
@@ -1014,7 +544,7 @@ func BrandCompetitionMatrix(c *fiber.Ctx) error {
 	`
 
 	var results []Result
-	err := db.Raw(sqlQuery, geoArgs...).Scan(&results).Error
+	err := db.Raw(sqlQuery, params).Scan(&results).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch brand competition matrix", "error": err.Error(),
@@ -1027,6 +557,9 @@ func BrandCompetitionMatrix(c *fiber.Ctx) error {
 // SECTION 6 — TOP 10 POS RANKING
 // Ranks Points of Sale by total farde sold, sold quantity and revenue.
 // Highlights your best-performing outlets for priority visit planning.
+// Revenue is converted to currency= (the currency of the country by
+// default) at the rate of the visit date, so the view stays on pos_forms:
+// the daily facts keep neither the currency nor the date of each visit.
 // ─────────────────────────────────────────────────────────────────────────────
 
 func TopPOSRanking(c *fiber.Ctx) error {
@@ -1040,6 +573,7 @@ func TopPOSRanking(c *fiber.Ctx) error {
 	start_date := c.Query("start_date")
 	end_date := c.Query("end_date")
 	limit := c.Query("limit") // default 10
+	segment := segmentQuery(c)
 
 	if start_date == "" || end_date == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1047,16 +581,30 @@ func TopPOSRanking(c *fiber.Ctx) error {
 		})
 	}
 
+	if !validSegment(segment) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "invalid segment; use A|B|C",
+		})
+	}
+
 	if limit == "" {
 		limit = "10"
 	}
 
+	currency, err := utils.ResolveCurrency(db, c.Query("currency"), country_uuid)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "Invalid currency", "error": err.Error(),
+		})
+	}
+
 	type Result struct {
 		Rank         int     `json:"rank"`
 		PosName      string  `json:"pos_name"`
 		PosUUID      string  `json:"pos_uuid"`
 		Shop         string  `json:"shop"`
 		Postype      string  `json:"postype"`
+		Segment      string  `json:"segment"`
 		CommuneName  string  `json:"commune_name"`
 		AreaName     string  `json:"area_name"`
 		TotalVisits  int64   `json:"total_visits"`
@@ -1087,8 +635,17 @@ func TopPOSRanking(c *fiber.Ctx) error {
 		geoVals = append(geoVals, commune_uuid)
 	}
 	// Each WHERE clause in the SQL uses geoFilter + BETWEEN ? AND ?
-	// The query has two such clauses (global_farde CTE + main WHERE), so repeat args twice.
-	args := append(append(append([]interface{}{}, geoVals...), start_date, end_date), append(geoVals, start_date, end_date)...)
+	// The query has two such clauses (global_farde CTE + main WHERE), so repeat args twice,
+	// with the currency of the FX join in between.
+	args := append(append(append([]interface{}{}, geoVals...), start_date, end_date, currency), append(geoVals, start_date, end_date)...)
+
+	// ?segment= ranks the POS of the segment now, their share staying of
+	// the whole territory
+	segmentFilter := ""
+	if segment != "" {
+		segmentFilter = " AND p.segment = ?"
+		args = append(args, segment)
+	}
 
 	sqlQuery := `
 		WITH global_farde AS (
@@ -1105,41 +662,46 @@ func TopPOSRanking(c *fiber.Ctx) error {
 			p.uuid                                                    AS pos_uuid,
 			p.shop,
 			COALESCE(NULLIF(p.postype,''), 'Non défini')              AS postype,
+			p.segment,
 			co.name                                                   AS commune_name,
 			a.name                                                    AS area_name,
 			COUNT(DISTINCT pf.uuid)                                   AS total_visits,
 			ROUND(SUM(pfi.number_farde)::numeric, 2)                  AS total_farde,
 			ROUND(SUM(pfi.sold)::numeric, 2)                          AS total_sold,
-			ROUND(SUM(pf.price)::numeric, 2)                          AS total_revenue,
-			ROUND(AVG(pf.price)::numeric, 2)                          AS avg_price,
+			ROUND(COALESCE(SUM(pf.price * fx.rate), 0)::numeric, 2)   AS total_revenue,
+			ROUND(COALESCE(AVG(pf.price * fx.rate), 0)::numeric, 2)   AS avg_price,
 			ROUND((SUM(pfi.number_farde) * 100.0 / NULLIF((SELECT g_farde FROM global_farde), 0))::numeric, 2) AS farde_share
 		FROM pos_form_items pfi
 		INNER JOIN pos_forms pf  ON pfi.pos_form_uuid = pf.uuid
 		INNER JOIN pos p         ON pf.pos_uuid = p.uuid
 		LEFT  JOIN communes co   ON pf.commune_uuid = co.uuid
 		LEFT  JOIN areas a       ON pf.area_uuid = a.uuid
+		` + visitFX + `
 		WHERE ` + geoFilter + `
 		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		GROUP BY p.name, p.uuid, p.shop, p.postype, co.name, a.name
+		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL` + segmentFilter + `
+		GROUP BY p.name, p.uuid, p.shop, p.postype, p.segment, co.name, a.name
 		ORDER BY total_farde DESC
 		LIMIT ` + limit + `;
 	`
 
 	var results []Result
-	err := db.Raw(sqlQuery, args...).Scan(&results).Error
+	err = db.Raw(sqlQuery, args...).Scan(&results).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch top POS ranking", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Top POS ranking", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "Top POS ranking", "data": results, "currency": currency})
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
 // SECTION 7 — SALES REPRESENTATIVE SCORECARD
 // Per-agent summary: visits, farde, sold, revenue, avg price, coverage rate
 // and a performance score to quickly identify top vs under-performing reps.
+// Revenue is converted to currency= (the currency of the country by
+// default) at the rate of the visit date, read from pos_forms like the
+// ranking above.
 // ─────────────────────────────────────────────────────────────────────────────
 
 func SalesRepScorecard(c *fiber.Ctx) error {
@@ -1154,6 +716,13 @@ func SalesRepScorecard(c *fiber.Ctx) error {
 	start_date := c.Query("start_date")
 	end_date := c.Query("end_date")
 
+	currency, err := utils.ResolveCurrency(db, c.Query("currency"), country_uuid)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "Invalid currency", "error": err.Error(),
+		})
+	}
+
 	type Result struct {
 		AgentName          string  `json:"agent_name"`
 		AgentUUID          string  `json:"agent_uuid"`
@@ -1169,6 +738,9 @@ func SalesRepScorecard(c *fiber.Ctx) error {
 		VisitTarget        int64   `json:"visit_target"`
 		AchievementPct     float64 `json:"achievement_pct"`
 		PerfScore          float64 `json:"perf_score"` // composite: 50% visits + 30% farde + 20% sold
+
+		// Time on site, travel and time in market over the period
+		visitTiming `gorm:"-"`
 	}
 
 	geoFilter := "pf.country_uuid = ?"
@@ -1226,7 +798,7 @@ func SalesRepScorecard(c *fiber.Ctx) error {
 				COUNT(DISTINCT pf.pos_uuid)                       AS unique_pos,
 				ROUND(SUM(pfi.number_farde)::numeric, 2)          AS total_farde,
 				ROUND(SUM(pfi.sold)::numeric, 2)                   AS total_sold,
-				ROUND(SUM(pf.price)::numeric, 2)                   AS total_revenue,
+				ROUND(COALESCE(SUM(pf.price * fx.rate), 0)::numeric, 2) AS total_revenue,
 				COUNT(DISTINCT pfi.brand_uuid)                    AS brands_covered,
 				(CASE
 					WHEN u.title = 'ASM'        THEN 10 * ((?::date - ?::date) + 1)
@@ -1238,6 +810,7 @@ func SalesRepScorecard(c *fiber.Ctx) error {
 			FROM pos_form_items pfi
 			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
 			INNER JOIN users u      ON pf.user_uuid = u.uuid
+			` + visitFX + `
 			WHERE ` + geoFilter + `
 			  AND pf.created_at BETWEEN ? AND ?
 			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
@@ -1292,17 +865,32 @@ func SalesRepScorecard(c *fiber.Ctx) error {
 	}
 
 	finalArgs := []interface{}{end_date, start_date, end_date, start_date, end_date, start_date, end_date, start_date}
+	finalArgs = append(finalArgs, currency)
 	finalArgs = append(finalArgs, geoValsForQuery...)
 	finalArgs = append(finalArgs, start_date, end_date)
 
 	var results []Result
-	err := db.Raw(sqlQuery, finalArgs...).Scan(&results).Error
+	err = db.Raw(sqlQuery, finalArgs...).Scan(&results).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch rep scorecard", "error": err.Error(),
 		})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "Sales representative scorecard", "data": results})
+
+	agentUUIDs := make([]string, 0, len(results))
+	for _, r := range results {
+		agentUUIDs = append(agentUUIDs, r.AgentUUID)
+	}
+	timing, err := agentVisitTiming(db, agentUUIDs, start_date, end_date)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to fetch visit timing", "error": err.Error(),
+		})
+	}
+	for i := range results {
+		results[i].visitTiming = timing[results[i].AgentUUID]
+	}
+	return c.JSON(fiber.Map{"status": "success", "message": "Sales representative scorecard", "data": results, "currency": currency})
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -1315,13 +903,12 @@ func SalesRepScorecard(c *fiber.Ctx) error {
 func SalesHeatmapByDayOfWeek(c *fiber.Ctx) error {
 	db := database.DB
 
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
+	params, msg := salesParams(c)
+	if msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": msg,
+		})
+	}
 
 	type Result struct {
 		DayOfWeek   int     `json:"day_of_week"` // 1=Monday … 7=Sunday (ISO)
@@ -1333,49 +920,24 @@ func SalesHeatmapByDayOfWeek(c *fiber.Ctx) error {
 		AvgFarde    float64 `json:"avg_farde"`
 	}
 
-	geoFilter := "pf.country_uuid = ?"
-	geoVals := []interface{}{country_uuid}
-
-	if province_uuid != "" {
-		geoFilter += " AND pf.province_uuid = ?"
-		geoVals = append(geoVals, province_uuid)
-	}
-	if area_uuid != "" {
-		geoFilter += " AND pf.area_uuid = ?"
-		geoVals = append(geoVals, area_uuid)
-	}
-	if sub_area_uuid != "" {
-		geoFilter += " AND pf.sub_area_uuid = ?"
-		geoVals = append(geoVals, sub_area_uuid)
-	}
-	if commune_uuid != "" {
-		geoFilter += " AND pf.commune_uuid = ?"
-		geoVals = append(geoVals, commune_uuid)
-	}
-
-	args := append(geoVals, start_date, end_date)
-
 	sqlQuery := `
+		WITH ` + salesBrandDays + `
 		SELECT
-			EXTRACT(ISODOW FROM pf.created_at)::int                 AS day_of_week,
-			TO_CHAR(pf.created_at, 'Day')                           AS day_name,
-			b.name                                                    AS brand_name,
-			ROUND(SUM(pfi.number_farde)::numeric, 2)                 AS total_farde,
-			ROUND(SUM(pfi.sold)::numeric, 2)                          AS total_sold,
-			COUNT(DISTINCT pf.uuid)                                   AS total_visits,
-			ROUND(AVG(pfi.number_farde)::numeric, 2)                  AS avg_farde
-		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-		INNER JOIN brands b     ON pfi.brand_uuid = b.uuid
-		WHERE ` + geoFilter + `
-		  AND pf.created_at BETWEEN ? AND ?
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+			EXTRACT(ISODOW FROM d.day)::int                              AS day_of_week,
+			TO_CHAR(d.day, 'Day')                                        AS day_name,
+			b.name                                                       AS brand_name,
+			ROUND(SUM(d.fardes)::numeric, 2)                             AS total_farde,
+			ROUND(SUM(d.sold)::numeric, 2)                               AS total_sold,
+			SUM(d.visits)::bigint                                        AS total_visits,
+			ROUND((SUM(d.fardes) / NULLIF(SUM(d.visits), 0))::numeric, 2) AS avg_farde
+		FROM brand_days d
+		INNER JOIN brands b ON b.uuid = d.brand_uuid
 		GROUP BY day_of_week, day_name, b.name
 		ORDER BY day_of_week, total_farde DESC;
 	`
 
 	var results []Result
-	err := db.Raw(sqlQuery, args...).Scan(&results).Error
+	err := db.Raw(sqlQuery, params).Scan(&results).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch day-of-week heatmap", "error": err.Error(),
@@ -1388,7 +950,10 @@ func SalesHeatmapByDayOfWeek(c *fiber.Ctx) error {
 // SECTION 9 — SALES SUMMARY KPI CARD
 // Single-request KPI card for the top of the dashboard:
 // total farde, sold, revenue, visits, active POS, brands, avg price,
-// plus deltas vs previous equivalent period.
+// plus deltas vs previous equivalent period. Revenue is converted to
+// currency= (the currency of the country by default) at the rate of the
+// visit date; the visits without rate are counted apart, which is why the
+// card is read from pos_forms rather than the daily facts.
 // ─────────────────────────────────────────────────────────────────────────────
 
 func SalesSummaryKPI(c *fiber.Ctx) error {
@@ -1402,15 +967,23 @@ func SalesSummaryKPI(c *fiber.Ctx) error {
 	start_date := c.Query("start_date")
 	end_date := c.Query("end_date")
 
+	currency, err := utils.ResolveCurrency(db, c.Query("currency"), country_uuid)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "Invalid currency", "error": err.Error(),
+		})
+	}
+
 	type PeriodKPI struct {
-		TotalFarde   float64 `json:"total_farde"`
-		TotalSold    float64 `json:"total_sold"`
-		TotalRevenue float64 `json:"total_revenue"`
-		TotalVisits  int64   `json:"total_visits"`
-		ActivePos    int64   `json:"active_pos"`
-		ActiveBrands int64   `json:"active_brands"`
-		AvgPrice     float64 `json:"avg_price"`
-		ActiveAgents int64   `json:"active_agents"`
+		TotalFarde        float64 `json:"total_farde"`
+		TotalSold         float64 `json:"total_sold"`
+		TotalRevenue      float64 `json:"total_revenue"`
+		TotalVisits       int64   `json:"total_visits"`
+		ActivePos         int64   `json:"active_pos"`
+		ActiveBrands      int64   `json:"active_brands"`
+		AvgPrice          float64 `json:"avg_price"`
+		ActiveAgents      int64   `json:"active_agents"`
+		UnconvertedVisits int64   `json:"unconverted_visits"` // Priced visits without exchange rate, left out of revenue
 	}
 	type KPISummary struct {
 		Current       PeriodKPI `json:"current"`
@@ -1443,23 +1016,25 @@ func SalesSummaryKPI(c *fiber.Ctx) error {
 		SELECT
 			ROUND(COALESCE(SUM(pfi.number_farde), 0)::numeric, 2)  AS total_farde,
 			ROUND(COALESCE(SUM(pfi.sold), 0)::numeric, 2)           AS total_sold,
-			ROUND(COALESCE(SUM(pf.price), 0)::numeric, 2)           AS total_revenue,
+			ROUND(COALESCE(SUM(pf.price * fx.rate), 0)::numeric, 2) AS total_revenue,
 			COUNT(DISTINCT pf.uuid)                                  AS total_visits,
 			COUNT(DISTINCT pf.pos_uuid)                              AS active_pos,
 			COUNT(DISTINCT pfi.brand_uuid)                           AS active_brands,
-			ROUND(COALESCE(AVG(pf.price), 0)::numeric, 2)           AS avg_price,
-			COUNT(DISTINCT pf.user_uuid)                             AS active_agents
+			ROUND(COALESCE(AVG(pf.price * fx.rate), 0)::numeric, 2) AS avg_price,
+			COUNT(DISTINCT pf.user_uuid)                             AS active_agents,
+			COUNT(DISTINCT pf.uuid) FILTER (WHERE pf.price <> 0 AND fx.rate IS NULL) AS unconverted_visits
 		FROM pos_form_items pfi
 		INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
+		` + visitFX + `
 		WHERE ` + geoFilter + `
 		  AND pf.created_at BETWEEN ? AND ?
 		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL;
 	`
 
-	currArgs := append(append([]interface{}{}, geoVals...), start_date, end_date)
+	currArgs := append(append([]interface{}{currency}, geoVals...), start_date, end_date)
 
 	var current PeriodKPI
-	err := db.Raw(sqlQuery, currArgs...).Scan(&current).Error
+	err = db.Raw(sqlQuery, currArgs...).Scan(&current).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status": "error", "message": "Failed to fetch KPI summary", "error": err.Error(),
@@ -1467,8 +1042,9 @@ func SalesSummaryKPI(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"status":  "success",
-		"message": "Sales summary KPI",
+		"status":   "success",
+		"message":  "Sales summary KPI",
+		"currency": currency,
 		"data": KPISummary{
 			Current:       current,
 			FardeGrowth:   0, // requires prev period params — extend as needed
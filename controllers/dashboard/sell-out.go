@@ -0,0 +1,273 @@
+package dashboard
+
+import (
+	"strconv"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// SELL-OUT ESTIMATE
+// NumberFarde is the stock counted at the visit and Sold what the shop says
+// it sold. Between two visits of the same POS, the stock of the first one
+// plus the deliveries recorded in between, less the stock of the second one,
+// estimates what was sold independently of the shop:
+//
+//	estimated_sold     = previous_stock + delivered - stock
+//	days_between       = days from the previous visit to the visit
+//	daily_rate_of_sale = estimated_sold / days_between
+//
+// Each visit of the period is paired, per brand, with the latest earlier
+// visit of the POS with the brand, before the period included. A negative
+// estimate means stock came in without a recorded delivery.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// Gap flags of a sell-out pair
+const (
+	sellOutConsistent     = "consistent"
+	sellOutOverReported   = "over_reported"
+	sellOutUnderReported  = "under_reported"
+	sellOutStockIncreased = "stock_increase"
+)
+
+// sellOutCTEs are the CTEs of the sell-out pairs of the visits matched by
+// metricFormFilter, to follow a WITH:
+//
+//	visit_stock    — pos_form_uuid, pos_uuid, created_at, brand_uuid, stock,
+//	                 reported_sold: the brand lines of each visit added up
+//	sell_out_pairs — the visit_stock columns and previous_pos_form_uuid,
+//	                 previous_visit_at, previous_stock, delivered,
+//	                 estimated_sold, days_between
+//	sell_out       — the sell_out_pairs columns and daily_rate_of_sale, gap,
+//	                 gap_flag
+//
+// gap is reported_sold - estimated_sold; the pair is consistent when the
+// gap is within @tolerance % of the larger of the two.
+var sellOutCTEs = `
+		visit_stock AS (
+			SELECT
+				pf.uuid               AS pos_form_uuid,
+				pf.pos_uuid,
+				pf.created_at,
+				pfi.brand_uuid,
+				SUM(pfi.number_farde) AS stock,
+				SUM(pfi.sold)         AS reported_sold
+			FROM pos_forms pf
+			INNER JOIN pos_form_items pfi ON pfi.pos_form_uuid = pf.uuid AND pfi.deleted_at IS NULL
+			WHERE ` + metricFormFilter + `
+			  AND pfi.brand_uuid <> ''
+			GROUP BY pf.uuid, pfi.brand_uuid
+		),
+		sell_out_pairs AS (
+			SELECT
+				vs.*,
+				prev.pos_form_uuid                                            AS previous_pos_form_uuid,
+				prev.created_at                                               AS previous_visit_at,
+				prev.stock                                                    AS previous_stock,
+				COALESCE(dl.fardes, 0)                                        AS delivered,
+				prev.stock + COALESCE(dl.fardes, 0) - vs.stock                AS estimated_sold,
+				(EXTRACT(EPOCH FROM vs.created_at - prev.created_at) / 86400)::float8 AS days_between
+			FROM visit_stock vs
+			INNER JOIN LATERAL (
+				SELECT ppf.uuid AS pos_form_uuid, ppf.created_at, SUM(ppfi.number_farde) AS stock
+				FROM pos_forms ppf
+				INNER JOIN pos_form_items ppfi ON ppfi.pos_form_uuid = ppf.uuid AND ppfi.deleted_at IS NULL
+				WHERE ppf.pos_uuid = vs.pos_uuid
+				  AND ppfi.brand_uuid = vs.brand_uuid
+				  AND ppf.created_at < vs.created_at
+				  AND ppf.deleted_at IS NULL
+				GROUP BY ppf.uuid, ppf.created_at
+				ORDER BY ppf.created_at DESC
+				LIMIT 1
+			) prev ON true
+			LEFT JOIN LATERAL (
+				SELECT SUM(d.fardes) AS fardes
+				FROM deliveries d
+				WHERE d.pos_uuid = vs.pos_uuid
+				  AND d.brand_uuid = vs.brand_uuid
+				  AND d.delivered_at > prev.created_at AND d.delivered_at <= vs.created_at
+				  AND d.deleted_at IS NULL
+			) dl ON true
+		),
+		sell_out AS (
+			SELECT
+				sp.*,
+				sp.estimated_sold / NULLIF(sp.days_between, 0) AS daily_rate_of_sale,
+				sp.reported_sold - sp.estimated_sold           AS gap,
+				CASE
+					WHEN sp.estimated_sold < 0 THEN '` + sellOutStockIncreased + `'
+					WHEN ABS(sp.reported_sold - sp.estimated_sold) <=
+					     CAST(@tolerance AS float8) / 100 * GREATEST(sp.reported_sold, sp.estimated_sold)
+					     THEN '` + sellOutConsistent + `'
+					WHEN sp.reported_sold > sp.estimated_sold THEN '` + sellOutOverReported + `'
+					ELSE '` + sellOutUnderReported + `'
+				END AS gap_flag
+			FROM sell_out_pairs sp
+		)`
+
+// sellOutParams reads the metric params and the gap tolerance (%, default
+// 30). Returns nil when required params are missing. The estimate is over
+// all segments.
+func sellOutParams(c *fiber.Ctx) map[string]interface{} {
+	params := metricParams(c)
+	if params == nil {
+		return nil
+	}
+	tolerance, err := strconv.ParseFloat(c.Query("tolerance", "30"), 64)
+	if err != nil || tolerance < 0 {
+		tolerance = 30
+	}
+	params["tolerance"] = tolerance
+	params["segment"] = ""
+	return params
+}
+
+// SISHSellOut — Estimated vs reported sell-out per brand.
+//
+//	pairs              — visits paired with a previous visit of the POS
+//	estimated_sold     — Σ previous_stock + delivered - stock
+//	reported_sold      — Σ Sold declared at those visits
+//	gap_percent        — (reported - estimated) / estimated × 100
+//	daily_rate_of_sale — estimated_sold / Σ days_between, per POS
+//	consistent, over_reported, under_reported, stock_increase — pairs per flag
+//
+// ?tolerance=30 is the gap (%) still counted as consistent.
+func SISHSellOut(c *fiber.Ctx) error {
+	db := database.DB
+	params := sellOutParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "country_uuid, start_date and end_date are required",
+		})
+	}
+
+	sqlQuery := `
+		WITH ` + sellOutCTEs + `
+		SELECT
+			b.uuid                                                     AS brand_uuid,
+			b.name                                                     AS brand_name,
+			COUNT(*)                                                   AS pairs,
+			ROUND(SUM(so.estimated_sold)::numeric, 2)                  AS estimated_sold,
+			ROUND(SUM(so.reported_sold)::numeric, 2)                   AS reported_sold,
+			ROUND(SUM(so.delivered)::numeric, 2)                       AS delivered,
+			ROUND(((SUM(so.reported_sold) - SUM(so.estimated_sold)) * 100.0 /
+			       NULLIF(SUM(so.estimated_sold), 0))::numeric, 2)     AS gap_percent,
+			ROUND(AVG(so.days_between)::numeric, 1)                    AS avg_days_between,
+			ROUND((SUM(so.estimated_sold) /
+			       NULLIF(SUM(so.days_between), 0))::numeric, 3)       AS daily_rate_of_sale,
+			COUNT(*) FILTER (WHERE so.gap_flag = '` + sellOutConsistent + `')     AS consistent,
+			COUNT(*) FILTER (WHERE so.gap_flag = '` + sellOutOverReported + `')   AS over_reported,
+			COUNT(*) FILTER (WHERE so.gap_flag = '` + sellOutUnderReported + `')  AS under_reported,
+			COUNT(*) FILTER (WHERE so.gap_flag = '` + sellOutStockIncreased + `') AS stock_increase
+		FROM sell_out so
+		INNER JOIN brands b ON b.uuid = so.brand_uuid
+		GROUP BY b.uuid, b.name
+		ORDER BY estimated_sold DESC
+	`
+
+	type SellOutRow struct {
+		BrandUUID       string  `json:"brand_uuid"`
+		BrandName       string  `json:"brand_name"`
+		Pairs           int64   `json:"pairs"`
+		EstimatedSold   float64 `json:"estimated_sold"`
+		ReportedSold    float64 `json:"reported_sold"`
+		Delivered       float64 `json:"delivered"`
+		GapPercent      float64 `json:"gap_percent"`
+		AvgDaysBetween  float64 `json:"avg_days_between"`
+		DailyRateOfSale float64 `json:"daily_rate_of_sale"`
+		Consistent      int64   `json:"consistent"`
+		OverReported    int64   `json:"over_reported"`
+		UnderReported   int64   `json:"under_reported"`
+		StockIncrease   int64   `json:"stock_increase"`
+	}
+
+	var results []SellOutRow
+	if err := db.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to fetch SISH sell-out estimate", "error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status": "success", "message": "SISH Sell-Out Estimate",
+		"tolerance": params["tolerance"],
+		"data":      results,
+	})
+}
+
+// SISHSellOutGaps — The visits whose reported Sold is furthest from the
+// sell-out estimate, not consistent ones (?brand_uuid=… optional,
+// ?tolerance=30).
+func SISHSellOutGaps(c *fiber.Ctx) error {
+	db := database.DB
+	params := sellOutParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "country_uuid, start_date and end_date are required",
+		})
+	}
+	params["brand_uuid"] = c.Query("brand_uuid")
+
+	sqlQuery := `
+		WITH ` + sellOutCTEs + `
+		SELECT
+			so.pos_form_uuid,
+			so.previous_pos_form_uuid,
+			p.uuid                                  AS pos_uuid,
+			p.name                                  AS pos_name,
+			p.shop                                  AS pos_shop,
+			b.uuid                                  AS brand_uuid,
+			b.name                                  AS brand_name,
+			so.previous_visit_at,
+			so.created_at                           AS visit_at,
+			ROUND(so.days_between::numeric, 1)      AS days_between,
+			so.previous_stock,
+			so.delivered,
+			so.stock,
+			so.estimated_sold,
+			so.reported_sold,
+			so.gap,
+			ROUND(so.daily_rate_of_sale::numeric, 3) AS daily_rate_of_sale,
+			so.gap_flag
+		FROM sell_out so
+		INNER JOIN pos p    ON p.uuid = so.pos_uuid
+		INNER JOIN brands b ON b.uuid = so.brand_uuid
+		WHERE so.gap_flag <> '` + sellOutConsistent + `'
+		  AND (@brand_uuid = '' OR so.brand_uuid = @brand_uuid)
+		ORDER BY ABS(so.gap) DESC
+		LIMIT 100
+	`
+
+	type GapRow struct {
+		PosFormUUID         string  `json:"pos_form_uuid"`
+		PreviousPosFormUUID string  `json:"previous_pos_form_uuid"`
+		PosUUID             string  `json:"pos_uuid"`
+		PosName             string  `json:"pos_name"`
+		PosShop             string  `json:"pos_shop"`
+		BrandUUID           string  `json:"brand_uuid"`
+		BrandName           string  `json:"brand_name"`
+		PreviousVisitAt     string  `json:"previous_visit_at"`
+		VisitAt             string  `json:"visit_at"`
+		DaysBetween         float64 `json:"days_between"`
+		PreviousStock       float64 `json:"previous_stock"`
+		Delivered           float64 `json:"delivered"`
+		Stock               float64 `json:"stock"`
+		EstimatedSold       float64 `json:"estimated_sold"`
+		ReportedSold        float64 `json:"reported_sold"`
+		Gap                 float64 `json:"gap"`
+		DailyRateOfSale     float64 `json:"daily_rate_of_sale"`
+		GapFlag             string  `json:"gap_flag"`
+	}
+
+	var results []GapRow
+	if err := db.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to fetch SISH sell-out gaps", "error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status": "success", "message": "SISH Sell-Out Gaps",
+		"tolerance": params["tolerance"],
+		"data":      results,
+	})
+}
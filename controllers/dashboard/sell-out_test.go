@@ -0,0 +1,116 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSellOutTolerance(t *testing.T) {
+	queries := logQueries(t)
+	app := fiber.New()
+	app.Get("/sell-out", SISHSellOut)
+
+	const period = "/sell-out?country_uuid=cd&start_date=2026-09-01&end_date=2026-09-30"
+	tests := []struct {
+		query     string
+		tolerance float64
+	}{
+		{"", 30},
+		{"&tolerance=12.5", 12.5},
+		{"&tolerance=0", 0},
+		{"&tolerance=-5", 30},
+		{"&tolerance=a+lot", 30},
+	}
+	for _, tt := range tests {
+		*queries = nil
+		resp, err := app.Test(httptest.NewRequest("GET", period+tt.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Status    string
+			Tolerance float64
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != fiber.StatusOK || body.Status != "success" || body.Tolerance != tt.tolerance {
+			t.Errorf("%q: %d %+v, want tolerance %v", tt.query, resp.StatusCode, body, tt.tolerance)
+		}
+		if len(*queries) != 1 {
+			t.Fatalf("%q: queries = %q", tt.query, *queries)
+		}
+		// Pairs within the tolerance of the larger of the two are consistent
+		if want := fmt.Sprintf("CAST('%v' AS float8) / 100", tt.tolerance); !strings.Contains((*queries)[0], want) {
+			t.Errorf("%q: query misses %s", tt.query, want)
+		}
+	}
+}
+
+func TestSellOutQueries(t *testing.T) {
+	queries := logQueries(t)
+	app := fiber.New()
+	app.Get("/sell-out", SISHSellOut)
+	app.Get("/sell-out-gaps", SISHSellOutGaps)
+
+	get := func(target string) int {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	t.Run("missing period", func(t *testing.T) {
+		*queries = nil
+		for _, target := range []string{"/sell-out?country_uuid=cd", "/sell-out-gaps?start_date=2026-09-01&end_date=2026-09-30"} {
+			if status := get(target); status != fiber.StatusBadRequest {
+				t.Errorf("%s: %d, want 400", target, status)
+			}
+		}
+		if len(*queries) != 0 {
+			t.Errorf("refused requests ran %q", *queries)
+		}
+	})
+
+	t.Run("estimate over every segment", func(t *testing.T) {
+		*queries = nil
+		if status := get("/sell-out?country_uuid=cd&start_date=2026-09-01&end_date=2026-09-30&segment=A"); status != fiber.StatusOK {
+			t.Fatalf("status %d", status)
+		}
+		q := (*queries)[0]
+		if strings.Contains(q, "'A'") {
+			t.Errorf("the estimate is filtered on the segment:\n%s", q)
+		}
+		for _, want := range []string{
+			"pf.created_at >= '2026-09-01' AND pf.created_at <= '2026-09-30 23:59:59'",
+			"d.delivered_at > prev.created_at AND d.delivered_at <= vs.created_at",
+			"prev.stock + COALESCE(dl.fardes, 0) - vs.stock",
+			"GROUP BY b.uuid, b.name",
+		} {
+			if !strings.Contains(q, want) {
+				t.Errorf("estimate query misses %s", want)
+			}
+		}
+	})
+
+	t.Run("gaps of one brand", func(t *testing.T) {
+		*queries = nil
+		if status := get("/sell-out-gaps?country_uuid=cd&start_date=2026-09-01&end_date=2026-09-30&brand_uuid=primus"); status != fiber.StatusOK {
+			t.Fatalf("status %d", status)
+		}
+		q := (*queries)[0]
+		for _, want := range []string{
+			"so.gap_flag <> '" + sellOutConsistent + "'",
+			"('primus' = '' OR so.brand_uuid = 'primus')",
+			"ORDER BY ABS(so.gap) DESC",
+		} {
+			if !strings.Contains(q, want) {
+				t.Errorf("gaps query misses %s", want)
+			}
+		}
+	})
+}
@@ -0,0 +1,865 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// ╔══════════════════════════════════════════════════════════════════════════════╗
+// ║                 DASHBOARD VIEW EXPORT — XLSX / CSV OF ANY VIEW               ║
+// ╠══════════════════════════════════════════════════════════════════════════════╣
+// ║  GET /dashboard/:metric/:view/export?format=xlsx|csv&…view params           ║
+// ║  Runs the view with the same params, then turns its JSON into tables:       ║
+// ║    data array        — one row per item; the first nested array of each     ║
+// ║                        item (e.g. the brands of a territory) is exploded    ║
+// ║    data object       — a table per array of items, a brand × territory      ║
+// ║                        table for a heatmap matrix                           ║
+// ║    other values      — "Résumé" table of indicator / value                  ║
+// ║  The workbook opens on a cover sheet listing the filters and each table     ║
+// ║  comes with a native chart of its key measure.                              ║
+// ║  UUID columns are left out unless with_uuid=true.                           ║
+// ╚══════════════════════════════════════════════════════════════════════════════╝
+
+// exportGroups lists the views of the dashboards outside the metric engine,
+// by route group then view.
+var exportGroups = map[string]map[string]fiber.Handler{
+	"sales-evolution": {
+		"table-view-province":       TypePosTableProvince,
+		"table-view-area":           TypePosTableArea,
+		"table-view-subarea":        TypePosTableSubArea,
+		"table-view-commune":        TypePosTableCommune,
+		"table-view-province-price": PriceTableProvince,
+		"table-view-area-price":     PriceTableArea,
+		"table-view-subarea-price":  PriceTableSubArea,
+		"table-view-commune-price":  PriceTableCommune,
+		"evolution-by-month":        SalesEvolutionByMonth,
+		"growth-rate":               SalesGrowthRate,
+		"brand-competition-matrix":  BrandCompetitionMatrix,
+		"top-pos-ranking":           TopPOSRanking,
+		"rep-scorecard":             SalesRepScorecard,
+		"heatmap-day-of-week":       SalesHeatmapByDayOfWeek,
+		"summary-kpi":               SalesSummaryKPI,
+	},
+	"price-compliance": {
+		"summary":            PriceComplianceSummary,
+		"dispersion-commune": PriceDispersionCommune,
+		"trend":              PriceTrend,
+	},
+	"kpi": {
+		"territory-overview":  GetKPITerritoryOverview,
+		"agent-performance":   GetAgentPerformanceDetails,
+		"pos-insights":        GetPOSLevelInsights,
+		"target-vs-actual":    GetKPITargetVsActual,
+		"absence-analysis":    GetTeamAbsenceAnalysis,
+		"period-comparison":   GetPeriodComparison,
+		"nd-analysis":         GetNDAnalysisByTerritory,
+		"table-view-country":  TotalVisitsByCountry,
+		"table-view-province": TotalVisitsByProvince,
+		"table-view-area":     TotalVisitsByArea,
+		"table-view-sub-area": TotalVisitsBySubArea,
+		"table-view-commune":  TotalVisitsByCommune,
+		"user-visit-summary":  KpiUserVisitSummary,
+	},
+	"google-map": {
+		"view": GoogleMaps,
+	},
+}
+
+// exportView finds the handler of a view. The metric engine views also
+// answer to their per-level route names, e.g. table-view-area.
+func exportView(group, view string) (fiber.Handler, bool) {
+	if m, ok := metricDefinitions[group]; ok {
+		for _, base := range []string{"table-view", "bar-chart"} {
+			if level, ok := strings.CutPrefix(view, base+"-"); ok {
+				if _, ok := metricLevels[level]; ok {
+					return func(c *fiber.Ctx) error { return m.serve(c, base, level) }, true
+				}
+			}
+		}
+		return func(c *fiber.Ctx) error { return m.serve(c, view, c.Query("level", "province")) }, true
+	}
+	h, ok := exportGroups[group][view]
+	return h, ok
+}
+
+// decodeView decodes the JSON response of a view.
+func decodeView(raw []byte) (*jsonObject, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	decoded, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	body, ok := decoded.(*jsonObject)
+	if !ok {
+		return nil, errors.New("the view did not answer a JSON object")
+	}
+	return body, nil
+}
+
+// runView runs a view with its query params overridden by params and
+// returns its decoded JSON, or the message of its error response. The
+// query params and response of c are left as they were.
+func runView(c *fiber.Ctx, h fiber.Handler, params map[string]string) (*jsonObject, error) {
+	args := c.Request().URI().QueryArgs()
+	saved := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(saved)
+	args.CopyTo(saved)
+	defer saved.CopyTo(args)
+	for k, v := range params {
+		args.Set(k, v)
+	}
+
+	status := c.Response().StatusCode()
+	err := h(c)
+	code := c.Response().StatusCode()
+	body, decodeErr := decodeView(c.Response().Body())
+	c.Response().ResetBody()
+	c.Status(status)
+
+	if err != nil {
+		return nil, err
+	}
+	if code != fiber.StatusOK {
+		msg := fmt.Sprintf("status %d", code)
+		if body != nil {
+			if m := body.str("message"); m != "" {
+				msg = m
+			}
+			if e := body.str("error"); e != "" {
+				msg += ": " + e
+			}
+		}
+		return nil, errors.New(msg)
+	}
+	return body, decodeErr
+}
+
+// ExportDashboardView exports a dashboard view as XLSX or CSV.
+//
+// GET /api/dashboard/:metric/:view/export?format=xlsx|csv
+func ExportDashboardView(c *fiber.Ctx) error {
+	group, view := c.Params("metric"), c.Params("view")
+	format := c.Query("format", "xlsx")
+	if format != "xlsx" && format != "csv" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "invalid format; use xlsx|csv",
+		})
+	}
+
+	h, ok := exportView(group, view)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status": "error", "message": "Unknown dashboard view " + group + "/" + view,
+		})
+	}
+
+	// Run the view; its errors are answered as they are
+	if err := h(c); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() != fiber.StatusOK {
+		return nil
+	}
+
+	body, err := decodeView(c.Response().Body())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Failed to read the " + group + "/" + view + " view", "error": err.Error(),
+		})
+	}
+	c.Response().ResetBody()
+
+	title, _ := body.values["message"].(string)
+	if title == "" {
+		title = group + " — " + view
+	}
+	tables := exportTables(body, c.QueryBool("with_uuid"), format == "xlsx")
+	filename := fmt.Sprintf("%s_%s_%s.%s", group, view, time.Now().Format("2006-01-02_15-04-05"), format)
+
+	if format == "csv" {
+		// Only the data, the summary when there is nothing else
+		if len(tables) > 1 && tables[0].Name == "Résumé" {
+			tables = tables[1:]
+		}
+		var buf bytes.Buffer
+		if err := utils.WriteReportCSV(&buf, tables); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status": "error", "message": "Failed to write the CSV file", "error": err.Error(),
+			})
+		}
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		c.Set(fiber.HeaderCacheControl, "no-cache, no-store, must-revalidate")
+		return c.Send(buf.Bytes())
+	}
+
+	author := ""
+	if user := middlewares.CurrentUser(c); user != nil {
+		author = user.Fullname
+	}
+	config := utils.ExcelReportConfig{
+		Title:       title,
+		CompanyName: "MSPOS",
+		ReportDate:  time.Now(),
+		Author:      author,
+	}
+	f, err := utils.NewTableWorkbook(config, exportFilters(c, group, view), tables)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Erreur lors de la génération du fichier Excel", "error": err.Error(),
+		})
+	}
+	defer f.Close()
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": "Erreur lors de la génération du fichier Excel", "error": err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Set(fiber.HeaderCacheControl, "no-cache, no-store, must-revalidate")
+	return c.Send(buf.Bytes())
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// COVER SHEET FILTERS
+// ─────────────────────────────────────────────────────────────────────────────
+
+// exportFilterNames labels the query params and, for uuids, gives the
+// table and column of the name they are shown with.
+var exportFilterNames = map[string][3]string{
+	"country_uuid":      {"Pays", "countries", "name"},
+	"province_uuid":     {"Province", "provinces", "name"},
+	"area_uuid":         {"Area", "areas", "name"},
+	"sub_area_uuid":     {"Sub area", "sub_areas", "name"},
+	"commune_uuid":      {"Commune", "communes", "name"},
+	"territory_uuid":    {"Territoire"},
+	"brand_uuid":        {"Marque", "brands", "name"},
+	"category_uuid":     {"Catégorie", "categories", "name"},
+	"manufacturer_uuid": {"Fabricant", "manufacturers", "name"},
+	"ownership":         {"Propriété"},
+	"group":             {"Regroupement"},
+	"segment":           {"Segment"},
+	"user_uuid":         {"Agent", "users", "fullname"},
+	"agent_uuid":        {"Agent", "users", "fullname"},
+	"start_date":        {"Date de début"},
+	"end_date":          {"Date de fin"},
+	"level":             {"Niveau"},
+	"month":             {"Mois"},
+	"months":            {"Mois de tendance"},
+	"unit":              {"Unité de prix"},
+	"currency":          {"Devise"},
+	"tolerance":         {"Tolérance (%)"},
+	"granularity":       {"Granularité"},
+}
+
+// exportFilters lists the view and its query params, uuids with the name
+// they stand for.
+func exportFilters(c *fiber.Ctx, group, view string) []utils.ReportFilter {
+	filters := []utils.ReportFilter{{Label: "Vue", Value: group + " / " + view}}
+	c.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		key, value := string(k), strings.TrimSpace(string(v))
+		if value == "" || key == "token" || key == "format" || key == "with_uuid" {
+			return
+		}
+		label := key
+		if name, ok := exportFilterNames[key]; ok {
+			label = name[0]
+			if name[1] != "" {
+				var resolved string
+				database.DB.Table(name[1]).Select(name[2]).Where("uuid = ?", value).Scan(&resolved)
+				if resolved != "" {
+					value = resolved + " (" + value + ")"
+				}
+			}
+		}
+		filters = append(filters, utils.ReportFilter{Label: label, Value: value})
+	})
+	return filters
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// JSON → TABLES
+// ─────────────────────────────────────────────────────────────────────────────
+
+// jsonObject is a decoded JSON object that keeps the order of its keys, so
+// that the columns follow the order of the view's fields.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: map[string]interface{}{}}
+}
+
+func (o *jsonObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *jsonObject) clone() *jsonObject {
+	c := &jsonObject{keys: append([]string(nil), o.keys...), values: make(map[string]interface{}, len(o.values))}
+	for k, v := range o.values {
+		c.values[k] = v
+	}
+	return c
+}
+
+// str returns the string at key, or "".
+func (o *jsonObject) str(key string) string {
+	s, _ := o.values[key].(string)
+	return s
+}
+
+// num returns the number at key, or 0.
+func (o *jsonObject) num(key string) float64 {
+	switch v := o.values[key].(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// object returns the object at key, or an empty one.
+func (o *jsonObject) object(key string) *jsonObject {
+	if v, ok := o.values[key].(*jsonObject); ok {
+		return v
+	}
+	return newJSONObject()
+}
+
+// list returns the objects of the array at key.
+func (o *jsonObject) list(key string) []*jsonObject {
+	arr, _ := o.values[key].([]interface{})
+	out := make([]*jsonObject, 0, len(arr))
+	for _, v := range arr {
+		if obj, ok := v.(*jsonObject); ok {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// decodeJSON decodes the next value of dec: objects as *jsonObject, arrays
+// as []interface{}, numbers as int64 or float64.
+func decodeJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			obj := newJSONObject()
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.set(key.(string), v)
+			}
+			_, err := dec.Token()
+			return obj, err
+		}
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return tok, nil
+}
+
+func isObjectArray(v interface{}) bool {
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) == 0 {
+		return false
+	}
+	_, ok = arr[0].(*jsonObject)
+	return ok
+}
+
+// joinScalars shows an array of plain values in one cell.
+func joinScalars(arr []interface{}) string {
+	parts := make([]string, 0, len(arr))
+	for _, v := range arr {
+		if _, ok := v.(*jsonObject); ok {
+			continue
+		}
+		if _, ok := v.([]interface{}); ok {
+			continue
+		}
+		parts = append(parts, fmt.Sprint(v))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// flattenScalars copies the plain values of obj into row, nested objects
+// under "parent.child". Arrays of objects are left out.
+func flattenScalars(obj *jsonObject, prefix string, row *jsonObject) {
+	for _, key := range obj.keys {
+		switch v := obj.values[key].(type) {
+		case *jsonObject:
+			flattenScalars(v, prefix+key+".", row)
+		case []interface{}:
+			if !isObjectArray(v) {
+				row.set(prefix+key, joinScalars(v))
+			}
+		default:
+			row.set(prefix+key, v)
+		}
+	}
+}
+
+// flattenItem makes the rows of an item: its plain values, repeated for
+// each row of its first array of objects. Child columns that clash with a
+// parent column of another value are named "array.column".
+func flattenItem(obj *jsonObject) []*jsonObject {
+	base := newJSONObject()
+	var nestedKey string
+	var nested []interface{}
+	for _, key := range obj.keys {
+		switch v := obj.values[key].(type) {
+		case *jsonObject:
+			flattenScalars(v, key+".", base)
+		case []interface{}:
+			if !isObjectArray(v) {
+				base.set(key, joinScalars(v))
+			} else if nested == nil {
+				nestedKey, nested = key, v
+			}
+		default:
+			base.set(key, v)
+		}
+	}
+	if len(nested) == 0 {
+		return []*jsonObject{base}
+	}
+
+	rows := []*jsonObject{}
+	for _, item := range nested {
+		child, ok := item.(*jsonObject)
+		if !ok {
+			continue
+		}
+		for _, cr := range flattenItem(child) {
+			row := base.clone()
+			for _, key := range cr.keys {
+				name := key
+				if v, clash := row.values[name]; clash {
+					if v == cr.values[key] {
+						continue
+					}
+					name = nestedKey + "." + key
+				}
+				row.set(name, cr.values[key])
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func isUUIDColumn(name string) bool {
+	return strings.HasSuffix(name, "uuid")
+}
+
+// rowsTable lays out flattened rows as a table, columns in order of first
+// appearance. A column holding decimals has all its numbers as decimals.
+// With charts, the table may be followed by the pivot its chart is drawn
+// from.
+func rowsTable(name string, rows []*jsonObject, withUUID, charts bool) []utils.ReportTable {
+	t := utils.ReportTable{Name: name}
+	seen := map[string]bool{}
+	for _, r := range rows {
+		for _, key := range r.keys {
+			if !seen[key] && (withUUID || !isUUIDColumn(key)) {
+				seen[key] = true
+				t.Columns = append(t.Columns, key)
+			}
+		}
+	}
+
+	decimal := make([]bool, len(t.Columns))
+	for _, r := range rows {
+		values := make([]interface{}, len(t.Columns))
+		for i, col := range t.Columns {
+			values[i] = r.values[col]
+			if _, ok := values[i].(float64); ok {
+				decimal[i] = true
+			}
+		}
+		t.Rows = append(t.Rows, values)
+	}
+	for _, values := range t.Rows {
+		for i, v := range values {
+			if n, ok := v.(int64); ok && decimal[i] {
+				values[i] = float64(n)
+			}
+		}
+	}
+	if !charts {
+		return []utils.ReportTable{t}
+	}
+	chart, pivot := exportChart(&t)
+	t.Chart = chart
+	if pivot != nil {
+		return []utils.ReportTable{t, *pivot}
+	}
+	return []utils.ReportTable{t}
+}
+
+func arrayTable(name string, arr []interface{}, withUUID, charts bool) []utils.ReportTable {
+	rows := []*jsonObject{}
+	for _, item := range arr {
+		if obj, ok := item.(*jsonObject); ok {
+			rows = append(rows, flattenItem(obj)...)
+		} else {
+			row := newJSONObject()
+			row.set("value", item)
+			rows = append(rows, row)
+		}
+	}
+	return rowsTable(name, rows, withUUID, charts)
+}
+
+// itemLabel is the name of a heatmap axis item.
+func itemLabel(v interface{}) string {
+	obj, ok := v.(*jsonObject)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	if name, ok := obj.values["name"].(string); ok {
+		return name
+	}
+	for _, key := range obj.keys {
+		if s, ok := obj.values[key].(string); ok && !isUUIDColumn(key) {
+			return s
+		}
+	}
+	return ""
+}
+
+// matrixTable lays out a heatmap {rows: [...], columns: [...], matrix} as
+// one row per matrix row. The axes are the arrays as long as the matrix
+// and its rows. Returns false when obj has no such matrix.
+func matrixTable(obj *jsonObject, charts bool) (utils.ReportTable, []string, bool) {
+	matrix, ok := obj.values["matrix"].([]interface{})
+	if !ok || len(matrix) == 0 {
+		return utils.ReportTable{}, nil, false
+	}
+	first, ok := matrix[0].([]interface{})
+	if !ok {
+		return utils.ReportTable{}, nil, false
+	}
+
+	rowKey, colKey := "", ""
+	for _, key := range obj.keys {
+		arr, ok := obj.values[key].([]interface{})
+		if !ok || key == "matrix" {
+			continue
+		}
+		if rowKey == "" && len(arr) == len(matrix) {
+			rowKey = key
+		} else if colKey == "" && len(arr) == len(first) {
+			colKey = key
+		}
+	}
+	if rowKey == "" || colKey == "" {
+		return utils.ReportTable{}, nil, false
+	}
+
+	rowItems := obj.values[rowKey].([]interface{})
+	colItems := obj.values[colKey].([]interface{})
+	t := utils.ReportTable{Name: "Heatmap", Columns: []string{rowKey}}
+	for _, item := range colItems {
+		t.Columns = append(t.Columns, itemLabel(item))
+	}
+	for i, line := range matrix {
+		cells, _ := line.([]interface{})
+		values := []interface{}{itemLabel(rowItems[i])}
+		for _, v := range cells {
+			if n, ok := v.(int64); ok {
+				v = float64(n)
+			}
+			values = append(values, v)
+		}
+		t.Rows = append(t.Rows, values)
+	}
+	if charts {
+		t.Chart = pivotChart(&t, "Heatmap")
+	}
+	return t, []string{"matrix", rowKey, colKey}, true
+}
+
+// exportTables turns the JSON of a view into tables: a "Résumé" of the
+// plain values, those of data, then the other arrays of items. charts adds
+// the chart of each table.
+func exportTables(body *jsonObject, withUUID, charts bool) []utils.ReportTable {
+	tables := []utils.ReportTable{}
+	summary := newJSONObject()
+
+	switch data := body.values["data"].(type) {
+	case []interface{}:
+		tables = append(tables, arrayTable("Données", data, withUUID, charts)...)
+	case *jsonObject:
+		used := map[string]bool{}
+		if t, keys, ok := matrixTable(data, charts); ok {
+			tables = append(tables, t)
+			for _, key := range keys {
+				used[key] = true
+			}
+		}
+		for _, key := range data.keys {
+			if used[key] {
+				continue
+			}
+			switch v := data.values[key].(type) {
+			case []interface{}:
+				if isObjectArray(v) {
+					tables = append(tables, arrayTable(key, v, withUUID, charts)...)
+				} else {
+					summary.set(key, joinScalars(v))
+				}
+			case *jsonObject:
+				flattenScalars(v, key+".", summary)
+			default:
+				summary.set(key, v)
+			}
+		}
+	}
+
+	for _, key := range body.keys {
+		switch key {
+		case "status", "message", "data":
+			continue
+		}
+		switch v := body.values[key].(type) {
+		case []interface{}:
+			if isObjectArray(v) {
+				tables = append(tables, arrayTable(key, v, withUUID, charts)...)
+			} else {
+				summary.set(key, joinScalars(v))
+			}
+		case *jsonObject:
+			flattenScalars(v, key+".", summary)
+		default:
+			summary.set(key, v)
+		}
+	}
+
+	if len(summary.keys) > 0 {
+		t := utils.ReportTable{Name: "Résumé", Columns: []string{"Indicateur", "Valeur"}}
+		for _, key := range summary.keys {
+			if !withUUID && isUUIDColumn(key) {
+				continue
+			}
+			t.Rows = append(t.Rows, []interface{}{key, summary.values[key]})
+		}
+		tables = append([]utils.ReportTable{t}, tables...)
+	}
+	return tables
+}
+
+// ─────────────────────────────────────────────────────────────────────────────
+// CHARTS
+// ─────────────────────────────────────────────────────────────────────────────
+
+// measurePriority ranks the numeric columns a chart shows: rates first,
+// then any other measure.
+var measurePriority = []string{"percent", "pct", "share", "rate", "index", ""}
+
+// numericColumn reports whether every value of column i is a number.
+func numericColumn(t *utils.ReportTable, i int) bool {
+	name := t.Columns[i]
+	if isUUIDColumn(name) || strings.HasSuffix(name, "rank") || strings.HasSuffix(name, "day_of_week") {
+		return false
+	}
+	found := false
+	for _, values := range t.Rows {
+		switch values[i].(type) {
+		case int64, float64:
+			found = true
+		case nil:
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+func stringColumn(t *utils.ReportTable, i int) bool {
+	if isUUIDColumn(t.Columns[i]) {
+		return false
+	}
+	for _, values := range t.Rows {
+		if _, ok := values[i].(string); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// baseName is the column name without its "array." prefix.
+func baseName(col string) string {
+	return col[strings.LastIndex(col, ".")+1:]
+}
+
+func chartTypeFor(label string) string {
+	for _, s := range []string{"month", "date", "period", "week"} {
+		if strings.Contains(label, s) {
+			return "line"
+		}
+	}
+	return "col"
+}
+
+// exportChart picks the chart of a table: its key measures per label. When
+// the labels repeat once per brand (territory × brand, month × brand), the
+// key measure is pivoted into a label × brand table that gets the chart.
+func exportChart(t *utils.ReportTable) (*utils.ChartSpec, *utils.ReportTable) {
+	if len(t.Rows) == 0 {
+		return nil, nil
+	}
+
+	label, brand := -1, -1
+	for i, col := range t.Columns {
+		if !stringColumn(t, i) {
+			continue
+		}
+		if baseName(col) == "brand_name" {
+			if brand < 0 {
+				brand = i
+			}
+		} else if label < 0 {
+			label = i
+		}
+	}
+	if label < 0 {
+		label, brand = brand, -1
+	}
+	if label < 0 {
+		return nil, nil
+	}
+
+	var measures []int
+	for _, p := range measurePriority {
+		for i, col := range t.Columns {
+			if strings.Contains(baseName(col), p) && numericColumn(t, i) {
+				measures = append(measures, i)
+			}
+		}
+		if len(measures) > 0 {
+			break
+		}
+	}
+	if len(measures) == 0 {
+		return nil, nil
+	}
+
+	// Labels repeated across brands: chart one measure as label × brand
+	if brand >= 0 {
+		seen := map[interface{}]bool{}
+		repeated := false
+		for _, values := range t.Rows {
+			if seen[values[label]] {
+				repeated = true
+				break
+			}
+			seen[values[label]] = true
+		}
+		if repeated {
+			pivot := pivotTable(t, label, brand, measures[0])
+			return nil, &pivot
+		}
+	}
+
+	cols := []int{}
+	for _, i := range measures[:min(len(measures), 3)] {
+		cols = append(cols, i+1)
+	}
+	return &utils.ChartSpec{
+		Type:      chartTypeFor(t.Columns[label]),
+		Title:     t.Columns[measures[0]] + " / " + t.Columns[label],
+		LabelCol:  label + 1,
+		ValueCols: cols,
+	}, nil
+}
+
+// pivotTable lays out the measure column of t as one row per label and one
+// column per brand, labels and brands in order of first appearance.
+func pivotTable(t *utils.ReportTable, label, brand, measure int) utils.ReportTable {
+	p := utils.ReportTable{
+		Name:    t.Name + " - " + t.Columns[measure],
+		Columns: []string{t.Columns[label]},
+	}
+	rowIndex := map[interface{}]int{}
+	colIndex := map[interface{}]int{}
+	for _, values := range t.Rows {
+		if _, ok := colIndex[values[brand]]; !ok {
+			colIndex[values[brand]] = len(p.Columns)
+			p.Columns = append(p.Columns, fmt.Sprint(values[brand]))
+		}
+	}
+	for _, values := range t.Rows {
+		i, ok := rowIndex[values[label]]
+		if !ok {
+			i = len(p.Rows)
+			rowIndex[values[label]] = i
+			row := make([]interface{}, len(p.Columns))
+			row[0] = values[label]
+			p.Rows = append(p.Rows, row)
+		}
+		v := values[measure]
+		if n, ok := v.(int64); ok {
+			v = float64(n)
+		}
+		p.Rows[i][colIndex[values[brand]]] = v
+	}
+	p.Chart = pivotChart(&p, t.Columns[measure]+" / "+t.Columns[label])
+	return p
+}
+
+// pivotChart charts every column of a pivot table against its first one.
+func pivotChart(t *utils.ReportTable, title string) *utils.ChartSpec {
+	if len(t.Rows) == 0 || len(t.Columns) < 2 {
+		return nil
+	}
+	cols := []int{}
+	for i := 2; i <= len(t.Columns) && len(cols) < 10; i++ {
+		cols = append(cols, i)
+	}
+	return &utils.ChartSpec{Type: chartTypeFor(t.Columns[0]), Title: title, LabelCol: 1, ValueCols: cols}
+}
@@ -0,0 +1,160 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestExportTables(t *testing.T) {
+	body, err := decodeView([]byte(`{
+		"status": "success",
+		"message": "ND Province Table",
+		"level": "province",
+		"data": [
+			{"territory_uuid": "t1", "territory_name": "Kinshasa", "total": {"pos": 10},
+			 "brands": [{"brand_name": "Alpha", "nd": 50}, {"brand_name": "Zeta", "nd": 12.5}]},
+			{"territory_uuid": "t2", "territory_name": "Kongo", "total": {"pos": 4},
+			 "brands": [{"brand_name": "Alpha", "nd": 25}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := exportTables(body, false, false)
+	want := []struct {
+		name    string
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"Résumé", []string{"Indicateur", "Valeur"}, [][]interface{}{{"level", "province"}}},
+		// One row per brand, the territory repeated; nd holds a decimal so
+		// all its numbers are decimals
+		{"Données", []string{"territory_name", "total.pos", "brand_name", "nd"}, [][]interface{}{
+			{"Kinshasa", int64(10), "Alpha", 50.0},
+			{"Kinshasa", int64(10), "Zeta", 12.5},
+			{"Kongo", int64(4), "Alpha", 25.0},
+		}},
+	}
+	if len(tables) != len(want) {
+		t.Fatalf("%d tables, want %d", len(tables), len(want))
+	}
+	for i, w := range want {
+		got := tables[i]
+		if got.Name != w.name || !reflect.DeepEqual(got.Columns, w.columns) || !reflect.DeepEqual(got.Rows, w.rows) {
+			t.Errorf("table %d = %s %v %v, want %s %v %v", i, got.Name, got.Columns, got.Rows, w.name, w.columns, w.rows)
+		}
+	}
+
+	withUUID := exportTables(body, true, false)
+	if cols := withUUID[1].Columns; cols[0] != "territory_uuid" {
+		t.Errorf("columns with_uuid = %v, want territory_uuid first", cols)
+	}
+}
+
+func TestExportTablesHeatmap(t *testing.T) {
+	body, err := decodeView([]byte(`{"status": "success", "data": {
+		"brands": [{"uuid": "b1", "name": "Alpha"}, {"uuid": "b2", "name": "Zeta"}],
+		"territories": [{"uuid": "t1", "name": "Kinshasa"}, {"uuid": "t2", "name": "Kongo"}, {"uuid": "t3", "name": "Kwilu"}],
+		"matrix": [[1, 2, 3], [4, 5, 6.5]]
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := exportTables(body, false, true)
+	if len(tables) != 1 {
+		t.Fatalf("%d tables, want the heatmap only", len(tables))
+	}
+	h := tables[0]
+	if !reflect.DeepEqual(h.Columns, []string{"brands", "Kinshasa", "Kongo", "Kwilu"}) {
+		t.Errorf("columns = %v", h.Columns)
+	}
+	if !reflect.DeepEqual(h.Rows[1], []interface{}{"Zeta", 4.0, 5.0, 6.5}) {
+		t.Errorf("row of Zeta = %v", h.Rows[1])
+	}
+	if h.Chart == nil {
+		t.Error("no chart for the heatmap")
+	}
+}
+
+// exportApp serves ExportDashboardView with a fake view, test/view, that
+// needs no database.
+func exportApp(t *testing.T) *fiber.App {
+	t.Helper()
+	exportGroups["test"] = map[string]fiber.Handler{
+		"view": func(c *fiber.Ctx) error {
+			if c.Query("fail") != "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "bad view params"})
+			}
+			return c.JSON(fiber.Map{
+				"status":  "success",
+				"message": "Test view",
+				"data": []fiber.Map{
+					{"brand_uuid": "b1", "brand_name": "Alpha, Ltd", "visits": 3},
+					{"brand_uuid": "b2", "brand_name": "Zeta", "visits": 1.5},
+				},
+			})
+		},
+	}
+	t.Cleanup(func() { delete(exportGroups, "test") })
+
+	app := fiber.New()
+	app.Get("/dashboard/:metric/:view/export", ExportDashboardView)
+	return app
+}
+
+func TestExportDashboardView(t *testing.T) {
+	app := exportApp(t)
+	get := func(target string) (int, string, []byte) {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get(fiber.HeaderContentType), body
+	}
+
+	status, contentType, body := get("/dashboard/test/view/export?format=csv")
+	want := "\uFEFFbrand_name,visits\n\"Alpha, Ltd\",3\nZeta,1.5\n"
+	if status != fiber.StatusOK || !strings.HasPrefix(contentType, "text/csv") || string(body) != want {
+		t.Errorf("csv: %d %s %q, want %q", status, contentType, body, want)
+	}
+
+	status, _, body = get("/dashboard/test/view/export?level=province")
+	if status != fiber.StatusOK {
+		t.Fatalf("xlsx: status %d: %s", status, body)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, _ := f.GetRows("Données")
+	if !reflect.DeepEqual(rows, [][]string{{"brand_name", "visits"}, {"Alpha, Ltd", "3.00"}, {"Zeta", "1.50"}}) {
+		t.Errorf("data sheet = %v", rows)
+	}
+	cover, _ := f.GetRows("Couverture")
+	if !strings.Contains(fmt.Sprint(cover), "[Niveau province]") {
+		t.Errorf("cover sheet does not list the level: %v", cover)
+	}
+
+	for target, want := range map[string]int{
+		"/dashboard/test/view/export?format=pdf":                    fiber.StatusBadRequest,
+		"/dashboard/test/nothing/export":                            fiber.StatusNotFound,
+		"/dashboard/test/view/export?fail=1":                        fiber.StatusBadRequest, // The view's own error
+		"/dashboard/numeric-distribution/pie/export":                fiber.StatusNotFound,
+		"/dashboard/numeric-distribution/table-view-village/export": fiber.StatusNotFound,
+	} {
+		if status, _, body := get(target); status != want {
+			t.Errorf("%s: %d %s, want %d", target, status, body, want)
+		}
+	}
+}
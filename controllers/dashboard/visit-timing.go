@@ -0,0 +1,159 @@
+package dashboard
+
+import (
+	"gorm.io/gorm"
+)
+
+// ─────────────────────────────────────────────────────────────────────────────
+// VISIT TIMING
+// Time on site, travel time and time in market derived from the check-in /
+// check-out events of pos_forms. A visit captured without events starts and
+// ends at created_at, so it counts for first/last visit but not for time
+// on site. Travel time is the gap between the end of a visit and the start
+// of the agent's next visit the same day.
+// ─────────────────────────────────────────────────────────────────────────────
+
+// visitTimingCTE numbers the visits of each agent per day. It expects the
+// named params @users, @start_date and @end_date, end date included.
+const visitTimingCTE = `
+	visits AS (
+		SELECT
+			pf.user_uuid,
+			COALESCE(pf.check_in_at, pf.created_at)                   AS visit_start,
+			COALESCE(pf.check_out_at, pf.check_in_at, pf.created_at)  AS visit_end,
+			pf.duration_seconds
+		FROM pos_forms pf
+		WHERE pf.deleted_at IS NULL
+		  AND pf.user_uuid IN @users
+		  AND COALESCE(pf.check_in_at, pf.created_at) >= CAST(@start_date AS date)
+		  AND COALESCE(pf.check_in_at, pf.created_at) <  CAST(@end_date AS date) + 1
+	),
+	ordered AS (
+		SELECT
+			v.*,
+			DATE(v.visit_start) AS visit_day,
+			GREATEST(EXTRACT(EPOCH FROM v.visit_start - LAG(v.visit_end) OVER (
+				PARTITION BY v.user_uuid, DATE(v.visit_start) ORDER BY v.visit_start
+			)), 0) AS travel_seconds
+		FROM visits v
+	),
+	agent_days AS (
+		SELECT
+			user_uuid,
+			visit_day,
+			MIN(visit_start)                                       AS first_visit,
+			MAX(visit_end)                                         AS last_visit,
+			EXTRACT(EPOCH FROM MAX(visit_end) - MIN(visit_start))  AS time_in_market_seconds,
+			AVG(duration_seconds)                                  AS avg_time_on_site_seconds,
+			SUM(travel_seconds)                                    AS travel_seconds
+		FROM ordered
+		GROUP BY user_uuid, visit_day
+	)`
+
+// visitTiming is the time-on-site summary of one agent over a period.
+// First and last visit are the average time of day, as HH:MM.
+type visitTiming struct {
+	UserUUID           string  `json:"-"`
+	AvgTimeOnSiteMin   float64 `json:"avg_time_on_site_min"`
+	AvgTravelMin       float64 `json:"avg_travel_min"`
+	AvgTimeInMarketMin float64 `json:"avg_time_in_market_min"`
+	AvgFirstVisit      string  `json:"avg_first_visit"`
+	AvgLastVisit       string  `json:"avg_last_visit"`
+}
+
+// dailyVisitTiming is the time-on-site summary of one agent for one day.
+type dailyVisitTiming struct {
+	Day              string  `json:"-"`
+	FirstVisit       string  `json:"first_visit"`
+	LastVisit        string  `json:"last_visit"`
+	TimeInMarketMin  float64 `json:"time_in_market_min"`
+	AvgTimeOnSiteMin float64 `json:"avg_time_on_site_min"`
+	TravelMin        float64 `json:"travel_min"`
+}
+
+// agentVisitTiming returns the visit timing of each agent, keyed by user
+// uuid. start and end are compared to the visit start like the callers
+// compare created_at.
+func agentVisitTiming(db *gorm.DB, userUUIDs []string, start, end interface{}) (map[string]visitTiming, error) {
+	out := make(map[string]visitTiming, len(userUUIDs))
+	if len(userUUIDs) == 0 {
+		return out, nil
+	}
+
+	query := `
+		WITH ` + visitTimingCTE + `,
+		per_visit AS (
+			SELECT
+				user_uuid,
+				AVG(duration_seconds) AS avg_time_on_site_seconds,
+				AVG(travel_seconds)   AS avg_travel_seconds
+			FROM ordered
+			GROUP BY user_uuid
+		),
+		per_day AS (
+			SELECT
+				user_uuid,
+				AVG(time_in_market_seconds)                   AS avg_time_in_market_seconds,
+				AVG(EXTRACT(EPOCH FROM first_visit::time))    AS avg_first_seconds,
+				AVG(EXTRACT(EPOCH FROM last_visit::time))     AS avg_last_seconds
+			FROM agent_days
+			GROUP BY user_uuid
+		)
+		SELECT
+			d.user_uuid,
+			ROUND(COALESCE(v.avg_time_on_site_seconds, 0)::numeric / 60, 1)    AS avg_time_on_site_min,
+			ROUND(COALESCE(v.avg_travel_seconds, 0)::numeric / 60, 1)          AS avg_travel_min,
+			ROUND(COALESCE(d.avg_time_in_market_seconds, 0)::numeric / 60, 1)  AS avg_time_in_market_min,
+			TO_CHAR(MAKE_INTERVAL(secs => d.avg_first_seconds), 'HH24:MI')     AS avg_first_visit,
+			TO_CHAR(MAKE_INTERVAL(secs => d.avg_last_seconds), 'HH24:MI')      AS avg_last_visit
+		FROM per_day d
+		LEFT JOIN per_visit v ON v.user_uuid = d.user_uuid
+	`
+
+	var rows []visitTiming
+	err := db.Raw(query, map[string]interface{}{
+		"users":      userUUIDs,
+		"start_date": start,
+		"end_date":   end,
+	}).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		out[r.UserUUID] = r
+	}
+	return out, nil
+}
+
+// agentDailyVisitTiming returns the visit timing of one agent per day,
+// keyed by YYYY-MM-DD.
+func agentDailyVisitTiming(db *gorm.DB, userUUID string, start, end interface{}) (map[string]dailyVisitTiming, error) {
+	query := `
+		WITH ` + visitTimingCTE + `
+		SELECT
+			TO_CHAR(visit_day, 'YYYY-MM-DD')                                    AS day,
+			TO_CHAR(first_visit, 'HH24:MI')                                     AS first_visit,
+			TO_CHAR(last_visit, 'HH24:MI')                                      AS last_visit,
+			ROUND(time_in_market_seconds::numeric / 60, 1)                      AS time_in_market_min,
+			ROUND(COALESCE(avg_time_on_site_seconds, 0)::numeric / 60, 1)       AS avg_time_on_site_min,
+			ROUND(COALESCE(travel_seconds, 0)::numeric / 60, 1)                 AS travel_min
+		FROM agent_days
+	`
+
+	var rows []dailyVisitTiming
+	err := db.Raw(query, map[string]interface{}{
+		"users":      []string{userUUID},
+		"start_date": start,
+		"end_date":   end,
+	}).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]dailyVisitTiming, len(rows))
+	for _, r := range rows {
+		out[r.Day] = r
+	}
+	return out, nil
+}
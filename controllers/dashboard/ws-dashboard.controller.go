@@ -1,9 +1,6 @@
 package dashboard
 
 import (
-	"math"
-	"time"
-
 	"github.com/danny19977/mspos-api-v3/database"
 	"github.com/gofiber/fiber/v2"
 )
@@ -26,894 +23,119 @@ import (
 // ╚══════════════════════════════════════════════════════════════════════════════╝
 
 // ─────────────────────────────────────────────────────────────────────────────
-// SECTION 1 — TABLE VIEWS
+// SECTIONS 1–3 — TABLE VIEWS / BAR CHARTS / MONTHLY TREND (metric engine)
 //
 //	Each row = (territory × brand) with:
-//	  brand_sold    — total units sold at POS where brand number_farde > 0
+//	  brand_sold    — units sold of the brand, for brands with number_farde > 0
 //	  total_sold    — total units sold across ALL visited POS in the territory
 //	  ws_percent    — brand_sold / total_sold × 100
-//	  nd_pos        — distinct POS where brand number_farde > 0
+//	  nd_pos        — visits where brand number_farde > 0
 //	  total_pos     — distinct POS visited
 //	  nd_percent    — nd_pos / total_pos × 100  (for comparison)
+//
+// ?brand_uuid= (optional) — filter to a single brand
 // ─────────────────────────────────────────────────────────────────────────────
 
-// WSTableViewProvince — WS% breakdown per brand at Province level
-func WSTableViewProvince(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT
-				pf.province_uuid,
-				SUM(pfi.sold)                AS total_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS total_pos,
-				COUNT(DISTINCT pf.uuid)      AS total_posforms
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.province_uuid
-		),
-		brand_sales AS (
-			SELECT
-				pf.province_uuid,
-				pfi.brand_uuid,
-				SUM(pfi.sold)                AS brand_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			  AND pfi.number_farde > 0
-			GROUP BY pf.province_uuid, pfi.brand_uuid
-		)
-		SELECT
-			pr.name                                                              AS territory_name,
-			pr.uuid                                                              AS territory_uuid,
-			'province'                                                           AS territory_level,
-			b.name                                                               AS brand_name,
-			b.uuid                                                               AS brand_uuid,
-			bs.brand_sold,
-			COALESCE(ts.total_sold, 0)                                           AS total_sold,
-			bs.nd_pos,
-			COALESCE(ts.total_pos, 0)                                            AS total_pos,
-			ROUND((bs.brand_sold * 100.0 /
-			       NULLIF(ts.total_sold, 0))::numeric, 2)                        AS ws_percent,
-			ROUND((bs.nd_pos * 100.0 /
-			       NULLIF(ts.total_posforms, 0))::numeric, 2)                    AS nd_percent
-		FROM brand_sales bs
-		INNER JOIN brands    b  ON b.uuid  = bs.brand_uuid
-		INNER JOIN provinces pr ON pr.uuid = bs.province_uuid
-		LEFT  JOIN total_sales ts ON ts.province_uuid = bs.province_uuid
-		ORDER BY pr.name, ws_percent DESC
-	`
-
-	type WSRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		BrandSold      float64 `json:"brand_sold"`
-		TotalSold      float64 `json:"total_sold"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		WsPercent      float64 `json:"ws_percent"`
-		NdPercent      float64 `json:"nd_percent"`
-	}
-
-	var results []WSRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date,
-	}).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch WS province table", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Province Table", "data": results})
+var wsMetric = metricDefinition{
+	Key:       "weighted-sales",
+	Label:     "WS",
+	Aggregate: wsAggregate,
+	Counts:    []string{"nd_pos", "total_pos"},
+	Amounts:   []string{"brand_sold", "total_sold", "ws_percent", "nd_percent"},
+	Sort:      "ws_percent",
+	Bar: flatRows("territory_name", "territory_uuid", "brand_name", "brand_uuid",
+		"brand_sold", "total_sold", "ws_percent"),
+	Line: flatRows("month", "brand_name", "brand_uuid", "brand_sold", "total_sold", "ws_percent"),
+	Views: map[string]fiber.Handler{
+		"summary-kpi":       WSSummaryKPI,
+		"brand-ranking":     WSBrandRanking,
+		"gap-analysis":      WSGapAnalysis,
+		"heatmap":           WSHeatmap,
+		"evolution":         WSEvolution,
+		"vs-nd-correlation": WSvsNDCorrelation,
+		"pos-drill-down":    WSPosDrillDown,
+	},
 }
 
-// WSTableViewArea — WS% breakdown per brand at Area level
-func WSTableViewArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT
-				pf.area_uuid,
-				SUM(pfi.sold)                AS total_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS total_pos,
-				COUNT(DISTINCT pf.uuid)      AS total_posforms
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.area_uuid
-		),
-		brand_sales AS (
-			SELECT
-				pf.area_uuid,
-				pfi.brand_uuid,
-				SUM(pfi.sold)                AS brand_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			  AND pfi.number_farde > 0
-			GROUP BY pf.area_uuid, pfi.brand_uuid
-		)
+func wsAggregate(d metricDim) string {
+	return metricFacts(d) + `
 		SELECT
-			a.name                                                               AS territory_name,
-			a.uuid                                                               AS territory_uuid,
-			'area'                                                               AS territory_level,
-			b.name                                                               AS brand_name,
-			b.uuid                                                               AS brand_uuid,
-			bs.brand_sold,
-			COALESCE(ts.total_sold, 0)                                           AS total_sold,
-			bs.nd_pos,
-			COALESCE(ts.total_pos, 0)                                            AS total_pos,
-			ROUND((bs.brand_sold * 100.0 /
-			       NULLIF(ts.total_sold, 0))::numeric, 2)                        AS ws_percent,
-			ROUND((bs.nd_pos * 100.0 /
-			       NULLIF(ts.total_posforms, 0))::numeric, 2)                    AS nd_percent
-		FROM brand_sales bs
-		INNER JOIN brands b ON b.uuid = bs.brand_uuid
-		INNER JOIN areas  a ON a.uuid = bs.area_uuid
-		LEFT  JOIN total_sales ts ON ts.area_uuid = bs.area_uuid
-		ORDER BY a.name, ws_percent DESC
-	`
-
-	type WSRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		BrandSold      float64 `json:"brand_sold"`
-		TotalSold      float64 `json:"total_sold"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		WsPercent      float64 `json:"ws_percent"`
-		NdPercent      float64 `json:"nd_percent"`
-	}
-
-	var results []WSRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date,
-	}).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch WS area table", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Area Table", "data": results})
+			bf.dim,
+			bf.brand_uuid,
+			bf.sold          AS brand_sold,
+			t.sold           AS total_sold,
+			bf.brand_present AS nd_pos,
+			v.total_pos,
+			ROUND((bf.sold * 100.0 /
+			       NULLIF(t.sold, 0))::numeric, 2)            AS ws_percent,
+			ROUND((bf.brand_present * 100.0 /
+			       NULLIF(t.visits, 0))::numeric, 2)          AS nd_percent
+		FROM brand_facts bf
+		LEFT JOIN totals t  ON t.dim = bf.dim AND t.category_uuid = bf.category_uuid
+		LEFT JOIN visited v ON v.dim = bf.dim
+		WHERE (@brand_uuid = '' OR bf.brand_uuid = @brand_uuid)
+		  AND bf.brand_present > 0`
 }
 
-// WSTableViewSubArea — WS% breakdown per brand at SubArea level
-func WSTableViewSubArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT
-				pf.sub_area_uuid,
-				SUM(pfi.sold)                AS total_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS total_pos,
-				COUNT(DISTINCT pf.uuid)      AS total_posforms
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.sub_area_uuid
-		),
-		brand_sales AS (
-			SELECT
-				pf.sub_area_uuid,
-				pfi.brand_uuid,
-				SUM(pfi.sold)                AS brand_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			  AND pfi.number_farde > 0
-			GROUP BY pf.sub_area_uuid, pfi.brand_uuid
-		)
-		SELECT
-			sa.name                                                              AS territory_name,
-			sa.uuid                                                              AS territory_uuid,
-			'subarea'                                                            AS territory_level,
-			b.name                                                               AS brand_name,
-			b.uuid                                                               AS brand_uuid,
-			bs.brand_sold,
-			COALESCE(ts.total_sold, 0)                                           AS total_sold,
-			bs.nd_pos,
-			COALESCE(ts.total_pos, 0)                                            AS total_pos,
-			ROUND((bs.brand_sold * 100.0 /
-			       NULLIF(ts.total_sold, 0))::numeric, 2)                        AS ws_percent,
-			ROUND((bs.nd_pos * 100.0 /
-			       NULLIF(ts.total_posforms, 0))::numeric, 2)                    AS nd_percent
-		FROM brand_sales bs
-		INNER JOIN brands    b  ON b.uuid  = bs.brand_uuid
-		INNER JOIN sub_areas sa ON sa.uuid = bs.sub_area_uuid
-		LEFT  JOIN total_sales ts ON ts.sub_area_uuid = bs.sub_area_uuid
-		ORDER BY sa.name, ws_percent DESC
-	`
-
-	type WSRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		BrandSold      float64 `json:"brand_sold"`
-		TotalSold      float64 `json:"total_sold"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		WsPercent      float64 `json:"ws_percent"`
-		NdPercent      float64 `json:"nd_percent"`
-	}
+// WSTableViewProvince — WS% breakdown per brand at Province level
+func WSTableViewProvince(c *fiber.Ctx) error { return wsMetric.serve(c, "table-view", "province") }
 
-	var results []WSRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date,
-	}).Scan(&results).Error
+// WSTableViewArea — WS% breakdown per brand at Area level
+func WSTableViewArea(c *fiber.Ctx) error { return wsMetric.serve(c, "table-view", "area") }
 
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch WS subarea table", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS SubArea Table", "data": results})
-}
+// WSTableViewSubArea — WS% breakdown per brand at SubArea level
+func WSTableViewSubArea(c *fiber.Ctx) error { return wsMetric.serve(c, "table-view", "subarea") }
 
 // WSTableViewCommune — WS% breakdown per brand at Commune level
-func WSTableViewCommune(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT
-				pf.commune_uuid,
-				SUM(pfi.sold)                AS total_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS total_pos,
-				COUNT(DISTINCT pf.uuid)      AS total_posforms
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.commune_uuid
-		),
-		brand_sales AS (
-			SELECT
-				pf.commune_uuid,
-				pfi.brand_uuid,
-				SUM(pfi.sold)                AS brand_sold,
-				COUNT(DISTINCT pf.pos_uuid)  AS nd_pos
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			  AND pfi.number_farde > 0
-			GROUP BY pf.commune_uuid, pfi.brand_uuid
-		)
-		SELECT
-			co.name                                                              AS territory_name,
-			co.uuid                                                              AS territory_uuid,
-			'commune'                                                            AS territory_level,
-			b.name                                                               AS brand_name,
-			b.uuid                                                               AS brand_uuid,
-			bs.brand_sold,
-			COALESCE(ts.total_sold, 0)                                           AS total_sold,
-			bs.nd_pos,
-			COALESCE(ts.total_pos, 0)                                            AS total_pos,
-			ROUND((bs.brand_sold * 100.0 /
-			       NULLIF(ts.total_sold, 0))::numeric, 2)                        AS ws_percent,
-			ROUND((bs.nd_pos * 100.0 /
-			       NULLIF(ts.total_posforms, 0))::numeric, 2)                    AS nd_percent
-		FROM brand_sales bs
-		INNER JOIN brands    b  ON b.uuid  = bs.brand_uuid
-		INNER JOIN communes  co ON co.uuid = bs.commune_uuid
-		LEFT  JOIN total_sales ts ON ts.commune_uuid = bs.commune_uuid
-		ORDER BY co.name, ws_percent DESC
-	`
-
-	type WSRow struct {
-		TerritoryName  string  `json:"territory_name"`
-		TerritoryUUID  string  `json:"territory_uuid"`
-		TerritoryLevel string  `json:"territory_level"`
-		BrandName      string  `json:"brand_name"`
-		BrandUUID      string  `json:"brand_uuid"`
-		BrandSold      float64 `json:"brand_sold"`
-		TotalSold      float64 `json:"total_sold"`
-		NdPos          int64   `json:"nd_pos"`
-		TotalPos       int64   `json:"total_pos"`
-		WsPercent      float64 `json:"ws_percent"`
-		NdPercent      float64 `json:"nd_percent"`
-	}
-
-	var results []WSRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid":  country_uuid,
-		"province_uuid": province_uuid,
-		"area_uuid":     area_uuid,
-		"sub_area_uuid": sub_area_uuid,
-		"commune_uuid":  commune_uuid,
-		"start_date":    start_date,
-		"end_date":      end_date,
-	}).Scan(&results).Error
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error", "message": "Failed to fetch WS commune table", "error": err.Error(),
-		})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Commune Table", "data": results})
-}
-
-// ─────────────────────────────────────────────────────────────────────────────
-// SECTION 2 — BAR CHARTS
-// ─────────────────────────────────────────────────────────────────────────────
+func WSTableViewCommune(c *fiber.Ctx) error { return wsMetric.serve(c, "table-view", "commune") }
 
 // WSBarChartProvince — grouped bar chart data: WS% per brand per province
-func WSBarChartProvince(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT pf.province_uuid, SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.province_uuid
-		),
-		brand_sales AS (
-			SELECT pf.province_uuid, pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pf.province_uuid, pfi.brand_uuid
-		)
-		SELECT pr.name AS territory_name, pr.uuid AS territory_uuid,
-			b.name AS brand_name, b.uuid AS brand_uuid,
-			bs.brand_sold, COALESCE(ts.total_sold,0) AS total_sold,
-			ROUND((bs.brand_sold*100.0/NULLIF(ts.total_sold,0))::numeric,2) AS ws_percent
-		FROM brand_sales bs
-		INNER JOIN brands b ON b.uuid=bs.brand_uuid
-		INNER JOIN provinces pr ON pr.uuid=bs.province_uuid
-		LEFT JOIN total_sales ts ON ts.province_uuid=bs.province_uuid
-		ORDER BY pr.name, ws_percent DESC
-	`
-
-	type WSBarRow struct {
-		TerritoryName string  `json:"territory_name"`
-		TerritoryUUID string  `json:"territory_uuid"`
-		BrandName     string  `json:"brand_name"`
-		BrandUUID     string  `json:"brand_uuid"`
-		BrandSold     float64 `json:"brand_sold"`
-		TotalSold     float64 `json:"total_sold"`
-		WsPercent     float64 `json:"ws_percent"`
-	}
-
-	var results []WSBarRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS province bar chart", "error": err.Error()})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Province Bar Chart", "data": results})
-}
+func WSBarChartProvince(c *fiber.Ctx) error { return wsMetric.serve(c, "bar-chart", "province") }
 
 // WSBarChartArea — grouped bar chart data: WS% per brand per area
-func WSBarChartArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT pf.area_uuid, SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.area_uuid
-		),
-		brand_sales AS (
-			SELECT pf.area_uuid, pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pf.area_uuid, pfi.brand_uuid
-		)
-		SELECT a.name AS territory_name, a.uuid AS territory_uuid,
-			b.name AS brand_name, b.uuid AS brand_uuid,
-			bs.brand_sold, COALESCE(ts.total_sold,0) AS total_sold,
-			ROUND((bs.brand_sold*100.0/NULLIF(ts.total_sold,0))::numeric,2) AS ws_percent
-		FROM brand_sales bs
-		INNER JOIN brands b ON b.uuid=bs.brand_uuid
-		INNER JOIN areas a ON a.uuid=bs.area_uuid
-		LEFT JOIN total_sales ts ON ts.area_uuid=bs.area_uuid
-		ORDER BY a.name, ws_percent DESC
-	`
-
-	type WSBarRow struct {
-		TerritoryName string  `json:"territory_name"`
-		TerritoryUUID string  `json:"territory_uuid"`
-		BrandName     string  `json:"brand_name"`
-		BrandUUID     string  `json:"brand_uuid"`
-		BrandSold     float64 `json:"brand_sold"`
-		TotalSold     float64 `json:"total_sold"`
-		WsPercent     float64 `json:"ws_percent"`
-	}
-
-	var results []WSBarRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS area bar chart", "error": err.Error()})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Area Bar Chart", "data": results})
-}
+func WSBarChartArea(c *fiber.Ctx) error { return wsMetric.serve(c, "bar-chart", "area") }
 
-// WSBarChartSubArea — grouped bar chart data: WS% per brand per subarea
-func WSBarChartSubArea(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT pf.sub_area_uuid, SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.sub_area_uuid
-		),
-		brand_sales AS (
-			SELECT pf.sub_area_uuid, pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pf.sub_area_uuid, pfi.brand_uuid
-		)
-		SELECT sa.name AS territory_name, sa.uuid AS territory_uuid,
-			b.name AS brand_name, b.uuid AS brand_uuid,
-			bs.brand_sold, COALESCE(ts.total_sold,0) AS total_sold,
-			ROUND((bs.brand_sold*100.0/NULLIF(ts.total_sold,0))::numeric,2) AS ws_percent
-		FROM brand_sales bs
-		INNER JOIN brands b ON b.uuid=bs.brand_uuid
-		INNER JOIN sub_areas sa ON sa.uuid=bs.sub_area_uuid
-		LEFT JOIN total_sales ts ON ts.sub_area_uuid=bs.sub_area_uuid
-		ORDER BY sa.name, ws_percent DESC
-	`
-
-	type WSBarRow struct {
-		TerritoryName string  `json:"territory_name"`
-		TerritoryUUID string  `json:"territory_uuid"`
-		BrandName     string  `json:"brand_name"`
-		BrandUUID     string  `json:"brand_uuid"`
-		BrandSold     float64 `json:"brand_sold"`
-		TotalSold     float64 `json:"total_sold"`
-		WsPercent     float64 `json:"ws_percent"`
-	}
-
-	var results []WSBarRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS subarea bar chart", "error": err.Error()})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS SubArea Bar Chart", "data": results})
-}
+// WSBarChartSubArea — grouped bar chart data: WS% per brand per sub-area
+func WSBarChartSubArea(c *fiber.Ctx) error { return wsMetric.serve(c, "bar-chart", "subarea") }
 
 // WSBarChartCommune — grouped bar chart data: WS% per brand per commune
-func WSBarChartCommune(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH total_sales AS (
-			SELECT pf.commune_uuid, SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.commune_uuid
-		),
-		brand_sales AS (
-			SELECT pf.commune_uuid, pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pf.commune_uuid, pfi.brand_uuid
-		)
-		SELECT co.name AS territory_name, co.uuid AS territory_uuid,
-			b.name AS brand_name, b.uuid AS brand_uuid,
-			bs.brand_sold, COALESCE(ts.total_sold,0) AS total_sold,
-			ROUND((bs.brand_sold*100.0/NULLIF(ts.total_sold,0))::numeric,2) AS ws_percent
-		FROM brand_sales bs
-		INNER JOIN brands b ON b.uuid=bs.brand_uuid
-		INNER JOIN communes co ON co.uuid=bs.commune_uuid
-		LEFT JOIN total_sales ts ON ts.commune_uuid=bs.commune_uuid
-		ORDER BY co.name, ws_percent DESC
-	`
-
-	type WSBarRow struct {
-		TerritoryName string  `json:"territory_name"`
-		TerritoryUUID string  `json:"territory_uuid"`
-		BrandName     string  `json:"brand_name"`
-		BrandUUID     string  `json:"brand_uuid"`
-		BrandSold     float64 `json:"brand_sold"`
-		TotalSold     float64 `json:"total_sold"`
-		WsPercent     float64 `json:"ws_percent"`
-	}
-
-	var results []WSBarRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS commune bar chart", "error": err.Error()})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Commune Bar Chart", "data": results})
-}
-
-// ─────────────────────────────────────────────────────────────────────────────
-// SECTION 3 — MONTHLY TREND LINE CHART
-// ?brand_uuid= (optional) — filter to a single brand
-// ─────────────────────────────────────────────────────────────────────────────
+func WSBarChartCommune(c *fiber.Ctx) error { return wsMetric.serve(c, "bar-chart", "commune") }
 
 // WSLineChartByMonth — WS% per brand per calendar month
 func WSLineChartByMonth(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	brand_uuid := c.Query("brand_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
-	}
-
-	sqlQuery := `
-		WITH monthly_total AS (
-			SELECT
-				TO_CHAR(DATE_TRUNC('month', pf.created_at), 'YYYY-MM') AS month,
-				SUM(pfi.sold)                                           AS total_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY DATE_TRUNC('month', pf.created_at)
-		),
-		monthly_brand AS (
-			SELECT
-				TO_CHAR(DATE_TRUNC('month', pf.created_at), 'YYYY-MM') AS month,
-				pfi.brand_uuid,
-				SUM(pfi.sold)                                           AS brand_sold
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND (@brand_uuid    = '' OR pfi.brand_uuid   = @brand_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			  AND pfi.number_farde > 0
-			GROUP BY DATE_TRUNC('month', pf.created_at), pfi.brand_uuid
-		)
-		SELECT
-			mb.month,
-			b.name                                                               AS brand_name,
-			b.uuid                                                               AS brand_uuid,
-			mb.brand_sold,
-			COALESCE(mt.total_sold, 0)                                           AS total_sold,
-			ROUND((mb.brand_sold * 100.0 /
-			       NULLIF(mt.total_sold, 0))::numeric, 2)                        AS ws_percent
-		FROM monthly_brand mb
-		INNER JOIN brands b ON b.uuid = mb.brand_uuid
-		LEFT  JOIN monthly_total mt ON mt.month = mb.month
-		ORDER BY mb.month, ws_percent DESC
-	`
-
-	type WSMonthRow struct {
-		Month     string  `json:"month"`
-		BrandName string  `json:"brand_name"`
-		BrandUUID string  `json:"brand_uuid"`
-		BrandSold float64 `json:"brand_sold"`
-		TotalSold float64 `json:"total_sold"`
-		WsPercent float64 `json:"ws_percent"`
-	}
-
-	var results []WSMonthRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "brand_uuid": brand_uuid,
-		"start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS monthly trend", "error": err.Error()})
-	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Monthly Trend", "data": results})
+	return wsMetric.serve(c, "line-chart-by-month", "")
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
 // SECTION 4 — POWER ANALYTICS
 // ─────────────────────────────────────────────────────────────────────────────
 
-// WSSummaryKPI — single KPI card: overall WS% + brand count + total sold
+// WSSummaryKPI — single KPI card: overall WS% + brand count + total sold.
+// total_brands counts the brands on the visits, brands_with_ws those with
+// stock on shelf.
 func WSSummaryKPI(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
 
 	sqlQuery := `
-		WITH base AS (
-			SELECT pfi.brand_uuid, pfi.sold, pfi.number_farde
-			FROM pos_form_items pfi
-			INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+		WITH ` + metricCTE(wsAggregate, metricDim{Fact: metricTotal, Group: group}) + `,
+		base AS (
+			SELECT
+				COUNT(DISTINCT f.brand_uuid) FILTER (WHERE f.brand_uuid <> '') AS total_brands,
+				COALESCE(SUM(f.sold) FILTER (WHERE f.brand_uuid = ''), 0)      AS grand_total_sold
+			FROM daily_pos_facts f
+			WHERE ` + metricFactFilter + `
 		)
 		SELECT
-			COUNT(DISTINCT brand_uuid)                                           AS total_brands,
-			SUM(sold)                                                            AS grand_total_sold,
-			SUM(CASE WHEN number_farde > 0 THEN sold ELSE 0 END)                AS weighted_sold,
-			ROUND((SUM(CASE WHEN number_farde > 0 THEN sold ELSE 0 END) * 100.0 /
-			       NULLIF(SUM(sold), 0))::numeric, 2)                            AS overall_ws_percent,
-			COUNT(DISTINCT CASE WHEN number_farde > 0 THEN brand_uuid END)      AS brands_with_ws
-		FROM base
+			b.total_brands,
+			b.grand_total_sold,
+			COALESCE((SELECT SUM(brand_sold) FROM m), 0)                         AS weighted_sold,
+			ROUND((COALESCE((SELECT SUM(brand_sold) FROM m), 0) * 100.0 /
+			       NULLIF(b.grand_total_sold, 0))::numeric, 2)                   AS overall_ws_percent,
+			(SELECT COUNT(*) FROM m)                                             AS brands_with_ws
+		FROM base b
 	`
 
 	type WSKpi struct {
@@ -925,70 +147,29 @@ func WSSummaryKPI(c *fiber.Ctx) error {
 	}
 
 	var result WSKpi
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&result).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&result).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS KPI", "error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Summary KPI", "data": result})
+	return c.JSON(fiber.Map{"status": "success", "message": "WS Summary KPI", "group": group, "data": result})
 }
 
 // WSBrandRanking — brands ranked by WS%, with WS–ND gap
 func WSBrandRanking(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
 
 	sqlQuery := `
-		WITH total AS (
-			SELECT SUM(pfi.sold) AS total_sold, COUNT(DISTINCT pf.pos_uuid) AS total_pos
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		),
-		brand_agg AS (
-			SELECT pfi.brand_uuid, SUM(pfi.sold) AS brand_sold, COUNT(DISTINCT pf.pos_uuid) AS nd_pos
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pfi.brand_uuid
-		)
+		WITH ` + metricCTE(wsAggregate, metricDim{Fact: metricTotal, Group: group}) + `
 		SELECT
-			b.name AS brand_name, b.uuid AS brand_uuid,
-			ba.brand_sold, t.total_sold, ba.nd_pos, t.total_pos,
-			ROUND((ba.brand_sold*100.0/NULLIF(t.total_sold,0))::numeric,2) AS ws_percent,
-			ROUND((ba.nd_pos    *100.0/NULLIF(t.total_pos, 0))::numeric,2) AS nd_percent,
-			ROUND(((ba.brand_sold*100.0/NULLIF(t.total_sold,0)) -
-			       (ba.nd_pos    *100.0/NULLIF(t.total_pos, 0)))::numeric,2) AS ws_nd_gap,
-			RANK() OVER (ORDER BY (ba.brand_sold*100.0/NULLIF(t.total_sold,0)) DESC) AS rank
-		FROM brand_agg ba
-		CROSS JOIN total t
-		INNER JOIN brands b ON b.uuid = ba.brand_uuid
+			brand_name, brand_uuid,
+			brand_sold, total_sold, nd_pos, total_pos,
+			ws_percent,
+			nd_percent,
+			ws_percent - nd_percent                 AS ws_nd_gap,
+			RANK() OVER (ORDER BY ws_percent DESC) AS rank
+		FROM m
 		ORDER BY ws_percent DESC
 	`
 
@@ -1006,66 +187,27 @@ func WSBrandRanking(c *fiber.Ctx) error {
 	}
 
 	var results []WSRankRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS brand ranking", "error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Brand Ranking", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "WS Brand Ranking", "group": group, "data": results})
 }
 
 // WSGapAnalysis — bucket brands into 3 zones: Strong (≥66%), Mid (33-66%), Weak (<33%)
 func WSGapAnalysis(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
 	}
 
 	sqlQuery := `
-		WITH total_sales AS (
-			SELECT SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		),
-		brand_ws AS (
-			SELECT pfi.brand_uuid,
-				ROUND((SUM(pfi.sold)*100.0/NULLIF((SELECT total_sold FROM total_sales),0))::numeric,2) AS ws_percent
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pfi.brand_uuid
-		)
-		SELECT b.name AS brand_name, b.uuid AS brand_uuid, bw.ws_percent,
-			CASE WHEN bw.ws_percent >= 66 THEN 'strong'
-			     WHEN bw.ws_percent >= 33 THEN 'mid'
+		WITH ` + metricCTE(wsAggregate, metricDim{Fact: metricTotal, Group: group}) + `
+		SELECT brand_name, brand_uuid, ws_percent,
+			CASE WHEN ws_percent >= 66 THEN 'strong'
+			     WHEN ws_percent >= 33 THEN 'mid'
 			     ELSE 'weak' END AS zone
-		FROM brand_ws bw INNER JOIN brands b ON b.uuid = bw.brand_uuid
-		ORDER BY bw.ws_percent DESC
+		FROM m
+		ORDER BY ws_percent DESC
 	`
 
 	type WSGapRow struct {
@@ -1076,12 +218,7 @@ func WSGapAnalysis(c *fiber.Ctx) error {
 	}
 
 	var rows []WSGapRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&rows).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&rows).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS gap analysis", "error": err.Error()})
 	}
 
@@ -1089,7 +226,7 @@ func WSGapAnalysis(c *fiber.Ctx) error {
 	for _, r := range rows {
 		zoneSummary[r.Zone]++
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Gap Analysis", "summary": zoneSummary, "data": rows})
+	return c.JSON(fiber.Map{"status": "success", "message": "WS Gap Analysis", "group": group, "summary": zoneSummary, "data": rows})
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -1099,77 +236,27 @@ func WSGapAnalysis(c *fiber.Ctx) error {
 // WSHeatmap — brand × territory WS% matrix
 // ?level=province|area|subarea|commune  (default: province)
 func WSHeatmap(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
+	}
 	level := c.Query("level", "province")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
+	lv, ok := metricLevels[level]
+	if !ok {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
+			"status": "error", "message": "invalid level; use province|area|subarea|commune",
 		})
 	}
 
-	var territoryJoin, territoryGroup, territorySel string
-	switch level {
-	case "area":
-		territoryJoin = "INNER JOIN areas t ON t.uuid = pf.area_uuid"
-		territoryGroup = "pf.area_uuid"
-		territorySel = "pf.area_uuid AS territory_uuid, t.name AS territory_name"
-	case "subarea":
-		territoryJoin = "INNER JOIN sub_areas t ON t.uuid = pf.sub_area_uuid"
-		territoryGroup = "pf.sub_area_uuid"
-		territorySel = "pf.sub_area_uuid AS territory_uuid, t.name AS territory_name"
-	case "commune":
-		territoryJoin = "INNER JOIN communes t ON t.uuid = pf.commune_uuid"
-		territoryGroup = "pf.commune_uuid"
-		territorySel = "pf.commune_uuid AS territory_uuid, t.name AS territory_name"
-	default:
-		territoryJoin = "INNER JOIN provinces t ON t.uuid = pf.province_uuid"
-		territoryGroup = "pf.province_uuid"
-		territorySel = "pf.province_uuid AS territory_uuid, t.name AS territory_name"
-	}
-
 	sqlQuery := `
-		WITH total_sales AS (
-			SELECT ` + territoryGroup + ` AS territory_uuid, SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY ` + territoryGroup + `
-		),
-		brand_sales AS (
-			SELECT ` + territorySel + `, pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			` + territoryJoin + `
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY ` + territoryGroup + `, t.name, pfi.brand_uuid
-		)
-		SELECT bs.territory_uuid, bs.territory_name,
-			b.uuid AS brand_uuid, b.name AS brand_name,
-			bs.brand_sold, COALESCE(ts.total_sold,0) AS total_sold,
-			ROUND((bs.brand_sold*100.0/NULLIF(ts.total_sold,0))::numeric,2) AS ws_percent
-		FROM brand_sales bs
-		INNER JOIN brands b ON b.uuid = bs.brand_uuid
-		LEFT  JOIN total_sales ts ON ts.territory_uuid = bs.territory_uuid
-		ORDER BY bs.territory_name, ws_percent DESC
+		WITH ` + metricCTE(wsAggregate, metricDim{Fact: "f." + lv.Column, Group: group}) + `
+		SELECT t.uuid AS territory_uuid, t.name AS territory_name,
+			m.brand_uuid, m.brand_name,
+			m.brand_sold, COALESCE(m.total_sold, 0) AS total_sold,
+			m.ws_percent
+		FROM m
+		INNER JOIN ` + lv.Table + ` t ON t.uuid = m.dim
+		ORDER BY t.name, m.ws_percent DESC
 	`
 
 	type WSHeatRow struct {
@@ -1183,100 +270,33 @@ func WSHeatmap(c *fiber.Ctx) error {
 	}
 
 	var results []WSHeatRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS heatmap", "error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Heatmap", "level": level, "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "WS Heatmap", "level": level, "group": group, "data": results})
 }
 
 // WSEvolution — period-over-period WS% comparison (current vs previous window of same length)
 func WSEvolution(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
+	}
+	params, err := periodParams(params)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
+			"status": "error", "message": "start_date and end_date must be YYYY-MM-DD",
 		})
 	}
 
-	// Compute previous period in Go to avoid @param::date casting issues in GORM
-	currStart, _ := time.Parse("2006-01-02", start_date)
-	currEnd, _ := time.Parse("2006-01-02", end_date)
-	periodDays := int(currEnd.Sub(currStart).Hours()/24) + 1
-	prevEnd := currStart.AddDate(0, 0, -1)
-	prevStart := prevEnd.AddDate(0, 0, -(periodDays - 1))
-	prev_start_date := prevStart.Format("2006-01-02")
-	prev_end_date := prevEnd.Format("2006-01-02")
-
 	sqlQuery := `
-		WITH curr_total AS (
-			SELECT SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		),
-		prev_total AS (
-			SELECT SUM(pfi.sold) AS total_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @prev_start_date AND @prev_end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		),
-		curr_brand AS (
-			SELECT pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pfi.brand_uuid
-		),
-		prev_brand AS (
-			SELECT pfi.brand_uuid, SUM(pfi.sold) AS brand_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @prev_start_date AND @prev_end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pfi.brand_uuid
-		)
-		SELECT b.name AS brand_name, b.uuid AS brand_uuid,
-			ROUND((COALESCE(cb.brand_sold,0)*100.0/NULLIF((SELECT total_sold FROM curr_total),0))::numeric,2) AS curr_ws_percent,
-			ROUND((COALESCE(pb.brand_sold,0)*100.0/NULLIF((SELECT total_sold FROM prev_total),0))::numeric,2) AS prev_ws_percent,
-			ROUND(((COALESCE(cb.brand_sold,0)*100.0/NULLIF((SELECT total_sold FROM curr_total),0)) -
-			       (COALESCE(pb.brand_sold,0)*100.0/NULLIF((SELECT total_sold FROM prev_total),0)))::numeric,2) AS delta_ws
-		FROM brands b
-		LEFT JOIN curr_brand cb ON cb.brand_uuid = b.uuid
-		LEFT JOIN prev_brand pb ON pb.brand_uuid = b.uuid
-		WHERE cb.brand_uuid IS NOT NULL OR pb.brand_uuid IS NOT NULL
+		WITH ` + metricCTE(wsAggregate, metricDim{Fact: metricPeriods, Group: group}) + `,
+		` + metricEvolution("m", []string{"ws_percent"}, nil) + `
+		SELECT brand_name, brand_uuid,
+			current_ws_percent                        AS curr_ws_percent,
+			previous_ws_percent                       AS prev_ws_percent,
+			current_ws_percent - previous_ws_percent  AS delta_ws
+		FROM evolution
 		ORDER BY curr_ws_percent DESC
 	`
 
@@ -1289,79 +309,38 @@ func WSEvolution(c *fiber.Ctx) error {
 	}
 
 	var results []WSEvoRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid,
-		"start_date":   start_date, "end_date": end_date,
-		"prev_start_date": prev_start_date, "prev_end_date": prev_end_date,
-	}).Scan(&results).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS evolution", "error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS Evolution", "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "WS Evolution", "group": group, "data": results})
 }
 
 // WSvsNDCorrelation — WS × ND quadrant matrix per brand
 // ?threshold=50  (default 50)
 func WSvsNDCorrelation(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
+	params, group, msg := viewParams(c)
+	if params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": msg})
+	}
 	threshold := 50.0
-	_ = math.IsNaN(threshold) // keep math import used
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error", "message": "country_uuid, start_date and end_date are required",
-		})
+	if t := c.QueryFloat("threshold"); t > 0 {
+		threshold = t
 	}
+	params["threshold"] = threshold
 
 	sqlQuery := `
-		WITH total AS (
-			SELECT SUM(pfi.sold) AS total_sold, COUNT(DISTINCT pf.pos_uuid) AS total_pos
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-		),
-		brand_agg AS (
-			SELECT pfi.brand_uuid, SUM(pfi.sold) AS brand_sold, COUNT(DISTINCT pf.pos_uuid) AS nd_pos
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid
-			  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-			  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-			  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-			  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-			  AND pf.created_at BETWEEN @start_date AND @end_date
-			  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL AND pfi.number_farde > 0
-			GROUP BY pfi.brand_uuid
-		)
-		SELECT b.name AS brand_name, b.uuid AS brand_uuid,
-			ba.brand_sold, t.total_sold, ba.nd_pos, t.total_pos,
-			ROUND((ba.brand_sold*100.0/NULLIF(t.total_sold,0))::numeric,2) AS ws_percent,
-			ROUND((ba.nd_pos    *100.0/NULLIF(t.total_pos, 0))::numeric,2) AS nd_percent,
+		WITH ` + metricCTE(wsAggregate, metricDim{Fact: metricTotal, Group: group}) + `
+		SELECT brand_name, brand_uuid,
+			brand_sold, total_sold, nd_pos, total_pos,
+			ws_percent,
+			nd_percent,
 			CASE
-				WHEN (ba.brand_sold*100.0/NULLIF(t.total_sold,0)) >= @threshold
-				 AND (ba.nd_pos    *100.0/NULLIF(t.total_pos, 0)) >= @threshold THEN 'leader'
-				WHEN (ba.brand_sold*100.0/NULLIF(t.total_sold,0)) >= @threshold
-				 AND (ba.nd_pos    *100.0/NULLIF(t.total_pos, 0)) <  @threshold THEN 'niche'
-				WHEN (ba.brand_sold*100.0/NULLIF(t.total_sold,0)) <  @threshold
-				 AND (ba.nd_pos    *100.0/NULLIF(t.total_pos, 0)) >= @threshold THEN 'volume'
+				WHEN ws_percent >= @threshold AND nd_percent >= @threshold THEN 'leader'
+				WHEN ws_percent >= @threshold                              THEN 'niche'
+				WHEN nd_percent >= @threshold                              THEN 'volume'
 				ELSE 'laggard'
 			END AS segment
-		FROM brand_agg ba CROSS JOIN total t INNER JOIN brands b ON b.uuid = ba.brand_uuid
+		FROM m
 		ORDER BY ws_percent DESC
 	`
 
@@ -1378,49 +357,47 @@ func WSvsNDCorrelation(c *fiber.Ctx) error {
 	}
 
 	var results []WSCorrRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "start_date": start_date, "end_date": end_date,
-		"threshold": threshold,
-	}).Scan(&results).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS vs ND correlation", "error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"status": "success", "message": "WS vs ND Correlation", "threshold": threshold, "data": results})
+	return c.JSON(fiber.Map{"status": "success", "message": "WS vs ND Correlation", "threshold": threshold, "group": group, "data": results})
 }
 
 // WSPosDrillDown — POS-level WS deep-dive for a specific brand
 // ?brand_uuid= (required)
+//
+// One row per visit line of the brand, with its visit: it stays on
+// pos_forms and pos_form_items, the facts having no visits.
 func WSPosDrillDown(c *fiber.Ctx) error {
-	db := database.DB
-
-	country_uuid := c.Query("country_uuid")
-	province_uuid := c.Query("province_uuid")
-	area_uuid := c.Query("area_uuid")
-	sub_area_uuid := c.Query("sub_area_uuid")
-	commune_uuid := c.Query("commune_uuid")
-	brand_uuid := c.Query("brand_uuid")
-	start_date := c.Query("start_date")
-	end_date := c.Query("end_date")
-
-	if start_date == "" || end_date == "" || country_uuid == "" {
+	params := metricParams(c)
+	if params == nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status": "error", "message": "country_uuid, start_date and end_date are required",
 		})
 	}
-	if brand_uuid == "" {
+	if params["brand_uuid"] == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status": "error", "message": "brand_uuid is required for POS drill-down",
 		})
 	}
+	if !validSegment(params["segment"]) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "error", "message": "invalid segment; use A|B|C",
+		})
+	}
 
 	sqlQuery := `
-		WITH pos_total AS (
-			SELECT pf.uuid AS pos_form_uuid, SUM(pfi.sold) AS pos_sold
-			FROM pos_form_items pfi INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
-			WHERE pf.country_uuid = @country_uuid AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
-			GROUP BY pf.uuid
+		WITH forms AS (
+			SELECT pf.uuid, pf.pos_uuid, pf.created_at
+			FROM pos_forms pf
+			WHERE ` + metricFormFilter + `
+		),
+		pos_total AS (
+			SELECT pfi.pos_form_uuid, SUM(pfi.sold) AS pos_sold
+			FROM pos_form_items pfi
+			INNER JOIN forms pf ON pf.uuid = pfi.pos_form_uuid
+			WHERE pfi.deleted_at IS NULL
+			GROUP BY pfi.pos_form_uuid
 		)
 		SELECT
 			p.name                                                               AS pos_name,
@@ -1433,18 +410,12 @@ func WSPosDrillDown(c *fiber.Ctx) error {
 			ROUND((pfi.sold*100.0/NULLIF(pt.pos_sold,0))::numeric,2)            AS ws_contribution,
 			pf.created_at::date                                                  AS visit_date
 		FROM pos_form_items pfi
-		INNER JOIN pos_forms pf ON pfi.pos_form_uuid = pf.uuid
+		INNER JOIN forms     pf ON pfi.pos_form_uuid = pf.uuid
 		INNER JOIN pos        p ON p.uuid = pf.pos_uuid
 		INNER JOIN brands     b ON b.uuid = pfi.brand_uuid
 		LEFT  JOIN pos_total pt ON pt.pos_form_uuid = pf.uuid
-		WHERE pf.country_uuid = @country_uuid
-		  AND (@province_uuid = '' OR pf.province_uuid = @province_uuid)
-		  AND (@area_uuid     = '' OR pf.area_uuid     = @area_uuid)
-		  AND (@sub_area_uuid = '' OR pf.sub_area_uuid = @sub_area_uuid)
-		  AND (@commune_uuid  = '' OR pf.commune_uuid  = @commune_uuid)
-		  AND pfi.brand_uuid = @brand_uuid
-		  AND pf.created_at BETWEEN @start_date AND @end_date
-		  AND pf.deleted_at IS NULL AND pfi.deleted_at IS NULL
+		WHERE pfi.brand_uuid = @brand_uuid
+		  AND pfi.deleted_at IS NULL
 		ORDER BY ws_contribution DESC, visit_date DESC
 	`
 
@@ -1462,13 +433,7 @@ func WSPosDrillDown(c *fiber.Ctx) error {
 	}
 
 	var results []WSDrillRow
-	err := db.Raw(sqlQuery, map[string]interface{}{
-		"country_uuid": country_uuid, "province_uuid": province_uuid,
-		"area_uuid": area_uuid, "sub_area_uuid": sub_area_uuid,
-		"commune_uuid": commune_uuid, "brand_uuid": brand_uuid,
-		"start_date": start_date, "end_date": end_date,
-	}).Scan(&results).Error
-	if err != nil {
+	if err := database.DB.Raw(sqlQuery, params).Scan(&results).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to fetch WS POS drill-down", "error": err.Error()})
 	}
 	return c.JSON(fiber.Map{"status": "success", "message": "WS POS Drill-Down", "data": results})
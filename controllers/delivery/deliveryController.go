@@ -0,0 +1,286 @@
+package delivery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// deliveryInput is a delivery as sent by the client, delivered_at being a
+// RFC 3339 time or a "2006-01-02" date.
+type deliveryInput struct {
+	PosUUID     string  `json:"pos_uuid"`
+	BrandUUID   string  `json:"brand_uuid"`
+	DeliveredAt string  `json:"delivered_at"`
+	Fardes      float64 `json:"fardes"`
+	Signature   string  `json:"signature"`
+}
+
+// apply validates the input and copies it to d.
+func (in *deliveryInput) apply(d *models.Delivery) error {
+	posUUID := strings.TrimSpace(in.PosUUID)
+	brandUUID := strings.TrimSpace(in.BrandUUID)
+	if posUUID == "" || brandUUID == "" {
+		return fmt.Errorf("pos_uuid and brand_uuid are required")
+	}
+	var count int64
+	database.DB.Model(&models.Pos{}).Where("uuid = ?", posUUID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("unknown pos_uuid")
+	}
+	database.DB.Model(&models.Brand{}).Where("uuid = ?", brandUUID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("unknown brand_uuid")
+	}
+	deliveredAt, err := time.Parse(time.RFC3339, strings.TrimSpace(in.DeliveredAt))
+	if err != nil {
+		if deliveredAt, err = utils.ParseDay(strings.TrimSpace(in.DeliveredAt)); err != nil {
+			return fmt.Errorf("delivered_at must be a time (RFC 3339) or a date (YYYY-MM-DD)")
+		}
+	}
+	if deliveredAt.After(time.Now()) {
+		return fmt.Errorf("delivered_at is in the future")
+	}
+	if in.Fardes <= 0 {
+		return fmt.Errorf("fardes must be positive")
+	}
+
+	d.PosUUID = posUUID
+	d.BrandUUID = brandUUID
+	d.DeliveredAt = deliveredAt
+	d.Fardes = in.Fardes
+	d.Signature = in.Signature
+	return nil
+}
+
+// invalidateDashboards drops the cached dashboard responses, whose
+// sell-out estimates may have changed.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedDeliveries(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := db.Model(&models.Delivery{})
+	for _, param := range []string{"pos_uuid", "brand_uuid"} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(param+" = ?", v)
+		}
+	}
+	if start := c.Query("start_date"); start != "" {
+		query = query.Where("delivered_at >= ?", start)
+	}
+	if end := c.Query("end_date"); end != "" {
+		query = query.Where("delivered_at <= ?", end+" 23:59:59")
+	}
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var dataList []models.Delivery
+	err = query.
+		Preload("Pos").
+		Preload("Brand").
+		Offset(offset).
+		Limit(limit).
+		Order("delivered_at DESC").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch deliveries",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "deliveries retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data of a POS
+func GetAllDeliveriesByPos(c *fiber.Ctx) error {
+	posUUID := c.Params("pos_uuid")
+	db := database.DB
+
+	var data []models.Delivery
+	db.Preload("Brand").Where("pos_uuid = ?", posUUID).Order("delivered_at DESC").Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All deliveries of the POS",
+		"data":    data,
+	})
+}
+
+// Get one data
+func GetOneDelivery(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var delivery models.Delivery
+	db.Preload("Pos").Preload("Brand").Where("uuid = ?", uuid).First(&delivery)
+	if delivery.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No delivery found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "delivery found",
+			"data":    delivery,
+		},
+	)
+}
+
+// Create data
+func CreateDelivery(c *fiber.Ctx) error {
+	var input deliveryInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	d := &models.Delivery{UUID: uuid.New().String()}
+	if err := input.apply(d); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid delivery",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := database.DB.Omit("Pos", "Brand").Create(d).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create delivery",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "delivery created success",
+			"data":    d,
+		},
+	)
+}
+
+// Update data
+func UpdateDelivery(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input deliveryInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	var delivery models.Delivery
+	db.Where("uuid = ?", uuid).First(&delivery)
+	if delivery.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No delivery found",
+			"data":    nil,
+		})
+	}
+
+	if err := input.apply(&delivery); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid delivery",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := db.Omit("Pos", "Brand").Save(&delivery).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update delivery",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "delivery updated success",
+			"data":    delivery,
+		},
+	)
+}
+
+// Delete data
+func DeleteDelivery(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var delivery models.Delivery
+	db.Where("uuid = ?", uuid).First(&delivery)
+	if delivery.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No delivery found",
+				"data":    nil,
+			},
+		)
+	}
+
+	db.Delete(&delivery)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "delivery deleted success",
+			"data":    nil,
+		},
+	)
+}
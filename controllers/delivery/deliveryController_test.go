@@ -0,0 +1,116 @@
+package delivery
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// known is a connector counting one row for the uuids it holds, and none
+// for the others.
+type known map[string]bool
+
+func (k known) Connect(context.Context) (driver.Conn, error) { return k, nil }
+func (known) Driver() driver.Driver                          { return nil }
+func (k known) Prepare(string) (driver.Stmt, error)          { return knownStmt{k}, nil }
+func (known) Close() error                                   { return nil }
+func (known) Begin() (driver.Tx, error)                      { return nil, driver.ErrSkip }
+
+type knownStmt struct{ k known }
+
+func (knownStmt) Close() error                               { return nil }
+func (knownStmt) NumInput() int                              { return -1 }
+func (knownStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (s knownStmt) Query(args []driver.Value) (driver.Rows, error) {
+	count := int64(0)
+	if uuid, _ := args[0].(string); s.k[uuid] {
+		count = 1
+	}
+	return &countRows{count: count}, nil
+}
+
+type countRows struct {
+	count int64
+	done  bool
+}
+
+func (*countRows) Columns() []string { return []string{"count"} }
+func (*countRows) Close() error      { return nil }
+func (r *countRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.count
+	return nil
+}
+
+func TestDeliveryInputApply(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(known{"pos1": true, "primus": true})}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = saved })
+
+	t.Run("date", func(t *testing.T) {
+		var d models.Delivery
+		in := deliveryInput{PosUUID: " pos1", BrandUUID: "primus ", DeliveredAt: "2024-09-30", Fardes: 12.5, Signature: "depot"}
+		if err := in.apply(&d); err != nil {
+			t.Fatal(err)
+		}
+		want := models.Delivery{PosUUID: "pos1", BrandUUID: "primus", DeliveredAt: time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC), Fardes: 12.5, Signature: "depot"}
+		if d.PosUUID != want.PosUUID || d.BrandUUID != want.BrandUUID || !d.DeliveredAt.Equal(want.DeliveredAt) || d.Fardes != want.Fardes || d.Signature != want.Signature {
+			t.Errorf("delivery = %+v, want %+v", d, want)
+		}
+	})
+
+	t.Run("time", func(t *testing.T) {
+		var d models.Delivery
+		in := deliveryInput{PosUUID: "pos1", BrandUUID: "primus", DeliveredAt: "2024-09-30T14:20:00+01:00", Fardes: 3}
+		if err := in.apply(&d); err != nil {
+			t.Fatal(err)
+		}
+		if want := time.Date(2024, 9, 30, 13, 20, 0, 0, time.UTC); !d.DeliveredAt.Equal(want) {
+			t.Errorf("delivered at %v, want %v", d.DeliveredAt, want)
+		}
+	})
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(time.RFC3339)
+	tests := []struct {
+		in   deliveryInput
+		want string
+	}{
+		{deliveryInput{BrandUUID: "primus", DeliveredAt: "2024-09-30", Fardes: 1}, "pos_uuid and brand_uuid are required"},
+		{deliveryInput{PosUUID: "pos1", BrandUUID: "  ", DeliveredAt: "2024-09-30", Fardes: 1}, "pos_uuid and brand_uuid are required"},
+		{deliveryInput{PosUUID: "pos9", BrandUUID: "primus", DeliveredAt: "2024-09-30", Fardes: 1}, "unknown pos_uuid"},
+		{deliveryInput{PosUUID: "pos1", BrandUUID: "tembo", DeliveredAt: "2024-09-30", Fardes: 1}, "unknown brand_uuid"},
+		{deliveryInput{PosUUID: "pos1", BrandUUID: "primus", DeliveredAt: "30/09/2026", Fardes: 1}, "delivered_at must be a time (RFC 3339) or a date (YYYY-MM-DD)"},
+		{deliveryInput{PosUUID: "pos1", BrandUUID: "primus", DeliveredAt: tomorrow, Fardes: 1}, "delivered_at is in the future"},
+		{deliveryInput{PosUUID: "pos1", BrandUUID: "primus", DeliveredAt: "2024-09-30"}, "fardes must be positive"},
+		{deliveryInput{PosUUID: "pos1", BrandUUID: "primus", DeliveredAt: "2024-09-30", Fardes: -4}, "fardes must be positive"},
+	}
+	for _, tt := range tests {
+		d := models.Delivery{PosUUID: "untouched"}
+		err := tt.in.apply(&d)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%+v: error %v, want %q", tt.in, err, tt.want)
+		}
+		if d.PosUUID != "untouched" {
+			t.Errorf("%+v: delivery changed to %+v", tt.in, d)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package exchangerate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// rateInput is an exchange rate as sent by the client, with its date as
+// "2006-01-02".
+type rateInput struct {
+	Date          string  `json:"date"`
+	BaseCurrency  string  `json:"base_currency"`
+	QuoteCurrency string  `json:"quote_currency"`
+	Rate          float64 `json:"rate"`
+	Signature     string  `json:"signature"`
+}
+
+// apply validates the input and copies it to r.
+func (in *rateInput) apply(r *models.ExchangeRate) error {
+	date, err := utils.ParseDay(strings.TrimSpace(in.Date))
+	if err != nil {
+		return fmt.Errorf("date must be a date (YYYY-MM-DD)")
+	}
+	if strings.TrimSpace(in.BaseCurrency) == "" || strings.TrimSpace(in.QuoteCurrency) == "" {
+		return fmt.Errorf("base_currency and quote_currency are required")
+	}
+	base, err := utils.NormalizeCurrency(in.BaseCurrency)
+	if err != nil {
+		return err
+	}
+	quote, err := utils.NormalizeCurrency(in.QuoteCurrency)
+	if err != nil {
+		return err
+	}
+	if base == quote {
+		return fmt.Errorf("base_currency and quote_currency must differ")
+	}
+	if in.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+
+	r.Date = date
+	r.BaseCurrency = base
+	r.QuoteCurrency = quote
+	r.Rate = in.Rate
+	r.Signature = in.Signature
+	return nil
+}
+
+// invalidateDashboards drops the cached dashboard responses, whose
+// converted revenue may have changed.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedExchangeRates(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := db.Model(&models.ExchangeRate{})
+	if currency := strings.ToUpper(c.Query("currency")); currency != "" {
+		query = query.Where("base_currency = ? OR quote_currency = ?", currency, currency)
+	}
+	if start := c.Query("start_date"); start != "" {
+		query = query.Where("date >= ?", start)
+	}
+	if end := c.Query("end_date"); end != "" {
+		query = query.Where("date <= ?", end)
+	}
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var dataList []models.ExchangeRate
+	err = query.
+		Offset(offset).
+		Limit(limit).
+		Order("date DESC, base_currency, quote_currency").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch exchange rates",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "exchange rates retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data
+func GetAllExchangeRates(c *fiber.Ctx) error {
+	db := database.DB
+
+	query := db.Order("date DESC, base_currency, quote_currency")
+	if currency := strings.ToUpper(c.Query("currency")); currency != "" {
+		query = query.Where("base_currency = ? OR quote_currency = ?", currency, currency)
+	}
+
+	var data []models.ExchangeRate
+	query.Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All exchange rates",
+		"data":    data,
+	})
+}
+
+// Get one data
+func GetOneExchangeRate(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var rate models.ExchangeRate
+	db.Where("uuid = ?", uuid).First(&rate)
+	if rate.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No exchange rate found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "exchange rate found",
+			"data":    rate,
+		},
+	)
+}
+
+// Create data
+func CreateExchangeRate(c *fiber.Ctx) error {
+	var input rateInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	r := &models.ExchangeRate{UUID: uuid.New().String()}
+	if err := input.apply(r); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid exchange rate",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := database.DB.Create(r).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create exchange rate",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "exchange rate created success",
+			"data":    r,
+		},
+	)
+}
+
+// Update data
+func UpdateExchangeRate(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input rateInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	var rate models.ExchangeRate
+	db.Where("uuid = ?", uuid).First(&rate)
+	if rate.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No exchange rate found",
+			"data":    nil,
+		})
+	}
+
+	if err := input.apply(&rate); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid exchange rate",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := db.Save(&rate).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update exchange rate",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "exchange rate updated success",
+			"data":    rate,
+		},
+	)
+}
+
+// Delete data
+func DeleteExchangeRate(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var rate models.ExchangeRate
+	db.Where("uuid = ?", uuid).First(&rate)
+	if rate.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No exchange rate found",
+				"data":    nil,
+			},
+		)
+	}
+
+	db.Delete(&rate)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "exchange rate deleted success",
+			"data":    nil,
+		},
+	)
+}
@@ -0,0 +1,36 @@
+package exchangerate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestRateInputApply(t *testing.T) {
+	var r models.ExchangeRate
+	in := rateInput{Date: " 2026-10-01 ", BaseCurrency: "usd", QuoteCurrency: " cdf", Rate: 2850.5, Signature: "ops"}
+	if err := in.apply(&r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Date.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) || r.BaseCurrency != "USD" || r.QuoteCurrency != "CDF" || r.Rate != 2850.5 || r.Signature != "ops" {
+		t.Errorf("rate = %+v", r)
+	}
+
+	for name, in := range map[string]rateInput{
+		"no date":         {BaseCurrency: "USD", QuoteCurrency: "CDF", Rate: 1},
+		"no base":         {Date: "2026-10-01", QuoteCurrency: "CDF", Rate: 1},
+		"bad quote":       {Date: "2026-10-01", BaseCurrency: "USD", QuoteCurrency: "FC", Rate: 1},
+		"same currencies": {Date: "2026-10-01", BaseCurrency: "usd", QuoteCurrency: "USD", Rate: 1},
+		"zero rate":       {Date: "2026-10-01", BaseCurrency: "USD", QuoteCurrency: "CDF"},
+		"negative rate":   {Date: "2026-10-01", BaseCurrency: "USD", QuoteCurrency: "CDF", Rate: -2},
+	} {
+		before := r
+		if err := in.apply(&r); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		if r != before {
+			t.Errorf("%s: rate changed to %+v", name, r)
+		}
+	}
+}
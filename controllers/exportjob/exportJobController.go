@@ -0,0 +1,219 @@
+package exportjob
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+)
+
+// findJob loads the job of the uuid param if the caller owns it. Support
+// users see every job.
+func findJob(c *fiber.Ctx) (*models.ExportJob, error) {
+	user := middlewares.CurrentUser(c)
+
+	query := database.DB.Where("uuid = ?", c.Params("uuid"))
+	if user == nil || middlewares.NormalizeRole(user.Role) != middlewares.RoleSupport {
+		userUUID := ""
+		if user != nil {
+			userUUID = user.UUID
+		}
+		query = query.Where("user_uuid = ?", userUUID)
+	}
+
+	var job models.ExportJob
+	if err := query.First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CreateExportJob queues the report of the kind param, built from the
+// query params of its synchronous route. stream=true streams the detail
+// rows and lifts their limit.
+func CreateExportJob(c *fiber.Ctx) error {
+	kind := c.Params("kind")
+	if !knownKind(kind) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Unknown report",
+			"data":    KnownKinds(),
+		})
+	}
+
+	user := middlewares.CurrentUser(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "unauthenticated",
+		})
+	}
+
+	// Keep the (scoped) report params, without the token and job options
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	c.Request().URI().QueryArgs().CopyTo(args)
+	args.Del("token")
+	args.Del("stream")
+
+	job := &models.ExportJob{
+		UUID:     uuid.New().String(),
+		UserUUID: user.UUID,
+		Kind:     kind,
+		Query:    string(args.QueryString()),
+		Stream:   c.QueryBool("stream"),
+		Status:   models.ExportQueued,
+	}
+	if err := database.DB.Create(job).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create export job",
+			"error":   err.Error(),
+		})
+	}
+
+	if !enqueue(task{jobUUID: job.UUID, user: user}) {
+		database.DB.Model(job).Updates(map[string]interface{}{
+			"status": models.ExportFailed,
+			"error":  "File d'attente des exports pleine",
+		})
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Too many exports in progress, try again later",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": "export job queued",
+		"data":    job,
+	})
+}
+
+// GetExportJobs lists the latest export jobs of the caller.
+func GetExportJobs(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+	if user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "unauthenticated",
+		})
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	query := database.DB.Where("user_uuid = ?", user.UUID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var jobs []models.ExportJob
+	if err := query.Order("created_at DESC").Limit(limit).Find(&jobs).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch export jobs",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "export jobs",
+		"data":    jobs,
+	})
+}
+
+// GetExportJob returns the status and progress of a job.
+func GetExportJob(c *fiber.Ctx) error {
+	job, err := findJob(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No export job found",
+			"data":    nil,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "export job found",
+		"data":    job,
+	})
+}
+
+// DownloadExportJob sends the file of a finished job.
+func DownloadExportJob(c *fiber.Ctx) error {
+	job, err := findJob(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No export job found",
+			"data":    nil,
+		})
+	}
+
+	switch job.Status {
+	case models.ExportDone:
+	case models.ExportExpired:
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The export file has expired, submit the export again",
+			"data":    job,
+		})
+	default:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The export is not finished",
+			"data":    job,
+		})
+	}
+
+	if _, err := os.Stat(job.FilePath); err != nil {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The export file is no longer available",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.Download(job.FilePath, job.FileName)
+}
+
+// DeleteExportJob removes a job and its file.
+func DeleteExportJob(c *fiber.Ctx) error {
+	job, err := findJob(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No export job found",
+			"data":    nil,
+		})
+	}
+	if job.Status == models.ExportQueued || job.Status == models.ExportRunning {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The export is in progress",
+			"data":    job,
+		})
+	}
+
+	if job.FilePath != "" {
+		os.Remove(job.FilePath)
+	}
+	database.DB.Delete(job)
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "export job deleted",
+		"data":    nil,
+	})
+}
@@ -0,0 +1,291 @@
+package exportjob
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/dashboard"
+	"github.com/danny19977/mspos-api-v3/controllers/pos"
+	"github.com/danny19977/mspos-api-v3/controllers/posform"
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Reports are the reports an export job can build, by kind. Each takes the
+// query params of its synchronous route.
+var Reports = map[string]utils.ReportBuilder{
+	"pos":      pos.BuildPosExcelReport,
+	"posforms": posform.BuildPosFormExcelReport,
+	"kpi":      dashboard.BuildKPIExcel,
+}
+
+// Documents are the reports built as other files than workbooks, by kind.
+var Documents = map[string]utils.DocumentBuilder{
+	"executive": dashboard.BuildExecutivePDF,
+}
+
+// knownKind reports whether kind is a key of Reports or Documents.
+func knownKind(kind string) bool {
+	_, report := Reports[kind]
+	_, document := Documents[kind]
+	return report || document
+}
+
+// task is a queued job with the user it runs as.
+type task struct {
+	jobUUID string
+	user    *models.User
+}
+
+var (
+	queue     chan task
+	exportDir string
+	retention time.Duration
+	startOnce sync.Once
+
+	// replayApp provides the contexts the report builders read their
+	// params and user from
+	replayApp = fiber.New()
+)
+
+// Start starts the export workers and the removal of expired files. It is
+// configured by EXPORT_WORKERS (concurrent jobs, 2 by default),
+// EXPORT_QUEUE_SIZE (waiting jobs, 100), EXPORT_DIR (./exports) and
+// EXPORT_RETENTION_HOURS (24).
+func Start() {
+	startOnce.Do(func() {
+		exportDir = utils.Env("EXPORT_DIR")
+		if exportDir == "" {
+			exportDir = "exports"
+		}
+		// Downloads are typed by the extension of their file
+		mime.AddExtensionType(".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if err := os.MkdirAll(exportDir, 0o755); err != nil {
+			log.Printf("Export directory %s: %v", exportDir, err)
+		}
+		retention = time.Duration(utils.EnvInt("EXPORT_RETENTION_HOURS", 24)) * time.Hour
+		queue = make(chan task, utils.EnvInt("EXPORT_QUEUE_SIZE", 100))
+
+		// The jobs of a previous run are lost with its queue
+		database.DB.Model(&models.ExportJob{}).
+			Where("status IN ?", []string{models.ExportQueued, models.ExportRunning}).
+			Updates(map[string]interface{}{
+				"status": models.ExportFailed,
+				"error":  "Interrompu par un redémarrage du serveur",
+			})
+
+		for i := 0; i < utils.EnvInt("EXPORT_WORKERS", 2); i++ {
+			go func() {
+				for t := range queue {
+					run(t)
+				}
+			}()
+		}
+		go func() {
+			for {
+				removeExpired()
+				time.Sleep(time.Hour)
+			}
+		}()
+	})
+}
+
+// enqueue adds the job to the queue, or reports false when it is full.
+func enqueue(t task) bool {
+	select {
+	case queue <- t:
+		return true
+	default:
+		return false
+	}
+}
+
+// run builds the report of a job and stores it in the export directory.
+func run(t task) {
+	db := database.DB
+
+	var job models.ExportJob
+	if err := db.Where("uuid = ?", t.jobUUID).First(&job).Error; err != nil {
+		log.Printf("Export job %s: %v", t.jobUUID, err)
+		return
+	}
+
+	started := time.Now()
+	db.Model(&job).Updates(map[string]interface{}{"status": models.ExportRunning, "started_at": started})
+
+	fileName, path, err := build(&job, t.user)
+	finished := time.Now()
+	if err != nil {
+		log.Printf("Export job %s (%s) failed: %v", job.UUID, job.Kind, err)
+		db.Model(&job).Updates(map[string]interface{}{
+			"status":      models.ExportFailed,
+			"error":       err.Error(),
+			"finished_at": finished,
+		})
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	db.Model(&job).Updates(map[string]interface{}{
+		"status":      models.ExportDone,
+		"progress":    100,
+		"file_name":   fileName,
+		"file_path":   path,
+		"file_size":   size,
+		"finished_at": finished,
+		"expires_at":  finished.Add(retention),
+	})
+}
+
+// replay runs fn with a context whose query string and user are those of
+// the report request. A panic of fn is returned as an error.
+func replay(query string, user *models.User, fn func(c *fiber.Ctx) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("report panicked: %v", r)
+		}
+	}()
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.SetRequestURI("/?" + query)
+	c := replayApp.AcquireCtx(fctx)
+	defer replayApp.ReleaseCtx(c)
+	middlewares.SetCurrentUser(c, user)
+	return fn(c)
+}
+
+// build runs the report builder of the job as its user and saves the
+// workbook or document. A panic of the builder fails the job.
+func build(job *models.ExportJob, user *models.User) (fileName, path string, err error) {
+	if !knownKind(job.Kind) {
+		return "", "", fmt.Errorf("unknown report %q", job.Kind)
+	}
+
+	// Save the progress at most once a second
+	var saved time.Time
+	progress := func(done, total int) {
+		if time.Since(saved) < time.Second && done < total {
+			return
+		}
+		saved = time.Now()
+		pct := 0
+		if total > 0 {
+			pct = done * 100 / total
+		}
+		if pct > 99 {
+			pct = 99
+		}
+		database.DB.Model(job).Updates(map[string]interface{}{
+			"progress":   pct,
+			"rows_done":  done,
+			"rows_total": total,
+		})
+	}
+	opts := utils.ReportOptions{Progress: progress, Stream: job.Stream}
+
+	err = replay(job.Query, user, func(c *fiber.Ctx) error {
+		if document, ok := Documents[job.Kind]; ok {
+			content, name, err := document(c, opts)
+			if err != nil {
+				return err
+			}
+			fileName, path = name, filepath.Join(exportDir, job.UUID+filepath.Ext(name))
+			return os.WriteFile(path, content, 0o644)
+		}
+
+		f, name, err := Reports[job.Kind](c, opts)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fileName, path = name, filepath.Join(exportDir, job.UUID+".xlsx")
+		return f.SaveAs(path)
+	})
+	if err != nil {
+		if path != "" {
+			os.Remove(path)
+		}
+		return "", "", err
+	}
+	return fileName, path, nil
+}
+
+// Render builds the report of kind in memory, from query as user, and
+// returns its content and file name. The query is pinned to the scope of
+// the user first, as ScopeQueryParams does for the requests.
+func Render(kind, query string, user *models.User) (content []byte, fileName string, err error) {
+	if !knownKind(kind) {
+		return nil, "", fmt.Errorf("unknown report %q", kind)
+	}
+	scope := utils.ResolveScope(user)
+	if scope.Denied {
+		return nil, "", fmt.Errorf("the role %q of the user cannot read reports", user.Role)
+	}
+
+	err = replay(query, user, func(c *fiber.Ctx) error {
+		utils.ApplyScopeToQueryArgs(c, scope)
+		if document, ok := Documents[kind]; ok {
+			content, fileName, err = document(c, utils.ReportOptions{})
+			return err
+		}
+
+		f, name, err := Reports[kind](c, utils.ReportOptions{})
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			return err
+		}
+		content, fileName = buf.Bytes(), name
+		return nil
+	})
+	return content, fileName, err
+}
+
+// KnownKinds lists the kinds of Reports and Documents.
+func KnownKinds() []string {
+	kinds := make([]string, 0, len(Reports)+len(Documents))
+	for k := range Reports {
+		kinds = append(kinds, k)
+	}
+	for k := range Documents {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// removeExpired deletes the files of the jobs past their retention period.
+func removeExpired() {
+	db := database.DB
+
+	var jobs []models.ExportJob
+	err := db.Where("status = ? AND expires_at < ?", models.ExportDone, time.Now()).
+		Find(&jobs).Error
+	if err != nil {
+		log.Printf("Export cleanup: %v", err)
+		return
+	}
+	for i := range jobs {
+		if err := os.Remove(jobs[i].FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Export cleanup %s: %v", jobs[i].FilePath, err)
+			continue
+		}
+		db.Model(&jobs[i]).Updates(map[string]interface{}{"status": models.ExportExpired, "file_path": ""})
+	}
+}
@@ -0,0 +1,163 @@
+package exportjob
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeReports replaces the reports by builders echoing the region and
+// caller of the request, and the export directory by a temporary one.
+func fakeReports(t *testing.T) {
+	t.Helper()
+	reports, documents, dir, db := Reports, Documents, exportDir, database.DB
+	t.Cleanup(func() { Reports, Documents, exportDir, database.DB = reports, documents, dir, db })
+
+	// The progress of the jobs is saved in a dry run
+	dryRun, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB = dryRun
+	exportDir = t.TempDir()
+
+	Reports = map[string]utils.ReportBuilder{
+		"sheet": func(c *fiber.Ctx, opts utils.ReportOptions) (*excelize.File, string, error) {
+			opts.Progress(1, 2)
+			f := excelize.NewFile()
+			f.SetCellValue("Sheet1", "A1", c.Query("province_uuid"))
+			return f, "sheet.xlsx", nil
+		},
+		"broken": func(c *fiber.Ctx, opts utils.ReportOptions) (*excelize.File, string, error) {
+			return nil, "", errors.New("no data")
+		},
+	}
+	Documents = map[string]utils.DocumentBuilder{
+		"note": func(c *fiber.Ctx, opts utils.ReportOptions) ([]byte, string, error) {
+			return []byte(c.Query("province_uuid") + " for " + middlewares.CurrentUser(c).UUID), "note.txt", nil
+		},
+		"panic": func(c *fiber.Ctx, opts utils.ReportOptions) ([]byte, string, error) {
+			var user *models.User
+			return []byte(user.UUID), "panic.txt", nil
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	fakeReports(t)
+	user := &models.User{UUID: "u1"}
+
+	fileName, path, err := build(&models.ExportJob{UUID: "j1", Kind: "note", Query: "province_uuid=kin"}, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	if fileName != "note.txt" || path != filepath.Join(exportDir, "j1.txt") || string(content) != "kin for u1" {
+		t.Errorf("document %s at %s: %q", fileName, path, content)
+	}
+
+	fileName, path, err = build(&models.ExportJob{UUID: "j2", Kind: "sheet", Query: "province_uuid=kin"}, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if cell, _ := f.GetCellValue("Sheet1", "A1"); fileName != "sheet.xlsx" || cell != "kin" {
+		t.Errorf("workbook %s has A1 = %q, want kin", fileName, cell)
+	}
+
+	for _, kind := range []string{"broken", "panic", "unknown"} {
+		if _, _, err := build(&models.ExportJob{UUID: "j-" + kind, Kind: kind}, user); err == nil {
+			t.Errorf("%s: no error", kind)
+		}
+	}
+	files, _ := os.ReadDir(exportDir)
+	if len(files) != 2 {
+		t.Errorf("%d files in the export directory, want the 2 built", len(files))
+	}
+}
+
+func TestRenderPinsTheScope(t *testing.T) {
+	fakeReports(t)
+
+	asm := &models.User{UUID: "a1", Role: "ASM", CountryUUID: "cd", ProvinceUUID: "kin"}
+	content, fileName, err := Render("note", "province_uuid=kongo", asm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileName != "note.txt" || string(content) != "kin for a1" {
+		t.Errorf("%s: %q, want the report of the ASM's province", fileName, content)
+	}
+
+	if _, _, err := Render("note", "", &models.User{UUID: "x1", Role: "visitor"}); err == nil {
+		t.Error("no error for a role without a scope")
+	}
+	if _, _, err := Render("unknown", "", asm); err == nil {
+		t.Error("no error for an unknown report")
+	}
+}
+
+func TestEnqueueFullQueue(t *testing.T) {
+	saved := queue
+	t.Cleanup(func() { queue = saved })
+	queue = make(chan task, 1)
+
+	if !enqueue(task{jobUUID: "j1"}) {
+		t.Fatal("first job refused")
+	}
+	if enqueue(task{jobUUID: "j2"}) {
+		t.Error("job queued beyond the size of the queue")
+	}
+}
+
+func TestCreateExportJobRefused(t *testing.T) {
+	app := fiber.New()
+	app.Post("/exports/:kind", func(c *fiber.Ctx) error {
+		if uuid := c.Get("X-User"); uuid != "" {
+			middlewares.SetCurrentUser(c, &models.User{UUID: uuid})
+		}
+		return CreateExportJob(c)
+	})
+
+	for _, tt := range []struct {
+		kind, user string
+		status     int
+	}{
+		{"nothing", "u1", fiber.StatusBadRequest},
+		{"pos", "", fiber.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("POST", "/exports/"+tt.kind, nil)
+		req.Header.Set("X-User", tt.user)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s by %q: status %d, want %d", tt.kind, tt.user, resp.StatusCode, tt.status)
+		}
+	}
+	if !strings.Contains(strings.Join(KnownKinds(), ","), "executive") {
+		t.Errorf("KnownKinds = %v, want the documents too", KnownKinds())
+	}
+}
@@ -0,0 +1,243 @@
+package manufacturer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// manufacturerInput is a manufacturer as sent by the client.
+type manufacturerInput struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+}
+
+// invalidateDashboards drops the cached dashboard responses, which show
+// the catalogue.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedManufacturers(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	search := c.Query("search", "")
+
+	var totalRecords int64
+	db.Model(&models.Manufacturer{}).
+		Where("name ILIKE ?", "%"+search+"%").
+		Count(&totalRecords)
+
+	var dataList []models.Manufacturer
+	err = db.
+		Where("name ILIKE ?", "%"+search+"%").
+		Offset(offset).
+		Limit(limit).
+		Order("name").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch manufacturers",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "manufacturers retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data
+func GetAllManufacturers(c *fiber.Ctx) error {
+	db := database.DB
+
+	var data []models.Manufacturer
+	db.Order("name").Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All manufacturers",
+		"data":    data,
+	})
+}
+
+// Get one data, with its brands
+func GetOneManufacturer(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var manufacturer models.Manufacturer
+	db.Preload("Brands").Where("uuid = ?", uuid).First(&manufacturer)
+	if manufacturer.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No manufacturer found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "manufacturer found",
+			"data":    manufacturer,
+		},
+	)
+}
+
+// Create data
+func CreateManufacturer(c *fiber.Ctx) error {
+	var input manufacturerInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "name is required",
+		})
+	}
+
+	p := &models.Manufacturer{
+		UUID:      uuid.New().String(),
+		Name:      strings.TrimSpace(input.Name),
+		Signature: input.Signature,
+	}
+	if err := database.DB.Create(p).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create manufacturer",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "manufacturer created success",
+			"data":    p,
+		},
+	)
+}
+
+// Update data
+func UpdateManufacturer(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input manufacturerInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if strings.TrimSpace(input.Name) == "" {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "name is required",
+		})
+	}
+
+	var manufacturer models.Manufacturer
+	db.Where("uuid = ?", uuid).First(&manufacturer)
+	if manufacturer.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No manufacturer found",
+			"data":    nil,
+		})
+	}
+
+	manufacturer.Name = strings.TrimSpace(input.Name)
+	manufacturer.Signature = input.Signature
+	if err := db.Save(&manufacturer).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update manufacturer",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "manufacturer updated success",
+			"data":    manufacturer,
+		},
+	)
+}
+
+// Delete data, refused while brands are made by the manufacturer
+func DeleteManufacturer(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var manufacturer models.Manufacturer
+	db.Where("uuid = ?", uuid).First(&manufacturer)
+	if manufacturer.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No manufacturer found",
+				"data":    nil,
+			},
+		)
+	}
+
+	var brands int64
+	db.Model(&models.Brand{}).Where("manufacturer_uuid = ?", uuid).Count(&brands)
+	if brands > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Move the brands of the manufacturer before deleting it",
+		})
+	}
+
+	db.Delete(&manufacturer)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "manufacturer deleted success",
+			"data":    nil,
+		},
+	)
+}
@@ -0,0 +1,263 @@
+package mobilesync
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// —————————————————————————————————————————————————————————————
+// PULL — delta download since the last sync
+//   GET /api/sync/pull?since=<cursor>
+//
+// For every model the agent is scoped to, returns the rows changed since the
+// cursor (upserts) and the uuids soft-deleted since the cursor
+// (tombstones, from the gorm.DeletedAt columns, plus the POS moved out of
+// the territory), plus a new cursor to send on the next call. An empty
+// cursor downloads everything.
+// —————————————————————————————————————————————————————————————
+
+// pullLag is how far behind the clock cursors stay: longer than a push
+// transaction, whose rows are stamped before they are committed.
+const pullLag = 2 * time.Minute
+
+// Territory levels from the top of the tree down
+var territoryLevels = []string{"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid"}
+
+// Tombstone is a record deleted on the server since the cursor.
+type Tombstone struct {
+	UUID      string    `json:"uuid"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// changeSet holds the changes of one model.
+type changeSet struct {
+	Upserts    interface{} `json:"upserts"`
+	Tombstones []Tombstone `json:"tombstones"`
+}
+
+// Pull returns the changes visible to the caller since the given cursor.
+func Pull(c *fiber.Ctx) error {
+	since, err := decodeCursor(c.Query("since"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid sync cursor",
+			"error":   err.Error(),
+		})
+	}
+
+	user := middlewares.CurrentUser(c)
+	scope := middlewares.Scope(c)
+	if scope.Denied {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Rôle non reconnu — accès refusé",
+		})
+	}
+
+	next := pullUntil(time.Now(), since)
+
+	data, err := pullChanges(database.DB, scope, user, since, next)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to compute sync changes",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Sync pull processed",
+		"cursor":  encodeCursor(next),
+		"data":    data,
+	})
+}
+
+// pullUntil is the end of the changes pulled at now from since, and the
+// next cursor. Rows stamped within the lag may belong to transactions not
+// committed yet: they are left to the next pull.
+func pullUntil(now, since time.Time) time.Time {
+	until := now.Add(-pullLag)
+	if until.Before(since) {
+		return since
+	}
+	return until
+}
+
+// pullChanges collects the changes visible in scope from since, excluded,
+// to until, included.
+func pullChanges(db *gorm.DB, scope utils.Scope, user *models.User, since, until time.Time) (fiber.Map, error) {
+	data := fiber.Map{}
+
+	// Territory tree (Country → Commune)
+	territories := []struct {
+		key   string
+		level int
+		model interface{}
+		rows  interface{}
+	}{
+		{"countries", 0, &models.Country{}, &[]models.Country{}},
+		{"provinces", 1, &models.Province{}, &[]models.Province{}},
+		{"areas", 2, &models.Area{}, &[]models.Area{}},
+		{"sub_areas", 3, &models.SubArea{}, &[]models.SubArea{}},
+		{"communes", 4, &models.Commune{}, &[]models.Commune{}},
+	}
+	for _, t := range territories {
+		query := territoryScope(db.Model(t.model), scope, user, t.key, t.level)
+		cs, err := changes(query, t.rows, since, until)
+		if err != nil {
+			return nil, err
+		}
+		data[t.key] = cs
+	}
+
+	// Assigned POS, and those moved out of the territory
+	posQuery := utils.ApplyScope(db.Model(&models.Pos{}), scope, "pos")
+	pos, err := changes(posQuery, &[]models.Pos{}, since, until)
+	if err != nil {
+		return nil, err
+	}
+	moved, err := movedOut(db, scope, since, until)
+	if err != nil {
+		return nil, err
+	}
+	pos.Tombstones = append(pos.Tombstones, moved...)
+	data["pos"] = pos
+
+	// Published route plans and their items
+	rpQuery := utils.ApplyScope(db.Model(&models.RoutePlan{}), scope, "route_plans").
+		Where("route_plans.status = ?", models.RoutePlanPublished)
+	routePlans, err := changes(rpQuery, &[]models.RoutePlan{}, since, until)
+	if err != nil {
+		return nil, err
+	}
+	data["route_plans"] = routePlans
+
+	planUUIDs := utils.ApplyScope(db.Unscoped().Model(&models.RoutePlan{}), scope, "route_plans").
+		Where("route_plans.status = ?", models.RoutePlanPublished).
+		Select("route_plans.uuid")
+	rpiQuery := db.Model(&models.RoutePlanItem{}).Where("route_plan_items.route_plan_uuid IN (?)", planUUIDs)
+	routePlanItems, err := changes(rpiQuery, &[]models.RoutePlanItem{}, since, until)
+	if err != nil {
+		return nil, err
+	}
+	data["route_plan_items"] = routePlanItems
+
+	// Brands of the agent's province (country or everything above ASM)
+	brandQuery := db.Model(&models.Brand{})
+	switch {
+	case scope.Unrestricted():
+	case user.ProvinceUUID != "" && scope.Column != "country_uuid":
+		brandQuery = brandQuery.Where("brands.province_uuid = ?", user.ProvinceUUID)
+	default:
+		brandQuery = brandQuery.Where("brands.country_uuid = ?", user.CountryUUID)
+	}
+	brands, err := changes(brandQuery, &[]models.Brand{}, since, until)
+	if err != nil {
+		return nil, err
+	}
+	data["brands"] = brands
+
+	return data, nil
+}
+
+// territoryScope filters a territory table (countries, provinces, …) at
+// the given level: ancestors of the user's scope are limited to the user's
+// own branch, descendants to the scope.
+func territoryScope(query *gorm.DB, scope utils.Scope, user *models.User, table string, level int) *gorm.DB {
+	if scope.Unrestricted() {
+		return query
+	}
+
+	scopeLevel := 0
+	for i, col := range territoryLevels {
+		if col == scope.Column {
+			scopeLevel = i
+		}
+	}
+
+	if level <= scopeLevel {
+		own := []string{user.CountryUUID, user.ProvinceUUID, user.AreaUUID, user.SubAreaUUID, user.CommuneUUID}[level]
+		return query.Where(table+".uuid = ?", own)
+	}
+	return query.Where(table+"."+scope.Column+" = ?", scope.UUID)
+}
+
+// changes loads the rows of query changed from since, excluded, to until,
+// included, into rows (a pointer to a slice of models) and collects the
+// tombstones.
+func changes(query *gorm.DB, rows interface{}, since, until time.Time) (changeSet, error) {
+	cs := changeSet{Upserts: rows, Tombstones: []Tombstone{}}
+
+	base := query.Session(&gorm.Session{})
+	table := base.Statement.Table
+	if table == "" {
+		if err := base.Statement.Parse(base.Statement.Model); err != nil {
+			return cs, err
+		}
+		table = base.Statement.Schema.Table
+	}
+
+	if err := base.Where(table+".updated_at > ? AND "+table+".updated_at <= ?", since, until).Find(rows).Error; err != nil {
+		return cs, err
+	}
+
+	if !since.IsZero() {
+		err := base.Unscoped().
+			Select(table+".uuid", table+".deleted_at AS deleted_at").
+			Where(table+".deleted_at > ? AND "+table+".deleted_at <= ?", since, until).
+			Find(&cs.Tombstones).Error
+		if err != nil {
+			return cs, err
+		}
+	}
+
+	return cs, nil
+}
+
+// movedOut returns as tombstones the POS moved out of the scope from since,
+// excluded, to until, included, and not back in since.
+func movedOut(db *gorm.DB, scope utils.Scope, since, until time.Time) ([]Tombstone, error) {
+	moved := []Tombstone{}
+	if since.IsZero() || scope.Unrestricted() || scope.Denied {
+		return moved, nil
+	}
+	err := db.Model(&models.PosMove{}).
+		Select("pos_moves.pos_uuid AS uuid", "MAX(pos_moves.created_at) AS deleted_at").
+		Joins("INNER JOIN pos ON pos.uuid = pos_moves.pos_uuid").
+		Where("pos_moves."+scope.Column+" = ? AND pos."+scope.Column+" <> ?", scope.UUID, scope.UUID).
+		Where("pos_moves.created_at > ? AND pos_moves.created_at <= ?", since, until).
+		Group("pos_moves.pos_uuid").
+		Find(&moved).Error
+	return moved, err
+}
+
+// encodeCursor makes the opaque cursor returned to clients.
+func encodeCursor(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.UTC().Format(time.RFC3339Nano)))
+}
+
+// decodeCursor parses a cursor from encodeCursor; "" means the beginning.
+func decodeCursor(cursor string) (time.Time, error) {
+	if cursor == "" {
+		return time.Time{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(raw))
+	if err != nil {
+		return time.Time{}, errors.New("malformed cursor")
+	}
+	return t, nil
+}
@@ -0,0 +1,186 @@
+package mobilesync
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	kinshasa := time.FixedZone("WAT", 3600)
+
+	tests := []struct {
+		name string
+		at   time.Time
+	}{
+		{"utc", time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC)},
+		{"nanoseconds", time.Date(2026, 3, 14, 9, 26, 53, 589793238, time.UTC)},
+		{"other zone", time.Date(2026, 12, 31, 23, 59, 59, 999, kinshasa)},
+		{"zero time", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := encodeCursor(tt.at)
+			got, err := decodeCursor(cursor)
+			if err != nil {
+				t.Fatalf("decodeCursor(%q): %v", cursor, err)
+			}
+			if !got.Equal(tt.at) {
+				t.Errorf("decodeCursor(encodeCursor(%v)) = %v", tt.at, got)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		cursor  string
+		want    time.Time
+		wantErr bool
+	}{
+		{"empty is the beginning", "", time.Time{}, false},
+		{"valid", base64.RawURLEncoding.EncodeToString([]byte("2026-01-02T03:04:05Z")), time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), false},
+		{"not base64", "%%%", time.Time{}, true},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2026-01-02T03:04:05Z")), time.Time{}, true},
+		{"not a time", base64.RawURLEncoding.EncodeToString([]byte("yesterday")), time.Time{}, true},
+		{"date only", base64.RawURLEncoding.EncodeToString([]byte("2026-01-02")), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.cursor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeCursor(%q) error = %v, want error %v", tt.cursor, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("decodeCursor(%q) = %v, want %v", tt.cursor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPullUntil(t *testing.T) {
+	now := time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		since time.Time
+		want  time.Time
+	}{
+		{"first pull", time.Time{}, now.Add(-pullLag)},
+		{"cursor behind the lag", now.Add(-time.Hour), now.Add(-pullLag)},
+		{"cursor within the lag", now.Add(-pullLag / 2), now.Add(-pullLag / 2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pullUntil(now, tt.since); !got.Equal(tt.want) {
+				t.Errorf("pullUntil = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPullChanges(t *testing.T) {
+	since := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	until := time.Date(2026, 10, 17, 8, 58, 0, 0, time.UTC)
+	cyclo := &models.User{
+		UUID: "u1", Role: "Cyclo",
+		CountryUUID: "cd", ProvinceUUID: "kin", AreaUUID: "lukunga", SubAreaUUID: "gombe", CommuneUUID: "c1",
+	}
+	support := &models.User{UUID: "u2", Role: "Support"}
+
+	tests := []struct {
+		name  string
+		user  *models.User
+		since time.Time
+		// Statements of each table, SQL fragments all of them contain
+		want map[string][]string
+	}{
+		{
+			name:  "first pull of an agent",
+			user:  cyclo,
+			since: time.Time{},
+			want: map[string][]string{
+				`FROM "communes"`:         {`communes.uuid = 'c1'`},
+				`FROM "pos"`:              {`pos.commune_uuid = 'c1'`, `pos.updated_at <= '2026-10-17 08:58:00'`},
+				`FROM "route_plans"`:      {`route_plans.commune_uuid = 'c1'`, `route_plans.status = 'published'`},
+				`FROM "route_plan_items"`: {`route_plans.commune_uuid = 'c1'`},
+				`FROM "brands"`:           {`brands.province_uuid = 'kin'`},
+			},
+		},
+		{
+			name:  "delta pull of an agent",
+			user:  cyclo,
+			since: since,
+			want: map[string][]string{
+				`FROM "communes"`:         {`communes.uuid = 'c1'`, `'2026-10-16 08:00:00'`},
+				`FROM "pos"`:              {`pos.commune_uuid = 'c1'`, `'2026-10-16 08:00:00'`, `'2026-10-17 08:58:00'`},
+				`FROM "pos_moves"`:        {`pos_moves.commune_uuid = 'c1' AND pos.commune_uuid <> 'c1'`, `pos_moves.created_at > '2026-10-16 08:00:00'`},
+				`FROM "route_plans"`:      {`route_plans.commune_uuid = 'c1'`},
+				`FROM "route_plan_items"`: {`route_plans.commune_uuid = 'c1'`},
+				`FROM "brands"`:           {`brands.province_uuid = 'kin'`},
+			},
+		},
+		{
+			name:  "delta pull of the support",
+			user:  support,
+			since: since,
+			want: map[string][]string{
+				`FROM "pos"`:    {`'2026-10-16 08:00:00'`},
+				`FROM "brands"`: {`'2026-10-16 08:00:00'`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, rec := dryRun(t)
+			scope := utils.ResolveScope(tt.user)
+			if _, err := pullChanges(db, scope, tt.user, tt.since, until); err != nil {
+				t.Fatal(err)
+			}
+
+			// 5 territory levels, POS, route plans and their items, brands,
+			// each with its tombstones but on the first pull; and the moves
+			perModel := 1
+			moves := 0
+			if !tt.since.IsZero() {
+				perModel = 2
+				if !scope.Unrestricted() {
+					moves = 1
+				}
+			}
+			if got, want := len(rec.statements), 9*perModel+moves; got != want {
+				t.Fatalf("%d statements, want %d:\n%s", got, want, strings.Join(rec.statements, "\n"))
+			}
+
+			for table, fragments := range tt.want {
+				found := 0
+				for _, sql := range rec.statements {
+					if !strings.Contains(sql, table+" ") && !strings.HasSuffix(sql, table) {
+						continue
+					}
+					found++
+					for _, f := range fragments {
+						if !strings.Contains(sql, f) {
+							t.Errorf("%s without %s: %s", table, f, sql)
+						}
+					}
+				}
+				if found == 0 {
+					t.Errorf("no statement %s", table)
+				}
+			}
+			if scope.Unrestricted() {
+				for _, sql := range rec.statements {
+					if strings.Contains(sql, "_uuid = ") && !strings.Contains(sql, "route_plan_uuid") {
+						t.Errorf("territory filter for the support: %s", sql)
+					}
+				}
+			}
+		})
+	}
+}
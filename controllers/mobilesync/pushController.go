@@ -0,0 +1,424 @@
+package mobilesync
+
+import (
+	"errors"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// —————————————————————————————————————————————————————————————
+// PUSH — offline-first batch upload
+//   POST /api/sync/push
+//
+// Records are upserted in one transaction, in dependency order
+// (pos → pos_forms → pos_form_items → pos_equipments). Each record runs in
+// its own savepoint so a rejected record does not roll back the others.
+// Deduplication is on the client UUID; the client updated_at is compared
+// with the server time of the last write to decide between an update and
+// a skip, so a retried upload is a no-op. Written records are stamped with
+// the server time, which the pull cursor is compared with.
+// —————————————————————————————————————————————————————————————
+
+// posServerColumns are set by the server only: the location refined from
+// the visits or set by a supervisor, and the segment. A POS pushed from an
+// offline copy keeps the server values.
+var posServerColumns = []string{
+	"latitude", "longitude", "location_accuracy", "location_source", "location_samples", "location_set_at",
+	"segment", "segment_score", "segmented_at",
+}
+
+// Push upserts a batch of offline records and reports a status per record.
+func Push(c *fiber.Ctx) error {
+	var req models.SyncPushRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	user := middlewares.CurrentUser(c)
+	scope := middlewares.Scope(c)
+
+	results := make([]models.SyncRecordResult, 0,
+		len(req.Pos)+len(req.PosForms)+len(req.PosFormItems)+len(req.PosEquipments))
+
+	// Visits whose dashboard facts change with this batch
+	factFormUUIDs := make([]string, 0, len(req.PosForms)+len(req.PosFormItems))
+	for _, pf := range req.PosForms {
+		factFormUUIDs = append(factFormUUIDs, pf.UUID)
+	}
+	for _, it := range req.PosFormItems {
+		factFormUUIDs = append(factFormUUIDs, it.PosFormUUID)
+	}
+
+	// Visits before and after the batch, for the cached responses
+	var factForms []*models.PosForm
+	// POS of another type, whose visits all have their facts recomputed
+	var retyped []string
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Agent and day of the visits before the batch, in case they move
+		staleForms, err := utils.DailyFactForms(tx, factFormUUIDs)
+		if err != nil {
+			return err
+		}
+
+		for i := range req.Pos {
+			p := &req.Pos[i]
+			results = append(results, pushRecord(tx, "pos", p.UUID, p.UpdatedAt, p, pushHooks{
+				owned: func() error {
+					if !inScope(tx, scope, &models.Pos{}, p.UUID) {
+						return errors.New("pos is outside of your territory")
+					}
+					return nil
+				},
+				validate: func() error {
+					if p.Name == "" {
+						return errors.New("name is required")
+					}
+					if p.CommuneUUID == "" || p.ProvinceUUID == "" {
+						return errors.New("territory (province_uuid, commune_uuid) is required")
+					}
+					if !scope.Contains(p.CountryUUID, p.ProvinceUUID, p.AreaUUID, p.SubAreaUUID, p.CommuneUUID) {
+						return errors.New("pos is outside of your territory")
+					}
+					if p.UserUUID == "" {
+						p.UserUUID = user.UUID
+					}
+					p.Sync = true
+					return nil
+				},
+				update: func(sp *gorm.DB) error {
+					var stored models.Pos
+					if err := sp.Where("uuid = ?", p.UUID).First(&stored).Error; err != nil {
+						return err
+					}
+					if stored.Postype != p.Postype {
+						retyped = append(retyped, p.UUID)
+					}
+					return utils.RecordPosMove(sp, &stored, p)
+				},
+				serverOwned: posServerColumns,
+			}))
+		}
+
+		for i := range req.PosForms {
+			pf := &req.PosForms[i]
+			results = append(results, pushRecord(tx, "pos_form", pf.UUID, pf.UpdatedAt, pf, pushHooks{
+				owned: func() error {
+					if !inScope(tx, scope, &models.PosForm{}, pf.UUID) {
+						return errors.New("visit is outside of your territory")
+					}
+					if middlewares.IsFieldAgent(user) && parentOf(tx, &models.PosForm{}, "user_uuid", pf.UUID) != user.UUID {
+						return errors.New("visit of another agent")
+					}
+					return nil
+				},
+				validate: func() error {
+					var pos models.Pos
+					if pf.PosUUID == "" || tx.Where("uuid = ?", pf.PosUUID).Limit(1).Find(&pos).RowsAffected == 0 {
+						return errors.New("unknown pos_uuid")
+					}
+					if !scope.Contains(pos.CountryUUID, pos.ProvinceUUID, pos.AreaUUID, pos.SubAreaUUID, pos.CommuneUUID) {
+						return errors.New("pos is outside of your territory")
+					}
+					if err := utils.ApplyVisitTiming(pf); err != nil {
+						return err
+					}
+					if err := utils.NormalizeFormCurrency(pf); err != nil {
+						return err
+					}
+
+					// Field agents visit under their own name; others may
+					// file the visit of an agent of their territory
+					if middlewares.IsFieldAgent(user) || pf.UserUUID == "" {
+						pf.UserUUID = user.UUID
+					} else if pf.UserUUID != user.UUID && !inScope(tx, scope, &models.User{}, pf.UserUUID) {
+						return errors.New("agent is outside of your territory")
+					}
+
+					// Territory and hierarchy always follow the POS
+					utils.PlaceVisit(pf, &pos)
+					pf.Sync = true
+					return nil
+				},
+				create: func(sp *gorm.DB) error {
+					if err := utils.ApplyGeofence(sp, pf); err != nil {
+						return err
+					}
+					return utils.MatchRoutePlan(sp, pf)
+				},
+				update: func(sp *gorm.DB) error {
+					var stored models.PosForm
+					if err := sp.Where("uuid = ?", pf.UUID).First(&stored).Error; err != nil {
+						return err
+					}
+					pf.CreatedAt = stored.CreatedAt
+					if err := utils.UpdateGeofence(sp, &stored, pf); err != nil {
+						return err
+					}
+					return utils.RematchRoutePlan(sp, &stored, pf)
+				},
+			}))
+		}
+
+		for i := range req.PosFormItems {
+			it := &req.PosFormItems[i]
+			results = append(results, pushRecord(tx, "pos_form_item", it.UUID, it.UpdatedAt, it, pushHooks{
+				owned: func() error {
+					if !inScope(tx, scope, &models.PosForm{}, parentOf(tx, &models.PosFormItems{}, "pos_form_uuid", it.UUID)) {
+						return errors.New("visit line is outside of your territory")
+					}
+					return nil
+				},
+				validate: func() error {
+					if !exists(tx, &models.PosForm{}, it.PosFormUUID) {
+						return errors.New("unknown posform_uuid")
+					}
+					if !inScope(tx, scope, &models.PosForm{}, it.PosFormUUID) {
+						return errors.New("visit is outside of your territory")
+					}
+					if !exists(tx, &models.Brand{}, it.BrandUUID) {
+						return errors.New("unknown brand_uuid")
+					}
+					if it.NumberFarde < 0 || it.Sold < 0 {
+						return errors.New("number_farde and sold must be positive")
+					}
+					if _, err := utils.NormalizeItemPrice(it, utils.VisitCurrencyOf(tx, it.PosFormUUID)); err != nil {
+						return err
+					}
+					return utils.CheckItemSku(tx, it)
+				},
+			}))
+		}
+
+		for i := range req.PosEquipments {
+			pe := &req.PosEquipments[i]
+			results = append(results, pushRecord(tx, "pos_equipment", pe.UUID, pe.UpdatedAt, pe, pushHooks{
+				owned: func() error {
+					if !inScope(tx, scope, &models.Pos{}, parentOf(tx, &models.PosEquipment{}, "pos_uuid", pe.UUID)) {
+						return errors.New("equipment is outside of your territory")
+					}
+					return nil
+				},
+				validate: func() error {
+					if !exists(tx, &models.Pos{}, pe.PosUUID) {
+						return errors.New("unknown pos_uuid")
+					}
+					if !inScope(tx, scope, &models.Pos{}, pe.PosUUID) {
+						return errors.New("pos is outside of your territory")
+					}
+					return nil
+				},
+			}))
+		}
+
+		forms, err := utils.DailyFactForms(tx, factFormUUIDs)
+		if err != nil {
+			return err
+		}
+		posForms, err := utils.PosDailyFactForms(tx, retyped)
+		if err != nil {
+			return err
+		}
+		factForms = append(append(staleForms, forms...), posForms...)
+		return utils.RefreshDailyFacts(tx, factForms...)
+	})
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to sync records",
+			"error":   err.Error(),
+		})
+	}
+
+	utils.InvalidateCachedResponses(factForms...)
+
+	// Observations of the new visits
+	created := map[string]bool{}
+	for _, r := range results {
+		if r.Entity == "pos_form" && r.Status == models.SyncCreated {
+			created[r.UUID] = true
+		}
+	}
+	for i := range req.PosForms {
+		if created[req.PosForms[i].UUID] {
+			utils.NotifyObservations(database.DB, &req.PosForms[i])
+		}
+	}
+
+	summary := map[string]int{
+		models.SyncCreated:  0,
+		models.SyncUpdated:  0,
+		models.SyncSkipped:  0,
+		models.SyncRejected: 0,
+	}
+	for _, r := range results {
+		summary[r.Status]++
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Sync push processed",
+		"data": fiber.Map{
+			"results":   results,
+			"summary":   summary,
+			"synced_at": time.Now(),
+		},
+	})
+}
+
+// pushHooks are the entity-specific steps of pushRecord.
+type pushHooks struct {
+	// owned checks that the server copy, deleted or not, may be changed by
+	// the caller.
+	owned func() error
+	// validate checks and normalizes the incoming record.
+	validate func() error
+	// create runs the side effects of a new record inside its savepoint,
+	// before it is inserted.
+	create func(sp *gorm.DB) error
+	// update runs the side effects of an update inside its savepoint,
+	// before it is saved.
+	update func(sp *gorm.DB) error
+	// serverOwned are the columns the client cannot write: left to their
+	// default on creation, kept on update.
+	serverOwned []string
+}
+
+// pushRecord validates and upserts one record. The server copy is checked
+// first: out of the caller's territory the record is rejected, deleted or
+// at least as recent it is skipped, before anything is written. Writes run
+// inside a savepoint. record must be a pointer to a model whose table has
+// uuid, created_at, updated_at and deleted_at columns.
+func pushRecord(tx *gorm.DB, entity, recordUUID string, updatedAt time.Time, record interface{}, hooks pushHooks) models.SyncRecordResult {
+	res := models.SyncRecordResult{Entity: entity, UUID: recordUUID}
+
+	if _, err := uuid.Parse(recordUUID); err != nil {
+		res.Status, res.Error = models.SyncRejected, "a client-generated uuid is required"
+		return res
+	}
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+
+	var current struct {
+		UpdatedAt time.Time
+		DeletedAt gorm.DeletedAt
+	}
+	found := tx.Model(record).Unscoped().
+		Select("updated_at", "deleted_at").
+		Where("uuid = ?", recordUUID).
+		Limit(1).
+		Scan(&current)
+	if found.Error != nil {
+		res.Status, res.Error = models.SyncRejected, found.Error.Error()
+		return res
+	}
+	if found.RowsAffected > 0 {
+		if err := hooks.owned(); err != nil {
+			res.Status, res.Error = models.SyncRejected, err.Error()
+			return res
+		}
+		// updated_at is the server time of the last write, so a retried
+		// upload is always older than the copy it created
+		if current.DeletedAt.Valid || !updatedAt.After(current.UpdatedAt) {
+			res.Status = models.SyncSkipped
+			return res
+		}
+	}
+	if err := hooks.validate(); err != nil {
+		res.Status, res.Error = models.SyncRejected, err.Error()
+		return res
+	}
+
+	err := tx.Transaction(func(sp *gorm.DB) error {
+		if found.RowsAffected == 0 {
+			res.Status = models.SyncCreated
+			if hooks.create != nil {
+				if err := hooks.create(sp); err != nil {
+					return err
+				}
+			}
+		} else {
+			res.Status = models.SyncUpdated
+			if hooks.update != nil {
+				if err := hooks.update(sp); err != nil {
+					return err
+				}
+			}
+		}
+		if err := saveRecord(sp, record, res.Status == models.SyncCreated, hooks.serverOwned); err != nil {
+			return err
+		}
+		// Server time, so that pulls from a cursor taken before this push
+		// see the record
+		return sp.Model(record).Where("uuid = ?", recordUUID).UpdateColumn("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		res.Status, res.Error = models.SyncRejected, err.Error()
+	}
+
+	return res
+}
+
+// saveRecord inserts or updates record, without its associations and the
+// serverOwned columns. An update keeps the creation time.
+func saveRecord(sp *gorm.DB, record interface{}, create bool, serverOwned []string) error {
+	omit := append([]string{clause.Associations}, serverOwned...)
+	if create {
+		return sp.Omit(omit...).Create(record).Error
+	}
+	return sp.Omit(append(omit, "created_at")...).Save(record).Error
+}
+
+// exists reports whether a non-deleted row with this uuid exists; rows
+// pushed earlier in the same batch are visible through tx.
+func exists(tx *gorm.DB, model interface{}, recordUUID string) bool {
+	if recordUUID == "" {
+		return false
+	}
+	var count int64
+	tx.Model(model).Where("uuid = ?", recordUUID).Count(&count)
+	return count > 0
+}
+
+// inScope reports whether the row of model with this uuid, deleted or not,
+// lies in the territory of scope.
+func inScope(tx *gorm.DB, scope utils.Scope, model interface{}, recordUUID string) bool {
+	var t struct {
+		CountryUUID  string
+		ProvinceUUID string
+		AreaUUID     string
+		SubAreaUUID  string
+		CommuneUUID  string
+	}
+	found := tx.Model(model).Unscoped().
+		Select("country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid").
+		Where("uuid = ?", recordUUID).
+		Limit(1).
+		Scan(&t)
+	if found.Error != nil || found.RowsAffected == 0 {
+		return false
+	}
+	return scope.Contains(t.CountryUUID, t.ProvinceUUID, t.AreaUUID, t.SubAreaUUID, t.CommuneUUID)
+}
+
+// parentOf returns the parent uuid held in column by the row of model with
+// this uuid, deleted or not.
+func parentOf(tx *gorm.DB, model interface{}, column, recordUUID string) string {
+	var parent string
+	tx.Model(model).Unscoped().Select(column).Where("uuid = ?", recordUUID).Limit(1).Scan(&parent)
+	return parent
+}
@@ -0,0 +1,207 @@
+package mobilesync
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a gorm logger keeping the SQL of every statement.
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// dryRun returns a database building the statements without running them,
+// and their recorder.
+func dryRun(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost", PreferSimpleProtocol: true}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 rec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+// emptyConnector opens connections whose queries return no row and whose
+// statements affect one, for the code paths DryRun cannot run.
+type emptyConnector struct{}
+
+func (emptyConnector) Connect(context.Context) (driver.Conn, error) { return emptyConn{}, nil }
+func (emptyConnector) Driver() driver.Driver                        { return nil }
+
+type emptyConn struct{}
+
+func (emptyConn) Prepare(query string) (driver.Stmt, error) { return emptyStmt{}, nil }
+func (emptyConn) Close() error                              { return nil }
+func (emptyConn) Begin() (driver.Tx, error)                 { return emptyConn{}, nil }
+func (emptyConn) Commit() error                             { return nil }
+func (emptyConn) Rollback() error                           { return nil }
+
+type emptyStmt struct{}
+
+func (emptyStmt) Close() error                               { return nil }
+func (emptyStmt) NumInput() int                              { return -1 }
+func (emptyStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (emptyStmt) Query([]driver.Value) (driver.Rows, error)  { return emptyRows{}, nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return nil }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+// emptyDB returns a database over emptyConnector, and the recorder of its
+// statements.
+func emptyDB(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(emptyConnector{})}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 rec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+func TestSaveRecordServerOwnedColumns(t *testing.T) {
+	now := time.Now()
+	// An offline copy with stale location and segment
+	pos := func() *models.Pos {
+		return &models.Pos{
+			UUID:             "3f1c2a9e-7b5d-4c1e-9a0b-2d6e8f4a1c3b",
+			Name:             "Chez Mama",
+			Latitude:         -4.3,
+			Longitude:        15.3,
+			LocationAccuracy: 500,
+			LocationSource:   models.PosLocationFromVisits,
+			LocationSamples:  1,
+			LocationSetAt:    &now,
+			Segment:          models.SegmentC,
+			SegmentScore:     12,
+			SegmentedAt:      &now,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		create   bool
+		verb     string
+		excluded []string
+	}{
+		{"create", true, "INSERT INTO", posServerColumns},
+		{"update", false, "UPDATE", append([]string{"created_at"}, posServerColumns...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, rec := dryRun(t)
+			if err := saveRecord(db, pos(), tt.create, posServerColumns); err != nil {
+				t.Fatal(err)
+			}
+			if len(rec.statements) != 1 {
+				t.Fatalf("statements = %q, want one", rec.statements)
+			}
+			sql := rec.statements[0]
+			if !strings.HasPrefix(sql, tt.verb+` "pos"`) {
+				t.Fatalf("statement = %s, want %s of pos", sql, tt.verb)
+			}
+			if !strings.Contains(sql, `"name"`) {
+				t.Errorf("name not written: %s", sql)
+			}
+			for _, column := range tt.excluded {
+				if strings.Contains(sql, `"`+column+`"`) {
+					t.Errorf("%s written: %s", column, sql)
+				}
+			}
+		})
+	}
+}
+
+// A record unknown to the server is validated, then created in a savepoint
+// and stamped with the server time; a rejected one writes nothing.
+func TestPushRecordCreate(t *testing.T) {
+	const recordUUID = "9b2e4c1a-5d3f-4e6b-8a7c-1f0d2e3b4a5c"
+	fail := errors.New("name is required")
+
+	tests := []struct {
+		name       string
+		uuid       string
+		validate   error
+		create     error
+		wantStatus string
+		wantError  string
+		wantWrites bool
+	}{
+		{"created", recordUUID, nil, nil, models.SyncCreated, "", true},
+		{"server uuid", "", nil, nil, models.SyncRejected, "a client-generated uuid is required", false},
+		{"invalid record", recordUUID, fail, nil, models.SyncRejected, fail.Error(), false},
+		{"failed side effect", recordUUID, nil, errors.New("no route plan"), models.SyncRejected, "no route plan", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, rec := emptyDB(t)
+			var created, updated bool
+			res := pushRecord(db, "pos", tt.uuid, time.Time{}, &models.Pos{UUID: tt.uuid, Name: "Chez Mama"}, pushHooks{
+				owned:    func() error { return errors.New("not expected for a new record") },
+				validate: func() error { return tt.validate },
+				create: func(*gorm.DB) error {
+					created = true
+					return tt.create
+				},
+				update: func(*gorm.DB) error {
+					updated = true
+					return nil
+				},
+				serverOwned: posServerColumns,
+			})
+
+			if res.Status != tt.wantStatus || res.Error != tt.wantError {
+				t.Fatalf("result = %s %q, want %s %q", res.Status, res.Error, tt.wantStatus, tt.wantError)
+			}
+			if res.Entity != "pos" || res.UUID != tt.uuid {
+				t.Errorf("result of %s %s, want pos %s", res.Entity, res.UUID, tt.uuid)
+			}
+			if updated {
+				t.Error("update hook run for a new record")
+			}
+			if tt.uuid == "" && len(rec.statements) != 0 {
+				t.Errorf("statements = %q, want none without a uuid", rec.statements)
+			}
+
+			var insert, stamp bool
+			for _, sql := range rec.statements {
+				insert = insert || strings.HasPrefix(sql, `INSERT INTO "pos"`)
+				stamp = stamp || (strings.HasPrefix(sql, `UPDATE "pos" SET "updated_at"`) && strings.Contains(sql, recordUUID))
+			}
+			if tt.wantWrites && (!created || !insert || !stamp) {
+				t.Errorf("created %v, insert %v, stamp %v in %q, want all", created, insert, stamp, rec.statements)
+			}
+			if !tt.wantWrites && (insert || stamp) {
+				t.Errorf("written although rejected: %q", rec.statements)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package ndindividual
 
 import (
+	"math"
+
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -28,6 +32,8 @@ import (
 //   - nd_percent        : nd_pos / total_pos_visited × 100
 //   - universe_pos      : total de POS enregistrés dans le territoire de l'agent
 //   - reach_rate        : total_pos_visited / universe_pos × 100
+//   - nd_target         : objectif ND% de l'agent sur la période (table targets)
+//   - nd_achievement    : nd_percent / nd_target × 100
 //
 // Params : user_uuid (path), start_date, end_date (query)
 func GetNDSummary(c *fiber.Ctx) error {
@@ -52,6 +58,8 @@ func GetNDSummary(c *fiber.Ctx) error {
 		NdPercent       float64 `json:"nd_percent"`
 		UniversePos     int64   `json:"universe_pos"`
 		ReachRate       float64 `json:"reach_rate"`
+		NdTarget        float64 `json:"nd_target"`
+		NdAchievement   float64 `json:"nd_achievement"`
 	}
 
 	sqlQuery := `
@@ -111,6 +119,15 @@ func GetNDSummary(c *fiber.Ctx) error {
 		})
 	}
 
+	target, err := utils.AgentTargets(db, models.TargetND, []string{userUUID}, startDate, endDate)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": err.Error(),
+		})
+	}
+	result.NdTarget = math.Round(target(userUUID, "")*100) / 100
+	result.NdAchievement = utils.Achievement(result.NdPercent, result.NdTarget)
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status": "success",
 		"data":   result,
@@ -126,6 +143,8 @@ func GetNDSummary(c *fiber.Ctx) error {
 //   - nd_pos      : COUNT(DISTINCT pos_form_items.uuid) pour cette marque
 //   - total_pos   : total POS visités par l'agent
 //   - nd_percent  : nd_pos / total_pos × 100
+//   - nd_target   : objectif ND% de l'agent pour la marque (table targets)
+//   - nd_achievement : nd_percent / nd_target × 100
 //
 // Params : user_uuid (path), start_date, end_date (query)
 func GetNDByBrand(c *fiber.Ctx) error {
@@ -143,11 +162,13 @@ func GetNDByBrand(c *fiber.Ctx) error {
 	}
 
 	type BrandRow struct {
-		BrandUUID string  `json:"brand_uuid"`
-		BrandName string  `json:"brand_name"`
-		NdPos     int64   `json:"nd_pos"`
-		TotalPos  int64   `json:"total_pos"`
-		NdPercent float64 `json:"nd_percent"`
+		BrandUUID     string  `json:"brand_uuid"`
+		BrandName     string  `json:"brand_name"`
+		NdPos         int64   `json:"nd_pos"`
+		TotalPos      int64   `json:"total_pos"`
+		NdPercent     float64 `json:"nd_percent"`
+		NdTarget      float64 `json:"nd_target"`
+		NdAchievement float64 `json:"nd_achievement"`
 	}
 
 	sqlQuery := `
@@ -196,6 +217,17 @@ func GetNDByBrand(c *fiber.Ctx) error {
 		})
 	}
 
+	target, err := utils.AgentTargets(db, models.TargetND, []string{userUUID}, startDate, endDate)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": err.Error(),
+		})
+	}
+	for i := range rows {
+		rows[i].NdTarget = math.Round(target(userUUID, rows[i].BrandUUID)*100) / 100
+		rows[i].NdAchievement = utils.Achievement(rows[i].NdPercent, rows[i].NdTarget)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status": "success",
 		"data":   rows,
@@ -1,7 +1,11 @@
 package ndindividual
 
 import (
+	"math"
+
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -31,6 +35,8 @@ import (
 //   - reach_rate          : total_pos_visited / universe_pos × 100
 //   - dominant_brand      : marque avec le plus grand nombre de fardes
 //   - dominant_brand_sos  : SOS% de la marque dominante
+//   - sos_target          : objectif SOS% de l'agent pour la marque dominante (table targets)
+//   - sos_achievement     : dominant_brand_sos / sos_target × 100
 //
 // Params : user_uuid (path), start_date, end_date (query)
 func GetSOSSummary(c *fiber.Ctx) error {
@@ -48,15 +54,18 @@ func GetSOSSummary(c *fiber.Ctx) error {
 	}
 
 	type SummaryRow struct {
-		UserUUID         string  `json:"user_uuid"`
-		Fullname         string  `json:"fullname"`
-		TotalPosVisited  int64   `json:"total_pos_visited"`
-		TotalFardesPos   float64 `json:"total_fardes_pos"`
-		BrandCount       int64   `json:"brand_count"`
-		UniversePos      int64   `json:"universe_pos"`
-		ReachRate        float64 `json:"reach_rate"`
-		DominantBrand    string  `json:"dominant_brand"`
-		DominantBrandSos float64 `json:"dominant_brand_sos"`
+		UserUUID          string  `json:"user_uuid"`
+		Fullname          string  `json:"fullname"`
+		TotalPosVisited   int64   `json:"total_pos_visited"`
+		TotalFardesPos    float64 `json:"total_fardes_pos"`
+		BrandCount        int64   `json:"brand_count"`
+		UniversePos       int64   `json:"universe_pos"`
+		ReachRate         float64 `json:"reach_rate"`
+		DominantBrandUUID string  `json:"dominant_brand_uuid"`
+		DominantBrand     string  `json:"dominant_brand"`
+		DominantBrandSos  float64 `json:"dominant_brand_sos"`
+		SosTarget         float64 `json:"sos_target"`
+		SosAchievement    float64 `json:"sos_achievement"`
 	}
 
 	sqlQuery := `
@@ -102,6 +111,7 @@ func GetSOSSummary(c *fiber.Ctx) error {
 		),
 		dominant AS (
 			SELECT 
+				b.uuid AS brand_uuid,
 				b.name AS brand_name,
 				ROUND((fs.total_fardes * 100.0 / NULLIF((SELECT total FROM total_fardes), 0))::numeric, 2) AS sos_percent
 			FROM fardes_summary fs
@@ -118,6 +128,7 @@ func GetSOSSummary(c *fiber.Ctx) error {
 			COALESCE(u.universe_pos, 0)                                       AS universe_pos,
 			ROUND((COALESCE(v.total_pos, 0) * 100.0 /
 			       NULLIF(COALESCE(u.universe_pos, 0), 0))::numeric, 2)      AS reach_rate,
+			COALESCE((SELECT brand_uuid FROM dominant), '')                   AS dominant_brand_uuid,
 			COALESCE((SELECT brand_name FROM dominant), 'N/A')                AS dominant_brand,
 			COALESCE((SELECT sos_percent FROM dominant), 0)                   AS dominant_brand_sos
 		FROM agent a
@@ -138,6 +149,15 @@ func GetSOSSummary(c *fiber.Ctx) error {
 		})
 	}
 
+	target, err := utils.AgentTargets(db, models.TargetSOS, []string{userUUID}, startDate, endDate)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": err.Error(),
+		})
+	}
+	result.SosTarget = math.Round(target(userUUID, result.DominantBrandUUID)*100) / 100
+	result.SosAchievement = utils.Achievement(result.DominantBrandSos, result.SosTarget)
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status": "success",
 		"data":   result,
@@ -155,6 +175,8 @@ func GetSOSSummary(c *fiber.Ctx) error {
 //   - sos_percent      : brand_fardes / total_fardes × 100
 //   - pos_count        : nombre de POS où cette marque a des fardes
 //   - avg_fardes_per_pos: moyenne de fardes par POS
+//   - sos_target       : objectif SOS% de l'agent pour la marque (table targets)
+//   - sos_achievement  : sos_percent / sos_target × 100
 //
 // Params : user_uuid (path), start_date, end_date (query)
 func GetSOSByBrand(c *fiber.Ctx) error {
@@ -179,6 +201,8 @@ func GetSOSByBrand(c *fiber.Ctx) error {
 		SosPercent      float64 `json:"sos_percent"`
 		PosCount        int64   `json:"pos_count"`
 		AvgFardesPerPos float64 `json:"avg_fardes_per_pos"`
+		SosTarget       float64 `json:"sos_target"`
+		SosAchievement  float64 `json:"sos_achievement"`
 	}
 
 	sqlQuery := `
@@ -233,6 +257,17 @@ func GetSOSByBrand(c *fiber.Ctx) error {
 		})
 	}
 
+	target, err := utils.AgentTargets(db, models.TargetSOS, []string{userUUID}, startDate, endDate)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "error", "message": err.Error(),
+		})
+	}
+	for i := range rows {
+		rows[i].SosTarget = math.Round(target(userUUID, rows[i].BrandUUID)*100) / 100
+		rows[i].SosAchievement = utils.Achievement(rows[i].SosPercent, rows[i].SosTarget)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status": "success",
 		"data":   rows,
@@ -0,0 +1,198 @@
+package notification
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"gorm.io/gorm"
+)
+
+// unreadCount counts the unread notifications of the user.
+func unreadCount(userUUID string) int64 {
+	var count int64
+	database.DB.Model(&models.Notification{}).
+		Where("user_uuid = ? AND read_at IS NULL", userUUID).
+		Count(&count)
+	return count
+}
+
+// GetNotifications lists the inbox of the caller, latest first.
+// ?unread=true keeps the unread ones, ?type= one type.
+func GetNotifications(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset := (page - 1) * limit
+
+	query := database.DB.Model(&models.Notification{}).Where("user_uuid = ?", user.UUID)
+	if c.QueryBool("unread") {
+		query = query.Where("read_at IS NULL")
+	}
+	if kind := c.Query("type"); kind != "" {
+		query = query.Where("type = ?", kind)
+	}
+
+	query = query.Session(&gorm.Session{})
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var dataList []models.Notification
+	err = query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch notifications",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "notifications retrieved successfully",
+		"data":    dataList,
+		"unread":  unreadCount(user.UUID),
+		"pagination": map[string]interface{}{
+			"total_records": totalRecords,
+			"total_pages":   totalPages,
+			"current_page":  page,
+			"page_size":     limit,
+		},
+	})
+}
+
+// GetUnreadCount returns the number of unread notifications of the caller.
+func GetUnreadCount(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "unread notifications",
+		"data":    fiber.Map{"count": unreadCount(user.UUID)},
+	})
+}
+
+// MarkRead marks one notification of the caller as read.
+func MarkRead(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+
+	var n models.Notification
+	database.DB.Where("uuid = ? AND user_uuid = ?", c.Params("uuid"), user.UUID).First(&n)
+	if n.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No notification found",
+			"data":    nil,
+		})
+	}
+	if n.ReadAt == nil {
+		now := time.Now()
+		if err := database.DB.Model(&n).Update("read_at", now).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Failed to update notification",
+				"error":   err.Error(),
+			})
+		}
+		n.ReadAt = &now
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "notification read",
+		"data":    n,
+	})
+}
+
+// MarkAllRead marks every notification of the caller as read.
+func MarkAllRead(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+
+	res := database.DB.Model(&models.Notification{}).
+		Where("user_uuid = ? AND read_at IS NULL", user.UUID).
+		Update("read_at", time.Now())
+	if res.Error != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update notifications",
+			"error":   res.Error.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "notifications read",
+		"data":    fiber.Map{"updated": res.RowsAffected},
+	})
+}
+
+// writeEvent writes a Server-Sent Event with data as JSON.
+func writeEvent(w *bufio.Writer, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Stream pushes the new notifications of the caller as Server-Sent Events:
+// "unread_count" once connected, then a "notification" per notification.
+// A comment is sent every 25 seconds to keep the connection open. Browsers
+// pass the token as the token query param, EventSource having no headers.
+func Stream(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+	ch, cancel := utils.Notifications.Subscribe(user.UUID)
+	count := unreadCount(user.UUID)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		if err := writeEvent(w, "unread_count", fiber.Map{"count": count}); err != nil {
+			return
+		}
+
+		ping := time.NewTicker(25 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case n, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := writeEvent(w, "notification", n); err != nil {
+					return
+				}
+			case <-ping.C:
+				if _, err := w.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	return nil
+}
@@ -2,9 +2,9 @@ package observation
 
 import (
 	"strconv"
-	"strings"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
@@ -58,6 +58,9 @@ func paginatedObservations(c *fiber.Ctx, extraWhere ...interface{}) error {
 	// Common search / geographic / agent filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	query.Count(&totalRecords)
 
 	err = query.
@@ -133,70 +136,28 @@ func paginatedObservations(c *fiber.Ctx, extraWhere ...interface{}) error {
 
 // —————————————————————————————————————————————————————————————
 // 1. ROLE-BASED endpoint  –  auto-filters according to current user's role
-//    GET /api/observations/all/paginate?token=<jwt>
+//    GET /api/observations/all/paginate
 // —————————————————————————————————————————————————————————————
 
-// GetObservationsByRole retrieves paginated observations filtered by the role
-// of the authenticated user:
-//   - Support / Manager  → no territory filter (see everything)
+// GetObservationsByRole retrieves paginated observations restricted to the
+// territory of the authenticated user (see utils.ResolveScope):
+//   - Support            → no territory filter (see everything)
+//   - Manager            → filtered to their country
 //   - ASM                → filtered to their province
 //   - Supervisor (Sup)   → filtered to their area
 //   - DR                 → filtered to their sub-area
 //   - Cyclo              → filtered to their commune
 func GetObservationsByRole(c *fiber.Ctx) error {
-	// Resolve JWT from query param or cookie (mirrors AuthUser pattern)
-	token := c.Query("token")
-	if token == "" {
-		token = c.Cookies("token")
-	}
-
-	userUUID, err := utils.VerifyJwt(token)
-	if err != nil || userUUID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Non autorisé — token invalide ou manquant",
-		})
-	}
-
-	// Load the current user
-	var currentUser models.User
-	if err := database.DB.Where("uuid = ?", userUUID).First(&currentUser).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Utilisateur introuvable",
-		})
-	}
-
-	role := strings.ToLower(strings.TrimSpace(currentUser.Role))
-
-	switch role {
-	case "support", "manager":
-		// No territory restriction — see everything
-		return paginatedObservations(c)
-
-	case "asm":
-		return paginatedObservations(c,
-			"pos_forms.province_uuid = ?", currentUser.ProvinceUUID)
-
-	case "supervisor", "sup":
-		return paginatedObservations(c,
-			"pos_forms.area_uuid = ?", currentUser.AreaUUID)
-
-	case "dr":
-		return paginatedObservations(c,
-			"pos_forms.sub_area_uuid = ?", currentUser.SubAreaUUID)
-
-	case "cyclo":
-		return paginatedObservations(c,
-			"pos_forms.commune_uuid = ?", currentUser.CommuneUUID)
-
-	default:
+	if middlewares.Scope(c).Denied {
 		// Unknown role — restricted to nothing
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Rôle non reconnu — accès refusé",
 		})
 	}
+
+	// paginatedObservations applies the caller's scope
+	return paginatedObservations(c)
 }
 
 // —————————————————————————————————————————————————————————————
@@ -6,10 +6,13 @@ import (
 	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
 )
 
 // Paginate
@@ -42,6 +45,9 @@ func GetPaginatedPos(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -122,6 +128,9 @@ func GetPaginatedPosByCountryUUID(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -202,6 +211,9 @@ func GetPaginatedPosByProvinceUUID(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -282,6 +294,9 @@ func GetPaginatedPosByAreaUUID(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -362,6 +377,9 @@ func GetPaginatedPosBySubAreaUUID(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -442,6 +460,9 @@ func GetPaginatedPosByCommuneUUID(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -520,6 +541,9 @@ func GetPaginatedPosByCommuneUserUUIDFilter(c *fiber.Ctx) error {
 	// Apply advanced filters
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -569,7 +593,7 @@ func GetPaginatedPosByCommuneUserUUIDFilter(c *fiber.Ctx) error {
 
 // Get All data
 func GetAllPoss(c *fiber.Ctx) error {
-	db := database.DB
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "pos")
 	var data []models.Pos
 	db.Where("status = ?", true).Find(&data)
 	return c.JSON(fiber.Map{
@@ -581,7 +605,7 @@ func GetAllPoss(c *fiber.Ctx) error {
 
 // Get All data by manager
 func GetAllPosByManager(c *fiber.Ctx) error {
-	db := database.DB
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "pos")
 
 	countryUUID := c.Params("country_uuid")
 
@@ -597,7 +621,7 @@ func GetAllPosByManager(c *fiber.Ctx) error {
 
 // Get All data by ASM
 func GetAllPosByASM(c *fiber.Ctx) error {
-	db := database.DB
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "pos")
 
 	ProvinceUUID := c.Params("province_uuid")
 
@@ -613,7 +637,7 @@ func GetAllPosByASM(c *fiber.Ctx) error {
 
 // Get All data by Supervisor
 func GetAllPosBySup(c *fiber.Ctx) error {
-	db := database.DB
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "pos")
 
 	AreaUUID := c.Params("area_uuid")
 
@@ -629,7 +653,7 @@ func GetAllPosBySup(c *fiber.Ctx) error {
 
 // Get All data by DR
 func GetAllPosByDR(c *fiber.Ctx) error {
-	db := database.DB
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "pos")
 
 	SubAreaUUID := c.Params("sub_area_uuid")
 
@@ -645,7 +669,7 @@ func GetAllPosByDR(c *fiber.Ctx) error {
 
 // Get All data by CYclo
 func GetAllPosByCyclo(c *fiber.Ctx) error {
-	db := database.DB
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "pos")
 
 	UserUUID := c.Params("user_uuid")
 
@@ -702,6 +726,8 @@ func CreatePos(c *fiber.Ctx) error {
 
 	p.UUID = uuid.New().String()
 	p.Sync = true
+	// The segment is set by the segmentation job only
+	p.Segment, p.SegmentScore, p.SegmentedAt = "", 0, nil
 	database.DB.Create(p)
 
 	return c.JSON(
@@ -768,6 +794,7 @@ func UpdatePos(c *fiber.Ctx) error {
 	pos := new(models.Pos)
 
 	db.Where("uuid = ?", uuid).First(&pos)
+	before := *pos
 	pos.Name = updateData.Name
 	pos.Shop = updateData.Shop
 	pos.Postype = updateData.Postype
@@ -793,7 +820,34 @@ func UpdatePos(c *fiber.Ctx) error {
 	pos.Signature = updateData.Signature
 	pos.Sync = true
 
-	db.Save(&pos)
+	// A POS moved out of a territory is dropped from the devices there; a
+	// POS of another type has the daily facts of its visits recomputed
+	var factForms []*models.PosForm
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&pos).Error; err != nil {
+			return err
+		}
+		if err := utils.RecordPosMove(tx, &before, pos); err != nil {
+			return err
+		}
+		if before.Postype == pos.Postype {
+			return nil
+		}
+		var err error
+		if factForms, err = utils.PosDailyFactForms(tx, []string{pos.UUID}); err != nil {
+			return err
+		}
+		return utils.RefreshDailyFacts(tx, factForms...)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update POS",
+			"error":   err.Error(),
+		})
+	}
+
+	utils.InvalidateCachedResponses(factForms...)
 
 	return c.JSON(
 		fiber.Map{
@@ -833,12 +887,95 @@ func DeletePos(c *fiber.Ctx) error {
 	)
 }
 
+// SetPosLocation lets a supervisor fix the canonical location of a POS.
+// The geofence of the POS's existing visits is recomputed against it.
+func SetPosLocation(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var body struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Accuracy  float64 `json:"accuracy"` // Radius in meters
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if !utils.ValidCoordinates(body.Latitude, body.Longitude) || body.Accuracy < 0 {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid coordinates or accuracy",
+		})
+	}
+	if body.Accuracy == 0 {
+		body.Accuracy = utils.DefaultGeofenceRadius
+	}
+
+	var pos models.Pos
+	if err := db.Where("uuid = ?", uuid).First(&pos).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No POS found",
+			"data":    nil,
+		})
+	}
+	if !middlewares.Scope(c).Contains(pos.CountryUUID, pos.ProvinceUUID, pos.AreaUUID, pos.SubAreaUUID, pos.CommuneUUID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "POS is outside of your territory",
+		})
+	}
+
+	now := time.Now()
+	pos.Latitude = body.Latitude
+	pos.Longitude = body.Longitude
+	pos.LocationAccuracy = body.Accuracy
+	pos.LocationSource = models.PosLocationFromSupervisor
+	pos.LocationSamples = 0
+	pos.LocationSetAt = &now
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&pos).Updates(map[string]interface{}{
+			"latitude":          pos.Latitude,
+			"longitude":         pos.Longitude,
+			"location_accuracy": pos.LocationAccuracy,
+			"location_source":   pos.LocationSource,
+			"location_samples":  pos.LocationSamples,
+			"location_set_at":   pos.LocationSetAt,
+		}).Error; err != nil {
+			return err
+		}
+		return utils.RegeofenceVisits(tx, &pos)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to set POS location",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "POS location updated success",
+		"data":    pos,
+	})
+}
+
 // GeneratePosExcelReport generates an Excel report for POS data
 func GeneratePosExcelReport(c *fiber.Ctx) error {
+	return utils.SendReport(c, BuildPosExcelReport)
+}
+
+// BuildPosExcelReport builds the POS report of GeneratePosExcelReport. When
+// streaming, the detailed rows go to their own sheet.
+func BuildPosExcelReport(c *fiber.Ctx, opts utils.ReportOptions) (*excelize.File, string, error) {
 	db := database.DB
 
-	// Parse query parameters for filtering (same as pagination)
-	var dataList []models.Pos
 	var totalRecords int64
 
 	// Build query with joins for better filtering
@@ -853,6 +990,9 @@ func GeneratePosExcelReport(c *fiber.Ctx) error {
 	// Apply common filters (geographic and agent filters)
 	query = utils.ApplyCommonFilters(query, c, "pos", []string{"name", "shop", "postype", "gerant", "quartier", "reference"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos")
+
 	// Apply date range filters for Excel export
 	startDate := c.Query("startDate", "")
 	endDate := c.Query("endDate", "")
@@ -878,40 +1018,19 @@ func GeneratePosExcelReport(c *fiber.Ctx) error {
 			query = query.Where("pos.created_at <= ?", endTime)
 		}
 	}
+	query = query.Session(&gorm.Session{})
 
 	// Count total records
 	query.Count(&totalRecords)
 
-	// Get all filtered data for the report (no pagination for Excel)
-	// Limit to 10000 records to prevent memory issues
+	// Limit the in-request report to 10000 records to prevent memory
+	// issues; streamed exports have no limit
 	limit := 10000
-	if totalRecords > int64(limit) {
-		return c.Status(400).JSON(fiber.Map{
-			"status":  "error",
-			"message": fmt.Sprintf("Trop de données pour le rapport Excel. Maximum %d enregistrements autorisés, %d trouvés. Veuillez utiliser des filtres plus spécifiques.", limit, totalRecords),
-			"data":    nil,
-		})
-	}
-
-	err := query.
-		Select("pos.*").
-		Order("pos.updated_at DESC").
-		Preload("Country").
-		Preload("Province").
-		Preload("Area").
-		Preload("SubArea").
-		Preload("Commune").
-		Preload("User").
-		Preload("PosForms").
-		Preload("PosEquipments").
-		Find(&dataList).Error
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Échec de la récupération des données POS pour le rapport Excel",
-			"error":   err.Error(),
-		})
+	if !opts.Stream && totalRecords > int64(limit) {
+		return nil, "", &utils.ReportError{
+			Status:  400,
+			Message: fmt.Sprintf("Trop de données pour le rapport Excel. Maximum %d enregistrements autorisés, %d trouvés. Veuillez utiliser des filtres plus spécifiques ou un export asynchrone.", limit, totalRecords),
+		}
 	}
 
 	// Create Excel file
@@ -931,41 +1050,15 @@ func GeneratePosExcelReport(c *fiber.Ctx) error {
 	// Setup styles
 	styles, err := utils.SetupExcelStyles(f)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de la configuration des styles Excel",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de la configuration des styles Excel", Err: err}
 	}
 
 	// Add report header
 	err = utils.AddReportHeader(f, sheetName, config, styles)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de l'ajout de l'en-tête du rapport",
-			"error":   err.Error(),
-		})
-	}
-
-	// Add summary statistics
-	summaryData := map[string]interface{}{
-		"Total des POS":      totalRecords,
-		"POS Actifs":         countActivePos(dataList),
-		"POS Inactifs":       countInactivePos(dataList),
-		"Total Provinces":    countUniqueProvinces(dataList),
-		"Total Aires":        countUniqueAreas(dataList),
-		"Total Sous-Aires":   countUniqueSubAreas(dataList),
-		"Date de génération": time.Now().Format("02/01/2006 15:04:05"),
-	}
-
-	err = utils.AddSummaryTable(f, sheetName, summaryData, 6, styles)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de l'ajout du résumé",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de l'ajout de l'en-tête du rapport", Err: err}
 	}
 
 	// Define headers for the main data table
@@ -976,198 +1069,161 @@ func GeneratePosExcelReport(c *fiber.Ctx) error {
 		"Statut", "Date Création", "Date Modification",
 	}
 
-	// Start data table after summary (row 15)
+	// Start data table after summary (row 15), or on its own sheet when
+	// streaming
+	dataSheet := sheetName
 	dataStartRow := 15
+	if opts.Stream {
+		dataSheet = "Données POS"
+		f.NewSheet(dataSheet)
+		dataStartRow = 1
+	}
+	rows, err := utils.NewSheetRowWriter(f, dataSheet, opts.Stream)
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de la création de la feuille de données", Err: err}
+	}
+	rows.SetColWidth(1, len(headers), 15.0)
 
 	// Add main data table title
-	f.SetCellValue(sheetName, fmt.Sprintf("A%d", dataStartRow), "DONNÉES DÉTAILLÉES DES POS")
-	f.SetCellStyle(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow), styles["title"])
-	f.MergeCell(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow))
-	dataStartRow += 2
+	if !opts.Stream {
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", dataStartRow), "DONNÉES DÉTAILLÉES DES POS")
+		f.SetCellStyle(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow), styles["title"])
+		f.MergeCell(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow))
+		dataStartRow += 2
+	}
 
 	// Add headers
+	headerValues := make([]interface{}, len(headers))
+	headerStyles := make([]int, len(headers))
 	for i, header := range headers {
-		col := string(rune('A' + i))
-		if i >= 26 {
-			// Handle columns beyond Z (AA, AB, etc.)
-			col = string(rune('A'+(i/26-1))) + string(rune('A'+(i%26)))
-		}
-		cell := fmt.Sprintf("%s%d", col, dataStartRow)
-		f.SetCellValue(sheetName, cell, header)
-		f.SetCellStyle(sheetName, cell, cell, styles["header"])
+		headerValues[i] = header
+		headerStyles[i] = styles["header"]
 	}
+	rows.SetRow(dataStartRow, headerValues, headerStyles)
 
-	// Add data rows
-	for i, pos := range dataList {
-		row := dataStartRow + 1 + i
-
-		// Convert status to readable format
-		statusText := "Inactif"
-		if pos.Status {
-			statusText = "Actif"
-		}
-
-		// Get related data safely
-		countryName := ""
-		if pos.Country.Name != "" {
-			countryName = pos.Country.Name
-		}
-
-		provinceName := ""
-		if pos.Province.Name != "" {
-			provinceName = pos.Province.Name
-		}
-
-		areaName := ""
-		if pos.Area.Name != "" {
-			areaName = pos.Area.Name
-		}
-
-		subAreaName := ""
-		if pos.SubArea.Name != "" {
-			subAreaName = pos.SubArea.Name
-		}
-
-		communeName := ""
-		if pos.Commune.Name != "" {
-			communeName = pos.Commune.Name
-		}
-
-		userName := ""
-		if pos.User.Fullname != "" {
-			userName = pos.User.Fullname
-		}
+	// Add data rows, batch by batch
+	summary := newPosSummary()
+	row := dataStartRow
+	err = utils.EachBatch(query.Select("pos.*").
+		Preload("Country").
+		Preload("Province").
+		Preload("Area").
+		Preload("SubArea").
+		Preload("Commune").
+		Preload("User"),
+		"pos", 1000,
+		func(p *models.Pos) (time.Time, string) { return p.UpdatedAt, p.UUID },
+		func(batch []models.Pos) error {
+			for _, pos := range batch {
+				row++
+				summary.add(&pos)
+
+				// Convert status to readable format
+				statusText := "Inactif"
+				statusStyle := styles["warning"]
+				if pos.Status {
+					statusText = "Actif"
+					statusStyle = styles["success"]
+				}
 
-		// Data array
-		rowData := []interface{}{
-			pos.UUID,
-			pos.Name,
-			pos.Shop,
-			pos.Postype,
-			pos.Gerant,
-			pos.Avenue,
-			pos.Quartier,
-			pos.Reference,
-			pos.Telephone,
-			countryName,
-			provinceName,
-			areaName,
-			subAreaName,
-			communeName,
-			userName,
-			pos.Asm,
-			pos.Sup,
-			pos.Dr,
-			pos.Cyclo,
-			statusText,
-			pos.CreatedAt.Format("02/01/2006 15:04:05"),
-			pos.UpdatedAt.Format("02/01/2006 15:04:05"),
-		}
+				// Data array
+				rowData := []interface{}{
+					pos.UUID,
+					pos.Name,
+					pos.Shop,
+					pos.Postype,
+					pos.Gerant,
+					pos.Avenue,
+					pos.Quartier,
+					pos.Reference,
+					pos.Telephone,
+					pos.Country.Name,
+					pos.Province.Name,
+					pos.Area.Name,
+					pos.SubArea.Name,
+					pos.Commune.Name,
+					pos.User.Fullname,
+					pos.Asm,
+					pos.Sup,
+					pos.Dr,
+					pos.Cyclo,
+					statusText,
+					pos.CreatedAt.Format("02/01/2006 15:04:05"),
+					pos.UpdatedAt.Format("02/01/2006 15:04:05"),
+				}
 
-		// Set data in cells
-		for j, data := range rowData {
-			col := string(rune('A' + j))
-			if j >= 26 {
-				col = string(rune('A'+(j/26-1))) + string(rune('A'+(j%26)))
-			}
-			cell := fmt.Sprintf("%s%d", col, row)
-			f.SetCellValue(sheetName, cell, data)
+				// Apply appropriate style based on data type
+				rowStyles := make([]int, len(rowData))
+				for j := range rowStyles {
+					rowStyles[j] = styles["data"]
+				}
+				rowStyles[19] = statusStyle                                   // Status column
+				rowStyles[20], rowStyles[21] = styles["date"], styles["date"] // Date columns
 
-			// Apply appropriate style based on data type
-			style := styles["data"]
-			if j == 19 { // Status column
-				if pos.Status {
-					style = styles["success"]
-				} else {
-					style = styles["warning"]
+				if err := rows.SetRow(row, rowData, rowStyles); err != nil {
+					return err
 				}
-			} else if j == 20 || j == 21 { // Date columns
-				style = styles["date"]
 			}
-			f.SetCellStyle(sheetName, cell, cell, style)
-		}
+			opts.ReportProgress(row-dataStartRow, int(totalRecords))
+			return nil
+		})
+	if err == nil {
+		err = rows.Flush()
 	}
-
-	// Auto-fit columns
-	columns := []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O", "P", "Q", "R", "S", "T", "U", "V"}
-	err = utils.AutoFitColumns(f, sheetName, columns, 15.0)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de l'ajustement des colonnes",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Échec de la récupération des données POS pour le rapport Excel", Err: err}
 	}
 
-	// Generate filename with timestamp
-	filename := fmt.Sprintf("rapport_pos_%s.xlsx", time.Now().Format("2006-01-02_15-04-05"))
-
-	// Set response headers for file download
-	c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	// Add summary statistics
+	summaryData := map[string]interface{}{
+		"Total des POS":      totalRecords,
+		"POS Actifs":         summary.active,
+		"POS Inactifs":       summary.inactive,
+		"Total Provinces":    len(summary.provinces),
+		"Total Aires":        len(summary.areas),
+		"Total Sous-Aires":   len(summary.subAreas),
+		"Date de génération": time.Now().Format("02/01/2006 15:04:05"),
+	}
 
-	// Write file to response
-	buffer, err := f.WriteToBuffer()
+	err = utils.AddSummaryTable(f, sheetName, summaryData, 6, styles)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de la génération du fichier Excel",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de l'ajout du résumé", Err: err}
 	}
 
-	return c.Send(buffer.Bytes())
-}
+	// Generate filename with timestamp
+	filename := fmt.Sprintf("rapport_pos_%s.xlsx", time.Now().Format("2006-01-02_15-04-05"))
 
-// Helper functions for summary statistics
-func countActivePos(posList []models.Pos) int {
-	count := 0
-	for _, pos := range posList {
-		if pos.Status {
-			count++
-		}
-	}
-	return count
+	return f, filename, nil
 }
 
-func countInactivePos(posList []models.Pos) int {
-	count := 0
-	for _, pos := range posList {
-		if !pos.Status {
-			count++
-		}
-	}
-	return count
+// posSummary counts the POS of a report as its rows are written.
+type posSummary struct {
+	active, inactive           int
+	provinces, areas, subAreas map[string]bool
 }
 
-func countUniqueProvinces(posList []models.Pos) int {
-	provinces := make(map[string]bool)
-	for _, pos := range posList {
-		if pos.Province.Name != "" {
-			provinces[pos.Province.Name] = true
-		}
-	}
-	return len(provinces)
+func newPosSummary() *posSummary {
+	return &posSummary{provinces: map[string]bool{}, areas: map[string]bool{}, subAreas: map[string]bool{}}
 }
 
-func countUniqueAreas(posList []models.Pos) int {
-	areas := make(map[string]bool)
-	for _, pos := range posList {
-		if pos.Area.Name != "" {
-			areas[pos.Area.Name] = true
-		}
+func (s *posSummary) add(pos *models.Pos) {
+	if pos.Status {
+		s.active++
+	} else {
+		s.inactive++
 	}
-	return len(areas)
-}
-
-func countUniqueSubAreas(posList []models.Pos) int {
-	subAreas := make(map[string]bool)
-	for _, pos := range posList {
-		if pos.SubArea.Name != "" {
-			subAreas[pos.SubArea.Name] = true
-		}
+	if pos.Province.Name != "" {
+		s.provinces[pos.Province.Name] = true
+	}
+	if pos.Area.Name != "" {
+		s.areas[pos.Area.Name] = true
+	}
+	if pos.SubArea.Name != "" {
+		s.subAreas[pos.SubArea.Name] = true
 	}
-	return len(subAreas)
 }
 
 func MapPos(c *fiber.Ctx) error {
@@ -1190,6 +1246,11 @@ func MapPos(c *fiber.Ctx) error {
 		Dr        string  `json:"dr"`         // Name of the DR
 		Cyclo     string  `json:"cyclo"`      // Name of the Cyclo
 		CreatedAt string  `json:"created_at"` // Creation date of the form
+
+		PosLatitude    float64  `json:"pos_latitude"`    // Canonical location of the POS
+		PosLongitude   float64  `json:"pos_longitude"`   // Canonical location of the POS
+		DistanceToPos  *float64 `json:"distance_to_pos"` // Meters between the visit and the POS
+		GeofenceStatus string   `json:"geofence_status"` // inside, outside or unknown
 	}
 
 	err := db.Table("pos_forms").
@@ -1197,6 +1258,10 @@ func MapPos(c *fiber.Ctx) error {
 		Select(`
 			pos_forms.latitude AS latitude,
 			pos_forms.longitude AS longitude,
+			pos.latitude AS pos_latitude,
+			pos.longitude AS pos_longitude,
+			pos_forms.distance_to_pos AS distance_to_pos,
+			pos_forms.geofence_status AS geofence_status,
 			pos_forms.signature AS signature,
 			pos_forms.created_at AS created_at,
 			pos.name AS pos_name,
@@ -6,9 +6,12 @@ import (
 	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2" 
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
 )
 
 // Paginate ALL data
@@ -52,6 +55,9 @@ func GetPaginatedPosForm(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -143,6 +149,9 @@ func GetPaginatedPosFormCountryUUID(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -234,6 +243,9 @@ func GetPaginatedPosFormProvine(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -325,6 +337,9 @@ func GetPaginatedPosFormArea(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -416,6 +431,9 @@ func GetPaginatedPosFormSubArea(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -507,6 +525,9 @@ func GetPaginatedPosFormCommune(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -598,6 +619,9 @@ func GetPaginatedPosFormCommuneFilter(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -689,6 +713,9 @@ func GetPaginatedPosFormByPOS(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -779,6 +806,9 @@ func GetPaginatedPosFormByUserUUID(c *fiber.Ctx) error {
 	// Apply filters
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Count total records
 	query.Count(&totalRecords)
 
@@ -872,9 +902,65 @@ func CreatePosform(c *fiber.Ctx) error {
 	}
 
 	// p.UUID = uuid.New().String()
+	if p.UUID == "" {
+		p.UUID = utils.GenerateUUID()
+	}
+
+	if err := utils.NormalizeFormCurrency(p); err != nil {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid currency",
+			"error":   err.Error(),
+		})
+	}
+	currency := utils.VisitCurrency(database.DB, p)
+	for i := range p.PosFormItems {
+		if _, err := utils.NormalizeItemPrice(&p.PosFormItems[i], currency); err != nil {
+			return c.Status(422).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid brand line price",
+				"error":   err.Error(),
+			})
+		}
+		if err := utils.CheckItemSku(database.DB, &p.PosFormItems[i]); err != nil {
+			return c.Status(422).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid brand line SKU",
+				"error":   err.Error(),
+			})
+		}
+	}
 
 	// p.Sync = true
-	database.DB.Create(p)
+	if err := utils.ApplyVisitTiming(p); err != nil {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid check-in / check-out",
+			"error":   err.Error(),
+		})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.ApplyGeofence(tx, p); err != nil {
+			return err
+		}
+		if err := utils.MatchRoutePlan(tx, p); err != nil {
+			return err
+		}
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+		return utils.RefreshDailyFacts(tx, p)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create posform",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(p)
+	utils.NotifyObservations(database.DB, p)
 
 	return c.JSON(
 		fiber.Map{
@@ -891,9 +977,10 @@ func UpdatePosform(c *fiber.Ctx) error {
 	db := database.DB
 
 	type UpdateData struct {
-		Price   int    `json:"price"`
-		Comment string `json:"comment"`
-		PosUUID string `json:"pos_uuid"`
+		Price    int    `json:"price"`
+		Currency string `json:"currency"`
+		Comment  string `json:"comment"`
+		PosUUID  string `json:"pos_uuid"`
 
 		Latitude  float64 `json:"latitude"`  // Latitude of the user
 		Longitude float64 `json:"longitude"` // Longitude of the user
@@ -931,8 +1018,17 @@ func UpdatePosform(c *fiber.Ctx) error {
 	posform := new(models.PosForm)
 
 	db.Where("uuid = ?", uuid).First(&posform)
+	before := *posform
 
 	posform.Price = updateData.Price
+	posform.Currency = updateData.Currency
+	if err := utils.NormalizeFormCurrency(posform); err != nil {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid currency",
+			"error":   err.Error(),
+		})
+	}
 	posform.Comment = updateData.Comment
 	posform.PosUUID = updateData.PosUUID
 
@@ -957,7 +1053,26 @@ func UpdatePosform(c *fiber.Ctx) error {
 	posform.UserUUID = updateData.UserUUID
 	// posform.Sync = true
 
-	db.Save(&posform)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := utils.UpdateGeofence(tx, &before, posform); err != nil {
+			return err
+		}
+		if err := utils.RematchRoutePlan(tx, &before, posform); err != nil {
+			return err
+		}
+		if err := tx.Save(&posform).Error; err != nil {
+			return err
+		}
+		return utils.RefreshDailyFacts(tx, &before, posform)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update posform",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(&before, posform)
 
 	return c.JSON(
 		fiber.Map{
@@ -987,7 +1102,23 @@ func DeletePosform(c *fiber.Ctx) error {
 		)
 	}
 
-	db.Delete(&posform)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := utils.UnmatchRoutePlan(tx, &posform); err != nil {
+			return err
+		}
+		if err := tx.Delete(&posform).Error; err != nil {
+			return err
+		}
+		return utils.RefreshDailyFacts(tx, &posform)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete posform",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(&posform)
 
 	return c.JSON(
 		fiber.Map{
@@ -1001,10 +1132,15 @@ func DeletePosform(c *fiber.Ctx) error {
 
 // GeneratePosFormExcelReport generates an Excel report for PosForm data
 func GeneratePosFormExcelReport(c *fiber.Ctx) error {
+	return utils.SendReport(c, BuildPosFormExcelReport)
+}
+
+// BuildPosFormExcelReport builds the PosForm report of
+// GeneratePosFormExcelReport. When streaming, the detailed rows go to their
+// own sheet.
+func BuildPosFormExcelReport(c *fiber.Ctx, opts utils.ReportOptions) (*excelize.File, string, error) {
 	db := database.DB
 
-	// Parse query parameters for filtering
-	var dataList []models.PosForm
 	var totalRecords int64
 
 	// Get date parameters for display in report
@@ -1032,6 +1168,9 @@ func GeneratePosFormExcelReport(c *fiber.Ctx) error {
 	// Apply common filters (geographic and agent filters)
 	query = utils.ApplyCommonFilters(query, c, "pos_forms", []string{"comment"})
 
+	// Restrict to the caller's territory
+	query = utils.ApplyScope(query, middlewares.Scope(c), "pos_forms")
+
 	// Apply date range filters for Excel export
 	if startDate != "" && endDate != "" {
 		query = query.Where("pos_forms.created_at >= ? AND pos_forms.created_at <= ?", startDate, endDate)
@@ -1040,40 +1179,19 @@ func GeneratePosFormExcelReport(c *fiber.Ctx) error {
 	} else if endDate != "" {
 		query = query.Where("pos_forms.created_at <= ?", endDate)
 	}
+	query = query.Session(&gorm.Session{})
 
 	// Count total records
 	query.Count(&totalRecords)
 
-	// Get all filtered data for the report (no pagination for Excel)
-	// Limit to 10000 records to prevent memory issues
+	// Limit the in-request report to 10000 records to prevent memory
+	// issues; streamed exports have no limit
 	limit := 10000
-	if totalRecords > int64(limit) {
-		return c.Status(400).JSON(fiber.Map{
-			"status":  "error",
-			"message": fmt.Sprintf("Trop de données pour le rapport Excel. Maximum %d enregistrements autorisés, %d trouvés. Veuillez utiliser des filtres plus spécifiques.", limit, totalRecords),
-			"data":    nil,
-		})
-	}
-
-	err := query.
-		Select("pos_forms.*").
-		Order("pos_forms.updated_at DESC").
-		Preload("Country").
-		Preload("Province").
-		Preload("Area").
-		Preload("SubArea").
-		Preload("Commune").
-		Preload("User").
-		Preload("Pos").
-		Preload("PosFormItems").
-		Find(&dataList).Error
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Échec de la récupération des données PosForm pour le rapport Excel",
-			"error":   err.Error(),
-		})
+	if !opts.Stream && totalRecords > int64(limit) {
+		return nil, "", &utils.ReportError{
+			Status:  400,
+			Message: fmt.Sprintf("Trop de données pour le rapport Excel. Maximum %d enregistrements autorisés, %d trouvés. Veuillez utiliser des filtres plus spécifiques ou un export asynchrone.", limit, totalRecords),
+		}
 	}
 
 	// Create Excel file
@@ -1102,53 +1220,15 @@ func GeneratePosFormExcelReport(c *fiber.Ctx) error {
 	// Setup styles
 	styles, err := utils.SetupExcelStyles(f)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de la configuration des styles Excel",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de la configuration des styles Excel", Err: err}
 	}
 
 	// Add report header
 	err = utils.AddReportHeader(f, sheetName, config, styles)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de l'ajout de l'en-tête du rapport",
-			"error":   err.Error(),
-		})
-	}
-
-	// Add summary statistics
-	summaryData := map[string]interface{}{
-		"Total des Formulaires":  totalRecords,
-		"Formulaires Complets":   countCompleteForms(dataList),
-		"Formulaires Incomplets": countIncompleteForms(dataList),
-		"Total Provinces":        countUniqueProvincesForm(dataList),
-		"Total Aires":            countUniqueAreasForm(dataList),
-		"Total Sous-Aires":       countUniqueSubAreasForm(dataList),
-		"Prix Total":             calculateTotalPrice(dataList),
-		"Date de génération":     time.Now().Format("02/01/2006 15:04:05"),
-	}
-
-	// Add date filter information if filters are applied
-	if startDate != "" && endDate != "" {
-		summaryData["Période (Du - Au)"] = fmt.Sprintf("%s - %s", startDate, endDate)
-	} else if startDate != "" {
-		summaryData["Période (Depuis)"] = startDate
-	} else if endDate != "" {
-		summaryData["Période (Jusqu'au)"] = endDate
-	} else {
-		summaryData["Période"] = "Toutes les données"
-	}
-
-	err = utils.AddSummaryTable(f, sheetName, summaryData, 6, styles)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de l'ajout du résumé",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de l'ajout de l'en-tête du rapport", Err: err}
 	}
 
 	// Define headers for the main data table
@@ -1159,218 +1239,184 @@ func GeneratePosFormExcelReport(c *fiber.Ctx) error {
 		"Nombre d'Articles", "Statut", "Date Création", "Date Modification",
 	}
 
-	// Start data table after summary (row 15)
+	// Start data table after summary (row 15), or on its own sheet when
+	// streaming
+	dataSheet := sheetName
 	dataStartRow := 15
+	if opts.Stream {
+		dataSheet = "Données PosForm"
+		f.NewSheet(dataSheet)
+		dataStartRow = 1
+	}
+	rows, err := utils.NewSheetRowWriter(f, dataSheet, opts.Stream)
+	if err != nil {
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de la création de la feuille de données", Err: err}
+	}
+	rows.SetColWidth(1, len(headers), 15.0)
 
 	// Add main data table title
-	f.SetCellValue(sheetName, fmt.Sprintf("A%d", dataStartRow), "DONNÉES DÉTAILLÉES DES FORMULAIRES POS")
-	f.SetCellStyle(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow), styles["title"])
-	f.MergeCell(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow))
-	dataStartRow += 2
+	if !opts.Stream {
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", dataStartRow), "DONNÉES DÉTAILLÉES DES FORMULAIRES POS")
+		f.SetCellStyle(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow), styles["title"])
+		f.MergeCell(sheetName, fmt.Sprintf("A%d", dataStartRow), fmt.Sprintf("V%d", dataStartRow))
+		dataStartRow += 2
+	}
 
 	// Add headers
+	headerValues := make([]interface{}, len(headers))
+	headerStyles := make([]int, len(headers))
 	for i, header := range headers {
-		col := string(rune('A' + i))
-		if i >= 26 {
-			// Handle columns beyond Z (AA, AB, etc.)
-			col = string(rune('A'+(i/26-1))) + string(rune('A'+(i%26)))
-		}
-		cell := fmt.Sprintf("%s%d", col, dataStartRow)
-		f.SetCellValue(sheetName, cell, header)
-		f.SetCellStyle(sheetName, cell, cell, styles["header"])
+		headerValues[i] = header
+		headerStyles[i] = styles["header"]
 	}
+	rows.SetRow(dataStartRow, headerValues, headerStyles)
 
-	// Add data rows
-	for i, form := range dataList {
-		row := dataStartRow + 1 + i
-
-		// Convert status to readable format
-		statusText := "Incomplet"
-		if form.PosUUID != "" {
-			statusText = "Complet"
-		}
-
-		// Get related data safely
-		countryName := ""
-		if form.Country.Name != "" {
-			countryName = form.Country.Name
-		}
-
-		provinceName := ""
-		if form.Province.Name != "" {
-			provinceName = form.Province.Name
-		}
-
-		areaName := ""
-		if form.Area.Name != "" {
-			areaName = form.Area.Name
-		}
-
-		subAreaName := ""
-		if form.SubArea.Name != "" {
-			subAreaName = form.SubArea.Name
-		}
-
-		communeName := ""
-		if form.Commune.Name != "" {
-			communeName = form.Commune.Name
-		}
+	// Add data rows, batch by batch
+	summary := newPosFormSummary()
+	row := dataStartRow
+	err = utils.EachBatch(query.Select("pos_forms.*").
+		Preload("Country").
+		Preload("Province").
+		Preload("Area").
+		Preload("SubArea").
+		Preload("Commune").
+		Preload("User").
+		Preload("Pos").
+		Preload("PosFormItems"),
+		"pos_forms", 1000,
+		func(form *models.PosForm) (time.Time, string) { return form.UpdatedAt, form.UUID },
+		func(batch []models.PosForm) error {
+			for _, form := range batch {
+				row++
+				summary.add(&form)
+
+				// Convert status to readable format
+				statusText := "Incomplet"
+				statusStyle := styles["warning"]
+				if form.PosUUID != "" {
+					statusText = "Complet"
+					statusStyle = styles["success"]
+				}
 
-		userName := ""
-		if form.User.Fullname != "" {
-			userName = form.User.Fullname
-		}
+				posName := ""
+				posShop := ""
+				if form.Pos.Name != "" {
+					posName = form.Pos.Name
+					posShop = form.Pos.Shop
+				}
 
-		posName := ""
-		posShop := ""
-		if form.Pos.Name != "" {
-			posName = form.Pos.Name
-			posShop = form.Pos.Shop
-		}
+				// Data array
+				rowData := []interface{}{
+					form.UUID,
+					form.Price,
+					form.Comment,
+					form.Latitude,
+					form.Longitude,
+					form.Signature,
+					form.Country.Name,
+					form.Province.Name,
+					form.Area.Name,
+					form.SubArea.Name,
+					form.Commune.Name,
+					form.User.Fullname,
+					posName,
+					posShop,
+					form.Asm,
+					form.Sup,
+					form.Dr,
+					form.Cyclo,
+					len(form.PosFormItems),
+					statusText,
+					form.CreatedAt.Format("02/01/2006 15:04:05"),
+					form.UpdatedAt.Format("02/01/2006 15:04:05"),
+				}
 
-		// Count form items
-		itemCount := len(form.PosFormItems)
-
-		// Data array
-		rowData := []interface{}{
-			form.UUID,
-			form.Price,
-			form.Comment,
-			form.Latitude,
-			form.Longitude,
-			form.Signature,
-			countryName,
-			provinceName,
-			areaName,
-			subAreaName,
-			communeName,
-			userName,
-			posName,
-			posShop,
-			form.Asm,
-			form.Sup,
-			form.Dr,
-			form.Cyclo,
-			itemCount,
-			statusText,
-			form.CreatedAt.Format("02/01/2006 15:04:05"),
-			form.UpdatedAt.Format("02/01/2006 15:04:05"),
-		}
+				// Apply appropriate style based on data type
+				rowStyles := make([]int, len(rowData))
+				for j := range rowStyles {
+					rowStyles[j] = styles["data"]
+				}
+				rowStyles[1] = styles["number"]                               // Prix column
+				rowStyles[18] = styles["number"]                              // Nombre d'articles
+				rowStyles[19] = statusStyle                                   // Status column
+				rowStyles[20], rowStyles[21] = styles["date"], styles["date"] // Date columns
 
-		// Set data in cells
-		for j, data := range rowData {
-			col := string(rune('A' + j))
-			if j >= 26 {
-				col = string(rune('A'+(j/26-1))) + string(rune('A'+(j%26)))
-			}
-			cell := fmt.Sprintf("%s%d", col, row)
-			f.SetCellValue(sheetName, cell, data)
-
-			// Apply appropriate style based on data type
-			style := styles["data"]
-			if j == 1 { // Prix column
-				style = styles["number"]
-			} else if j == 19 { // Status column
-				if form.PosUUID != "" {
-					style = styles["success"]
-				} else {
-					style = styles["warning"]
+				if err := rows.SetRow(row, rowData, rowStyles); err != nil {
+					return err
 				}
-			} else if j == 20 || j == 21 { // Date columns
-				style = styles["date"]
-			} else if j == 18 { // Nombre d'articles
-				style = styles["number"]
 			}
-			f.SetCellStyle(sheetName, cell, cell, style)
-		}
+			opts.ReportProgress(row-dataStartRow, int(totalRecords))
+			return nil
+		})
+	if err == nil {
+		err = rows.Flush()
 	}
-
-	// Auto-fit columns
-	columns := []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L", "M", "N", "O", "P", "Q", "R", "S", "T", "U", "V"}
-	err = utils.AutoFitColumns(f, sheetName, columns, 15.0)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de l'ajustement des colonnes",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Échec de la récupération des données PosForm pour le rapport Excel", Err: err}
 	}
 
-	// Generate filename with timestamp
-	filename := fmt.Sprintf("rapport_posform_%s.xlsx", time.Now().Format("2006-01-02_15-04-05"))
+	// Add summary statistics
+	summaryData := map[string]interface{}{
+		"Total des Formulaires":  totalRecords,
+		"Formulaires Complets":   summary.complete,
+		"Formulaires Incomplets": summary.incomplete,
+		"Total Provinces":        len(summary.provinces),
+		"Total Aires":            len(summary.areas),
+		"Total Sous-Aires":       len(summary.subAreas),
+		"Prix Total":             summary.totalPrice,
+		"Date de génération":     time.Now().Format("02/01/2006 15:04:05"),
+	}
 
-	// Set response headers for file download
-	c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	// Add date filter information if filters are applied
+	if startDate != "" && endDate != "" {
+		summaryData["Période (Du - Au)"] = fmt.Sprintf("%s - %s", startDate, endDate)
+	} else if startDate != "" {
+		summaryData["Période (Depuis)"] = startDate
+	} else if endDate != "" {
+		summaryData["Période (Jusqu'au)"] = endDate
+	} else {
+		summaryData["Période"] = "Toutes les données"
+	}
 
-	// Write file to response
-	buffer, err := f.WriteToBuffer()
+	err = utils.AddSummaryTable(f, sheetName, summaryData, 6, styles)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Erreur lors de la génération du fichier Excel",
-			"error":   err.Error(),
-		})
+		f.Close()
+		return nil, "", &utils.ReportError{Status: 500, Message: "Erreur lors de l'ajout du résumé", Err: err}
 	}
 
-	return c.Send(buffer.Bytes())
-}
+	// Generate filename with timestamp
+	filename := fmt.Sprintf("rapport_posform_%s.xlsx", time.Now().Format("2006-01-02_15-04-05"))
 
-// Helper functions for summary statistics
-func countCompleteForms(formList []models.PosForm) int {
-	count := 0
-	for _, form := range formList {
-		if form.PosUUID != "" {
-			count++
-		}
-	}
-	return count
+	return f, filename, nil
 }
 
-func countIncompleteForms(formList []models.PosForm) int {
-	count := 0
-	for _, form := range formList {
-		if form.PosUUID == "" {
-			count++
-		}
-	}
-	return count
+// posFormSummary counts the forms of a report as its rows are written.
+type posFormSummary struct {
+	complete, incomplete       int
+	totalPrice                 int
+	provinces, areas, subAreas map[string]bool
 }
 
-func countUniqueProvincesForm(formList []models.PosForm) int {
-	provinces := make(map[string]bool)
-	for _, form := range formList {
-		if form.Province.Name != "" {
-			provinces[form.Province.Name] = true
-		}
-	}
-	return len(provinces)
+func newPosFormSummary() *posFormSummary {
+	return &posFormSummary{provinces: map[string]bool{}, areas: map[string]bool{}, subAreas: map[string]bool{}}
 }
 
-func countUniqueAreasForm(formList []models.PosForm) int {
-	areas := make(map[string]bool)
-	for _, form := range formList {
-		if form.Area.Name != "" {
-			areas[form.Area.Name] = true
-		}
+func (s *posFormSummary) add(form *models.PosForm) {
+	if form.PosUUID != "" {
+		s.complete++
+	} else {
+		s.incomplete++
 	}
-	return len(areas)
-}
-
-func countUniqueSubAreasForm(formList []models.PosForm) int {
-	subAreas := make(map[string]bool)
-	for _, form := range formList {
-		if form.SubArea.Name != "" {
-			subAreas[form.SubArea.Name] = true
-		}
+	s.totalPrice += form.Price
+	if form.Province.Name != "" {
+		s.provinces[form.Province.Name] = true
 	}
-	return len(subAreas)
-}
-
-func calculateTotalPrice(formList []models.PosForm) int {
-	total := 0
-	for _, form := range formList {
-		total += form.Price
+	if form.Area.Name != "" {
+		s.areas[form.Area.Name] = true
+	}
+	if form.SubArea.Name != "" {
+		s.subAreas[form.SubArea.Name] = true
 	}
-	return total
 }
@@ -0,0 +1,175 @@
+package posform
+
+import (
+	"fmt"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// visitError is one validation problem of a visit submission.
+// Index is the position of the brand line, -1 for the form itself.
+type visitError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SubmitVisit creates a PosForm together with all its PosFormItems in one
+// transaction. Nothing is written unless the form and every brand line are
+// valid:
+//   - the POS exists and is inside the caller's territory
+//   - at least one brand line, no brand repeated
+//   - every brand belongs to the POS's province
+//   - number_farde and sold are not negative
+//   - a shelf price is not negative, in a known unit and currency
+//
+// POST /api/posforms/submit
+func SubmitVisit(c *fiber.Ctx) error {
+	db := database.DB
+
+	var body models.VisitSubmission
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	user := middlewares.CurrentUser(c)
+	errs := []visitError{}
+
+	// ── Form ──────────────────────────────────────────────────────────────
+	var pos models.Pos
+	if body.PosUUID == "" {
+		errs = append(errs, visitError{Index: -1, Field: "pos_uuid", Message: "pos_uuid is required"})
+	} else if err := db.Where("uuid = ?", body.PosUUID).First(&pos).Error; err != nil {
+		errs = append(errs, visitError{Index: -1, Field: "pos_uuid", Message: "unknown pos"})
+	} else if !middlewares.Scope(c).Contains(pos.CountryUUID, pos.ProvinceUUID, pos.AreaUUID, pos.SubAreaUUID, pos.CommuneUUID) {
+		errs = append(errs, visitError{Index: -1, Field: "pos_uuid", Message: "pos is outside of your territory"})
+	}
+	if body.Price < 0 {
+		errs = append(errs, visitError{Index: -1, Field: "price", Message: "price must not be negative"})
+	}
+	if err := utils.NormalizeFormCurrency(&body.PosForm); err != nil {
+		errs = append(errs, visitError{Index: -1, Field: "currency", Message: err.Error()})
+	}
+	if err := utils.ApplyVisitTiming(&body.PosForm); err != nil {
+		errs = append(errs, visitError{Index: -1, Field: "check_out_at", Message: err.Error()})
+	}
+	if len(body.Items) == 0 {
+		errs = append(errs, visitError{Index: -1, Field: "items", Message: "at least one brand line is required"})
+	}
+
+	// ── Brand lines ───────────────────────────────────────────────────────
+	brandUUIDs := make([]string, 0, len(body.Items))
+	for _, it := range body.Items {
+		brandUUIDs = append(brandUUIDs, it.BrandUUID)
+	}
+	var brands []models.Brand
+	db.Where("uuid IN ?", brandUUIDs).Find(&brands)
+	brandByUUID := make(map[string]models.Brand, len(brands))
+	for _, b := range brands {
+		brandByUUID[b.UUID] = b
+	}
+
+	currency := body.Currency
+	if currency == "" {
+		currency = utils.CountryCurrency(db, pos.CountryUUID)
+	}
+	seen := make(map[string]int, len(body.Items))
+	for i, it := range body.Items {
+		brand, ok := brandByUUID[it.BrandUUID]
+		switch {
+		case it.BrandUUID == "":
+			errs = append(errs, visitError{Index: i, Field: "brand_uuid", Message: "brand_uuid is required"})
+		case !ok:
+			errs = append(errs, visitError{Index: i, Field: "brand_uuid", Message: "unknown brand"})
+		case pos.UUID != "" && brand.ProvinceUUID != pos.ProvinceUUID:
+			errs = append(errs, visitError{Index: i, Field: "brand_uuid", Message: "brand " + brand.Name + " is not sold in the pos province"})
+		}
+		if first, dup := seen[it.BrandUUID]; dup && it.BrandUUID != "" {
+			errs = append(errs, visitError{Index: i, Field: "brand_uuid", Message: fmt.Sprintf("duplicate of line %d", first)})
+		} else {
+			seen[it.BrandUUID] = i
+		}
+		if it.NumberFarde < 0 {
+			errs = append(errs, visitError{Index: i, Field: "number_farde", Message: "number_farde must not be negative"})
+		}
+		if it.Sold < 0 {
+			errs = append(errs, visitError{Index: i, Field: "sold", Message: "sold must not be negative"})
+		}
+		if field, err := utils.NormalizeItemPrice(&body.Items[i], currency); err != nil {
+			errs = append(errs, visitError{Index: i, Field: field, Message: err.Error()})
+		}
+		if err := utils.CheckItemSku(db, &body.Items[i]); err != nil {
+			errs = append(errs, visitError{Index: i, Field: "sku_uuid", Message: err.Error()})
+		}
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Visit rejected, nothing was saved",
+			"errors":  errs,
+		})
+	}
+
+	// ── Persist ───────────────────────────────────────────────────────────
+	form := body.PosForm
+	if form.UUID == "" {
+		form.UUID = uuid.New().String()
+	}
+	form.UserUUID = user.UUID
+
+	// Territory and hierarchy always follow the POS
+	utils.PlaceVisit(&form, &pos)
+
+	items := body.Items
+	for i := range items {
+		if items[i].UUID == "" {
+			items[i].UUID = uuid.New().String()
+		}
+		items[i].PosFormUUID = form.UUID
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := utils.ApplyGeofence(tx, &form); err != nil {
+			return err
+		}
+		if err := utils.MatchRoutePlan(tx, &form); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Create(&form).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Create(&items).Error; err != nil {
+			return err
+		}
+		return utils.RefreshDailyFacts(tx, &form)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to save visit",
+			"error":   err.Error(),
+		})
+	}
+
+	utils.InvalidateCachedResponses(&form)
+	utils.NotifyObservations(db, &form)
+	form.PosFormItems = items
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "visit submitted success",
+		"data":    form,
+	})
+}
@@ -0,0 +1,134 @@
+package posform
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// noRows is a database/sql connector where nothing exists: queries return
+// no row. It records the statements, so that a test can check nothing was
+// written.
+type noRows struct{ statements *[]string }
+
+func (n noRows) Connect(context.Context) (driver.Conn, error) { return n, nil }
+func (noRows) Driver() driver.Driver                          { return nil }
+func (n noRows) Prepare(query string) (driver.Stmt, error) {
+	*n.statements = append(*n.statements, query)
+	return n, nil
+}
+func (noRows) Close() error                                { return nil }
+func (n noRows) Begin() (driver.Tx, error)                 { return n, nil }
+func (noRows) Commit() error                               { return nil }
+func (noRows) Rollback() error                             { return nil }
+func (noRows) NumInput() int                               { return -1 }
+func (noRows) Exec([]driver.Value) (driver.Result, error)  { return driver.RowsAffected(1), nil }
+func (n noRows) Query([]driver.Value) (driver.Rows, error) { return n, nil }
+func (noRows) Columns() []string                           { return nil }
+func (noRows) Next([]driver.Value) error                   { return io.EOF }
+
+// submit posts body to SubmitVisit over an empty database and returns the
+// status, the errors of the response and the statements run.
+func submit(t *testing.T, body string) (int, []visitError, []string) {
+	t.Helper()
+	var statements []string
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(noRows{&statements})}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = saved })
+
+	app := fiber.New()
+	app.Post("/submit", SubmitVisit)
+	req := httptest.NewRequest("POST", "/submit", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, int(time.Minute/time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Errors []visitError `json:"errors"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out.Errors, statements
+}
+
+func TestSubmitVisitRejectsTheWholeVisit(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string // index:field of the errors
+	}{
+		{
+			"no pos and no line",
+			`{"price": 0, "items": []}`,
+			[]string{"-1:items", "-1:pos_uuid"},
+		},
+		{
+			"unknown pos, negative price and check-out before check-in",
+			`{"pos_uuid": "p1", "price": -5,
+			  "check_in_at": "2026-10-16T10:00:00Z", "check_out_at": "2026-10-16T09:00:00Z",
+			  "items": [{"brand_uuid": "b1", "number_farde": 2}]}`,
+			[]string{"-1:check_out_at", "-1:pos_uuid", "-1:price", "0:brand_uuid"},
+		},
+		{
+			"bad lines",
+			`{"pos_uuid": "p1", "items": [
+			  {"brand_uuid": "", "number_farde": -1},
+			  {"brand_uuid": "b1", "sold": -2},
+			  {"brand_uuid": "b1", "price": 100, "price_unit": "crate"}]}`,
+			[]string{
+				"-1:pos_uuid",
+				"0:brand_uuid", "0:number_farde",
+				"1:brand_uuid", "1:sold",
+				"2:brand_uuid", "2:brand_uuid", "2:price_unit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, errs, statements := submit(t, tt.body)
+			if status != fiber.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d", status, fiber.StatusUnprocessableEntity)
+			}
+			got := make([]string, 0, len(errs))
+			for _, e := range errs {
+				got = append(got, strconv.Itoa(e.Index)+":"+e.Field)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("errors = %v, want %v", got, tt.want)
+			}
+			for _, sql := range statements {
+				if !strings.HasPrefix(sql, "SELECT") {
+					t.Errorf("%s run for a rejected visit", sql)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitVisitBadJSON(t *testing.T) {
+	if status, _, statements := submit(t, `{"items": "none"}`); status != fiber.StatusBadRequest || len(statements) != 0 {
+		t.Errorf("status = %d after %d statements, want %d before any", status, len(statements), fiber.StatusBadRequest)
+	}
+}
@@ -0,0 +1,125 @@
+package posform
+
+import (
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// visitEvent is the body of a check-in or check-out. At defaults to the
+// server time so online clients can omit it; offline clients send the
+// time the event happened on the device.
+type visitEvent struct {
+	At        *time.Time `json:"at"`
+	Latitude  float64    `json:"latitude"`
+	Longitude float64    `json:"longitude"`
+}
+
+// CheckInVisit records the arrival of the agent at the POS of a visit.
+// Agents only time their own visits.
+//
+// PUT /api/posforms/check-in/:uuid
+func CheckInVisit(c *fiber.Ctx) error {
+	return recordVisitEvent(c, func(form *models.PosForm, ev visitEvent) {
+		form.CheckInAt = ev.At
+		form.CheckInLatitude = ev.Latitude
+		form.CheckInLongitude = ev.Longitude
+	})
+}
+
+// CheckOutVisit records the departure of the agent and computes the time
+// on site.
+//
+// PUT /api/posforms/check-out/:uuid
+func CheckOutVisit(c *fiber.Ctx) error {
+	return recordVisitEvent(c, func(form *models.PosForm, ev visitEvent) {
+		form.CheckOutAt = ev.At
+		form.CheckOutLatitude = ev.Latitude
+		form.CheckOutLongitude = ev.Longitude
+	})
+}
+
+func recordVisitEvent(c *fiber.Ctx, apply func(*models.PosForm, visitEvent)) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var ev visitEvent
+	if err := c.BodyParser(&ev); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if ev.At == nil {
+		now := time.Now()
+		ev.At = &now
+	}
+
+	var form models.PosForm
+	if err := db.Where("uuid = ?", uuid).First(&form).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No posform found",
+			"data":    nil,
+		})
+	}
+	if !middlewares.Scope(c).Contains(form.CountryUUID, form.ProvinceUUID, form.AreaUUID, form.SubAreaUUID, form.CommuneUUID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Visit is outside of your territory",
+		})
+	}
+	if form.UserUUID != middlewares.CurrentUser(c).UUID {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Visit of another agent",
+		})
+	}
+
+	before := form
+	apply(&form, ev)
+	if err := utils.ApplyVisitTiming(&form); err != nil {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid check-in / check-out",
+			"error":   err.Error(),
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// A check-in may move the visit to another day of its route plans
+		if err := utils.RematchRoutePlan(tx, &before, &form); err != nil {
+			return err
+		}
+		return tx.Model(&form).Updates(map[string]interface{}{
+			"check_in_at":         form.CheckInAt,
+			"check_in_latitude":   form.CheckInLatitude,
+			"check_in_longitude":  form.CheckInLongitude,
+			"check_out_at":        form.CheckOutAt,
+			"check_out_latitude":  form.CheckOutLatitude,
+			"check_out_longitude": form.CheckOutLongitude,
+			"duration_seconds":    form.DurationSeconds,
+			"route_plan_uuid":     form.RoutePlanUUID,
+		}).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to record visit event",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(&before, &form)
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "visit event recorded",
+		"data":    form,
+	})
+}
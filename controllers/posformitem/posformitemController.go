@@ -6,9 +6,22 @@ import (
 
 	"github.com/danny19977/mspos-api-v3/database"
 	"github.com/danny19977/mspos-api-v3/models" 
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
+// refreshFacts recomputes the dashboard daily facts of the visits of
+// changed lines, in the transaction of the change. It returns the visits,
+// whose cached responses are dropped once committed.
+func refreshFacts(tx *gorm.DB, formUUIDs ...string) ([]*models.PosForm, error) {
+	forms, err := utils.DailyFactForms(tx, formUUIDs)
+	if err != nil {
+		return nil, err
+	}
+	return forms, utils.RefreshDailyFacts(tx, forms...)
+}
+
 // Paginate
 func GetPaginatedPosformItem(c *fiber.Ctx) error {
 	db := database.DB
@@ -117,8 +130,39 @@ func CreatePosformItem(c *fiber.Ctx) error {
 		return err
 	}
 
+	if _, err := utils.NormalizeItemPrice(p, utils.VisitCurrencyOf(database.DB, p.PosFormUUID)); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid price",
+			"error":   err.Error(),
+		})
+	}
+	if err := utils.CheckItemSku(database.DB, p); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid SKU",
+			"error":   err.Error(),
+		})
+	}
+
 	// p.UUID = utils.GenerateUUID()
-	database.DB.Create(p)
+	var forms []*models.PosForm
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+		var err error
+		forms, err = refreshFacts(tx, p.PosFormUUID)
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create posformitem",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(forms...)
 
 	return c.JSON(
 		fiber.Map{
@@ -142,7 +186,11 @@ func UpdatePosformItem(c *fiber.Ctx) error {
 		Counter     int     `gorm:"not null" json:"counter"`                        // Allows to calculate the Sum of the ND Dashboard
 		PosFormUUID string  `json:"posform_uuid" gorm:"type:varchar(255);not null"` // Foreign key (belongs to), tag `index` will create index for this column
 		BrandUUID   string  `json:"brand_id" gorm:"type:varchar(255);not null"`     // Foreign key (belongs to), tag `index` will create index for this column
+		SkuUUID     string  `json:"sku_uuid"`
 		PosUUID     string  `json:"pos_uuid" gorm:"type:varchar(255);not null"`     // Foreign key (belongs to), tag `index` will create index for this column
+		Price       float64 `json:"price"`
+		PriceUnit   string  `json:"price_unit"`
+		Currency    string  `json:"currency"`
 		// Foreign key (belongs to), tag `index` will create index for this column
 	}
 
@@ -161,12 +209,47 @@ func UpdatePosformItem(c *fiber.Ctx) error {
 	posFormItem := new(models.PosFormItems)
 
 	db.Where("uuid = ?", uuid).First(&posFormItem)
+	oldPosFormUUID := posFormItem.PosFormUUID
 	posFormItem.Sold = updateData.Sold
 	posFormItem.NumberFarde = updateData.NumberFarde
 	posFormItem.PosFormUUID = updateData.PosFormUUID
 	posFormItem.BrandUUID = updateData.BrandUUID
+	posFormItem.SkuUUID = updateData.SkuUUID
+	posFormItem.Price = updateData.Price
+	posFormItem.PriceUnit = updateData.PriceUnit
+	posFormItem.Currency = updateData.Currency
+	if _, err := utils.NormalizeItemPrice(posFormItem, utils.VisitCurrencyOf(db, posFormItem.PosFormUUID)); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid price",
+			"error":   err.Error(),
+		})
+	}
+	if err := utils.CheckItemSku(db, posFormItem); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid SKU",
+			"error":   err.Error(),
+		})
+	}
 
-	db.Save(&posFormItem)
+	var forms []*models.PosForm
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&posFormItem).Error; err != nil {
+			return err
+		}
+		var err error
+		forms, err = refreshFacts(tx, oldPosFormUUID, posFormItem.PosFormUUID)
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update stock",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(forms...)
 
 	return c.JSON(
 		fiber.Map{
@@ -196,7 +279,23 @@ func DeletePosformItem(c *fiber.Ctx) error {
 		)
 	}
 
-	db.Delete(&posFormItems)
+	var forms []*models.PosForm
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&posFormItems).Error; err != nil {
+			return err
+		}
+		var err error
+		forms, err = refreshFacts(tx, posFormItems.PosFormUUID)
+		return err
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete stock",
+			"error":   err.Error(),
+		})
+	}
+	utils.InvalidateCachedResponses(forms...)
 
 	return c.JSON(
 		fiber.Map{
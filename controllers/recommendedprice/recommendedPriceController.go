@@ -0,0 +1,311 @@
+package recommendedprice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// priceInput is a recommended price as sent by the client, with its dates
+// as "2006-01-02".
+type priceInput struct {
+	BrandUUID    string  `json:"brand_uuid"`
+	Price        float64 `json:"price"`
+	PriceUnit    string  `json:"price_unit"`
+	Currency     string  `json:"currency"`
+	CountryUUID  string  `json:"country_uuid"`
+	ProvinceUUID string  `json:"province_uuid"`
+	AreaUUID     string  `json:"area_uuid"`
+	SubAreaUUID  string  `json:"sub_area_uuid"`
+	CommuneUUID  string  `json:"commune_uuid"`
+	StartDate    string  `json:"start_date"`
+	EndDate      string  `json:"end_date"`
+	Signature    string  `json:"signature"`
+}
+
+// apply validates the input and copies it to p.
+func (in *priceInput) apply(p *models.RecommendedPrice) error {
+	brandUUID := strings.TrimSpace(in.BrandUUID)
+	if brandUUID == "" {
+		return fmt.Errorf("brand_uuid is required")
+	}
+	var count int64
+	database.DB.Model(&models.Brand{}).Where("uuid = ?", brandUUID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("unknown brand_uuid")
+	}
+	if in.Price <= 0 {
+		return fmt.Errorf("price must be positive")
+	}
+	unit, err := utils.NormalizePriceUnit(in.PriceUnit)
+	if err != nil {
+		return err
+	}
+	currency, err := utils.NormalizeCurrency(in.Currency)
+	if err != nil {
+		return err
+	}
+	start, err := utils.ParseDay(strings.TrimSpace(in.StartDate))
+	if err != nil {
+		return fmt.Errorf("start_date must be a date (YYYY-MM-DD)")
+	}
+	var end *time.Time
+	if s := strings.TrimSpace(in.EndDate); s != "" {
+		e, err := utils.ParseDay(s)
+		if err != nil {
+			return fmt.Errorf("end_date must be a date (YYYY-MM-DD)")
+		}
+		if e.Before(start) {
+			return fmt.Errorf("end_date is before start_date")
+		}
+		end = &e
+	}
+
+	p.BrandUUID = brandUUID
+	p.Price = in.Price
+	p.PriceUnit = unit
+	p.Currency = currency
+	p.CountryUUID = strings.TrimSpace(in.CountryUUID)
+	p.ProvinceUUID = strings.TrimSpace(in.ProvinceUUID)
+	p.AreaUUID = strings.TrimSpace(in.AreaUUID)
+	p.SubAreaUUID = strings.TrimSpace(in.SubAreaUUID)
+	p.CommuneUUID = strings.TrimSpace(in.CommuneUUID)
+	p.StartDate = start
+	p.EndDate = end
+	p.Signature = in.Signature
+	return nil
+}
+
+// invalidateDashboards drops the cached dashboard responses, whose
+// recommended prices may have changed.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedRecommendedPrices(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := db.Model(&models.RecommendedPrice{})
+	for _, param := range []string{"brand_uuid", "price_unit", "currency", "country_uuid",
+		"province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid"} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(param+" = ?", v)
+		}
+	}
+	if date := c.Query("date"); date != "" {
+		query = query.Where("start_date <= ?", date).
+			Where("end_date IS NULL OR end_date >= ?", date)
+	}
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var dataList []models.RecommendedPrice
+	err = query.
+		Preload("Brand").
+		Offset(offset).
+		Limit(limit).
+		Order("brand_uuid, start_date DESC, updated_at DESC").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch recommended prices",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "recommended prices retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data
+func GetAllRecommendedPrices(c *fiber.Ctx) error {
+	db := database.DB
+
+	query := db.Preload("Brand").Order("brand_uuid, start_date DESC, updated_at DESC")
+	if brandUUID := c.Query("brand_uuid"); brandUUID != "" {
+		query = query.Where("brand_uuid = ?", brandUUID)
+	}
+
+	var data []models.RecommendedPrice
+	query.Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All recommended prices",
+		"data":    data,
+	})
+}
+
+// Get one data
+func GetOneRecommendedPrice(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var price models.RecommendedPrice
+	db.Preload("Brand").Where("uuid = ?", uuid).First(&price)
+	if price.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No recommended price found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "recommended price found",
+			"data":    price,
+		},
+	)
+}
+
+// Create data
+func CreateRecommendedPrice(c *fiber.Ctx) error {
+	var input priceInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	p := &models.RecommendedPrice{UUID: uuid.New().String()}
+	if err := input.apply(p); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid recommended price",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := database.DB.Omit("Brand").Create(p).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create recommended price",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "recommended price created success",
+			"data":    p,
+		},
+	)
+}
+
+// Update data
+func UpdateRecommendedPrice(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input priceInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	var price models.RecommendedPrice
+	db.Where("uuid = ?", uuid).First(&price)
+	if price.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No recommended price found",
+			"data":    nil,
+		})
+	}
+
+	if err := input.apply(&price); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid recommended price",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := db.Omit("Brand").Save(&price).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update recommended price",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "recommended price updated success",
+			"data":    price,
+		},
+	)
+}
+
+// Delete data
+func DeleteRecommendedPrice(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var price models.RecommendedPrice
+	db.Where("uuid = ?", uuid).First(&price)
+	if price.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No recommended price found",
+				"data":    nil,
+			},
+		)
+	}
+
+	db.Delete(&price)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "recommended price deleted success",
+			"data":    nil,
+		},
+	)
+}
@@ -0,0 +1,295 @@
+package routeplan
+
+import (
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GenerateRequest is the body of POST /routeplans/generate.
+type GenerateRequest struct {
+	UserUUID    string                `json:"user_uuid"`
+	StartDate   string                `json:"start_date"`   // YYYY-MM-DD
+	EndDate     string                `json:"end_date"`     // YYYY-MM-DD
+	WorkingDays []int                 `json:"working_days"` // 0 = Sunday … 6 = Saturday, default Monday to Saturday
+	MaxPerDay   int                   `json:"max_per_day"`  // 0 = no cap, load is still balanced
+	Rules       []utils.FrequencyRule `json:"rules"`        // By POS type and segment (A, B or C)
+}
+
+// GenerateRouteplan builds draft route plans, one per working day, for the
+// POS of the user's territory according to the visit frequency rules, A
+// POS coming first when days are full. The drafts are not sent to the agent until they are published.
+// They replace the drafts of the user on the working days of the period;
+// published plans are kept.
+func GenerateRouteplan(c *fiber.Ctx) error {
+	db := database.DB
+
+	var req GenerateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	for i := range req.Rules {
+		if strings.TrimSpace(req.Rules[i].Segment) == "*" {
+			continue
+		}
+		segment, err := utils.NormalizeSegment(req.Rules[i].Segment)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid frequency rule",
+				"error":   err.Error(),
+			})
+		}
+		req.Rules[i].Segment = segment
+	}
+
+	start, errStart := time.ParseInLocation("2006-01-02", req.StartDate, time.Local)
+	end, errEnd := time.ParseInLocation("2006-01-02", req.EndDate, time.Local)
+	if errStart != nil || errEnd != nil || end.Before(start) {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "start_date and end_date are required (YYYY-MM-DD), end_date not before start_date",
+		})
+	}
+	if end.Sub(start) > 92*24*time.Hour {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The period can not exceed 3 months",
+		})
+	}
+
+	var agent models.User
+	if err := db.Where("uuid = ?", req.UserUUID).First(&agent).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No user found",
+			"data":    nil,
+		})
+	}
+	if !middlewares.Scope(c).Contains(agent.CountryUUID, agent.ProvinceUUID, agent.AreaUUID, agent.SubAreaUUID, agent.CommuneUUID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User is outside of your territory",
+		})
+	}
+	agentScope := utils.ResolveScope(&agent)
+	if agentScope.Denied || agentScope.Unrestricted() {
+		return c.Status(422).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Route plans can only be generated for a field user with a territory",
+		})
+	}
+
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	if len(req.WorkingDays) > 0 {
+		weekdays = weekdays[:0]
+		for _, d := range req.WorkingDays {
+			if d >= 0 && d <= 6 {
+				weekdays = append(weekdays, time.Weekday(d))
+			}
+		}
+	}
+	days := utils.WorkingDays(start, end, weekdays)
+
+	// POS universe of the user's territory
+	var posList []models.Pos
+	if err := utils.ApplyScope(db, agentScope, "pos").
+		Where("pos.status = ?", true).
+		Find(&posList).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch POS",
+			"error":   err.Error(),
+		})
+	}
+
+	// Last visit of each POS, by anyone
+	posUUIDs := make([]string, 0, len(posList))
+	for _, p := range posList {
+		posUUIDs = append(posUUIDs, p.UUID)
+	}
+	var visits []struct {
+		PosUUID   string
+		LastVisit time.Time
+	}
+	if len(posUUIDs) > 0 {
+		if err := db.Model(&models.PosForm{}).
+			Select("pos_uuid, MAX(created_at) AS last_visit").
+			Where("pos_uuid IN ?", posUUIDs).
+			Group("pos_uuid").
+			Scan(&visits).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Failed to fetch last visits",
+				"error":   err.Error(),
+			})
+		}
+	}
+	lastVisit := make(map[string]time.Time, len(visits))
+	for _, v := range visits {
+		lastVisit[v.PosUUID] = v.LastVisit.In(time.Local)
+	}
+
+	planned, unscheduled := utils.GenerateRoutePlan(posList, lastVisit, days, req.Rules, req.MaxPerDay)
+
+	// One draft plan per working day that got visits
+	signature := middlewares.CurrentUser(c).Fullname
+	plans := make([]models.RoutePlan, 0, len(days))
+	planByDay := make(map[time.Time]int, len(days))
+	for _, v := range planned {
+		i, ok := planByDay[v.Day]
+		if !ok {
+			day := v.Day
+			plans = append(plans, models.RoutePlan{
+				UUID:         utils.GenerateUUID(),
+				UserUUID:     agent.UUID,
+				CountryUUID:  agent.CountryUUID,
+				ProvinceUUID: agent.ProvinceUUID,
+				AreaUUID:     agent.AreaUUID,
+				SubAreaUUID:  agent.SubAreaUUID,
+				CommuneUUID:  agent.CommuneUUID,
+				PlanDate:     &day,
+				Status:       models.RoutePlanDraft,
+				Signature:    signature,
+			})
+			i = len(plans) - 1
+			planByDay[v.Day] = i
+		}
+		plans[i].RoutePlanItems = append(plans[i].RoutePlanItems, models.RoutePlanItem{
+			UUID:          utils.GenerateUUID(),
+			RoutePlanUUID: plans[i].UUID,
+			PosUUID:       v.PosUUID,
+		})
+	}
+
+	// Each day's stops in visiting order
+	posByUUID := make(map[string]models.Pos, len(posList))
+	for _, p := range posList {
+		posByUUID[p.UUID] = p
+	}
+	for i := range plans {
+		orderStops(nil, plans[i].RoutePlanItems, posByUUID)
+	}
+
+	dates := make([]string, 0, len(days))
+	for _, d := range days {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+
+	var replaced []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// One generation at a time per agent, so that two of the same days
+		// do not both find no draft to replace
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "routeplan-generate:"+agent.UUID).Error; err != nil {
+			return err
+		}
+		if len(dates) > 0 {
+			if err := tx.Model(&models.RoutePlan{}).
+				Where("user_uuid = ? AND status = ? AND plan_date IN ?", agent.UUID, models.RoutePlanDraft, dates).
+				Pluck("uuid", &replaced).Error; err != nil {
+				return err
+			}
+		}
+		if len(replaced) > 0 {
+			if err := tx.Where("route_plan_uuid IN ?", replaced).Delete(&models.RoutePlanItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("uuid IN ?", replaced).Delete(&models.RoutePlan{}).Error; err != nil {
+				return err
+			}
+		}
+
+		for i := range plans {
+			if err := tx.Omit(clause.Associations).Create(&plans[i]).Error; err != nil {
+				return err
+			}
+			if err := tx.Omit(clause.Associations).Create(&plans[i].RoutePlanItems).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to save draft route plans",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Draft route plans generated",
+		"data": fiber.Map{
+			"route_plans":  plans,
+			"total_pos":    len(posList),
+			"total_visits": len(planned),
+			"unscheduled":  unscheduled,
+			"replaced":     len(replaced),
+		},
+	})
+}
+
+// PublishRouteplan makes a draft route plan and its items visible to its
+// agent.
+func PublishRouteplan(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var routeplan models.RoutePlan
+	db.Where("uuid = ?", uuid).First(&routeplan)
+	if routeplan.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No routeplan found",
+			"data":    nil,
+		})
+	}
+
+	if !middlewares.Scope(c).Contains(routeplan.CountryUUID, routeplan.ProvinceUUID, routeplan.AreaUUID, routeplan.SubAreaUUID, routeplan.CommuneUUID) {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Route plan is outside of your territory",
+		})
+	}
+
+	wasDraft := routeplan.Status == models.RoutePlanDraft
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&routeplan).Update("status", models.RoutePlanPublished).Error; err != nil {
+			return err
+		}
+		// The items are pulled by the agent from their own updated_at
+		return tx.Model(&models.RoutePlanItem{}).
+			Where("route_plan_uuid = ?", routeplan.UUID).
+			UpdateColumn("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to publish routeplan",
+			"error":   err.Error(),
+		})
+	}
+	if wasDraft {
+		routeplan.Status = models.RoutePlanPublished
+		utils.NotifyRoutePlan(db, &routeplan)
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "RoutePlan published success",
+		"data":    routeplan,
+	})
+}
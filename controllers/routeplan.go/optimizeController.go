@@ -0,0 +1,178 @@
+package routeplan
+
+import (
+	"math"
+	"slices"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// supervisingRoles may reorder the route plans of the agents of their
+// territory; the other roles only their own.
+var supervisingRoles = []string{middlewares.RoleSupport, middlewares.RoleManager, middlewares.RoleASM, middlewares.RoleSupervisor}
+
+// routeStop is one ordered stop of an optimized route plan.
+type routeStop struct {
+	Sequence    int     `json:"sequence"`
+	ItemUUID    string  `json:"item_uuid"`
+	PosUUID     string  `json:"pos_uuid"`
+	PosName     string  `json:"pos_name"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	LegDistance float64 `json:"leg_distance"` // Meters from the previous stop (or the start)
+}
+
+// orderStops sets the Sequence of the items to a short visiting order from
+// start. Items whose POS has no location go last, in their current order.
+// It returns the located stops in order, the uuids of the unlocated items
+// and the total distance in meters.
+func orderStops(start *utils.GeoPoint, items []models.RoutePlanItem, posByUUID map[string]models.Pos) ([]routeStop, []string, float64) {
+	var located []int
+	var points []utils.GeoPoint
+	unlocated := []string{}
+	for i, it := range items {
+		pos := posByUUID[it.PosUUID]
+		if utils.PosHasLocation(&pos) {
+			located = append(located, i)
+			points = append(points, utils.GeoPoint{Lat: pos.Latitude, Lng: pos.Longitude})
+		}
+	}
+
+	order, legs, total := utils.OptimizeRoute(start, points)
+
+	stops := make([]routeStop, 0, len(order))
+	for n, o := range order {
+		it := &items[located[o]]
+		it.Sequence = n + 1
+		pos := posByUUID[it.PosUUID]
+		stops = append(stops, routeStop{
+			Sequence:    it.Sequence,
+			ItemUUID:    it.UUID,
+			PosUUID:     it.PosUUID,
+			PosName:     pos.Name,
+			Latitude:    pos.Latitude,
+			Longitude:   pos.Longitude,
+			LegDistance: legs[n],
+		})
+	}
+
+	next := len(order) + 1
+	for i := range items {
+		pos := posByUUID[items[i].PosUUID]
+		if !utils.PosHasLocation(&pos) {
+			items[i].Sequence = next
+			next++
+			unlocated = append(unlocated, items[i].UUID)
+		}
+	}
+
+	return stops, unlocated, total
+}
+
+// OptimizeRouteplan orders the items of a route plan into an efficient
+// visiting sequence using straight-line distances between the POS. The
+// route starts at the latitude/longitude of the body when given, else at
+// the agent's last GPS fix, else at the best first stop. Agents reorder
+// their own plans, supervisors those of their territory.
+func OptimizeRouteplan(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var body struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Review your input",
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	var routeplan models.RoutePlan
+	db.Where("uuid = ?", uuid).
+		Preload("RoutePlanItems", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sequence, created_at")
+		}).
+		Preload("RoutePlanItems.Pos").
+		First(&routeplan)
+	if routeplan.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No routeplan found",
+			"data":    nil,
+		})
+	}
+	user := middlewares.CurrentUser(c)
+	if routeplan.UserUUID != user.UUID {
+		if !slices.Contains(supervisingRoles, middlewares.NormalizeRole(user.Role)) ||
+			!middlewares.Scope(c).Contains(routeplan.CountryUUID, routeplan.ProvinceUUID, routeplan.AreaUUID, routeplan.SubAreaUUID, routeplan.CommuneUUID) {
+			return c.Status(403).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Route plan of another agent",
+			})
+		}
+	}
+
+	var start *utils.GeoPoint
+	if utils.ValidCoordinates(body.Latitude, body.Longitude) {
+		start = &utils.GeoPoint{Lat: body.Latitude, Lng: body.Longitude}
+	} else {
+		var last models.PosForm
+		db.Select("latitude", "longitude").
+			Where("user_uuid = ? AND NOT (latitude = 0 AND longitude = 0)", routeplan.UserUUID).
+			Order("created_at DESC").
+			Limit(1).
+			Find(&last)
+		if utils.ValidCoordinates(last.Latitude, last.Longitude) {
+			start = &utils.GeoPoint{Lat: last.Latitude, Lng: last.Longitude}
+		}
+	}
+
+	items := routeplan.RoutePlanItems
+	posByUUID := make(map[string]models.Pos, len(items))
+	for _, it := range items {
+		posByUUID[it.PosUUID] = it.Pos
+	}
+
+	stops, unlocated, total := orderStops(start, items, posByUUID)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, it := range items {
+			if err := tx.Model(&models.RoutePlanItem{}).
+				Where("uuid = ?", it.UUID).
+				Update("sequence", it.Sequence).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to save route order",
+			"error":   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "RoutePlan optimized success",
+		"data": fiber.Map{
+			"route_plan_uuid":   routeplan.UUID,
+			"start":             start,
+			"stops":             stops,
+			"unlocated":         unlocated,
+			"total_distance":    total,
+			"total_distance_km": math.Round(total/100) / 10,
+		},
+	})
+}
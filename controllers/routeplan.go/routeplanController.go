@@ -2,18 +2,41 @@ package routeplan
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
+// routePlanProgress adds the number of items of each plan and how many of
+// them were visited.
+const routePlanProgress = `
+	route_plans.*,
+	(
+		SELECT COUNT(DISTINCT r.uuid)
+		FROM route_plan_items r
+		WHERE r.route_plan_uuid = route_plans.uuid
+		  AND r.status = true
+		  AND r.deleted_at IS NULL
+	) AS total_item_active,
+	(
+		SELECT COUNT(DISTINCT r.uuid)
+		FROM route_plan_items r
+		WHERE r.route_plan_uuid = route_plans.uuid
+		  AND r.deleted_at IS NULL
+	) AS total_item
+`
+
 // Paginate
 func GetPaginatedRouteplan(c *fiber.Ctx) error {
 
 	// Initialize database connection
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page <= 0 {
@@ -43,26 +66,7 @@ func GetPaginatedRouteplan(c *fiber.Ctx) error {
 		Where(`  
 		EXISTS(SELECT 1 FROM users WHERE route_plans.user_uuid = users.uuid AND users.fullname ILIKE ?)
 		`, "%"+search+"%").
-		// Select(`
-		// 	route_plans.*, 
-		// 	COALESCE((
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid 
-		// 		AND r.status = true
-		// 	), 0) AS total_item_active,
-		// 	COALESCE((
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid
-		// 	), 0) AS total_item
-		// `).
+		Select(routePlanProgress).
 		Offset(offset).
 		Limit(limit).
 		Order("updated_at DESC").
@@ -109,7 +113,8 @@ func GetPaginatedRouthplaByProvinceUUID(c *fiber.Ctx) error {
 	provinceUUID := c.Params("province_uuid")
 
 	// Initialize database connection
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page <= 0 {
@@ -141,26 +146,7 @@ func GetPaginatedRouthplaByProvinceUUID(c *fiber.Ctx) error {
 		Where(`  
 		 EXISTS(SELECT 1 FROM users WHERE route_plans.user_uuid = users.uuid AND users.fullname ILIKE ?)
 		`, "%"+search+"%").
-		// Select(`
-		// 	route_plans.*, 
-		// 	(
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid 
-		// 		AND r.status = true
-		// 	) AS total_item_active,
-		// 	 (
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid
-		// 	) AS total_item
-		// `).
+		Select(routePlanProgress).
 		Offset(offset).
 		Limit(limit).
 		Order("updated_at DESC").
@@ -207,7 +193,8 @@ func GetPaginatedRouthplaByareaUUID(c *fiber.Ctx) error {
 	areaUUID := c.Params("area_uuid")
 
 	// Initialize database connection
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page <= 0 {
@@ -239,26 +226,7 @@ func GetPaginatedRouthplaByareaUUID(c *fiber.Ctx) error {
 		Where(`  
 		EXISTS(SELECT 1 FROM users WHERE route_plans.user_uuid = users.uuid AND users.fullname ILIKE ?)
 		`, "%"+search+"%").
-		// Select(`
-		// 	route_plans.*, 
-		// 	(
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid 
-		// 		AND r.status = true
-		// 	) AS total_item_active,
-		// 	 (
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid
-		// 	) AS total_item
-		// `).
+		Select(routePlanProgress).
 		Offset(offset).
 		Limit(limit).
 		Order("updated_at DESC").
@@ -305,7 +273,8 @@ func GetPaginatedRouthplaBySubareaUUID(c *fiber.Ctx) error {
 	subareaUUID := c.Params("sub_area_uuid")
 
 	// Initialize database connection
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page <= 0 {
@@ -337,26 +306,7 @@ func GetPaginatedRouthplaBySubareaUUID(c *fiber.Ctx) error {
 		Where(`  
 		EXISTS(SELECT 1 FROM users WHERE route_plans.user_uuid = users.uuid AND users.fullname ILIKE ?)
 		`, "%"+search+"%").
-		// Select(`
-		// 	route_plans.*, 
-		// 	(
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid 
-		// 		AND r.status = true
-		// 	) AS total_item_active,
-		// 	 (
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid
-		// 	) AS total_item
-		// `).
+		Select(routePlanProgress).
 		Offset(offset).
 		Limit(limit).
 		Order("updated_at DESC").
@@ -403,7 +353,8 @@ func GetPaginatedRouteplaBycommuneUUID(c *fiber.Ctx) error {
 	UserUUID := c.Params("user_uuid")
 
 	// Initialize database connection
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
 	if err != nil || page <= 0 {
@@ -435,26 +386,7 @@ func GetPaginatedRouteplaBycommuneUUID(c *fiber.Ctx) error {
 		Where(`  
 		EXISTS(SELECT 1 FROM users WHERE route_plans.user_uuid = users.uuid AND users.fullname ILIKE ?)
 		`, "%"+search+"%").
-		// Select(`
-		// 	route_plans.*, 
-		// 	(
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid 
-		// 		AND r.status = true
-		// 	) AS total_item_active,
-		// 	 (
-		// 		SELECT
-		// 		COUNT(DISTINCT r.uuid)
-		// 		FROM
-		// 		route_plan_items r 
-		// 		WHERE
-		// 		r.route_plan_uuid = route_plans.uuid
-		// 	) AS total_item
-		// `).
+		Select(routePlanProgress).
 		Offset(offset).
 		Limit(limit).
 		Order("updated_at DESC").
@@ -497,7 +429,8 @@ func GetPaginatedRouteplaBycommuneUUID(c *fiber.Ctx) error {
 
 // Get All data
 func GetAllRouteplan(c *fiber.Ctx) error {
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 
 	var data []models.RoutePlan
 	db.Find(&data)
@@ -510,7 +443,8 @@ func GetAllRouteplan(c *fiber.Ctx) error {
 
 // Get All data by id
 func GetAllRouteplanBySearch(c *fiber.Ctx) error {
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "route_plans").Session(&gorm.Session{})
 
 	search := c.Query("search", "")
 
@@ -560,7 +494,9 @@ func GetRouteplan(c *fiber.Ctx) error {
 
 	var Routeplan models.RoutePlan
 	db.Where("uuid = ?", uuid).
-		Preload("RoutePlanItems").
+		Preload("RoutePlanItems", func(db *gorm.DB) *gorm.DB {
+			return db.Order("sequence, created_at")
+		}).
 		First(&Routeplan)
 	if Routeplan.UUID == "0000000-0000-0000-0000-000000000000" {
 		return c.Status(404).JSON(
@@ -591,6 +527,7 @@ func CreateRouteplan(c *fiber.Ctx) error {
 	p.UUID = utils.GenerateUUID()
 	// Omit the primary key field (ID) during creation
 	database.DB.Omit("ID").Create(p)
+	utils.NotifyRoutePlan(database.DB, p)
 
 	return c.JSON(
 		fiber.Map{
@@ -615,7 +552,8 @@ func UpdateRouteplan(c *fiber.Ctx) error {
 		SubAreaUUID  string `json:"sub_area_uuid"`
 		CommuneUUID  string `json:"commune_uuid"`
 
-		Signature string `json:"signature"`
+		PlanDate  *time.Time `json:"plan_date"`
+		Signature string     `json:"signature"`
 	}
 
 	var updateData UpdateData
@@ -632,14 +570,22 @@ func UpdateRouteplan(c *fiber.Ctx) error {
 	RoutePlan := new(models.RoutePlan)
 
 	db.Where("uuid = ?", uuid).First(&RoutePlan)
+	reassigned := RoutePlan.UserUUID != updateData.UserUUID
 	RoutePlan.UserUUID = updateData.UserUUID
 	RoutePlan.ProvinceUUID = updateData.ProvinceUUID
 	RoutePlan.AreaUUID = updateData.AreaUUID
 	RoutePlan.SubAreaUUID = updateData.SubAreaUUID
 	RoutePlan.CommuneUUID = updateData.CommuneUUID
+	// An update without plan_date keeps the date of the plan
+	if updateData.PlanDate != nil {
+		RoutePlan.PlanDate = updateData.PlanDate
+	}
 	RoutePlan.Signature = updateData.Signature
 
 	db.Save(&RoutePlan)
+	if reassigned {
+		utils.NotifyRoutePlan(db, RoutePlan)
+	}
 
 	return c.JSON(
 		fiber.Map{
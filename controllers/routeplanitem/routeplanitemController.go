@@ -82,6 +82,7 @@ func GetAllRoutePlanItem(c *fiber.Ctx) error {
 	var dataList []models.RoutePlanItem
 	db.
 		Where("route_plan_uuid = ?", routePlanUUID).
+		Order("sequence, created_at").
 		Preload("RoutePlan").
 		Preload("Pos").
 		Find(&dataList)
@@ -0,0 +1,117 @@
+package segmentation
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"gorm.io/gorm"
+)
+
+var startOnce sync.Once
+
+// Start classifies the POS every SEGMENTATION_HOURS (24).
+func Start() {
+	startOnce.Do(func() {
+		interval := time.Duration(utils.EnvInt("SEGMENTATION_HOURS", 24)) * time.Hour
+		go func() {
+			for {
+				runDue(time.Now(), interval)
+				time.Sleep(interval)
+			}
+		}()
+	})
+}
+
+// runDue runs the configs not run for half an interval. Each one is
+// claimed by moving its last run first, so that it is run once even with
+// several instances of the API.
+func runDue(now time.Time, interval time.Duration) {
+	db := database.DB
+
+	var due []models.SegmentationConfig
+	err := db.Where("last_run_at IS NULL OR last_run_at <= ?", now.Add(-interval/2)).
+		Order("country_uuid").
+		Find(&due).Error
+	if err != nil {
+		log.Printf("Segmentation: %v", err)
+		return
+	}
+	for i := range due {
+		cfg := &due[i]
+		claim := db.Model(&models.SegmentationConfig{}).Where("uuid = ?", cfg.UUID)
+		if cfg.LastRunAt == nil {
+			claim = claim.Where("last_run_at IS NULL")
+		} else {
+			claim = claim.Where("last_run_at = ?", *cfg.LastRunAt)
+		}
+		claim = claim.UpdateColumn("last_run_at", now)
+		if claim.Error != nil || claim.RowsAffected != 1 {
+			continue
+		}
+		if _, err := Run(db, cfg, now); err != nil {
+			log.Printf("Segmentation %s: %v", cfg.UUID, err)
+		}
+	}
+}
+
+// configCountries returns the countries classified with cfg: its own, or
+// those without a config of their own for the default config.
+func configCountries(db *gorm.DB, cfg *models.SegmentationConfig) ([]string, error) {
+	if cfg.CountryUUID != "" {
+		return []string{cfg.CountryUUID}, nil
+	}
+	var countries []string
+	err := db.Model(&models.Country{}).
+		Where("uuid NOT IN (SELECT country_uuid FROM segmentation_configs WHERE country_uuid <> '' AND deleted_at IS NULL)").
+		Pluck("uuid", &countries).Error
+	return countries, err
+}
+
+// Run classifies the POS of the countries of cfg as of now, and drops the
+// cached dashboard responses.
+func Run(db *gorm.DB, cfg *models.SegmentationConfig, now time.Time) (utils.SegmentationResult, error) {
+	res := utils.SegmentationResult{Segments: map[string]int{}}
+	countries, err := configCountries(db, cfg)
+	if err != nil {
+		return res, err
+	}
+	defer utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+	for _, country := range countries {
+		if err := utils.SegmentCountry(db, cfg, country, now, &res); err != nil {
+			return res, fmt.Errorf("country %s: %w", country, err)
+		}
+	}
+	return res, nil
+}
+
+// RunAll runs every config now.
+func RunAll(db *gorm.DB, now time.Time) (utils.SegmentationResult, error) {
+	total := utils.SegmentationResult{Segments: map[string]int{}}
+
+	var configs []models.SegmentationConfig
+	if err := db.Order("country_uuid").Find(&configs).Error; err != nil {
+		return total, err
+	}
+	for i := range configs {
+		cfg := &configs[i]
+		if err := db.Model(cfg).UpdateColumn("last_run_at", now).Error; err != nil {
+			return total, err
+		}
+		res, err := Run(db, cfg, now)
+		total.Countries += res.Countries
+		total.Classified += res.Classified
+		total.Changed += res.Changed
+		for segment, n := range res.Segments {
+			total.Segments[segment] += n
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,294 @@
+package segmentation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// configInput is a segmentation config as sent by the client. Fields left
+// out keep their current value, the defaults on creation.
+type configInput struct {
+	CountryUUID   string   `json:"country_uuid"`
+	WindowDays    int      `json:"window_days"`
+	FardesWeight  *float64 `json:"fardes_weight"`
+	SoldWeight    *float64 `json:"sold_weight"`
+	RevenueWeight *float64 `json:"revenue_weight"`
+	ACutoff       *float64 `json:"a_cutoff"`
+	BCutoff       *float64 `json:"b_cutoff"`
+	Signature     string   `json:"signature"`
+}
+
+// apply validates the input and copies it to cfg. The country of a config
+// is set on creation only.
+func (in *configInput) apply(db *gorm.DB, cfg *models.SegmentationConfig) error {
+	country := strings.TrimSpace(in.CountryUUID)
+	if cfg.CreatedAt.IsZero() {
+		if country != "" {
+			var count int64
+			db.Model(&models.Country{}).Where("uuid = ?", country).Count(&count)
+			if count == 0 {
+				return fmt.Errorf("unknown country_uuid")
+			}
+		}
+		var count int64
+		db.Model(&models.SegmentationConfig{}).Where("country_uuid = ?", country).Count(&count)
+		if count > 0 {
+			return fmt.Errorf("the country already has a segmentation config")
+		}
+		cfg.CountryUUID = country
+	} else if country != cfg.CountryUUID {
+		return fmt.Errorf("country_uuid cannot be changed")
+	}
+
+	if in.WindowDays != 0 {
+		cfg.WindowDays = in.WindowDays
+	}
+	for _, f := range []struct {
+		in  *float64
+		out *float64
+	}{
+		{in.FardesWeight, &cfg.FardesWeight},
+		{in.SoldWeight, &cfg.SoldWeight},
+		{in.RevenueWeight, &cfg.RevenueWeight},
+		{in.ACutoff, &cfg.ACutoff},
+		{in.BCutoff, &cfg.BCutoff},
+	} {
+		if f.in != nil {
+			*f.out = *f.in
+		}
+	}
+
+	if cfg.WindowDays < 7 || cfg.WindowDays > 365 {
+		return fmt.Errorf("window_days must be from 7 to 365")
+	}
+	if cfg.FardesWeight < 0 || cfg.SoldWeight < 0 || cfg.RevenueWeight < 0 {
+		return fmt.Errorf("weights cannot be negative")
+	}
+	if cfg.FardesWeight+cfg.SoldWeight+cfg.RevenueWeight <= 0 {
+		return fmt.Errorf("at least one weight must be positive")
+	}
+	if cfg.BCutoff < 0 || cfg.BCutoff >= cfg.ACutoff || cfg.ACutoff > 100 {
+		return fmt.Errorf("cut-offs must be 0 <= b_cutoff < a_cutoff <= 100")
+	}
+	cfg.Signature = in.Signature
+	return nil
+}
+
+// GetConfigs lists the segmentation configs, the default one first.
+func GetConfigs(c *fiber.Ctx) error {
+	var data []models.SegmentationConfig
+	if err := database.DB.Order("country_uuid").Find(&data).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch segmentation configs",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All segmentation configs",
+		"data":    data,
+	})
+}
+
+// GetConfig returns one segmentation config.
+func GetConfig(c *fiber.Ctx) error {
+	var cfg models.SegmentationConfig
+	database.DB.Where("uuid = ?", c.Params("uuid")).First(&cfg)
+	if cfg.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No segmentation config found",
+			"data":    nil,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "segmentation config found",
+		"data":    cfg,
+	})
+}
+
+// CreateConfig saves the segmentation config of a country. Its POS are
+// classified with it from the next run.
+func CreateConfig(c *fiber.Ctx) error {
+	var input configInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	cfg := &models.SegmentationConfig{
+		UUID:          uuid.New().String(),
+		WindowDays:    90,
+		FardesWeight:  1,
+		SoldWeight:    1,
+		RevenueWeight: 1,
+		ACutoff:       80,
+		BCutoff:       50,
+	}
+	if err := input.apply(database.DB, cfg); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid segmentation config",
+			"error":   err.Error(),
+		})
+	}
+
+	// Select all so that zero weights are not replaced by the defaults
+	if err := database.DB.Select("*").Create(cfg).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create segmentation config",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "segmentation config created success",
+		"data":    cfg,
+	})
+}
+
+// UpdateConfig changes the window, weights or cut-offs of a segmentation
+// config, applied from the next run.
+func UpdateConfig(c *fiber.Ctx) error {
+	db := database.DB
+
+	var cfg models.SegmentationConfig
+	db.Where("uuid = ?", c.Params("uuid")).First(&cfg)
+	if cfg.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No segmentation config found",
+			"data":    nil,
+		})
+	}
+
+	input := configInput{CountryUUID: cfg.CountryUUID}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if err := input.apply(db, &cfg); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid segmentation config",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := db.Save(&cfg).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update segmentation config",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "segmentation config updated success",
+		"data":    cfg,
+	})
+}
+
+// DeleteConfig removes the config of a country, classified with the
+// default config from the next run. The default config cannot be deleted.
+func DeleteConfig(c *fiber.Ctx) error {
+	db := database.DB
+
+	var cfg models.SegmentationConfig
+	db.Where("uuid = ?", c.Params("uuid")).First(&cfg)
+	if cfg.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No segmentation config found",
+			"data":    nil,
+		})
+	}
+	if cfg.CountryUUID == "" {
+		return c.Status(409).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The default segmentation config cannot be deleted",
+			"data":    nil,
+		})
+	}
+
+	// Hard delete, so that the country can get a config again
+	if err := db.Unscoped().Delete(&cfg).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete segmentation config",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "segmentation config deleted success",
+		"data":    nil,
+	})
+}
+
+// RunSegmentation classifies the POS of every country now and returns the
+// outcome.
+func RunSegmentation(c *fiber.Ctx) error {
+	res, err := RunAll(database.DB, time.Now())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to run the segmentation",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "segmentation run",
+		"data":    res,
+	})
+}
+
+// GetPosSegmentHistory returns the current segment of a POS and its
+// changes of segment, the latest first.
+func GetPosSegmentHistory(c *fiber.Ctx) error {
+	db := database.DB
+
+	var pos models.Pos
+	db.Where("uuid = ?", c.Params("pos_uuid")).First(&pos)
+	if pos.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No POS found",
+			"data":    nil,
+		})
+	}
+
+	var changes []models.PosSegmentChange
+	if err := db.Where("pos_uuid = ?", pos.UUID).Order("effective_from DESC").Find(&changes).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch segment history",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"message":       "segment history of the POS",
+		"segment":       pos.Segment,
+		"segment_score": pos.SegmentScore,
+		"segmented_at":  pos.SegmentedAt,
+		"data":          changes,
+	})
+}
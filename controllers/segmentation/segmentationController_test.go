@@ -0,0 +1,58 @@
+package segmentation
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// Updates of a saved config, sent as JSON: fields left out keep their
+// value, and the config must stay consistent as a whole.
+func TestConfigInputUpdate(t *testing.T) {
+	saved := models.SegmentationConfig{
+		CreatedAt: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), CountryUUID: "cd",
+		WindowDays: 90, FardesWeight: 1, SoldWeight: 1, RevenueWeight: 1, ACutoff: 80, BCutoff: 50,
+	}
+
+	tests := []struct {
+		body string
+		want string // Error, or the config changed from saved as JSON
+	}{
+		{`{"country_uuid": "cd", "signature": "ops"}`, ""},
+		{`{"country_uuid": "cd", "window_days": 30, "revenue_weight": 0, "b_cutoff": 40}`, `"window_days":30,"revenue_weight":0,"b_cutoff":40`},
+		{`{"country_uuid": "cd", "fardes_weight": 0, "sold_weight": 0, "revenue_weight": 2.5}`, `"fardes_weight":0,"sold_weight":0,"revenue_weight":2.5`},
+		{`{"country_uuid": "cg"}`, "country_uuid cannot be changed"},
+		{`{"country_uuid": ""}`, "country_uuid cannot be changed"},
+		{`{"country_uuid": "cd", "window_days": 6}`, "window_days must be from 7 to 365"},
+		{`{"country_uuid": "cd", "window_days": 366}`, "window_days must be from 7 to 365"},
+		{`{"country_uuid": "cd", "sold_weight": -1}`, "weights cannot be negative"},
+		{`{"country_uuid": "cd", "fardes_weight": 0, "sold_weight": 0, "revenue_weight": 0}`, "at least one weight must be positive"},
+		{`{"country_uuid": "cd", "b_cutoff": 80}`, "cut-offs must be 0 <= b_cutoff < a_cutoff <= 100"},
+		{`{"country_uuid": "cd", "a_cutoff": 101}`, "cut-offs must be 0 <= b_cutoff < a_cutoff <= 100"},
+		{`{"country_uuid": "cd", "b_cutoff": -5}`, "cut-offs must be 0 <= b_cutoff < a_cutoff <= 100"},
+	}
+	for _, tt := range tests {
+		var in configInput
+		if err := json.Unmarshal([]byte(tt.body), &in); err != nil {
+			t.Fatal(err)
+		}
+		cfg := saved
+		// A saved config is checked without the database
+		err := in.apply(nil, &cfg)
+		if err != nil {
+			if err.Error() != tt.want {
+				t.Errorf("%s: %v, want %q", tt.body, err, tt.want)
+			}
+			continue
+		}
+		got, _ := json.Marshal(cfg)
+		want := saved
+		json.Unmarshal([]byte("{"+tt.want+"}"), &want)
+		want.Signature = in.Signature
+		if wantJSON, _ := json.Marshal(want); string(got) != string(wantJSON) {
+			t.Errorf("%s:\n got %s\nwant %s", tt.body, got, wantJSON)
+		}
+	}
+}
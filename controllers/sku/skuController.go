@@ -0,0 +1,283 @@
+package sku
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// skuInput is a SKU as sent by the client.
+type skuInput struct {
+	BrandUUID string `json:"brand_uuid"`
+	Name      string `json:"name"`
+	Code      string `json:"code"`
+	PackSize  int    `json:"pack_size"`
+	Signature string `json:"signature"`
+}
+
+// apply validates the input and copies it to s.
+func (in *skuInput) apply(s *models.Sku) error {
+	brandUUID := strings.TrimSpace(in.BrandUUID)
+	if brandUUID == "" {
+		return fmt.Errorf("brand_uuid is required")
+	}
+	var count int64
+	database.DB.Model(&models.Brand{}).Where("uuid = ?", brandUUID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("unknown brand_uuid")
+	}
+	if strings.TrimSpace(in.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if in.PackSize < 0 {
+		return fmt.Errorf("pack_size must not be negative")
+	}
+
+	s.BrandUUID = brandUUID
+	s.Name = strings.TrimSpace(in.Name)
+	s.Code = strings.TrimSpace(in.Code)
+	s.PackSize = in.PackSize
+	s.Signature = in.Signature
+	return nil
+}
+
+// invalidateDashboards drops the cached dashboard responses, which show
+// the catalogue.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedSkus(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	search := c.Query("search", "")
+
+	query := db.Model(&models.Sku{}).
+		Where("name ILIKE ? OR code ILIKE ?", "%"+search+"%", "%"+search+"%")
+	if brandUUID := c.Query("brand_uuid"); brandUUID != "" {
+		query = query.Where("brand_uuid = ?", brandUUID)
+	}
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var dataList []models.Sku
+	err = query.
+		Preload("Brand").
+		Offset(offset).
+		Limit(limit).
+		Order("brand_uuid, name").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch SKUs",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "SKUs retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data
+func GetAllSkus(c *fiber.Ctx) error {
+	db := database.DB
+
+	query := db.Order("brand_uuid, name")
+	if brandUUID := c.Query("brand_uuid"); brandUUID != "" {
+		query = query.Where("brand_uuid = ?", brandUUID)
+	}
+
+	var data []models.Sku
+	query.Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All SKUs",
+		"data":    data,
+	})
+}
+
+// Get one data
+func GetOneSku(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var sku models.Sku
+	db.Preload("Brand").Where("uuid = ?", uuid).First(&sku)
+	if sku.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No SKU found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "SKU found",
+			"data":    sku,
+		},
+	)
+}
+
+// Create data
+func CreateSku(c *fiber.Ctx) error {
+	var input skuInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	s := &models.Sku{UUID: uuid.New().String()}
+	if err := input.apply(s); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid SKU",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := database.DB.Omit("Brand").Create(s).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create SKU",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "SKU created success",
+			"data":    s,
+		},
+	)
+}
+
+// Update data
+func UpdateSku(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input skuInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	var sku models.Sku
+	db.Where("uuid = ?", uuid).First(&sku)
+	if sku.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No SKU found",
+			"data":    nil,
+		})
+	}
+
+	previousBrand := sku.BrandUUID
+	if err := input.apply(&sku); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid SKU",
+			"error":   err.Error(),
+		})
+	}
+	if sku.BrandUUID != previousBrand {
+		// Lines recorded against the SKU are lines of its former brand.
+		var lines int64
+		db.Model(&models.PosFormItems{}).Where("sku_uuid = ?", sku.UUID).Count(&lines)
+		if lines > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"status":  "error",
+				"message": "The SKU has visit lines, its brand cannot change",
+			})
+		}
+	}
+
+	if err := db.Omit("Brand").Save(&sku).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update SKU",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "SKU updated success",
+			"data":    sku,
+		},
+	)
+}
+
+// Delete data
+func DeleteSku(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var sku models.Sku
+	db.Where("uuid = ?", uuid).First(&sku)
+	if sku.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No SKU found",
+				"data":    nil,
+			},
+		)
+	}
+
+	db.Delete(&sku)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "SKU deleted success",
+			"data":    nil,
+		},
+	)
+}
@@ -0,0 +1,215 @@
+package subscription
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/exportjob"
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/google/uuid"
+)
+
+var startOnce sync.Once
+
+// Start checks every minute for the subscriptions due and sends them, one
+// at a time.
+func Start() {
+	startOnce.Do(func() {
+		go func() {
+			for {
+				runDue(time.Now())
+				time.Sleep(time.Minute)
+			}
+		}()
+	})
+}
+
+// runDue sends the active subscriptions whose next run is past. Each one
+// is claimed by moving its next run first, so that it is sent once even
+// with several instances of the API.
+func runDue(now time.Time) {
+	db := database.DB
+
+	var due []models.ReportSubscription
+	err := db.Where("active = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at").
+		Find(&due).Error
+	if err != nil {
+		log.Printf("Report subscriptions: %v", err)
+		return
+	}
+	for i := range due {
+		sub := &due[i]
+		scheduled := *sub.NextRunAt
+		next := NextRun(sub, now)
+		claim := db.Model(&models.ReportSubscription{}).
+			Where("uuid = ? AND next_run_at = ?", sub.UUID, scheduled).
+			Update("next_run_at", next)
+		if claim.Error != nil || claim.RowsAffected != 1 {
+			continue
+		}
+		sub.NextRunAt = &next
+		if _, err := deliver(sub, scheduled, false); err != nil {
+			log.Printf("Report subscription %s (%s): %v", sub.UUID, sub.Kind, err)
+		}
+	}
+}
+
+// isoWeekday maps 1 (Monday) to 7 (Sunday) to a time.Weekday.
+func isoWeekday(day int) time.Weekday {
+	return time.Weekday(day % 7)
+}
+
+// NextRun is the first time after after the subscription is due, at its
+// hour on every day, on its day of the week or on its day of the month.
+func NextRun(s *models.ReportSubscription, after time.Time) time.Time {
+	after = after.In(time.Local)
+	at := time.Date(after.Year(), after.Month(), after.Day(), s.Hour, 0, 0, 0, time.Local)
+	switch s.Frequency {
+	case models.SubscriptionWeekly:
+		for at.Weekday() != isoWeekday(s.Day) || !at.After(after) {
+			at = at.AddDate(0, 0, 1)
+		}
+	case models.SubscriptionMonthly:
+		at = time.Date(after.Year(), after.Month(), s.Day, s.Hour, 0, 0, 0, time.Local)
+		if !at.After(after) {
+			at = at.AddDate(0, 1, 0)
+		}
+	default:
+		if !at.After(after) {
+			at = at.AddDate(0, 0, 1)
+		}
+	}
+	return at
+}
+
+// reportPeriod is the period a run at reports on, both days included:
+// the day before, the 7 days before or the month before.
+func reportPeriod(frequency string, at time.Time) (start, end time.Time) {
+	at = at.In(time.Local)
+	today := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.Local)
+	switch frequency {
+	case models.SubscriptionWeekly:
+		return today.AddDate(0, 0, -7), today.AddDate(0, 0, -1)
+	case models.SubscriptionMonthly:
+		start = time.Date(at.Year(), at.Month()-1, 1, 0, 0, 0, 0, time.Local)
+		return start, start.AddDate(0, 1, -1)
+	default:
+		return today.AddDate(0, 0, -1), today.AddDate(0, 0, -1)
+	}
+}
+
+// reportQuery is the query string of the report: the filters of the
+// subscription and its period.
+func reportQuery(s *models.ReportSubscription, start, end time.Time) string {
+	values, _ := url.ParseQuery(s.Filters)
+	values.Set("start_date", start.Format("2006-01-02"))
+	values.Set("end_date", end.Format("2006-01-02"))
+	if s.Frequency == models.SubscriptionMonthly {
+		values.Set("month", start.Format("2006-01"))
+	}
+	return values.Encode()
+}
+
+// periodLabel shows a period as one day or as a range of days.
+func periodLabel(start, end time.Time) string {
+	if start.Equal(end) {
+		return start.Format("02/01/2006")
+	}
+	return start.Format("02/01/2006") + " – " + end.Format("02/01/2006")
+}
+
+// deliver builds the report of the subscription for the period of at,
+// emails it to the recipients and records the delivery, failed or not.
+func deliver(s *models.ReportSubscription, at time.Time, manual bool) (*models.ReportDelivery, error) {
+	started := time.Now()
+	start, end := reportPeriod(s.Frequency, at)
+	d := &models.ReportDelivery{
+		UUID:             uuid.New().String(),
+		SubscriptionUUID: s.UUID,
+		PeriodStart:      start,
+		PeriodEnd:        end,
+		Manual:           manual,
+		Recipients:       s.Recipients,
+	}
+
+	err := send(s, d, start, end)
+	d.DurationMs = time.Since(started).Milliseconds()
+	d.Status = models.DeliverySent
+	if err != nil {
+		d.Status, d.Error = models.DeliveryFailed, err.Error()
+	}
+
+	db := database.DB
+	if dbErr := db.Create(d).Error; dbErr != nil {
+		log.Printf("Report subscription %s: saving the delivery: %v", s.UUID, dbErr)
+	}
+	finished := time.Now()
+	s.LastRunAt, s.LastStatus, s.LastError = &finished, d.Status, d.Error
+	db.Model(s).Updates(map[string]interface{}{
+		"last_run_at": finished,
+		"last_status": d.Status,
+		"last_error":  d.Error,
+	})
+	return d, err
+}
+
+// send renders the report as the owner of the subscription and emails it.
+func send(s *models.ReportSubscription, d *models.ReportDelivery, start, end time.Time) error {
+	var user models.User
+	if err := database.DB.Where("uuid = ?", s.UserUUID).First(&user).Error; err != nil {
+		return fmt.Errorf("owner %s not found", s.UserUUID)
+	}
+	if !user.Status {
+		return fmt.Errorf("owner %s is inactive", user.Fullname)
+	}
+	to, err := utils.ParseRecipients(s.Recipients)
+	if err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no recipient")
+	}
+
+	content, fileName, err := exportjob.Render(s.Kind, reportQuery(s, start, end), &user)
+	if err != nil {
+		return err
+	}
+	d.FileName, d.FileSize = fileName, int64(len(content))
+
+	period := periodLabel(start, end)
+	name := s.Name
+	if name == "" {
+		name = "Rapport " + s.Kind
+	}
+	body := fmt.Sprintf(`<p>Bonjour,</p>
+<p>Veuillez trouver ci-joint <b>%s</b> pour la période du %s.</p>
+<p style="color:#7f8c8d;font-size:12px">Abonnement de %s — envoi %s. Pour ne plus le recevoir, désactivez l'abonnement dans MSPOS.</p>`,
+		html.EscapeString(name), html.EscapeString(period), html.EscapeString(user.Fullname), frequencyLabels[s.Frequency])
+
+	return utils.SendEmail(utils.EmailMessage{
+		To:      to,
+		Subject: fmt.Sprintf("%s — %s", name, period),
+		HTML:    body,
+		Attachments: []utils.EmailAttachment{{
+			Name:        fileName,
+			ContentType: mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))),
+			Data:        content,
+		}},
+	})
+}
+
+var frequencyLabels = map[string]string{
+	models.SubscriptionDaily:   "quotidien",
+	models.SubscriptionWeekly:  "hebdomadaire",
+	models.SubscriptionMonthly: "mensuel",
+}
@@ -0,0 +1,68 @@
+package subscription
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// at returns a local time on the day of 2026, at the hour and minute.
+func at(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2026, month, day, hour, minute, 0, 0, time.Local)
+}
+
+func TestNextRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency string
+		day, hour int
+		after     time.Time
+		want      time.Time
+	}{
+		{"daily later today", models.SubscriptionDaily, 0, 7, at(10, 17, 6, 59), at(10, 17, 7, 0)},
+		{"daily at the hour", models.SubscriptionDaily, 0, 7, at(10, 17, 7, 0), at(10, 18, 7, 0)},
+		{"daily tomorrow", models.SubscriptionDaily, 0, 7, at(10, 17, 12, 0), at(10, 18, 7, 0)},
+		{"daily over the month end", models.SubscriptionDaily, 0, 7, at(10, 31, 8, 0), at(11, 1, 7, 0)},
+		{"weekly on monday", models.SubscriptionWeekly, 1, 8, at(10, 17, 12, 0), at(10, 19, 8, 0)},
+		{"weekly on sunday", models.SubscriptionWeekly, 7, 8, at(10, 17, 12, 0), at(10, 18, 8, 0)},
+		{"weekly later today", models.SubscriptionWeekly, 6, 18, at(10, 17, 12, 0), at(10, 17, 18, 0)},
+		{"weekly next week", models.SubscriptionWeekly, 6, 8, at(10, 17, 12, 0), at(10, 24, 8, 0)},
+		{"monthly this month", models.SubscriptionMonthly, 28, 6, at(10, 17, 12, 0), at(10, 28, 6, 0)},
+		{"monthly next month", models.SubscriptionMonthly, 1, 6, at(10, 17, 12, 0), at(11, 1, 6, 0)},
+		{"monthly at the hour", models.SubscriptionMonthly, 17, 12, at(10, 17, 12, 0), at(11, 17, 12, 0)},
+		{"monthly next year", models.SubscriptionMonthly, 5, 6, at(12, 20, 0, 0), time.Date(2027, 1, 5, 6, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &models.ReportSubscription{Frequency: tt.frequency, Day: tt.day, Hour: tt.hour}
+			if got := NextRun(s, tt.after); !got.Equal(tt.want) {
+				t.Errorf("NextRun = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportPeriod(t *testing.T) {
+	tests := []struct {
+		name       string
+		frequency  string
+		at         time.Time
+		start, end time.Time
+	}{
+		{"daily", models.SubscriptionDaily, at(10, 17, 7, 0), at(10, 16, 0, 0), at(10, 16, 0, 0)},
+		{"daily on the first", models.SubscriptionDaily, at(11, 1, 7, 0), at(10, 31, 0, 0), at(10, 31, 0, 0)},
+		{"weekly", models.SubscriptionWeekly, at(10, 19, 8, 0), at(10, 12, 0, 0), at(10, 18, 0, 0)},
+		{"monthly", models.SubscriptionMonthly, at(11, 1, 6, 0), at(10, 1, 0, 0), at(10, 31, 0, 0)},
+		{"monthly february", models.SubscriptionMonthly, at(3, 15, 6, 0), at(2, 1, 0, 0), at(2, 28, 0, 0)},
+		{"monthly in january", models.SubscriptionMonthly, at(1, 1, 6, 0), time.Date(2025, 12, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 12, 31, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := reportPeriod(tt.frequency, tt.at)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("reportPeriod = %v to %v, want %v to %v", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
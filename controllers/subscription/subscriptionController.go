@@ -0,0 +1,334 @@
+package subscription
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/exportjob"
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var frequencies = []string{models.SubscriptionDaily, models.SubscriptionWeekly, models.SubscriptionMonthly}
+
+// subscriptionInput is a subscription as sent by the client. Filters is
+// the query string of the report; its period params are ignored.
+type subscriptionInput struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Filters    string `json:"filters"`
+	Frequency  string `json:"frequency"`
+	Day        int    `json:"day"`
+	Hour       *int   `json:"hour"`
+	Recipients string `json:"recipients"`
+	Active     *bool  `json:"active"`
+}
+
+// apply validates the input and copies it to s. Recipients default to the
+// email of user.
+func (in *subscriptionInput) apply(s *models.ReportSubscription, user *models.User) error {
+	kind := strings.TrimSpace(in.Kind)
+	if !slices.Contains(exportjob.KnownKinds(), kind) {
+		return fmt.Errorf("kind must be one of %s", strings.Join(exportjob.KnownKinds(), ", "))
+	}
+	frequency := strings.ToLower(strings.TrimSpace(in.Frequency))
+	if !slices.Contains(frequencies, frequency) {
+		return fmt.Errorf("frequency must be one of %s", strings.Join(frequencies, ", "))
+	}
+	day := in.Day
+	switch frequency {
+	case models.SubscriptionWeekly:
+		if day == 0 {
+			day = 1
+		}
+		if day < 1 || day > 7 {
+			return fmt.Errorf("day must be from 1 (Monday) to 7 (Sunday) for a weekly subscription")
+		}
+	case models.SubscriptionMonthly:
+		if day == 0 {
+			day = 1
+		}
+		if day < 1 || day > 28 {
+			return fmt.Errorf("day must be from 1 to 28 for a monthly subscription")
+		}
+	default:
+		day = 0
+	}
+	hour := 6
+	if in.Hour != nil {
+		hour = *in.Hour
+	}
+	if hour < 0 || hour > 23 {
+		return fmt.Errorf("hour must be from 0 to 23")
+	}
+
+	filters, err := url.ParseQuery(strings.TrimPrefix(strings.TrimSpace(in.Filters), "?"))
+	if err != nil {
+		return fmt.Errorf("filters must be a query string: %v", err)
+	}
+	for _, key := range []string{"token", "stream", "start_date", "end_date", "month"} {
+		filters.Del(key)
+	}
+
+	recipients := in.Recipients
+	if strings.TrimSpace(recipients) == "" {
+		recipients = user.Email
+	}
+	to, err := utils.ParseRecipients(recipients)
+	if err != nil {
+		return err
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("recipients is required")
+	}
+
+	s.Name = strings.TrimSpace(in.Name)
+	s.Kind = kind
+	s.Filters = filters.Encode()
+	s.Frequency = frequency
+	s.Day = day
+	s.Hour = hour
+	s.Recipients = strings.Join(to, ", ")
+	if in.Active != nil {
+		s.Active = *in.Active
+	}
+	next := NextRun(s, time.Now())
+	s.NextRunAt = &next
+	return nil
+}
+
+// findSubscription loads the subscription of the uuid param if the caller
+// owns it or is Support.
+func findSubscription(c *fiber.Ctx) (*models.ReportSubscription, error) {
+	user := middlewares.CurrentUser(c)
+	query := database.DB.Where("uuid = ?", c.Params("uuid"))
+	if middlewares.NormalizeRole(user.Role) != middlewares.RoleSupport {
+		query = query.Where("user_uuid = ?", user.UUID)
+	}
+	var s models.ReportSubscription
+	if err := query.First(&s).Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSubscriptions lists the subscriptions of the caller, or of everyone
+// (optionally of user_uuid) for Support.
+func GetSubscriptions(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+
+	query := database.DB.Order("created_at DESC")
+	if middlewares.NormalizeRole(user.Role) != middlewares.RoleSupport {
+		query = query.Where("user_uuid = ?", user.UUID)
+	} else if userUUID := c.Query("user_uuid"); userUUID != "" {
+		query = query.Where("user_uuid = ?", userUUID)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var data []models.ReportSubscription
+	if err := query.Find(&data).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch subscriptions",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All subscriptions",
+		"data":    data,
+	})
+}
+
+// GetSubscription returns one subscription.
+func GetSubscription(c *fiber.Ctx) error {
+	s, err := findSubscription(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No subscription found",
+			"data":    nil,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "subscription found",
+		"data":    s,
+	})
+}
+
+// CreateSubscription subscribes the caller to a report.
+func CreateSubscription(c *fiber.Ctx) error {
+	user := middlewares.CurrentUser(c)
+
+	var input subscriptionInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	s := &models.ReportSubscription{UUID: uuid.New().String(), UserUUID: user.UUID, Active: true}
+	if err := input.apply(s, user); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid subscription",
+			"error":   err.Error(),
+		})
+	}
+
+	// Select all so that active=false and day=0 are not replaced by the defaults
+	if err := database.DB.Select("*").Create(s).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create subscription",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "subscription created success",
+		"data":    s,
+	})
+}
+
+// UpdateSubscription replaces the settings of a subscription and
+// reschedules it.
+func UpdateSubscription(c *fiber.Ctx) error {
+	s, err := findSubscription(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No subscription found",
+			"data":    nil,
+		})
+	}
+
+	var input subscriptionInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+	if err := input.apply(s, middlewares.CurrentUser(c)); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid subscription",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := database.DB.Save(s).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update subscription",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "subscription updated success",
+		"data":    s,
+	})
+}
+
+// DeleteSubscription removes a subscription. Its deliveries are kept.
+func DeleteSubscription(c *fiber.Ctx) error {
+	s, err := findSubscription(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No subscription found",
+			"data":    nil,
+		})
+	}
+	if err := database.DB.Delete(s).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete subscription",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "subscription deleted success",
+		"data":    nil,
+	})
+}
+
+// SendSubscription sends the report of a subscription now, for the period
+// it would report on today, and returns the delivery.
+func SendSubscription(c *fiber.Ctx) error {
+	s, err := findSubscription(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No subscription found",
+			"data":    nil,
+		})
+	}
+
+	d, err := deliver(s, time.Now(), true)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The report was not sent",
+			"error":   err.Error(),
+			"data":    d,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "report sent",
+		"data":    d,
+	})
+}
+
+// GetDeliveries lists the latest deliveries of a subscription, failures
+// only with status=failed.
+func GetDeliveries(c *fiber.Ctx) error {
+	s, err := findSubscription(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No subscription found",
+			"data":    nil,
+		})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	query := database.DB.Where("subscription_uuid = ?", s.UUID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var data []models.ReportDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Find(&data).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch deliveries",
+			"error":   err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "deliveries of the subscription",
+		"data":    data,
+	})
+}
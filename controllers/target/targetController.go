@@ -0,0 +1,483 @@
+package target
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// targetInput is a target as sent by the client or read from an upload,
+// with its dates as "2006-01-02".
+type targetInput struct {
+	Metric       string  `json:"metric"`
+	Period       string  `json:"period"`
+	Value        float64 `json:"value"`
+	UserUUID     string  `json:"user_uuid"`
+	Title        string  `json:"title"`
+	CountryUUID  string  `json:"country_uuid"`
+	ProvinceUUID string  `json:"province_uuid"`
+	AreaUUID     string  `json:"area_uuid"`
+	SubAreaUUID  string  `json:"sub_area_uuid"`
+	CommuneUUID  string  `json:"commune_uuid"`
+	BrandUUID    string  `json:"brand_uuid"`
+	StartDate    string  `json:"start_date"`
+	EndDate      string  `json:"end_date"`
+	Signature    string  `json:"signature"`
+}
+
+// apply validates the input and copies it to t.
+func (in *targetInput) apply(t *models.Target) error {
+	metric := strings.ToLower(strings.TrimSpace(in.Metric))
+	if !slices.Contains(utils.TargetMetrics, metric) {
+		return fmt.Errorf("metric must be one of %s", strings.Join(utils.TargetMetrics, ", "))
+	}
+	period := strings.ToLower(strings.TrimSpace(in.Period))
+	if period == "" {
+		period = models.TargetPerDay
+	}
+	if !slices.Contains(utils.TargetPeriods, period) {
+		return fmt.Errorf("period must be one of %s", strings.Join(utils.TargetPeriods, ", "))
+	}
+	if in.Value < 0 {
+		return fmt.Errorf("value must not be negative")
+	}
+	start, err := utils.ParseDay(strings.TrimSpace(in.StartDate))
+	if err != nil {
+		return fmt.Errorf("start_date must be a date (YYYY-MM-DD)")
+	}
+	var end *time.Time
+	if s := strings.TrimSpace(in.EndDate); s != "" {
+		e, err := utils.ParseDay(s)
+		if err != nil {
+			return fmt.Errorf("end_date must be a date (YYYY-MM-DD)")
+		}
+		if e.Before(start) {
+			return fmt.Errorf("end_date is before start_date")
+		}
+		end = &e
+	}
+
+	t.Metric = metric
+	t.Period = period
+	t.Value = in.Value
+	t.UserUUID = strings.TrimSpace(in.UserUUID)
+	t.Title = strings.TrimSpace(in.Title)
+	t.CountryUUID = strings.TrimSpace(in.CountryUUID)
+	t.ProvinceUUID = strings.TrimSpace(in.ProvinceUUID)
+	t.AreaUUID = strings.TrimSpace(in.AreaUUID)
+	t.SubAreaUUID = strings.TrimSpace(in.SubAreaUUID)
+	t.CommuneUUID = strings.TrimSpace(in.CommuneUUID)
+	t.BrandUUID = strings.TrimSpace(in.BrandUUID)
+	t.StartDate = start
+	t.EndDate = end
+	t.Signature = in.Signature
+	return nil
+}
+
+// invalidateDashboards drops the cached dashboard responses, whose targets
+// may have changed.
+func invalidateDashboards() {
+	utils.ResponseCache.DeleteFunc(func(*utils.CachedResponse) bool { return true })
+}
+
+// Paginate
+func GetPaginatedTargets(c *fiber.Ctx) error {
+	db := database.DB
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "15"))
+	if err != nil || limit <= 0 {
+		limit = 15
+	}
+	offset := (page - 1) * limit
+
+	query := db.Model(&models.Target{})
+	for _, param := range []string{"metric", "period", "user_uuid", "title", "country_uuid",
+		"province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid", "brand_uuid"} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(param+" = ?", v)
+		}
+	}
+	if date := c.Query("date"); date != "" {
+		query = query.Where("start_date <= ?", date).
+			Where("end_date IS NULL OR end_date >= ?", date)
+	}
+
+	var totalRecords int64
+	query.Count(&totalRecords)
+
+	var dataList []models.Target
+	err = query.
+		Offset(offset).
+		Limit(limit).
+		Order("metric, start_date DESC, updated_at DESC").
+		Find(&dataList).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch targets",
+			"error":   err.Error(),
+		})
+	}
+
+	totalPages := int((totalRecords + int64(limit) - 1) / int64(limit))
+
+	pagination := map[string]interface{}{
+		"total_records": totalRecords,
+		"total_pages":   totalPages,
+		"current_page":  page,
+		"page_size":     limit,
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"message":    "targets retrieved successfully",
+		"data":       dataList,
+		"pagination": pagination,
+	})
+}
+
+// Get All data
+func GetAllTargets(c *fiber.Ctx) error {
+	db := database.DB
+
+	query := db.Order("metric, start_date DESC, updated_at DESC")
+	if metric := c.Query("metric"); metric != "" {
+		query = query.Where("metric = ?", metric)
+	}
+
+	var data []models.Target
+	query.Find(&data)
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "All Targets",
+		"data":    data,
+	})
+}
+
+// Get one data
+func GetOneTarget(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var target models.Target
+	db.Where("uuid = ?", uuid).First(&target)
+	if target.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No target found",
+				"data":    nil,
+			},
+		)
+	}
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "target found",
+			"data":    target,
+		},
+	)
+}
+
+// Create data
+func CreateTarget(c *fiber.Ctx) error {
+	var input targetInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	t := &models.Target{UUID: uuid.New().String()}
+	if err := input.apply(t); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid target",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := database.DB.Create(t).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create target",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "target created success",
+			"data":    t,
+		},
+	)
+}
+
+// Update data
+func UpdateTarget(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var input targetInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review your input",
+			"error":   err.Error(),
+		})
+	}
+
+	var target models.Target
+	db.Where("uuid = ?", uuid).First(&target)
+	if target.UUID == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No target found",
+			"data":    nil,
+		})
+	}
+
+	if err := input.apply(&target); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid target",
+			"error":   err.Error(),
+		})
+	}
+
+	if err := db.Save(&target).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update target",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "target updated success",
+			"data":    target,
+		},
+	)
+}
+
+// Delete data
+func DeleteTarget(c *fiber.Ctx) error {
+	uuid := c.Params("uuid")
+	db := database.DB
+
+	var target models.Target
+	db.Where("uuid = ?", uuid).First(&target)
+	if target.UUID == "" {
+		return c.Status(404).JSON(
+			fiber.Map{
+				"status":  "error",
+				"message": "No target found",
+				"data":    nil,
+			},
+		)
+	}
+
+	db.Delete(&target)
+	invalidateDashboards()
+
+	return c.JSON(
+		fiber.Map{
+			"status":  "success",
+			"message": "target deleted success",
+			"data":    nil,
+		},
+	)
+}
+
+// targetColumns are the columns of a target upload, in template order.
+var targetColumns = []string{
+	"metric", "period", "value", "user_uuid", "title",
+	"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid",
+	"brand_uuid", "start_date", "end_date",
+}
+
+// UploadTargets creates the targets of the first sheet of an Excel file
+// sent as "file". The first row names the columns (see targetColumns, in
+// any order); every row is validated and nothing is created if one fails.
+func UploadTargets(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Missing Excel file",
+			"error":   err.Error(),
+		})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to read Excel file",
+			"error":   err.Error(),
+		})
+	}
+	defer file.Close()
+
+	f, err := excelize.OpenReader(file)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid Excel file",
+			"error":   err.Error(),
+		})
+	}
+	defer f.Close()
+
+	rows, err := f.GetRows(f.GetSheetName(0))
+	if err != nil || len(rows) < 2 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "The first sheet must have a header row and at least one target",
+			"data":    nil,
+		})
+	}
+
+	index := map[string]int{}
+	for i, name := range rows[0] {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"metric", "value", "start_date"} {
+		if _, ok := index[required]; !ok {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Missing column " + required,
+				"data":    nil,
+			})
+		}
+	}
+	cell := func(row []string, column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	signature := c.FormValue("signature")
+	targets := make([]models.Target, 0, len(rows)-1)
+	rowErrors := []fiber.Map{}
+	for n, row := range rows[1:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		line := n + 2
+
+		value, err := strconv.ParseFloat(strings.ReplaceAll(cell(row, "value"), ",", "."), 64)
+		if err != nil {
+			rowErrors = append(rowErrors, fiber.Map{"row": line, "error": "value must be a number"})
+			continue
+		}
+		input := targetInput{
+			Metric:       cell(row, "metric"),
+			Period:       cell(row, "period"),
+			Value:        value,
+			UserUUID:     cell(row, "user_uuid"),
+			Title:        cell(row, "title"),
+			CountryUUID:  cell(row, "country_uuid"),
+			ProvinceUUID: cell(row, "province_uuid"),
+			AreaUUID:     cell(row, "area_uuid"),
+			SubAreaUUID:  cell(row, "sub_area_uuid"),
+			CommuneUUID:  cell(row, "commune_uuid"),
+			BrandUUID:    cell(row, "brand_uuid"),
+			StartDate:    cell(row, "start_date"),
+			EndDate:      cell(row, "end_date"),
+			Signature:    signature,
+		}
+		t := models.Target{UUID: uuid.New().String()}
+		if err := input.apply(&t); err != nil {
+			rowErrors = append(rowErrors, fiber.Map{"row": line, "error": err.Error()})
+			continue
+		}
+		targets = append(targets, t)
+	}
+
+	if len(rowErrors) > 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid rows, no target was created",
+			"data":    rowErrors,
+		})
+	}
+	if len(targets) == 0 {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No target found in the file",
+			"data":    nil,
+		})
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(targets, 200).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create targets",
+			"error":   err.Error(),
+		})
+	}
+	invalidateDashboards()
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("%d targets created", len(targets)),
+		"data":    targets,
+	})
+}
+
+// DownloadTargetTemplate sends an empty upload file with the target
+// columns.
+func DownloadTargetTemplate(c *fiber.Ctx) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := f.GetSheetName(0)
+	for i, column := range targetColumns {
+		name, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheet, name, column)
+	}
+	f.SetCellValue(sheet, "A2", models.TargetVisits)
+	f.SetCellValue(sheet, "B2", models.TargetPerDay)
+	f.SetCellValue(sheet, "C2", 10)
+	f.SetCellValue(sheet, "E2", "ASM")
+	f.SetCellValue(sheet, "L2", time.Now().Format("2006-01-02"))
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to generate template",
+			"error":   err.Error(),
+		})
+	}
+
+	c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Set("Content-Disposition", "attachment; filename=targets_template.xlsx")
+	return c.Send(buf.Bytes())
+}
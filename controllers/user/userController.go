@@ -5,14 +5,17 @@ import (
 	"strings"
 
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 // Paginate
 func GetPaginatedUsers(c *fiber.Ctx) error {
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
@@ -77,7 +80,8 @@ func GetPaginatedUsers(c *fiber.Ctx) error {
 }
 
 func GetPaginatedNoSerach(c *fiber.Ctx) error {
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 
 	// Parse query parameters for pagination
 	page, err := strconv.Atoi(c.Query("page", "1"))
@@ -137,7 +141,8 @@ func GetPaginatedNoSerach(c *fiber.Ctx) error {
 
 // query all data
 func GetAllUsers(c *fiber.Ctx) error {
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Find(&users)
 	return c.JSON(fiber.Map{
@@ -418,7 +423,8 @@ func DeleteUser(c *fiber.Ctx) error {
 // Get users by CountryUUID
 func GetUsersByCountryUUID(c *fiber.Ctx) error {
 	countryUUID := c.Params("country_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("country_uuid = ?", countryUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -451,7 +457,8 @@ func GetUserByCountryUUID(c *fiber.Ctx) error {
 // Get users by ProvinceUUID
 func GetUsersByProvinceUUID(c *fiber.Ctx) error {
 	provinceUUID := c.Params("province_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("province_uuid = ?", provinceUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -484,7 +491,8 @@ func GetUserByProvinceUUID(c *fiber.Ctx) error {
 // Get users by AreaUUID
 func GetUsersByAreaUUID(c *fiber.Ctx) error {
 	areaUUID := c.Params("area_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("area_uuid = ?", areaUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -517,7 +525,8 @@ func GetUserByAreaUUID(c *fiber.Ctx) error {
 // Get users by SubAreaUUID
 func GetUsersBySubAreaUUID(c *fiber.Ctx) error {
 	subAreaUUID := c.Params("sub_area_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("sub_area_uuid = ?", subAreaUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -550,7 +559,8 @@ func GetUserBySubAreaUUID(c *fiber.Ctx) error {
 // Get users by CommuneUUID
 func GetUsersByCommuneUUID(c *fiber.Ctx) error {
 	communeUUID := c.Params("commune_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("commune_uuid = ?", communeUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -583,7 +593,8 @@ func GetUserByCommuneUUID(c *fiber.Ctx) error {
 // Get users by SupportUUID
 func GetUsersBySupportUUID(c *fiber.Ctx) error {
 	supportUUID := c.Params("support_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("support_uuid = ?", supportUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -616,7 +627,8 @@ func GetUserBySupportUUID(c *fiber.Ctx) error {
 // Get users by ManagerUUID
 func GetUsersByManagerUUID(c *fiber.Ctx) error {
 	managerUUID := c.Params("manager_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("manager_uuid = ?", managerUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -649,7 +661,8 @@ func GetUserByManagerUUID(c *fiber.Ctx) error {
 // Get users by AsmUUID
 func GetUsersByAsmUUID(c *fiber.Ctx) error {
 	asmUUID := c.Params("asm_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("asm_uuid = ?", asmUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -682,7 +695,8 @@ func GetUserByAsmUUID(c *fiber.Ctx) error {
 // Get users by SupUUID
 func GetUsersBySupUUID(c *fiber.Ctx) error {
 	supUUID := c.Params("sup_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("sup_uuid = ?", supUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -715,7 +729,8 @@ func GetUserBySupUUID(c *fiber.Ctx) error {
 // Get users by DrUUID
 func GetUsersByDrUUID(c *fiber.Ctx) error {
 	drUUID := c.Params("dr_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("dr_uuid = ?", drUUID).Find(&users)
 	return c.JSON(fiber.Map{
@@ -748,7 +763,8 @@ func GetUserByDrUUID(c *fiber.Ctx) error {
 // Get users by CycloUUID
 func GetUsersByCycloUUID(c *fiber.Ctx) error {
 	cycloUUID := c.Params("cyclo_uuid")
-	db := database.DB
+	// Restrict to the caller's territory
+	db := utils.ApplyScope(database.DB, middlewares.Scope(c), "users").Session(&gorm.Session{})
 	var users []models.User
 	db.Where("cyclo_uuid = ?", cycloUUID).Find(&users)
 	return c.JSON(fiber.Map{
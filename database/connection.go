@@ -3,7 +3,9 @@ package database
 import (
 	"fmt"
 	"log"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
@@ -46,13 +48,92 @@ func Connect() {
 	migrateModel(&models.UserLogs{})
 	migrateModel(&models.Manager{})
 	migrateModel(&models.Pos{})
+	migrateModel(&models.SegmentationConfig{})
+	migrateModel(&models.PosSegmentChange{})
+	migrateModel(&models.PosMove{})
 	migrateModel(&models.PosEquipment{})
 	migrateModel(&models.PosForm{})
 	migrateModel(&models.PosFormItems{})
 	migrateModel(&models.RoutePlan{})
 	migrateModel(&models.RoutePlanItem{})
+	migrateModel(&models.Manufacturer{})
+	migrateModel(&models.Category{})
 	migrateModel(&models.Brand{})
+	migrateModel(&models.Sku{})
+	migrateModel(&models.Delivery{})
+	migrateModel(&models.DailyFact{})
+	migrateModel(&models.DailyGroupFact{})
+	// The POS segment joined the key of the daily facts: tables created
+	// before it got the column, empty until the facts are rebuilt at start
+	for _, fact := range []interface{}{&models.DailyFact{}, &models.DailyGroupFact{}} {
+		if err := migratePrimaryKey(connection, fact); err != nil {
+			log.Printf("Primary key migration failed for %T: %v\n", fact, err)
+		}
+	}
+	migrateModel(&models.DailyPosFact{})
+	migrateModel(&models.DailyFactsBuild{})
+	migrateModel(&models.Target{})
+	migrateModel(&models.RecommendedPrice{})
+	migrateModel(&models.ExchangeRate{})
+	migrateModel(&models.ExportJob{})
+	migrateModel(&models.ReportSubscription{})
+	migrateModel(&models.ReportDelivery{})
+	migrateModel(&models.Notification{})
+	migrateModel(&models.AlertRule{})
+	migrateModel(&models.Alert{})
 
 	// Initialiser le premier utilisateur Support s'il n'existe pas
 	InitializeSupportUser()
+	InitializeDefaultTargets()
+	InitializeDefaultAlertRules()
+	InitializeDefaultSegmentation()
+}
+
+// migratePrimaryKey recreates the primary key of the table of model when
+// it lacks primary key fields of the model: AutoMigrate adds the columns,
+// not the keys.
+func migratePrimaryKey(db *gorm.DB, model interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	var key []struct {
+		Conname string // Constraint
+		Attname string // Column
+	}
+	err := db.Raw(`
+		SELECT c.conname, a.attname
+		FROM pg_constraint c
+		INNER JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY(c.conkey)
+		WHERE c.conrelid = CAST(? AS regclass) AND c.contype = 'p'`, stmt.Schema.Table).Scan(&key).Error
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, 0, len(key))
+	for _, k := range key {
+		columns = append(columns, k.Attname)
+	}
+	missing := false
+	for _, name := range stmt.Schema.PrimaryFieldDBNames {
+		if !slices.Contains(columns, name) {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	quoted := make([]string, len(stmt.Schema.PrimaryFieldDBNames))
+	for i, name := range stmt.Schema.PrimaryFieldDBNames {
+		quoted[i] = `"` + name + `"`
+	}
+	alter := `ALTER TABLE "` + stmt.Schema.Table + `" `
+	if len(key) > 0 {
+		alter += `DROP CONSTRAINT "` + key[0].Conname + `", `
+	}
+	alter += `ADD PRIMARY KEY (` + strings.Join(quoted, ", ") + `)`
+	log.Printf("Recreating the primary key of %s\n", stmt.Schema.Table)
+	return db.Exec(alter).Error
 }
@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/google/uuid"
@@ -48,3 +49,95 @@ func InitializeSupportUser() {
 		fmt.Println("ℹ️ Utilisateur Support déjà existant")
 	}
 }
+
+// InitializeDefaultTargets crée les objectifs de visites par jour historiques
+// (ASM 10, Supervisor 20, DR et Cyclo 40) si aucun objectif n'existe
+func InitializeDefaultTargets() {
+	var count int64
+	if err := DB.Model(&models.Target{}).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for title, value := range map[string]float64{"ASM": 10, "Supervisor": 20, "DR": 40, "Cyclo": 40} {
+		target := &models.Target{
+			UUID:      uuid.New().String(),
+			Metric:    models.TargetVisits,
+			Period:    models.TargetPerDay,
+			Value:     value,
+			Title:     title,
+			StartDate: start,
+			Signature: "system",
+		}
+		if err := DB.Create(target).Error; err != nil {
+			fmt.Println("⚠️ Erreur lors de la création de l'objectif", title, ":", err)
+		}
+	}
+	fmt.Println("✅ Objectifs de visites par défaut créés")
+}
+
+// InitializeDefaultAlertRules crée les règles d'alerte par défaut : agents
+// sans visite depuis 7 jours et ruptures (OOS) d'au moins 50 % par area sur
+// 7 jours. Rien n'est fait si des règles existent déjà.
+func InitializeDefaultAlertRules() {
+	var count int64
+	if err := DB.Model(&models.AlertRule{}).Unscoped().Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	rules := []models.AlertRule{
+		{
+			Name:       "Agent inactif",
+			Metric:     models.AlertInactivity,
+			Level:      "agent",
+			Comparison: models.AlertAboveOrEqual,
+			Threshold:  7,
+			WindowDays: 7,
+			Severity:   models.AlertCritical,
+		},
+		{
+			Name:       "Rupture critique",
+			Metric:     models.AlertOOS,
+			Level:      "area",
+			Comparison: models.AlertAboveOrEqual,
+			Threshold:  50,
+			WindowDays: 7,
+			Severity:   models.AlertCritical,
+		},
+	}
+	for _, rule := range rules {
+		rule.UUID = uuid.New().String()
+		rule.Notify = true
+		rule.Active = true
+		if err := DB.Create(&rule).Error; err != nil {
+			fmt.Println("⚠️ Erreur lors de la création de la règle d'alerte", rule.Name, ":", err)
+		}
+	}
+	fmt.Println("✅ Règles d'alerte par défaut créées")
+}
+
+// InitializeDefaultSegmentation crée la configuration de segmentation des
+// PDV par défaut, utilisée pour les pays sans configuration propre : score
+// sur 90 jours, poids égaux, A à partir de 80, B à partir de 50.
+func InitializeDefaultSegmentation() {
+	var count int64
+	if err := DB.Model(&models.SegmentationConfig{}).Where("country_uuid = ''").Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+
+	config := &models.SegmentationConfig{
+		UUID:          uuid.New().String(),
+		WindowDays:    90,
+		FardesWeight:  1,
+		SoldWeight:    1,
+		RevenueWeight: 1,
+		ACutoff:       80,
+		BCutoff:       50,
+		Signature:     "system",
+	}
+	if err := DB.Create(config).Error; err != nil {
+		fmt.Println("⚠️ Erreur lors de la création de la configuration de segmentation :", err)
+		return
+	}
+	fmt.Println("✅ Configuration de segmentation par défaut créée")
+}
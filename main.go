@@ -4,8 +4,16 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/controllers/alert"
+	"github.com/danny19977/mspos-api-v3/controllers/exportjob"
+	"github.com/danny19977/mspos-api-v3/controllers/segmentation"
+	"github.com/danny19977/mspos-api-v3/controllers/subscription"
 	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/routes"
+	"github.com/danny19977/mspos-api-v3/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -22,11 +30,83 @@ func getPort() string {
 	return port
 }
 
+// rebuildFacts recomputes the dashboard daily facts, by default from the
+// first visit up to today.
+func rebuildFacts(args []string) {
+	if len(args) == 0 {
+		log.Println("Rebuilding every daily fact")
+		if err := utils.RebuildAllDailyFacts(database.DB); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Daily facts rebuilt")
+		return
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", args[0], time.Local)
+	if err != nil {
+		log.Fatalf("invalid from date %q: %v", args[0], err)
+	}
+	to := time.Now().AddDate(0, 0, 1)
+	if len(args) > 1 {
+		d, err := time.ParseInLocation("2006-01-02", args[1], time.Local)
+		if err != nil {
+			log.Fatalf("invalid to date %q: %v", args[1], err)
+		}
+		to = d.AddDate(0, 0, 1)
+	}
+
+	log.Printf("Rebuilding daily facts from %s to %s", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	if err := utils.RebuildDailyFacts(database.DB, from, to); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Daily facts rebuilt")
+}
+
+// segmentPos classifies the POS of every country now.
+func segmentPos() {
+	res, err := segmentation.RunAll(database.DB, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d POS classified in %d countries (A %d, B %d, C %d), %d changed segment",
+		res.Classified, res.Countries, res.Segments[models.SegmentA], res.Segments[models.SegmentB], res.Segments[models.SegmentC], res.Changed)
+}
 
 func main() {
 
 	database.Connect()
 
+	// go run . rebuild-facts [from YYYY-MM-DD] [to YYYY-MM-DD]
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-facts" {
+		rebuildFacts(os.Args[2:])
+		return
+	}
+
+	// go run . segment-pos
+	if len(os.Args) > 1 && os.Args[1] == "segment-pos" {
+		segmentPos()
+		return
+	}
+
+	// Background report exports
+	exportjob.Start()
+
+	// Scheduled report emails
+	subscription.Start()
+
+	// Alert rules, evaluated in the background
+	alert.Start()
+
+	// POS segmentation, run in the background
+	segmentation.Start()
+
+	// Daily facts of a new database or definition, rebuilt in the background
+	go func() {
+		if err := utils.EnsureDailyFacts(database.DB); err != nil {
+			log.Printf("Daily facts: %v", err)
+		}
+	}()
+
 	app := fiber.New()
 
 	// Initialize default config
@@ -35,7 +115,7 @@ func main() {
 	// Middleware
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "https://mspos-v3.onrender.com, http://localhost:4200, http://192.168.153.229:4200, http://192.168.160.229:4200",
-		AllowHeaders:     "Origin, Content-Type, Accept",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
 		AllowCredentials: true,
 		AllowMethods: strings.Join([]string{
 			fiber.MethodGet,
@@ -1,20 +1,200 @@
 package middlewares
 
 import (
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
 	"github.com/danny19977/mspos-api-v3/utils"
-	"github.com/gofiber/fiber"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Roles stored in users.role
+const (
+	RoleSupport    = "Support"
+	RoleManager    = "Manager"
+	RoleASM        = "ASM"
+	RoleSupervisor = "Supervisor"
+	RoleDR         = "DR"
+	RoleCyclo      = "Cyclo"
 )
 
+// userLocalsKey is the c.Locals key holding the authenticated *models.User
+const userLocalsKey = "user"
+
+// extractToken reads the JWT from, in order:
+//   - Authorization: Bearer <token>
+//   - the "token" cookie
+//   - the "token" query param (kept for the existing frontend calls)
+func extractToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	if cookie := c.Cookies("token"); cookie != "" {
+		return cookie
+	}
+	return c.Query("token")
+}
+
+// IsAuthenticated verifies the JWT, loads the user and stores it in c.Locals.
+// Inactive users (status = false) are rejected.
 func IsAuthenticated(c *fiber.Ctx) error {
+	token := extractToken(c)
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "unauthenticated",
+		})
+	}
 
-	cookie := c.Cookies("token")
+	userUUID, err := utils.VerifyJwt(token)
+	if err != nil || userUUID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "unauthenticated",
+		})
+	}
 
-	if _, err := utils.VerifyJwt(cookie); err != nil {
-		c.Status(fiber.StatusUnauthorized)
-		return c.JSON(fiber.Map{
+	var user models.User
+	if err := database.DB.Where("uuid = ?", userUUID).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
 			"message": "unauthenticated",
 		})
 	}
- c.Next()
- return nil
+
+	if !user.Status {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "vous n'êtes pas autorisé de se connecter 😰",
+		})
+	}
+
+	c.Locals(userLocalsKey, &user)
+
+	return c.Next()
+}
+
+// CurrentUser returns the user loaded by IsAuthenticated, or nil when the
+// route is not behind the middleware.
+func CurrentUser(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals(userLocalsKey).(*models.User)
+	return user
+}
+
+// SetCurrentUser stores the user CurrentUser returns, for requests replayed
+// outside of IsAuthenticated such as export jobs.
+func SetCurrentUser(c *fiber.Ctx, user *models.User) {
+	c.Locals(userLocalsKey, user)
+}
+
+// NormalizeRole maps the role spellings found in the database ("sup",
+// "supervisor", "asm"…) to the canonical Role* constants.
+func NormalizeRole(role string) string {
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case "support":
+		return RoleSupport
+	case "manager":
+		return RoleManager
+	case "asm":
+		return RoleASM
+	case "supervisor", "sup":
+		return RoleSupervisor
+	case "dr":
+		return RoleDR
+	case "cyclo":
+		return RoleCyclo
+	}
+	return role
+}
+
+// IsFieldAgent reports whether the user is a DR or a Cyclo, who capture
+// field data under their own name only.
+func IsFieldAgent(user *models.User) bool {
+	if user == nil {
+		return false
+	}
+	switch NormalizeRole(user.Role) {
+	case RoleDR, RoleCyclo:
+		return true
+	}
+	return false
+}
+
+// HasRole only lets through authenticated users whose role is in roles.
+// It must be mounted after IsAuthenticated.
+func HasRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := CurrentUser(c)
+		if user == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "unauthenticated",
+			})
+		}
+
+		role := NormalizeRole(user.Role)
+		for _, r := range roles {
+			if role == r {
+				return c.Next()
+			}
+		}
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Rôle non autorisé — accès refusé",
+		})
+	}
+}
+
+// Scope returns the territory the authenticated user may read.
+func Scope(c *fiber.Ctx) utils.Scope {
+	return utils.ResolveScope(CurrentUser(c))
+}
+
+// ScopeQueryParams pins the territory query params of the request to the
+// caller's scope, so an ASM cannot read another province by editing the
+// URL. Users with an unknown role are rejected.
+func ScopeQueryParams(c *fiber.Ctx) error {
+	s := Scope(c)
+	if s.Denied {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Rôle non reconnu — accès refusé",
+		})
+	}
+
+	utils.ApplyScopeToQueryArgs(c, s)
+
+	return c.Next()
+}
+
+// ScopeUserParam only lets through requests whose :user_uuid is the caller
+// or a user of the caller's scope, for the routes reading one agent's data.
+// It must be mounted on the route itself, where the param is known.
+func ScopeUserParam(c *fiber.Ctx) error {
+	userUUID := c.Params("user_uuid")
+	if user := CurrentUser(c); user != nil && user.UUID == userUUID {
+		return c.Next()
+	}
+
+	var count int64
+	err := utils.ApplyScope(database.DB.Model(&models.User{}), Scope(c), "users").
+		Where("users.uuid = ?", userUUID).
+		Count(&count).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to check the user",
+			"error":   err.Error(),
+		})
+	}
+	if count == 0 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Utilisateur hors de votre territoire — accès refusé",
+		})
+	}
+	return c.Next()
 }
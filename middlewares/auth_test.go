@@ -0,0 +1,178 @@
+package middlewares
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/database"
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// userTable is a connector whose users table holds its users, looked up by
+// uuid.
+type userTable map[string]models.User
+
+func (u userTable) Connect(context.Context) (driver.Conn, error) { return u, nil }
+func (userTable) Driver() driver.Driver                          { return nil }
+func (u userTable) Prepare(string) (driver.Stmt, error)          { return userStmt{u}, nil }
+func (userTable) Close() error                                   { return nil }
+func (userTable) Begin() (driver.Tx, error)                      { return nil, driver.ErrSkip }
+
+type userStmt struct{ users userTable }
+
+func (userStmt) Close() error                               { return nil }
+func (userStmt) NumInput() int                              { return -1 }
+func (userStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (s userStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &userRows{}
+	if u, ok := s.users[args[0].(string)]; ok {
+		rows.users = []models.User{u}
+	}
+	return rows, nil
+}
+
+type userRows struct{ users []models.User }
+
+func (*userRows) Columns() []string { return []string{"uuid", "role", "status"} }
+func (*userRows) Close() error      { return nil }
+func (r *userRows) Next(dest []driver.Value) error {
+	if len(r.users) == 0 {
+		return io.EOF
+	}
+	u := r.users[0]
+	r.users = r.users[1:]
+	copy(dest, []driver.Value{u.UUID, u.Role, u.Status})
+	return nil
+}
+
+// authApp serves /me, answering the role of the caller, and /managers, for
+// managers and support only, behind IsAuthenticated, the users being the
+// ones of userTable.
+func authApp(t *testing.T, users userTable) *fiber.App {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(users)}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, secret := database.DB, utils.SECRET_KEY
+	database.DB, utils.SECRET_KEY = db, "test-secret"
+	t.Cleanup(func() { database.DB, utils.SECRET_KEY = saved, secret })
+
+	app := fiber.New()
+	app.Use(IsAuthenticated)
+	app.Get("/me", func(c *fiber.Ctx) error { return c.SendString(CurrentUser(c).Role) })
+	app.Get("/managers", HasRole(RoleSupport, RoleManager), func(c *fiber.Ctx) error { return c.SendString("ok") })
+	return app
+}
+
+func TestIsAuthenticated(t *testing.T) {
+	app := authApp(t, userTable{
+		"mgr":     {UUID: "mgr", Role: "manager", Status: true},
+		"dr":      {UUID: "dr", Role: "DR", Status: true},
+		"blocked": {UUID: "blocked", Role: "Manager", Status: false},
+	})
+	token := func(uuid string) string {
+		tok, err := utils.GenerateJwt(uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	get := func(target string, header ...string) (int, string) {
+		req := httptest.NewRequest("GET", target, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// The token is read from the header, the cookie or the query
+	for name, request := range map[string]func() (int, string){
+		"header": func() (int, string) { return get("/me", fiber.HeaderAuthorization, "Bearer "+token("mgr")) },
+		"cookie": func() (int, string) { return get("/me", fiber.HeaderCookie, "token="+token("mgr")) },
+		"query":  func() (int, string) { return get("/me?token=" + token("mgr")) },
+	} {
+		if code, body := request(); code != fiber.StatusOK || body != "manager" {
+			t.Errorf("token in the %s: %d %s, want the manager", name, code, body)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header []string
+		status int
+	}{
+		{"no token", "/me", nil, fiber.StatusUnauthorized},
+		{"bad token", "/me", []string{fiber.HeaderAuthorization, "Bearer not-a-jwt"}, fiber.StatusUnauthorized},
+		{"unknown user", "/me?token=" + token("gone"), nil, fiber.StatusUnauthorized},
+		{"inactive user", "/me?token=" + token("blocked"), nil, fiber.StatusForbidden},
+		{"role allowed", "/managers?token=" + token("mgr"), nil, fiber.StatusOK},
+		{"role refused", "/managers?token=" + token("dr"), nil, fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if code, body := get(tt.target, tt.header...); code != tt.status {
+			t.Errorf("%s: %d %s, want %d", tt.name, code, body, tt.status)
+		}
+	}
+}
+
+func TestNormalizeRole(t *testing.T) {
+	for in, want := range map[string]string{
+		"support": RoleSupport, " Manager ": RoleManager, "asm": RoleASM,
+		"sup": RoleSupervisor, "SUPERVISOR": RoleSupervisor, "dr": RoleDR, "Cyclo": RoleCyclo,
+		"Auditor": "Auditor",
+	} {
+		if got := NormalizeRole(in); got != want {
+			t.Errorf("NormalizeRole(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if IsFieldAgent(nil) || IsFieldAgent(&models.User{Role: "asm"}) || !IsFieldAgent(&models.User{Role: "cyclo"}) {
+		t.Error("only DR and Cyclo users are field agents")
+	}
+}
+
+func TestScopeQueryParams(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		SetCurrentUser(c, &models.User{Role: c.Get("X-Role"), CountryUUID: "cd", ProvinceUUID: "kin"})
+		return c.Next()
+	})
+	app.Use(ScopeQueryParams)
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(c.Query("country_uuid") + "/" + c.Query("province_uuid"))
+	})
+
+	for role, want := range map[string]string{"ASM": "cd/kin", "Support": "cg/hk", "Auditor": ""} {
+		req := httptest.NewRequest("GET", "/?country_uuid=cg&province_uuid=hk", nil)
+		req.Header.Set("X-Role", role)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if want == "" {
+			if resp.StatusCode != fiber.StatusForbidden {
+				t.Errorf("%s: %d %s, want refused", role, resp.StatusCode, body)
+			}
+			continue
+		}
+		if string(body) != want {
+			t.Errorf("%s reads %s, want %s", role, body, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CacheResponses serves repeated GET requests from utils.ResponseCache for
+// up to ttl. The key is the path, the sorted non-empty query params and the
+// caller's scope, so it must run after ScopeQueryParams. Only successful
+// JSON responses are stored; a request with Cache-Control: no-cache skips
+// the lookup and refreshes the entry. Responses carry X-Cache (HIT | MISS)
+// and Age in seconds.
+func CacheResponses(ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodGet {
+			return c.Next()
+		}
+
+		key := cacheKey(c)
+		if !strings.Contains(c.Get(fiber.HeaderCacheControl), "no-cache") {
+			if r, ok := utils.ResponseCache.Get(key); ok {
+				c.Set("X-Cache", "HIT")
+				c.Set(fiber.HeaderAge, strconv.Itoa(int(time.Since(r.StoredAt).Seconds())))
+				c.Set(fiber.HeaderContentType, r.ContentType)
+				return c.Send(r.Body)
+			}
+		}
+
+		// Read before the handler, which may change the query args. Fiber
+		// strings point into the request buffer: copy what outlives it.
+		query := func(name string) string { return strings.Clone(c.Query(name)) }
+		r := &utils.CachedResponse{
+			CountryUUID:  query("country_uuid"),
+			ProvinceUUID: query("province_uuid"),
+			AreaUUID:     query("area_uuid"),
+			SubAreaUUID:  query("sub_area_uuid"),
+			CommuneUUID:  query("commune_uuid"),
+			StartDate:    query("start_date"),
+			EndDate:      query("end_date"),
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		c.Set("X-Cache", "MISS")
+		c.Set(fiber.HeaderAge, "0")
+
+		contentType := string(c.Response().Header.ContentType())
+		if c.Response().StatusCode() != fiber.StatusOK || !strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+			return nil
+		}
+		r.Body = append([]byte(nil), c.Response().Body()...)
+		r.ContentType = contentType
+		r.StoredAt = time.Now()
+		utils.ResponseCache.Set(key, r, ttl)
+		return nil
+	}
+}
+
+// cacheKey normalizes the request: path, query params sorted by name
+// without the token and empty values, then the caller's scope.
+func cacheKey(c *fiber.Ctx) string {
+	var params []string
+	c.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		name, value := string(k), strings.TrimSpace(string(v))
+		if name == "token" || value == "" {
+			return
+		}
+		params = append(params, name+"="+value)
+	})
+	sort.Strings(params)
+
+	s := Scope(c)
+	return strings.Clone(c.Path()) + "?" + strings.Join(params, "&") + "|" + s.CountryUUID + "|" + s.Column + "=" + s.UUID
+}
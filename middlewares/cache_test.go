@@ -0,0 +1,107 @@
+package middlewares
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/danny19977/mspos-api-v3/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+// cachedApp serves /count, which answers how many times it ran, and /fail
+// behind CacheResponses. The caller is an ASM of the province in the
+// X-Province header.
+func cachedApp(t *testing.T) (*fiber.App, *int) {
+	t.Helper()
+	store := utils.ResponseCache
+	utils.ResponseCache = utils.NewMemoryCache(10)
+	t.Cleanup(func() { utils.ResponseCache = store })
+
+	calls := 0
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		SetCurrentUser(c, &models.User{Role: "asm", CountryUUID: "cd", ProvinceUUID: c.Get("X-Province")})
+		return c.Next()
+	})
+	app.Use(CacheResponses(time.Minute))
+	app.Get("/count", func(c *fiber.Ctx) error {
+		calls++
+		return c.JSON(fiber.Map{"status": "success", "data": calls})
+	})
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error"})
+	})
+	return app, &calls
+}
+
+type cacheStep struct {
+	target   string
+	province string
+	noCache  bool
+	xCache   string // Expected X-Cache
+	body     string
+}
+
+func runCacheSteps(t *testing.T, app *fiber.App, steps []cacheStep) {
+	t.Helper()
+	for i, s := range steps {
+		req := httptest.NewRequest("GET", s.target, nil)
+		req.Header.Set("X-Province", s.province)
+		if s.noCache {
+			req.Header.Set(fiber.HeaderCacheControl, "no-cache")
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if got := resp.Header.Get("X-Cache"); got != s.xCache || string(body) != s.body {
+			t.Errorf("step %d %s: X-Cache %q body %s, want %q %s", i, s.target, got, body, s.xCache, s.body)
+		}
+	}
+}
+
+func TestCacheResponses(t *testing.T) {
+	t.Run("repeated request", func(t *testing.T) {
+		app, _ := cachedApp(t)
+		runCacheSteps(t, app, []cacheStep{
+			{target: "/count?start_date=2026-10-01&end_date=2026-10-16", province: "kin", xCache: "MISS", body: `{"data":1,"status":"success"}`},
+			// Same params in another order, with a token and an empty one
+			{target: "/count?end_date=2026-10-16&token=abc&area_uuid=&start_date=2026-10-01", province: "kin", xCache: "HIT", body: `{"data":1,"status":"success"}`},
+			{target: "/count?start_date=2026-10-02&end_date=2026-10-16", province: "kin", xCache: "MISS", body: `{"data":2,"status":"success"}`},
+		})
+	})
+
+	t.Run("other scope", func(t *testing.T) {
+		app, _ := cachedApp(t)
+		runCacheSteps(t, app, []cacheStep{
+			{target: "/count", province: "kin", xCache: "MISS", body: `{"data":1,"status":"success"}`},
+			{target: "/count", province: "kongo", xCache: "MISS", body: `{"data":2,"status":"success"}`},
+			{target: "/count", province: "kin", xCache: "HIT", body: `{"data":1,"status":"success"}`},
+		})
+	})
+
+	t.Run("no-cache refreshes the entry", func(t *testing.T) {
+		app, _ := cachedApp(t)
+		runCacheSteps(t, app, []cacheStep{
+			{target: "/count", province: "kin", xCache: "MISS", body: `{"data":1,"status":"success"}`},
+			{target: "/count", province: "kin", noCache: true, xCache: "MISS", body: `{"data":2,"status":"success"}`},
+			{target: "/count", province: "kin", xCache: "HIT", body: `{"data":2,"status":"success"}`},
+		})
+	})
+
+	t.Run("errors are not stored", func(t *testing.T) {
+		app, calls := cachedApp(t)
+		runCacheSteps(t, app, []cacheStep{
+			{target: "/fail", province: "kin", xCache: "MISS", body: `{"status":"error"}`},
+			{target: "/fail", province: "kin", xCache: "MISS", body: `{"status":"error"}`},
+		})
+		if *calls != 2 {
+			t.Errorf("handler ran %d times, want 2", *calls)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Alert rule metrics
+const (
+	AlertOOS        = "oos"        // OOS % of a brand in a territory
+	AlertND         = "nd"         // ND % of a brand in a territory
+	AlertSOS        = "sos"        // SOS % of a brand in a territory
+	AlertWD         = "wd"         // WD % of a brand in a territory
+	AlertVisits     = "visits"     // Visits of an agent or a territory over the window
+	AlertInactivity = "inactivity" // Days since the last visit of an agent
+)
+
+// Alert rule comparisons of the value with the threshold
+const (
+	AlertAbove        = "gt"
+	AlertAboveOrEqual = "gte"
+	AlertBelow        = "lt"
+	AlertBelowOrEqual = "lte"
+)
+
+// Alert severities
+const (
+	AlertInfo     = "info"
+	AlertWarning  = "warning"
+	AlertCritical = "critical"
+)
+
+// Alert statuses
+const (
+	AlertOpen     = "open"
+	AlertResolved = "resolved" // The value no longer breaches the threshold
+)
+
+// AlertRule raises an alert for each subject whose metric, over the last
+// WindowDays days, compares to Threshold. Subjects are the territories of
+// Level (× brand for the % metrics) or the agents when Level is "agent",
+// within the territory, brand and agent the rule is restricted to.
+type AlertRule struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Name       string  `json:"name" gorm:"not null;default:''"`
+	Metric     string  `json:"metric" gorm:"type:varchar(20);not null"`
+	Level      string  `json:"level" gorm:"type:varchar(20);not null"` // province, area, subarea, commune or agent
+	Comparison string  `json:"comparison" gorm:"type:varchar(5);not null"`
+	Threshold  float64 `json:"threshold" gorm:"not null"`
+	WindowDays int     `json:"window_days" gorm:"not null;default:7"`
+	Severity   string  `json:"severity" gorm:"type:varchar(20);not null;default:'warning'"`
+
+	CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null;default:''"`
+	ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null;default:''"`
+	AreaUUID     string `json:"area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	SubAreaUUID  string `json:"sub_area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	CommuneUUID  string `json:"commune_uuid" gorm:"type:varchar(255);not null;default:''"`
+	BrandUUID    string `json:"brand_uuid" gorm:"type:varchar(255);not null;default:''"`
+	UserUUID     string `json:"user_uuid" gorm:"type:varchar(255);not null;default:''"` // Agent
+
+	Notify    bool   `json:"notify" gorm:"not null;default:true"` // Notify the leads of the subject, and the author
+	Active    bool   `json:"active" gorm:"not null;default:true;index"`
+	CreatedBy string `json:"created_by" gorm:"type:varchar(255);not null;default:''"`
+
+	LastEvaluatedAt *time.Time `json:"last_evaluated_at"`
+	LastError       string     `json:"last_error" gorm:"type:text;not null;default:''"`
+}
+
+// Alert is a breach of a rule by a subject, from the evaluation that found
+// it until the one that no longer does. A subject has at most one open
+// alert per rule; resolved alerts are the history.
+type Alert struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	RuleUUID    string `json:"rule_uuid" gorm:"type:varchar(255);not null;index"`
+	Metric      string `json:"metric" gorm:"type:varchar(20);not null"`
+	Severity    string `json:"severity" gorm:"type:varchar(20);not null"`
+	Subject     string `json:"subject" gorm:"type:varchar(255);not null;index"` // Key of the subject within the rule
+	SubjectName string `json:"subject_name" gorm:"not null;default:''"`
+
+	Level         string `json:"level" gorm:"type:varchar(20);not null"`
+	TerritoryUUID string `json:"territory_uuid" gorm:"type:varchar(255);not null;default:''"`
+	BrandUUID     string `json:"brand_uuid" gorm:"type:varchar(255);not null;default:''"`
+	AgentUUID     string `json:"agent_uuid" gorm:"type:varchar(255);not null;default:''"`
+
+	CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null;default:''"`
+	ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null;default:''"`
+	AreaUUID     string `json:"area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	SubAreaUUID  string `json:"sub_area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	CommuneUUID  string `json:"commune_uuid" gorm:"type:varchar(255);not null;default:''"`
+
+	Value     float64 `json:"value" gorm:"not null"`      // At the last evaluation that found the breach
+	PeakValue float64 `json:"peak_value" gorm:"not null"` // Furthest from the threshold
+	Threshold float64 `json:"threshold" gorm:"not null"`
+
+	Status     string     `json:"status" gorm:"type:varchar(20);not null;default:'open';index"`
+	OpenedAt   time.Time  `json:"opened_at" gorm:"index"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
@@ -20,6 +20,15 @@ type Brand struct {
 	Province     Province `gorm:"foreignKey:ProvinceUUID;references:UUID"`
 	Signature    string   `json:"signature"`
 
+	// Catalogue: manufacturer → category → brand → SKU. Empty when unknown.
+	ManufacturerUUID string       `json:"manufacturer_uuid" gorm:"type:varchar(255);not null;default:''"`
+	Manufacturer     Manufacturer `gorm:"foreignKey:ManufacturerUUID;references:UUID"`
+	CategoryUUID     string       `json:"category_uuid" gorm:"type:varchar(255);not null;default:''"`
+	Category         Category     `gorm:"foreignKey:CategoryUUID;references:UUID"`
+	Competitor       bool         `json:"competitor" gorm:"not null;default:false"` // False for our own brands
+
+	Skus []Sku `gorm:"foreignKey:BrandUUID;references:UUID"`
+
 	TotalBrandUsage float64 `gorm:"-" json:"total_brand_usage"`
 
 	PosFormItems []PosFormItems `gorm:"foreignKey:BrandUUID;references:UUID"`
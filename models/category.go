@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Category is a product category of the catalogue, e.g. cigarettes or
+// beverages. Shares (SOS, SISH, …) are computed among the brands of a
+// category.
+type Category struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Name      string `gorm:"not null" json:"name"`
+	Signature string `json:"signature"`
+
+	Brands []Brand `gorm:"foreignKey:CategoryUUID;references:UUID"`
+}
@@ -14,6 +14,7 @@ type Country struct {
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 
 	Name      string `gorm:"not null" json:"name"`
+	Currency  string `json:"currency" gorm:"type:varchar(3);not null;default:'CDF'"` // ISO 4217 code of the prices captured in the country
 	Signature string `json:"signature"`
 
 	Provinces []Province `gorm:"foreignKey:CountryUUID;references:UUID"`
@@ -0,0 +1,120 @@
+package models
+
+import "time"
+
+// DailyFact is the pre-aggregated activity of one agent, one day, in one
+// commune, for one POS type, one POS segment and one brand. The row with an empty BrandUUID
+// holds the totals of the visits over all brands.
+//
+// On a brand row Visits and BrandPresent count the brand lines of the
+// visits, and PosCount the POS having the brand line that day. Shares are
+// within the category of the brand, as it was when the row was computed.
+type DailyFact struct {
+	Day         time.Time `gorm:"type:date;primaryKey" json:"day"`
+	CommuneUUID string    `gorm:"type:varchar(255);primaryKey" json:"commune_uuid"`
+	UserUUID    string    `gorm:"type:varchar(255);primaryKey" json:"user_uuid"`
+	BrandUUID   string    `gorm:"type:varchar(255);primaryKey" json:"brand_uuid"` // Empty for all brands
+	Postype     string    `gorm:"type:varchar(255);primaryKey" json:"postype"`
+	Segment     string    `gorm:"type:varchar(1);primaryKey;default:''" json:"segment"` // Of the POS at the visit, empty when not classified yet
+
+	CountryUUID  string `gorm:"type:varchar(255);not null;default:'';index" json:"country_uuid"`
+	ProvinceUUID string `gorm:"type:varchar(255);not null;default:''" json:"province_uuid"`
+	AreaUUID     string `gorm:"type:varchar(255);not null;default:''" json:"area_uuid"`
+	SubAreaUUID  string `gorm:"type:varchar(255);not null;default:''" json:"sub_area_uuid"`
+
+	Visits       int64   `gorm:"not null;default:0" json:"visits"`
+	PosCount     int64   `gorm:"not null;default:0" json:"pos_count"`     // Distinct POS of the day, not additive over days
+	Fardes       float64 `gorm:"not null;default:0" json:"fardes"`        // Stock on shelf
+	Sold         float64 `gorm:"not null;default:0" json:"sold"`          // Units sold
+	BrandPresent int64   `gorm:"not null;default:0" json:"brand_present"` // Visits with stock (number_farde > 0)
+	Revenue      float64 `gorm:"not null;default:0" json:"revenue"`       // Declared price of the visits, on the all-brands row
+
+	FardesShare float64 `gorm:"not null;default:0" json:"fardes_share"` // Sum of each line's % of the fardes of its category in the visit
+	BasketSold  float64 `gorm:"not null;default:0" json:"basket_sold"`  // Units sold by the brands of its category in the visits where the brand sold
+}
+
+// Brand groups of the daily group facts
+const (
+	GroupCategory     = "category"
+	GroupManufacturer = "manufacturer"
+	GroupOwnership    = "ownership"
+)
+
+// Ownership group keys
+const (
+	OwnershipOwn        = "own"
+	OwnershipCompetitor = "competitor"
+)
+
+// GroupScopeAll is the CategoryUUID of the group facts computed over all
+// categories.
+const GroupScopeAll = "*"
+
+// DailyGroupFact is the DailyFact of a group of brands: a category, a
+// manufacturer or the own and competitor brands. The measures are those of
+// a brand row, counted once per visit having a line of the group.
+//
+// CategoryUUID is the category the shares are computed within, GroupScopeAll
+// for all categories. Category rows are over all categories; manufacturer
+// and ownership rows are both per category and over all categories.
+type DailyGroupFact struct {
+	Day          time.Time `gorm:"type:date;primaryKey" json:"day"`
+	CommuneUUID  string    `gorm:"type:varchar(255);primaryKey" json:"commune_uuid"`
+	UserUUID     string    `gorm:"type:varchar(255);primaryKey" json:"user_uuid"`
+	Postype      string    `gorm:"type:varchar(255);primaryKey" json:"postype"`
+	Segment      string    `gorm:"type:varchar(1);primaryKey;default:''" json:"segment"`
+	GroupBy      string    `gorm:"type:varchar(20);primaryKey" json:"group_by"`
+	GroupKey     string    `gorm:"type:varchar(255);primaryKey" json:"group_key"` // Category or manufacturer UUID, or own | competitor
+	CategoryUUID string    `gorm:"type:varchar(255);primaryKey" json:"category_uuid"`
+
+	CountryUUID  string `gorm:"type:varchar(255);not null;default:'';index" json:"country_uuid"`
+	ProvinceUUID string `gorm:"type:varchar(255);not null;default:''" json:"province_uuid"`
+	AreaUUID     string `gorm:"type:varchar(255);not null;default:''" json:"area_uuid"`
+	SubAreaUUID  string `gorm:"type:varchar(255);not null;default:''" json:"sub_area_uuid"`
+
+	Visits       int64   `gorm:"not null;default:0" json:"visits"`        // Visits with a line of the group
+	PosCount     int64   `gorm:"not null;default:0" json:"pos_count"`     // Distinct POS of the day with a line of the group
+	Fardes       float64 `gorm:"not null;default:0" json:"fardes"`        // Stock on shelf
+	Sold         float64 `gorm:"not null;default:0" json:"sold"`          // Units sold
+	BrandPresent int64   `gorm:"not null;default:0" json:"brand_present"` // Visits with stock of the group
+
+	FardesShare float64 `gorm:"not null;default:0" json:"fardes_share"` // Sum of each visit's % of the fardes of the scope
+	BasketSold  float64 `gorm:"not null;default:0" json:"basket_sold"`  // Units sold in the scope in the visits where the group sold
+}
+
+// DailyPosFact is the activity of one agent, one day, at one POS and for
+// one brand, the row with an empty BrandUUID holding the totals of the
+// visits. Distinct POS over a period, which the daily facts cannot add up,
+// are counted on these rows, as are the per-POS drill-downs.
+//
+// On a brand row Visits and BrandPresent count the brand lines of the
+// visits. Territory, POS type and segment are those of the visits.
+type DailyPosFact struct {
+	Day       time.Time `gorm:"type:date;primaryKey" json:"day"`
+	PosUUID   string    `gorm:"type:varchar(255);primaryKey;index" json:"pos_uuid"`
+	UserUUID  string    `gorm:"type:varchar(255);primaryKey" json:"user_uuid"`
+	BrandUUID string    `gorm:"type:varchar(255);primaryKey" json:"brand_uuid"` // Empty for all brands
+
+	CountryUUID  string `gorm:"type:varchar(255);not null;default:'';index" json:"country_uuid"`
+	ProvinceUUID string `gorm:"type:varchar(255);not null;default:''" json:"province_uuid"`
+	AreaUUID     string `gorm:"type:varchar(255);not null;default:''" json:"area_uuid"`
+	SubAreaUUID  string `gorm:"type:varchar(255);not null;default:''" json:"sub_area_uuid"`
+	CommuneUUID  string `gorm:"type:varchar(255);not null;default:''" json:"commune_uuid"`
+	Postype      string `gorm:"type:varchar(255);not null;default:''" json:"postype"`
+	Segment      string `gorm:"type:varchar(1);not null;default:''" json:"segment"`
+
+	Visits       int64   `gorm:"not null;default:0" json:"visits"`
+	Fardes       float64 `gorm:"not null;default:0" json:"fardes"`        // Stock on shelf
+	Sold         float64 `gorm:"not null;default:0" json:"sold"`          // Units sold
+	BrandPresent int64   `gorm:"not null;default:0" json:"brand_present"` // Visits with stock (number_farde > 0)
+	Revenue      float64 `gorm:"not null;default:0" json:"revenue"`       // Declared price of the visits, on the all-brands row
+}
+
+// DailyFactsBuild records a full rebuild of the daily facts with a version
+// of their definition, see utils.DailyFactsVersion. BuiltAt is nil while
+// the rebuild runs.
+type DailyFactsBuild struct {
+	Version   int        `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	StartedAt time.Time  `gorm:"not null" json:"started_at"`
+	BuiltAt   *time.Time `json:"built_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Delivery is stock of a brand delivered to a POS, in fardes. Deliveries
+// between two visits of the POS are added to the stock of the first visit
+// when estimating the sell-out.
+type Delivery struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	PosUUID     string    `json:"pos_uuid" gorm:"type:varchar(255);not null;index:idx_delivery_pos_brand"`
+	Pos         Pos       `gorm:"foreignKey:PosUUID;references:UUID"`
+	BrandUUID   string    `json:"brand_uuid" gorm:"type:varchar(255);not null;index:idx_delivery_pos_brand"`
+	Brand       Brand     `gorm:"foreignKey:BrandUUID;references:UUID"`
+	DeliveredAt time.Time `json:"delivered_at" gorm:"not null"`
+	Fardes      float64   `json:"fardes" gorm:"not null"`
+	Signature   string    `json:"signature"`
+}
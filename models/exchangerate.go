@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExchangeRate is the rate of a currency pair from its date: one unit of
+// BaseCurrency is worth Rate units of QuoteCurrency. The rate of a day is
+// the latest at or before it, and serves both ways.
+type ExchangeRate struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Date          time.Time `json:"date" gorm:"type:date;not null;index"`
+	BaseCurrency  string    `json:"base_currency" gorm:"type:varchar(3);not null"`
+	QuoteCurrency string    `json:"quote_currency" gorm:"type:varchar(3);not null"`
+	Rate          float64   `json:"rate" gorm:"not null"`
+
+	Signature string `json:"signature"`
+}
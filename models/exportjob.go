@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Export job statuses
+const (
+	ExportQueued  = "queued"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+	ExportExpired = "expired" // Done, file removed after the retention period
+)
+
+// ExportJob is a report built in the background for a user. The finished
+// file is kept in the export directory until ExpiresAt.
+type ExportJob struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserUUID string `json:"user_uuid" gorm:"type:varchar(255);not null;index"`
+	Kind     string `json:"kind" gorm:"type:varchar(50);not null"`      // Report: pos, posforms, kpi
+	Query    string `json:"query" gorm:"type:text;not null;default:''"` // Query string of the report request
+	Stream   bool   `json:"stream" gorm:"not null;default:false"`
+
+	Status    string `json:"status" gorm:"type:varchar(20);not null;default:'queued';index"`
+	Progress  int    `json:"progress" gorm:"not null;default:0"` // 0 to 100
+	RowsDone  int    `json:"rows_done" gorm:"not null;default:0"`
+	RowsTotal int    `json:"rows_total" gorm:"not null;default:0"`
+	Error     string `json:"error" gorm:"type:text;not null;default:''"`
+
+	FileName string `json:"file_name" gorm:"not null;default:''"`
+	FilePath string `json:"-" gorm:"not null;default:''"`
+	FileSize int64  `json:"file_size" gorm:"not null;default:0"`
+
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Manufacturer is the company making brands of the catalogue.
+type Manufacturer struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Name      string `gorm:"not null" json:"name"`
+	Signature string `json:"signature"`
+
+	Brands []Brand `gorm:"foreignKey:ManufacturerUUID;references:UUID"`
+}
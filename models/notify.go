@@ -1,2 +1,68 @@
 package models
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	NotifyObservation   = "observation"    // A visit with a comment in the recipient's territory
+	NotifyRoutePlan     = "route_plan"     // A route plan published for the recipient
+	NotifyAgentInactive = "agent_inactive" // An inactivity alert on an agent of the recipient's territory
+	NotifyOOSCritical   = "oos_critical"   // An OOS alert on a brand in the recipient's territory
+	NotifyAlert         = "alert"          // Any other alert raised by an alert rule
+)
+
+// JSONMap is a JSON object stored in a jsonb column.
+type JSONMap map[string]interface{}
+
+// Value implements driver.Valuer.
+func (m JSONMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner.
+func (m *JSONMap) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*m = JSONMap{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONMap", value)
+	}
+	return json.Unmarshal(b, m)
+}
+
+// Notification is a message of a user's inbox. Payload holds the uuids the
+// client needs to open the related record. Key deduplicates the
+// notifications of the background checks: a user gets one notification
+// per key.
+type Notification struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserUUID string     `json:"user_uuid" gorm:"type:varchar(255);not null;index:idx_notifications_inbox,priority:1"`
+	Type     string     `json:"type" gorm:"type:varchar(30);not null;index"`
+	Title    string     `json:"title" gorm:"not null"`
+	Body     string     `json:"body" gorm:"type:text;not null;default:''"`
+	Payload  JSONMap    `json:"payload" gorm:"type:jsonb;not null;default:'{}'"`
+	Key      string     `json:"-" gorm:"type:varchar(255);not null;default:'';index"`
+	ReadAt   *time.Time `json:"read_at" gorm:"index:idx_notifications_inbox,priority:2"`
+}
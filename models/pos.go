@@ -6,6 +6,12 @@ import (
 	"gorm.io/gorm"
 )
 
+// Where a POS location comes from
+const (
+	PosLocationFromVisits     = "visit"
+	PosLocationFromSupervisor = "supervisor"
+)
+
 type Pos struct {
 	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
 
@@ -51,6 +57,23 @@ type Pos struct {
 
 	Sync bool   `json:"sync"`
 
+	// Canonical location of the shop, seeded from the first visits or set
+	// by a supervisor. LocationAccuracy is the geofence radius in meters. A
+	// location from visits stays provisional until LocationSamples reaches
+	// utils.LocationSampleLimit.
+	Latitude         float64    `json:"latitude" gorm:"default:0"`
+	Longitude        float64    `json:"longitude" gorm:"default:0"`
+	LocationAccuracy float64    `json:"location_accuracy" gorm:"default:0"`
+	LocationSource   string     `json:"location_source" gorm:"default:''"` // visit | supervisor, empty when unknown
+	LocationSamples  int        `json:"location_samples" gorm:"default:0"` // good visit fixes the location is the median of
+	LocationSetAt    *time.Time `json:"location_set_at"`
+
+	// A, B or C tier of the POS, set by the segmentation job (see
+	// SegmentationConfig). Empty until the POS is first classified.
+	Segment      string     `json:"segment" gorm:"type:varchar(1);not null;default:''"`
+	SegmentScore float64    `json:"segment_score" gorm:"not null;default:0"`
+	SegmentedAt  *time.Time `json:"segmented_at"`
+
 	// PosFormItems  []PosFormItems `gorm:"foreignKey:PosUUID;references:UUID"`
 	PosForms      []PosForm      `gorm:"foreignKey:PosUUID;references:UUID"`
 	PosEquipments []PosEquipment `gorm:"foreignKey:PosUUID;references:UUID"`
@@ -6,6 +6,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// Geofence statuses of a visit
+const (
+	GeofenceInside  = "inside"
+	GeofenceOutside = "outside"
+	GeofenceUnknown = "unknown"
+)
+
 type PosForm struct {
 	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
 
@@ -13,16 +20,33 @@ type PosForm struct {
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 
-	Price   int    `gorm:"default:0" json:"price"`
-	Comment string `json:"comment"`
+	Price    int    `gorm:"default:0" json:"price"`
+	Currency string `json:"currency" gorm:"type:varchar(3);not null;default:''"` // Empty for the currency of the country
+	Comment  string `json:"comment"`
+
+	Latitude    float64 `json:"latitude"`                      // Latitude of the user
+	Longitude   float64 `json:"longitude"`                     // Longitude of the user
+	GpsAccuracy float64 `json:"gps_accuracy" gorm:"default:0"` // Accuracy of the fix in meters reported by the device, 0 when unknown
+	Signature   string  `json:"signature"`
+
+	DistanceToPos  *float64 `json:"distance_to_pos"`                          // Meters between the user and the POS, nil when unknown
+	GeofenceStatus string   `json:"geofence_status" gorm:"default:'unknown'"` // inside | outside | unknown
 
-	Latitude  float64 `json:"latitude"`  // Latitude of the user
-	Longitude float64 `json:"longitude"` // Longitude of the user
-	Signature string  `json:"signature"`
+	// Check-in / check-out events of the visit, each with its own GPS fix.
+	// DurationSeconds is the time on site, nil until both events are known.
+	CheckInAt         *time.Time `json:"check_in_at"`
+	CheckInLatitude   float64    `json:"check_in_latitude"`
+	CheckInLongitude  float64    `json:"check_in_longitude"`
+	CheckOutAt        *time.Time `json:"check_out_at"`
+	CheckOutLatitude  float64    `json:"check_out_latitude"`
+	CheckOutLongitude float64    `json:"check_out_longitude"`
+	DurationSeconds   *int64     `json:"duration_seconds"`
 
 	PosUUID string `json:"pos_uuid" gorm:"type:varchar(255);not null;default:''"`
 	Pos Pos `gorm:"foreignKey:PosUUID;references:UUID"`
 
+	RoutePlanUUID string `json:"route_plan_uuid" gorm:"type:varchar(255);not null;default:''"` // Empty for an unplanned visit
+
 	CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null;default:''"`
 	ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null;default:''"`
 	AreaUUID     string `json:"area_uuid" gorm:"type:varchar(255);not null;default:''"`
@@ -53,3 +77,10 @@ type PosForm struct {
 
 	PosFormItems []PosFormItems `gorm:"foreignKey:PosFormUUID;references:UUID"`
 }
+
+// VisitSubmission is the body of POST /posforms/submit: a visit form and
+// all its brand lines, persisted together.
+type VisitSubmission struct {
+	PosForm
+	Items []PosFormItems `json:"items"`
+}
@@ -13,13 +13,19 @@ type PosFormItems struct {
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 
-	PosFormUUID string `json:"posform_uuid" gorm:"type:varchar(255);not null"` // Foreign key (belongs to), tag `index` will create index for this column
+	PosFormUUID string `json:"posform_uuid" gorm:"type:varchar(255);not null;index"` // Foreign key (belongs to), tag `index` will create index for this column
 	BrandUUID   string `json:"brand_uuid" gorm:"type:varchar(255);not null"`   // Foreign key (belongs to), tag `index` will create index for this column
+	SkuUUID     string `json:"sku_uuid" gorm:"type:varchar(255);not null;default:''"` // SKU of the brand, empty when captured at brand level
 
 	NumberFarde float64 `gorm:"not null" json:"number_farde"` // NUMBER Farde
 	// Counter     int     `gorm:"not null" json:"counter"`      // Allows to calculate the Sum of the ND Dashboard
 	Sold        float64 `gorm:"default:0" json:"sold"`        // Sold quantity of the item
 
+	// Shelf price of the brand at the POS, 0 when not captured
+	Price     float64 `gorm:"not null;default:0" json:"price"`
+	PriceUnit string  `gorm:"type:varchar(10);not null;default:''" json:"price_unit"` // farde or pack
+	Currency  string  `gorm:"type:varchar(3);not null;default:''" json:"currency"`    // ISO 4217, e.g. CDF
+
 	PosForm PosForm `gorm:"foreignKey:PosFormUUID;references:UUID"` // POS Form of the POS
 	Brand   Brand   `gorm:"foreignKey:BrandUUID;references:UUID"`   // Brand of the POS
 
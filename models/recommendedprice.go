@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Price units of a shelf price
+const (
+	PriceUnitFarde = "farde"
+	PriceUnitPack  = "pack"
+)
+
+// DefaultCurrency is the currency of the prices captured without one.
+const DefaultCurrency = "CDF"
+
+// RecommendedPrice is the recommended retail price (RRP) of a brand in a
+// unit and currency. Empty territory columns match every value; when
+// several prices match a visit, the most specific territory wins, then the
+// latest start date.
+type RecommendedPrice struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	BrandUUID string  `json:"brand_uuid" gorm:"type:varchar(255);not null;index"`
+	Brand     Brand   `gorm:"foreignKey:BrandUUID;references:UUID"`
+	Price     float64 `json:"price" gorm:"not null"`
+	PriceUnit string  `json:"price_unit" gorm:"type:varchar(10);not null;default:'farde'"`
+	Currency  string  `json:"currency" gorm:"type:varchar(3);not null"`
+
+	CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null;default:''"`
+	ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null;default:''"`
+	AreaUUID     string `json:"area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	SubAreaUUID  string `json:"sub_area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	CommuneUUID  string `json:"commune_uuid" gorm:"type:varchar(255);not null;default:''"`
+
+	StartDate time.Time  `json:"start_date" gorm:"type:date;not null"`
+	EndDate   *time.Time `json:"end_date" gorm:"type:date"` // Open-ended when nil
+
+	Signature string `json:"signature"`
+}
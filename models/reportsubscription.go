@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Report subscription frequencies
+const (
+	SubscriptionDaily   = "daily"   // Yesterday, every day
+	SubscriptionWeekly  = "weekly"  // The last 7 days, on Day of the week
+	SubscriptionMonthly = "monthly" // The last month, on Day of the month
+)
+
+// Report delivery statuses
+const (
+	DeliverySent   = "sent"
+	DeliveryFailed = "failed"
+)
+
+// ReportSubscription emails a report to its recipients on a schedule. The
+// report is built as its user, with Filters and the period of Frequency,
+// like an export job of the same Kind.
+type ReportSubscription struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	UserUUID   string `json:"user_uuid" gorm:"type:varchar(255);not null;index"`
+	Name       string `json:"name" gorm:"not null;default:''"`
+	Kind       string `json:"kind" gorm:"type:varchar(50);not null"`        // Report: pos, posforms, kpi, executive
+	Filters    string `json:"filters" gorm:"type:text;not null;default:''"` // Query string of the report, without the period
+	Frequency  string `json:"frequency" gorm:"type:varchar(10);not null"`
+	Day        int    `json:"day" gorm:"not null;default:1"`        // 1 (Monday) to 7 when weekly, 1 to 28 when monthly
+	Hour       int    `json:"hour" gorm:"not null;default:6"`       // Server time
+	Recipients string `json:"recipients" gorm:"type:text;not null"` // Comma separated emails
+	Active     bool   `json:"active" gorm:"not null;default:true;index"`
+
+	NextRunAt  *time.Time `json:"next_run_at" gorm:"index"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	LastStatus string     `json:"last_status" gorm:"type:varchar(20);not null;default:''"`
+	LastError  string     `json:"last_error" gorm:"type:text;not null;default:''"`
+}
+
+// ReportDelivery is one sending of a subscription, successful or not.
+type ReportDelivery struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+
+	SubscriptionUUID string    `json:"subscription_uuid" gorm:"type:varchar(255);not null;index"`
+	PeriodStart      time.Time `json:"period_start" gorm:"type:date"`
+	PeriodEnd        time.Time `json:"period_end" gorm:"type:date"`
+	Manual           bool      `json:"manual" gorm:"not null;default:false"` // Sent on request rather than by the scheduler
+
+	Status     string `json:"status" gorm:"type:varchar(20);not null"`
+	Recipients string `json:"recipients" gorm:"type:text;not null;default:''"`
+	FileName   string `json:"file_name" gorm:"not null;default:''"`
+	FileSize   int64  `json:"file_size" gorm:"not null;default:0"`
+	Error      string `json:"error" gorm:"type:text;not null;default:''"`
+	DurationMs int64  `json:"duration_ms" gorm:"not null;default:0"`
+}
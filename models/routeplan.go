@@ -6,6 +6,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// Route plan statuses. Generated plans start as drafts the supervisor can
+// adjust; only published plans are sent to agents and tracked.
+const (
+	RoutePlanDraft     = "draft"
+	RoutePlanPublished = "published"
+)
+
 type RoutePlan struct {
 	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
 
@@ -31,11 +38,16 @@ type RoutePlan struct {
 	CommuneUUID string  `json:"commune_uuid" gorm:"type:varchar(255);not null"`
 	Commune     Commune `gorm:"foreignKey:CommuneUUID;references:UUID"`
 
+	// Day the plan is meant to be executed; plans created before this field
+	// existed fall back to the creation day.
+	PlanDate *time.Time `json:"plan_date" gorm:"type:date;index"`
+	Status   string     `json:"status" gorm:"default:'published'"` // draft | published
+
 	// TotalPOS  int    `json:"total_pos"`
 	Signature string `json:"signature"`
 
-	// TotalItemActive int64 `json:"total_item_active" gorm:"-"`
-	// TotalItem       int64 `json:"total_item" gorm:"-"`
+	TotalItemActive int64 `json:"total_item_active" gorm:"->;-:migration"` // Items visited
+	TotalItem       int64 `json:"total_item" gorm:"->;-:migration"`
 
 	RoutePlanItems []RoutePlanItem `gorm:"foreignKey:RoutePlanUUID;references:UUID"`
 }
@@ -19,5 +19,11 @@ type RoutePlanItem struct {
 	PosUUID string `json:"pos_uuid" gorm:"type:varchar(255);not null"`
 	Pos     Pos    `gorm:"foreignKey:PosUUID;references:UUID"`
 
-	Status bool `json:"status"`
+	Status   bool `json:"status"`                    // Visited
+	Sequence int  `json:"sequence" gorm:"default:0"` // Visiting order, 1-based; 0 = not ordered yet
+
+	// Visit that fulfilled the item, set when the plan's user submits a
+	// PosForm for this POS on the plan day.
+	PosFormUUID string     `json:"posform_uuid" gorm:"type:varchar(255);not null;default:''"`
+	VisitedAt   *time.Time `json:"visited_at"`
 }
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// POS segments, from the most to the least valuable. A POS not classified
+// yet has an empty segment.
+const (
+	SegmentA = "A"
+	SegmentB = "B"
+	SegmentC = "C"
+)
+
+// SegmentationConfig sets how the POS of a country are classified: each
+// POS gets a score from 0 to 100, the weighted average of its percentile
+// rank in the country on fardes, sold and revenue over the trailing
+// WindowDays days. POS scoring at least ACutoff are A, at least BCutoff B,
+// the others C. The config with an empty CountryUUID is the default of the
+// countries without their own.
+type SegmentationConfig struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	CountryUUID   string  `json:"country_uuid" gorm:"type:varchar(255);not null;default:'';index"`
+	WindowDays    int     `json:"window_days" gorm:"not null;default:90"`
+	FardesWeight  float64 `json:"fardes_weight" gorm:"not null;default:1"`
+	SoldWeight    float64 `json:"sold_weight" gorm:"not null;default:1"`
+	RevenueWeight float64 `json:"revenue_weight" gorm:"not null;default:1"`
+	ACutoff       float64 `json:"a_cutoff" gorm:"not null;default:80"` // Minimum score of an A POS
+	BCutoff       float64 `json:"b_cutoff" gorm:"not null;default:50"` // Minimum score of a B POS
+
+	LastRunAt *time.Time `json:"last_run_at"`
+	Signature string     `json:"signature"`
+}
+
+// PosSegmentChange records a POS moving to another segment, with the
+// figures it was classified on. The segment applies to the visits from
+// EffectiveFrom; the first classification of a POS is effective from the
+// start of the window it was computed on.
+type PosSegmentChange struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+
+	PosUUID         string    `json:"pos_uuid" gorm:"type:varchar(255);not null;index:idx_pos_segment_change"`
+	Segment         string    `json:"segment" gorm:"type:varchar(1);not null"`
+	PreviousSegment string    `json:"previous_segment" gorm:"type:varchar(1);not null;default:''"`
+	EffectiveFrom   time.Time `json:"effective_from" gorm:"not null;index:idx_pos_segment_change"`
+
+	Score   float64 `json:"score" gorm:"not null;default:0"`
+	Fardes  float64 `json:"fardes" gorm:"not null;default:0"`
+	Sold    float64 `json:"sold" gorm:"not null;default:0"`
+	Revenue float64 `json:"revenue" gorm:"not null;default:0"` // In the currency of the country
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Sku is a product of a brand in one pack size, the finest level of the
+// catalogue.
+type Sku struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	BrandUUID string `json:"brand_uuid" gorm:"type:varchar(255);not null;index"`
+	Brand     Brand  `gorm:"foreignKey:BrandUUID;references:UUID"`
+	Name      string `gorm:"not null" json:"name"`
+	Code      string `json:"code" gorm:"not null;default:''"`     // Barcode or internal reference
+	PackSize  int    `json:"pack_size" gorm:"not null;default:0"` // Units per pack, 0 when unknown
+	Signature string `json:"signature"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Sync statuses returned per record by /sync/push
+const (
+	SyncCreated  = "created"
+	SyncUpdated  = "updated"
+	SyncSkipped  = "skipped"  // server copy is newer (or identical retry) or deleted
+	SyncRejected = "rejected" // validation error, nothing written
+)
+
+// SyncPushRequest is the batch uploaded by the mobile app after working
+// offline. Every record carries a client-generated UUID so retries are safe.
+type SyncPushRequest struct {
+	Pos           []Pos          `json:"pos"`
+	PosForms      []PosForm      `json:"pos_forms"`
+	PosFormItems  []PosFormItems `json:"pos_form_items"`
+	PosEquipments []PosEquipment `json:"pos_equipments"`
+}
+
+// SyncRecordResult is the outcome of one record of a SyncPushRequest.
+type SyncRecordResult struct {
+	Entity string `json:"entity"` // pos, pos_form, pos_form_item, pos_equipment
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PosMove records a POS leaving a territory: the territory it was in until
+// CreatedAt. Pulls send it as a tombstone to the agents of that territory.
+type PosMove struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time `gorm:"index"`
+
+	PosUUID      string `json:"pos_uuid" gorm:"type:varchar(255);not null;index"`
+	CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null;default:''"`
+	ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null;default:''"`
+	AreaUUID     string `json:"area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	SubAreaUUID  string `json:"sub_area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	CommuneUUID  string `json:"commune_uuid" gorm:"type:varchar(255);not null;default:''"`
+}
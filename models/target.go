@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Target metrics
+const (
+	TargetVisits = "visits" // Visits
+	TargetND     = "nd"     // ND %
+	TargetSOS    = "sos"    // SOS %
+	TargetWD     = "wd"     // WD %
+	TargetSold   = "sold"   // Sold volume
+)
+
+// Target periods
+const (
+	TargetPerDay   = "day"
+	TargetPerWeek  = "week"
+	TargetPerMonth = "month"
+)
+
+// Target is the goal of a metric for the users it applies to. Empty
+// UserUUID, Title, territory or BrandUUID match every value; when several
+// targets match, the most specific wins (see utils.TargetResolver).
+//
+// Value is per Period for visits and sold volume. For the % metrics it is
+// the expected rate whatever the period.
+type Target struct {
+	UUID string `gorm:"type:text;not null;unique;primaryKey" json:"uuid"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Metric string  `json:"metric" gorm:"type:varchar(20);not null;index"`
+	Period string  `json:"period" gorm:"type:varchar(10);not null;default:'day'"`
+	Value  float64 `json:"value" gorm:"not null"`
+
+	UserUUID     string `json:"user_uuid" gorm:"type:varchar(255);not null;default:''"`
+	Title        string `json:"title" gorm:"type:varchar(255);not null;default:''"` // Role title of the users, e.g. Supervisor
+	CountryUUID  string `json:"country_uuid" gorm:"type:varchar(255);not null;default:''"`
+	ProvinceUUID string `json:"province_uuid" gorm:"type:varchar(255);not null;default:''"`
+	AreaUUID     string `json:"area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	SubAreaUUID  string `json:"sub_area_uuid" gorm:"type:varchar(255);not null;default:''"`
+	CommuneUUID  string `json:"commune_uuid" gorm:"type:varchar(255);not null;default:''"`
+	BrandUUID    string `json:"brand_uuid" gorm:"type:varchar(255);not null;default:''"`
+
+	StartDate time.Time  `json:"start_date" gorm:"type:date;not null"`
+	EndDate   *time.Time `json:"end_date" gorm:"type:date"` // Open-ended when nil
+
+	Signature string `json:"signature"`
+}
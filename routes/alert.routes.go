@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/alert"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupAlertRoutes(api fiber.Router) {
+	// Alerts raised by the alert rules, within the caller's territory
+	al := api.Group("/alerts")
+	al.Get("/all", alert.GetAlerts)
+	al.Get("/get/:uuid", alert.GetAlert)
+
+	// Alert rules, evaluated in the background
+	al.Get("/rules/all", alert.GetRules)
+	al.Get("/rules/get/:uuid", alert.GetRule)
+	al.Post("/rules/create", supervisors, alert.CreateRule)
+	al.Post("/rules/evaluate/:uuid", supervisors, alert.EvaluateRule)
+	al.Put("/rules/update/:uuid", supervisors, alert.UpdateRule)
+	al.Delete("/rules/delete/:uuid", supervisors, alert.DeleteRule)
+}
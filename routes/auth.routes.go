@@ -2,18 +2,19 @@ package routes
 
 import (
 	"github.com/danny19977/mspos-api-v3/controllers/auth"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/gofiber/fiber/v2"
 )
 
 func setupAuthRoutes(api fiber.Router) {
 	a := api.Group("/auth")
-	a.Post("/register", auth.Register)
 	a.Post("/login", auth.Login)
 	a.Post("/forgot-password", auth.Forgot)
 	a.Post("/reset/:token", auth.ResetPassword)
 
-	// app.Use(middlewares.IsAuthenticated)
+	a.Use(middlewares.IsAuthenticated)
 
+	a.Post("/register", adminOnly, auth.Register)
 	a.Get("/user", auth.AuthUser)
 	a.Put("/profil/info", auth.UpdateInfo)
 	a.Put("/change-password", auth.ChangePassword)
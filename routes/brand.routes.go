@@ -14,7 +14,7 @@ func setupBrandRoutes(api fiber.Router) {
 	br.Get("/all/paginate/province/:province_uuid", brand.GetPaginatedBrandsByProvinceUUID)
 	br.Get("/all/provinces/:province_uuid", brand.GetAllBrandsByProvince)
 	br.Get("/get/:uuid", brand.GetOneBrand)
-	br.Post("/create", brand.CreateBrand)
-	br.Put("/update/:uuid", brand.UpdateBrand)
-	br.Delete("/delete/:uuid", brand.DeleteBrand)
+	br.Post("/create", adminOnly, brand.CreateBrand)
+	br.Put("/update/:uuid", adminOnly, brand.UpdateBrand)
+	br.Delete("/delete/:uuid", adminOnly, brand.DeleteBrand)
 }
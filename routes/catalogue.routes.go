@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/category"
+	"github.com/danny19977/mspos-api-v3/controllers/manufacturer"
+	"github.com/danny19977/mspos-api-v3/controllers/sku"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupCatalogueRoutes(api fiber.Router) {
+	// Category controller
+	ca := api.Group("/categories")
+	ca.Get("/all", category.GetAllCategories)
+	ca.Get("/all/paginate", category.GetPaginatedCategories)
+	ca.Get("/get/:uuid", category.GetOneCategory)
+	ca.Post("/create", adminOnly, category.CreateCategory)
+	ca.Put("/update/:uuid", adminOnly, category.UpdateCategory)
+	ca.Delete("/delete/:uuid", adminOnly, category.DeleteCategory)
+
+	// Manufacturer controller
+	ma := api.Group("/manufacturers")
+	ma.Get("/all", manufacturer.GetAllManufacturers)
+	ma.Get("/all/paginate", manufacturer.GetPaginatedManufacturers)
+	ma.Get("/get/:uuid", manufacturer.GetOneManufacturer)
+	ma.Post("/create", adminOnly, manufacturer.CreateManufacturer)
+	ma.Put("/update/:uuid", adminOnly, manufacturer.UpdateManufacturer)
+	ma.Delete("/delete/:uuid", adminOnly, manufacturer.DeleteManufacturer)
+
+	// SKU controller
+	sk := api.Group("/skus")
+	sk.Get("/all", sku.GetAllSkus)
+	sk.Get("/all/paginate", sku.GetPaginatedSkus)
+	sk.Get("/get/:uuid", sku.GetOneSku)
+	sk.Post("/create", adminOnly, sku.CreateSku)
+	sk.Put("/update/:uuid", adminOnly, sku.UpdateSku)
+	sk.Delete("/delete/:uuid", adminOnly, sku.DeleteSku)
+}
@@ -1,13 +1,19 @@
 package routes
 
 import (
+	"time"
+
 	"github.com/danny19977/mspos-api-v3/controllers/dashboard"
 	ndindividual "github.com/danny19977/mspos-api-v3/controllers/nd_individual"
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/gofiber/fiber/v2"
 )
 
 func setupDashboardRoutes(api fiber.Router) {
-	dash := api.Group("/dashboard")
+	// Territory params are pinned to the caller's scope for every dashboard,
+	// then responses are cached until a visit of their territory and period
+	// changes (or 10 minutes for what visits do not drive)
+	dash := api.Group("/dashboard", middlewares.ScopeQueryParams, middlewares.CacheResponses(10*time.Minute))
 
 	// ── ND Dashboard ──────────────────────────────────────────────────────────
 	// Numeric Distribution: ND% = (POS w/ brand counter>0) / total POS visited
@@ -180,6 +186,8 @@ func setupDashboardRoutes(api fiber.Router) {
 	sish.Get("/summary-kpi", dashboard.SISHSummaryKPI)       // executive KPI + entropy
 	sish.Get("/brand-ranking", dashboard.SISHBrandRanking)   // Pareto ranking + category
 	sish.Get("/velocity-index", dashboard.SISHVelocityIndex) // sell-through speed + stock_turn_days
+	sish.Get("/sell-out", dashboard.SISHSellOut)             // sell-out estimated from stock movements vs reported
+	sish.Get("/sell-out-gaps", dashboard.SISHSellOutGaps)    // visits whose Sold is far from the estimate (?tolerance=30)
 
 	// Section 5 — Advanced Analytics
 	sish.Get("/heatmap", dashboard.SISHHeatmap)                     // brand × territory SISH% matrix (?level=...)
@@ -192,6 +200,9 @@ func setupDashboardRoutes(api fiber.Router) {
 	gm := dash.Group("/google-map")
 	gm.Get("/view", dashboard.GoogleMaps)
 
+	// Route plan compliance: planned vs visited POS per agent / DR / Supervisor / ASM
+	dash.Get("/route-compliance", dashboard.RouteComplianceReport)
+
 	// Sales Evolution Dashboard
 	se := dash.Group("/sales-evolution")
 
@@ -228,19 +239,26 @@ func setupDashboardRoutes(api fiber.Router) {
 	// Single KPI card: farde, sold, revenue, visits, active POS & agents
 	se.Get("/summary-kpi", dashboard.SalesSummaryKPI)
 
+	// Price compliance: shelf price captured per brand line vs the RRP
+	pc := dash.Group("/price-compliance")
+	pc.Get("/summary", dashboard.PriceComplianceSummary)            // POS above / at / below the RRP
+	pc.Get("/dispersion-commune", dashboard.PriceDispersionCommune) // price spread per commune
+	pc.Get("/trend", dashboard.PriceTrend)                          // price vs RRP over time
+
 	// ── ND Individuel ────────────────────────────────────────────────────────
-	// Permet à chaque agent de consulter et défendre son propre ND
-	ndi := api.Group("/nd-individual")
-	ndi.Get("/summary/:user_uuid", ndindividual.GetNDSummary)  // KPI global de l'agent
-	ndi.Get("/by-brand/:user_uuid", ndindividual.GetNDByBrand) // ND par marque
-	ndi.Get("/pos-list/:user_uuid", ndindividual.GetNDPosList) // Liste POS visités + ND
+	// Permet à chaque agent de consulter et défendre son propre ND ; les
+	// autres ne lisent que les agents de leur territoire
+	ndi := api.Group("/nd-individual", middlewares.ScopeQueryParams)
+	ndi.Get("/summary/:user_uuid", middlewares.ScopeUserParam, ndindividual.GetNDSummary)  // KPI global de l'agent
+	ndi.Get("/by-brand/:user_uuid", middlewares.ScopeUserParam, ndindividual.GetNDByBrand) // ND par marque
+	ndi.Get("/pos-list/:user_uuid", middlewares.ScopeUserParam, ndindividual.GetNDPosList) // Liste POS visités + ND
 
 	// ── SOS Individuel ───────────────────────────────────────────────────────
 	// Permet à chaque agent de consulter et défendre son propre SOS (Share of Stock)
-	sosi := api.Group("/sos-individual")
-	sosi.Get("/summary/:user_uuid", ndindividual.GetSOSSummary)  // KPI global de l'agent
-	sosi.Get("/by-brand/:user_uuid", ndindividual.GetSOSByBrand) // SOS par marque
-	sosi.Get("/pos-list/:user_uuid", ndindividual.GetSOSPosList) // Liste POS visités + fardes
+	sosi := api.Group("/sos-individual", middlewares.ScopeQueryParams)
+	sosi.Get("/summary/:user_uuid", middlewares.ScopeUserParam, ndindividual.GetSOSSummary)  // KPI global de l'agent
+	sosi.Get("/by-brand/:user_uuid", middlewares.ScopeUserParam, ndindividual.GetSOSByBrand) // SOS par marque
+	sosi.Get("/pos-list/:user_uuid", middlewares.ScopeUserParam, ndindividual.GetSOSPosList) // Liste POS visités + fardes
 
 	// KPI Dashboard
 	kp := dash.Group("/kpi")
@@ -259,4 +277,16 @@ func setupDashboardRoutes(api fiber.Router) {
 	kp.Get("/user-visit-summary", dashboard.KpiUserVisitSummary)
 	kp.Get("/export-excel", dashboard.ExportKPIExcel)
 
+	// XLSX / CSV export of any view above, with its params, e.g.
+	// /dashboard/numeric-distribution/table-view-area/export?format=csv
+	dash.Get("/:metric/:view/export", dashboard.ExportDashboardView)
+
+	// Monthly review of a territory as PDF, e.g.
+	// /dashboard/executive-report/pdf?country_uuid=…&month=2026-09
+	dash.Get("/executive-report/pdf", dashboard.ExportExecutivePDF)
+
+	// Any distribution metric × view through the metric engine, e.g.
+	// /dashboard/share-of-stock/bar-chart?level=area
+	// Registered last so the routes above keep precedence.
+	dash.Get("/:metric/:view", dashboard.MetricView)
 }
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/delivery"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupDeliveryRoutes(api fiber.Router) {
+	// Stock delivered to the POS, used by the sell-out estimate
+	dl := api.Group("/deliveries")
+	dl.Get("/all/paginate", delivery.GetPaginatedDeliveries)
+	dl.Get("/all/pos/:pos_uuid", delivery.GetAllDeliveriesByPos)
+	dl.Get("/get/:uuid", delivery.GetOneDelivery)
+	dl.Post("/create", fieldAgents, delivery.CreateDelivery)
+	dl.Put("/update/:uuid", fieldAgents, delivery.UpdateDelivery)
+	dl.Delete("/delete/:uuid", provinceLead, delivery.DeleteDelivery)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/exchangerate"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupExchangeRateRoutes(api fiber.Router) {
+	// FX rates converting revenue between currencies
+	fx := api.Group("/exchange-rates")
+	fx.Get("/all", exchangerate.GetAllExchangeRates)
+	fx.Get("/all/paginate", exchangerate.GetPaginatedExchangeRates)
+	fx.Get("/get/:uuid", exchangerate.GetOneExchangeRate)
+	fx.Post("/create", adminOnly, exchangerate.CreateExchangeRate)
+	fx.Put("/update/:uuid", adminOnly, exchangerate.UpdateExchangeRate)
+	fx.Delete("/delete/:uuid", adminOnly, exchangerate.DeleteExchangeRate)
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/exportjob"
+	"github.com/danny19977/mspos-api-v3/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupExportRoutes(api fiber.Router) {
+	// Asynchronous report exports. The query params of a job are pinned
+	// to the caller's scope when it is submitted.
+	ex := api.Group("/exports", middlewares.ScopeQueryParams)
+	ex.Get("/all", exportjob.GetExportJobs)
+	ex.Get("/get/:uuid", exportjob.GetExportJob)
+	ex.Get("/download/:uuid", exportjob.DownloadExportJob)
+	ex.Post("/create/:kind", exportjob.CreateExportJob)
+	ex.Delete("/delete/:uuid", exportjob.DeleteExportJob)
+}
@@ -16,9 +16,9 @@ func setupGeographicRoutes(api fiber.Router) {
 	co.Get("/all/paginate", country.GetPaginatedCountry)
 	// co.Get("/all/dropdown", country.GetCountryDropdown)
 	co.Get("/get/:uuid", country.GetCountry)
-	co.Post("/create", country.CreateCountry)
-	co.Put("/update/:uuid", country.UpdateCountry)
-	co.Delete("/delete/:uuid", country.DeleteCountry)
+	co.Post("/create", adminOnly, country.CreateCountry)
+	co.Put("/update/:uuid", adminOnly, country.UpdateCountry)
+	co.Delete("/delete/:uuid", adminOnly, country.DeleteCountry)
 
 	// Province controller
 	prov := api.Group("/provinces")
@@ -29,9 +29,9 @@ func setupGeographicRoutes(api fiber.Router) {
 	prov.Get("/all/:country_uuid", province.GetAllProvinceByCountry)
 	prov.Get("/get/:uuid", province.GetProvince)
 	prov.Get("/get-by/:uuid", province.GetProvinceByName)
-	prov.Post("/create", province.CreateProvince)
-	prov.Put("/update/:uuid", province.UpdateProvince)
-	prov.Delete("/delete/:uuid", province.DeleteProvince)
+	prov.Post("/create", adminOnly, province.CreateProvince)
+	prov.Put("/update/:uuid", adminOnly, province.UpdateProvince)
+	prov.Delete("/delete/:uuid", adminOnly, province.DeleteProvince)
 
 	// Areas controller
 	ar := api.Group("/areas")
@@ -45,9 +45,9 @@ func setupGeographicRoutes(api fiber.Router) {
 	ar.Get("/all-area/:id", area.GetSupAreaByID)
 	ar.Get("/get/:uuid", area.GetArea)
 	ar.Get("/get-by/:uuid", area.GetAreaByName)
-	ar.Post("/create", area.CreateArea)
-	ar.Put("/update/:uuid", area.UpdateArea)
-	ar.Delete("/delete/:uuid", area.DeleteArea)
+	ar.Post("/create", adminOnly, area.CreateArea)
+	ar.Put("/update/:uuid", adminOnly, area.UpdateArea)
+	ar.Delete("/delete/:uuid", adminOnly, area.DeleteArea)
 
 	//SubArea controller
 	sa := api.Group("/subareas")
@@ -60,9 +60,9 @@ func setupGeographicRoutes(api fiber.Router) {
 	sa.Get("/all/:area_uuid", Subarea.GetAllDataBySubAreaByAreaUUID)
 	sa.Get("/get/:uuid", Subarea.GetSubArea)
 	sa.Get("/get-by/:uuid", Subarea.GetSubAreaByName)
-	sa.Post("/create", Subarea.CreateSubArea)
-	sa.Put("/update/:uuid", Subarea.UpdateSubArea)
-	sa.Delete("/delete/:uuid", Subarea.DeleteSubarea)
+	sa.Post("/create", adminOnly, Subarea.CreateSubArea)
+	sa.Put("/update/:uuid", adminOnly, Subarea.UpdateSubArea)
+	sa.Delete("/delete/:uuid", adminOnly, Subarea.DeleteSubarea)
 
 	// Commune controller
 	com := api.Group("/communes")
@@ -76,7 +76,7 @@ func setupGeographicRoutes(api fiber.Router) {
 	com.Get("/all/:sub_area_uuid", commune.GetAllCommunesBySubAreaUUID)
 	com.Get("/all/:id", commune.GetCommune)
 	com.Get("/get/:uuid", commune.GetCommune)
-	com.Post("/create", commune.CreateCommune)
-	com.Put("/update/:uuid", commune.UpdateCommune)
-	com.Delete("/delete/:uuid", commune.DeleteCommune)
+	com.Post("/create", adminOnly, commune.CreateCommune)
+	com.Put("/update/:uuid", adminOnly, commune.UpdateCommune)
+	com.Delete("/delete/:uuid", adminOnly, commune.DeleteCommune)
 }
@@ -16,9 +16,9 @@ func setupHierarchyRoutes(api fiber.Router) {
 	ma.Get("/all/paginate", manager.GetPaginatedManager)
 	ma.Get("/get/:uuid", manager.GetManager)
 	// ma.Get("/all/:id", manager.GetManagerByID)
-	ma.Post("/create", manager.CreateManager)
-	ma.Put("/update/:uuid", manager.UpdateManager)
-	ma.Delete("/delete/:uuid", manager.DeleteManager)
+	ma.Post("/create", adminOnly, manager.CreateManager)
+	ma.Put("/update/:uuid", adminOnly, manager.UpdateManager)
+	ma.Delete("/delete/:uuid", adminOnly, manager.DeleteManager)
 
 	// ASM controller
 	as := api.Group("/asms")
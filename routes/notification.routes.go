@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/notification"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupNotificationRoutes(api fiber.Router) {
+	// Inbox of the caller, and its real-time feed as Server-Sent Events
+	nt := api.Group("/notifications")
+	nt.Get("/all", notification.GetNotifications)
+	nt.Get("/unread-count", notification.GetUnreadCount)
+	nt.Get("/stream", notification.Stream)
+	nt.Put("/read/:uuid", notification.MarkRead)
+	nt.Put("/read-all", notification.MarkAllRead)
+}
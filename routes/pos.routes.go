@@ -24,18 +24,19 @@ func setupPosRoutes(api fiber.Router) {
 	po.Get("/all/cyclo/:user_uuid", pos.GetAllPosByCyclo)
 	po.Get("/export/excel", pos.GeneratePosExcelReport)
 	po.Get("/map-pos/:pos_uuid", pos.MapPos)
-	po.Post("/create", pos.CreatePos)
+	po.Post("/create", fieldAgents, pos.CreatePos)
 	po.Get("/get/:uuid", pos.GetPos)
-	po.Put("/update/:uuid", pos.UpdatePos)
-	po.Delete("/delete/:uuid", pos.DeletePos)
+	po.Put("/update/:uuid", fieldAgents, pos.UpdatePos)
+	po.Delete("/delete/:uuid", provinceLead, pos.DeletePos)
+	po.Put("/location/:uuid", supervisors, pos.SetPosLocation)
 
 	// POSEQUIPEMENT controller
 	pe := api.Group("/pos-equipements")
 	pe.Get("/all/paginate/:pos_uuid", posequiment.GetPaginatedPosEquipmentByPos)
 	pe.Get("/all", posequiment.GetAllPosEquipments)
-	pe.Post("/create", posequiment.CreatePosEquipment)
+	pe.Post("/create", fieldAgents, posequiment.CreatePosEquipment)
 	pe.Get("/get/:uuid", posequiment.GetAllPosEquipments)
 	pe.Get("/get/:uuid", posequiment.GetPosEquipment)
-	pe.Put("/update/:uuid", posequiment.UpdatePosEquipment)
-	pe.Delete("/delete/:uuid", posequiment.DeletePosEquipment)
+	pe.Put("/update/:uuid", fieldAgents, posequiment.UpdatePosEquipment)
+	pe.Delete("/delete/:uuid", provinceLead, posequiment.DeletePosEquipment)
 }
@@ -20,10 +20,13 @@ func setupPosFormRoutes(api fiber.Router) {
 	posf.Get("/all/paginate/user/:user_uuid", posform.GetPaginatedPosFormByUserUUID)
 	posf.Get("/all", posform.GetAllPosforms)
 	posf.Get("/export/excel", posform.GeneratePosFormExcelReport)
-	posf.Post("/create", posform.CreatePosform)
+	posf.Post("/create", fieldAgents, posform.CreatePosform)
+	posf.Post("/submit", fieldAgents, posform.SubmitVisit)
 	posf.Get("/get/:uuid", posform.GetPosForm)
-	posf.Put("/update/:uuid", posform.UpdatePosform)
-	posf.Delete("/delete/:uuid", posform.DeletePosform)
+	posf.Put("/update/:uuid", fieldAgents, posform.UpdatePosform)
+	posf.Put("/check-in/:uuid", fieldAgents, posform.CheckInVisit)
+	posf.Put("/check-out/:uuid", fieldAgents, posform.CheckOutVisit)
+	posf.Delete("/delete/:uuid", provinceLead, posform.DeletePosform)
 
 	// POSformItem controller
 	posfi := api.Group("/posform-items")
@@ -31,7 +34,7 @@ func setupPosFormRoutes(api fiber.Router) {
 	posfi.Get("/all/paginate", PosFormItem.GetPaginatedPosformItem)
 	posfi.Get("/all/:pos_form_uuid", PosFormItem.GetAllPosFormItemsByUUID)
 	// posfi.Get("/get/:uuid", PosFormItem.Get)
-	posfi.Post("/create", PosFormItem.CreatePosformItem)
-	posfi.Put("/update/:uuid", PosFormItem.UpdatePosformItem)
-	posfi.Delete("/delete/:uuid", PosFormItem.DeletePosformItem)
+	posfi.Post("/create", fieldAgents, PosFormItem.CreatePosformItem)
+	posfi.Put("/update/:uuid", fieldAgents, PosFormItem.UpdatePosformItem)
+	posfi.Delete("/delete/:uuid", provinceLead, PosFormItem.DeletePosformItem)
 }
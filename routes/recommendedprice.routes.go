@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/recommendedprice"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupRecommendedPriceRoutes(api fiber.Router) {
+	// Recommended retail prices (RRP) per brand and territory
+	rp := api.Group("/recommended-prices")
+	rp.Get("/all", recommendedprice.GetAllRecommendedPrices)
+	rp.Get("/all/paginate", recommendedprice.GetPaginatedRecommendedPrices)
+	rp.Get("/get/:uuid", recommendedprice.GetOneRecommendedPrice)
+	rp.Post("/create", adminOnly, recommendedprice.CreateRecommendedPrice)
+	rp.Put("/update/:uuid", adminOnly, recommendedprice.UpdateRecommendedPrice)
+	rp.Delete("/delete/:uuid", adminOnly, recommendedprice.DeleteRecommendedPrice)
+}
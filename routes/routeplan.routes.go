@@ -18,16 +18,19 @@ func setupRoutePlanRoutes(api fiber.Router) {
 	rp.Get("/all/:uuid", routeplan.GetRouteplan)
 	rp.Get("/get-by-user/:user_uuid", routeplan.GetRouteplanByUserUUID)
 	rp.Get("/get/:uuid", routeplan.GetRouteplan)
-	rp.Post("/create", routeplan.CreateRouteplan)
-	rp.Put("/update/:uuid", routeplan.UpdateRouteplan)
-	rp.Delete("/delete/:uuid", routeplan.DeleteRouteplan)
+	rp.Post("/create", planners, routeplan.CreateRouteplan)
+	rp.Post("/generate", planners, routeplan.GenerateRouteplan)
+	rp.Put("/publish/:uuid", planners, routeplan.PublishRouteplan)
+	rp.Put("/optimize/:uuid", fieldAgents, routeplan.OptimizeRouteplan)
+	rp.Put("/update/:uuid", planners, routeplan.UpdateRouteplan)
+	rp.Delete("/delete/:uuid", planners, routeplan.DeleteRouteplan)
 
 	// routeplanitem controller
 	rpi := api.Group("/routeplan-items")
 	rpi.Get("/all/paginate", RoutePlanItem.GetPaginatedRoutePlanItem)
 	rpi.Get("/all/:route_plan_uuid", RoutePlanItem.GetAllRoutePlanItem)
 	rpi.Get("/get/:uuid", RoutePlanItem.GetOneByRouteItermUUID)
-	rpi.Post("/create", RoutePlanItem.CreateRoutePlanItem)
-	rpi.Put("/update/:uuid", RoutePlanItem.UpdateRoutePlanItem)
-	rpi.Delete("/delete/:uuid", RoutePlanItem.DeleteRoutePlanItem)
+	rpi.Post("/create", planners, RoutePlanItem.CreateRoutePlanItem)
+	rpi.Put("/update/:uuid", fieldAgents, RoutePlanItem.UpdateRoutePlanItem)
+	rpi.Delete("/delete/:uuid", planners, RoutePlanItem.DeleteRoutePlanItem)
 }
@@ -1,23 +1,62 @@
 package routes
 
 import (
+	"github.com/danny19977/mspos-api-v3/middlewares"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 )
 
+// Role guards shared by the route groups. They run after
+// middlewares.IsAuthenticated, which is mounted on every group below
+// except the public auth endpoints.
+var (
+	// Reference data (territories, brands, users, managers)
+	adminOnly = middlewares.HasRole(middlewares.RoleSupport, middlewares.RoleManager)
+
+	// Deleting field data
+	provinceLead = middlewares.HasRole(middlewares.RoleSupport, middlewares.RoleManager, middlewares.RoleASM)
+
+	// Fixing a POS location
+	supervisors = middlewares.HasRole(middlewares.RoleSupport, middlewares.RoleManager, middlewares.RoleASM,
+		middlewares.RoleSupervisor)
+
+	// Route planning
+	planners = middlewares.HasRole(middlewares.RoleSupport, middlewares.RoleManager, middlewares.RoleASM,
+		middlewares.RoleSupervisor, middlewares.RoleDR)
+
+	// Field data capture (POS, visit forms)
+	fieldAgents = middlewares.HasRole(middlewares.RoleSupport, middlewares.RoleManager, middlewares.RoleASM,
+		middlewares.RoleSupervisor, middlewares.RoleDR, middlewares.RoleCyclo)
+)
+
 func Setup(app *fiber.App) {
 	api := app.Group("/api", logger.New())
 
-	// Setup all route groups
+	// Public auth endpoints are registered before the JWT middleware
 	setupAuthRoutes(api)
+
+	api.Use(middlewares.IsAuthenticated)
+
+	// Setup all route groups
 	setupUsersRoutes(api)
 	setupGeographicRoutes(api)
 	setupHierarchyRoutes(api)
 	setupPosRoutes(api)
+	setupSegmentationRoutes(api)
 	setupRoutePlanRoutes(api)
 	setupBrandRoutes(api)
+	setupCatalogueRoutes(api)
+	setupTargetRoutes(api)
+	setupRecommendedPriceRoutes(api)
+	setupExchangeRateRoutes(api)
+	setupDeliveryRoutes(api)
 	setupPosFormRoutes(api)
 	setupObservationRoutes(api)
 	setupUserLogsRoutes(api)
 	setupDashboardRoutes(api)
+	setupSyncRoutes(api)
+	setupExportRoutes(api)
+	setupSubscriptionRoutes(api)
+	setupNotificationRoutes(api)
+	setupAlertRoutes(api)
 }
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/segmentation"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupSegmentationRoutes(api fiber.Router) {
+	// A/B/C classification of the POS, run in the background
+	sg := api.Group("/segmentation")
+	sg.Get("/configs/all", segmentation.GetConfigs)
+	sg.Get("/configs/get/:uuid", segmentation.GetConfig)
+	sg.Post("/configs/create", adminOnly, segmentation.CreateConfig)
+	sg.Put("/configs/update/:uuid", adminOnly, segmentation.UpdateConfig)
+	sg.Delete("/configs/delete/:uuid", adminOnly, segmentation.DeleteConfig)
+	sg.Post("/run", adminOnly, segmentation.RunSegmentation)
+	sg.Get("/history/:pos_uuid", segmentation.GetPosSegmentHistory)
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/subscription"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupSubscriptionRoutes(api fiber.Router) {
+	// Reports emailed on a schedule. They are built as their owner, within
+	// the owner's scope at the time of sending.
+	sb := api.Group("/subscriptions")
+	sb.Get("/all", subscription.GetSubscriptions)
+	sb.Get("/get/:uuid", subscription.GetSubscription)
+	sb.Get("/deliveries/:uuid", subscription.GetDeliveries)
+	sb.Post("/create", subscription.CreateSubscription)
+	sb.Post("/send/:uuid", subscription.SendSubscription)
+	sb.Put("/update/:uuid", subscription.UpdateSubscription)
+	sb.Delete("/delete/:uuid", subscription.DeleteSubscription)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/mobilesync"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupSyncRoutes(api fiber.Router) {
+	// Mobile offline sync controller
+	sy := api.Group("/sync")
+	sy.Post("/push", fieldAgents, mobilesync.Push)
+	sy.Get("/pull", fieldAgents, mobilesync.Pull)
+}
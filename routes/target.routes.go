@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/danny19977/mspos-api-v3/controllers/target"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupTargetRoutes(api fiber.Router) {
+	// Target controller
+	tg := api.Group("/targets")
+	tg.Get("/all", target.GetAllTargets)
+	tg.Get("/all/paginate", target.GetPaginatedTargets)
+	tg.Get("/template", target.DownloadTargetTemplate)
+	tg.Get("/get/:uuid", target.GetOneTarget)
+	tg.Post("/create", adminOnly, target.CreateTarget)
+	tg.Post("/upload", adminOnly, target.UploadTargets)
+	tg.Put("/update/:uuid", adminOnly, target.UpdateTarget)
+	tg.Delete("/delete/:uuid", adminOnly, target.DeleteTarget)
+}
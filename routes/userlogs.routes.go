@@ -12,7 +12,7 @@ func setupUserLogsRoutes(api fiber.Router) {
 	log.Get("/all/paginate", user_logs.GetPaginatedUserLogs)
 	log.Get("/all/paginate/:user_uuid", user_logs.GetUserLogByID)
 	log.Get("/get/:uuid", user_logs.GetUserLog)
-	log.Post("/create", user_logs.CreateUserLog)
-	log.Put("/update/:uuid", user_logs.UpdateUserLog)
-	log.Delete("/delete/:uuid", user_logs.DeleteUserLog)
+	log.Post("/create", fieldAgents, user_logs.CreateUserLog)
+	log.Put("/update/:uuid", adminOnly, user_logs.UpdateUserLog)
+	log.Delete("/delete/:uuid", adminOnly, user_logs.DeleteUserLog)
 }
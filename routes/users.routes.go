@@ -12,9 +12,9 @@ func setupUsersRoutes(api fiber.Router) {
 	u.Get("/all/paginate/nosearch", user.GetPaginatedNoSerach)
 	u.Get("/all/:id", user.GetUserByID)
 	u.Get("/get/:uuid", user.GetUser)
-	u.Post("/create", user.CreateUser)
-	u.Put("/update/:uuid", user.UpdateUser)
-	u.Delete("/delete/:uuid", user.DeleteUser)
+	u.Post("/create", adminOnly, user.CreateUser)
+	u.Put("/update/:uuid", adminOnly, user.UpdateUser)
+	u.Delete("/delete/:uuid", adminOnly, user.DeleteUser)
 
 	// --- Custom UUID user routes ---
 	u.Get("/by-country/:country_uuid", user.GetUsersByCountryUUID)
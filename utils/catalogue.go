@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+// CheckItemSku checks that the SKU of a brand line, when given, is a SKU of
+// the brand of the line.
+func CheckItemSku(db *gorm.DB, it *models.PosFormItems) error {
+	it.SkuUUID = strings.TrimSpace(it.SkuUUID)
+	if it.SkuUUID == "" {
+		return nil
+	}
+	var sku models.Sku
+	if err := db.Select("uuid", "brand_uuid").Where("uuid = ?", it.SkuUUID).First(&sku).Error; err != nil {
+		return fmt.Errorf("unknown sku_uuid")
+	}
+	if sku.BrandUUID != it.BrandUUID {
+		return fmt.Errorf("sku_uuid is not a SKU of the brand of the line")
+	}
+	return nil
+}
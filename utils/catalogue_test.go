@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// skuTable is a connector whose skus table holds the SKUs of skuTable,
+// keyed by uuid to their brand.
+type skuTable map[string]string
+
+func (s skuTable) Connect(context.Context) (driver.Conn, error) { return s, nil }
+func (skuTable) Driver() driver.Driver                          { return nil }
+func (s skuTable) Prepare(string) (driver.Stmt, error)          { return skuStmt{s}, nil }
+func (skuTable) Close() error                                   { return nil }
+func (skuTable) Begin() (driver.Tx, error)                      { return nil, driver.ErrSkip }
+
+type skuStmt struct{ skus skuTable }
+
+func (skuStmt) Close() error                               { return nil }
+func (skuStmt) NumInput() int                              { return -1 }
+func (skuStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+
+// Query answers the lookup of the SKU of its first arg.
+func (s skuStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &skuRows{}
+	if brand, ok := s.skus[args[0].(string)]; ok {
+		rows.rows = [][]driver.Value{{args[0], brand}}
+	}
+	return rows, nil
+}
+
+type skuRows struct{ rows [][]driver.Value }
+
+func (*skuRows) Columns() []string { return []string{"uuid", "brand_uuid"} }
+func (*skuRows) Close() error      { return nil }
+func (r *skuRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestCheckItemSku(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sql.OpenDB(skuTable{"sku-33cl": "primus", "sku-50cl": "primus", "sku-can": "heineken"}),
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		brand, sku string
+		wantSku    string
+		wantErr    string
+	}{
+		{brand: "primus", sku: "", wantSku: ""},
+		{brand: "primus", sku: "   ", wantSku: ""},
+		{brand: "primus", sku: "sku-33cl", wantSku: "sku-33cl"},
+		{brand: "primus", sku: " sku-50cl\t", wantSku: "sku-50cl"},
+		{brand: "primus", sku: "sku-can", wantSku: "sku-can", wantErr: "sku_uuid is not a SKU of the brand of the line"},
+		{brand: "primus", sku: "sku-gone", wantSku: "sku-gone", wantErr: "unknown sku_uuid"},
+	}
+	for _, tt := range tests {
+		it := models.PosFormItems{BrandUUID: tt.brand, SkuUUID: tt.sku}
+		err := CheckItemSku(db, &it)
+		gotErr := ""
+		if err != nil {
+			gotErr = err.Error()
+		}
+		if gotErr != tt.wantErr || it.SkuUUID != tt.wantSku {
+			t.Errorf("%s line with sku %q: sku %q, error %q; want %q, %q", tt.brand, tt.sku, it.SkuUUID, gotErr, tt.wantSku, tt.wantErr)
+		}
+	}
+}
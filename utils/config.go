@@ -3,6 +3,7 @@ package utils
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -15,4 +16,13 @@ func Env(key string) string {
 		fmt.Print("Error loading .env file")
 	}
 	return os.Getenv(key)
-}
\ No newline at end of file
+}
+
+// EnvInt reads a positive integer setting, or def when unset or invalid.
+func EnvInt(key string, def int) int {
+	n, err := strconv.Atoi(Env(key))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
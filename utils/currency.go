@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+// CountryCurrency returns the currency of the country, the default currency
+// when unknown.
+func CountryCurrency(db *gorm.DB, countryUUID string) string {
+	var currency string
+	if countryUUID != "" {
+		db.Model(&models.Country{}).Where("uuid = ?", countryUUID).Select("currency").Scan(&currency)
+	}
+	if currency == "" {
+		return models.DefaultCurrency
+	}
+	return currency
+}
+
+// VisitCurrency returns the currency of the price of the visit: its own,
+// else the currency of its country.
+func VisitCurrency(db *gorm.DB, pf *models.PosForm) string {
+	if pf.Currency != "" {
+		return pf.Currency
+	}
+	return CountryCurrency(db, pf.CountryUUID)
+}
+
+// VisitCurrencyOf returns the VisitCurrency of the visit posFormUUID.
+func VisitCurrencyOf(db *gorm.DB, posFormUUID string) string {
+	var pf models.PosForm
+	db.Select("currency", "country_uuid").Where("uuid = ?", posFormUUID).Find(&pf)
+	return VisitCurrency(db, &pf)
+}
+
+// NormalizeFormCurrency validates the currency of the visit price, left
+// empty for the currency of the country.
+func NormalizeFormCurrency(pf *models.PosForm) error {
+	if strings.TrimSpace(pf.Currency) == "" {
+		pf.Currency = ""
+		return nil
+	}
+	currency, err := NormalizeCurrency(pf.Currency)
+	if err != nil {
+		return err
+	}
+	pf.Currency = currency
+	return nil
+}
+
+// ResolveCurrency returns the currency revenue is reported in: the
+// requested one, else the currency of the country, else the default one.
+func ResolveCurrency(db *gorm.DB, requested, countryUUID string) (string, error) {
+	if strings.TrimSpace(requested) != "" {
+		return NormalizeCurrency(requested)
+	}
+	return CountryCurrency(db, countryUUID), nil
+}
+
+// FormCurrencySQL is the SQL expression of the currency of the price of
+// the visit alias.
+func FormCurrencySQL(alias string) string {
+	return fmt.Sprintf(`COALESCE(NULLIF(%[1]s.currency, ''),
+			(SELECT cur.currency FROM countries cur WHERE cur.uuid = %[1]s.country_uuid), '%[2]s')`,
+		alias, models.DefaultCurrency)
+}
+
+// FXJoin returns a LEFT JOIN LATERAL adding alias.rate, the rate converting
+// an amount in the currency from to the currency to on the date day, all
+// three SQL expressions. The rate is 1 between a currency and itself, and
+// null when no exchange rate is known at that date: converted amounts are
+// then left out of sums.
+func FXJoin(alias, from, day, to string) string {
+	return `LEFT JOIN LATERAL (
+			SELECT CASE WHEN src.code = tgt.code THEN 1 ELSE (
+				SELECT CASE WHEN er.base_currency = src.code THEN er.rate ELSE 1 / NULLIF(er.rate, 0) END
+				FROM exchange_rates er
+				WHERE er.date <= ` + day + `
+				  AND ((er.base_currency = src.code AND er.quote_currency = tgt.code)
+				    OR (er.base_currency = tgt.code AND er.quote_currency = src.code))
+				  AND er.deleted_at IS NULL
+				ORDER BY er.date DESC, er.updated_at DESC
+				LIMIT 1
+			) END::float8 AS rate
+			FROM (SELECT ` + from + ` AS code) src, (SELECT CAST(` + to + ` AS varchar) AS code) tgt
+		) ` + alias + ` ON true`
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestNormalizeFormCurrency(t *testing.T) {
+	pf := models.PosForm{Currency: "  "}
+	if err := NormalizeFormCurrency(&pf); err != nil || pf.Currency != "" {
+		t.Errorf("blank currency: %q, %v, want empty for the country's", pf.Currency, err)
+	}
+	pf.Currency = "usd"
+	if err := NormalizeFormCurrency(&pf); err != nil || pf.Currency != "USD" {
+		t.Errorf("usd: %q, %v, want USD", pf.Currency, err)
+	}
+	pf.Currency = "dollar"
+	if err := NormalizeFormCurrency(&pf); err == nil {
+		t.Error("dollar: no error")
+	}
+}
+
+func TestResolveCurrency(t *testing.T) {
+	db, rec := dryRun(t)
+
+	if got, err := ResolveCurrency(db, " eur ", "cd"); err != nil || got != "EUR" {
+		t.Errorf("requested eur = %q, %v, want EUR", got, err)
+	}
+	if _, err := ResolveCurrency(db, "euro", "cd"); err == nil {
+		t.Error("requested euro: no error")
+	}
+	if len(rec.statements) != 0 {
+		t.Errorf("a requested currency looked up the country: %q", rec.statements)
+	}
+
+	// The dry run finds no country: the default currency
+	if got, _ := ResolveCurrency(db, "", "cd"); got != models.DefaultCurrency {
+		t.Errorf("currency of an unknown country = %q, want %s", got, models.DefaultCurrency)
+	}
+	if len(rec.statements) != 1 || !strings.Contains(rec.statements[0], `SELECT "currency" FROM "countries" WHERE uuid = 'cd'`) {
+		t.Errorf("statements = %q, want the currency of country cd", rec.statements)
+	}
+	if got := VisitCurrency(db, &models.PosForm{Currency: "USD", CountryUUID: "cd"}); got != "USD" {
+		t.Errorf("currency of a visit priced in USD = %q", got)
+	}
+}
+
+func TestFXJoin(t *testing.T) {
+	join := FXJoin("fx", "pfi.currency", "pf.created_at::date", "@currency")
+	for _, want := range []string{
+		"WHERE er.date <= pf.created_at::date",
+		"FROM (SELECT pfi.currency AS code) src, (SELECT CAST(@currency AS varchar) AS code) tgt",
+		"WHEN src.code = tgt.code THEN 1",
+		// A rate quoted the other way round is inverted
+		"ELSE 1 / NULLIF(er.rate, 0)",
+	} {
+		if !strings.Contains(join, want) {
+			t.Errorf("FXJoin misses %s", want)
+		}
+	}
+	if !strings.HasSuffix(join, ") fx ON true") {
+		t.Errorf("FXJoin does not alias its rate fx: …%s", join[len(join)-20:])
+	}
+}
@@ -0,0 +1,430 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DailyFactsVersion is the version of the definition of the daily facts.
+// Bump it when a change of the aggregation makes the stored facts stale:
+// EnsureDailyFacts rebuilds them at the next start.
+//
+//	1 — first definition
+//	2 — shares within the category of the brand, group facts
+//	3 — POS segment
+//	4 — POS facts
+const DailyFactsVersion = 4
+
+// dailyFactsLock is the advisory lock of the daily facts: refreshes hold it
+// shared, rebuilds exclusively. Each agent × day is also locked by its
+// refresh, under dailyFactsLockClass, so that concurrent writes replace
+// its facts one after the other.
+const (
+	dailyFactsLock      = 7301920412
+	dailyFactsLockClass = 7301
+)
+
+// dailyFactForms selects the visits matched by the %s condition on
+// pos_forms pf, with their day, POS type, segment and the fardes and sold
+// of their lines: the forms CTE of the daily facts.
+var dailyFactForms = `
+		SELECT
+			pf.uuid,
+			pf.pos_uuid,
+			pf.price,
+			DATE(pf.created_at)     AS day,
+			pf.commune_uuid,
+			pf.user_uuid,
+			COALESCE(p.postype, '') AS postype,
+			` + PosSegmentAtSQL("pf.pos_uuid", "pf.created_at") + ` AS segment,
+			pf.country_uuid,
+			pf.province_uuid,
+			pf.area_uuid,
+			pf.sub_area_uuid,
+			COALESCE(t.fardes, 0)   AS fardes,
+			COALESCE(t.sold, 0)     AS sold
+		FROM pos_forms pf
+		LEFT JOIN pos p ON p.uuid = pf.pos_uuid
+		LEFT JOIN LATERAL (
+			SELECT SUM(number_farde) AS fardes, SUM(sold) AS sold
+			FROM pos_form_items
+			WHERE pos_form_uuid = pf.uuid AND deleted_at IS NULL
+		) t ON true
+		WHERE pf.deleted_at IS NULL AND %s`
+
+// dailyFactsInsert aggregates the visits selected by the %s condition on
+// pos_forms pf into daily_facts: one all-brands row and one row per brand
+// for each day × commune × agent × POS type × segment. The shares of a
+// brand row are within the category of the brand.
+var dailyFactsInsert = `
+	INSERT INTO daily_facts (
+		day, commune_uuid, user_uuid, brand_uuid, postype, segment,
+		country_uuid, province_uuid, area_uuid, sub_area_uuid,
+		visits, pos_count, fardes, sold, brand_present, revenue, fardes_share, basket_sold
+	)
+	WITH forms AS (` + dailyFactForms + `
+	),
+	category_totals AS (
+		SELECT
+			pfi.pos_form_uuid               AS form_uuid,
+			COALESCE(b.category_uuid, '')   AS category_uuid,
+			SUM(pfi.number_farde)           AS fardes,
+			SUM(pfi.sold)                   AS sold
+		FROM forms f
+		INNER JOIN pos_form_items pfi ON pfi.pos_form_uuid = f.uuid AND pfi.deleted_at IS NULL
+		LEFT JOIN brands b ON b.uuid = pfi.brand_uuid
+		WHERE pfi.brand_uuid <> ''
+		GROUP BY 1, 2
+	)
+	SELECT
+		day, commune_uuid, user_uuid, '', postype, segment,
+		MAX(country_uuid), MAX(province_uuid), MAX(area_uuid), MAX(sub_area_uuid),
+		COUNT(*),
+		COUNT(DISTINCT pos_uuid),
+		SUM(fardes),
+		SUM(sold),
+		COUNT(*) FILTER (WHERE fardes > 0),
+		SUM(price),
+		100.0 * COUNT(*) FILTER (WHERE fardes > 0),
+		SUM(sold)
+	FROM forms
+	GROUP BY day, commune_uuid, user_uuid, postype, segment
+	UNION ALL
+	SELECT
+		f.day, f.commune_uuid, f.user_uuid, pfi.brand_uuid, f.postype, f.segment,
+		MAX(f.country_uuid), MAX(f.province_uuid), MAX(f.area_uuid), MAX(f.sub_area_uuid),
+		COUNT(pfi.uuid),
+		COUNT(DISTINCT f.pos_uuid),
+		SUM(pfi.number_farde),
+		SUM(pfi.sold),
+		COUNT(pfi.uuid) FILTER (WHERE pfi.number_farde > 0),
+		0,
+		COALESCE(SUM(pfi.number_farde * 100.0 / NULLIF(ct.fardes, 0)), 0),
+		COALESCE(SUM(ct.sold) FILTER (WHERE pfi.sold > 0), 0)
+	FROM forms f
+	INNER JOIN pos_form_items pfi ON pfi.pos_form_uuid = f.uuid AND pfi.deleted_at IS NULL
+	LEFT JOIN brands b ON b.uuid = pfi.brand_uuid
+	LEFT JOIN category_totals ct ON ct.form_uuid = f.uuid AND ct.category_uuid = COALESCE(b.category_uuid, '')
+	WHERE pfi.brand_uuid <> ''
+	GROUP BY f.day, f.commune_uuid, f.user_uuid, pfi.brand_uuid, f.postype, f.segment`
+
+// dailyGroupFactsInsert aggregates the visits selected by the %s condition
+// on pos_forms pf into daily_group_facts: one row per group of brands for
+// each day × commune × agent × POS type × segment, see models.DailyGroupFact.
+var dailyGroupFactsInsert = `
+	INSERT INTO daily_group_facts (
+		day, commune_uuid, user_uuid, postype, segment, group_by, group_key, category_uuid,
+		country_uuid, province_uuid, area_uuid, sub_area_uuid,
+		visits, pos_count, fardes, sold, brand_present, fardes_share, basket_sold
+	)
+	WITH forms AS (
+		SELECT
+			pf.uuid,
+			pf.pos_uuid,
+			DATE(pf.created_at)     AS day,
+			pf.commune_uuid,
+			pf.user_uuid,
+			COALESCE(p.postype, '') AS postype,
+			` + PosSegmentAtSQL("pf.pos_uuid", "pf.created_at") + ` AS segment,
+			pf.country_uuid,
+			pf.province_uuid,
+			pf.area_uuid,
+			pf.sub_area_uuid
+		FROM pos_forms pf
+		LEFT JOIN pos p ON p.uuid = pf.pos_uuid
+		WHERE pf.deleted_at IS NULL AND %s
+	),
+	lines AS (
+		SELECT
+			pfi.pos_form_uuid                     AS form_uuid,
+			pfi.number_farde,
+			pfi.sold,
+			COALESCE(b.category_uuid, '')         AS category_uuid,
+			COALESCE(b.manufacturer_uuid, '')     AS manufacturer_uuid,
+			CASE WHEN b.competitor THEN '` + models.OwnershipCompetitor + `' ELSE '` + models.OwnershipOwn + `' END AS ownership
+		FROM forms f
+		INNER JOIN pos_form_items pfi ON pfi.pos_form_uuid = f.uuid AND pfi.deleted_at IS NULL
+		LEFT JOIN brands b ON b.uuid = pfi.brand_uuid
+		WHERE pfi.brand_uuid <> ''
+	),
+	visit_groups AS (
+		SELECT l.form_uuid, g.group_by, g.group_key, g.category_uuid,
+		       SUM(l.number_farde) AS fardes, SUM(l.sold) AS sold
+		FROM lines l
+		CROSS JOIN LATERAL (VALUES
+			('` + models.GroupCategory + `',     l.category_uuid,     '` + models.GroupScopeAll + `'),
+			('` + models.GroupManufacturer + `', l.manufacturer_uuid, '` + models.GroupScopeAll + `'),
+			('` + models.GroupManufacturer + `', l.manufacturer_uuid, l.category_uuid),
+			('` + models.GroupOwnership + `',    l.ownership,         '` + models.GroupScopeAll + `'),
+			('` + models.GroupOwnership + `',    l.ownership,         l.category_uuid)
+		) g(group_by, group_key, category_uuid)
+		GROUP BY 1, 2, 3, 4
+	),
+	scope_totals AS (
+		SELECT form_uuid, category_uuid, SUM(number_farde) AS fardes, SUM(sold) AS sold
+		FROM lines
+		GROUP BY 1, 2
+		UNION ALL
+		SELECT form_uuid, '` + models.GroupScopeAll + `', SUM(number_farde), SUM(sold)
+		FROM lines
+		GROUP BY 1
+	)
+	SELECT
+		f.day, f.commune_uuid, f.user_uuid, f.postype, f.segment, vg.group_by, vg.group_key, vg.category_uuid,
+		MAX(f.country_uuid), MAX(f.province_uuid), MAX(f.area_uuid), MAX(f.sub_area_uuid),
+		COUNT(*),
+		COUNT(DISTINCT f.pos_uuid),
+		SUM(vg.fardes),
+		SUM(vg.sold),
+		COUNT(*) FILTER (WHERE vg.fardes > 0),
+		COALESCE(SUM(vg.fardes * 100.0 / NULLIF(st.fardes, 0)), 0),
+		COALESCE(SUM(st.sold) FILTER (WHERE vg.sold > 0), 0)
+	FROM visit_groups vg
+	INNER JOIN forms f ON f.uuid = vg.form_uuid
+	LEFT JOIN scope_totals st ON st.form_uuid = vg.form_uuid AND st.category_uuid = vg.category_uuid
+	GROUP BY f.day, f.commune_uuid, f.user_uuid, f.postype, f.segment, vg.group_by, vg.group_key, vg.category_uuid`
+
+// dailyPosFactsInsert aggregates the visits selected by the %s condition
+// on pos_forms pf into daily_pos_facts: one all-brands row and one row per
+// brand for each day × POS × agent.
+var dailyPosFactsInsert = `
+	INSERT INTO daily_pos_facts (
+		day, pos_uuid, user_uuid, brand_uuid,
+		country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid, postype, segment,
+		visits, fardes, sold, brand_present, revenue
+	)
+	WITH forms AS (` + dailyFactForms + `
+	)
+	SELECT
+		day, COALESCE(pos_uuid, ''), user_uuid, '',
+		MAX(country_uuid), MAX(province_uuid), MAX(area_uuid), MAX(sub_area_uuid), MAX(commune_uuid),
+		MAX(postype), MAX(segment),
+		COUNT(*),
+		SUM(fardes),
+		SUM(sold),
+		COUNT(*) FILTER (WHERE fardes > 0),
+		SUM(price)
+	FROM forms
+	GROUP BY 1, 2, 3
+	UNION ALL
+	SELECT
+		f.day, COALESCE(f.pos_uuid, ''), f.user_uuid, pfi.brand_uuid,
+		MAX(f.country_uuid), MAX(f.province_uuid), MAX(f.area_uuid), MAX(f.sub_area_uuid), MAX(f.commune_uuid),
+		MAX(f.postype), MAX(f.segment),
+		COUNT(pfi.uuid),
+		SUM(pfi.number_farde),
+		SUM(pfi.sold),
+		COUNT(pfi.uuid) FILTER (WHERE pfi.number_farde > 0),
+		0
+	FROM forms f
+	INNER JOIN pos_form_items pfi ON pfi.pos_form_uuid = f.uuid AND pfi.deleted_at IS NULL
+	WHERE pfi.brand_uuid <> ''
+	GROUP BY 1, 2, 3, 4`
+
+// refreshDailyFacts replaces the facts, group facts and POS facts matched
+// by factWhere with the aggregate of the visits matched by formWhere. Both
+// must select the same days and agents.
+func refreshDailyFacts(tx *gorm.DB, factWhere, formWhere string, params map[string]interface{}) error {
+	for _, table := range []string{"daily_facts", "daily_group_facts", "daily_pos_facts"} {
+		if err := tx.Exec("DELETE FROM "+table+" WHERE "+factWhere, params).Error; err != nil {
+			return err
+		}
+	}
+	for _, insert := range []string{dailyFactsInsert, dailyGroupFactsInsert, dailyPosFactsInsert} {
+		if err := tx.Exec(fmt.Sprintf(insert, formWhere), params).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefreshDailyFacts recomputes the daily facts of the agent and day of each
+// visit. Call it in the transaction of the change after a visit or one of
+// its lines is created, updated or deleted; for a visit whose agent, date
+// or territory changed, pass the old version too. Once committed, drop the
+// cached responses of the same visits with InvalidateCachedResponses.
+func RefreshDailyFacts(tx *gorm.DB, forms ...*models.PosForm) error {
+	// Agents and days in a stable order, for transactions refreshing
+	// several to take their locks in the same order
+	sorted := make([]*models.PosForm, 0, len(forms))
+	for _, form := range forms {
+		if form != nil && !form.CreatedAt.IsZero() {
+			sorted = append(sorted, form)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].UserUUID != sorted[j].UserUUID {
+			return sorted[i].UserUUID < sorted[j].UserUUID
+		}
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+	if len(sorted) > 0 {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock_shared(?)", dailyFactsLock).Error; err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]bool, len(sorted))
+	for _, form := range sorted {
+		key := form.UserUUID + "|" + form.CreatedAt.Format(time.RFC3339)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		params := map[string]interface{}{"user": form.UserUUID, "at": form.CreatedAt, "class": dailyFactsLockClass}
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(CAST(@class AS integer), hashtext(CAST(@user AS text) || DATE(CAST(@at AS timestamptz))))", params).Error; err != nil {
+			return err
+		}
+		err := refreshDailyFacts(tx,
+			"user_uuid = @user AND day = DATE(CAST(@at AS timestamptz))",
+			`pf.user_uuid = @user
+			  AND pf.created_at >= DATE(CAST(@at AS timestamptz))
+			  AND pf.created_at <  DATE(CAST(@at AS timestamptz)) + 1`,
+			params,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DailyFactForms loads the agent, territory, creation and check-in times of
+// visits, deleted ones included, for RefreshDailyFacts and
+// InvalidateCachedResponses.
+func DailyFactForms(tx *gorm.DB, formUUIDs []string) ([]*models.PosForm, error) {
+	forms := []*models.PosForm{}
+	if len(formUUIDs) == 0 {
+		return forms, nil
+	}
+	err := tx.Unscoped().
+		Select("uuid", "user_uuid", "created_at", "check_in_at",
+			"country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid").
+		Where("uuid IN ?", formUUIDs).
+		Find(&forms).Error
+	return forms, err
+}
+
+// PosDailyFactForms loads the visits of the POS like DailyFactForms. The
+// daily facts copy the current type of the POS: when it changes, refresh
+// the facts of all its visits with them. Their territory is that of each
+// visit and their segment the one of the POS at the visit, which a later
+// change of the POS leaves as they are.
+func PosDailyFactForms(tx *gorm.DB, posUUIDs []string) ([]*models.PosForm, error) {
+	var formUUIDs []string
+	if len(posUUIDs) > 0 {
+		if err := tx.Model(&models.PosForm{}).Where("pos_uuid IN ?", posUUIDs).Pluck("uuid", &formUUIDs).Error; err != nil {
+			return nil, err
+		}
+	}
+	return DailyFactForms(tx, formUUIDs)
+}
+
+// RebuildDailyFacts recomputes the daily facts of every visit created from
+// the day of from up to the day before to, one month per transaction.
+func RebuildDailyFacts(db *gorm.DB, from, to time.Time) error {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	for start := from; start.Before(to); {
+		end := time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, time.Local)
+		if end.After(to) {
+			end = to
+		}
+		params := map[string]interface{}{
+			"from": start.Format("2006-01-02"),
+			"to":   end.Format("2006-01-02"),
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", dailyFactsLock).Error; err != nil {
+				return err
+			}
+			return refreshDailyFacts(tx,
+				"day >= CAST(@from AS date) AND day < CAST(@to AS date)",
+				"pf.created_at >= CAST(@from AS date) AND pf.created_at < CAST(@to AS date)",
+				params,
+			)
+		})
+		if err != nil {
+			return fmt.Errorf("rebuild daily facts %s to %s: %w", params["from"], params["to"], err)
+		}
+		start = end
+	}
+	return nil
+}
+
+// RebuildBrandFacts recomputes the daily facts since the first visit with a
+// line of the brand, after its category, manufacturer or ownership changed,
+// and drops every cached response.
+func RebuildBrandFacts(db *gorm.DB, brandUUID string) error {
+	var first *time.Time
+	err := db.Raw(`
+		SELECT MIN(pf.created_at)
+		FROM pos_form_items pfi
+		INNER JOIN pos_forms pf ON pf.uuid = pfi.pos_form_uuid
+		WHERE pfi.brand_uuid = ?`, brandUUID).Scan(&first).Error
+	if err != nil || first == nil {
+		return err
+	}
+	if err := RebuildDailyFacts(db, first.In(time.Local), time.Now().AddDate(0, 0, 1)); err != nil {
+		return err
+	}
+	ResponseCache.DeleteFunc(func(*CachedResponse) bool { return true })
+	return nil
+}
+
+// RebuildAllDailyFacts recomputes the daily facts of every visit and
+// records that they are built with DailyFactsVersion.
+func RebuildAllDailyFacts(db *gorm.DB) error {
+	started := time.Now()
+	var first *time.Time
+	if err := db.Raw("SELECT MIN(created_at) FROM pos_forms").Scan(&first).Error; err != nil {
+		return err
+	}
+	if first != nil {
+		if err := RebuildDailyFacts(db, first.In(time.Local), started.AddDate(0, 0, 1)); err != nil {
+			return err
+		}
+	}
+	builtAt := time.Now()
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.DailyFactsBuild{
+		Version:   DailyFactsVersion,
+		StartedAt: started,
+		BuiltAt:   &builtAt,
+	}).Error
+}
+
+// EnsureDailyFacts rebuilds every daily fact when they were not built with
+// DailyFactsVersion yet: on a new database, or after a change of their
+// definition. The rebuild is claimed by recording its start, so that one
+// instance of the API runs it, again if it did not complete within a day.
+// Dashboards read the facts rebuilt so far meanwhile.
+func EnsureDailyFacts(db *gorm.DB) error {
+	now := time.Now()
+	claim := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.DailyFactsBuild{Version: DailyFactsVersion, StartedAt: now})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected != 1 {
+		claim = db.Model(&models.DailyFactsBuild{}).
+			Where("version = ? AND built_at IS NULL AND started_at <= ?", DailyFactsVersion, now.AddDate(0, 0, -1)).
+			UpdateColumn("started_at", now)
+		if claim.Error != nil || claim.RowsAffected != 1 {
+			return claim.Error
+		}
+	}
+
+	log.Printf("Rebuilding the daily facts (version %d)", DailyFactsVersion)
+	if err := RebuildAllDailyFacts(db); err != nil {
+		return err
+	}
+	ResponseCache.DeleteFunc(func(*CachedResponse) bool { return true })
+	log.Println("Daily facts rebuilt")
+	return nil
+}
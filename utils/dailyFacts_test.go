@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a gorm logger keeping the SQL of every statement.
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// dryRun returns a database building the statements without running them,
+// and their recorder.
+func dryRun(t *testing.T) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost", PreferSimpleProtocol: true}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 rec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+func TestRefreshDailyFacts(t *testing.T) {
+	morning := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	form := func(user string, at time.Time) *models.PosForm {
+		return &models.PosForm{UserUUID: user, CreatedAt: at}
+	}
+
+	tests := []struct {
+		name  string
+		forms []*models.PosForm
+		want  []string // Agents refreshed, in order
+	}{
+		{"no visit", nil, nil},
+		{"nil and unsaved visits", []*models.PosForm{nil, form("u1", time.Time{})}, nil},
+		{"one visit", []*models.PosForm{form("u1", morning)}, []string{"u1"}},
+		{"same agent and time once", []*models.PosForm{form("u1", morning), form("u1", morning)}, []string{"u1"}},
+		{"moved to another day", []*models.PosForm{form("u1", morning), form("u1", morning.AddDate(0, 0, -1))}, []string{"u1", "u1"}},
+		{"agents in order", []*models.PosForm{form("u2", morning), form("u1", morning), form("u3", morning)}, []string{"u1", "u2", "u3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, rec := dryRun(t)
+			if err := RefreshDailyFacts(db, tt.forms...); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.want) == 0 {
+				if len(rec.statements) != 0 {
+					t.Fatalf("statements = %q, want none", rec.statements)
+				}
+				return
+			}
+
+			// The shared lock, then a lock, 3 deletes and 3 inserts by agent × day
+			if got, want := len(rec.statements), 1+7*len(tt.want); got != want {
+				t.Fatalf("%d statements, want %d", got, want)
+			}
+			if !strings.Contains(rec.statements[0], "pg_advisory_xact_lock_shared") {
+				t.Errorf("first statement = %s, want the shared lock", rec.statements[0])
+			}
+			var users []string
+			for i, sql := range rec.statements[1:] {
+				if strings.Contains(sql, "@") {
+					t.Errorf("unbound parameter in %s", sql)
+				}
+				if i%7 == 0 {
+					if !strings.Contains(sql, "pg_advisory_xact_lock(") {
+						t.Errorf("statement %d = %s, want the lock of the agent × day", i+1, sql)
+					}
+					for _, u := range []string{"u1", "u2", "u3"} {
+						if strings.Contains(sql, "'"+u+"'") {
+							users = append(users, u)
+						}
+					}
+				}
+			}
+			if !reflect.DeepEqual(users, tt.want) {
+				t.Errorf("agents = %v, want %v", users, tt.want)
+			}
+		})
+	}
+}
+
+// The visits of a retyped POS are looked up among the live visits only,
+// and not at all without POS.
+func TestPosDailyFactForms(t *testing.T) {
+	db, rec := dryRun(t)
+	if _, err := PosDailyFactForms(db, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.statements) != 0 {
+		t.Fatalf("statements = %q, want none", rec.statements)
+	}
+
+	if _, err := PosDailyFactForms(db, []string{"p1", "p2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.statements) != 1 {
+		t.Fatalf("statements = %q, want the visits of the POS", rec.statements)
+	}
+	sql := rec.statements[0]
+	for _, want := range []string{"pos_uuid IN ('p1','p2')", `"deleted_at" IS NULL`} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("%s does not contain %s", sql, want)
+		}
+	}
+}
+
+func TestDailyFactsInsert(t *testing.T) {
+	db, rec := dryRun(t)
+	err := refreshDailyFacts(db, "day = CAST(@day AS date)", "DATE(pf.created_at) = CAST(@day AS date)",
+		map[string]interface{}{"day": "2026-10-16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"DELETE FROM daily_facts WHERE day = CAST('2026-10-16' AS date)",
+		"DELETE FROM daily_group_facts WHERE day = CAST('2026-10-16' AS date)",
+		"DELETE FROM daily_pos_facts WHERE day = CAST('2026-10-16' AS date)",
+		"INSERT INTO daily_facts",
+		"INSERT INTO daily_group_facts",
+		"INSERT INTO daily_pos_facts",
+	}
+	if len(rec.statements) != len(want) {
+		t.Fatalf("statements = %q, want %d", rec.statements, len(want))
+	}
+	for i, sql := range rec.statements {
+		if !strings.Contains(sql, want[i]) {
+			t.Errorf("statement %d = %s, want %s", i, sql, want[i])
+		}
+		if i >= 3 {
+			if !strings.Contains(sql, "DATE(pf.created_at) = CAST('2026-10-16' AS date)") {
+				t.Errorf("statement %d does not select the visits of the day", i)
+			}
+			if strings.Contains(sql, "%!") {
+				t.Errorf("statement %d is badly formatted: %s", i, sql)
+			}
+		}
+	}
+}
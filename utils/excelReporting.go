@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/xuri/excelize/v2"
@@ -122,6 +123,29 @@ func SetupExcelStyles(f *excelize.File) (map[string]int, error) {
 	}
 	styles["number"] = numberStyle
 
+	// Decimal style
+	decimalStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{
+			Size:   10,
+			Family: "Calibri",
+		},
+		Alignment: &excelize.Alignment{
+			Horizontal: "right",
+			Vertical:   "center",
+		},
+		Border: []excelize.Border{
+			{Type: "left", Color: "D3D3D3", Style: 1},
+			{Type: "top", Color: "D3D3D3", Style: 1},
+			{Type: "bottom", Color: "D3D3D3", Style: 1},
+			{Type: "right", Color: "D3D3D3", Style: 1},
+		},
+		NumFmt: 4, // Number format with thousands separator and 2 decimals
+	})
+	if err != nil {
+		return nil, err
+	}
+	styles["decimal"] = decimalStyle
+
 	// Date style
 	dateStyle, err := f.NewStyle(&excelize.Style{
 		Font: &excelize.Font{
@@ -297,41 +321,65 @@ func AddSummaryTable(f *excelize.File, sheetName string, data map[string]interfa
 	return nil
 }
 
-// CreateChart creates a chart in the Excel file
-// Note: Chart functionality is temporarily disabled due to API compatibility issues
-func CreateChart(f *excelize.File, sheetName string, chartType string, dataRange string, title string) error {
-	// TODO: Implement chart creation when excelize API is clarified
-	// For now, we'll add a text note about the chart
-	f.SetCellValue(sheetName, "E2", fmt.Sprintf("Graphique: %s (%s)", title, chartType))
-	f.SetCellValue(sheetName, "E3", fmt.Sprintf("Données: %s", dataRange))
+// ChartSpec describes a chart of the columns of a table: its header is on
+// HeaderRow and its data on the next rows up to LastRow. LabelCol holds the
+// categories and each of ValueCols a series. Columns are 1-based.
+type ChartSpec struct {
+	Type      string // col, bar, line or pie
+	Title     string
+	HeaderRow int
+	LastRow   int
+	LabelCol  int
+	ValueCols []int
+}
 
-	// Create a simple style for the chart placeholder
-	chartStyle, err := f.NewStyle(&excelize.Style{
-		Font: &excelize.Font{
-			Bold:   true,
-			Size:   12,
-			Color:  "4472C4",
-			Family: "Calibri",
-		},
-		Alignment: &excelize.Alignment{
-			Horizontal: "center",
-			Vertical:   "center",
-		},
-		Border: []excelize.Border{
-			{Type: "left", Color: "4472C4", Style: 2},
-			{Type: "top", Color: "4472C4", Style: 2},
-			{Type: "bottom", Color: "4472C4", Style: 2},
-			{Type: "right", Color: "4472C4", Style: 2},
-		},
-	})
+var chartTypes = map[string]excelize.ChartType{
+	"col":  excelize.Col,
+	"bar":  excelize.Bar,
+	"line": excelize.Line,
+	"pie":  excelize.Pie,
+}
 
-	if err == nil {
-		f.SetCellStyle(sheetName, "E2", "F3", chartStyle)
-		f.MergeCell(sheetName, "E2", "F2")
-		f.MergeCell(sheetName, "E3", "F3")
+// chartRef is the absolute reference of a column from row to lastRow
+func chartRef(sheetName string, col, row, lastRow int) string {
+	name, _ := excelize.ColumnNumberToName(col)
+	ref := fmt.Sprintf("'%s'!$%s$%d", strings.ReplaceAll(sheetName, "'", "''"), name, row)
+	if lastRow > row {
+		ref += fmt.Sprintf(":$%s$%d", name, lastRow)
 	}
+	return ref
+}
 
-	return nil
+// CreateChart adds a native Excel chart of spec, read from sheetName, with
+// its top left corner at cell
+func CreateChart(f *excelize.File, sheetName, cell string, spec ChartSpec) error {
+	chartType, ok := chartTypes[spec.Type]
+	if !ok {
+		return fmt.Errorf("unknown chart type %q", spec.Type)
+	}
+	if spec.LastRow <= spec.HeaderRow || len(spec.ValueCols) == 0 {
+		return nil // Nothing to draw
+	}
+
+	valueCols := spec.ValueCols
+	if chartType == excelize.Pie {
+		valueCols = valueCols[:1]
+	}
+
+	chart := &excelize.Chart{
+		Type:      chartType,
+		Title:     []excelize.RichTextRun{{Text: spec.Title}},
+		Legend:    excelize.ChartLegend{Position: "bottom"},
+		Dimension: excelize.ChartDimension{Width: 720, Height: 360},
+	}
+	for _, col := range valueCols {
+		chart.Series = append(chart.Series, excelize.ChartSeries{
+			Name:       chartRef(sheetName, col, spec.HeaderRow, spec.HeaderRow),
+			Categories: chartRef(sheetName, spec.LabelCol, spec.HeaderRow+1, spec.LastRow),
+			Values:     chartRef(sheetName, col, spec.HeaderRow+1, spec.LastRow),
+		})
+	}
+	return f.AddChart(sheetName, cell, chart)
 }
 
 // AddDataValidation adds data validation to specific cells
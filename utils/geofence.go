@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+const (
+	earthRadiusMeters = 6371000.0
+
+	// DefaultGeofenceRadius is the accuracy given to a POS location seeded
+	// from visits, in meters.
+	DefaultGeofenceRadius = 100.0
+	// MinGeofenceRadius keeps a very precise location from flagging every
+	// visit because of normal GPS drift.
+	MinGeofenceRadius = 50.0
+	// LocationSampleLimit is the number of good visit fixes whose median
+	// makes a visit-seeded POS location stable. Until then the location is
+	// provisional and flags no visit outside.
+	LocationSampleLimit = 5
+	// GoodFixAccuracy is the worst accuracy, in meters, of a visit fix
+	// refining a provisional location.
+	GoodFixAccuracy = 50.0
+)
+
+// HaversineMeters returns the great-circle distance between two points.
+func HaversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusMeters * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// ValidCoordinates rejects the 0,0 fix sent by devices without GPS and
+// out-of-range values.
+func ValidCoordinates(lat, lng float64) bool {
+	if lat == 0 && lng == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// PosHasLocation reports whether the POS has a canonical location.
+func PosHasLocation(pos *models.Pos) bool {
+	return pos.LocationSource != "" && ValidCoordinates(pos.Latitude, pos.Longitude)
+}
+
+// PosLocationProvisional reports whether the location of the POS comes
+// from fewer than LocationSampleLimit good visit fixes.
+func PosLocationProvisional(pos *models.Pos) bool {
+	return pos.LocationSource == models.PosLocationFromVisits && pos.LocationSamples < LocationSampleLimit
+}
+
+// GoodFix reports whether the GPS fix of the visit is valid and at least as
+// accurate as GoodFixAccuracy. Fixes of unknown accuracy are not.
+func GoodFix(form *models.PosForm) bool {
+	return ValidCoordinates(form.Latitude, form.Longitude) &&
+		form.GpsAccuracy > 0 && form.GpsAccuracy <= GoodFixAccuracy
+}
+
+// GeofenceStatus compares a GPS fix with the POS location and returns the
+// distance in meters and inside/outside/unknown. Against a provisional
+// location the distance is known but the status is not.
+func GeofenceStatus(pos *models.Pos, lat, lng float64) (*float64, string) {
+	if !PosHasLocation(pos) || !ValidCoordinates(lat, lng) {
+		return nil, models.GeofenceUnknown
+	}
+
+	distance := math.Round(HaversineMeters(pos.Latitude, pos.Longitude, lat, lng)*10) / 10
+	if PosLocationProvisional(pos) {
+		return &distance, models.GeofenceUnknown
+	}
+	radius := math.Max(pos.LocationAccuracy, MinGeofenceRadius)
+	if distance <= radius {
+		return &distance, models.GeofenceInside
+	}
+	return &distance, models.GeofenceOutside
+}
+
+// ApplyGeofence computes the distance and geofence status of a new visit
+// before it is created, in its transaction. When the POS has no location
+// yet, the visit seeds a provisional one. While the location comes from
+// visits and is provisional, every good fix, inside the fence or not,
+// moves it to the median of the first good fixes of the POS; at
+// LocationSampleLimit of them it is stable and the earlier visits of the
+// POS are checked against it. Supervisor-set locations are never moved.
+// Updates go through UpdateGeofence, so that a visit counts once in the
+// POS location.
+func ApplyGeofence(tx *gorm.DB, form *models.PosForm) error {
+	form.DistanceToPos, form.GeofenceStatus = nil, models.GeofenceUnknown
+
+	if form.PosUUID == "" || !ValidCoordinates(form.Latitude, form.Longitude) {
+		return nil
+	}
+
+	var pos models.Pos
+	if err := tx.Where("uuid = ?", form.PosUUID).First(&pos).Error; err != nil {
+		return nil
+	}
+
+	if !PosHasLocation(&pos) {
+		now := time.Now()
+		pos.Latitude, pos.Longitude = form.Latitude, form.Longitude
+		pos.LocationAccuracy = DefaultGeofenceRadius
+		pos.LocationSource = models.PosLocationFromVisits
+		pos.LocationSamples = 0
+		if GoodFix(form) {
+			pos.LocationSamples = 1
+		}
+		pos.LocationSetAt = &now
+		form.DistanceToPos, form.GeofenceStatus = GeofenceStatus(&pos, form.Latitude, form.Longitude)
+		return tx.Model(&pos).Updates(map[string]interface{}{
+			"latitude":          pos.Latitude,
+			"longitude":         pos.Longitude,
+			"location_accuracy": pos.LocationAccuracy,
+			"location_source":   pos.LocationSource,
+			"location_samples":  pos.LocationSamples,
+			"location_set_at":   pos.LocationSetAt,
+		}).Error
+	}
+
+	if PosLocationProvisional(&pos) && GoodFix(form) {
+		var fixes []models.PosForm
+		if err := tx.Select("latitude", "longitude").
+			Where("pos_uuid = ? AND uuid <> ?", pos.UUID, form.UUID).
+			Where("gps_accuracy > 0 AND gps_accuracy <= ?", GoodFixAccuracy).
+			Where("NOT (latitude = 0 AND longitude = 0)").
+			Order("created_at").
+			Limit(LocationSampleLimit - 1).
+			Find(&fixes).Error; err != nil {
+			return err
+		}
+		fixes = append(fixes, *form)
+
+		pos.Latitude, pos.Longitude = medianFix(fixes)
+		pos.LocationSamples = len(fixes)
+		if err := tx.Model(&pos).Updates(map[string]interface{}{
+			"latitude":         pos.Latitude,
+			"longitude":        pos.Longitude,
+			"location_samples": pos.LocationSamples,
+		}).Error; err != nil {
+			return err
+		}
+		if !PosLocationProvisional(&pos) {
+			if err := RegeofenceVisits(tx, &pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	form.DistanceToPos, form.GeofenceStatus = GeofenceStatus(&pos, form.Latitude, form.Longitude)
+	return nil
+}
+
+// medianFix returns the median latitude and the median longitude of the
+// fixes, which one stray fix cannot pull away like a mean.
+func medianFix(fixes []models.PosForm) (float64, float64) {
+	lats := make([]float64, len(fixes))
+	lngs := make([]float64, len(fixes))
+	for i, f := range fixes {
+		lats[i], lngs[i] = f.Latitude, f.Longitude
+	}
+	return median(lats), median(lngs)
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// RegeofenceVisits recomputes the distance and geofence status of every
+// visit of the POS against its current location.
+func RegeofenceVisits(tx *gorm.DB, pos *models.Pos) error {
+	var forms []models.PosForm
+	if err := tx.Select("uuid", "latitude", "longitude").
+		Where("pos_uuid = ?", pos.UUID).Find(&forms).Error; err != nil {
+		return err
+	}
+	for _, f := range forms {
+		distance, status := GeofenceStatus(pos, f.Latitude, f.Longitude)
+		if err := tx.Model(&models.PosForm{}).Where("uuid = ?", f.UUID).
+			UpdateColumns(map[string]interface{}{
+				"distance_to_pos": distance,
+				"geofence_status": status,
+			}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateGeofence recomputes the distance and geofence status of an updated
+// visit whose POS or coordinates changed, without moving the POS location.
+// Otherwise the visit keeps those of its previous version.
+func UpdateGeofence(tx *gorm.DB, before, after *models.PosForm) error {
+	if before.PosUUID == after.PosUUID && before.Latitude == after.Latitude && before.Longitude == after.Longitude {
+		after.DistanceToPos, after.GeofenceStatus = before.DistanceToPos, before.GeofenceStatus
+		return nil
+	}
+	after.DistanceToPos, after.GeofenceStatus = nil, models.GeofenceUnknown
+
+	if after.PosUUID == "" || !ValidCoordinates(after.Latitude, after.Longitude) {
+		return nil
+	}
+
+	var pos models.Pos
+	if err := tx.Where("uuid = ?", after.PosUUID).First(&pos).Error; err != nil {
+		return nil
+	}
+	after.DistanceToPos, after.GeofenceStatus = GeofenceStatus(&pos, after.Latitude, after.Longitude)
+	return nil
+}
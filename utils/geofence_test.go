@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestHaversineMeters(t *testing.T) {
+	// One degree of a great circle
+	degree := 2 * math.Pi * earthRadiusMeters / 360
+
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"same point", -4.3217, 15.3126, -4.3217, 15.3126, 0},
+		{"one degree of latitude", 0, 0, 1, 0, degree},
+		{"one degree of longitude on the equator", 0, 0, 0, 1, degree},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, degree},
+		{"antipodes", 0, 0, 0, 180, math.Pi * earthRadiusMeters},
+		{"pole to pole", 90, 0, -90, 0, math.Pi * earthRadiusMeters},
+		{"one degree of longitude at 60°", 60, 10, 60, 11, 55597.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HaversineMeters(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+			if math.Abs(got-tt.want) > 1 {
+				t.Errorf("HaversineMeters = %.1f, want %.1f", got, tt.want)
+			}
+			if back := HaversineMeters(tt.lat2, tt.lng2, tt.lat1, tt.lng1); math.Abs(back-got) > 1e-6 {
+				t.Errorf("not symmetric: %.6f and %.6f", got, back)
+			}
+		})
+	}
+}
+
+func TestGeofenceStatus(t *testing.T) {
+	located := func(accuracy float64) *models.Pos {
+		return &models.Pos{
+			Latitude:         -4.3217,
+			Longitude:        15.3126,
+			LocationAccuracy: accuracy,
+			LocationSource:   models.PosLocationFromSupervisor,
+		}
+	}
+	// Latitude offset of about 80 meters
+	near := -4.3217 + 80/(2*math.Pi*earthRadiusMeters/360)
+
+	tests := []struct {
+		name     string
+		pos      *models.Pos
+		lat, lng float64
+		want     string
+		distance bool
+	}{
+		{"pos without location", &models.Pos{}, -4.3217, 15.3126, models.GeofenceUnknown, false},
+		{"visit without gps", located(100), 0, 0, models.GeofenceUnknown, false},
+		{"at the pos", located(100), -4.3217, 15.3126, models.GeofenceInside, true},
+		{"within the accuracy", located(100), near, 15.3126, models.GeofenceInside, true},
+		{"beyond the accuracy", located(60), near, 15.3126, models.GeofenceOutside, true},
+		{"precise location widened to the minimum radius", located(5), -4.3217 + 40/(2*math.Pi*earthRadiusMeters/360), 15.3126, models.GeofenceInside, true},
+		{"provisional location flags no visit", &models.Pos{Latitude: -4.3217, Longitude: 15.3126, LocationAccuracy: 100, LocationSource: models.PosLocationFromVisits, LocationSamples: 2}, -4.4, 15.3126, models.GeofenceUnknown, true},
+		{"stable location from visits", &models.Pos{Latitude: -4.3217, Longitude: 15.3126, LocationAccuracy: 100, LocationSource: models.PosLocationFromVisits, LocationSamples: LocationSampleLimit}, -4.4, 15.3126, models.GeofenceOutside, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance, status := GeofenceStatus(tt.pos, tt.lat, tt.lng)
+			if status != tt.want {
+				t.Errorf("status = %s, want %s", status, tt.want)
+			}
+			if (distance != nil) != tt.distance {
+				t.Errorf("distance = %v, want set %v", distance, tt.distance)
+			}
+		})
+	}
+}
+
+// One stray fix does not move the median of the others.
+func TestMedianFix(t *testing.T) {
+	fixes := []models.PosForm{
+		{Latitude: -4.3217, Longitude: 15.3126},
+		{Latitude: -4.3219, Longitude: 15.3124},
+		{Latitude: -4.9000, Longitude: 15.9000},
+		{Latitude: -4.3218, Longitude: 15.3125},
+	}
+	lat, lng := medianFix(fixes)
+	if math.Abs(lat-(-4.32185)) > 1e-9 || math.Abs(lng-15.31255) > 1e-9 {
+		t.Errorf("median = %v, %v, want -4.32185, 15.31255", lat, lng)
+	}
+	if lat, lng := medianFix(fixes[:3]); lat != -4.3219 || lng != 15.3126 {
+		t.Errorf("median of three = %v, %v, want -4.3219, 15.3126", lat, lng)
+	}
+}
+
+func TestGoodFix(t *testing.T) {
+	tests := []struct {
+		form models.PosForm
+		want bool
+	}{
+		{models.PosForm{Latitude: -4.32, Longitude: 15.31, GpsAccuracy: 12}, true},
+		{models.PosForm{Latitude: -4.32, Longitude: 15.31, GpsAccuracy: GoodFixAccuracy}, true},
+		{models.PosForm{Latitude: -4.32, Longitude: 15.31, GpsAccuracy: 300}, false},
+		{models.PosForm{Latitude: -4.32, Longitude: 15.31}, false}, // accuracy unknown
+		{models.PosForm{GpsAccuracy: 5}, false},                    // no fix
+	}
+	for _, tt := range tests {
+		if got := GoodFix(&tt.form); got != tt.want {
+			t.Errorf("GoodFix(%+v) = %v, want %v", tt.form, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailAttachment is a file attached to an email.
+type EmailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// EmailMessage is an HTML email. From defaults to the sender's address.
+type EmailMessage struct {
+	From        string
+	To          []string
+	Subject     string
+	HTML        string
+	Attachments []EmailAttachment
+}
+
+// EmailSender delivers emails.
+type EmailSender interface {
+	Send(msg EmailMessage) error
+}
+
+// SMTPSender sends emails through an SMTP server. Without Username it does
+// not authenticate, as with a local test server such as MailHog.
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPSender reads the server from EMAIL_HOST, EMAIL_PORT (25 when
+// unset), EMAIL_USERNAME, EMAIL_PASSWORD and EMAIL_FROM.
+func NewSMTPSender() *SMTPSender {
+	s := &SMTPSender{
+		Host:     Env("EMAIL_HOST"),
+		Port:     Env("EMAIL_PORT"),
+		Username: Env("EMAIL_USERNAME"),
+		Password: Env("EMAIL_PASSWORD"),
+		From:     Env("EMAIL_FROM"),
+	}
+	if s.Port == "" {
+		s.Port = "25"
+	}
+	return s
+}
+
+// Mailer sends the application emails when set, e.g. to capture them.
+// Otherwise SendEmail uses NewSMTPSender.
+var Mailer EmailSender
+
+// SendEmail sends msg through Mailer or the configured SMTP server.
+func SendEmail(msg EmailMessage) error {
+	if Mailer != nil {
+		return Mailer.Send(msg)
+	}
+	return NewSMTPSender().Send(msg)
+}
+
+// Send implements EmailSender.
+func (s *SMTPSender) Send(msg EmailMessage) error {
+	if s.Host == "" {
+		return fmt.Errorf("EMAIL_HOST is not set")
+	}
+	if msg.From == "" {
+		msg.From = s.From
+	}
+	if len(msg.To) == 0 {
+		return fmt.Errorf("no recipient")
+	}
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+
+	body, err := BuildEmail(msg)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(s.Host+":"+s.Port, auth, from.Address, msg.To, body)
+}
+
+// BuildEmail encodes msg as a MIME message: its HTML body alone, or with
+// the attachments in a multipart/mixed message.
+func BuildEmail(msg EmailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(key, value string) { fmt.Fprintf(&buf, "%s: %s\r\n", key, value) }
+	header("From", msg.From)
+	header("To", strings.Join(msg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if len(msg.Attachments) == 0 {
+		header("Content-Type", "text/html; charset=utf-8")
+		header("Content-Transfer-Encoding", "base64")
+		buf.WriteString("\r\n")
+		writeBase64(&buf, []byte(msg.HTML))
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64(part, []byte(msg.HTML))
+
+	for _, a := range msg.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": a.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, a.Data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64 writes data in base64 lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// ParseRecipients splits a comma or semicolon separated list of emails and
+// checks each of them.
+func ParseRecipients(list string) ([]string, error) {
+	out := []string{}
+	for _, s := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ';' }) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email %q", s)
+		}
+		out = append(out, addr.Address)
+	}
+	return out, nil
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NotificationHub pushes the new notifications to the connected clients of
+// their recipient. It is in process memory: with several instances of the
+// API, a client only receives the notifications created by its instance
+// and finds the others in its inbox.
+type NotificationHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.Notification]struct{}
+}
+
+// Notifications is the hub of the API.
+var Notifications = &NotificationHub{subscribers: map[string]map[chan models.Notification]struct{}{}}
+
+// Subscribe returns the channel of the notifications of the user and the
+// function that closes it.
+func (h *NotificationHub) Subscribe(userUUID string) (<-chan models.Notification, func()) {
+	ch := make(chan models.Notification, 16)
+	h.mu.Lock()
+	if h.subscribers[userUUID] == nil {
+		h.subscribers[userUUID] = map[chan models.Notification]struct{}{}
+	}
+	h.subscribers[userUUID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[userUUID], ch)
+			if len(h.subscribers[userUUID]) == 0 {
+				delete(h.subscribers, userUUID)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends n to the clients of its recipient. A client that does not
+// keep up misses it.
+func (h *NotificationHub) Publish(n models.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[n.UserUUID] {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// Notify saves a copy of n for each recipient and publishes it. With a Key,
+// recipients who already had a notification of that key are skipped.
+// Failures are logged: a notification never fails the request that
+// raised it.
+func Notify(db *gorm.DB, recipients []string, n models.Notification) {
+	seen := map[string]bool{}
+	for _, userUUID := range recipients {
+		if userUUID == "" || seen[userUUID] {
+			continue
+		}
+		seen[userUUID] = true
+
+		if n.Key != "" {
+			var count int64
+			db.Model(&models.Notification{}).Unscoped().
+				Where("user_uuid = ? AND key = ?", userUUID, n.Key).
+				Count(&count)
+			if count > 0 {
+				continue
+			}
+		}
+
+		copy := n
+		copy.UUID = uuid.New().String()
+		copy.UserUUID = userUUID
+		if err := db.Create(&copy).Error; err != nil {
+			log.Printf("Notification %s to %s: %v", n.Type, userUUID, err)
+			continue
+		}
+		Notifications.Publish(copy)
+	}
+}
+
+// TerritoryLeads returns the active users in charge of the territory: the
+// manager of its country, the ASM of its province, the supervisor of its
+// area, the DR of its sub-area and the cyclo of its commune, but exclude.
+func TerritoryLeads(db *gorm.DB, countryUUID, provinceUUID, areaUUID, subAreaUUID, communeUUID, exclude string) []string {
+	var leads []string
+	db.Model(&models.User{}).
+		Where("status = true AND uuid <> ?", exclude).
+		Where(`(LOWER(role) = 'manager' AND country_uuid = ? AND country_uuid <> '')
+			OR (LOWER(role) = 'asm' AND province_uuid = ? AND province_uuid <> '')
+			OR (LOWER(role) IN ('supervisor', 'sup') AND area_uuid = ? AND area_uuid <> '')
+			OR (LOWER(role) = 'dr' AND sub_area_uuid = ? AND sub_area_uuid <> '')
+			OR (LOWER(role) = 'cyclo' AND commune_uuid = ? AND commune_uuid <> '')`,
+			countryUUID, provinceUUID, areaUUID, subAreaUUID, communeUUID).
+		Pluck("uuid", &leads)
+	return leads
+}
+
+// NotifyObservations tells the leads of their territory about the visits
+// that carry a comment.
+func NotifyObservations(db *gorm.DB, forms ...*models.PosForm) {
+	for _, f := range forms {
+		comment := strings.TrimSpace(f.Comment)
+		if comment == "" {
+			continue
+		}
+		var names struct {
+			PosName  string
+			UserName string
+		}
+		db.Raw(`SELECT
+				(SELECT name FROM pos WHERE uuid = ?)       AS pos_name,
+				(SELECT fullname FROM users WHERE uuid = ?) AS user_name`, f.PosUUID, f.UserUUID).
+			Scan(&names)
+
+		recipients := TerritoryLeads(db, f.CountryUUID, f.ProvinceUUID, f.AreaUUID, f.SubAreaUUID, f.CommuneUUID, f.UserUUID)
+		Notify(db, recipients, models.Notification{
+			Type:  models.NotifyObservation,
+			Title: fmt.Sprintf("Nouvelle observation — %s", names.PosName),
+			Body:  fmt.Sprintf("%s: %s", names.UserName, comment),
+			Payload: models.JSONMap{
+				"pos_form_uuid": f.UUID,
+				"pos_uuid":      f.PosUUID,
+				"user_uuid":     f.UserUUID,
+			},
+		})
+	}
+}
+
+// NotifyRoutePlan tells its agent about a published route plan.
+func NotifyRoutePlan(db *gorm.DB, plan *models.RoutePlan) {
+	if plan.Status == models.RoutePlanDraft || plan.UserUUID == "" {
+		return
+	}
+	day := plan.CreatedAt
+	if plan.PlanDate != nil {
+		day = *plan.PlanDate
+	}
+	var stops int64
+	db.Model(&models.RoutePlanItem{}).Where("route_plan_uuid = ?", plan.UUID).Count(&stops)
+
+	Notify(db, []string{plan.UserUUID}, models.Notification{
+		Type:  models.NotifyRoutePlan,
+		Title: "Nouveau plan de route",
+		Body:  fmt.Sprintf("Plan de route du %s : %d POS à visiter.", day.Format("02/01/2006"), stops),
+		Payload: models.JSONMap{
+			"route_plan_uuid": plan.UUID,
+			"plan_date":       day.Format("2006-01-02"),
+		},
+		Key: "route_plan:" + plan.UUID,
+	})
+}
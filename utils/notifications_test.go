@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestNotificationHub(t *testing.T) {
+	hub := &NotificationHub{subscribers: map[string]map[chan models.Notification]struct{}{}}
+	phone, cancelPhone := hub.Subscribe("u1")
+	browser, cancelBrowser := hub.Subscribe("u1")
+	other, cancelOther := hub.Subscribe("u2")
+	defer cancelOther()
+
+	hub.Publish(models.Notification{UUID: "n1", UserUUID: "u1"})
+	for name, ch := range map[string]<-chan models.Notification{"phone": phone, "browser": browser} {
+		select {
+		case n := <-ch:
+			if n.UUID != "n1" {
+				t.Errorf("%s received %s, want n1", name, n.UUID)
+			}
+		default:
+			t.Errorf("%s received nothing", name)
+		}
+	}
+	select {
+	case n := <-other:
+		t.Errorf("u2 received %s of u1", n.UUID)
+	default:
+	}
+
+	// A client that does not read misses what overflows its buffer
+	for i := 0; i < cap(phone)+5; i++ {
+		hub.Publish(models.Notification{UserUUID: "u1"})
+	}
+	if len(phone) != cap(phone) {
+		t.Errorf("%d waiting notifications, want the %d of the buffer", len(phone), cap(phone))
+	}
+
+	cancelPhone()
+	cancelPhone()
+	cancelBrowser()
+	if _, ok := hub.subscribers["u1"]; ok {
+		t.Error("u1 still subscribed after closing its clients")
+	}
+	for range phone {
+	}
+	hub.Publish(models.Notification{UserUUID: "u1"}) // No client left: dropped
+}
+
+func TestNotify(t *testing.T) {
+	db, rec := dryRun(t)
+	ch, cancel := Notifications.Subscribe("u2")
+	defer cancel()
+
+	Notify(db, []string{"u1", "", "u2", "u1"}, models.Notification{Type: models.NotifyObservation, Title: "Observation"})
+	creates := 0
+	for _, sql := range rec.statements {
+		if strings.HasPrefix(sql, `INSERT INTO "notifications"`) {
+			creates++
+		}
+		if strings.Contains(sql, "count(") {
+			t.Errorf("notification without a key is looked up: %s", sql)
+		}
+	}
+	if creates != 2 {
+		t.Errorf("%d notifications saved, want one for each of u1 and u2", creates)
+	}
+	select {
+	case n := <-ch:
+		if n.UserUUID != "u2" || n.UUID == "" || n.Title != "Observation" {
+			t.Errorf("u2 received %+v", n)
+		}
+	default:
+		t.Error("u2 received nothing")
+	}
+
+	rec.statements = nil
+	Notify(db, []string{"u1"}, models.Notification{Type: models.NotifyRoutePlan, Key: "route_plan:rp1"})
+	if len(rec.statements) != 2 || !strings.Contains(rec.statements[0], "key = 'route_plan:rp1'") {
+		t.Errorf("statements = %q, want the lookup of the key then the insert", rec.statements)
+	}
+}
+
+func TestNotifyRoutePlanSkipsDrafts(t *testing.T) {
+	db, rec := dryRun(t)
+	NotifyRoutePlan(db, &models.RoutePlan{UUID: "rp1", UserUUID: "u1", Status: models.RoutePlanDraft})
+	NotifyRoutePlan(db, &models.RoutePlan{UUID: "rp2", Status: models.RoutePlanPublished})
+	if len(rec.statements) != 0 {
+		t.Errorf("statements = %q, want none for a draft or a plan without agent", rec.statements)
+	}
+}
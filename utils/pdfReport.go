@@ -0,0 +1,510 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// PDFReportConfig holds the branding of a PDF report
+type PDFReportConfig struct {
+	Title       string
+	Subtitle    string
+	CompanyName string
+	ReportDate  time.Time
+	Author      string
+}
+
+// PDFCard is a KPI card: a label, its value and an optional note
+type PDFCard struct {
+	Label string
+	Value string
+	Note  string
+}
+
+// PDFColumn is a column of a PDF table. Width is in mm; the columns without
+// one share the rest of the page. Align is L, C or R.
+type PDFColumn struct {
+	Title string
+	Width float64
+	Align string
+}
+
+// PDFSeries is a series of a line chart, one value per label
+type PDFSeries struct {
+	Name   string
+	Values []float64
+}
+
+type rgb struct{ r, g, b int }
+
+// Colours of the report, those of the Excel reports
+var (
+	pdfDarkBlue  = rgb{0x1F, 0x4E, 0x79}
+	pdfBlue      = rgb{0x2E, 0x75, 0xB6}
+	pdfLightBlue = rgb{0xD6, 0xE4, 0xF0}
+	pdfAltRow    = rgb{0xF2, 0xF7, 0xFB}
+	pdfGrey      = rgb{0x80, 0x80, 0x80}
+	pdfGrid      = rgb{0xD3, 0xD3, 0xD3}
+
+	pdfPalette = []rgb{
+		{0x2E, 0x75, 0xB6}, {0xED, 0x7D, 0x31}, {0x70, 0xAD, 0x47}, {0xFF, 0xC0, 0x00},
+		{0x5B, 0x9B, 0xD5}, {0xA5, 0xA5, 0xA5}, {0x26, 0x44, 0x78}, {0x9E, 0x48, 0x0E},
+	}
+)
+
+// PDFReport composes a branded A4 report page by page: every page has the
+// company band and title at the top and the page number at the bottom.
+type PDFReport struct {
+	pdf    *gofpdf.Fpdf
+	tr     func(string) string
+	config PDFReportConfig
+}
+
+const (
+	pdfMargin     = 15.0
+	pdfHeaderBand = 14.0
+)
+
+// NewPDFReport creates an empty report
+func NewPDFReport(config PDFReportConfig) *PDFReport {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	r := &PDFReport{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor(""), config: config}
+
+	pdf.SetTitle(config.Title, true)
+	pdf.SetAuthor(config.Author, true)
+	pdf.SetCreator(config.CompanyName, true)
+	pdf.SetMargins(pdfMargin, pdfHeaderBand+10, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin+5)
+	pdf.AliasNbPages("{nb}")
+
+	pdf.SetHeaderFunc(func() {
+		w, _ := pdf.GetPageSize()
+		r.fill(pdfDarkBlue)
+		pdf.Rect(0, 0, w, pdfHeaderBand, "F")
+		pdf.SetTextColor(255, 255, 255)
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.SetXY(pdfMargin, 0)
+		pdf.CellFormat(w/2-pdfMargin, pdfHeaderBand, r.tr(config.CompanyName), "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(w/2-pdfMargin, pdfHeaderBand, r.tr(config.Title), "", 0, "R", false, 0, "")
+		pdf.SetXY(pdfMargin, pdfHeaderBand+10)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin)
+		pdf.SetFont("Helvetica", "I", 8)
+		r.text(pdfGrey)
+		pdf.CellFormat(0, 5, r.tr(fmt.Sprintf("%s — %s", config.Subtitle, config.ReportDate.Format("02/01/2006 15:04"))), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	return r
+}
+
+func (r *PDFReport) fill(c rgb) { r.pdf.SetFillColor(c.r, c.g, c.b) }
+func (r *PDFReport) draw(c rgb) { r.pdf.SetDrawColor(c.r, c.g, c.b) }
+func (r *PDFReport) text(c rgb) { r.pdf.SetTextColor(c.r, c.g, c.b) }
+
+// width is the width between the margins
+func (r *PDFReport) width() float64 {
+	w, _ := r.pdf.GetPageSize()
+	return w - 2*pdfMargin
+}
+
+// ensure starts a new page unless h mm are left on this one
+func (r *PDFReport) ensure(h float64) {
+	_, pageH := r.pdf.GetPageSize()
+	if r.pdf.GetY()+h > pageH-pdfMargin-5 {
+		r.pdf.AddPage()
+	}
+}
+
+// CoverPage adds the title page with the filters of the report
+func (r *PDFReport) CoverPage(filters []ReportFilter) {
+	pdf := r.pdf
+	pdf.AddPage()
+	w := r.width()
+
+	pdf.SetY(70)
+	pdf.SetFont("Helvetica", "B", 24)
+	r.text(pdfDarkBlue)
+	pdf.MultiCell(w, 11, r.tr(r.config.Title), "", "C", false)
+	if r.config.Subtitle != "" {
+		pdf.SetFont("Helvetica", "", 14)
+		r.text(pdfBlue)
+		pdf.MultiCell(w, 8, r.tr(r.config.Subtitle), "", "C", false)
+	}
+
+	pdf.Ln(6)
+	r.draw(pdfBlue)
+	pdf.SetLineWidth(0.8)
+	pdf.Line(pdfMargin+w/4, pdf.GetY(), pdfMargin+3*w/4, pdf.GetY())
+	pdf.SetLineWidth(0.2)
+	pdf.Ln(12)
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, f := range filters {
+		pdf.SetX(pdfMargin + w/6)
+		r.text(pdfBlue)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(w/4, 7, r.tr(f.Label), "", 0, "L", false, 0, "")
+		r.text(rgb{0, 0, 0})
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(w/2, 7, r.tr(f.Value), "", 1, "L", false, 0, "")
+	}
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "I", 9)
+	r.text(pdfGrey)
+	line := "Date du rapport: " + r.config.ReportDate.Format("02/01/2006 15:04:05")
+	if r.config.Author != "" {
+		line += "  —  Généré par: " + r.config.Author
+	}
+	pdf.CellFormat(w, 6, r.tr(line), "", 1, "C", false, 0, "")
+}
+
+// Section starts a titled section, on a new page when asked or when less
+// than a third of the page is left
+func (r *PDFReport) Section(title string, newPage bool) {
+	_, pageH := r.pdf.GetPageSize()
+	if newPage || r.pdf.PageNo() == 0 {
+		r.pdf.AddPage()
+	} else {
+		r.ensure(pageH / 3)
+	}
+	r.pdf.Ln(2)
+	r.pdf.SetFont("Helvetica", "B", 14)
+	r.text(pdfDarkBlue)
+	r.pdf.CellFormat(r.width(), 8, r.tr(title), "B", 1, "L", false, 0, "")
+	r.pdf.Ln(4)
+}
+
+// Heading adds a sub title within a section
+func (r *PDFReport) Heading(title string) {
+	r.ensure(20)
+	r.pdf.SetFont("Helvetica", "B", 11)
+	r.text(pdfBlue)
+	r.pdf.CellFormat(r.width(), 7, r.tr(title), "", 1, "L", false, 0, "")
+	r.pdf.Ln(1)
+}
+
+// Paragraph adds a block of text
+func (r *PDFReport) Paragraph(text string) {
+	r.pdf.SetFont("Helvetica", "", 9)
+	r.text(rgb{0x40, 0x40, 0x40})
+	r.pdf.MultiCell(r.width(), 5, r.tr(text), "", "L", false)
+	r.pdf.Ln(2)
+}
+
+// KPICards lays out the cards three per row
+func (r *PDFReport) KPICards(cards []PDFCard) {
+	const perRow, h, gap = 3, 22.0, 4.0
+	pdf := r.pdf
+	w := (r.width() - gap*(perRow-1)) / perRow
+
+	for i, card := range cards {
+		if i%perRow == 0 {
+			if i > 0 {
+				pdf.SetY(pdf.GetY() + h + gap)
+			}
+			r.ensure(h + gap)
+		}
+		x := pdfMargin + float64(i%perRow)*(w+gap)
+		y := pdf.GetY()
+
+		r.fill(pdfLightBlue)
+		r.draw(pdfBlue)
+		pdf.Rect(x, y, w, h, "FD")
+		r.fill(pdfBlue)
+		pdf.Rect(x, y, 1.5, h, "F")
+
+		pdf.SetXY(x+4, y+2)
+		pdf.SetFont("Helvetica", "", 8)
+		r.text(pdfDarkBlue)
+		pdf.CellFormat(w-6, 5, r.tr(card.Label), "", 0, "L", false, 0, "")
+		pdf.SetXY(x+4, y+8)
+		pdf.SetFont("Helvetica", "B", 15)
+		pdf.CellFormat(w-6, 8, r.tr(card.Value), "", 0, "L", false, 0, "")
+		if card.Note != "" {
+			pdf.SetXY(x+4, y+16)
+			pdf.SetFont("Helvetica", "", 7)
+			r.text(pdfGrey)
+			pdf.CellFormat(w-6, 4, r.tr(card.Note), "", 0, "L", false, 0, "")
+		}
+	}
+	if len(cards) > 0 {
+		pdf.SetXY(pdfMargin, pdf.GetY()+h+gap+2)
+	}
+}
+
+// fit shortens s with an ellipsis until it fits in w mm
+func (r *PDFReport) fit(s string, w float64) string {
+	s = r.tr(s)
+	if r.pdf.GetStringWidth(s) <= w {
+		return s
+	}
+	for len(s) > 0 && r.pdf.GetStringWidth(s+"...") > w {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+// Table adds a table; its header is repeated on every page it spans
+func (r *PDFReport) Table(columns []PDFColumn, rows [][]string) {
+	pdf := r.pdf
+	const rowH = 6.0
+
+	// Columns without a width share what is left
+	widths := make([]float64, len(columns))
+	rest, free := r.width(), 0
+	for i, col := range columns {
+		widths[i] = col.Width
+		rest -= col.Width
+		if col.Width == 0 {
+			free++
+		}
+	}
+	for i := range widths {
+		if widths[i] == 0 && free > 0 {
+			widths[i] = math.Max(rest/float64(free), 10)
+		}
+	}
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		r.fill(pdfBlue)
+		r.draw(rgb{255, 255, 255})
+		pdf.SetTextColor(255, 255, 255)
+		for i, col := range columns {
+			pdf.CellFormat(widths[i], rowH+1, r.fit(col.Title, widths[i]-2), "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	r.ensure(3 * rowH)
+	header()
+	if len(rows) == 0 {
+		pdf.SetFont("Helvetica", "I", 8)
+		r.text(pdfGrey)
+		pdf.CellFormat(r.width(), rowH, r.tr("Aucune donnée sur la période"), "", 1, "C", false, 0, "")
+	}
+	r.draw(pdfGrid)
+	for n, row := range rows {
+		_, pageH := pdf.GetPageSize()
+		if pdf.GetY()+rowH > pageH-pdfMargin-5 {
+			pdf.AddPage()
+			header()
+			r.draw(pdfGrid)
+		}
+		pdf.SetFont("Helvetica", "", 8)
+		r.text(rgb{0, 0, 0})
+		if n%2 == 1 {
+			r.fill(pdfAltRow)
+		} else {
+			pdf.SetFillColor(255, 255, 255)
+		}
+		for i := range columns {
+			v := ""
+			if i < len(row) {
+				v = row[i]
+			}
+			align := columns[i].Align
+			if align == "" {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], rowH, r.fit(v, widths[i]-2), "1", 0, align, true, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	pdf.Ln(4)
+}
+
+// niceMax rounds v up to 1, 2 or 5 × a power of ten for a chart axis
+func niceMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// axisLabel formats an axis or bar value
+func axisLabel(v float64, unit string) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if v != math.Trunc(v) {
+		s = strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	return s + unit
+}
+
+// BarChart adds a horizontal bar chart, one bar per label
+func (r *PDFReport) BarChart(title string, labels []string, values []float64, unit string) {
+	pdf := r.pdf
+	const barH, gap, labelW = 6.0, 2.0, 45.0
+	h := float64(len(labels))*(barH+gap) + 16
+	r.ensure(h)
+	r.Heading(title)
+
+	maxV := 0.0
+	for _, v := range values {
+		maxV = math.Max(maxV, v)
+	}
+	maxV = niceMax(maxV)
+
+	x0 := pdfMargin + labelW
+	plotW := r.width() - labelW - 20
+	y := pdf.GetY()
+
+	// Vertical grid every quarter of the axis
+	pdf.SetFont("Helvetica", "", 7)
+	r.draw(pdfGrid)
+	for i := 0; i <= 4; i++ {
+		x := x0 + plotW*float64(i)/4
+		pdf.Line(x, y, x, y+float64(len(labels))*(barH+gap))
+		r.text(pdfGrey)
+		pdf.Text(x-3, y+float64(len(labels))*(barH+gap)+4, axisLabel(maxV*float64(i)/4, unit))
+	}
+
+	for i, label := range labels {
+		by := y + float64(i)*(barH+gap)
+		pdf.SetXY(pdfMargin, by)
+		pdf.SetFont("Helvetica", "", 8)
+		r.text(rgb{0, 0, 0})
+		pdf.CellFormat(labelW-2, barH, r.fit(label, labelW-3), "", 0, "R", false, 0, "")
+
+		v := 0.0
+		if i < len(values) {
+			v = values[i]
+		}
+		bw := plotW * math.Max(v, 0) / maxV
+		r.fill(pdfPalette[0])
+		pdf.Rect(x0, by, bw, barH, "F")
+		pdf.SetXY(x0+bw+1, by)
+		pdf.SetFont("Helvetica", "", 7)
+		pdf.CellFormat(18, barH, axisLabel(math.Round(v*10)/10, unit), "", 0, "L", false, 0, "")
+	}
+	pdf.SetXY(pdfMargin, y+float64(len(labels))*(barH+gap)+8)
+}
+
+// LineChart adds a line chart of the series over the labels, with its
+// legend below
+func (r *PDFReport) LineChart(title string, labels []string, series []PDFSeries, unit string) {
+	pdf := r.pdf
+	const plotH, axisW = 60.0, 14.0
+	r.ensure(plotH + 30)
+	r.Heading(title)
+	if len(labels) == 0 {
+		r.Paragraph("Aucune donnée sur la période")
+		return
+	}
+
+	maxV := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			maxV = math.Max(maxV, v)
+		}
+	}
+	maxV = niceMax(maxV)
+
+	x0 := pdfMargin + axisW
+	y0 := pdf.GetY() + 2
+	plotW := r.width() - axisW - 4
+	step := plotW
+	if len(labels) > 1 {
+		step = plotW / float64(len(labels)-1)
+	}
+	xAt := func(i int) float64 {
+		if len(labels) == 1 {
+			return x0 + plotW/2
+		}
+		return x0 + float64(i)*step
+	}
+	yAt := func(v float64) float64 { return y0 + plotH - plotH*math.Max(v, 0)/maxV }
+
+	// Horizontal grid and axis labels
+	pdf.SetFont("Helvetica", "", 7)
+	pdf.SetLineWidth(0.1)
+	for i := 0; i <= 4; i++ {
+		v := maxV * float64(i) / 4
+		r.draw(pdfGrid)
+		pdf.Line(x0, yAt(v), x0+plotW, yAt(v))
+		r.text(pdfGrey)
+		pdf.SetXY(pdfMargin, yAt(v)-2)
+		pdf.CellFormat(axisW-1, 4, axisLabel(v, unit), "", 0, "R", false, 0, "")
+	}
+	for i, label := range labels {
+		pdf.SetXY(xAt(i)-10, y0+plotH+1)
+		pdf.CellFormat(20, 4, r.tr(label), "", 0, "C", false, 0, "")
+	}
+
+	pdf.SetLineWidth(0.6)
+	for n, s := range series {
+		c := pdfPalette[n%len(pdfPalette)]
+		r.draw(c)
+		r.fill(c)
+		for i, v := range s.Values {
+			if i >= len(labels) {
+				break
+			}
+			if i > 0 {
+				pdf.Line(xAt(i-1), yAt(s.Values[i-1]), xAt(i), yAt(v))
+			}
+			pdf.Circle(xAt(i), yAt(v), 0.8, "F")
+		}
+	}
+	pdf.SetLineWidth(0.2)
+
+	// Legend
+	pdf.SetXY(x0, y0+plotH+7)
+	pdf.SetFont("Helvetica", "", 7)
+	for n, s := range series {
+		c := pdfPalette[n%len(pdfPalette)]
+		name := r.fit(s.Name, 35)
+		w := pdf.GetStringWidth(name) + 9
+		if pdf.GetX()+w > pdfMargin+r.width() {
+			pdf.SetXY(x0, pdf.GetY()+5)
+		}
+		r.fill(c)
+		pdf.Rect(pdf.GetX(), pdf.GetY()+1, 3, 3, "F")
+		pdf.SetX(pdf.GetX() + 4)
+		r.text(rgb{0, 0, 0})
+		pdf.CellFormat(w-4, 5, name, "", 0, "L", false, 0, "")
+	}
+	pdf.SetXY(pdfMargin, pdf.GetY()+10)
+}
+
+// Output writes the PDF document
+func (r *PDFReport) Output(w io.Writer) error {
+	return r.pdf.Output(w)
+}
+
+// FormatNumber formats v with spaces between thousands and the given
+// decimals, e.g. 12 345,6
+func FormatNumber(v float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(d)
+	}
+	out := b.String()
+	if frac != "" {
+		out += "," + frac
+	}
+	if v < 0 {
+		out = "-" + out
+	}
+	return out
+}
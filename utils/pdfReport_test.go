@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFormatNumber(t *testing.T) {
+	for _, tt := range []struct {
+		v        float64
+		decimals int
+		want     string
+	}{
+		{0, 0, "0"},
+		{999, 0, "999"},
+		{1000, 0, "1 000"},
+		{1234567.891, 2, "1 234 567,89"},
+		{-12345.6, 1, "-12 345,6"},
+		{-0.04, 1, "-0,0"},
+		{99.95, 1, "100,0"},
+	} {
+		if got := FormatNumber(tt.v, tt.decimals); got != tt.want {
+			t.Errorf("FormatNumber(%v, %d) = %q, want %q", tt.v, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestNiceMax(t *testing.T) {
+	for v, want := range map[float64]float64{-3: 1, 0: 1, 0.3: 0.5, 1: 1, 7: 10, 12: 20, 45: 50, 100: 100, 101: 200} {
+		if got := niceMax(v); got != want {
+			t.Errorf("niceMax(%v) = %v, want %v", v, got, want)
+		}
+	}
+}
+
+// pdfPages counts the page objects of a PDF document.
+var pdfPages = regexp.MustCompile(`/Type /Page\b[^s]`)
+
+func TestPDFReport(t *testing.T) {
+	r := NewPDFReport(PDFReportConfig{
+		Title:       "Revue mensuelle",
+		Subtitle:    "Kinshasa — Février 2026",
+		CompanyName: "MSPOS",
+		ReportDate:  time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC),
+		Author:      "Équipe données",
+	})
+	r.CoverPage([]ReportFilter{{Label: "Période", Value: "Février 2026"}, {Label: "Territoire", Value: "Kinshasa"}})
+
+	r.Section("Indicateurs clés", true)
+	r.KPICards([]PDFCard{{Label: "Visites", Value: "1 234", Note: "+12 %"}, {Label: "ND", Value: "45,2 %"}})
+	r.Paragraph("Une phrase assez longue pour passer à la ligne, avec des accents : é, è, à, ç.")
+	r.BarChart("ND par marque", []string{"Alpha", "Zeta"}, []float64{45.2, 12}, " %")
+	r.LineChart("Tendance", []string{"2026-01", "2026-02"}, []PDFSeries{{Name: "Alpha", Values: []float64{40, 45.2}}}, " %")
+	// Empty charts draw nothing rather than failing
+	r.BarChart("Vide", nil, nil, "")
+	r.LineChart("Vide", nil, nil, "")
+
+	r.Section("Agents", true)
+	rows := make([][]string, 80)
+	for i := range rows {
+		rows[i] = []string{"Agent " + strconv.Itoa(i), FormatNumber(float64(i*100), 0)}
+	}
+	r.Table([]PDFColumn{{Title: "Agent"}, {Title: "Visites", Width: 30, Align: "R"}}, rows)
+
+	var buf bytes.Buffer
+	if err := r.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte("%PDF-")) || !bytes.HasSuffix(bytes.TrimSpace(out), []byte("%%EOF")) {
+		t.Fatalf("not a PDF document: %q…", out[:min(len(out), 20)])
+	}
+	// Cover, indicators, and the 80 agents over more than one page
+	if pages := len(pdfPages.FindAll(out, -1)); pages < 4 {
+		t.Errorf("%d pages, want the table to break onto a new page", pages)
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// PriceUnits are the units a price can be captured in.
+var PriceUnits = []string{models.PriceUnitFarde, models.PriceUnitPack}
+
+// NormalizePriceUnit returns the unit in lower case, farde when empty.
+func NormalizePriceUnit(unit string) (string, error) {
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	if unit == "" {
+		return models.PriceUnitFarde, nil
+	}
+	for _, u := range PriceUnits {
+		if unit == u {
+			return unit, nil
+		}
+	}
+	return "", fmt.Errorf("price_unit must be one of %s", strings.Join(PriceUnits, ", "))
+}
+
+// NormalizeCurrency returns the ISO 4217 code in upper case, the default
+// currency when empty.
+func NormalizeCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return models.DefaultCurrency, nil
+	}
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", fmt.Errorf("currency must be a 3-letter ISO 4217 code")
+	}
+	return currency, nil
+}
+
+// NormalizeItemPrice validates the shelf price of a brand line. A line
+// without price has no unit nor currency; a priced line defaults to a
+// price per farde in currency, the currency of its visit. The field at
+// fault is returned with the error.
+func NormalizeItemPrice(it *models.PosFormItems, currency string) (string, error) {
+	if it.Price < 0 {
+		return "price", fmt.Errorf("price must not be negative")
+	}
+	if it.Price == 0 {
+		it.PriceUnit, it.Currency = "", ""
+		return "", nil
+	}
+	unit, err := NormalizePriceUnit(it.PriceUnit)
+	if err != nil {
+		return "price_unit", err
+	}
+	if strings.TrimSpace(it.Currency) != "" {
+		if currency, err = NormalizeCurrency(it.Currency); err != nil {
+			return "currency", err
+		}
+	}
+	it.PriceUnit, it.Currency = unit, currency
+	return "", nil
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestNormalizeItemPrice(t *testing.T) {
+	tests := []struct {
+		name           string
+		item           models.PosFormItems
+		field          string // At fault, empty when valid
+		unit, currency string
+	}{
+		{"no price", models.PosFormItems{PriceUnit: "pack", Currency: "USD"}, "", "", ""},
+		{"defaults", models.PosFormItems{Price: 2500}, "", models.PriceUnitFarde, "CDF"},
+		{"own unit and currency", models.PosFormItems{Price: 2.5, PriceUnit: " Pack ", Currency: "usd"}, "", models.PriceUnitPack, "USD"},
+		{"negative price", models.PosFormItems{Price: -1}, "price", "", ""},
+		{"unknown unit", models.PosFormItems{Price: 1, PriceUnit: "carton"}, "price_unit", "", ""},
+		{"bad currency", models.PosFormItems{Price: 1, Currency: "US$"}, "currency", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := tt.item
+			field, err := NormalizeItemPrice(&it, "CDF")
+			if field != tt.field || (err != nil) != (tt.field != "") {
+				t.Fatalf("field %q, err %v, want field %q", field, err, tt.field)
+			}
+			if tt.field == "" && (it.PriceUnit != tt.unit || it.Currency != tt.currency) {
+				t.Errorf("unit %q currency %q, want %q %q", it.PriceUnit, it.Currency, tt.unit, tt.currency)
+			}
+		})
+	}
+}
+
+func TestNormalizeCurrency(t *testing.T) {
+	valid := map[string]string{"": models.DefaultCurrency, "usd": "USD", " Cdf ": "CDF", "EUR": "EUR"}
+	for in, want := range valid {
+		if got, err := NormalizeCurrency(in); err != nil || got != want {
+			t.Errorf("NormalizeCurrency(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"US", "USDT", "U$D", "€€€", "12A"} {
+		if got, err := NormalizeCurrency(in); err == nil {
+			t.Errorf("NormalizeCurrency(%q) = %q, want an error", in, got)
+		}
+	}
+}
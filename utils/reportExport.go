@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// ReportOptions tells a report builder how it is run: in the request, or
+// by an export job that follows its progress and may stream the rows.
+type ReportOptions struct {
+	// Progress is called with the rows written so far and the rows to
+	// write. It may be nil.
+	Progress func(done, total int)
+
+	// Stream writes the detail rows through an excelize StreamWriter and
+	// lifts the row limit of the in-request reports.
+	Stream bool
+}
+
+// ReportProgress calls opts.Progress when set.
+func (opts ReportOptions) ReportProgress(done, total int) {
+	if opts.Progress != nil {
+		opts.Progress(done, total)
+	}
+}
+
+// ReportBuilder builds a workbook from the query params and user of c and
+// returns it with its file name.
+type ReportBuilder func(c *fiber.Ctx, opts ReportOptions) (*excelize.File, string, error)
+
+// ReportError is a failure of a report builder with the status and message
+// the report handler answers with.
+type ReportError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *ReportError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *ReportError) Unwrap() error { return e.Err }
+
+// SendReport builds the report and sends it as the response, or the JSON
+// error of the builder.
+func SendReport(c *fiber.Ctx, build ReportBuilder) error {
+	f, filename, err := build(c, ReportOptions{})
+	if err != nil {
+		return SendReportError(c, err)
+	}
+	defer f.Close()
+
+	buffer, err := f.WriteToBuffer()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Erreur lors de la génération du fichier Excel",
+			"error":   err.Error(),
+		})
+	}
+
+	c.Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	return c.Send(buffer.Bytes())
+}
+
+// DocumentBuilder builds a report that is not a workbook, e.g. a PDF, from
+// the query params and user of c and returns its content with its file
+// name, whose extension gives its type.
+type DocumentBuilder func(c *fiber.Ctx, opts ReportOptions) ([]byte, string, error)
+
+// SendDocument builds the document and sends it as the response, or the
+// JSON error of the builder.
+func SendDocument(c *fiber.Ctx, build DocumentBuilder) error {
+	content, filename, err := build(c, ReportOptions{})
+	if err != nil {
+		return SendReportError(c, err)
+	}
+
+	c.Type(filepath.Ext(filename))
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	return c.Send(content)
+}
+
+// SendReportError answers with the status and message of a ReportError,
+// or a 500 for any other error.
+func SendReportError(c *fiber.Ctx, err error) error {
+	re, ok := err.(*ReportError)
+	if !ok {
+		re = &ReportError{Status: 500, Message: "Erreur lors de la génération du rapport", Err: err}
+	}
+	body := fiber.Map{
+		"status":  "error",
+		"message": re.Message,
+		"data":    nil,
+	}
+	if re.Err != nil {
+		body["error"] = re.Err.Error()
+	}
+	return c.Status(re.Status).JSON(body)
+}
+
+// SheetRowWriter writes the rows of a sheet in order, through a
+// StreamWriter when streaming so the written rows are not kept in memory.
+// A streamed sheet must only be written through the writer.
+type SheetRowWriter struct {
+	f      *excelize.File
+	sheet  string
+	stream *excelize.StreamWriter
+}
+
+// NewSheetRowWriter creates a writer for an existing sheet.
+func NewSheetRowWriter(f *excelize.File, sheet string, stream bool) (*SheetRowWriter, error) {
+	w := &SheetRowWriter{f: f, sheet: sheet}
+	if stream {
+		sw, err := f.NewStreamWriter(sheet)
+		if err != nil {
+			return nil, err
+		}
+		w.stream = sw
+	}
+	return w, nil
+}
+
+// SetColWidth sets the width of the columns from min to max, 1-based. When
+// streaming it must be called before the first row.
+func (w *SheetRowWriter) SetColWidth(min, max int, width float64) error {
+	if w.stream != nil {
+		return w.stream.SetColWidth(min, max, width)
+	}
+	from, _ := excelize.ColumnNumberToName(min)
+	to, _ := excelize.ColumnNumberToName(max)
+	return w.f.SetColWidth(w.sheet, from, to, width)
+}
+
+// SetRow writes values from column A of row, 1-based, each with the style
+// at the same index of styles.
+func (w *SheetRowWriter) SetRow(row int, values []interface{}, styles []int) error {
+	if w.stream != nil {
+		cells := make([]interface{}, len(values))
+		for i, v := range values {
+			cell := excelize.Cell{Value: v}
+			if i < len(styles) {
+				cell.StyleID = styles[i]
+			}
+			cells[i] = cell
+		}
+		axis, _ := excelize.CoordinatesToCellName(1, row)
+		return w.stream.SetRow(axis, cells)
+	}
+	for i, v := range values {
+		axis, _ := excelize.CoordinatesToCellName(i+1, row)
+		if err := w.f.SetCellValue(w.sheet, axis, v); err != nil {
+			return err
+		}
+		if i < len(styles) && styles[i] != 0 {
+			if err := w.f.SetCellStyle(w.sheet, axis, axis, styles[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MergeCell merges the cells from top left to bottom right, e.g. "A1", "H1".
+func (w *SheetRowWriter) MergeCell(topLeft, bottomRight string) error {
+	if w.stream != nil {
+		return w.stream.MergeCell(topLeft, bottomRight)
+	}
+	return w.f.MergeCell(w.sheet, topLeft, bottomRight)
+}
+
+// Flush ends a streamed sheet. It does nothing otherwise.
+func (w *SheetRowWriter) Flush() error {
+	if w.stream != nil {
+		return w.stream.Flush()
+	}
+	return nil
+}
+
+// EachBatch loads the rows of query, ordered by updated_at then uuid of
+// table, most recent first, by batches of size and calls fn with each
+// batch. key returns the updated_at and uuid of a row; the next batch
+// starts after the last row of the previous one.
+func EachBatch[T any](query *gorm.DB, table string, size int, key func(*T) (time.Time, string), fn func(batch []T) error) error {
+	var lastAt time.Time
+	lastUUID := ""
+	for {
+		q := query.Session(&gorm.Session{})
+		if lastUUID != "" {
+			q = q.Where(fmt.Sprintf("(%[1]s.updated_at, %[1]s.uuid) < (?, ?)", table), lastAt, lastUUID)
+		}
+		batch := make([]T, 0, size)
+		err := q.Order(table + ".updated_at DESC").
+			Order(table + ".uuid DESC").
+			Limit(size).
+			Find(&batch).Error
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < size {
+			return nil
+		}
+		lastAt, lastUUID = key(&batch[len(batch)-1])
+	}
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ReportTable is a table of a report: a sheet of a workbook or a section
+// of a CSV file. Chart, when set, gives the type, title, label and value
+// columns of the chart of the table; its rows are those of the table.
+type ReportTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+	Chart   *ChartSpec
+}
+
+// ReportFilter is a filter of a report, listed on its cover sheet.
+type ReportFilter struct {
+	Label string
+	Value string
+}
+
+// maxChartRows caps the categories of a chart so that it stays readable.
+const maxChartRows = 50
+
+// sheetName makes a valid sheet name out of name, unique among used.
+func sheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, strings.Trim(name, "'"))
+	if name == "" {
+		name = "Données"
+	}
+	for utf8.RuneCountInString(name) > 31 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	unique := name
+	for i := 2; used[unique]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		base := []rune(name)
+		if len(base)+len(suffix) > 31 {
+			base = base[:31-len(suffix)]
+		}
+		unique = string(base) + suffix
+	}
+	used[unique] = true
+	return unique
+}
+
+// cellStyle picks the style of a value among those of SetupExcelStyles.
+func cellStyle(v interface{}, styles map[string]int) int {
+	switch v.(type) {
+	case float32, float64:
+		return styles["decimal"]
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return styles["number"]
+	}
+	return styles["data"]
+}
+
+// NewTableWorkbook creates a workbook with a cover sheet holding the header
+// of config, the filters and the list of the tables, then one sheet per
+// table with its chart.
+func NewTableWorkbook(config ExcelReportConfig, filters []ReportFilter, tables []ReportTable) (*excelize.File, error) {
+	f := CreateExcelFile(config)
+	styles, err := SetupExcelStyles(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// ── Cover sheet ──────────────────────────────────────────────────────────
+	cover := "Couverture"
+	f.SetSheetName("Sheet1", cover)
+	AddReportHeader(f, cover, config, styles)
+	f.SetColWidth(cover, "A", "A", 30)
+	f.SetColWidth(cover, "B", "B", 50)
+
+	row := 6
+	f.SetCellValue(cover, fmt.Sprintf("A%d", row), "Filtre")
+	f.SetCellValue(cover, fmt.Sprintf("B%d", row), "Valeur")
+	f.SetCellStyle(cover, fmt.Sprintf("A%d", row), fmt.Sprintf("B%d", row), styles["header"])
+	if len(filters) == 0 {
+		filters = []ReportFilter{{Label: "Aucun filtre"}}
+	}
+	for _, filter := range filters {
+		row++
+		f.SetCellValue(cover, fmt.Sprintf("A%d", row), filter.Label)
+		f.SetCellValue(cover, fmt.Sprintf("B%d", row), filter.Value)
+		f.SetCellStyle(cover, fmt.Sprintf("A%d", row), fmt.Sprintf("B%d", row), styles["data"])
+	}
+
+	row += 2
+	f.SetCellValue(cover, fmt.Sprintf("A%d", row), "Onglet")
+	f.SetCellValue(cover, fmt.Sprintf("B%d", row), "Lignes")
+	f.SetCellStyle(cover, fmt.Sprintf("A%d", row), fmt.Sprintf("B%d", row), styles["header"])
+	coverRow := row
+
+	// ── One sheet per table ──────────────────────────────────────────────────
+	used := map[string]bool{cover: true}
+	for _, t := range tables {
+		name := sheetName(t.Name, used)
+		if _, err := f.NewSheet(name); err != nil {
+			return nil, err
+		}
+
+		coverRow++
+		f.SetCellValue(cover, fmt.Sprintf("A%d", coverRow), name)
+		f.SetCellValue(cover, fmt.Sprintf("B%d", coverRow), len(t.Rows))
+		f.SetCellStyle(cover, fmt.Sprintf("A%d", coverRow), fmt.Sprintf("A%d", coverRow), styles["data"])
+		f.SetCellStyle(cover, fmt.Sprintf("B%d", coverRow), fmt.Sprintf("B%d", coverRow), styles["number"])
+
+		widths := make([]int, len(t.Columns))
+		for i, col := range t.Columns {
+			cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+			f.SetCellValue(name, cell, col)
+			f.SetCellStyle(name, cell, cell, styles["header"])
+			widths[i] = utf8.RuneCountInString(col)
+		}
+		for r, values := range t.Rows {
+			for i, v := range values {
+				if i >= len(t.Columns) {
+					break
+				}
+				cell, _ := excelize.CoordinatesToCellName(i+1, r+2)
+				f.SetCellValue(name, cell, v)
+				f.SetCellStyle(name, cell, cell, cellStyle(v, styles))
+				if s, ok := v.(string); ok && utf8.RuneCountInString(s) > widths[i] {
+					widths[i] = utf8.RuneCountInString(s)
+				}
+			}
+		}
+		for i, w := range widths {
+			col, _ := excelize.ColumnNumberToName(i + 1)
+			f.SetColWidth(name, col, col, float64(min(max(w+2, 10), 50)))
+		}
+		if len(t.Columns) == 0 {
+			continue
+		}
+
+		last, _ := excelize.CoordinatesToCellName(len(t.Columns), len(t.Rows)+1)
+		f.SetPanes(name, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+		f.AutoFilter(name, "A1:"+last, nil)
+
+		if t.Chart != nil && len(t.Rows) > 0 {
+			spec := *t.Chart
+			spec.HeaderRow = 1
+			spec.LastRow = 1 + min(len(t.Rows), maxChartRows)
+			cell, _ := excelize.CoordinatesToCellName(len(t.Columns)+2, 2)
+			if err := CreateChart(f, name, cell, spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	f.SetActiveSheet(0)
+	return f, nil
+}
+
+// csvValue formats a value of a table for CSV.
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
+
+// WriteReportCSV writes the tables as CSV, after a UTF-8 BOM so that Excel
+// reads the accents. When there are several tables, each one starts with a
+// line holding its name and ends with an empty line.
+func WriteReportCSV(w io.Writer, tables []ReportTable) error {
+	if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	for i, t := range tables {
+		if len(tables) > 1 {
+			if i > 0 {
+				cw.Write([]string{})
+			}
+			cw.Write([]string{t.Name})
+		}
+		cw.Write(t.Columns)
+		record := make([]string, len(t.Columns))
+		for _, values := range t.Rows {
+			for j := range record {
+				record[j] = ""
+				if j < len(values) {
+					record[j] = csvValue(values[j])
+				}
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// CachedResponse is a stored dashboard response with the territory and
+// period of the request that produced it.
+type CachedResponse struct {
+	Body        []byte
+	ContentType string
+	StoredAt    time.Time
+
+	CountryUUID  string
+	ProvinceUUID string
+	AreaUUID     string
+	SubAreaUUID  string
+	CommuneUUID  string
+	StartDate    string // As sent, empty when the request has no period
+	EndDate      string
+}
+
+// Covers reports whether a visit made in the territory on day may change
+// the response. Empty territory params match every territory. The period
+// is widened back by its own length for the period-over-period views, and
+// a response without a period is covered by every day.
+func (r *CachedResponse) Covers(countryUUID, provinceUUID, areaUUID, subAreaUUID, communeUUID string, day time.Time) bool {
+	match := func(filter, value string) bool { return filter == "" || filter == value }
+	if !match(r.CountryUUID, countryUUID) || !match(r.ProvinceUUID, provinceUUID) ||
+		!match(r.AreaUUID, areaUUID) || !match(r.SubAreaUUID, subAreaUUID) ||
+		!match(r.CommuneUUID, communeUUID) {
+		return false
+	}
+
+	start, errStart := parseCacheDate(r.StartDate)
+	end, errEnd := parseCacheDate(r.EndDate)
+	if errStart != nil || errEnd != nil {
+		return true
+	}
+	from := start.Add(-end.Sub(start) - 24*time.Hour)
+	d := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	return !d.Before(from) && !d.After(end)
+}
+
+func parseCacheDate(s string) (time.Time, error) {
+	if len(s) > 10 {
+		s = s[:10]
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// CacheStore keeps responses by key. MemoryCache is the in-process store; a
+// shared store only has to implement these methods.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, r *CachedResponse, ttl time.Duration)
+	DeleteFunc(match func(r *CachedResponse) bool)
+}
+
+// ResponseCache is the store of the dashboard responses.
+var ResponseCache CacheStore = NewMemoryCache(2000)
+
+type memoryCacheEntry struct {
+	response *CachedResponse
+	expires  time.Time
+}
+
+// MemoryCache is a CacheStore holding at most maxEntries responses in
+// process memory. When full, expired entries go first, then the oldest.
+type MemoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]memoryCacheEntry
+	maxEntries int
+}
+
+// NewMemoryCache creates an empty MemoryCache.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{entries: make(map[string]memoryCacheEntry), maxEntries: maxEntries}
+}
+
+func (m *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return e.response, true
+}
+
+func (m *MemoryCache) Set(key string, r *CachedResponse, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		m.evict()
+	}
+	m.entries[key] = memoryCacheEntry{response: r, expires: time.Now().Add(ttl)}
+}
+
+func (m *MemoryCache) DeleteFunc(match func(r *CachedResponse) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, e := range m.entries {
+		if match(e.response) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// evict makes room for one entry. m.mu must be held.
+func (m *MemoryCache) evict() {
+	now := time.Now()
+	oldestKey := ""
+	var oldest time.Time
+	for key, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, key)
+			continue
+		}
+		if oldestKey == "" || e.response.StoredAt.Before(oldest) {
+			oldestKey, oldest = key, e.response.StoredAt
+		}
+	}
+	if len(m.entries) >= m.maxEntries && oldestKey != "" {
+		delete(m.entries, oldestKey)
+	}
+}
+
+// InvalidateCachedResponses drops the cached responses a change of these
+// visits, or of their lines, may have made stale: those of the day of
+// creation and of check-in of each visit. Call it once the change is
+// committed, with the previous version of visits whose date or territory
+// changed.
+func InvalidateCachedResponses(forms ...*models.PosForm) {
+	for _, form := range forms {
+		if form == nil {
+			continue
+		}
+		days := []time.Time{form.CreatedAt.In(time.Local)}
+		if form.CheckInAt != nil {
+			days = append(days, form.CheckInAt.In(time.Local))
+		}
+		for _, day := range days {
+			ResponseCache.DeleteFunc(func(r *CachedResponse) bool {
+				return r.Covers(form.CountryUUID, form.ProvinceUUID, form.AreaUUID, form.SubAreaUUID, form.CommuneUUID, day)
+			})
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestCachedResponseCovers(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		return d
+	}
+	march := &CachedResponse{CountryUUID: "cd", ProvinceUUID: "kin", StartDate: "2026-03-11", EndDate: "2026-03-20 23:59:59"}
+
+	tests := []struct {
+		name  string
+		r     *CachedResponse
+		terr  [5]string
+		day   time.Time
+		cover bool
+	}{
+		{"visit in the period", march, [5]string{"cd", "kin", "a1", "s1", "c1"}, day("2026-03-15 10:00"), true},
+		{"last day, late", march, [5]string{"cd", "kin", "a1", "s1", "c1"}, day("2026-03-20 23:30"), true},
+		{"day after", march, [5]string{"cd", "kin", "a1", "s1", "c1"}, day("2026-03-21 00:10"), false},
+		{"previous period", march, [5]string{"cd", "kin", "a1", "s1", "c1"}, day("2026-03-01 08:00"), true},
+		{"before the previous period", march, [5]string{"cd", "kin", "a1", "s1", "c1"}, day("2026-02-28 08:00"), false},
+		{"other province", march, [5]string{"cd", "kongo", "a1", "s1", "c1"}, day("2026-03-15 10:00"), false},
+		{"no period", &CachedResponse{CountryUUID: "cd"}, [5]string{"cd", "kin", "a1", "s1", "c1"}, day("2020-01-01 10:00"), true},
+		{"other country", &CachedResponse{CountryUUID: "cd"}, [5]string{"cg", "", "", "", ""}, day("2026-03-15 10:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.Covers(tt.terr[0], tt.terr[1], tt.terr[2], tt.terr[3], tt.terr[4], tt.day); got != tt.cover {
+				t.Errorf("Covers = %v, want %v", got, tt.cover)
+			}
+		})
+	}
+}
+
+func TestInvalidateCachedResponses(t *testing.T) {
+	store := ResponseCache
+	t.Cleanup(func() { ResponseCache = store })
+	cache := NewMemoryCache(10)
+	ResponseCache = cache
+
+	for key, r := range map[string]*CachedResponse{
+		"kin-march": {CountryUUID: "cd", ProvinceUUID: "kin", StartDate: "2026-03-01", EndDate: "2026-03-31"},
+		"kin-april": {CountryUUID: "cd", ProvinceUUID: "kin", StartDate: "2026-04-01", EndDate: "2026-04-30"},
+		"kongo":     {CountryUUID: "cd", ProvinceUUID: "kongo"},
+		"country":   {CountryUUID: "cd"},
+	} {
+		cache.Set(key, r, time.Hour)
+	}
+
+	// Created in April, checked in on the last day of March
+	checkIn := time.Date(2026, 3, 31, 17, 0, 0, 0, time.Local)
+	InvalidateCachedResponses(nil, &models.PosForm{
+		CountryUUID: "cd", ProvinceUUID: "kin",
+		CreatedAt: time.Date(2026, 4, 2, 9, 0, 0, 0, time.Local), CheckInAt: &checkIn,
+	})
+
+	for key, kept := range map[string]bool{"kin-march": false, "kin-april": false, "kongo": true, "country": false} {
+		if _, ok := cache.Get(key); ok != kept {
+			t.Errorf("%s kept = %v, want %v", key, ok, kept)
+		}
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(2)
+	now := time.Now()
+	kept := func(keys ...string) {
+		t.Helper()
+		for _, key := range []string{"expired", "old", "new", "newer"} {
+			_, ok := cache.entries[key]
+			want := false
+			for _, k := range keys {
+				want = want || k == key
+			}
+			if ok != want {
+				t.Errorf("%s kept = %v, want %v", key, ok, want)
+			}
+		}
+	}
+
+	cache.Set("expired", &CachedResponse{StoredAt: now}, -time.Second)
+	cache.Set("old", &CachedResponse{StoredAt: now.Add(-time.Minute)}, time.Hour)
+	// Full: the expired entry goes first, however recent
+	cache.Set("new", &CachedResponse{StoredAt: now}, time.Hour)
+	kept("old", "new")
+	// Then the oldest
+	cache.Set("newer", &CachedResponse{StoredAt: now}, time.Hour)
+	kept("new", "newer")
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+// MatchRoutePlan links a visit to the route plan item it fulfils: a plan of
+// the visit's user, for the visit's POS, on the day of the visit. The item
+// is marked visited by the first such visit. A visit with no matching item
+// is unplanned and keeps an empty RoutePlanUUID.
+func MatchRoutePlan(tx *gorm.DB, form *models.PosForm) error {
+	form.RoutePlanUUID = ""
+
+	if form.UserUUID == "" || form.PosUUID == "" {
+		return nil
+	}
+
+	visitedAt := VisitStart(form)
+
+	var item models.RoutePlanItem
+	err := tx.Model(&models.RoutePlanItem{}).
+		Select("route_plan_items.*").
+		Joins("JOIN route_plans ON route_plans.uuid = route_plan_items.route_plan_uuid AND route_plans.deleted_at IS NULL").
+		Where("route_plans.status = ?", models.RoutePlanPublished).
+		Where("route_plans.user_uuid = ? AND route_plan_items.pos_uuid = ?", form.UserUUID, form.PosUUID).
+		Where("COALESCE(route_plans.plan_date, DATE(route_plans.created_at)) = ?", visitedAt.Format("2006-01-02")).
+		Order("route_plan_items.pos_form_uuid <> ''"). // Prefer an item not fulfilled yet
+		First(&item).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	form.RoutePlanUUID = item.RoutePlanUUID
+	if item.PosFormUUID != "" {
+		return nil
+	}
+
+	return tx.Model(&item).Updates(map[string]interface{}{
+		"status":        true,
+		"pos_form_uuid": form.UUID,
+		"visited_at":    visitedAt,
+	}).Error
+}
+
+// UnmatchRoutePlan releases the route plan item fulfilled by a visit, once
+// the visit is deleted or no longer matches it.
+func UnmatchRoutePlan(tx *gorm.DB, form *models.PosForm) error {
+	return tx.Model(&models.RoutePlanItem{}).
+		Where("pos_form_uuid = ?", form.UUID).
+		Updates(map[string]interface{}{
+			"status":        false,
+			"pos_form_uuid": "",
+			"visited_at":    nil,
+		}).Error
+}
+
+// RematchRoutePlan links an updated visit again when its agent, POS or day
+// changed, releasing the item its previous version fulfilled. Otherwise the
+// visit keeps its route plan.
+func RematchRoutePlan(tx *gorm.DB, before, after *models.PosForm) error {
+	if before.UserUUID == after.UserUUID && before.PosUUID == after.PosUUID &&
+		VisitStart(before).Format("2006-01-02") == VisitStart(after).Format("2006-01-02") {
+		after.RoutePlanUUID = before.RoutePlanUUID
+		return nil
+	}
+	if err := UnmatchRoutePlan(tx, before); err != nil {
+		return err
+	}
+	return MatchRoutePlan(tx, after)
+}
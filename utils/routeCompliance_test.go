@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// itemConnector opens connections answering every query with one route
+// plan item, and whose statements affect one row.
+type itemConnector struct{ posformUUID string }
+
+func (c itemConnector) Connect(context.Context) (driver.Conn, error) { return itemConn(c), nil }
+func (itemConnector) Driver() driver.Driver                          { return nil }
+
+type itemConn itemConnector
+
+func (c itemConn) Prepare(string) (driver.Stmt, error) { return itemStmt(c), nil }
+func (itemConn) Close() error                          { return nil }
+func (c itemConn) Begin() (driver.Tx, error)           { return c, nil }
+func (itemConn) Commit() error                         { return nil }
+func (itemConn) Rollback() error                       { return nil }
+
+type itemStmt itemConn
+
+func (itemStmt) Close() error                               { return nil }
+func (itemStmt) NumInput() int                              { return -1 }
+func (itemStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (s itemStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &itemRows{posformUUID: s.posformUUID}, nil
+}
+
+type itemRows struct {
+	posformUUID string
+	done        bool
+}
+
+func (*itemRows) Columns() []string {
+	return []string{"uuid", "route_plan_uuid", "pos_uuid", "pos_form_uuid"}
+}
+func (*itemRows) Close() error { return nil }
+func (r *itemRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, []driver.Value{"item1", "rp1", "p1", r.posformUUID})
+	return nil
+}
+
+// itemDB returns a database finding the item item1 of the route plan rp1,
+// fulfilled by posformUUID, and the recorder of its statements.
+func itemDB(t *testing.T, posformUUID string) (*gorm.DB, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(itemConnector{posformUUID})}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 rec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, rec
+}
+
+func TestMatchRoutePlan(t *testing.T) {
+	created := time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)
+	checkIn := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+
+	t.Run("visit without agent or POS", func(t *testing.T) {
+		db, rec := dryRun(t)
+		form := &models.PosForm{UUID: "f1", PosUUID: "p1", RoutePlanUUID: "stale"}
+		if err := MatchRoutePlan(db, form); err != nil {
+			t.Fatal(err)
+		}
+		if form.RoutePlanUUID != "" || len(rec.statements) != 0 {
+			t.Errorf("plan %q after %d statements, want unplanned without a query", form.RoutePlanUUID, len(rec.statements))
+		}
+	})
+
+	t.Run("plan of the day of the check-in", func(t *testing.T) {
+		db, rec := itemDB(t, "")
+		form := &models.PosForm{UUID: "f1", UserUUID: "u1", PosUUID: "p1", CheckInAt: &checkIn, CreatedAt: created}
+		if err := MatchRoutePlan(db, form); err != nil {
+			t.Fatal(err)
+		}
+		if form.RoutePlanUUID != "rp1" {
+			t.Errorf("plan = %q, want rp1", form.RoutePlanUUID)
+		}
+		if len(rec.statements) != 2 {
+			t.Fatalf("statements = %q, want the item lookup and its update", rec.statements)
+		}
+		lookup, update := rec.statements[0], rec.statements[1]
+		for _, want := range []string{
+			"route_plans.status = 'published'",
+			"route_plans.user_uuid = 'u1' AND route_plan_items.pos_uuid = 'p1'",
+			"COALESCE(route_plans.plan_date, DATE(route_plans.created_at)) = '2026-10-15'",
+			"route_plans.deleted_at IS NULL",
+		} {
+			if !strings.Contains(lookup, want) {
+				t.Errorf("lookup misses %s:\n%s", want, lookup)
+			}
+		}
+		if !strings.Contains(update, `"pos_form_uuid"='f1'`) || !strings.Contains(update, `"status"=true`) || !strings.Contains(update, `"uuid" = 'item1'`) {
+			t.Errorf("update does not mark item1 visited by f1:\n%s", update)
+		}
+	})
+
+	t.Run("item already fulfilled by another visit", func(t *testing.T) {
+		db, rec := itemDB(t, "f0")
+		form := &models.PosForm{UUID: "f1", UserUUID: "u1", PosUUID: "p1", CreatedAt: created}
+		if err := MatchRoutePlan(db, form); err != nil {
+			t.Fatal(err)
+		}
+		if form.RoutePlanUUID != "rp1" || len(rec.statements) != 1 {
+			t.Errorf("plan %q after %q, want rp1 without taking the item of f0", form.RoutePlanUUID, rec.statements)
+		}
+	})
+}
+
+func TestRematchRoutePlan(t *testing.T) {
+	morning := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	visit := func(user, pos string, at time.Time) *models.PosForm {
+		return &models.PosForm{UUID: "f1", UserUUID: user, PosUUID: pos, CreatedAt: at, RoutePlanUUID: "rp1"}
+	}
+
+	tests := []struct {
+		name    string
+		after   *models.PosForm
+		rematch bool
+	}{
+		{"same visit later the same day", visit("u1", "p1", morning.Add(9*time.Hour)), false},
+		{"other agent", visit("u2", "p1", morning), true},
+		{"other POS", visit("u1", "p2", morning), true},
+		{"other day", visit("u1", "p1", morning.AddDate(0, 0, -1)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, rec := itemDB(t, "")
+			tt.after.RoutePlanUUID = ""
+			if err := RematchRoutePlan(db, visit("u1", "p1", morning), tt.after); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.rematch {
+				if len(rec.statements) != 0 || tt.after.RoutePlanUUID != "rp1" {
+					t.Errorf("plan %q after %q, want rp1 kept without a query", tt.after.RoutePlanUUID, rec.statements)
+				}
+				return
+			}
+			if len(rec.statements) < 2 {
+				t.Fatalf("statements = %q, want the release and a new lookup", rec.statements)
+			}
+			release := rec.statements[0]
+			if !strings.Contains(release, `"pos_form_uuid"=''`) || !strings.Contains(release, "pos_form_uuid = 'f1'") {
+				t.Errorf("first statement does not release the item of f1:\n%s", release)
+			}
+			if !strings.Contains(rec.statements[1], "route_plan_items.pos_uuid = '"+tt.after.PosUUID+"'") {
+				t.Errorf("lookup is not for the new POS:\n%s", rec.statements[1])
+			}
+		})
+	}
+}
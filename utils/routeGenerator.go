@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// DefaultVisitEveryDays is the visit frequency of a POS no rule matches.
+const DefaultVisitEveryDays = 14
+
+// FrequencyRule sets how often POS of a type and segment must be visited.
+// An empty Postype or Segment (or "*") matches any; a POS gets the most
+// specific rule matching it, the segment weighing more than the type, and
+// the first one among equals.
+type FrequencyRule struct {
+	Postype   string `json:"postype"`
+	Segment   string `json:"segment"`
+	EveryDays int    `json:"every_days"`
+}
+
+// PlannedVisit is one POS scheduled on one working day.
+type PlannedVisit struct {
+	Day     time.Time
+	PosUUID string
+}
+
+// GenerateRoutePlan distributes the POS over the working days so each is
+// visited as often as its rule requires. Every required visit has a window
+// of EveryDays starting when it is due (last visit + EveryDays, or the
+// first day for a POS never visited or overdue) and goes to the least
+// loaded working day of that window. A POS are placed before B and C ones,
+// and overdue and never visited POS first, so they are not the ones pushed
+// out when days are full; POS not classified yet rank with B.
+// maxPerDay <= 0 means no cap. Visits that fit no day are returned as
+// unscheduled.
+func GenerateRoutePlan(pos []models.Pos, lastVisit map[string]time.Time, days []time.Time, rules []FrequencyRule, maxPerDay int) ([]PlannedVisit, []string) {
+	if len(days) == 0 {
+		unscheduled := make([]string, 0, len(pos))
+		for _, p := range pos {
+			unscheduled = append(unscheduled, p.UUID)
+		}
+		return nil, unscheduled
+	}
+
+	first, last := days[0], days[len(days)-1]
+
+	type occurrence struct {
+		posUUID   string
+		from, to  time.Time
+		lastVisit time.Time
+		everyDays int
+		rank      int
+	}
+
+	var occurrences []occurrence
+	for _, p := range pos {
+		every := visitEveryDays(p.Postype, p.Segment, rules)
+		lv, visited := lastVisit[p.UUID]
+
+		due := first
+		if visited {
+			if next := truncateDay(lv).AddDate(0, 0, every); next.After(first) {
+				due = next
+			}
+		}
+
+		for !due.After(last) {
+			occurrences = append(occurrences, occurrence{
+				posUUID:   p.UUID,
+				from:      due,
+				to:        due.AddDate(0, 0, every-1),
+				lastVisit: lv,
+				everyDays: every,
+				rank:      segmentRank(p.Segment),
+			})
+			due = due.AddDate(0, 0, every)
+		}
+	}
+
+	// Earliest windows first; within a window the best segments, then the
+	// POS waiting the longest (never visited = zero time) first.
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		a, b := occurrences[i], occurrences[j]
+		if !a.from.Equal(b.from) {
+			return a.from.Before(b.from)
+		}
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if !a.lastVisit.Equal(b.lastVisit) {
+			return a.lastVisit.Before(b.lastVisit)
+		}
+		return a.everyDays < b.everyDays
+	})
+
+	load := make([]int, len(days))
+	planned := make([]PlannedVisit, 0, len(occurrences))
+	var unscheduled []string
+
+	for _, o := range occurrences {
+		best := -1
+		for i, d := range days {
+			if d.Before(o.from) || d.After(o.to) {
+				continue
+			}
+			if maxPerDay > 0 && load[i] >= maxPerDay {
+				continue
+			}
+			if best == -1 || load[i] < load[best] {
+				best = i
+			}
+		}
+		if best == -1 {
+			unscheduled = append(unscheduled, o.posUUID)
+			continue
+		}
+		load[best]++
+		planned = append(planned, PlannedVisit{Day: days[best], PosUUID: o.posUUID})
+	}
+
+	return planned, unscheduled
+}
+
+// WorkingDays lists the days between start and end (inclusive) whose
+// weekday is in weekdays.
+func WorkingDays(start, end time.Time, weekdays []time.Weekday) []time.Time {
+	allowed := make(map[time.Weekday]bool, len(weekdays))
+	for _, w := range weekdays {
+		allowed[w] = true
+	}
+
+	var days []time.Time
+	for d := truncateDay(start); !d.After(truncateDay(end)); d = d.AddDate(0, 0, 1) {
+		if allowed[d.Weekday()] {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+func visitEveryDays(postype, segment string, rules []FrequencyRule) int {
+	every, best := 0, -1
+	for _, r := range rules {
+		if r.EveryDays <= 0 {
+			continue
+		}
+		specificity := 0
+		switch {
+		case r.Segment == "" || r.Segment == "*":
+		case strings.EqualFold(r.Segment, segment) && segment != "":
+			specificity += 2
+		default:
+			continue
+		}
+		switch {
+		case r.Postype == "" || r.Postype == "*":
+		case strings.EqualFold(r.Postype, postype) && postype != "":
+			specificity++
+		default:
+			continue
+		}
+		if specificity > best {
+			every, best = r.EveryDays, specificity
+		}
+	}
+	if every == 0 {
+		every = DefaultVisitEveryDays
+	}
+	return every
+}
+
+// segmentRank orders the POS by segment, the unclassified ones with B.
+func segmentRank(segment string) int {
+	switch segment {
+	case models.SegmentA:
+		return 0
+	case models.SegmentC:
+		return 2
+	}
+	return 1
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// october returns the nth day of October 2026, in UTC.
+func october(n int) time.Time {
+	return time.Date(2026, 10, n, 0, 0, 0, 0, time.UTC)
+}
+
+// octoberDays returns the days from the first to the last of October 2026.
+func octoberDays(first, last int) []time.Time {
+	var out []time.Time
+	for n := first; n <= last; n++ {
+		out = append(out, october(n))
+	}
+	return out
+}
+
+func TestGenerateRoutePlan(t *testing.T) {
+	pos := func(uuid, segment string) models.Pos {
+		return models.Pos{UUID: uuid, Postype: "shop", Segment: segment}
+	}
+
+	tests := []struct {
+		name            string
+		pos             []models.Pos
+		lastVisit       map[string]time.Time
+		days            []time.Time
+		rules           []FrequencyRule
+		maxPerDay       int
+		want            []PlannedVisit
+		wantUnscheduled []string
+	}{
+		{
+			name:            "no working day",
+			pos:             []models.Pos{pos("p1", ""), pos("p2", "")},
+			wantUnscheduled: []string{"p1", "p2"},
+		},
+		{
+			name:  "every day",
+			pos:   []models.Pos{pos("p1", "")},
+			days:  octoberDays(19, 21),
+			rules: []FrequencyRule{{EveryDays: 1}},
+			want: []PlannedVisit{
+				{Day: october(19), PosUUID: "p1"},
+				{Day: october(20), PosUUID: "p1"},
+				{Day: october(21), PosUUID: "p1"},
+			},
+		},
+		{
+			name: "never visited spread over the least loaded days",
+			pos:  []models.Pos{pos("p1", ""), pos("p2", ""), pos("p3", "")},
+			days: octoberDays(19, 23),
+			want: []PlannedVisit{
+				{Day: october(19), PosUUID: "p1"},
+				{Day: october(20), PosUUID: "p2"},
+				{Day: october(21), PosUUID: "p3"},
+			},
+		},
+		{
+			name:      "due after the last visit",
+			pos:       []models.Pos{pos("p1", "")},
+			lastVisit: map[string]time.Time{"p1": october(17).Add(15 * time.Hour)},
+			days:      octoberDays(19, 30),
+			rules:     []FrequencyRule{{EveryDays: 7}},
+			want: []PlannedVisit{
+				{Day: october(24), PosUUID: "p1"},
+			},
+		},
+		{
+			name:      "overdue visited on the first day",
+			pos:       []models.Pos{pos("p1", "")},
+			lastVisit: map[string]time.Time{"p1": october(1)},
+			days:      octoberDays(19, 23),
+			rules:     []FrequencyRule{{EveryDays: 7}},
+			want: []PlannedVisit{
+				{Day: october(19), PosUUID: "p1"},
+			},
+		},
+		{
+			name:      "full days keep the best segments",
+			pos:       []models.Pos{pos("c", models.SegmentC), pos("b", models.SegmentB), pos("a", models.SegmentA)},
+			days:      octoberDays(19, 19),
+			maxPerDay: 2,
+			want: []PlannedVisit{
+				{Day: october(19), PosUUID: "a"},
+				{Day: october(19), PosUUID: "b"},
+			},
+			wantUnscheduled: []string{"c"},
+		},
+		{
+			name:      "longest waiting first among equals",
+			pos:       []models.Pos{pos("recent", ""), pos("old", "")},
+			lastVisit: map[string]time.Time{"recent": october(4), "old": october(2)},
+			days:      octoberDays(19, 19),
+			rules:     []FrequencyRule{{EveryDays: 7}},
+			maxPerDay: 1,
+			want: []PlannedVisit{
+				{Day: october(19), PosUUID: "old"},
+			},
+			wantUnscheduled: []string{"recent"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unscheduled := GenerateRoutePlan(tt.pos, tt.lastVisit, tt.days, tt.rules, tt.maxPerDay)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("planned = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(unscheduled, tt.wantUnscheduled) {
+				t.Errorf("unscheduled = %v, want %v", unscheduled, tt.wantUnscheduled)
+			}
+		})
+	}
+}
+
+func TestVisitEveryDays(t *testing.T) {
+	rules := []FrequencyRule{
+		{Postype: "*", Segment: "*", EveryDays: 21},
+		{Postype: "kiosk", EveryDays: 10},
+		{Segment: models.SegmentA, EveryDays: 7},
+		{Postype: "kiosk", Segment: models.SegmentA, EveryDays: 3},
+		{Postype: "shop", Segment: models.SegmentB, EveryDays: 0}, // Ignored
+	}
+
+	tests := []struct {
+		name             string
+		postype, segment string
+		rules            []FrequencyRule
+		want             int
+	}{
+		{"no rule", "kiosk", models.SegmentA, nil, DefaultVisitEveryDays},
+		{"catch-all", "shop", models.SegmentC, rules, 21},
+		{"type", "KIOSK", models.SegmentC, rules, 10},
+		{"segment outweighs type", "shop", models.SegmentA, rules, 7},
+		{"type and segment", "kiosk", models.SegmentA, rules, 3},
+		{"rule without frequency", "shop", models.SegmentB, rules, 21},
+		{"unclassified", "shop", "", rules, 21},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visitEveryDays(tt.postype, tt.segment, tt.rules); got != tt.want {
+				t.Errorf("visitEveryDays(%q, %q) = %d, want %d", tt.postype, tt.segment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkingDays(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []time.Time
+	}{
+		{"one week", october(19), october(25), octoberDays(19, 23)},
+		{"weekend only", october(24), october(25), nil},
+		{"times of day ignored", october(23).Add(18 * time.Hour), october(26).Add(time.Hour), []time.Time{october(23), october(26)}},
+		{"end before start", october(23), october(19), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WorkingDays(tt.start, tt.end, weekdays); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WorkingDays = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
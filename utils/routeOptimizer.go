@@ -0,0 +1,98 @@
+package utils
+
+import "math"
+
+// GeoPoint is a latitude/longitude pair in degrees.
+type GeoPoint struct {
+	Lat float64 `json:"latitude"`
+	Lng float64 `json:"longitude"`
+}
+
+// OptimizeRoute orders the stops into a short open path starting from
+// start (nil to start at the best first stop), using straight-line
+// distances: nearest neighbour, then 2-opt until no reversal shortens the
+// path. It returns the stop indexes in visiting order, the distance in
+// meters of each leg (to reach that stop) and the total distance.
+func OptimizeRoute(start *GeoPoint, stops []GeoPoint) ([]int, []float64, float64) {
+	n := len(stops)
+	if n == 0 {
+		return nil, nil, 0
+	}
+
+	dist := func(a, b GeoPoint) float64 { return HaversineMeters(a.Lat, a.Lng, b.Lat, b.Lng) }
+
+	// Path of points: the start (if any) then the stops
+	points := make([]GeoPoint, 0, n+1)
+	offset := 0
+	if start != nil {
+		points = append(points, *start)
+		offset = 1
+	}
+	points = append(points, stops...)
+
+	// Nearest neighbour from the start, or from the first stop
+	path := make([]int, 0, len(points))
+	visited := make([]bool, len(points))
+	path = append(path, 0)
+	visited[0] = true
+	for len(path) < len(points) {
+		last := points[path[len(path)-1]]
+		best, bestDist := -1, math.MaxFloat64
+		for i := range points {
+			if visited[i] {
+				continue
+			}
+			if d := dist(last, points[i]); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		path = append(path, best)
+		visited[best] = true
+	}
+
+	// 2-opt on the open path: the start stays first, both ends are free
+	// otherwise.
+	for improved := true; improved; {
+		improved = false
+		for i := offset; i < len(path)-1; i++ {
+			for k := i + 1; k < len(path); k++ {
+				b, c := points[path[i]], points[path[k]]
+				before, after := 0.0, 0.0
+				if i > 0 {
+					a := points[path[i-1]]
+					before += dist(a, b)
+					after += dist(a, c)
+				}
+				if k+1 < len(path) {
+					d := points[path[k+1]]
+					before += dist(c, d)
+					after += dist(b, d)
+				}
+				if after < before-1e-6 {
+					for l, r := i, k; l < r; l, r = l+1, r-1 {
+						path[l], path[r] = path[r], path[l]
+					}
+					improved = true
+				}
+			}
+		}
+	}
+
+	order := make([]int, 0, n)
+	legs := make([]float64, 0, n)
+	total := 0.0
+	for i, p := range path {
+		if p < offset {
+			continue
+		}
+		leg := 0.0
+		if i > 0 {
+			leg = math.Round(dist(points[path[i-1]], points[p]))
+		}
+		order = append(order, p-offset)
+		legs = append(legs, leg)
+		total += leg
+	}
+
+	return order, legs, total
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Scope is the territory a user is allowed to read, derived from its role:
+//   - Support            → everything (Column == "")
+//   - Manager            → their country (everything if no country is set)
+//   - ASM                → their province
+//   - Supervisor (Sup)   → their area
+//   - DR                 → their sub-area
+//   - Cyclo              → their commune
+type Scope struct {
+	Column string `json:"column"` // country_uuid, province_uuid, area_uuid, sub_area_uuid, commune_uuid
+	UUID   string `json:"uuid"`
+
+	CountryUUID string `json:"country_uuid"`
+
+	// Denied is set for unknown roles: nothing is visible
+	Denied bool `json:"denied"`
+}
+
+// ResolveScope maps a user to the territory it can read.
+func ResolveScope(user *models.User) Scope {
+	if user == nil {
+		return Scope{Denied: true}
+	}
+
+	s := Scope{CountryUUID: user.CountryUUID}
+
+	switch strings.ToLower(strings.TrimSpace(user.Role)) {
+	case "support":
+		s.CountryUUID = ""
+	case "manager":
+		if user.CountryUUID != "" {
+			s.Column, s.UUID = "country_uuid", user.CountryUUID
+		}
+	case "asm":
+		s.Column, s.UUID = "province_uuid", user.ProvinceUUID
+	case "supervisor", "sup":
+		s.Column, s.UUID = "area_uuid", user.AreaUUID
+	case "dr":
+		s.Column, s.UUID = "sub_area_uuid", user.SubAreaUUID
+	case "cyclo":
+		s.Column, s.UUID = "commune_uuid", user.CommuneUUID
+	default:
+		s.Denied = true
+	}
+
+	return s
+}
+
+// Unrestricted reports whether the scope sees every territory.
+func (s Scope) Unrestricted() bool {
+	return !s.Denied && s.Column == ""
+}
+
+// ApplyScope restricts a query on tableName (pos, pos_forms, route_plans,
+// users…) to the scope. Tables must carry the usual *_uuid territory columns.
+// Chain .Session(&gorm.Session{}) on the result before reusing it for both
+// a Count and a Find.
+func ApplyScope(query *gorm.DB, s Scope, tableName string) *gorm.DB {
+	if s.Denied {
+		return query.Where("1 = 0")
+	}
+	if s.Column == "" {
+		return query
+	}
+	return query.Where(tableName+"."+s.Column+" = ?", s.UUID)
+}
+
+// ApplyScopeToQueryArgs overwrites the territory query params (country_uuid,
+// province_uuid, …) with the scope values so that handlers reading them
+// through c.Query, such as the dashboards' raw SQL, stay inside the
+// caller's territory whatever the URL says. Denied scopes must be rejected
+// by the caller beforehand.
+func ApplyScopeToQueryArgs(c *fiber.Ctx, s Scope) {
+	args := c.Request().URI().QueryArgs()
+
+	if s.CountryUUID != "" {
+		args.Set("country_uuid", s.CountryUUID)
+	}
+	if s.Column != "" && s.Column != "country_uuid" {
+		args.Set(s.Column, s.UUID)
+	}
+}
+
+// Contains reports whether a record located in the given territory is inside
+// the scope.
+func (s Scope) Contains(countryUUID, provinceUUID, areaUUID, subAreaUUID, communeUUID string) bool {
+	if s.Denied {
+		return false
+	}
+	switch s.Column {
+	case "":
+		return true
+	case "country_uuid":
+		return countryUUID == s.UUID
+	case "province_uuid":
+		return provinceUUID == s.UUID
+	case "area_uuid":
+		return areaUUID == s.UUID
+	case "sub_area_uuid":
+		return subAreaUUID == s.UUID
+	case "commune_uuid":
+		return communeUUID == s.UUID
+	}
+	return false
+}
+
+// PlaceVisit copies the territory and hierarchy of its POS to a visit,
+// whatever the client sent.
+func PlaceVisit(form *models.PosForm, pos *models.Pos) {
+	form.CountryUUID = pos.CountryUUID
+	form.ProvinceUUID = pos.ProvinceUUID
+	form.AreaUUID = pos.AreaUUID
+	form.SubAreaUUID = pos.SubAreaUUID
+	form.CommuneUUID = pos.CommuneUUID
+	form.AsmUUID, form.Asm = pos.AsmUUID, pos.Asm
+	form.SupUUID, form.Sup = pos.SupUUID, pos.Sup
+	form.DrUUID, form.Dr = pos.DrUUID, pos.Dr
+	form.CycloUUID, form.Cyclo = pos.CycloUUID, pos.Cyclo
+}
+
+// RecordPosMove records that a POS left its territory, when after is in
+// another commune, sub-area, area, province or country than before.
+func RecordPosMove(tx *gorm.DB, before, after *models.Pos) error {
+	if before.CountryUUID == after.CountryUUID && before.ProvinceUUID == after.ProvinceUUID &&
+		before.AreaUUID == after.AreaUUID && before.SubAreaUUID == after.SubAreaUUID &&
+		before.CommuneUUID == after.CommuneUUID {
+		return nil
+	}
+	return tx.Create(&models.PosMove{
+		UUID:         GenerateUUID(),
+		PosUUID:      before.UUID,
+		CountryUUID:  before.CountryUUID,
+		ProvinceUUID: before.ProvinceUUID,
+		AreaUUID:     before.AreaUUID,
+		SubAreaUUID:  before.SubAreaUUID,
+		CommuneUUID:  before.CommuneUUID,
+	}).Error
+}
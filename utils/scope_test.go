@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// agent is a user of every level of the territory of Kinshasa.
+func agent(role string) *models.User {
+	return &models.User{
+		Role: role, CountryUUID: "cd", ProvinceUUID: "kin", AreaUUID: "lukunga",
+		SubAreaUUID: "gombe-nord", CommuneUUID: "gombe",
+	}
+}
+
+func TestResolveScope(t *testing.T) {
+	tests := []struct {
+		role string
+		want Scope
+	}{
+		{"Support", Scope{}},
+		{"Manager", Scope{Column: "country_uuid", UUID: "cd", CountryUUID: "cd"}},
+		{"asm", Scope{Column: "province_uuid", UUID: "kin", CountryUUID: "cd"}},
+		{"Sup", Scope{Column: "area_uuid", UUID: "lukunga", CountryUUID: "cd"}},
+		{" supervisor ", Scope{Column: "area_uuid", UUID: "lukunga", CountryUUID: "cd"}},
+		{"DR", Scope{Column: "sub_area_uuid", UUID: "gombe-nord", CountryUUID: "cd"}},
+		{"Cyclo", Scope{Column: "commune_uuid", UUID: "gombe", CountryUUID: "cd"}},
+		{"Auditor", Scope{CountryUUID: "cd", Denied: true}},
+	}
+	for _, tt := range tests {
+		if got := ResolveScope(agent(tt.role)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.role, got, tt.want)
+		}
+	}
+
+	if got := ResolveScope(&models.User{Role: "Manager"}); !got.Unrestricted() {
+		t.Errorf("manager without a country: %+v, want every territory", got)
+	}
+	if got := ResolveScope(nil); !got.Denied || got.Unrestricted() {
+		t.Errorf("no user: %+v, want nothing visible", got)
+	}
+}
+
+func TestScopeContains(t *testing.T) {
+	// A visit of Gombe, and one of Lubumbashi
+	gombe := []string{"cd", "kin", "lukunga", "gombe-nord", "gombe"}
+	lubumbashi := []string{"cd", "hk", "lubumbashi", "lubumbashi-centre", "kampemba"}
+
+	for _, role := range []string{"Support", "Manager", "ASM", "Sup", "DR", "Cyclo", "Auditor"} {
+		s := ResolveScope(agent(role))
+		inGombe := s.Contains(gombe[0], gombe[1], gombe[2], gombe[3], gombe[4])
+		inLubumbashi := s.Contains(lubumbashi[0], lubumbashi[1], lubumbashi[2], lubumbashi[3], lubumbashi[4])
+
+		wantGombe := role != "Auditor"
+		wantLubumbashi := role == "Support" || role == "Manager"
+		if inGombe != wantGombe || inLubumbashi != wantLubumbashi {
+			t.Errorf("%s sees Gombe %v and Lubumbashi %v, want %v and %v", role, inGombe, inLubumbashi, wantGombe, wantLubumbashi)
+		}
+	}
+}
+
+func TestApplyScope(t *testing.T) {
+	for role, want := range map[string]string{
+		"Support": `SELECT * FROM "pos" WHERE "pos"."deleted_at" IS NULL`,
+		"ASM":     `SELECT * FROM "pos" WHERE pos.province_uuid = 'kin' AND "pos"."deleted_at" IS NULL`,
+		"Auditor": `SELECT * FROM "pos" WHERE 1 = 0 AND "pos"."deleted_at" IS NULL`,
+	} {
+		db, rec := dryRun(t)
+		var pos []models.Pos
+		ApplyScope(db.Model(&models.Pos{}), ResolveScope(agent(role)), "pos").Find(&pos)
+		if len(rec.statements) != 1 || rec.statements[0] != want {
+			t.Errorf("%s:\n got %q\nwant %q", role, rec.statements, want)
+		}
+	}
+}
+
+func TestApplyScopeToQueryArgs(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		ApplyScopeToQueryArgs(c, ResolveScope(agent(c.Get("X-Role"))))
+		return c.SendString(c.Request().URI().QueryArgs().String())
+	})
+
+	// The country and the level of the caller are pinned; the other params
+	// of the URL can only narrow the results further
+	const target = "/?country_uuid=cg&province_uuid=hk&area_uuid=x&start_date=2026-10-01"
+	for role, want := range map[string]string{
+		"Support": "country_uuid=cg&province_uuid=hk&area_uuid=x&start_date=2026-10-01",
+		"Manager": "country_uuid=cd&province_uuid=hk&area_uuid=x&start_date=2026-10-01",
+		"ASM":     "country_uuid=cd&province_uuid=kin&area_uuid=x&start_date=2026-10-01",
+		"Cyclo":   "country_uuid=cd&province_uuid=hk&area_uuid=x&start_date=2026-10-01&commune_uuid=gombe",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("X-Role", role)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want {
+			t.Errorf("%s: %s, want %s", role, body, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+// NormalizeSegment validates a segment, returned in upper case. Empty is
+// left empty.
+func NormalizeSegment(segment string) (string, error) {
+	segment = strings.ToUpper(strings.TrimSpace(segment))
+	switch segment {
+	case "", models.SegmentA, models.SegmentB, models.SegmentC:
+		return segment, nil
+	}
+	return "", fmt.Errorf("invalid segment %q; use A|B|C", segment)
+}
+
+// PosSegmentAtSQL is the SQL expression of the segment the POS pos had at
+// the time at, both SQL expressions: empty before its first classification.
+func PosSegmentAtSQL(pos, at string) string {
+	return `COALESCE((
+				SELECT sc.segment FROM pos_segment_changes sc
+				WHERE sc.pos_uuid = ` + pos + ` AND sc.effective_from <= ` + at + `
+				ORDER BY sc.effective_from DESC LIMIT 1
+			), '')`
+}
+
+// SegmentOf returns the segment of a score under cfg.
+func SegmentOf(cfg *models.SegmentationConfig, score float64) string {
+	switch {
+	case score >= cfg.ACutoff:
+		return models.SegmentA
+	case score >= cfg.BCutoff:
+		return models.SegmentB
+	}
+	return models.SegmentC
+}
+
+// SegmentationResult sums up a segmentation run.
+type SegmentationResult struct {
+	Countries  int            `json:"countries"`
+	Classified int            `json:"classified"` // Active POS scored
+	Changed    int            `json:"changed"`    // POS whose segment changed
+	Segments   map[string]int `json:"segments"`   // POS per segment
+}
+
+// segmentScores scores the active POS of @country on the visits since
+// @since, revenue converted to @currency at the rate of the visit date.
+var segmentScores = `
+	WITH visits AS (
+		SELECT
+			pf.pos_uuid,
+			COALESCE(t.fardes, 0) AS fardes,
+			COALESCE(t.sold, 0)   AS sold,
+			pf.price * fx.rate    AS revenue
+		FROM pos_forms pf
+		LEFT JOIN LATERAL (
+			SELECT SUM(number_farde) AS fardes, SUM(sold) AS sold
+			FROM pos_form_items
+			WHERE pos_form_uuid = pf.uuid AND deleted_at IS NULL
+		) t ON true
+		` + FXJoin("fx", FormCurrencySQL("pf"), "DATE(pf.created_at)", "@currency") + `
+		WHERE pf.country_uuid = @country
+		  AND pf.created_at >= @since
+		  AND pf.deleted_at IS NULL
+	),
+	volumes AS (
+		SELECT
+			p.uuid                      AS pos_uuid,
+			p.segment,
+			COALESCE(SUM(v.fardes), 0)  AS fardes,
+			COALESCE(SUM(v.sold), 0)    AS sold,
+			COALESCE(SUM(v.revenue), 0) AS revenue
+		FROM pos p
+		LEFT JOIN visits v ON v.pos_uuid = p.uuid
+		WHERE p.country_uuid = @country AND p.status = true AND p.deleted_at IS NULL
+		GROUP BY p.uuid, p.segment
+	)
+	SELECT
+		pos_uuid, segment, fardes, sold, revenue,
+		100 * (CAST(@fardes_weight AS float8)  * PERCENT_RANK() OVER (ORDER BY fardes)
+		     + CAST(@sold_weight AS float8)    * PERCENT_RANK() OVER (ORDER BY sold)
+		     + CAST(@revenue_weight AS float8) * PERCENT_RANK() OVER (ORDER BY revenue))
+		    / CAST(@total_weight AS float8) AS score
+	FROM volumes`
+
+// SegmentCountry classifies the active POS of a country under cfg as of
+// now and adds up the outcome in res. Changes of segment are recorded; the
+// daily facts of the visits of the POS classified for the first time are
+// refreshed, as their segment is backdated to the start of the window.
+func SegmentCountry(db *gorm.DB, cfg *models.SegmentationConfig, countryUUID string, now time.Time, res *SegmentationResult) error {
+	since := now.AddDate(0, 0, -cfg.WindowDays)
+
+	var rows []struct {
+		PosUUID string
+		Segment string
+		Fardes  float64
+		Sold    float64
+		Revenue float64
+		Score   float64
+	}
+	err := db.Raw(segmentScores, map[string]interface{}{
+		"country":        countryUUID,
+		"since":          since,
+		"currency":       CountryCurrency(db, countryUUID),
+		"fardes_weight":  cfg.FardesWeight,
+		"sold_weight":    cfg.SoldWeight,
+		"revenue_weight": cfg.RevenueWeight,
+		"total_weight":   cfg.FardesWeight + cfg.SoldWeight + cfg.RevenueWeight,
+	}).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	var firstClassified []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, r := range rows {
+			segment := SegmentOf(cfg, r.Score)
+			if err := tx.Model(&models.Pos{}).Where("uuid = ?", r.PosUUID).UpdateColumns(map[string]interface{}{
+				"segment":       segment,
+				"segment_score": r.Score,
+				"segmented_at":  now,
+			}).Error; err != nil {
+				return err
+			}
+			if res.Segments == nil {
+				res.Segments = map[string]int{}
+			}
+			res.Segments[segment]++
+			res.Classified++
+			if segment == r.Segment {
+				continue
+			}
+
+			effective := now
+			if r.Segment == "" {
+				effective = since
+				firstClassified = append(firstClassified, r.PosUUID)
+			}
+			if err := tx.Create(&models.PosSegmentChange{
+				UUID:            GenerateUUID(),
+				PosUUID:         r.PosUUID,
+				Segment:         segment,
+				PreviousSegment: r.Segment,
+				EffectiveFrom:   effective,
+				Score:           r.Score,
+				Fardes:          r.Fardes,
+				Sold:            r.Sold,
+				Revenue:         r.Revenue,
+			}).Error; err != nil {
+				return err
+			}
+			res.Changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	res.Countries++
+
+	// Daily facts of the visits now in a segment, by batches of POS
+	for start := 0; start < len(firstClassified); start += 500 {
+		end := start + 500
+		if end > len(firstClassified) {
+			end = len(firstClassified)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var formUUIDs []string
+			if err := tx.Model(&models.PosForm{}).
+				Where("pos_uuid IN ? AND created_at >= ?", firstClassified[start:end], since).
+				Pluck("uuid", &formUUIDs).Error; err != nil {
+				return err
+			}
+			forms, err := DailyFactForms(tx, formUUIDs)
+			if err != nil {
+				return err
+			}
+			return RefreshDailyFacts(tx, forms...)
+		})
+		if err != nil {
+			return fmt.Errorf("refresh daily facts: %w", err)
+		}
+	}
+	return nil
+}
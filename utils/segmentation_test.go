@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestNormalizeSegment(t *testing.T) {
+	for in, want := range map[string]string{"": "", "  ": "", "a": "A", " B ": "B", "C": "C"} {
+		if got, err := NormalizeSegment(in); err != nil || got != want {
+			t.Errorf("NormalizeSegment(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"D", "AB", "premium"} {
+		if got, err := NormalizeSegment(in); err == nil {
+			t.Errorf("NormalizeSegment(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+func TestSegmentOf(t *testing.T) {
+	cfg := &models.SegmentationConfig{ACutoff: 80, BCutoff: 50}
+	for _, tt := range []struct {
+		score float64
+		want  string
+	}{
+		{100, models.SegmentA},
+		{80, models.SegmentA},
+		{79.99, models.SegmentB},
+		{50, models.SegmentB},
+		{49.99, models.SegmentC},
+		{0, models.SegmentC},
+	} {
+		if got := SegmentOf(cfg, tt.score); got != tt.want {
+			t.Errorf("score %v: %s, want %s", tt.score, got, tt.want)
+		}
+	}
+}
+
+// answering is a connector answering each query with the columns and rows
+// answer returns for it, and logging every statement with its args.
+type answering struct {
+	answer func(query string) ([]string, [][]driver.Value)
+	log    *[]string
+}
+
+func (a answering) Connect(context.Context) (driver.Conn, error) { return a, nil }
+func (answering) Driver() driver.Driver                          { return nil }
+func (a answering) Prepare(query string) (driver.Stmt, error)    { return answeringStmt{a, query}, nil }
+func (answering) Close() error                                   { return nil }
+func (a answering) Begin() (driver.Tx, error)                    { return a, nil }
+func (answering) Commit() error                                  { return nil }
+func (answering) Rollback() error                                { return nil }
+
+type answeringStmt struct {
+	a     answering
+	query string
+}
+
+func (answeringStmt) Close() error  { return nil }
+func (answeringStmt) NumInput() int { return -1 }
+func (s answeringStmt) Exec(args []driver.Value) (driver.Result, error) {
+	*s.a.log = append(*s.a.log, fmt.Sprint(s.query, " ", args))
+	return driver.RowsAffected(1), nil
+}
+func (s answeringStmt) Query(args []driver.Value) (driver.Rows, error) {
+	*s.a.log = append(*s.a.log, fmt.Sprint(s.query, " ", args))
+	columns, rows := s.a.answer(s.query)
+	return &answeringRows{columns, rows}, nil
+}
+
+type answeringRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *answeringRows) Columns() []string { return r.columns }
+func (*answeringRows) Close() error        { return nil }
+func (r *answeringRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSegmentCountry(t *testing.T) {
+	now := time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC)
+	cfg := &models.SegmentationConfig{WindowDays: 90, FardesWeight: 2, SoldWeight: 1, RevenueWeight: 1, ACutoff: 80, BCutoff: 50}
+
+	var log []string
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(answering{
+		answer: func(query string) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, `FROM "countries"`):
+				return []string{"currency"}, [][]driver.Value{{"CDF"}}
+			case strings.Contains(query, "WITH visits"):
+				return []string{"pos_uuid", "segment", "fardes", "sold", "revenue", "score"}, [][]driver.Value{
+					{"steady", "A", 120.0, 90.0, 5000.0, 92.5}, // Stays A
+					{"falling", "A", 10.0, 8.0, 400.0, 61.0},   // A to B
+					{"new", "", 1.0, 0.0, 0.0, 12.0},           // First classified C
+				}
+			}
+			return nil, nil
+		},
+		log: &log,
+	})}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := SegmentationResult{Countries: 1, Classified: 4, Segments: map[string]int{models.SegmentA: 4}}
+	if err := SegmentCountry(db, cfg, "cd", now, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Countries != 2 || res.Classified != 7 || res.Changed != 2 ||
+		res.Segments[models.SegmentA] != 5 || res.Segments[models.SegmentB] != 1 || res.Segments[models.SegmentC] != 1 {
+		t.Errorf("result = %+v, want added up to the previous countries", res)
+	}
+
+	since := now.AddDate(0, 0, -90)
+	find := func(substrings ...string) {
+		t.Helper()
+	statements:
+		for _, s := range log {
+			for _, sub := range substrings {
+				if !strings.Contains(s, sub) {
+					continue statements
+				}
+			}
+			return
+		}
+		t.Errorf("no statement with %q in:\n%s", substrings, strings.Join(log, "\n"))
+	}
+
+	// Scored in the currency of the country, on the weights of the config
+	find("WITH visits", "cd", "CDF", " 2 1 1 4]")
+
+	// Every POS gets its segment and score, only changes are recorded
+	for pos, segment := range map[string]string{"steady": "A", "falling": "B", "new": "C"} {
+		find(`UPDATE "pos"`, "["+segment+" ", " "+pos+"]")
+	}
+	if n := strings.Count(strings.Join(log, "\n"), `INSERT INTO "pos_segment_changes"`); n != 2 {
+		t.Errorf("%d segment changes recorded, want 2", n)
+	}
+	find(`INSERT INTO "pos_segment_changes"`, " falling B A "+now.String())
+
+	// The first classification is backdated to the window, and the visits
+	// since then looked up to refresh their facts
+	find(`INSERT INTO "pos_segment_changes"`, " new C  "+since.String())
+	find(`FROM "pos_forms"`, "pos_uuid IN ($1)", "[new "+since.String())
+}
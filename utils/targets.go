@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"math"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+	"gorm.io/gorm"
+)
+
+// TargetMetrics and TargetPeriods are the accepted Target.Metric and
+// Target.Period values.
+var (
+	TargetMetrics = []string{models.TargetVisits, models.TargetND, models.TargetSOS, models.TargetWD, models.TargetSold}
+	TargetPeriods = []string{models.TargetPerDay, models.TargetPerWeek, models.TargetPerMonth}
+)
+
+// IsRateTarget reports whether the metric is a % whose target is averaged
+// over a period rather than summed.
+func IsRateTarget(metric string) bool {
+	return metric == models.TargetND || metric == models.TargetSOS || metric == models.TargetWD
+}
+
+// ParseDay parses the date part of a "2006-01-02" or RFC 3339 query param
+// as a UTC day.
+func ParseDay(s string) (time.Time, error) {
+	if len(s) > 10 {
+		s = s[:10]
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func utcDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// TargetResolver picks, for each day of a period, the target of a metric
+// that applies to a user.
+type TargetResolver struct {
+	metric     string
+	start, end time.Time
+	targets    []models.Target
+}
+
+// LoadTargets loads the targets of metric effective on a day from start to
+// end, both included.
+func LoadTargets(db *gorm.DB, metric string, start, end time.Time) (*TargetResolver, error) {
+	r := &TargetResolver{metric: metric, start: utcDay(start), end: utcDay(end)}
+	err := db.Where("metric = ?", metric).
+		Where("start_date <= ?", r.end.Format("2006-01-02")).
+		Where("end_date IS NULL OR end_date >= ?", r.start.Format("2006-01-02")).
+		Find(&r.targets).Error
+	return r, err
+}
+
+// specificity ranks the scopes of a target: a brand beats a user, a user
+// beats a title, a title beats a territory, and a deeper territory beats a
+// wider one.
+func specificity(t *models.Target) int {
+	score := 0
+	// Most significant bit first
+	for _, set := range []bool{
+		t.BrandUUID != "", t.UserUUID != "", t.Title != "",
+		t.CommuneUUID != "", t.SubAreaUUID != "", t.AreaUUID != "",
+		t.ProvinceUUID != "", t.CountryUUID != "",
+	} {
+		score <<= 1
+		if set {
+			score |= 1
+		}
+	}
+	return score
+}
+
+func targetMatches(t *models.Target, user *models.User, brandUUID string, day time.Time) bool {
+	match := func(scope, value string) bool { return scope == "" || scope == value }
+	if utcDay(t.StartDate).After(day) || (t.EndDate != nil && utcDay(*t.EndDate).Before(day)) {
+		return false
+	}
+	return match(t.UserUUID, user.UUID) && match(t.Title, user.Title) &&
+		match(t.CountryUUID, user.CountryUUID) && match(t.ProvinceUUID, user.ProvinceUUID) &&
+		match(t.AreaUUID, user.AreaUUID) && match(t.SubAreaUUID, user.SubAreaUUID) &&
+		match(t.CommuneUUID, user.CommuneUUID) && match(t.BrandUUID, brandUUID)
+}
+
+// targetOn returns the most specific target effective on day, the latest
+// one on a tie, or nil.
+func (r *TargetResolver) targetOn(user *models.User, brandUUID string, day time.Time) *models.Target {
+	var best *models.Target
+	bestScore := -1
+	for i := range r.targets {
+		t := &r.targets[i]
+		if !targetMatches(t, user, brandUUID, day) {
+			continue
+		}
+		score := specificity(t)
+		if best == nil || score > bestScore ||
+			(score == bestScore && (t.StartDate.After(best.StartDate) ||
+				(t.StartDate.Equal(best.StartDate) && t.UpdatedAt.After(best.UpdatedAt)))) {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+// dayShare is the part of a count target due on day.
+func dayShare(t *models.Target, day time.Time) float64 {
+	switch t.Period {
+	case models.TargetPerWeek:
+		return t.Value / 7
+	case models.TargetPerMonth:
+		return t.Value / float64(time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day())
+	default:
+		return t.Value
+	}
+}
+
+// For returns the target of the user over the period, for the brand or all
+// brands when brandUUID is empty: the sum of the daily shares for counts,
+// the average over the days having a target for rates. It is 0 when no
+// target applies.
+func (r *TargetResolver) For(user *models.User, brandUUID string) float64 {
+	if len(r.targets) == 0 {
+		return 0
+	}
+	sum, days := 0.0, 0
+	for day := r.start; !day.After(r.end); day = day.AddDate(0, 0, 1) {
+		t := r.targetOn(user, brandUUID, day)
+		if t == nil {
+			continue
+		}
+		days++
+		if IsRateTarget(r.metric) {
+			sum += t.Value
+		} else {
+			sum += dayShare(t, day)
+		}
+	}
+	if IsRateTarget(r.metric) {
+		if days == 0 {
+			return 0
+		}
+		return sum / float64(days)
+	}
+	return sum
+}
+
+// UserTargets returns the target of metric from start to end for each
+// user, keyed by user uuid.
+func UserTargets(db *gorm.DB, metric, brandUUID string, userUUIDs []string, start, end time.Time) (map[string]float64, error) {
+	out := make(map[string]float64, len(userUUIDs))
+	if len(userUUIDs) == 0 {
+		return out, nil
+	}
+	resolver, err := LoadTargets(db, metric, start, end)
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	err = db.Select("uuid", "title", "country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid").
+		Where("uuid IN ?", userUUIDs).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		out[users[i].UUID] = resolver.For(&users[i], brandUUID)
+	}
+	return out, nil
+}
+
+// AgentTargets loads the targets of metric applying to the agents from
+// start to end, YYYY-MM-DD days both included, and returns the target of
+// an agent for a brand, or for all brands with "". It is 0 for an agent
+// without a target or not in userUUIDs.
+func AgentTargets(db *gorm.DB, metric string, userUUIDs []string, start, end string) (func(userUUID, brandUUID string) float64, error) {
+	from, err := ParseDay(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ParseDay(end)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := LoadTargets(db, metric, from, to)
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	if len(userUUIDs) > 0 {
+		err = db.Select("uuid", "title", "country_uuid", "province_uuid", "area_uuid", "sub_area_uuid", "commune_uuid").
+			Where("uuid IN ?", userUUIDs).
+			Find(&users).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	byUUID := make(map[string]*models.User, len(users))
+	for i := range users {
+		byUUID[users[i].UUID] = &users[i]
+	}
+	return func(userUUID, brandUUID string) float64 {
+		user, ok := byUUID[userUUID]
+		if !ok {
+			return 0
+		}
+		return resolver.For(user, brandUUID)
+	}, nil
+}
+
+// TerritoryTarget combines the targets of the users of a territory: their
+// sum for counts, their average for rates.
+func TerritoryTarget(metric string, userTargets []float64) float64 {
+	sum, n := 0.0, 0
+	for _, t := range userTargets {
+		if t == 0 {
+			continue
+		}
+		sum += t
+		n++
+	}
+	if IsRateTarget(metric) && n > 0 {
+		return sum / float64(n)
+	}
+	return sum
+}
+
+// Achievement is actual as a % of target, rounded to 2 decimals, or 0
+// without a target.
+func Achievement(actual, target float64) float64 {
+	if target == 0 {
+		return 0
+	}
+	return math.Round(actual/target*10000) / 100
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func day(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestTargetResolverFor(t *testing.T) {
+	until := day("2026-04-03")
+	visits := &TargetResolver{
+		metric: models.TargetVisits,
+		start:  day("2026-04-01"),
+		end:    day("2026-04-10"),
+		targets: []models.Target{
+			{Period: models.TargetPerDay, Value: 10, CountryUUID: "cd", StartDate: day("2026-01-01")},
+			{Period: models.TargetPerDay, Value: 12, CountryUUID: "cd", StartDate: day("2026-04-08")},
+			{Period: models.TargetPerWeek, Value: 21, CountryUUID: "cd", ProvinceUUID: "kin", StartDate: day("2026-04-06")},
+			{Period: models.TargetPerDay, Value: 7, Title: "Supervisor", StartDate: day("2026-01-01")},
+			{Period: models.TargetPerMonth, Value: 600, UserUUID: "u1", StartDate: day("2026-04-01"), EndDate: &until},
+			{Period: models.TargetPerDay, Value: 4, CountryUUID: "cd", BrandUUID: "b1", StartDate: day("2026-01-01")},
+			{Period: models.TargetPerDay, Value: 99, CountryUUID: "cd", StartDate: day("2026-04-11")},
+		},
+	}
+	u1 := &models.User{UUID: "u1", Title: "DR", CountryUUID: "cd", ProvinceUUID: "kin"}
+	u2 := &models.User{UUID: "u2", Title: "DR", CountryUUID: "cd", ProvinceUUID: "kin"}
+	u3 := &models.User{UUID: "u3", Title: "DR", CountryUUID: "cd", ProvinceUUID: "kongo"}
+	s1 := &models.User{UUID: "s1", Title: "Supervisor", CountryUUID: "cd", ProvinceUUID: "kin"}
+	other := &models.User{UUID: "x1", Title: "DR", CountryUUID: "cg"}
+
+	tests := []struct {
+		name  string
+		user  *models.User
+		brand string
+		want  float64
+	}{
+		// 3 days of 600/30, 2 of the country's 10, 5 of the province's 21/7
+		{"own target then territory", u1, "", 3*20 + 2*10 + 5*3},
+		{"province over country", u2, "", 5*10 + 5*3},
+		// The latest of two country targets from 04-08
+		{"latest of equal targets", u3, "", 7*10 + 3*12},
+		{"title over territory", s1, "", 10 * 7},
+		{"brand over user", u1, "b1", 10 * 4},
+		{"target of all brands for another brand", u1, "b2", 3*20 + 2*10 + 5*3},
+		{"no target", other, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visits.For(tt.user, tt.brand); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("For = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A rate is averaged over the days having a target, not summed
+	nd := &TargetResolver{
+		metric: models.TargetND,
+		start:  day("2026-04-01"),
+		end:    day("2026-04-10"),
+		targets: []models.Target{
+			{Period: models.TargetPerMonth, Value: 80, CountryUUID: "cd", StartDate: day("2026-01-01")},
+			{Period: models.TargetPerMonth, Value: 90, UserUUID: "u1", StartDate: day("2026-04-06")},
+			{Period: models.TargetPerDay, Value: 60, UserUUID: "x1", StartDate: day("2026-04-09")},
+		},
+	}
+	if got := nd.For(u1, ""); got != 85 {
+		t.Errorf("ND target of u1 = %v, want 85", got)
+	}
+	if got := nd.For(other, ""); got != 60 {
+		t.Errorf("ND target over 2 days = %v, want 60", got)
+	}
+}
+
+func TestTerritoryTargetAndAchievement(t *testing.T) {
+	if got := TerritoryTarget(models.TargetVisits, []float64{100, 0, 50}); got != 150 {
+		t.Errorf("visits of a territory = %v, want the sum 150", got)
+	}
+	if got := TerritoryTarget(models.TargetSOS, []float64{40, 0, 50}); got != 45 {
+		t.Errorf("SOS of a territory = %v, want 45, agents without a target left out", got)
+	}
+	if got := TerritoryTarget(models.TargetND, nil); got != 0 {
+		t.Errorf("ND of a territory without agents = %v, want 0", got)
+	}
+
+	for _, tt := range [][3]float64{{50, 150, 33.33}, {150, 100, 150}, {10, 0, 0}} {
+		if got := Achievement(tt[0], tt[1]); got != tt[2] {
+			t.Errorf("Achievement(%v, %v) = %v, want %v", tt[0], tt[1], got, tt[2])
+		}
+	}
+}
+
+func TestAgentTargets(t *testing.T) {
+	db, rec := dryRun(t)
+	if _, err := AgentTargets(db, models.TargetVisits, []string{"u1"}, "04/01/2026", "2026-04-30"); err == nil {
+		t.Error("no error on a bad start date")
+	}
+
+	target, err := AgentTargets(db, models.TargetVisits, []string{"u1"}, "2026-04-01T00:00:00Z", "2026-04-30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := target("u9", ""); got != 0 {
+		t.Errorf("target of an agent not loaded = %v, want 0", got)
+	}
+	if len(rec.statements) != 2 {
+		t.Fatalf("statements = %q, want the targets and the users", rec.statements)
+	}
+	for _, want := range []string{"metric = 'visits'", "start_date <= '2026-04-30'", "end_date >= '2026-04-01'"} {
+		if !strings.Contains(rec.statements[0], want) {
+			t.Errorf("targets query misses %s:\n%s", want, rec.statements[0])
+		}
+	}
+	if !strings.Contains(rec.statements[1], "uuid IN ('u1')") {
+		t.Errorf("users query is not for u1:\n%s", rec.statements[1])
+	}
+}
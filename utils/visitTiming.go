@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+// ApplyVisitTiming computes the time on site of a visit from its check-in
+// and check-out events. The duration stays nil while either is missing.
+func ApplyVisitTiming(form *models.PosForm) error {
+	form.DurationSeconds = nil
+
+	if form.CheckOutAt == nil {
+		return nil
+	}
+	if form.CheckInAt == nil {
+		return errors.New("check_out_at requires check_in_at")
+	}
+	if form.CheckOutAt.Before(*form.CheckInAt) {
+		return errors.New("check_out_at is before check_in_at")
+	}
+
+	seconds := int64(form.CheckOutAt.Sub(*form.CheckInAt).Seconds())
+	form.DurationSeconds = &seconds
+	return nil
+}
+
+// VisitStart is when a visit began: the check-in, else the creation time,
+// else now for a form that is not saved yet.
+func VisitStart(form *models.PosForm) time.Time {
+	switch {
+	case form.CheckInAt != nil:
+		return *form.CheckInAt
+	case !form.CreatedAt.IsZero():
+		return form.CreatedAt
+	default:
+		return time.Now()
+	}
+}
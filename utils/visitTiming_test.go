@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danny19977/mspos-api-v3/models"
+)
+
+func TestApplyVisitTiming(t *testing.T) {
+	in := time.Date(2025, 3, 4, 9, 15, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ts := in.Add(d)
+		return &ts
+	}
+	stale := int64(999)
+
+	// Checked in only: no time on site yet, a stale duration is cleared
+	form := models.PosForm{CheckInAt: at(0), DurationSeconds: &stale}
+	if err := ApplyVisitTiming(&form); err != nil || form.DurationSeconds != nil {
+		t.Errorf("checked in only: duration %v, err %v, want nil, nil", form.DurationSeconds, err)
+	}
+
+	// Checked in and out: the seconds between, whole
+	form = models.PosForm{CheckInAt: at(0), CheckOutAt: at(12*time.Minute + 30*time.Second + 400*time.Millisecond)}
+	if err := ApplyVisitTiming(&form); err != nil {
+		t.Fatalf("checked in and out: %v", err)
+	}
+	if form.DurationSeconds == nil || *form.DurationSeconds != 750 {
+		t.Errorf("checked in and out: duration %v, want 750", form.DurationSeconds)
+	}
+
+	// Checked out at the check-in: zero, not nil
+	form = models.PosForm{CheckInAt: at(0), CheckOutAt: at(0)}
+	if err := ApplyVisitTiming(&form); err != nil || form.DurationSeconds == nil || *form.DurationSeconds != 0 {
+		t.Errorf("instant visit: duration %v, err %v, want 0, nil", form.DurationSeconds, err)
+	}
+
+	// Inconsistent events are refused and leave no duration
+	for name, form := range map[string]models.PosForm{
+		"check-out without check-in": {CheckOutAt: at(time.Minute), DurationSeconds: &stale},
+		"check-out before check-in":  {CheckInAt: at(0), CheckOutAt: at(-time.Second), DurationSeconds: &stale},
+	} {
+		if err := ApplyVisitTiming(&form); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		if form.DurationSeconds != nil {
+			t.Errorf("%s: duration %d, want nil", name, *form.DurationSeconds)
+		}
+	}
+}
+
+func TestVisitStart(t *testing.T) {
+	checkIn := time.Date(2025, 3, 4, 9, 15, 0, 0, time.UTC)
+	created := checkIn.Add(2 * time.Hour)
+
+	if got := VisitStart(&models.PosForm{CheckInAt: &checkIn, CreatedAt: created}); !got.Equal(checkIn) {
+		t.Errorf("checked-in visit starts at %v, want the check-in %v", got, checkIn)
+	}
+	if got := VisitStart(&models.PosForm{CreatedAt: created}); !got.Equal(created) {
+		t.Errorf("visit without events starts at %v, want its creation %v", got, created)
+	}
+
+	before := time.Now()
+	got := VisitStart(&models.PosForm{})
+	if got.Before(before) || got.After(time.Now()) {
+		t.Errorf("unsaved visit starts at %v, want now", got)
+	}
+}